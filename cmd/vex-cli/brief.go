@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// briefStatus is the one-line summary printed by status --brief, e.g.
+// "LOCKED choke cpu=15% lines=42/200 score=30".  It is never coloured, and
+// fields that are at their defaults are left out, apart from the lock,
+// the profile and the score.
+func briefStatus(s *state.SystemState) string {
+	fields := []string{"unlocked"}
+	if s.Compliance.Locked {
+		fields[0] = "LOCKED"
+	}
+	if s.Pause != nil {
+		fields = append(fields, "paused")
+	}
+	fields = append(fields, s.Network.Profile)
+	if c := s.Compute.CPULimitPct; c > 0 && c < 100 {
+		fields = append(fields, fmt.Sprintf("cpu=%d%%", c))
+	}
+	if s.Writing.Active {
+		fields = append(fields, fmt.Sprintf("lines=%d/%d", s.Writing.Completed, s.Writing.Required))
+	}
+	fields = append(fields, fmt.Sprintf("score=%d", s.Compliance.FailureScore))
+	return strings.Join(fields, " ")
+}
+
+// waybarStatus is the brief summary as a waybar custom-module object:
+// the summary as text, a longer tooltip, a class to style on ("locked",
+// "unlocked" or "paused") and the writing task's progress as percentage.
+func waybarStatus(s *state.SystemState) string {
+	class := "unlocked"
+	if s.Compliance.Locked {
+		class = "locked"
+	}
+	if s.Pause != nil {
+		class = "paused"
+	}
+	tooltip := []string{
+		fmt.Sprintf("Locked: %v", s.Compliance.Locked),
+		fmt.Sprintf("Failure score: %d", s.Compliance.FailureScore),
+		fmt.Sprintf("Task: %s", s.Compliance.TaskStatus),
+		fmt.Sprintf("Profile: %s", s.Network.Profile),
+		fmt.Sprintf("CPU limit: %d%%", s.Compute.CPULimitPct),
+		fmt.Sprintf("Blocked domains: %d", len(s.Guardian.BlockedDomains)),
+	}
+	out := struct {
+		Text       string `json:"text"`
+		Tooltip    string `json:"tooltip"`
+		Class      string `json:"class"`
+		Percentage *int   `json:"percentage,omitempty"`
+	}{Text: briefStatus(s), Class: class}
+	if s.Writing.Active && s.Writing.Required > 0 {
+		pct := s.Writing.Completed * 100 / s.Writing.Required
+		out.Percentage = &pct
+		tooltip = append(tooltip, fmt.Sprintf("Lines: %d/%d %q", s.Writing.Completed, s.Writing.Required, s.Writing.Phrase))
+	}
+	out.Tooltip = strings.Join(tooltip, "\n")
+	data, _ := json.Marshal(out)
+	return string(data)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+func TestBriefStatus(t *testing.T) {
+	s := &state.SystemState{}
+	s.Network.Profile = "standard"
+	s.Compute.CPULimitPct = 100
+	if got := briefStatus(s); got != "unlocked standard score=0" {
+		t.Errorf("Unexpected default summary %q", got)
+	}
+
+	s.Compliance.Locked = true
+	s.Compliance.FailureScore = 30
+	s.Network.Profile = "choke"
+	s.Compute.CPULimitPct = 15
+	s.Writing = state.WritingTask{Active: true, Phrase: "I obey", Required: 200, Completed: 42}
+	if got := briefStatus(s); got != "LOCKED choke cpu=15% lines=42/200 score=30" {
+		t.Errorf("Unexpected summary %q", got)
+	}
+
+	var w struct {
+		Text       string
+		Class      string
+		Percentage *int
+	}
+	if err := json.Unmarshal([]byte(waybarStatus(s)), &w); err != nil {
+		t.Fatal(err)
+	}
+	if w.Text != briefStatus(s) || w.Class != "locked" || w.Percentage == nil || *w.Percentage != 21 {
+		t.Errorf("Unexpected waybar output %+v", w)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build vextest
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+)
+
+// clockCommand is the clock command, for the virtual clock of a vexd
+// --simulate.  Production builds have neither, so it is left out of
+// them (noclock.go).
+func clockCommand() *command {
+	return &command{
+		name:  "clock",
+		short: "Show the virtual clock of a vexd --simulate",
+		long:  "Reach the simulation with --socket, at the path vexd --simulate logs.  A daemon on the real clock refuses every clock command.",
+		run:   func([]string) { cmdClock(nil) },
+		subs: []*command{
+			{
+				name:    "advance",
+				args:    "<duration>",
+				short:   "Move the clock forward by duration (e.g. 72h), firing what falls due on the way",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdClock(map[string]string{"advance": args[0]}) },
+			},
+			{
+				name:    "rate",
+				args:    "<factor>",
+				short:   "Run the clock factor times as fast as real time; 0 stops it",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdClock(map[string]string{"rate": args[0]}) },
+			},
+		},
+	}
+}
+
+// cmdClock shows or changes the virtual clock of vexd --simulate.  After
+// an advance it waits until the clock gets there, so that the next
+// command sees everything that fell due on the way.
+func cmdClock(args map[string]string) {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdClock, Args: args})
+	fmt.Println(resp.Message)
+	if args["advance"] == "" {
+		if resp.Clock != nil {
+			fmt.Println(dim(fmt.Sprintf("%d deadlines and periodic jobs armed", resp.Clock.Timers)))
+		}
+		return
+	}
+	for resp.Clock != nil && resp.Clock.Advancing != "" {
+		time.Sleep(100 * time.Millisecond)
+		resp = sendOrDie(&ipc.Request{Command: ipc.CmdClock})
+	}
+	if resp.Clock != nil {
+		fmt.Printf("Clock at %s.\n", fmtLocal(resp.Clock.Now))
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+)
+
+// command is a vex-cli command or subcommand.  Flags are parsed with the
+// standard flag package and may appear before, between or after the
+// positional arguments.
+type command struct {
+	name    string
+	aliases []string
+	args    string // positional argument synopsis, e.g. "<profile>"
+	short   string // one line for the parent's command list
+	long    string // extra text for "vex-cli help <command>"
+
+	// minArgs and maxArgs bound the positional arguments; a negative
+	// maxArgs means any number.
+	minArgs, maxArgs int
+
+	flags func(fs *flag.FlagSet)
+	run   func(args []string)
+
+	// global defines flags accepted by c and every command below it,
+	// before or after the subcommand name.
+	global func(fs *flag.FlagSet)
+	gflags *flag.FlagSet
+
+	// runSigned replaces run for restriction-lowering commands.  The
+	// single argument is a signed authorization payload, verified before
+	// runSigned is called.
+	runSigned func(cmd *security.SignedCommand)
+
+	subs   []*command
+	parent *command
+}
+
+// path is the full invocation, e.g. "vex-cli block add".
+func (c *command) path() string {
+	if c.parent == nil {
+		return c.name
+	}
+	return c.parent.path() + " " + c.name
+}
+
+// link sets the parent of every subcommand below c.
+func (c *command) link() *command {
+	for _, s := range c.subs {
+		s.parent = c
+		s.link()
+	}
+	return c
+}
+
+// lookup returns the subcommand called name, or nil.
+func (c *command) lookup(name string) *command {
+	for _, s := range c.subs {
+		if s.name == name || slices.Contains(s.aliases, name) {
+			return s
+		}
+	}
+	return nil
+}
+
+// find resolves a command path such as ["block", "add"] below c.
+func (c *command) find(names []string) (*command, error) {
+	for _, name := range names {
+		sub := c.lookup(name)
+		if sub == nil {
+			return nil, fmt.Errorf("unknown command %q for %s", name, c.path())
+		}
+		c = sub
+	}
+	return c, nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// globalFlags returns the flags c hands down.  They are defined once, so
+// a value set before a subcommand name survives into the subcommand.
+func (c *command) globalFlags() *flag.FlagSet {
+	if c.gflags == nil {
+		c.gflags = newFlagSet(c.path())
+		if c.global != nil {
+			c.global(c.gflags)
+		}
+	}
+	return c.gflags
+}
+
+// inherited adds the global flags of c and its ancestors to fs.
+func (c *command) inherited(fs *flag.FlagSet) *flag.FlagSet {
+	for p := c; p != nil; p = p.parent {
+		p.globalFlags().VisitAll(func(f *flag.Flag) {
+			fs.Var(f.Value, f.Name, f.Usage)
+		})
+	}
+	return fs
+}
+
+func (c *command) flagSet() *flag.FlagSet {
+	fs := newFlagSet(c.path())
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	return c.inherited(fs)
+}
+
+// execute runs c, or the subcommand named by the first argument that is
+// not a global flag.
+func (c *command) execute(args []string) {
+	if len(c.subs) > 0 {
+		fs := c.inherited(newFlagSet(c.path()))
+		if err := fs.Parse(args); err == nil && fs.NArg() > 0 {
+			if sub := c.lookup(fs.Arg(0)); sub != nil {
+				sub.execute(fs.Args()[1:])
+				return
+			}
+		}
+	}
+
+	rest, err := parseArgs(c.flagSet(), args)
+	if errors.Is(err, flag.ErrHelp) {
+		c.help(os.Stdout)
+		return
+	}
+	if err != nil {
+		c.usageError(err.Error())
+	}
+
+	maxArgs := c.maxArgs
+	if c.runSigned != nil {
+		maxArgs = 1
+	}
+	switch {
+	case c.run == nil && c.runSigned == nil:
+		if len(rest) > 0 {
+			c.usageError(fmt.Sprintf("unknown command %q", rest[0]))
+		}
+		c.usageError("missing command")
+	case c.runSigned != nil && len(rest) == 0:
+		c.usageError("a signed authorization payload (JSON) is required")
+	case len(rest) < c.minArgs:
+		c.usageError("missing arguments")
+	case maxArgs >= 0 && len(rest) > maxArgs:
+		if maxArgs == 0 && len(c.subs) > 0 {
+			c.usageError(fmt.Sprintf("unknown subcommand %q", rest[0]))
+		}
+		c.usageError(fmt.Sprintf("unexpected argument %q", rest[maxArgs]))
+	}
+
+	if c.runSigned != nil {
+		c.runSigned(authorize(rest[0]))
+		return
+	}
+	c.run(rest)
+}
+
+// parseArgs parses flags anywhere in args and returns the positional
+// arguments.  Everything after "--" is positional.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	rest := []string{}
+	for len(args) > 0 {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		// Parse stops at the first positional argument, or just after
+		// a "--" which it consumes.
+		if used := len(args) - fs.NArg(); used > 0 && args[used-1] == "--" {
+			return append(rest, fs.Args()...), nil
+		}
+		args = fs.Args()
+		if len(args) > 0 {
+			rest = append(rest, args[0])
+			args = args[1:]
+		}
+	}
+	return rest, nil
+}
+
+// authorize parses and verifies a signed payload, exiting if it is not
+// valid.
+func authorize(payload string) *security.SignedCommand {
+	cmd, err := security.ParseSignedCommand([]byte(payload))
+	if err != nil {
+		die(exitUnauthorized, "Invalid signed command: %v", err)
+	}
+	if err := security.VerifyCommand(cmd); err != nil {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: %v", err)
+	}
+	return cmd
+}
+
+// usageError reports a mistake in the invocation of c and exits.
+func (c *command) usageError(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", c.path(), msg)
+	fmt.Fprintf(os.Stderr, "Usage: %s\n", c.usage())
+	fmt.Fprintf(os.Stderr, "Run '%s --help' for more information.\n", c.path())
+	os.Exit(exitInvalid)
+}
+
+// usage is the one-line synopsis of c.
+func (c *command) usage() string {
+	parts := []string{c.path()}
+	switch {
+	case c.runSigned != nil:
+		parts = append(parts, "<signed-payload>")
+	case c.run == nil && len(c.subs) > 0:
+		parts = append(parts, "<command>")
+	case c.args == "" && len(c.subs) > 0:
+		parts = append(parts, "[<command>]")
+	}
+	if c.args != "" {
+		parts = append(parts, c.args)
+	}
+	if c.flags != nil {
+		parts = append(parts, "[flags]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// help writes the full help for c.
+func (c *command) help(w io.Writer) {
+	if c.short != "" {
+		fmt.Fprintf(w, "%s\n\n", c.short)
+	}
+	fmt.Fprintf(w, "Usage: %s\n", c.usage())
+	if c.run != nil && c.args != "" && len(c.subs) > 0 {
+		fmt.Fprintf(w, "       %s <command>\n", c.path())
+	}
+	if c.long != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.long))
+	}
+	if len(c.subs) > 0 {
+		fmt.Fprintln(w, "\nCommands:")
+		for _, s := range c.subs {
+			name := s.name
+			if len(s.aliases) > 0 {
+				name += " (" + strings.Join(s.aliases, ", ") + ")"
+			}
+			fmt.Fprintf(w, "  %-18s %s\n", name, s.short)
+		}
+	}
+	if c.flags != nil {
+		fmt.Fprintln(w, "\nFlags:")
+		fs := newFlagSet(c.path())
+		c.flags(fs)
+		printFlags(w, fs)
+	}
+	if fs := c.inherited(newFlagSet(c.path())); hasFlags(fs) {
+		fmt.Fprintln(w, "\nGlobal Flags:")
+		printFlags(w, fs)
+	}
+	if c.parent != nil && len(c.subs) > 0 {
+		fmt.Fprintf(w, "\nRun '%s <command> --help' for help on a command.\n", c.path())
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	return n > 0
+}
+
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		name, usage := flag.UnquoteUsage(f)
+		dash := "--"
+		if len(f.Name) == 1 {
+			dash = "-"
+		}
+		left := dash + f.Name
+		if name != "" {
+			left += " " + name
+		}
+		fmt.Fprintf(w, "  %-18s %s\n", left, usage)
+	})
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args, rest []string
+		days       string
+		apps       []string
+	}{
+		{[]string{"23:00", "07:00", "steam"}, []string{"23:00", "07:00", "steam"}, "", nil},
+		{[]string{"--days", "sat,sun", "23:00", "07:00"}, []string{"23:00", "07:00"}, "sat,sun", nil},
+		{[]string{"23:00", "--app", "a", "07:00", "--app=b", "--days", "fri"}, []string{"23:00", "07:00"}, "fri", []string{"a", "b"}},
+		{[]string{"3", "--", "-never", "--days"}, []string{"3", "-never", "--days"}, "", nil},
+	} {
+		var days string
+		var apps stringList
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.StringVar(&days, "days", "", "")
+		fs.Var(&apps, "app", "")
+		rest, err := parseArgs(fs, tc.args)
+		if err != nil {
+			t.Errorf("%v: %v", tc.args, err)
+			continue
+		}
+		if !slices.Equal(rest, tc.rest) || days != tc.days || !slices.Equal(apps, tc.apps) {
+			t.Errorf("%v: got %v days=%q apps=%v", tc.args, rest, days, apps)
+		}
+	}
+}
+
+func TestCommandTree(t *testing.T) {
+	for _, path := range [][]string{{"block", "rm"}, {"block", "del"}, {"request", "cancel"}, {"audit", "export"}, {"lines", "cancel"}} {
+		if _, err := root.find(path); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := root.find([]string{"block", "nope"}); err == nil {
+		t.Error("Expected an error for an unknown subcommand")
+	}
+
+	// Every restriction-lowering command must go through the signed
+	// payload check, and nothing else may.
+	for _, c := range root.subs {
+		if signed := c.runSigned != nil; signed != security.IsRestrictionLoweringCommand(c.name) {
+			t.Errorf("%s: signed=%v does not match the security policy", c.name, signed)
+		}
+		if c.run == nil && c.runSigned == nil && len(c.subs) == 0 {
+			t.Errorf("%s: nothing to run", c.name)
+		}
+	}
+}
+
+func TestExitFor(t *testing.T) {
+	for _, tc := range []struct {
+		resp ipc.Response
+		want int
+	}{
+		{ipc.Response{OK: true}, exitOK},
+		{ipc.Response{Error: "missing 'domain' argument"}, exitInvalid},
+		{ipc.Response{Error: "curfew in effect", Code: ipc.CodeLocked}, exitLocked},
+		{ipc.Response{Error: "failed to add domain", Code: ipc.CodeFailed}, exitFailure},
+		{ipc.Response{Error: "refused: this vex-cli is not a known-good build", Code: ipc.CodeUnauthorized}, exitUnauthorized},
+		{ipc.Response{Error: "session revoked", Code: ipc.CodeSession}, exitUnauthorized},
+	} {
+		if got := exitFor(&tc.resp); got != tc.want {
+			t.Errorf("%+v: got %d, want %d", tc.resp, got, tc.want)
+		}
+	}
+}
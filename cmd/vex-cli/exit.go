@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+)
+
+// Exit statuses.  Scripts and systemd units branch on these, so they are
+// part of the CLI's interface (VEX-CLI-OPERATION.md §7).
+const (
+	exitOK           = 0
+	exitFailure      = 1 // anything not covered below
+	exitUnreachable  = 2 // vexd could not be reached
+	exitUnauthorized = 3 // no access to vex-cli, or a signed payload was rejected
+	exitInvalid      = 4 // bad usage, or the daemon refused the request as invalid
+	exitLocked       = 5 // refused because of a lock; for status, the system is locked
+)
+
+// die logs the message and exits with code.
+func die(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// exitFor maps a daemon response to an exit status.
+func exitFor(resp *ipc.Response) int {
+	switch {
+	case resp.OK:
+		return exitOK
+	case resp.Code == ipc.CodeLocked:
+		return exitLocked
+	case resp.Code == ipc.CodeFailed:
+		return exitFailure
+	case resp.Code == ipc.CodeUnauthorized, resp.Code == ipc.CodeSession:
+		return exitUnauthorized
+	}
+	return exitInvalid
+}
+
+// quiet discards everything the command would print on stdout; errors
+// still go to stderr.
+func quiet(string) error {
+	if null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = null
+	}
+	return nil
+}
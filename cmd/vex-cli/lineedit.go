@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// Bracketed paste (xterm, and every terminal emulator since): once enabled,
+// the terminal wraps pasted text in pasteStart and pasteEnd.
+const (
+	pasteOn    = "\033[?2004h"
+	pasteOff   = "\033[?2004l"
+	pasteStart = "\033[200~"
+	pasteEnd   = "\033[201~"
+)
+
+// burstRunes is how many printable characters arriving in one read count
+// as a paste.  Keys typed by hand arrive one per read, or two or three
+// while the previous line is being submitted; a terminal without
+// bracketed paste hands over pasted text in one go.
+const burstRunes = 4
+
+// isTerminal reports whether fd is a terminal.
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	return err == nil
+}
+
+// lineReader reads lines from a terminal in raw mode, doing its own echo
+// and editing (Backspace, Ctrl+U), and notices lines that were pasted
+// rather than typed.
+type lineReader struct {
+	in   io.Reader
+	echo io.Writer
+
+	chunk   []byte // what is left of the last read
+	burst   bool   // the last read was a burst of characters
+	inPaste bool   // between pasteStart and pasteEnd
+}
+
+// readLine returns the next line.  pasted is set when any of it, the
+// Enter key included, was pasted.  Ctrl+D on an empty line and Ctrl+C
+// return io.EOF.
+func (r *lineReader) readLine() (line string, pasted bool, err error) {
+	var runes []rune
+	for {
+		if len(r.chunk) == 0 {
+			buf := make([]byte, 4096)
+			n, err := r.in.Read(buf)
+			if n == 0 {
+				if err == nil {
+					err = io.EOF
+				}
+				return "", false, err
+			}
+			r.chunk = buf[:n]
+			r.burst = printableRunes(r.chunk) >= burstRunes
+		}
+
+		if r.chunk[0] == 0x1b {
+			var seq []byte
+			seq, r.chunk = splitEscape(r.chunk)
+			switch string(seq) {
+			case pasteStart:
+				r.inPaste = true
+			case pasteEnd:
+				r.inPaste = false
+			}
+			continue
+		}
+
+		c, size := utf8.DecodeRune(r.chunk)
+		r.chunk = r.chunk[size:]
+		if r.burst || r.inPaste {
+			pasted = true
+		}
+		switch {
+		case c == '\r' || c == '\n':
+			fmt.Fprint(r.echo, "\r\n")
+			return string(runes), pasted, nil
+		case c == 0x7f || c == 0x08: // Backspace
+			if len(runes) > 0 {
+				runes = runes[:len(runes)-1]
+				fmt.Fprint(r.echo, "\b \b")
+			}
+		case c == 0x15: // Ctrl+U
+			for range runes {
+				fmt.Fprint(r.echo, "\b \b")
+			}
+			runes = runes[:0]
+		case c == 0x03, c == 0x04 && len(runes) == 0: // Ctrl+C, Ctrl+D
+			fmt.Fprint(r.echo, "\r\n")
+			return "", false, io.EOF
+		case unicode.IsPrint(c):
+			runes = append(runes, c)
+			fmt.Fprint(r.echo, string(c))
+		}
+	}
+}
+
+// splitEscape splits the escape sequence at the start of b from the rest.
+// A sequence cut short by the end of b is taken as it is.
+func splitEscape(b []byte) (seq, rest []byte) {
+	n := 1
+	switch {
+	case len(b) > 1 && b[1] == '[':
+		// CSI: parameter and intermediate bytes, then one final byte.
+		n = 2
+		for n < len(b) && b[n] >= 0x20 && b[n] <= 0x3f {
+			n++
+		}
+		if n < len(b) {
+			n++
+		}
+	case len(b) > 2 && b[1] == 'O':
+		n = 3
+	case len(b) > 1:
+		n = 2
+	}
+	return b[:n], b[n:]
+}
+
+// printableRunes counts the printable characters in b outside escape
+// sequences.
+func printableRunes(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			_, b = splitEscape(b)
+			continue
+		}
+		c, size := utf8.DecodeRune(b)
+		b = b[size:]
+		if unicode.IsPrint(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// rawLineReader puts stdin in raw mode with bracketed paste on, and
+// returns a lineReader for it and a function undoing both.
+func rawLineReader() (*lineReader, func()) {
+	restore := rawTerminal(int(os.Stdin.Fd()))
+	fmt.Print(pasteOn)
+	return &lineReader{in: os.Stdin, echo: os.Stdout}, func() {
+		fmt.Print(pasteOff)
+		restore()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read, like a terminal in raw mode.
+type chunkReader []string
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r)[0])
+	*r = (*r)[1:]
+	return n, nil
+}
+
+func TestLineReader(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		chunks []string
+		line   string
+		pasted bool
+	}{
+		{"typed", []string{"a", "b", "c", "\r"}, "abc", false},
+		{"edited", []string{"a", "x", "\x7f", "b", "\x15", "c", "é", "\r"}, "cé", false},
+		{"type-ahead", []string{"ab", "c\r"}, "abc", false},
+		{"arrow key", []string{"a", "\x1b[A", "\x1bOD", "\r"}, "a", false},
+		{"burst", []string{"I will obey\r"}, "I will obey", true},
+		{"burst then typed", []string{"I will", " obey", "\r"}, "I will obey", true},
+		{"bracketed", []string{pasteStart + "ab" + pasteEnd, "\r"}, "ab", true},
+	} {
+		in := chunkReader(tc.chunks)
+		r := &lineReader{in: &in, echo: io.Discard}
+		line, pasted, err := r.readLine()
+		if err != nil || line != tc.line || pasted != tc.pasted {
+			t.Errorf("%s: got %q pasted=%v err=%v, expected %q pasted=%v",
+				tc.name, line, pasted, err, tc.line, tc.pasted)
+		}
+	}
+
+	in := chunkReader{"abcd\rab", "\r", "\x04"}
+	r := &lineReader{in: &in, echo: io.Discard}
+	if _, pasted, _ := r.readLine(); !pasted {
+		t.Error("Expected the first line of a burst to be pasted")
+	}
+	if line, pasted, _ := r.readLine(); line != "ab" || !pasted {
+		t.Errorf("Expected the rest of the burst to taint the next line, got %q pasted=%v", line, pasted)
+	}
+	if _, _, err := r.readLine(); err != io.EOF {
+		t.Errorf("Expected io.EOF after Ctrl+D, got %v", err)
+	}
+}
@@ -10,259 +10,1072 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/user"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/buildinfo"
+	"github.com/adumbdinosaur/vex-cli/internal/config"
+	"github.com/adumbdinosaur/vex-cli/internal/history"
 	"github.com/adumbdinosaur/vex-cli/internal/ipc"
 	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
 	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/scheduler"
 	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
 	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+	"golang.org/x/sys/unix"
 )
 
 func main() {
+	vexlog.Console = os.Stderr
 	if err := vexlog.Init(); err != nil {
 		log.Printf("Logging initialization warning: %v", err)
 	}
 	defer vexlog.Close()
 
 	// Allow non-root users in the 'vex' group or root user
-	if !canAccessVex() {
-		log.Fatal("Error: vex-cli requires root privileges or membership in the 'vex' group.")
+	if testRoot == "" && !canAccessVex() {
+		die(exitUnauthorized, "Error: vex-cli requires root privileges or membership in the 'vex' group.")
 	}
 
 	if err := security.Init(); err != nil {
 		log.Printf("Security initialization warning: %v", err)
 	}
 
+	// The daemon configuration may move the socket.
+	if cfg, err := config.Load(); err != nil {
+		log.Printf("Config warning: %v", err)
+	} else if cfg != nil {
+		cfg.Apply()
+	}
+
 	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+		root.help(os.Stdout)
+		os.Exit(exitInvalid)
 	}
 
-	command := os.Args[1]
-	vexlog.LogCommand(command, strings.Join(os.Args[2:], " "), getComplianceState())
+	vexlog.LogCommand(os.Args[1], strings.Join(os.Args[2:], " "), getComplianceState())
+	root.execute(os.Args[1:])
+}
 
-	// Authorization gate for restriction-lowering commands
-	if security.IsRestrictionLoweringCommand(command) {
-		if len(os.Args) < 3 {
-			log.Fatal("Restricted commands require a signed authorization payload (JSON)")
-		}
-		signedData := []byte(os.Args[2])
-		cmd, err := security.ParseSignedCommand(signedData)
-		if err != nil {
-			log.Fatalf("Invalid signed command: %v", err)
-		}
-		if err := security.VerifyCommand(cmd); err != nil {
-			log.Fatalf("AUTHORIZATION DENIED: %v", err)
-		}
-	}
-
-	switch command {
-	case "status":
-		cmdStatus()
-	case "throttle":
-		if len(os.Args) < 3 {
-			log.Fatal("Usage: vex-cli throttle <profile>")
-		}
-		cmdThrottle(os.Args[2])
-	case "cpu":
-		if len(os.Args) < 3 {
-			log.Fatal("Usage: vex-cli cpu <percent>")
-		}
-		cmdCPU(os.Args[2])
-	case "latency":
-		if len(os.Args) < 3 {
-			log.Fatal("Usage: vex-cli latency <ms>")
-		}
-		cmdLatency(os.Args[2])
-	case "oom":
-		if len(os.Args) < 3 {
-			log.Fatal("Usage: vex-cli oom <score>")
-		}
-		cmdOOM(os.Args[2])
-	case "penance":
-		cmdPenance()
-	case "block":
-		if len(os.Args) < 3 {
-			cmdBlockList()
-			return
-		}
-		switch os.Args[2] {
-		case "add":
-			if len(os.Args) < 4 {
-				log.Fatal("Usage: vex-cli block add <domain>")
-			}
-			cmdBlockAdd(os.Args[3])
-		case "rm", "remove", "del":
-			if len(os.Args) < 4 {
-				log.Fatal("Usage: vex-cli block rm <domain>")
-			}
-			cmdBlockRemove(os.Args[3])
-		case "list", "ls":
-			cmdBlockList()
-		default:
-			// Treat as "block add <domain>" shorthand
-			cmdBlockAdd(os.Args[2])
-		}
-	case "unlock":
-		cmdUnlock()
-	case "reset-score":
-		cmdResetScore()
-	case "state":
-		cmdState()
-	case "check":
-		cmdCheck()
-	case "lines":
-		if len(os.Args) < 3 {
-			cmdLinesStatus()
-			return
-		}
-		switch os.Args[2] {
-		case "set":
-			// vex-cli lines set <count> <phrase...>
-			if len(os.Args) < 5 {
-				log.Fatal("Usage: vex-cli lines set <count> <phrase>")
-			}
-			cmdLinesSet(os.Args[3], strings.Join(os.Args[4:], " "))
-		case "clear", "cancel":
-			cmdLinesClear()
-		case "status":
-			cmdLinesStatus()
-		case "submit":
-			cmdLinesSubmitInteractive()
-		default:
-			fmt.Printf("Unknown lines subcommand: %s\n", os.Args[2])
-			os.Exit(1)
-		}
-	case "app":
-		if len(os.Args) < 3 {
-			cmdAppList()
-			return
-		}
-		switch os.Args[2] {
-		case "add":
-			if len(os.Args) < 4 {
-				log.Fatal("Usage: vex-cli app add <name>")
-			}
-			cmdAppAdd(os.Args[3])
-		case "rm", "remove", "del":
-			if len(os.Args) < 4 {
-				log.Fatal("Usage: vex-cli app rm <name>")
-			}
-			cmdAppRemove(os.Args[3])
-		case "list", "ls":
-			cmdAppList()
-		default:
-			fmt.Printf("Unknown app subcommand: %s\n", os.Args[2])
-			os.Exit(1)
-		}
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
-		printUsage()
-		os.Exit(1)
+// testRoot is the directory a test-mode vex-cli finds the daemon's files
+// under, or "" outside test mode.  Only a build with the vextest tag sets
+// it (testmode.go).
+var testRoot string
+
+// Flag values.  One command runs per invocation, so commands that take
+// the same flag share its variable.
+var (
+	flagJSON    bool
+	flagNoColor bool
+	flagSocket  string
+
+	flagFor    string
+	flagDays   string
+	flagReason string
+	flagEvents bool
+	flagWatch  bool
+	flagBrief  bool
+	flagWaybar bool
+
+	flagPeriod string
+	flagHTML   bool
+	flagSend   bool
+
+	flagFormat string
+	flagSince  string
+	flagOutput string
+
+	flagDomains stringList
+	flagApps    stringList
+
+	flagMinSeconds int
+	flagMaxSeconds int
+	flagPriority   int
+	flagAll        bool
+	flagLive       bool
+	flagMinKPM     int
+	flagMaxKPM     int
+	flagClauses    stringList
+	flagStepEvery  int
+	flagPaceStep   int
+	flagAbandon    string
+	flagOnAbandon  string
+	flagBump       int
+	flagSigned     string
+	flagExe        bool
+)
+
+func forFlag(fs *flag.FlagSet) {
+	fs.StringVar(&flagFor, "for", "", "revert automatically after `duration` (e.g. 30m, 2h)")
+}
+
+func daysFlag(fs *flag.FlagSet) {
+	fs.StringVar(&flagDays, "days", "", "limit to these `days` (e.g. mon,wed,fri or weekdays)")
+}
+
+// root is the command tree.  It is built in init because the help
+// command refers back to it.
+var root *command
+
+func init() {
+	root = (&command{
+		name:  "vex-cli",
+		short: "VEX-CLI (Protocol 106-V) - Control Plane",
+		long: `
+All commands talk to the running vexd daemon and persist for next boot.
+Run 'vex-cli help <command>' or 'vex-cli <command> --help' for help on a
+command.
+
+Exit status: 0 ok, 1 other failure, 2 daemon unreachable, 3 unauthorized,
+4 invalid usage or request, 5 locked (for status: the system is locked).`,
+		global: func(fs *flag.FlagSet) {
+			fs.BoolVar(&flagJSON, "json", false, "print the daemon's response as JSON instead of text")
+			fs.BoolFunc("quiet", "print nothing on stdout; only the exit status tells the result", quiet)
+			fs.BoolFunc("q", "shorthand for --quiet", quiet)
+			fs.BoolVar(&flagNoColor, "no-color", false, "plain output without colours (also set by NO_COLOR)")
+			fs.StringVar(&flagSocket, "socket", "", "`path` of the vexd socket (default $VEX_SOCKET, then "+state.SocketPath+")")
+		},
+		subs: []*command{
+			{
+				name:  "status",
+				short: "Display current system state (human-readable)",
+				long: `Exits 5 while the system is locked, so 'vex-cli -q status' can gate a script or unit.
+
+--brief prints one line for a status bar, e.g. "LOCKED choke cpu=15% lines=42/200 score=30";
+--waybar prints it as a waybar custom-module object and always exits 0, since waybar hides
+a module whose command fails. With --watch, either prints a new line on every change.`,
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&flagWatch, "watch", false, "redraw the report in place as the state changes")
+					fs.BoolVar(&flagBrief, "brief", false, "print a one-line summary for status bars")
+					fs.BoolVar(&flagWaybar, "waybar", false, "print the one-line summary as waybar JSON")
+				},
+				run: func([]string) {
+					if flagWatch {
+						watchStatus()
+						return
+					}
+					cmdStatus()
+				},
+			},
+			{
+				name:  "state",
+				short: "Dump live system state as JSON (machine-readable)",
+				run:   func([]string) { cmdState() },
+			},
+			{
+				name:  "watch",
+				short: "Stream state as JSON lines whenever it changes",
+				flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&flagEvents, "events", false, "interleave daemon events as {\"event\": ...} lines")
+				},
+				run: func([]string) { cmdWatch(flagEvents) },
+			},
+			{
+				name:    "throttle",
+				args:    "<profile>",
+				short:   "Set network profile (standard|choke|dial-up|black-hole|blackout)",
+				minArgs: 1, maxArgs: 1,
+				flags: forFlag,
+				run:   func(args []string) { cmdThrottle(args[0], flagFor) },
+			},
+			{
+				name:    "cpu",
+				args:    "<percent>",
+				short:   "Set CPU limit percentage (0-100)",
+				minArgs: 1, maxArgs: 1,
+				flags: forFlag,
+				run:   func(args []string) { cmdCPU(args[0], flagFor) },
+			},
+			{
+				name:    "priority",
+				args:    "<normal|low|lowest|idle>",
+				short:   "Lower the CPU and I/O priority of the subject's processes",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdPriority(args[0]) },
+			},
+			{
+				name:    "latency",
+				args:    "<ms|min-max>",
+				short:   "Set input latency in ms, or a jitter range (e.g. 50-400)",
+				minArgs: 1, maxArgs: 1,
+				flags: forFlag,
+				run:   func(args []string) { cmdLatency(args[0], flagFor) },
+			},
+			{
+				name:    "inputlock",
+				args:    "<duration>",
+				short:   "Block all keyboard input for a duration (e.g. 10m)",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdInputLock(args[0]) },
+			},
+			{
+				name:    "freeze",
+				args:    "<duration>",
+				short:   "Freeze the subject's sessions after a 1m warning (1m-1h)",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdFreeze(args[0]) },
+			},
+			{
+				name:    "gpu",
+				args:    "<percent>",
+				short:   "Cap GPU power and clocks (1-100, 100 lifts the cap)",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdGPU(args[0]) },
+			},
+			{
+				name:    "oom",
+				args:    "<score>",
+				short:   "Set the OOM score of the subject's processes (-1000 to 1000)",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdOOM(args[0]) },
+			},
+			{
+				name:    "brightness",
+				args:    "<percent>",
+				short:   "Cap display brightness (1-100, 100 lifts the cap)",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdBrightness(args[0]) },
+			},
+			{
+				name:    "volume",
+				args:    "<percent|mute>",
+				short:   "Cap audio volume (0-100, 100 lifts the cap) or mute it",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdVolume(args[0]) },
+			},
+			{
+				name:    "usage",
+				args:    "[today|week]",
+				short:   "Show screen time",
+				maxArgs: 1,
+				run:     func(args []string) { cmdUsage(argOr(args, "today")) },
+			},
+			{
+				name:    "history",
+				args:    "[day|week|month]",
+				short:   "Show score, kills and screen-time trends",
+				maxArgs: 1,
+				run:     func(args []string) { cmdHistory(argOr(args, "day")) },
+			},
+			{
+				name:  "score",
+				short: "Show how the failure score got where it is",
+				subs: []*command{
+					{
+						name:    "history",
+						args:    "[day|week|month|all]",
+						short:   "Draw the score over time and list every change with its cause",
+						maxArgs: 1,
+						run:     func(args []string) { cmdScoreHistory(argOr(args, "week")) },
+					},
+				},
+			},
+			{
+				name:  "penance",
+				short: "Start interactive penance submission session",
+				run:   func([]string) { cmdPenance() },
+			},
+			{
+				name:    "typing-test",
+				args:    "[text...]",
+				short:   "Typing test read from the keyboard by the daemon",
+				long:    "Without text the daemon picks the passage.",
+				maxArgs: -1,
+				run:     func(args []string) { cmdTypingTest(strings.Join(args, " ")) },
+			},
+			{
+				name:    "block",
+				args:    "[<domain>]",
+				short:   "Manage SNI domain blocklist",
+				long:    "With no arguments, lists blocked domains.  'block <domain>' is shorthand for 'block add <domain>'.  IPv4 prefixes (157.240.0.0/16) and autonomous systems (AS32934) can be blocked too; the daemon looks up the prefixes an AS announces.",
+				maxArgs: 1,
+				flags:   forFlag,
+				run: func(args []string) {
+					if len(args) == 0 {
+						cmdBlockList()
+						return
+					}
+					cmdBlockAdd(args[0], flagFor)
+				},
+				subs: []*command{
+					{
+						name:    "add",
+						args:    "<domain|prefix|ASN>",
+						short:   "Add a domain, IPv4 prefix or AS to the firewall blocklist",
+						minArgs: 1, maxArgs: 1,
+						flags: forFlag,
+						run:   func(args []string) { cmdBlockAdd(args[0], flagFor) },
+					},
+					{
+						name:    "rm",
+						aliases: []string{"remove", "del"},
+						args:    "<domain|prefix|ASN>",
+						short:   "Remove a domain, prefix or AS from the blocklist",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdBlockRemove(args[0]) },
+					},
+					{
+						name:    "list",
+						aliases: []string{"ls"},
+						short:   "List currently blocked domains",
+						run:     func([]string) { cmdBlockList() },
+					},
+					{
+						name:    "pass",
+						args:    "<domain>",
+						short:   "Watch a countdown, then get a few minutes on a blocked domain",
+						long:    "Starts a countdown (10 minutes unless exceptions.json says otherwise) that runs only while this command watches it.  When it runs out the domain is unblocked for a short window (5 minutes by default) and then blocked again.  Ctrl+C abandons the countdown.",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdBlockPass(args[0]) },
+					},
+					{
+						name:    "monitor",
+						args:    "<domain> <accesses>",
+						short:   "Warn on a domain's first accesses each day, then block it",
+						long:    "The first <accesses> accesses to the domain each day only warn, and the keyholder is told; the next one blocks it until the day is over.  Accesses are counted by the stub resolver (guardian.stub_resolver in config.json), and lookups less than 5 minutes apart count as one.  Running it again can only lower the count; 'block rm' stops monitoring.",
+						minArgs: 2, maxArgs: 2,
+						run:     func(args []string) { cmdBlockMonitor(args[0], args[1]) },
+					},
+				},
+			},
+			{
+				name:  "lines",
+				short: "Manage writing-lines task",
+				long:  "With no arguments, shows the active task.",
+				run:   func([]string) { cmdLinesStatus() },
+				subs: []*command{
+					{
+						name:  "set",
+						args:  "<count> <phrase...>",
+						short: "Assign phrase to be written count times",
+						flags: func(fs *flag.FlagSet) {
+							fs.IntVar(&flagMinSeconds, "min-seconds", 0, "reject a line typed in fewer than `n` seconds")
+							fs.IntVar(&flagMaxSeconds, "max-seconds", 0, "reject a line that takes more than `n` seconds")
+							fs.IntVar(&flagPriority, "priority", 0, "queue position: a higher `n` goes first and takes over from a lower active task")
+							fs.BoolVar(&flagLive, "live", false, "each line must match what the daemon saw typed on the keyboard")
+							fs.IntVar(&flagMinKPM, "min-kpm", 0, "with --live, reject a line typed slower than `n` keys per minute")
+							fs.IntVar(&flagMaxKPM, "max-kpm", 0, "with --live, reject a line typed faster than `n` keys per minute (default 600)")
+							fs.Var(&flagClauses, "clause", "`text` appended to the phrase at the next difficulty step; repeatable, used in order")
+							fs.IntVar(&flagStepEvery, "step-every", 0, "accepted lines between difficulty steps (default 25 with --clause or --pace-step)")
+							fs.IntVar(&flagPaceStep, "pace-step", 0, "take `n` seconds off --max-seconds at each difficulty step")
+							fs.StringVar(&flagAbandon, "abandon-after", "", "penalise the task after this `duration` without an accepted line, and again each time it passes")
+							fs.StringVar(&flagOnAbandon, "on-abandon", "", "penalties for an abandoned task: `list` of fail, bump, throttle (default fail)")
+							fs.IntVar(&flagBump, "abandon-bump", 0, "lines bump adds to the task (default 10)")
+						},
+						minArgs: 2, maxArgs: -1,
+						run: func(args []string) { cmdLinesSet(args[0], strings.Join(args[1:], " ")) },
+					},
+					{
+						name:  "status",
+						short: "Show progress and the queued tasks",
+						run:   func([]string) { cmdLinesStatus() },
+					},
+					{
+						name:  "submit",
+						short: "Interactive submission (type lines)",
+						run:   func([]string) { cmdLinesSubmitInteractive() },
+					},
+					{
+						name:    "clear",
+						aliases: []string{"cancel"},
+						short:   "Cancel the active task; the next queued one takes over",
+						flags: func(fs *flag.FlagSet) {
+							fs.BoolVar(&flagAll, "all", false, "cancel the queued tasks too")
+						},
+						run: func([]string) { cmdLinesClear(flagAll) },
+					},
+				},
+			},
+			{
+				name:  "app",
+				short: "Manage forbidden apps (process blocklist)",
+				long:  "With no arguments, lists forbidden apps.",
+				run:   func([]string) { cmdAppList() },
+				subs: []*command{
+					{
+						name:    "add",
+						args:    "<name>",
+						short:   "Add an app to the forbidden list",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdAppAdd(args[0]) },
+					},
+					{
+						name:    "rm",
+						aliases: []string{"remove", "del"},
+						args:    "<name>",
+						short:   "Remove an app from the forbidden list",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdAppRemove(args[0]) },
+					},
+					{
+						name:    "list",
+						aliases: []string{"ls"},
+						short:   "List currently forbidden apps",
+						run:     func([]string) { cmdAppList() },
+					},
+				},
+			},
+			{
+				name:  "allow",
+				short: "Recurring windows that permit blocked domains/apps",
+				long:  "With no arguments, lists allowances.",
+				run:   func([]string) { cmdAllowList() },
+				subs: []*command{
+					{
+						name:  "add",
+						args:  "<name> <HH:MM> <HH:MM>",
+						short: "Define an allowance, e.g. evening 19:00 20:00 --domain youtube.com",
+						flags: func(fs *flag.FlagSet) {
+							daysFlag(fs)
+							fs.Var(&flagDomains, "domain", "blocked `domain` to permit; repeatable")
+							fs.Var(&flagApps, "app", "forbidden `app` to permit; repeatable")
+						},
+						minArgs: 3, maxArgs: 3,
+						run: func(args []string) { cmdAllowAdd(args[0], args[1], args[2], flagDays, flagDomains, flagApps) },
+					},
+					{
+						name:    "rm",
+						aliases: []string{"remove", "del"},
+						args:    "<name>",
+						short:   "Delete an allowance (re-blocks anything it lifted)",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdAllowRemove(args[0]) },
+					},
+					{
+						name:    "list",
+						aliases: []string{"ls"},
+						short:   "List allowances and whether they are open",
+						run:     func([]string) { cmdAllowList() },
+					},
+				},
+			},
+			{
+				name:  "schedule",
+				short: "Run commands at a time of day",
+				long: `With no arguments, lists scheduled commands.
+
+The time is "HH:MM" followed by "daily", "weekdays", "weekends" or days
+such as "mon,wed,fri"; the command is any command the chat bridges
+accept that needs no signature; one that can lower restrictions also
+needs a signed payload (see schedule add --help). Put "--" before a
+command that has flags of its own:
+
+  vex-cli schedule add "22:00 daily" throttle black-hole
+  vex-cli schedule add "21:30 weekdays" -- block add reddit.com --for 2h
+
+A run missed by more than an hour (daemon down, machine asleep) is
+skipped.`,
+				run: func([]string) { cmdScheduleList() },
+				subs: []*command{
+					{
+						name:  "add",
+						args:  "<when> <command...>",
+						short: `Run a command at a time, e.g. "22:00 daily" throttle black-hole`,
+						long: `A command that can lower restrictions, such as throttle, cpu or
+block rm, also needs --signed: a payload signed for "schedule-add" with
+the args "<when>|<command>", e.g. "07:00 daily|throttle standard".  It is
+checked again every time the command runs.`,
+						flags: func(fs *flag.FlagSet) {
+							fs.StringVar(&flagSigned, "signed", "", "signed authorization `payload` (JSON) for a command that can lower restrictions")
+						},
+						minArgs: 2, maxArgs: -1,
+						run: func(args []string) { cmdScheduleAdd(args[0], args[1:], flagSigned) },
+					},
+					{
+						name:    "rm",
+						aliases: []string{"remove", "del"},
+						args:    "<id>",
+						short:   "Delete a scheduled command",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdScheduleRemove(args[0]) },
+					},
+					{
+						name:    "list",
+						aliases: []string{"ls"},
+						short:   "List scheduled commands and their next runs",
+						run:     func([]string) { cmdScheduleList() },
+					},
+				},
+			},
+			{
+				name:  "curfew",
+				short: "Manage nightly curfew (network black-hole + forbidden apps)",
+				long:  "With no arguments, shows the curfew.",
+				run:   func([]string) { cmdCurfewStatus() },
+				subs: []*command{
+					{
+						name:    "set",
+						args:    "<HH:MM> <HH:MM> [app...]",
+						short:   "Set the curfew window, e.g. 23:00 07:00 steam",
+						flags:   daysFlag,
+						minArgs: 2, maxArgs: -1,
+						run: func(args []string) { cmdCurfewSet(args[0], args[1], flagDays, args[2:]) },
+					},
+					{
+						name:  "status",
+						short: "Show the curfew and its next transition",
+						run:   func([]string) { cmdCurfewStatus() },
+					},
+				},
+			},
+			{
+				name:      "curfew-override",
+				short:     "End tonight's curfew, or disable it (requires signed authorization)",
+				long:      "The signed args choose the mode: \"\" or \"tonight\", or \"off\".",
+				runSigned: cmdCurfewOverride,
+			},
+			{
+				name:    "lockuntil",
+				args:    "<RFC3339 time|duration>",
+				short:   "Stay locked until a time or for a duration, even if tasks are completed",
+				long:    "For example 8h or 2026-03-01T09:00:00Z.",
+				minArgs: 1, maxArgs: 1,
+				run: func(args []string) { cmdLockUntil(args[0]) },
+			},
+			{
+				name:      "early-release",
+				short:     "End a lockuntil before its deadline (requires signed authorization)",
+				runSigned: func(*security.SignedCommand) { cmdEarlyRelease() },
+			},
+			{
+				name:  "calendar",
+				short: "Show calendar presets in force and the next week of mapped events",
+				run:   func([]string) { cmdCalendar() },
+			},
+			{
+				name:  "credits",
+				short: "Show the credit balance and the rewards it can buy",
+				run:   func([]string) { cmdCredits() },
+				subs: []*command{
+					{
+						name:    "spend",
+						args:    "<reward> [domain|app]",
+						short:   "Spend credits on a reward; unblock rewards take the domain or app to lift",
+						minArgs: 1, maxArgs: 2,
+						run: func(args []string) { cmdCreditsSpend(args) },
+					},
+				},
+			},
+			{
+				name:  "report",
+				short: "Print a compliance report",
+				flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&flagPeriod, "period", "", "report `period`: day or week (default day)")
+					fs.BoolVar(&flagHTML, "html", false, "render as HTML")
+					fs.BoolVar(&flagSend, "send", false, "also deliver the report as report.json configures")
+				},
+				run: func([]string) { cmdReport(flagPeriod, flagHTML, flagSend) },
+			},
+			{
+				name:  "audit",
+				short: "Export or verify the audit log",
+				subs: []*command{
+					{
+						name:  "export",
+						short: "Write the audit trail as CSV or JSON",
+						flags: func(fs *flag.FlagSet) {
+							fs.StringVar(&flagFormat, "format", "", "output `format`: csv or json (default csv)")
+							fs.StringVar(&flagSince, "since", "", "only entries after `time` (a date, RFC3339 time or duration)")
+							fs.StringVar(&flagOutput, "output", "", "write to `file` instead of stdout")
+							fs.StringVar(&flagOutput, "o", "", "shorthand for --output")
+						},
+						run: func([]string) { cmdAuditExport(flagFormat, flagSince, flagOutput) },
+					},
+					{
+						name:    "verify",
+						args:    "[YYYY-MM-DD]",
+						short:   "Check the log against its signed daily checkpoints",
+						long:    "Exits 1 if any checkpoint fails: a bad signature, a broken chain, or entries changed or removed since the day was sealed.",
+						maxArgs: 1,
+						run:     func(args []string) { cmdAuditVerify(argOr(args, "")) },
+					},
+				},
+			},
+			{
+				name:  "request",
+				short: "Ask the keyholder to lift a blocked domain or forbidden app for a while",
+				long:  "With no arguments, lists pending requests.",
+				run:   func([]string) { cmdRequestList() },
+				subs: []*command{
+					{
+						name:  "exception",
+						args:  "<domain|app>",
+						short: "Request an exception, e.g. zoom --for 1h --reason standup",
+						flags: func(fs *flag.FlagSet) {
+							fs.StringVar(&flagFor, "for", "", "how long the exception lasts, a `duration` (required)")
+							fs.StringVar(&flagReason, "reason", "", "`text` shown to the keyholder")
+						},
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdRequestException(args[0], flagFor, flagReason) },
+					},
+					{
+						name:    "list",
+						aliases: []string{"ls"},
+						short:   "List pending requests",
+						run:     func([]string) { cmdRequestList() },
+					},
+					{
+						name:    "cancel",
+						aliases: []string{"rm"},
+						args:    "<id>",
+						short:   "Withdraw a pending request",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdRequestCancel(args[0]) },
+					},
+				},
+			},
+			{
+				name:      "approve",
+				short:     "Approve a pending exception request (requires signed authorization)",
+				long:      "The signed args are the request ID.",
+				runSigned: cmdApprove,
+			},
+			{
+				name:      "pause",
+				short:     "Suspend all enforcement until a time or for a duration (requires signed authorization)",
+				long:      "The signed args are the deadline: a duration such as 168h, or an RFC3339 time.",
+				runSigned: cmdPause,
+			},
+			{
+				name:  "resume",
+				short: "End a pause early and restore enforcement",
+				run:   func([]string) { cmdResume() },
+			},
+			{
+				name:      "reset-score",
+				short:     "Reset failure score to zero (requires signed authorization)",
+				runSigned: func(*security.SignedCommand) { cmdResetScore() },
+			},
+			{
+				name:      "unlock",
+				short:     "Lift all restrictions (requires signed authorization)",
+				long:      "Signing a deadline (e.g. 3h) as the args re-applies the current restrictions when it passes.",
+				runSigned: cmdUnlock,
+			},
+			{
+				name:  "check",
+				short: "Run anti-tamper and integrity checks",
+				run:   func([]string) { cmdCheck() },
+			},
+			{
+				name:  "health",
+				short: "Show the daemon's background workers and their restarts",
+				long:  "Exits 1 while any worker is waiting to be restarted after a failure.",
+				run:   func([]string) { cmdHealth() },
+			},
+			{
+				name:  "sessions",
+				short: "List the daemon's client sessions and the process behind each",
+				run:   func([]string) { cmdSessions() },
+				subs: []*command{
+					{
+						name:  "revoke",
+						args:  "<id>",
+						short: "End a session and keep its process from opening another",
+						long: `The process is kept out until the session would have expired.  With
+--exe the session's executable is barred instead, until 'sessions unbar':
+its other sessions end, and no process running it gets another.  A
+known-good vex-cli cannot be barred.`,
+						flags: func(fs *flag.FlagSet) {
+							fs.BoolVar(&flagExe, "exe", false, "bar the session's executable, by its SHA-256, for good")
+						},
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdSessionRevoke(args[0], flagExe) },
+					},
+					{
+						name:    "unbar",
+						args:    "<sha256>",
+						short:   "Let a barred executable open sessions again",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdSessionUnbar(args[0]) },
+					},
+				},
+			},
+			{
+				name:  "version",
+				short: "Show the versions of vex-cli and the running vexd",
+				long:  "Exits 1 if they were not built from the same source.",
+				run:   func([]string) { cmdVersion() },
+			},
+			{
+				name:  "reload",
+				short: "Re-read the daemon config, domain and app lists, and manifest",
+				long:  "Only what changed is applied, so enforcement stays in place throughout.  Sending vexd SIGHUP does the same.",
+				run:   func([]string) { cmdReload() },
+			},
+			{
+				name:  "config",
+				short: "Show the daemon configuration in effect",
+				long:  "Every setting is listed by its dotted name, e.g. guardian.dns_refresh_minutes, with its value as JSON.",
+				run:   func([]string) { cmdConfig("") },
+				subs: []*command{
+					{
+						name:  "show",
+						short: "Show every setting in effect",
+						run:   func([]string) { cmdConfig("") },
+					},
+					{
+						name:    "get",
+						args:    "<name>",
+						short:   "Show one setting in effect",
+						minArgs: 1, maxArgs: 1,
+						run: func(args []string) { cmdConfig(args[0]) },
+					},
+					{
+						name:      "set",
+						short:     "Change a setting in config.json and reload (requires signed authorization)",
+						long:      "The signed args are name=value, e.g. guardian.dns_refresh_minutes=15; a list is a JSON array or comma-separated, and name= restores the default.",
+						runSigned: cmdConfigSet,
+					},
+				},
+			},
+			{
+				name:    "help",
+				args:    "[command...]",
+				short:   "Show help for a command",
+				maxArgs: -1,
+				run: func(args []string) {
+					c, err := root.find(args)
+					if err != nil {
+						root.lookup("help").usageError(err.Error())
+					}
+					c.help(os.Stdout)
+				},
+			},
+		},
+	}).link()
+	// Only vextest builds have the clock of vexd --simulate (clock.go).
+	if c := clockCommand(); c != nil {
+		i := slices.IndexFunc(root.subs, func(s *command) bool { return s.name == "help" })
+		root.subs = slices.Insert(root.subs, i, c)
+		root.link()
 	}
 }
 
-func printUsage() {
-	fmt.Println("VEX-CLI (Protocol 106-V) - Control Plane")
-	fmt.Println()
-	fmt.Println("Usage: vex-cli <command> [args]")
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Println("  status       Display current system state (human-readable)")
-	fmt.Println("  state        Dump live system state as JSON (machine-readable)")
-	fmt.Println("  throttle     Set network profile (standard|choke|dial-up|black-hole|blackout)")
-	fmt.Println("  cpu          Set CPU limit percentage (0-100)")
-	fmt.Println("  latency      Set input latency in milliseconds")
-	fmt.Println("  oom          Set OOM score adjustment (-1000 to 1000)")
-	fmt.Println("  penance      Start interactive penance submission session")
-	fmt.Println("  block        Manage SNI domain blocklist:")
-	fmt.Println("    block add <domain>    Add a domain to the firewall blocklist")
-	fmt.Println("    block rm <domain>     Remove a domain from the blocklist")
-	fmt.Println("    block list            List currently blocked domains")
-	fmt.Println("    block <domain>        Shorthand for 'block add <domain>'")
-	fmt.Println("  lines        Manage writing-lines task:")
-	fmt.Println("    lines set <N> <phrase> Assign phrase to be written N times")
-	fmt.Println("    lines status           Show progress")
-	fmt.Println("    lines submit           Interactive submission (type lines)")
-	fmt.Println("    lines clear            Cancel the active task")
-	fmt.Println("  app          Manage forbidden apps (process blocklist):")
-	fmt.Println("    app add <name>         Add an app to the forbidden list")
-	fmt.Println("    app rm <name>          Remove an app from the forbidden list")
-	fmt.Println("    app list               List currently forbidden apps")
-	fmt.Println("  reset-score  Reset failure score to zero (requires signed authorization)")
-	fmt.Println("  unlock       Lift all restrictions (requires signed authorization)")
-	fmt.Println("  check        Run anti-tamper and integrity checks")
-	fmt.Println()
-	fmt.Println("All commands talk to the running vexd daemon and persist for next boot.")
+// argOr returns the first argument, or def when there is none.
+func argOr(args []string, def string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return def
 }
 
 // ── Helpers ─────────────────────────────────────────────────────────
 
-func client() *ipc.Client { return ipc.NewClient() }
+// sharedClient is made on first use, after the flags are parsed, and kept
+// so that the whole invocation runs in one daemon session.
+var sharedClient *ipc.Client
+
+func client() *ipc.Client {
+	if sharedClient == nil {
+		sharedClient = ipc.NewClient(flagSocket)
+	}
+	return sharedClient
+}
 
+// sendOrDie sends req and returns the daemon's response, exiting if the
+// daemon cannot be reached or refuses the command.  With --json the
+// response is printed as it is and the command ends there, so every
+// command that goes through sendOrDie gets JSON output for free.
 func sendOrDie(req *ipc.Request) *ipc.Response {
-	resp, err := client().Send(req)
-	if err != nil {
-		log.Fatalf("Failed to communicate with vexd: %v", err)
+	resp := send(req)
+	if flagJSON {
+		printJSON(resp)
+		os.Exit(exitFor(resp))
 	}
 	if !resp.OK {
-		log.Fatalf("Command failed: %s", resp.Error)
+		die(exitFor(resp), "Command failed: %s", resp.Error)
+	}
+	return resp
+}
+
+func send(req *ipc.Request) *ipc.Response {
+	resp, err := client().Send(req)
+	if err != nil && ipc.Unreachable(err) && ipc.Spoolable(req.Command) {
+		return spool(req, err)
+	}
+	if err != nil {
+		die(exitUnreachable, "Failed to communicate with vexd: %v", err)
 	}
+	checkDaemonBuild(resp.Daemon)
 	return resp
 }
 
+// spool queues req, which vexd could not be reached for, to run when
+// vexd starts, and answers for the daemon.
+func spool(req *ipc.Request, sendErr error) *ipc.Response {
+	if _, err := ipc.Spool(req); err != nil {
+		die(exitUnreachable, "Failed to communicate with vexd: %v; could not queue the command either: %v", sendErr, err)
+	}
+	return &ipc.Response{
+		OK:      true,
+		Queued:  true,
+		Message: fmt.Sprintf("vexd is not running; %s queued, it runs when vexd starts", req.Command),
+	}
+}
+
+// checkDaemonBuild warns, on stderr, when vexd was built from different
+// source than this vex-cli: the usual cause of "unknown command" after
+// only one of them was rebuilt.  Daemons from before the check say
+// nothing and are not warned about.
+func checkDaemonBuild(daemon string) {
+	if own := buildinfo.Get().ID(); daemon != "" && daemon != own {
+		fmt.Fprintf(os.Stderr, "Warning: vex-cli %s does not match vexd %s; rebuild and restart both\n", own, daemon)
+	}
+}
+
+func printJSON(resp *ipc.Response) {
+	out, _ := json.MarshalIndent(resp, "", "  ")
+	fmt.Println(string(out))
+}
+
+// interactive rejects --json for commands that run a terminal session.
+func interactive(name string) {
+	if flagJSON {
+		die(exitInvalid, "%s is interactive and has no JSON output", name)
+	}
+}
+
 // ── Command implementations ─────────────────────────────────────────
 
 func cmdState() {
-	resp, err := client().Send(&ipc.Request{Command: ipc.CmdState})
-	if err != nil {
-		log.Fatalf("Failed to communicate with vexd: %v", err)
-	}
+	resp := send(&ipc.Request{Command: ipc.CmdState})
 	if !resp.OK {
-		log.Fatalf("Command failed: %s", resp.Error)
+		die(exitFor(resp), "Command failed: %s", resp.Error)
 	}
 	out, _ := json.MarshalIndent(resp.State, "", "  ")
 	fmt.Println(string(out))
 }
 
+// cmdWatch prints each state snapshot as a JSON line.  With events, the
+// daemon's events are interleaved as {"event": {...}} lines.
+func cmdWatch(events bool) {
+	watch := client().Watch
+	if events {
+		watch = client().WatchEvents
+	}
+	err := watch(func(resp *ipc.Response) bool {
+		var out []byte
+		if resp.Event != nil {
+			out, _ = json.Marshal(map[string]*ipc.Event{"event": resp.Event})
+		} else {
+			out, _ = json.Marshal(resp.State)
+		}
+		fmt.Println(string(out))
+		return true
+	})
+	if err != nil {
+		die(exitUnreachable, "Failed to communicate with vexd: %v", err)
+	}
+}
+
 func cmdStatus() {
+	if flagBrief || flagWaybar {
+		if flagJSON {
+			die(exitInvalid, "status --brief and --waybar can't be combined with --json")
+		}
+		resp := sendOrDie(&ipc.Request{Command: ipc.CmdStatus})
+		printBrief(resp.State)
+		if flagWaybar {
+			os.Exit(exitOK)
+		}
+		os.Exit(statusExit(resp))
+	}
+	if flagJSON {
+		// The text report includes the live metrics; so does the JSON.
+		resp := send(&ipc.Request{Command: ipc.CmdStatus})
+		if m, err := client().Send(&ipc.Request{Command: ipc.CmdMetrics}); err == nil && m.OK {
+			resp.Metrics = m.Metrics
+		}
+		printJSON(resp)
+		os.Exit(statusExit(resp))
+	}
 	resp := sendOrDie(&ipc.Request{Command: ipc.CmdStatus})
-	s := resp.State
+	printStatus(resp.State)
+	os.Exit(statusExit(resp))
+}
 
+// statusExit lets scripts test the lock without parsing the report.
+func statusExit(resp *ipc.Response) int {
+	if resp.OK && resp.State.Compliance.Locked {
+		return exitLocked
+	}
+	return exitFor(resp)
+}
+
+// printBrief prints the --brief or --waybar line for s.
+func printBrief(s *state.SystemState) {
+	if flagWaybar {
+		fmt.Println(waybarStatus(s))
+		return
+	}
+	fmt.Println(briefStatus(s))
+}
+
+// statusRefresh is how often status --watch redraws without a state
+// change, so that countdowns and live metrics keep moving.
+const statusRefresh = 2 * time.Second
+
+// watchStatus redraws the status report in place whenever the daemon's
+// state changes, until interrupted.  With --brief or --waybar it prints a
+// new line per change instead, for status bars that read a stream.
+func watchStatus() {
+	if flagJSON {
+		die(exitInvalid, "status --watch has no JSON output; use 'vex-cli watch' for a stream of JSON states")
+	}
+	states := make(chan *state.SystemState)
+	done := make(chan error, 1)
+	go func() {
+		done <- client().Watch(func(resp *ipc.Response) bool {
+			if resp.State != nil {
+				states <- resp.State
+			}
+			return true
+		})
+	}()
+
+	tick := time.NewTicker(statusRefresh)
+	defer tick.Stop()
+	var last *state.SystemState
+	for {
+		select {
+		case last = <-states:
+		case <-tick.C:
+			if last == nil || flagBrief || flagWaybar {
+				continue
+			}
+		case err := <-done:
+			die(exitUnreachable, "Failed to communicate with vexd: %v", err)
+		}
+		if flagBrief || flagWaybar {
+			printBrief(last)
+			continue
+		}
+		fmt.Print("\033[H\033[2J") // cursor home, clear screen
+		printStatus(last)
+		fmt.Println("Watching for changes; Ctrl+C to exit.")
+	}
+}
+
+func printStatus(s *state.SystemState) {
 	fmt.Println("========================================")
 	fmt.Println("VEX-CLI STATUS REPORT")
 	fmt.Printf("Time: %s\n", time.Now().UTC().Format(time.RFC3339))
 	fmt.Println("========================================")
 
+	if p := s.Pause; p != nil {
+		fmt.Println()
+		fmt.Println(heading("[PAUSED]"))
+		if until, err := time.Parse(time.RFC3339, p.Until); err == nil {
+			fmt.Printf("  Resumes:  %s (%s remaining)\n",
+				until.Local().Format("Mon 2006-01-02 15:04"), fmtCountdown(time.Until(until)))
+		}
+		fmt.Printf("  Restores: profile %s, %d blocked domains, %d forbidden apps\n",
+			p.Saved.Network.Profile, len(p.Saved.Guardian.BlockedDomains), len(p.Saved.ForbiddenApps))
+	}
+
 	fmt.Println()
-	fmt.Println("[COMPLIANCE]")
-	fmt.Printf("  System Locked:  %v\n", s.Compliance.Locked)
-	fmt.Printf("  Failure Score:  %d\n", s.Compliance.FailureScore)
+	fmt.Println(heading("[COMPLIANCE]"))
+	fmt.Printf("  System Locked:  %s\n", lockState(s.Compliance.Locked))
+	fmt.Printf("  Failure Score:  %s\n", score(s.Compliance.FailureScore))
+	if s.Compliance.Tier != "" {
+		fmt.Printf("  Tier:           %s\n", s.Compliance.Tier)
+	}
 	fmt.Printf("  Task Status:    %s\n", s.Compliance.TaskStatus)
+	if f := s.Compliance.Failures; len(f) > 0 {
+		var cats []string
+		for c := range f {
+			cats = append(cats, c)
+		}
+		sort.Slice(cats, func(i, j int) bool {
+			if f[cats[i]] != f[cats[j]] {
+				return f[cats[i]] > f[cats[j]]
+			}
+			return cats[i] < cats[j]
+		})
+		var parts []string
+		for _, c := range cats {
+			parts = append(parts, fmt.Sprintf("%s %d", c, f[c]))
+		}
+		fmt.Printf("  Failures:       %s\n", strings.Join(parts, ", "))
+	}
+	if until, err := time.Parse(time.RFC3339, s.Compliance.LockUntil); err == nil && time.Until(until) > 0 {
+		fmt.Printf("  Locked Until:   %s (%s remaining)\n",
+			until.Local().Format("Mon 2006-01-02 15:04"), fmtCountdown(time.Until(until)))
+	}
+	if r := s.Relock; r != nil {
+		if until, err := time.Parse(time.RFC3339, r.Until); err == nil {
+			fmt.Printf("  Re-locks:       %s (%s remaining)\n",
+				until.Local().Format("Mon 2006-01-02 15:04"), fmtCountdown(time.Until(until)))
+		}
+	}
 	if s.Writing.Active {
-		fmt.Printf("  Lines Done:     %d / %d\n", s.Writing.Completed, s.Writing.Required)
+		fmt.Printf("  Lines Done:     %s\n", progressBar(s.Writing.Completed, s.Writing.Required, 20))
+	}
+	if since, err := time.Parse(time.RFC3339, s.Streak.Since); err == nil {
+		fmt.Printf("  Streak:         %d clean days (no failures or kills)\n", int(time.Since(since)/(24*time.Hour)))
+	}
+	if c := s.Credits; c.LastDay != "" || c.Balance > 0 {
+		fmt.Printf("  Credits:        %d\n", c.Balance)
 	}
 
 	fmt.Println()
-	fmt.Println("[NETWORK]")
-	fmt.Printf("  Profile:      %s\n", s.Network.Profile)
+	fmt.Println(heading("[NETWORK]"))
+	fmt.Printf("  Profile:      %s\n", profile(s.Network.Profile))
 	fmt.Printf("  Packet Loss:  %.2f%%\n", s.Network.PacketLossPct)
+	printApplyStatus(s.Network.ApplyStatus)
 
 	fmt.Println()
-	fmt.Println("[COMPUTE]")
+	fmt.Println(heading("[COMPUTE]"))
 	fmt.Printf("  CPU Limit:      %d%%\n", s.Compute.CPULimitPct)
+	if s.Compute.Priority != "" {
+		fmt.Printf("  Priority:       %s\n", s.Compute.Priority)
+	}
+	if s.Compute.GPULimitPct > 0 && s.Compute.GPULimitPct < 100 {
+		fmt.Printf("  GPU Limit:      %d%%\n", s.Compute.GPULimitPct)
+	}
 	fmt.Printf("  OOM Score Adj:  %d\n", s.Compute.OOMScoreAdj)
-	fmt.Printf("  Input Latency:  %dms\n", s.Compute.InputLatencyMs)
+	if s.Compute.InputLatencyMaxMs > 0 {
+		fmt.Printf("  Input Latency:  %d-%dms (jitter)\n", s.Compute.InputLatencyMs, s.Compute.InputLatencyMaxMs)
+	} else {
+		fmt.Printf("  Input Latency:  %dms\n", s.Compute.InputLatencyMs)
+	}
+	if s.Compute.InputLockUntil != "" {
+		fmt.Printf("  Input Lock:     until %s\n", s.Compute.InputLockUntil)
+	}
+	if s.Compute.Frozen {
+		fmt.Printf("  Frozen:         until %s\n", s.Compute.FreezeUntil)
+	} else if s.Compute.FreezeAt != "" {
+		fmt.Printf("  Freeze:         %s until %s\n", s.Compute.FreezeAt, s.Compute.FreezeUntil)
+	}
+	printApplyStatus(s.Compute.ApplyStatus)
+
+	if m := s.Media; m.Capped() {
+		fmt.Println()
+		fmt.Println(heading("[MEDIA]"))
+		if m.BrightnessPct > 0 && m.BrightnessPct < 100 {
+			fmt.Printf("  Brightness:  at most %d%%\n", m.BrightnessPct)
+		}
+		switch {
+		case m.Muted:
+			fmt.Println("  Volume:      muted")
+		case m.VolumePct > 0 && m.VolumePct < 100:
+			fmt.Printf("  Volume:      at most %d%%\n", m.VolumePct)
+		}
+	}
 
 	fmt.Println()
-	fmt.Println("[GUARDIAN]")
+	fmt.Println(heading("[GUARDIAN]"))
 	fmt.Printf("  Firewall: %v\n", s.Guardian.FirewallEnabled)
 	fmt.Printf("  Reaper:   %v\n", s.Guardian.ReaperEnabled)
 	if len(s.Guardian.BlockedDomains) > 0 {
@@ -271,238 +1084,1371 @@ func cmdStatus() {
 			fmt.Printf("            - %s\n", d)
 		}
 	}
+	printApplyStatus(s.Guardian.ApplyStatus)
+
+	printSurveillance()
+
+	if hasTemporary(s.Expiries) {
+		fmt.Println()
+		fmt.Println(heading("[TEMPORARY]"))
+		for _, e := range s.Expiries {
+			printExpiry(e)
+		}
+	}
+
+	if s.Curfew.Enabled {
+		fmt.Println()
+		fmt.Println(heading("[CURFEW]"))
+		printCurfew(s.Curfew)
+	}
+
+	if len(s.Calendar.Presets) > 0 {
+		fmt.Println()
+		fmt.Println(heading("[CALENDAR]"))
+		fmt.Printf("  Presets:  %s\n", strings.Join(s.Calendar.Presets, ", "))
+	}
+
+	if len(s.Allowances) > 0 {
+		fmt.Println()
+		fmt.Println(heading("[ALLOWANCES]"))
+		for _, a := range s.Allowances {
+			w := scheduler.Window{Start: a.Start, End: a.End, Days: a.Days}
+			status := dim("closed")
+			if a.Active {
+				status = green("OPEN")
+			}
+			fmt.Printf("  %-12s %s [%s]\n", a.Name, w, status)
+		}
+	}
+
+	if len(s.Exceptions) > 0 {
+		fmt.Println()
+		fmt.Println(heading("[EXCEPTION REQUESTS]"))
+		for _, r := range s.Exceptions {
+			printException(r)
+		}
+	}
 
 	if s.Writing.Active {
 		fmt.Println()
-		fmt.Println("[WRITING TASK]")
+		fmt.Println(heading("[WRITING TASK]"))
 		fmt.Printf("  Phrase:    %q\n", s.Writing.Phrase)
-		fmt.Printf("  Progress:  %d / %d\n", s.Writing.Completed, s.Writing.Required)
+		fmt.Printf("  Progress:  %s\n", progressBar(s.Writing.Completed, s.Writing.Required, 30))
 		fmt.Printf("  Remaining: %d\n", s.Writing.Required-s.Writing.Completed)
+		if len(s.LinesQueue) > 0 {
+			fmt.Printf("  Queued:    %d more\n", len(s.LinesQueue))
+		}
+	}
+
+	if len(s.Transitions) > 0 {
+		fmt.Println()
+		fmt.Println(heading("[TIMELINE]"))
+		fmt.Printf("  %s\n", transitionChain(s.Transitions))
+		for _, t := range s.Transitions {
+			fmt.Printf("  %s  %-11s %s, %s\n", fmtLocal(t.At), t.By, profile(t.Profile), lockState(t.Locked))
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("State last updated: %s (by: %s)\n", s.LastUpdated, s.ChangedBy)
+	fmt.Println("========================================")
+}
+
+// printSurveillance renders live keyboard metrics.  Daemons that predate
+// the metrics command are skipped silently.
+func printSurveillance() {
+	resp, err := client().Send(&ipc.Request{Command: ipc.CmdMetrics})
+	if err != nil || !resp.OK || resp.Metrics == nil {
+		return
+	}
+	m := resp.Metrics
+
+	fmt.Println()
+	fmt.Println(heading("[SURVEILLANCE]"))
+	fmt.Printf("  Keystrokes:     %d (since %s)\n", m.Keystrokes, m.Since)
+	fmt.Printf("  Lines:          %d\n", m.LinesCompleted)
+	fmt.Printf("  KPM (1m / 5m):  %.1f / %.1f\n", m.KPM1m, m.KPM5m)
+	if m.InputLatencyMax > 0 {
+		fmt.Printf("  Input Latency:  %d-%dms (jitter)\n", m.InputLatencyMs, m.InputLatencyMax)
+	} else {
+		fmt.Printf("  Input Latency:  %dms\n", m.InputLatencyMs)
+	}
+	if m.ActiveApp != "" {
+		fmt.Printf("  Active App:     %s\n", m.ActiveApp)
+	}
+	if len(m.Devices) == 0 {
+		fmt.Println("  Devices:        none")
+	} else {
+		fmt.Printf("  Devices:        %d monitored\n", len(m.Devices))
+		for _, d := range m.Devices {
+			fmt.Printf("                  - %s\n", d)
+		}
+	}
+}
+
+func hasTemporary(expiries []state.Expiry) bool {
+	for _, e := range expiries {
+		if e.Kind != "lock" {
+			return true
+		}
+	}
+	return false
+}
+
+// fmtCountdown renders a remaining duration as e.g. "2d 3h 14m".
+func fmtCountdown(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, d/time.Hour, (d%time.Hour)/time.Minute)
+	}
+	return fmt.Sprintf("%dh %dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
+// printExpiry shows one --for restriction and when it reverts.  The
+// lockuntil deadline is shown under [COMPLIANCE] instead.
+func printExpiry(e state.Expiry) {
+	if e.Kind == "lock" {
+		return
+	}
+	left := "due"
+	if until, err := time.Parse(time.RFC3339, e.Until); err == nil && time.Until(until) > 0 {
+		left = "in " + time.Until(until).Round(time.Second).String()
+	}
+	if e.Kind == "block" {
+		fmt.Printf("  block %s: unblocked %s\n", e.Target, left)
+		return
+	}
+	if e.Kind == "exception" {
+		_, name, _ := strings.Cut(e.Target, ":")
+		fmt.Printf("  exception %s: %s again %s\n", name, e.Previous, left)
+		return
+	}
+	fmt.Printf("  %s %s: back to %s %s\n", e.Kind, e.Value, e.Previous, left)
+}
+
+// printApplyStatus shows when the daemon last enforced a section and
+// whether that attempt failed.
+func printApplyStatus(a state.ApplyStatus) {
+	if a.LastApplied == "" {
+		fmt.Println("  Applied:  never")
+		return
+	}
+	if a.LastError != "" {
+		fmt.Printf("  Applied:  %s at %s\n", red("FAILED"), a.LastApplied)
+		fmt.Printf("  Error:    %s\n", red(a.LastError))
+		return
+	}
+	fmt.Printf("  Applied:  OK at %s\n", a.LastApplied)
+}
+
+// withFor adds the optional --for period to request args.
+func withFor(args map[string]string, period string) map[string]string {
+	if period != "" {
+		args["for"] = period
+	}
+	return args
+}
+
+func cmdThrottle(profile, period string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdThrottle,
+		Args:    withFor(map[string]string{"profile": profile}, period),
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdCPU(pct, period string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdCPU,
+		Args:    withFor(map[string]string{"percent": pct}, period),
+	})
+	fmt.Println(resp.Message)
+}
+
+// cmdLatency accepts a fixed delay ("200") or a jitter range ("50-400").
+func cmdLatency(ms, period string) {
+	args := map[string]string{"ms": ms}
+	if lo, hi, ok := strings.Cut(ms, "-"); ok {
+		args = map[string]string{"ms": lo, "max_ms": hi}
+	}
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdLatency,
+		Args:    withFor(args, period),
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdInputLock(duration string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdInputLock,
+		Args:    map[string]string{"duration": duration},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdFreeze(duration string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdFreeze,
+		Args:    map[string]string{"duration": duration},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdUsage(rng string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdUsage,
+		Args:    map[string]string{"range": rng},
+	})
+
+	if len(resp.Usage) == 1 {
+		d := resp.Usage[0]
+		fmt.Printf("[USAGE] %s\n", d.Date)
+		fmt.Printf("  Screen Time:    %s\n", fmtSeconds(d.ActiveSeconds))
+		fmt.Printf("  Keystrokes:     %d\n", d.Keystrokes)
+		apps := topApps(d.Apps, 10)
+		if len(apps) > 0 {
+			fmt.Println("  Apps:")
+		}
+		for _, app := range apps {
+			fmt.Printf("    %-20s %s\n", app, fmtSeconds(d.Apps[app]))
+		}
+		sites := topApps(d.Sites, 10)
+		if len(sites) > 0 {
+			fmt.Println("  Sites:")
+		}
+		for _, site := range sites {
+			fmt.Printf("    %-20s %s\n", site, fmtSeconds(d.Sites[site]))
+		}
+		traffic := topApps(d.Network, 10)
+		if len(traffic) > 0 {
+			fmt.Println("  Network:")
+		}
+		for _, app := range traffic {
+			fmt.Printf("    %-20s %s\n", app, fmtBytes(d.Network[app]))
+		}
+		return
+	}
+
+	var total float64
+	fmt.Println("[USAGE]")
+	fmt.Printf("  %-12s %-10s %-10s %s\n", "DATE", "SCREEN", "KEYS", "TOP APP")
+	for _, d := range resp.Usage {
+		top := "-"
+		if apps := topApps(d.Apps, 1); len(apps) > 0 {
+			top = fmt.Sprintf("%s (%s)", apps[0], fmtSeconds(d.Apps[apps[0]]))
+		}
+		fmt.Printf("  %-12s %-10s %-10d %s\n", d.Date, fmtSeconds(d.ActiveSeconds), d.Keystrokes, top)
+		total += d.ActiveSeconds
+	}
+	fmt.Printf("  Total:         %s\n", fmtSeconds(total))
+}
+
+// cmdHistory draws the recorded metrics for the last day, week or month
+// as sparklines, followed by a row per bucket.
+func cmdHistory(rng string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdHistory,
+		Args:    map[string]string{"range": rng},
+	})
+
+	n := len(resp.History)
+	score, kills, screen, locked := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	has := make([]bool, n)
+	var maxScore, totalKills int
+	var totalScreen float64
+	for i, p := range resp.History {
+		score[i], kills[i], screen[i], locked[i] = float64(p.Score), float64(p.Kills), p.ActiveSeconds, float64(p.LockedPct)
+		has[i] = p.Samples > 0
+		maxScore = max(maxScore, p.Score)
+		totalKills += p.Kills
+		totalScreen += p.ActiveSeconds
+	}
+
+	fmt.Printf("[HISTORY] last %s\n", rng)
+	if !slices.Contains(has, true) {
+		fmt.Println("  Nothing recorded yet.")
+		return
+	}
+	fmt.Printf("  Score    %s  max %d\n", history.Sparkline(score, has), maxScore)
+	fmt.Printf("  Kills    %s  total %d\n", history.Sparkline(kills, has), totalKills)
+	fmt.Printf("  Screen   %s  total %s\n", history.Sparkline(screen, has), fmtSeconds(totalScreen))
+	fmt.Printf("  Locked   %s\n", history.Sparkline(locked, has))
+
+	fmt.Printf("\n  %-16s %-6s %-7s %-6s %-8s %s\n", "FROM", "SCORE", "LOCKED", "KILLS", "SCREEN", "PROFILE")
+	for _, p := range resp.History {
+		if p.Samples == 0 {
+			continue
+		}
+		start, _ := time.Parse(time.RFC3339, p.Start)
+		fmt.Printf("  %-16s %-6d %-7s %-6d %-8s %s\n", start.Local().Format("Mon 01-02 15:04"), p.Score,
+			fmt.Sprintf("%d%%", p.LockedPct), p.Kills, fmtSeconds(p.ActiveSeconds), p.Profile)
+	}
+}
+
+// cmdScoreHistory draws the failure score over the range as a sparkline,
+// followed by a row per change.
+func cmdScoreHistory(rng string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdScoreHistory,
+		Args:    map[string]string{"range": rng},
+	})
+	changes := resp.Scores
+	current := resp.State.Compliance.FailureScore
+
+	if rng == "all" {
+		fmt.Println("[SCORE] all time")
+	} else {
+		fmt.Printf("[SCORE] last %s\n", rng)
+	}
+	if len(changes) == 0 {
+		fmt.Printf("  No changes; the score is %d.\n", current)
+		return
+	}
+
+	now := time.Now()
+	from, n, err := history.ParseRange(rng, now)
+	if err != nil { // "all": from the first change
+		from, _ = time.Parse(time.RFC3339, changes[0].Time)
+		n = 30
+	}
+	values, has := scoreSeries(changes, from, now, n)
+	peak := 0
+	for _, c := range changes {
+		peak = max(peak, c.From, c.To)
+	}
+	fmt.Printf("  Score    %s  now %d, max %d\n", history.Sparkline(values, has), current, peak)
+
+	fmt.Printf("\n  %-16s %-7s %-6s %s\n", "TIME", "CHANGE", "SCORE", "CAUSE")
+	for _, c := range changes {
+		fmt.Printf("  %-16s %-7s %-6d %s\n", fmtLocal(c.Time), fmt.Sprintf("%+d", c.To-c.From), c.To, c.Cause)
+	}
+}
+
+// scoreSeries samples the score at the end of each of n equal buckets of
+// [from, to): the score after the last change before then, or before the
+// first change for buckets that end earlier.
+func scoreSeries(changes []ipc.ScoreChange, from, to time.Time, n int) ([]float64, []bool) {
+	values, has := make([]float64, n), make([]bool, n)
+	step := to.Sub(from) / time.Duration(n)
+	score, next := changes[0].From, 0
+	for b := range n {
+		end := from.Add(step * time.Duration(b+1))
+		for ; next < len(changes); next++ {
+			if t, _ := time.Parse(time.RFC3339, changes[next].Time); !t.Before(end) {
+				break
+			}
+			score = changes[next].To
+		}
+		if b == n-1 {
+			score = changes[len(changes)-1].To
+		}
+		values[b], has[b] = float64(score), true
+	}
+	return values, has
+}
+
+// topApps returns up to n apps ordered by descending focus time, or
+// traffic.
+func topApps[V float64 | uint64](apps map[string]V, n int) []string {
+	names := make([]string, 0, len(apps))
+	for app := range apps {
+		names = append(names, app)
+	}
+	sort.Slice(names, func(i, j int) bool { return apps[names[i]] > apps[names[j]] })
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+// fmtBytes formats a byte count as "812 KB", "43.2 MB" or "1.4 GB".
+func fmtBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
+
+// fmtSeconds formats a duration as "3h12m" or "45m".
+func fmtSeconds(secs float64) string {
+	mins := int(secs+30) / 60
+	if mins < 60 {
+		return fmt.Sprintf("%dm", mins)
+	}
+	return fmt.Sprintf("%dh%02dm", mins/60, mins%60)
+}
+
+// cmdReport prints a compliance report for the last day or week.  With
+// --send the daemon also delivers it as report.json configures.
+func cmdReport(period string, html, send bool) {
+	reqArgs := map[string]string{}
+	if period != "" {
+		reqArgs["period"] = period
+	}
+	if html {
+		reqArgs["format"] = "html"
+	}
+	if send {
+		reqArgs["send"] = "true"
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdReport, Args: reqArgs})
+	fmt.Print(resp.Message)
+	if send {
+		fmt.Fprintln(os.Stderr, "Report sent.")
+	}
+}
+
+// cmdAuditExport prints the audit export, or writes it to --output so
+// that log lines the CLI prints itself cannot end up in the file.
+func cmdAuditExport(format, since, output string) {
+	reqArgs := map[string]string{}
+	if format != "" {
+		reqArgs["format"] = format
+	}
+	if since != "" {
+		reqArgs["since"] = since
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdAuditExport, Args: reqArgs})
+	if output == "" {
+		fmt.Print(resp.Message)
+		return
+	}
+	if err := os.WriteFile(output, []byte(resp.Message), 0600); err != nil {
+		die(exitFailure, "Failed to write %s: %v", output, err)
+	}
+	fmt.Fprintf(os.Stderr, "Audit trail written to %s\n", output)
+}
+
+func cmdPriority(level string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdPriority,
+		Args:    map[string]string{"level": level},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdGPU(pct string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdGPU,
+		Args:    map[string]string{"percent": pct},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdOOM(score string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdOOM,
+		Args:    map[string]string{"score": score},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdBrightness(pct string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdBrightness,
+		Args:    map[string]string{"percent": pct},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdVolume(level string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdVolume,
+		Args:    map[string]string{"level": level},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdPenance() {
+	// Penance is interactive (stdin) so we handle it locally
+	// but validate + report result to daemon.
+	//
+	// NOTE: surveillance.Init() is only useful when running as root
+	// (it opens /dev/input/* devices).  When running as a non-root vex
+	// group member, skip it to avoid noisy "permission denied" warnings
+	// that obscure the penance interface.
+	interactive("penance")
+	if os.Geteuid() == 0 {
+		if err := surveillance.Init(); err != nil {
+			log.Printf("Surveillance initialization warning: %v", err)
+		}
+	}
+
+	m, err := penance.LoadManifest(penance.ManifestFile)
+	if err != nil {
+		die(exitFailure, "Failed to load penance manifest: %v", err)
+	}
+	penance.CurrentManifest = m // for the category penalties of failures
+	// A task assigned without a failure, e.g. by lockuntil, draws its
+	// topic now.
+	if topic, err := penance.AssignTopic(m); err != nil {
+		log.Printf("Topic assignment warning: %v", err)
+	} else if topic != "" {
+		vexlog.LogEvent("PENANCE", "TOPIC_ASSIGNED", fmt.Sprintf("topic=%q source=cli", topic))
+	}
+	topic := m.Active.RequiredContent.Topic
+	if cs, err := penance.LoadComplianceStatus(); err == nil {
+		topic = m.Topic(cs)
+	}
+
+	fmt.Println("\n========================================")
+	fmt.Printf("VEXATION PROTOCOL ACTIVE\n")
+	fmt.Printf("Subject: %s\n", m.Meta.TargetID)
+	fmt.Printf("Violation Level: %s\n", m.Active.Type)
+	fmt.Println("========================================")
+	fmt.Printf("INSTRUCTIONS:\n")
+	fmt.Printf("Topic: %s\n", topic)
+	fmt.Printf("Minimum Word Count: %d\n", m.Active.RequiredContent.MinWordCount)
+	if len(m.Active.RequiredContent.ValidationStrings) > 0 {
+		fmt.Printf("Must include phrases: %v\n", m.Active.RequiredContent.ValidationStrings)
+	}
+	if !m.Active.Constraints.AllowBackspace {
+		fmt.Println("WARNING: Backspace, Delete and paste are DISABLED. Errors require full line reset.")
+	}
+	if m.Active.Constraints.EnforceRhythm {
+		fmt.Printf("Typing speed: %d-%d KPM enforced\n",
+			m.Active.Constraints.MinKPM, m.Active.Constraints.MaxKPM)
+	}
+	fmt.Println("----------------------------------------")
+	fmt.Println("Type your submission below. Press Ctrl+D (EOF) when finished.")
+	fmt.Println("----------------------------------------")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	var sb strings.Builder
+	lineNum := 0
+	totalWords := 0
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !penance.ValidateLineInput(line, m.Active.Constraints) {
+			fmt.Println("[ERROR] Backspace detected! Line REJECTED. Retype the entire line.")
+			vexlog.LogEvent("PENANCE", "LINE_REJECTED", fmt.Sprintf("reason=backspace_violation line=%d", lineNum+1))
+			reportFailure("backspace_violation", lineNum+1)
+			continue
+		}
+		lineNum++
+		lineWords := len(strings.Fields(line))
+		totalWords += lineWords
+		sb.WriteString(line + "\n")
+
+		vexlog.LogEvent("PENANCE", "LINE_ACCEPTED", fmt.Sprintf("line=%d words=%d total_words=%d", lineNum, lineWords, totalWords))
+
+		// Send each accepted line to the daemon so it is registered in the
+		// daemon log and tracked over the socket.  The daemon answers with
+		// where the submission stands.
+		resp, err := client().Send(&ipc.Request{
+			Command: ipc.CmdPenanceInput,
+			Args:    map[string]string{"line": line, "num": strconv.Itoa(lineNum)},
+		})
+		if err != nil {
+			// Non-fatal: log locally but don't interrupt the session
+			vexlog.LogEvent("PENANCE", "IPC_WARN", fmt.Sprintf("could not reach daemon: %v", err))
+		} else if resp != nil && !resp.OK {
+			vexlog.LogEvent("PENANCE", "IPC_WARN", fmt.Sprintf("daemon rejected input: %s", resp.Error))
+		}
+
+		// Show the user that each line is registered, and where they stand
+		progress := &ipc.PenanceProgress{Words: totalWords, MinWords: m.Active.RequiredContent.MinWordCount}
+		if resp != nil && resp.Penance != nil {
+			progress = resp.Penance
+		}
+		printPenanceProgress(lineNum, lineWords, progress)
+
+		_ = penance.MarkInProgress()
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading input: %v", err)
+		return
+	}
+
+	submission := sb.String()
+	fmt.Println("\nVerifying submission...")
+	time.Sleep(1 * time.Second)
+
+	result := penance.ValidateSubmission(submission, m)
+	if !result.Valid {
+		for _, e := range result.Errors {
+			fmt.Printf("[FAIL] %s\n", e)
+		}
+		fmt.Println("\nSubmission REJECTED. Penance continues.")
+		reportFailure("submission_rejected", 0)
+		os.Exit(exitInvalid)
+	}
+
+	fmt.Println("\nSubmission ACCEPTED.")
+	if err := penance.RecordCompletion(); err == nil {
+		if cs, err := penance.LoadComplianceStatus(); err == nil {
+			vexlog.LogEvent("PENANCE", "COMPLETED", fmt.Sprintf("total_completed=%d locked=%v", cs.TotalCompleted, cs.Locked))
+		}
+	}
+
+	// Tell the daemon to lift restrictions
+	sendOrDie(&ipc.Request{Command: ipc.CmdUnlock})
+	fmt.Println("System state normalized. You may proceed.")
+}
+
+// printPenanceProgress shows the word count after a line, and the
+// phrases still missing and rhythm warnings when there are any.
+func printPenanceProgress(line, words int, p *ipc.PenanceProgress) {
+	fmt.Printf("  [line %d] %d words (total: %d/%d)\n", line, words, p.Words, p.MinWords)
+	if len(p.Missing) > 0 {
+		fmt.Printf("  still missing: %s\n", strings.Join(p.Missing, "; "))
+	}
+	for _, w := range p.Warnings {
+		fmt.Printf("  [WARN] %s\n", w)
+	}
+}
+
+// reportFailure records a penance failure and tells the daemon, which
+// passes it on to the keyholder.  line is the rejected line's number, or
+// 0 when the failure is not about one line.  An unreachable daemon is not
+// fatal.
+func reportFailure(reason string, line int) {
+	_ = penance.RecordFailure(reason)
+	args := map[string]string{"reason": reason}
+	if line > 0 {
+		args["line"] = strconv.Itoa(line)
+	}
+	if _, err := client().Send(&ipc.Request{
+		Command: ipc.CmdPenanceFailed,
+		Args:    args,
+	}); err != nil {
+		vexlog.LogEvent("PENANCE", "IPC_WARN", fmt.Sprintf("could not report failure to daemon: %v", err))
+	}
+}
+
+// cmdTypingTest runs a typing test whose input the daemon reads straight
+// from the keyboard.  The terminal is put in raw mode so typed keys don't
+// echo; stdin is only watched for Ctrl+C / Esc (abort) and Ctrl+D (finish
+// early).
+func cmdTypingTest(text string) {
+	interactive("typing-test")
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdTypingStart,
+		Args:    map[string]string{"text": text},
+	})
+	target := resp.Typing.Target
+
+	fmt.Println("\n========================================")
+	fmt.Println("TYPING TEST — input is read from the keyboard, not stdin")
+	fmt.Println("Esc/Ctrl+C aborts, Ctrl+D finishes early.")
+	fmt.Println("========================================")
+	fmt.Println(target)
+	fmt.Println("----------------------------------------")
+
+	restore := rawTerminal(int(os.Stdin.Fd()))
+	control := make(chan byte, 1)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			switch buf[0] {
+			case 0x03, 0x1b, 0x04:
+				control <- buf[0]
+				return
+			}
+		}
+	}()
+
+	abort := false
+	ticker := time.NewTicker(200 * time.Millisecond)
+loop:
+	for {
+		select {
+		case b := <-control:
+			abort = b != 0x04
+			break loop
+		case <-ticker.C:
+			st, err := client().Send(&ipc.Request{Command: ipc.CmdTypingStatus})
+			if err != nil || !st.OK {
+				break loop
+			}
+			t := st.Typing
+			fmt.Printf("\r\033[K%3d/%d chars | %5.1f KPM | %3.0f%% accurate",
+				len([]rune(t.Typed)), len([]rune(target)), t.KPM, t.Accuracy*100)
+			if t.Done {
+				break loop
+			}
+		}
+	}
+	ticker.Stop()
+	restore()
+	fmt.Println()
+
+	args := map[string]string{}
+	if abort {
+		args["abort"] = "true"
+	}
+	res := sendOrDie(&ipc.Request{Command: ipc.CmdTypingFinish, Args: args})
+	t := res.Typing
+	fmt.Printf("\nTyped:      %s\n", t.Typed)
+	fmt.Printf("Speed:      %.1f KPM\n", t.KPM)
+	fmt.Printf("Accuracy:   %.1f%%\n", t.Accuracy*100)
+	fmt.Printf("Backspaces: %d\n", t.Backspaces)
+	for _, e := range t.Errors {
+		fmt.Printf("[FAIL] %s\n", e)
+	}
+	fmt.Println(res.Message)
+	if !t.Passed {
+		os.Exit(exitInvalid)
+	}
+}
+
+// rawTerminal disables echo, line buffering and signal keys on fd and
+// returns a function restoring the previous settings.  It is a no-op when
+// fd is not a terminal.
+func rawTerminal(fd int) func() {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return func() {}
+	}
+	raw := *old
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return func() {}
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, old) }
+}
+
+func cmdBlockAdd(domain, period string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdBlockAdd,
+		Args:    withFor(map[string]string{"domain": domain}, period),
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdBlockRemove(domain string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdBlockRemove,
+		Args:    map[string]string{"domain": domain},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdBlockMonitor(domain, accesses string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdBlockMonitor,
+		Args:    map[string]string{"domain": domain, "allowed": accesses},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdBlockList() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdBlockList})
+	s := resp.State
+
+	fmt.Println(heading("[GUARDIAN — BLOCKED DOMAINS]"))
+	fmt.Printf("  Firewall Enabled: %v\n", s.Guardian.FirewallEnabled)
+	fmt.Printf("  Process Reaper:   %v\n", s.Guardian.ReaperEnabled)
+	fmt.Println()
+	if len(s.Guardian.BlockedDomains) == 0 {
+		fmt.Println(dim("  (no domains blocked)"))
+	} else {
+		for i, d := range s.Guardian.BlockedDomains {
+			fmt.Printf("  %d. %s\n", i+1, red(d))
+		}
+		fmt.Printf("\n  Total: %d entries\n", len(s.Guardian.BlockedDomains))
+	}
+	if len(s.Guardian.Monitored) > 0 {
+		fmt.Println()
+		fmt.Println("  Monitored (warn, then block for the day):")
+		for _, m := range s.Guardian.Monitored {
+			if m.Blocked {
+				fmt.Printf("    %s  %s\n", m.Domain, red("blocked until tomorrow"))
+			} else {
+				fmt.Printf("    %s  %d/%d accesses today\n", m.Domain, m.Accesses, m.Allowed)
+			}
+		}
+	}
+}
+
+// cmdBlockPass watches a block pass countdown, checking in with the
+// daemon every few seconds, and claims the pass when it runs out.
+func cmdBlockPass(domain string) {
+	interactive("block pass")
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdBlockPass,
+		Args:    map[string]string{"domain": domain},
+	})
+	pass := resp.Pass
+	readyAt, _ := time.Parse(time.RFC3339, pass.ReadyAt)
+	fmt.Printf("Countdown for %s started.  Keep watching; Ctrl+C abandons it.\n", pass.Domain)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastCheckIn := time.Now()
+	for now := range ticker.C {
+		left := time.Until(readyAt).Round(time.Second)
+		if left <= 0 {
+			break
+		}
+		fmt.Printf("\r\033[K%s: %d:%02d left", pass.Domain, left/time.Minute, left%time.Minute/time.Second)
+		if now.Sub(lastCheckIn) >= 5*time.Second {
+			r := send(&ipc.Request{
+				Command: ipc.CmdBlockPass,
+				Args:    map[string]string{"domain": pass.Domain, "id": pass.ID},
+			})
+			if !r.OK {
+				fmt.Println()
+				die(exitFor(r), "Countdown void: %s", r.Error)
+			}
+			lastCheckIn = now
+		}
+	}
+	fmt.Println()
+
+	resp = sendOrDie(&ipc.Request{
+		Command: ipc.CmdBlockPass,
+		Args:    map[string]string{"domain": pass.Domain, "id": pass.ID},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdResetScore() {
+	fmt.Println("Resetting failure score (authorized)…")
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdResetScore})
+	fmt.Println(resp.Message)
+}
+
+func cmdAppAdd(app string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdAppAdd,
+		Args:    map[string]string{"app": app},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdAppRemove(app string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdAppRemove,
+		Args:    map[string]string{"app": app},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdAppList() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdAppList})
+
+	fmt.Println(heading("[GUARDIAN — FORBIDDEN APPS]"))
+	if resp.Message == "" {
+		fmt.Println(dim("  (no forbidden apps)"))
+	} else {
+		apps := strings.Split(resp.Message, ",")
+		for i, a := range apps {
+			fmt.Printf("  %d. %s\n", i+1, red(a))
+		}
+		fmt.Printf("\n  Total: %d apps\n", len(apps))
+	}
+}
+
+// cmdUnlock lifts restrictions.  A signed deadline (RFC3339 time or a
+// duration such as 3h) makes the unlock temporary.
+func cmdUnlock(signed *security.SignedCommand) {
+	if signed.Command != "unlock" {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: payload was signed for '%s', not 'unlock'", signed.Command)
+	}
+	req := &ipc.Request{Command: ipc.CmdUnlock}
+	if signed.Args != "" {
+		req.Args = map[string]string{"until": signed.Args}
+	}
+	if !flagJSON {
+		fmt.Println("Lifting restrictions (authorized)…")
+	}
+	resp := sendOrDie(req)
+	fmt.Println(resp.Message)
+}
+
+// ── Curfew CLI commands ─────────────────────────────────────────────
+
+func cmdCurfewSet(start, end, days string, apps []string) {
+	args := map[string]string{"start": start, "end": end, "apps": strings.Join(apps, ",")}
+	if days != "" {
+		args["days"] = days
+	}
+
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCurfewSet, Args: args})
+	fmt.Println(resp.Message)
+}
+
+func cmdCurfewStatus() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCurfew})
+	if !resp.State.Curfew.Enabled {
+		fmt.Println("No curfew set.")
+		return
+	}
+	printCurfew(resp.State.Curfew)
+}
+
+func printCurfew(c state.CurfewState) {
+	w := scheduler.Window{Start: c.Start, End: c.End, Days: c.Days}
+	now := time.Now()
+	fmt.Printf("  Window:   %s\n", w)
+	if len(c.Apps) > 0 {
+		fmt.Printf("  Apps:     %s\n", strings.Join(c.Apps, ", "))
+	}
+	if c.Active {
+		fmt.Printf("  Status:   IN EFFECT until %s\n", w.EndAfter(now).Format("Mon 15:04"))
+		return
+	}
+	if skip, err := time.Parse(time.RFC3339, c.SkipUntil); err == nil && now.Before(skip) {
+		fmt.Printf("  Status:   overridden until %s\n", skip.Local().Format("Mon 15:04"))
+		return
+	}
+	fmt.Printf("  Status:   next at %s\n", w.StartAfter(now).Format("Mon 15:04"))
+}
+
+// cmdCurfewOverride sends the override.  The mode comes from the signed
+// payload's args ("" or "tonight", or "off") so it cannot be altered
+// without a new signature.
+func cmdCurfewOverride(signed *security.SignedCommand) {
+	if signed.Command != "curfew-override" {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: payload was signed for '%s', not 'curfew-override'", signed.Command)
+	}
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdCurfewOverride,
+		Args:    map[string]string{"mode": signed.Args},
+	})
+	fmt.Println(resp.Message)
+}
+
+// ── Allowance CLI commands ──────────────────────────────────────────
+
+func cmdAllowAdd(name, start, end, days string, domains, apps []string) {
+	args := map[string]string{
+		"name":    name,
+		"start":   start,
+		"end":     end,
+		"domains": strings.Join(domains, ","),
+		"apps":    strings.Join(apps, ","),
+	}
+	if days != "" {
+		args["days"] = days
+	}
+
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdAllowAdd, Args: args})
+	fmt.Println(resp.Message)
+}
+
+func cmdAllowRemove(name string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdAllowRemove,
+		Args:    map[string]string{"name": name},
+	})
+	fmt.Println(resp.Message)
+}
+
+// cmdScheduleAdd schedules a command.  The daemon verifies payload, which
+// it needs for a command that can lower restrictions.
+func cmdScheduleAdd(when string, command []string, payload string) {
+	args := map[string]string{"at": when, "command": strings.Join(command, " ")}
+	if payload != "" {
+		args["payload"] = payload
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdScheduleAdd, Args: args})
+	fmt.Println(resp.Message)
+}
+
+func cmdScheduleRemove(id string) {
+	resp := sendOrDie(&ipc.Request{
+		Command: ipc.CmdScheduleRemove,
+		Args:    map[string]string{"id": strings.TrimPrefix(id, "#")},
+	})
+	fmt.Println(resp.Message)
+}
+
+func cmdScheduleList() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdScheduleList})
+	if len(resp.State.Schedules) == 0 {
+		fmt.Println("No scheduled commands.")
+		return
+	}
+	fmt.Printf("%-4s %-20s %-34s %s\n", "ID", "WHEN", "NEXT RUN", "COMMAND")
+	for _, sc := range resp.State.Schedules {
+		when := scheduler.Daily{At: sc.At, Days: sc.Days}
+		next := "-"
+		if t, err := time.Parse(time.RFC3339, sc.NextRun); err == nil {
+			next = fmt.Sprintf("%s (in %s)", t.Local().Format("Mon 2006-01-02 15:04"), fmtCountdown(time.Until(t)))
+		}
+		fmt.Printf("%-4d %-20s %-34s %s\n", sc.ID, when, next, sc.Command)
+	}
+}
+
+func cmdAllowList() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdAllowList})
+	if len(resp.State.Allowances) == 0 {
+		fmt.Println("No allowances defined.")
+		return
+	}
+	for _, a := range resp.State.Allowances {
+		w := scheduler.Window{Start: a.Start, End: a.End, Days: a.Days}
+		status := "closed"
+		if a.Active {
+			status = "OPEN"
+		}
+		fmt.Printf("%s  %s  [%s]\n", a.Name, w, status)
+		if len(a.Domains) > 0 {
+			fmt.Printf("  domains: %s\n", strings.Join(a.Domains, ", "))
+		}
+		if len(a.Apps) > 0 {
+			fmt.Printf("  apps:    %s\n", strings.Join(a.Apps, ", "))
+		}
 	}
-
-	fmt.Println()
-	fmt.Printf("State last updated: %s (by: %s)\n", s.LastUpdated, s.ChangedBy)
-	fmt.Println("========================================")
 }
 
-func cmdThrottle(profile string) {
+func cmdLockUntil(deadline string) {
 	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdThrottle,
-		Args:    map[string]string{"profile": profile},
+		Command: ipc.CmdLockUntil,
+		Args:    map[string]string{"until": deadline},
 	})
 	fmt.Println(resp.Message)
 }
 
-func cmdCPU(pct string) {
-	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdCPU,
-		Args:    map[string]string{"percent": pct},
-	})
+func cmdEarlyRelease() {
+	if !flagJSON {
+		fmt.Println("Releasing lock early (authorized)…")
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdEarlyRelease})
 	fmt.Println(resp.Message)
 }
 
-func cmdLatency(ms string) {
-	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdLatency,
-		Args:    map[string]string{"ms": ms},
-	})
-	fmt.Println(resp.Message)
-}
+func cmdCalendar() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCalendar})
+	c := resp.State.Calendar
 
-func cmdOOM(score string) {
-	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdOOM,
-		Args:    map[string]string{"score": score},
-	})
-	fmt.Println(resp.Message)
-}
+	if c.LastFetch != "" {
+		fmt.Printf("Last fetch: %s\n", fmtLocal(c.LastFetch))
+	} else {
+		fmt.Println("Last fetch: never (using cached feed, if any)")
+	}
+	if c.LastError != "" {
+		fmt.Printf("Last error: %s\n", c.LastError)
+	}
+	if len(c.Presets) > 0 {
+		fmt.Printf("In force:   %s\n", strings.Join(c.Presets, ", "))
+	} else {
+		fmt.Println("In force:   none")
+	}
 
-func cmdPenance() {
-	// Penance is interactive (stdin) so we handle it locally
-	// but validate + report result to daemon.
-	//
-	// NOTE: surveillance.Init() is only useful when running as root
-	// (it opens /dev/input/* devices).  When running as a non-root vex
-	// group member, skip it to avoid noisy "permission denied" warnings
-	// that obscure the penance interface.
-	if os.Geteuid() == 0 {
-		if err := surveillance.Init(); err != nil {
-			log.Printf("Surveillance initialization warning: %v", err)
-		}
+	fmt.Println()
+	if len(resp.Events) == 0 {
+		fmt.Println("No mapped events in the next 7 days.")
+		return
+	}
+	fmt.Println("Next 7 days:")
+	for _, e := range resp.Events {
+		fmt.Printf("  %s - %s  %-12s %s\n", fmtLocal(e.Start), fmtLocal(e.End), e.Preset, e.Summary)
 	}
+}
 
-	m, err := penance.LoadManifest(penance.ManifestFile)
+// fmtLocal renders an RFC3339 timestamp in local time.
+func fmtLocal(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
 	if err != nil {
-		log.Fatalf("Failed to load penance manifest: %v", err)
+		return ts
 	}
+	return t.Local().Format("Mon 01-02 15:04")
+}
 
-	fmt.Println("\n========================================")
-	fmt.Printf("VEXATION PROTOCOL ACTIVE\n")
-	fmt.Printf("Subject: %s\n", m.Meta.TargetID)
-	fmt.Printf("Violation Level: %s\n", m.Active.Type)
-	fmt.Println("========================================")
-	fmt.Printf("INSTRUCTIONS:\n")
-	fmt.Printf("Topic: %s\n", m.Active.RequiredContent.Topic)
-	fmt.Printf("Minimum Word Count: %d\n", m.Active.RequiredContent.MinWordCount)
-	if len(m.Active.RequiredContent.ValidationStrings) > 0 {
-		fmt.Printf("Must include phrases: %v\n", m.Active.RequiredContent.ValidationStrings)
-	}
-	if !m.Active.Constraints.AllowBackspace {
-		fmt.Println("WARNING: Backspace is DISABLED. Errors require full line reset.")
+// cmdPause sends a vacation pause.  The deadline comes from the signed
+// payload's args (a duration such as 168h, or an RFC3339 time).
+func cmdRequestException(target, period, reason string) {
+	if period == "" {
+		die(exitInvalid, "An exception needs --for <duration> (e.g. 30m, 1h)")
 	}
-	if m.Active.Constraints.EnforceRhythm {
-		fmt.Printf("Typing speed: %d-%d KPM enforced\n",
-			m.Active.Constraints.MinKPM, m.Active.Constraints.MaxKPM)
+	args := map[string]string{"target": target, "for": period}
+	if reason != "" {
+		args["reason"] = reason
 	}
-	fmt.Println("----------------------------------------")
-	fmt.Println("Type your submission below. Press Ctrl+D (EOF) when finished.")
-	fmt.Println("----------------------------------------")
 
-	scanner := bufio.NewScanner(os.Stdin)
-	var sb strings.Builder
-	lineNum := 0
-	totalWords := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !penance.ValidateLineInput(line, m.Active.Constraints) {
-			fmt.Println("[ERROR] Backspace detected! Line REJECTED. Retype the entire line.")
-			vexlog.LogEvent("PENANCE", "LINE_REJECTED", fmt.Sprintf("reason=backspace_violation line=%d", lineNum+1))
-			_ = penance.RecordFailure("backspace_violation")
-			continue
-		}
-		lineNum++
-		lineWords := len(strings.Fields(line))
-		totalWords += lineWords
-		sb.WriteString(line + "\n")
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdExceptionRequest, Args: args})
+	fmt.Println(resp.Message)
+	id := resp.State.Exceptions[len(resp.State.Exceptions)-1].ID
+	fmt.Printf("The keyholder approves with a payload signed for 'approve' with args %q.\n", id)
+}
 
-		// Show the user that each line is registered
-		fmt.Printf("  [line %d] %d words (total: %d/%d)\n",
-			lineNum, lineWords, totalWords, m.Active.RequiredContent.MinWordCount)
+func cmdRequestList() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdExceptionList})
+	if len(resp.State.Exceptions) == 0 {
+		fmt.Println("No pending exception requests.")
+		return
+	}
+	for _, r := range resp.State.Exceptions {
+		printException(r)
+	}
+}
 
-		vexlog.LogEvent("PENANCE", "LINE_ACCEPTED", fmt.Sprintf("line=%d words=%d total_words=%d", lineNum, lineWords, totalWords))
+func printException(r state.ExceptionRequest) {
+	fmt.Printf("  %s  %s %s for %s, requested %s\n", r.ID, r.Kind, r.Target, r.For, fmtLocal(r.Requested))
+	if r.Reason != "" {
+		fmt.Printf("            reason: %s\n", r.Reason)
+	}
+	if r.AutoApprove != "" {
+		fmt.Printf("            auto-approves %s\n", fmtLocal(r.AutoApprove))
+	}
+}
 
-		// Send each accepted line to the daemon so it is registered in the
-		// daemon log and tracked over the socket.
-		resp, err := client().Send(&ipc.Request{
-			Command: ipc.CmdPenanceInput,
-			Args:    map[string]string{"line": line, "num": strconv.Itoa(lineNum)},
-		})
-		if err != nil {
-			// Non-fatal: log locally but don't interrupt the session
-			vexlog.LogEvent("PENANCE", "IPC_WARN", fmt.Sprintf("could not reach daemon: %v", err))
-		} else if resp != nil && !resp.OK {
-			vexlog.LogEvent("PENANCE", "IPC_WARN", fmt.Sprintf("daemon rejected input: %s", resp.Error))
-		}
+func cmdRequestCancel(id string) {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdExceptionCancel, Args: map[string]string{"id": id}})
+	fmt.Println(resp.Message)
+}
 
-		_ = penance.MarkInProgress()
+// cmdCredits prints the balance and the rewards on offer.
+func cmdCredits() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCredits})
+	c := resp.State.Credits
+	fmt.Printf("Balance: %d credits\n", c.Balance)
+	if c.LastDay != "" {
+		fmt.Printf("Last credited: %s (+%d)\n", c.LastDay, c.Earned)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading input: %v", err)
+
+	fmt.Println()
+	if len(resp.Rewards) == 0 {
+		fmt.Println("No rewards on offer.")
 		return
 	}
-
-	submission := sb.String()
-	fmt.Println("\nVerifying submission...")
-	time.Sleep(1 * time.Second)
-
-	result := penance.ValidateSubmission(submission, m)
-	if !result.Valid {
-		for _, e := range result.Errors {
-			fmt.Printf("[FAIL] %s\n", e)
+	fmt.Println("Rewards:")
+	for _, r := range resp.Rewards {
+		var what string
+		switch r.Kind {
+		case "unblock":
+			what = fmt.Sprintf("unblock for %dm", r.Minutes)
+			if len(r.Targets) > 0 {
+				what += ": " + strings.Join(r.Targets, ", ")
+			}
+		case "relax":
+			what = fmt.Sprintf("%s network for %dm", r.Profile, r.Minutes)
+		case "lines":
+			what = fmt.Sprintf("%d fewer lines", r.Lines)
 		}
-		fmt.Println("\nSubmission REJECTED. Penance continues.")
-		_ = penance.RecordFailure("submission_rejected")
-		os.Exit(1)
+		mark := " "
+		if r.Cost <= c.Balance {
+			mark = "*"
+		}
+		fmt.Printf(" %s %-16s %4d  %s\n", mark, r.Name, r.Cost, what)
 	}
+	fmt.Println("\n* affordable now")
+}
 
-	fmt.Println("\nSubmission ACCEPTED.")
-	_ = penance.RecordCompletion()
-
-	// Tell the daemon to lift restrictions
-	sendOrDie(&ipc.Request{Command: ipc.CmdUnlock})
-	fmt.Println("System state normalized. You may proceed.")
+func cmdCreditsSpend(args []string) {
+	req := &ipc.Request{Command: ipc.CmdCreditsSpend, Args: map[string]string{"reward": args[0]}}
+	if len(args) > 1 {
+		req.Args["target"] = args[1]
+	}
+	resp := sendOrDie(req)
+	fmt.Println(resp.Message)
 }
 
-func cmdBlockAdd(domain string) {
+// cmdApprove applies a pending exception request.  The request ID comes
+// from the signed payload.
+func cmdApprove(signed *security.SignedCommand) {
+	if signed.Command != "approve" {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: payload was signed for '%s', not 'approve'", signed.Command)
+	}
 	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdBlockAdd,
-		Args:    map[string]string{"domain": domain},
+		Command: ipc.CmdApprove,
+		Args:    map[string]string{"id": signed.Args},
 	})
 	fmt.Println(resp.Message)
 }
 
-func cmdBlockRemove(domain string) {
+func cmdPause(signed *security.SignedCommand) {
+	if signed.Command != "pause" {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: payload was signed for '%s', not 'pause'", signed.Command)
+	}
 	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdBlockRemove,
-		Args:    map[string]string{"domain": domain},
+		Command: ipc.CmdPause,
+		Args:    map[string]string{"until": signed.Args},
 	})
 	fmt.Println(resp.Message)
 }
 
-func cmdBlockList() {
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdBlockList})
-	s := resp.State
+func cmdResume() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdResume})
+	fmt.Println(resp.Message)
+}
 
-	fmt.Println("[GUARDIAN — BLOCKED DOMAINS]")
-	fmt.Printf("  Firewall Enabled: %v\n", s.Guardian.FirewallEnabled)
-	fmt.Printf("  Process Reaper:   %v\n", s.Guardian.ReaperEnabled)
-	fmt.Println()
-	if len(s.Guardian.BlockedDomains) == 0 {
-		fmt.Println("  (no domains blocked)")
-	} else {
-		for i, d := range s.Guardian.BlockedDomains {
-			fmt.Printf("  %d. %s\n", i+1, d)
-		}
-		fmt.Printf("\n  Total: %d domains\n", len(s.Guardian.BlockedDomains))
-	}
+func cmdCheck() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCheck})
+	fmt.Println(resp.Message)
 }
 
-func cmdResetScore() {
-	fmt.Println("Resetting failure score (authorized)…")
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdResetScore})
+func cmdReload() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdReload})
 	fmt.Println(resp.Message)
 }
 
-func cmdAppAdd(app string) {
-	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdAppAdd,
-		Args:    map[string]string{"app": app},
-	})
+// cmdConfig prints the settings in effect, or the one called name.
+func cmdConfig(name string) {
+	req := &ipc.Request{Command: ipc.CmdConfig}
+	if name != "" {
+		req.Args = map[string]string{"name": name}
+	}
+	resp := sendOrDie(req)
 	fmt.Println(resp.Message)
 }
 
-func cmdAppRemove(app string) {
+// cmdConfigSet changes one setting.  The name=value comes from the signed
+// payload.
+func cmdConfigSet(signed *security.SignedCommand) {
+	if signed.Command != "config-set" {
+		die(exitUnauthorized, "AUTHORIZATION DENIED: payload was signed for '%s', not 'config-set'", signed.Command)
+	}
+	name, value, ok := strings.Cut(signed.Args, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		die(exitInvalid, "The signed args must be name=value, e.g. guardian.dns_refresh_minutes=15")
+	}
 	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdAppRemove,
-		Args:    map[string]string{"app": app},
+		Command: ipc.CmdConfigSet,
+		Args:    map[string]string{"name": strings.TrimSpace(name), "value": strings.TrimSpace(value)},
 	})
 	fmt.Println(resp.Message)
 }
 
-func cmdAppList() {
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdAppList})
-
-	fmt.Println("[GUARDIAN — FORBIDDEN APPS]")
-	if resp.Message == "" {
-		fmt.Println("  (no forbidden apps)")
+func cmdVersion() {
+	own := buildinfo.Get()
+	resp, err := client().Send(&ipc.Request{Command: ipc.CmdVersion})
+	if flagJSON {
+		out := map[string]any{"vex-cli": own}
+		if err == nil {
+			out["vexd"] = resp.Build
+		}
+		data, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(data))
 	} else {
-		apps := strings.Split(resp.Message, ",")
-		for i, a := range apps {
-			fmt.Printf("  %d. %s\n", i+1, a)
+		fmt.Println("vex-cli", own)
+		if err == nil && resp.Build != nil {
+			fmt.Println("vexd   ", resp.Build)
+		}
+	}
+	switch {
+	case err != nil:
+		die(exitUnreachable, "Failed to communicate with vexd: %v", err)
+	case resp.Build == nil:
+		die(exitFailure, "vexd predates the version command; rebuild and restart it")
+	case resp.Build.ID() != own.ID():
+		die(exitFailure, "vex-cli and vexd were built from different source; rebuild and restart both")
+	}
+}
+
+func cmdHealth() {
+	if flagJSON {
+		resp := send(&ipc.Request{Command: ipc.CmdHealth})
+		printJSON(resp)
+		os.Exit(healthExit(resp))
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdHealth})
+	width := len("WORKER")
+	for _, w := range resp.Health {
+		width = max(width, len(w.Name))
+	}
+	fmt.Printf("%-*s  %-10s  %-9s  %-8s  %s\n", width, "WORKER", "STATE", "FOR", "RESTARTS", "LAST ERROR")
+	for _, w := range resp.Health {
+		since := "-"
+		if t, err := time.Parse(time.RFC3339, w.Since); err == nil {
+			since = fmtCountdown(time.Since(t))
+		}
+		lastErr := "-"
+		if w.LastError != "" {
+			lastErr = w.LastError
+			if t, err := time.Parse(time.RFC3339, w.LastFailure); err == nil {
+				lastErr = fmt.Sprintf("%s (%s ago)", w.LastError, fmtCountdown(time.Since(t)))
+			}
+		}
+		fmt.Printf("%-*s  %-10s  %-9s  %-8d  %s\n", width, w.Name, w.State, since, w.Restarts, lastErr)
+	}
+	os.Exit(healthExit(resp))
+}
+
+func cmdSessions() {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdSessions})
+	fmt.Printf("%-9s  %-7s  %-5s  %-15s  %-8s  %s\n", "ID", "PID", "UID", "OPENED", "REQUESTS", "EXECUTABLE")
+	for _, sess := range resp.Sessions {
+		id := sess.ID
+		if sess.Revoked {
+			id += "*"
+		}
+		fmt.Printf("%-9s  %-7d  %-5d  %-15s  %-8d  %s\n", id, sess.PID, sess.UID, fmtLocal(sess.Opened), sess.Requests, sess.Exe)
+	}
+	if slices.ContainsFunc(resp.Sessions, func(s ipc.Session) bool { return s.Revoked }) {
+		fmt.Println("* revoked")
+	}
+	if resp.State != nil && len(resp.State.Barred) > 0 {
+		fmt.Println("\nBarred executables (sha256):")
+		for _, hash := range resp.State.Barred {
+			fmt.Printf("  %s\n", hash)
 		}
-		fmt.Printf("\n  Total: %d apps\n", len(apps))
 	}
 }
 
-func cmdUnlock() {
-	fmt.Println("Lifting restrictions (authorized)…")
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdUnlock})
+// cmdSessionRevoke ends a session; with exe it also bars the session's
+// executable.
+func cmdSessionRevoke(id string, exe bool) {
+	args := map[string]string{"id": id}
+	if exe {
+		args["exe"] = "true"
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdSessionRevoke, Args: args})
 	fmt.Println(resp.Message)
 }
 
-func cmdCheck() {
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdCheck})
+func cmdSessionUnbar(hash string) {
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdSessionUnbar, Args: map[string]string{"hash": hash}})
+	fmt.Println(resp.Message)
+}
+
+func cmdAuditVerify(date string) {
+	req := &ipc.Request{Command: ipc.CmdAuditVerify, Args: map[string]string{"date": date}}
+	if flagJSON {
+		resp := send(req)
+		printJSON(resp)
+		os.Exit(verifyExit(resp))
+	}
+	resp := sendOrDie(req)
+	for _, c := range resp.Checkpoints {
+		status := "ok"
+		if c.Error != "" {
+			status = "FAILED: " + c.Error
+		}
+		fmt.Printf("%s  %6d entries  %s…  %s\n", c.Date, c.Entries, c.Digest[:min(12, len(c.Digest))], status)
+	}
 	fmt.Println(resp.Message)
+	os.Exit(verifyExit(resp))
+}
+
+// verifyExit fails audit verify when any checkpoint did not verify.
+func verifyExit(resp *ipc.Response) int {
+	if !resp.OK {
+		return exitFor(resp)
+	}
+	for _, c := range resp.Checkpoints {
+		if c.Error != "" {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// healthExit lets monitoring scripts notice a failing worker without
+// parsing the table.
+func healthExit(resp *ipc.Response) int {
+	if !resp.OK {
+		return exitFor(resp)
+	}
+	for _, w := range resp.Health {
+		if w.State == "restarting" {
+			return exitFailure
+		}
+	}
+	return exitOK
 }
 
 func getComplianceState() string {
@@ -516,15 +2462,83 @@ func getComplianceState() string {
 // ── Writing-lines CLI commands ──────────────────────────────────────
 
 func cmdLinesSet(countStr, phrase string) {
-	resp := sendOrDie(&ipc.Request{
-		Command: ipc.CmdLinesSet,
-		Args:    map[string]string{"phrase": phrase, "count": countStr},
-	})
+	args := map[string]string{"phrase": phrase, "count": countStr}
+	if flagMinSeconds != 0 {
+		args["min_seconds"] = strconv.Itoa(flagMinSeconds)
+	}
+	if flagMaxSeconds != 0 {
+		args["max_seconds"] = strconv.Itoa(flagMaxSeconds)
+	}
+	if flagPriority != 0 {
+		args["priority"] = strconv.Itoa(flagPriority)
+	}
+	if flagLive {
+		args["live"] = "true"
+	}
+	if flagMinKPM != 0 {
+		args["min_kpm"] = strconv.Itoa(flagMinKPM)
+	}
+	if flagMaxKPM != 0 {
+		args["max_kpm"] = strconv.Itoa(flagMaxKPM)
+	}
+	if len(flagClauses) > 0 {
+		args["clauses"] = strings.Join(flagClauses, "\n")
+	}
+	if flagStepEvery != 0 {
+		args["step_every"] = strconv.Itoa(flagStepEvery)
+	}
+	if flagPaceStep != 0 {
+		args["pace_step"] = strconv.Itoa(flagPaceStep)
+	}
+	if flagAbandon != "" {
+		args["abandon_after"] = flagAbandon
+	}
+	if flagOnAbandon != "" {
+		args["on_abandon"] = flagOnAbandon
+	}
+	if flagBump != 0 {
+		args["abandon_bump"] = strconv.Itoa(flagBump)
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdLinesSet, Args: args})
 	fmt.Println(resp.Message)
 }
 
-func cmdLinesClear() {
-	resp := sendOrDie(&ipc.Request{Command: ipc.CmdLinesClear})
+// linePace describes a task's per-line time limits and live typing, ""
+// if it has neither.
+func linePace(w state.WritingTask) string {
+	var parts []string
+	switch {
+	case w.MinSeconds > 0 && w.MaxSeconds > 0:
+		parts = append(parts, fmt.Sprintf("%d-%ds per line", w.MinSeconds, w.MaxSeconds))
+	case w.MinSeconds > 0:
+		parts = append(parts, fmt.Sprintf("at least %ds per line", w.MinSeconds))
+	case w.MaxSeconds > 0:
+		parts = append(parts, fmt.Sprintf("at most %ds per line", w.MaxSeconds))
+	}
+	if w.Live {
+		live := "live typing"
+		switch {
+		case w.MinKPM > 0 && w.MaxKPM > 0:
+			live += fmt.Sprintf(" at %d-%d KPM", w.MinKPM, w.MaxKPM)
+		case w.MinKPM > 0:
+			live += fmt.Sprintf(" at %d KPM or more", w.MinKPM)
+		case w.MaxKPM > 0:
+			live += fmt.Sprintf(" at up to %d KPM", w.MaxKPM)
+		}
+		parts = append(parts, live)
+	}
+	if w.StepEvery > 0 && (len(w.Clauses) > 0 || w.PaceStep > 0) {
+		parts = append(parts, fmt.Sprintf("harder every %d lines", w.StepEvery))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func cmdLinesClear(all bool) {
+	args := map[string]string{}
+	if all {
+		args["all"] = "true"
+	}
+	resp := sendOrDie(&ipc.Request{Command: ipc.CmdLinesClear, Args: args})
 	fmt.Println(resp.Message)
 }
 
@@ -538,13 +2552,43 @@ func cmdLinesStatus() {
 	}
 
 	remaining := s.Writing.Required - s.Writing.Completed
-	fmt.Println("[WRITING TASK]")
+	fmt.Println(heading("[WRITING TASK]"))
 	fmt.Printf("  Phrase:    %q\n", s.Writing.Phrase)
-	fmt.Printf("  Progress:  %d / %d\n", s.Writing.Completed, s.Writing.Required)
+	fmt.Printf("  Progress:  %s\n", progressBar(s.Writing.Completed, s.Writing.Required, 30))
 	fmt.Printf("  Remaining: %d\n", remaining)
+	if pace := linePace(s.Writing); pace != "" {
+		fmt.Printf("  Pace:      %s\n", pace)
+	}
+	if w := s.Writing; w.AbandonMins > 0 {
+		fmt.Printf("  Abandon:   %s after %s without a line\n", strings.Join(w.OnAbandon, ", "), time.Duration(w.AbandonMins)*time.Minute)
+	}
+	if len(s.LinesQueue) > 0 {
+		fmt.Println()
+		fmt.Println(heading("[QUEUED]"))
+		for i, t := range s.LinesQueue {
+			fmt.Printf("  %d. %q x %d", i+1, t.Phrase, t.Required)
+			if t.Completed > 0 {
+				fmt.Printf(", %d done", t.Completed)
+			}
+			if t.Priority != 0 {
+				fmt.Printf(", priority %d", t.Priority)
+			}
+			if pace := linePace(t); pace != "" {
+				fmt.Printf(", %s", pace)
+			}
+			fmt.Println()
+		}
+	}
 }
 
+// cmdLinesSubmitInteractive reads lines from the terminal and submits
+// them one by one.  Pasted lines are rejected here, never submitted, and
+// reported to the daemon.
 func cmdLinesSubmitInteractive() {
+	interactive("lines submit")
+	if !isTerminal(int(os.Stdin.Fd())) {
+		die(exitInvalid, "lines submit must be typed at a terminal; piped input is not accepted")
+	}
 	// First, check if there's an active task
 	statusResp := sendOrDie(&ipc.Request{Command: ipc.CmdLinesStatus})
 	s := statusResp.State
@@ -559,41 +2603,90 @@ func cmdLinesSubmitInteractive() {
 	fmt.Println("========================================")
 	fmt.Printf("Phrase:    %q\n", s.Writing.Phrase)
 	fmt.Printf("Remaining: %d lines\n", remaining)
+	if pace := linePace(s.Writing); pace != "" {
+		fmt.Printf("Pace:      %s, timed by the daemon\n", pace)
+	}
 	fmt.Println("----------------------------------------")
 	fmt.Println("Type the exact phrase on each line. Ctrl+D to stop.")
+	fmt.Println("Pasted lines are rejected and reported.")
 	fmt.Println("----------------------------------------")
 
-	scanner := bufio.NewScanner(os.Stdin)
+	in, restore := rawLineReader()
+	phrase, done, total := s.Writing.Phrase, s.Writing.Completed, s.Writing.Required
 	accepted := 0
 	rejected := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		resp, err := client().Send(&ipc.Request{
-			Command: ipc.CmdLinesSubmit,
-			Args:    map[string]string{"line": line},
-		})
+	start := time.Now()
+	fmt.Println(linesProgress(done, total, accepted, rejected, 0, start))
+	for {
+		lineStart := time.Now()
+		line, pasted, err := in.readLine()
 		if err != nil {
-			log.Fatalf("Failed to communicate with vexd: %v", err)
-		}
-		if resp.OK {
-			accepted++
-			fmt.Printf("  ✓ %s\n", resp.Message)
-			// Check if task is now complete
-			if resp.State != nil && !resp.State.Writing.Active {
-				fmt.Println("\n" + resp.Message)
-				break
+			if err != io.EOF {
+				log.Printf("Error reading input: %v", err)
 			}
-		} else {
+			break
+		}
+		took := time.Since(lineStart).Round(100 * time.Millisecond)
+		if pasted {
 			rejected++
-			fmt.Printf("  ✗ REJECTED: %s\n", resp.Error)
+			fmt.Printf("  ✗ REJECTED: pasted; type the line yourself (%s)\n", took)
+			reportLineRejected("pasted")
+		} else {
+			resp, err := client().Send(&ipc.Request{
+				Command: ipc.CmdLinesSubmit,
+				Args:    map[string]string{"line": line},
+			})
+			if err != nil {
+				restore()
+				die(exitUnreachable, "Failed to communicate with vexd: %v", err)
+			}
+			if resp.OK {
+				accepted++
+				fmt.Printf("  ✓ %s (%s)\n", resp.Message, took)
+				// Check if task is now complete
+				if resp.State != nil && !resp.State.Writing.Active {
+					fmt.Println("\n" + resp.Message)
+					break
+				}
+				if resp.State != nil {
+					if w := resp.State.Writing; w.Phrase != phrase {
+						// The phrase grew, or the task was done and a
+						// queued one took over.
+						phrase = w.Phrase
+						fmt.Println("----------------------------------------")
+						fmt.Printf("Next phrase: %q\n", phrase)
+						if pace := linePace(w); pace != "" {
+							fmt.Printf("Pace:        %s, timed by the daemon\n", pace)
+						}
+						fmt.Println("----------------------------------------")
+					}
+					done, total = resp.State.Writing.Completed, resp.State.Writing.Required
+				}
+			} else {
+				rejected++
+				fmt.Printf("  ✗ REJECTED: %s (%s)\n", resp.Error, took)
+			}
 		}
+		fmt.Println(linesProgress(done, total, accepted, rejected, time.Since(start), time.Now()))
 	}
+	restore()
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading input: %v", err)
-	}
+	fmt.Printf("\nSession: %d accepted, %d rejected in %s\n",
+		accepted, rejected, time.Since(start).Round(time.Second))
+}
 
-	fmt.Printf("\nSession: %d accepted, %d rejected\n", accepted, rejected)
+// reportLineRejected tells the daemon about a line rejected without being
+// submitted.  An unreachable daemon is not fatal.
+func reportLineRejected(reason string) {
+	resp, err := client().Send(&ipc.Request{
+		Command: ipc.CmdLinesRejected,
+		Args:    map[string]string{"reason": reason},
+	})
+	if err != nil {
+		vexlog.LogEvent("WRITING", "IPC_WARN", fmt.Sprintf("could not report rejected line to daemon: %v", err))
+	} else if !resp.OK {
+		vexlog.LogEvent("WRITING", "IPC_WARN", fmt.Sprintf("daemon refused rejected line: %s", resp.Error))
+	}
 }
 
 // canAccessVex checks if the current user has permission to run vex-cli.
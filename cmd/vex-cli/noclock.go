@@ -0,0 +1,7 @@
+//go:build !vextest
+
+package main
+
+// clockCommand is nil in production builds: their vexd has no --simulate
+// and so no virtual clock (clock.go).
+func clockCommand() *command { return nil }
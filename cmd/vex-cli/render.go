@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// ANSI SGR codes.  Only the terminal's own 16-colour palette is used, never
+// fixed RGB values, so the output follows whatever light or dark theme
+// the terminal has.
+const (
+	sgrBold   = "1"
+	sgrDim    = "2"
+	sgrRed    = "31"
+	sgrGreen  = "32"
+	sgrYellow = "33"
+)
+
+// colorEnabled reports whether output is coloured: stdout must be a
+// terminal, and neither NO_COLOR (https://no-color.org) nor --no-color
+// may be set.  It is decided once, after the flags are parsed.
+var colorEnabled = sync.OnceValue(func() bool {
+	if flagNoColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(int(os.Stdout.Fd()))
+})
+
+// paint wraps s in the given SGR codes when colour is enabled.
+func paint(s string, codes ...string) string {
+	if !colorEnabled() || s == "" {
+		return s
+	}
+	return "\033[" + strings.Join(codes, ";") + "m" + s + "\033[0m"
+}
+
+func bold(s string) string   { return paint(s, sgrBold) }
+func dim(s string) string    { return paint(s, sgrDim) }
+func red(s string) string    { return paint(s, sgrRed) }
+func green(s string) string  { return paint(s, sgrGreen) }
+func yellow(s string) string { return paint(s, sgrYellow) }
+
+// heading renders a section title such as "[COMPLIANCE]".
+func heading(s string) string { return bold(s) }
+
+// lockState renders the lock: red when locked, green when not.
+func lockState(locked bool) string {
+	if locked {
+		return paint("LOCKED", sgrBold, sgrRed)
+	}
+	return green("unlocked")
+}
+
+// score renders a failure score, red once it is above zero.
+func score(n int) string {
+	if n > 0 {
+		return red(fmt.Sprint(n))
+	}
+	return green("0")
+}
+
+// profile renders a network profile, green only when unrestricted.
+func profile(p string) string {
+	if p == "standard" {
+		return green(p)
+	}
+	return yellow(p)
+}
+
+// progressBar renders done out of total as a bar width cells wide
+// followed by the counts, e.g. "[██████░░░░░░] 42/200 21%".
+func progressBar(done, total, width int) string {
+	if total <= 0 {
+		return ""
+	}
+	done = max(0, min(done, total))
+	filled := done * width / total
+	bar := green(strings.Repeat("█", filled)) + dim(strings.Repeat("░", width-filled))
+	return fmt.Sprintf("[%s] %d/%d %d%%", bar, done, total, done*100/total)
+}
+
+// linesProgress is the line shown between lines of a lines submit session:
+// the bar, this session's counters and, once a line has been accepted, the
+// pace and an estimated finish assuming that pace holds.
+func linesProgress(done, total, accepted, rejected int, elapsed time.Duration, now time.Time) string {
+	out := fmt.Sprintf("%s | %d accepted, %d rejected", progressBar(done, total, 30), accepted, rejected)
+	if accepted > 0 && done < total {
+		pace := elapsed / time.Duration(accepted)
+		eta := pace * time.Duration(total-done)
+		out += fmt.Sprintf(" | %.1fs/line | ETA %s (%s)",
+			pace.Seconds(), eta.Round(time.Second), now.Add(eta).Format("15:04"))
+	}
+	return dim(out)
+}
+
+// transitionChain renders who took over the state in turn, oldest first,
+// e.g. "cli → penance → escalation → unlock".
+func transitionChain(ts []state.Transition) string {
+	var chain []string
+	for _, t := range ts {
+		if len(chain) == 0 || chain[len(chain)-1] != t.By {
+			chain = append(chain, t.By)
+		}
+	}
+	return strings.Join(chain, " → ")
+}
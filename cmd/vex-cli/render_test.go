@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+func TestProgressBar(t *testing.T) {
+	flagNoColor = true
+	for _, tc := range []struct {
+		done, total int
+		want        string
+	}{
+		{0, 4, "[░░░░] 0/4 0%"},
+		{1, 4, "[█░░░] 1/4 25%"},
+		{4, 4, "[████] 4/4 100%"},
+		{9, 4, "[████] 4/4 100%"},
+		{1, 0, ""},
+	} {
+		if got := progressBar(tc.done, tc.total, 4); got != tc.want {
+			t.Errorf("progressBar(%d, %d) = %q, want %q", tc.done, tc.total, got, tc.want)
+		}
+	}
+	if got := lockState(true); got != "LOCKED" {
+		t.Errorf("Expected no colour with --no-color, got %q", got)
+	}
+}
+
+func TestLinesProgress(t *testing.T) {
+	flagNoColor = true
+	now := time.Date(2026, 1, 2, 14, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		done, accepted int
+		elapsed        time.Duration
+		want           string
+	}{
+		{5, 0, 0, "0 accepted, 1 rejected"},
+		{8, 3, 30 * time.Second, "8/10 80% | 3 accepted, 1 rejected | 10.0s/line | ETA 20s (14:00)"},
+		{4, 4, 2 * time.Minute, "| 30.0s/line | ETA 3m0s (14:03)"},
+		{10, 2, time.Minute, "10/10 100% | 2 accepted, 1 rejected"},
+	} {
+		got := linesProgress(tc.done, 10, tc.accepted, 1, tc.elapsed, now)
+		if !strings.HasSuffix(got, tc.want) {
+			t.Errorf("linesProgress(%d, %d, %v) = %q, want suffix %q", tc.done, tc.accepted, tc.elapsed, got, tc.want)
+		}
+	}
+}
+
+func TestTransitionChain(t *testing.T) {
+	ts := []state.Transition{
+		{By: "cli", Profile: "dial-up"},
+		{By: "penance", Profile: "black-hole", Locked: true},
+		{By: "penance", Profile: "black-hole"},
+		{By: "unlock", Profile: "standard"},
+	}
+	if got, want := transitionChain(ts), "cli → penance → unlock"; got != want {
+		t.Errorf("transitionChain = %q, want %q", got, want)
+	}
+	if got := transitionChain(nil); got != "" {
+		t.Errorf("transitionChain(nil) = %q, want empty", got)
+	}
+}
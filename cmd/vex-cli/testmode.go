@@ -0,0 +1,29 @@
+//go:build vextest
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/adumbdinosaur/vex-cli/internal/fakes"
+)
+
+// Test mode.  A vex-cli built with the vextest tag and started with
+// VEX_TEST_ROOT set talks to the test-mode vexd under that directory and
+// reads the keyholder's files from there, without root or the vex group.
+// Production builds have none of this: the management key would be
+// anyone's to replace.
+func init() {
+	root := os.Getenv("VEX_TEST_ROOT")
+	if root == "" {
+		return
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		log.Fatalf("Test mode: %v", err)
+	}
+	testRoot = root
+	fakes.Reroot(root)
+}
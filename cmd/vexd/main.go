@@ -5,22 +5,72 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/adumbdinosaur/vex-cli/internal/antitamper"
+	"github.com/adumbdinosaur/vex-cli/internal/audit"
+	"github.com/adumbdinosaur/vex-cli/internal/blackout"
+	"github.com/adumbdinosaur/vex-cli/internal/buildinfo"
+	"github.com/adumbdinosaur/vex-cli/internal/calendar"
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	"github.com/adumbdinosaur/vex-cli/internal/checkpoint"
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/config"
+	"github.com/adumbdinosaur/vex-cli/internal/credits"
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+	"github.com/adumbdinosaur/vex-cli/internal/discord"
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/extension"
+	"github.com/adumbdinosaur/vex-cli/internal/gpu"
 	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/heartbeat"
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	"github.com/adumbdinosaur/vex-cli/internal/hostsync"
 	"github.com/adumbdinosaur/vex-cli/internal/ipc"
 	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/matrix"
+	"github.com/adumbdinosaur/vex-cli/internal/media"
+	"github.com/adumbdinosaur/vex-cli/internal/messages"
+	"github.com/adumbdinosaur/vex-cli/internal/modules"
+	"github.com/adumbdinosaur/vex-cli/internal/mqtt"
+	"github.com/adumbdinosaur/vex-cli/internal/netacct"
+	"github.com/adumbdinosaur/vex-cli/internal/notice"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
 	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/push"
+	"github.com/adumbdinosaur/vex-cli/internal/report"
+	"github.com/adumbdinosaur/vex-cli/internal/sandbox"
+	"github.com/adumbdinosaur/vex-cli/internal/scheduler"
+	"github.com/adumbdinosaur/vex-cli/internal/seat"
 	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/selftest"
 	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/stats"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
 	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
 	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+	"github.com/adumbdinosaur/vex-cli/internal/tiers"
+	"github.com/adumbdinosaur/vex-cli/internal/usb"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 )
 
 // dryRun disables all kernel side-effects (qdiscs, nftables, cgroups,
@@ -29,30 +79,91 @@ import (
 // syscalls are skipped.  Useful for testing the CLI ↔ daemon flow.
 var dryRun bool
 
+// fakeRoot is the directory vexd keeps every file under when it runs
+// against the fake kernel of package fakes, or "" on the real one: in
+// test mode (testmode.go, vextest builds only) and under --simulate.
+var fakeRoot string
+
+// simulate runs vexd on a virtual clock (simulate.go, vextest builds
+// only).
+var simulate bool
+
+// Hooks of --simulate, set by simulate.go in vextest builds.  They are
+// nil in production builds, which link neither the fake kernel nor the
+// clock controls.
+var (
+	startSimulation func()      // before anything reads or writes a file
+	endSimulation   func()      // on exit
+	handleClock     ipc.Handler // CmdClock
+)
+
 func main() {
 	// Check for --dry-run before anything else.
 	for _, arg := range os.Args[1:] {
-		if arg == "--dry-run" {
+		switch arg {
+		case "--dry-run":
 			dryRun = true
+		case "--simulate":
+			// Not ignored: a production vexd started to preview would
+			// otherwise enforce for real.
+			if startSimulation == nil {
+				fmt.Fprintln(os.Stderr, "vexd: --simulate needs a build with the vextest tag (go build -tags vextest ./cmd/vexd)")
+				os.Exit(2)
+			}
+			simulate = true
+		case "--version":
+			fmt.Println("vexd", buildinfo.Get())
+			return
+		case "--selftest":
+			os.Exit(runSelfTest())
 		}
 	}
 
+	// Before anything reads or writes a file.
+	if simulate {
+		startSimulation()
+	}
+
 	// ── Logging ─────────────────────────────────────────────────────
 	if err := vexlog.Init(); err != nil {
 		log.Printf("Logging initialization warning: %v", err)
 	}
 	defer vexlog.Close()
+	defer crashOnPanic()
+	if err := vexlog.StartRotation(); err != nil {
+		log.Printf("Logging: rotation disabled: %v", err)
+	}
+	if err := vexlog.StartForwarding(); err != nil {
+		log.Printf("Logging: remote forwarding disabled: %v", err)
+	}
 
 	if dryRun {
-		log.Println("Starting vexd (Protocol 106-V) [DRY-RUN MODE] …")
+		log.Printf("Starting vexd %s (Protocol 106-V) [DRY-RUN MODE] …", buildinfo.Get())
 	} else {
-		log.Println("Starting vexd (Protocol 106-V) …")
+		log.Printf("Starting vexd %s (Protocol 106-V) …", buildinfo.Get())
 	}
 
-	if os.Geteuid() != 0 {
+	if os.Geteuid() != 0 && fakeRoot == "" {
 		log.Fatal("Error: vexd must be run as root.")
 	}
 
+	// ── Daemon configuration ────────────────────────────────────────
+	// Before anything reads the tunables it may override.
+	if cfg, err := config.Load(); err != nil {
+		log.Printf("Config warning (using built-in defaults): %v", err)
+	} else if cfg != nil {
+		cfg.Apply()
+		log.Printf("Config: loaded %s", config.File)
+	}
+	if dryRun {
+		subsystem.DryRunAll()
+	}
+	for _, name := range subsystem.Names {
+		if m := subsystem.Get(name); m != subsystem.Enforce {
+			log.Printf("Subsystem %s: %s", name, m)
+		}
+	}
+
 	// ── Security ────────────────────────────────────────────────────
 	if err := security.Init(); err != nil {
 		log.Printf("Security initialization warning: %v", err)
@@ -61,7 +172,28 @@ func main() {
 	// Ensure config files and the log are accessible to vex group members
 	// so non-root users running vex-cli can read manifests, keys, and
 	// append to the shared log file.
-	security.EnsureConfigPermissions()
+	if fakeRoot == "" {
+		security.EnsureConfigPermissions()
+	}
+
+	// ── Keyholder notifications ─────────────────────────────────────
+	// Before the subsystems start, so that early kills and escalations
+	// are reported too.
+	if err := notify.Init(); err != nil {
+		log.Printf("Notify initialization warning: %v", err)
+	}
+	if err := push.Init(); err != nil {
+		log.Printf("Push initialization warning: %v", err)
+	}
+	if err := desktop.Init(); err != nil {
+		log.Printf("Desktop notification warning: %v", err)
+	}
+	if msgCfg, err := messages.LoadConfig(); err != nil {
+		log.Printf("Messages initialization warning: %v", err)
+	} else if msgCfg != nil {
+		messages.Start(msgCfg)
+	}
+	notifyEvents()
 
 	// ── Load persisted state ────────────────────────────────────────
 	sysState, err := state.Load()
@@ -77,79 +209,144 @@ func main() {
 		sysState.Compliance.TaskStatus = cs.TaskStatus
 	}
 
-	penaltyActive := sysState.Compliance.Locked
-	if penaltyActive {
+	// A pause keeps the penalty off until it ends, even across restarts;
+	// resumeIfDue re-applies the saved state below.
+	penaltyActive := sysState.Compliance.Locked && sysState.Pause == nil
+	if sysState.Pause != nil {
+		log.Printf("Enforcement PAUSED until %s", sysState.Pause.Until)
+	} else if penaltyActive {
 		log.Println("Compliance state: LOCKED — penalties will be enforced")
 	} else {
 		log.Println("Compliance state: UNLOCKED — starting with persisted/clean state")
 	}
+	previous := markRunning()
 
 	// ── Subsystem init ──────────────────────────────────────────────
 
+	var late []lateStep
 	if !dryRun {
-		// 1. Throttler — detect interface
-		if err := throttler.Init(); err != nil {
-			log.Printf("Throttler initialization warning: %v", err)
+		// Target users — what follows is scoped to them, if set
+		if err := users.Init(); err != nil {
+			log.Printf("Target users warning: %v", err)
 		}
 
-		// 2. Apply network state
-		applyNetworkState(sysState)
-
-		// 3. Apply compute state
-		applyComputeState(sysState)
+		// 1–5. Throttler (interface, then network and compute state),
+		// guardian and surveillance, side by side.  One still starting
+		// after initStepTimeout, like a guardian waiting on DNS, finishes
+		// in the background.
+		late = runInitSteps(sysState, []initStep{
+			{subsystem.Throttler, func(c *state.SystemState) func(*state.SystemState) {
+				if subsystem.Enabled(subsystem.Throttler) {
+					if err := throttler.Init(); err != nil {
+						log.Printf("Throttler initialization warning: %v", err)
+					}
+				}
+				applyNetworkState(c)
+				applyComputeState(c)
+				return func(s *state.SystemState) {
+					s.Network.ApplyStatus = c.Network.ApplyStatus
+					s.Compute.ApplyStatus = c.Compute.ApplyStatus
+				}
+			}},
+			{subsystem.Guardian, func(c *state.SystemState) func(*state.SystemState) {
+				if !subsystem.Enabled(subsystem.Guardian) {
+					return func(*state.SystemState) {}
+				}
+				initGuardian(c, penaltyActive)
+				return func(s *state.SystemState) { s.Guardian.ApplyStatus = c.Guardian.ApplyStatus }
+			}},
+			{subsystem.Surveillance, func(c *state.SystemState) func(*state.SystemState) {
+				if !subsystem.Enabled(subsystem.Surveillance) {
+					return func(*state.SystemState) {}
+				}
+				until, status := c.Compute.InputLockUntil, c.Compute.ApplyStatus
+				initSurveillance(c)
+				return func(s *state.SystemState) {
+					if s.Compute.InputLockUntil == until {
+						s.Compute.InputLockUntil = c.Compute.InputLockUntil // cleared if it ran out
+					}
+					if c.Compute.ApplyStatus != status {
+						s.Compute.ApplyStatus = c.Compute.ApplyStatus
+					}
+				}
+			}},
+		})
 
-		// 4. Guardian
-		if err := guardian.Init(penaltyActive || sysState.Guardian.FirewallEnabled); err != nil {
-			log.Printf("Guardian initialization warning: %v", err)
+		// 6. Penance (may override state if penalty is active)
+		penanceErr := penance.Init()
+		if penanceErr != nil {
+			log.Printf("Penance initialization warning: %v", penanceErr)
 		}
-		// Restore persisted blocked domains (if any) that aren't already
-		// covered by loadBlockedDomains() inside Init.
-		if len(sysState.Guardian.BlockedDomains) > 0 {
-			if err := guardian.SetBlockedDomains(sysState.Guardian.BlockedDomains); err != nil {
-				log.Printf("Guardian: failed to restore persisted blocklist: %v", err)
-			} else {
-				log.Printf("Guardian: Restored %d persisted blocked domains", len(sysState.Guardian.BlockedDomains))
+		if sysState.Pause != nil && sysState.Compliance.Locked {
+			// penance.Init enforced the manifest; lift it again.
+			liftEnforcement(sysState)
+		}
+		// If penance enforcement changed network/compute, re-sync state
+		if penaltyActive {
+			if m := penance.CurrentManifest; m != nil {
+				syncPenanceOverrides(sysState, m, penanceErr)
 			}
 		}
 
-		// 5. Surveillance
-		if err := surveillance.Init(); err != nil {
-			log.Printf("Surveillance initialization warning: %v", err)
-		}
-		if sysState.Compute.InputLatencyMs > 0 {
-			surveillance.InjectLatency(sysState.Compute.InputLatencyMs)
+		// 7. Anti-tamper
+		if subsystem.Enabled(subsystem.AntiTamper) {
+			if err := antitamper.Init(); err != nil {
+				log.Printf("Anti-tamper initialization warning: %v", err)
+			}
 		}
 
-		// 6. Penance (may override state if penalty is active)
-		if err := penance.Init(); err != nil {
-			log.Printf("Penance initialization warning: %v", err)
+		// 8. Enforcement modules, the media caps, the GPU cap, the
+		// backup uplink blackout and the notice among them
+		if subsystem.Enabled(subsystem.Media) {
+			modules.Register(media.NewModule())
 		}
-		// If penance enforcement changed network/compute, re-sync state
-		if penaltyActive {
-			if m := penance.CurrentManifest; m != nil {
-				sysState.Network.Profile = m.Overrides.Network.Profile
-				sysState.Network.PacketLossPct = float32(m.Overrides.Network.PacketLoss)
-				sysState.Compute.CPULimitPct = m.Overrides.Compute.CPULimit
-				sysState.Compute.InputLatencyMs = m.Overrides.Compute.InputLatency
-				sysState.Compute.OOMScoreAdj = m.Overrides.Compute.OOMScoreAdj
-				sysState.Guardian.FirewallEnabled = true
-				sysState.Guardian.BlockedDomains = guardian.GetBlockedDomains()
-				sysState.ChangedBy = "penance"
+		if subsystem.Enabled(subsystem.Throttler) {
+			modules.Register(gpu.NewModule())
+			if bCfg, err := blackout.LoadConfig(); err != nil {
+				log.Printf("Blackout initialization warning: %v", err)
+			} else if bCfg != nil {
+				modules.Register(blackout.NewModule(bCfg))
 			}
 		}
+		if nCfg, err := notice.LoadConfig(); err != nil {
+			log.Printf("Notice initialization warning: %v", err)
+		} else if nCfg != nil {
+			modules.Register(notice.NewModule(nCfg))
+		}
+		if err := modules.Init(); err != nil {
+			log.Printf("Modules initialization warning: %v", err)
+		}
 
-		// 7. Anti-tamper
-		if err := antitamper.Init(); err != nil {
-			log.Printf("Anti-tamper initialization warning: %v", err)
+		// 9. Whatever a crashed run left behind
+		if previous != nil {
+			reassertKernelState(sysState)
 		}
+		modules.Apply(sysState)
 	} else {
 		log.Println("[DRY-RUN] Skipping all subsystem initialization (no kernel changes)")
+		if err := users.Resolve(); err != nil {
+			log.Printf("Target users warning: %v", err)
+		}
 	}
 
 	// Persist the resolved state so it's always up to date on disk.
 	if err := state.Save(sysState); err != nil {
 		log.Printf("Failed to persist initial state: %v", err)
 	}
+	if err := state.EnsureSpoolDir(); err != nil {
+		log.Printf("Spool directory warning: %v", err)
+	}
+
+	// ── Exception requests ──────────────────────────────────────────
+	if err := loadExceptionPolicy(); err != nil {
+		log.Printf("Exception policy warning: %v", err)
+	}
+	if fbCfg, err := seat.LoadBreakConfig(); err != nil {
+		log.Printf("Forced break initialization warning: %v", err)
+	} else if fbCfg != nil {
+		forcedBreakCfg = fbCfg
+		log.Printf("Forced break: enabled (%s after a %s warning)", fbCfg.Action, fbCfg.Grace())
+	}
 
 	// ── IPC server ──────────────────────────────────────────────────
 	srv, err := ipc.NewServer(sysState)
@@ -157,36 +354,491 @@ func main() {
 		log.Fatalf("Failed to start IPC server: %v", err)
 	}
 	registerHandlers(srv)
+	srv.RequireClient(antitamper.TrustedClient, loweringCommands...)
+	if len(antitamper.KnownClients()) == 0 {
+		log.Printf("IPC: WARNING - no known-good vex-cli build, clients will not be verified")
+	}
+	srv.OnPanic(crash)
+	srv.OnChange(modules.Apply)
+	streamEvents(srv)
+	events.Subscribe(blackoutEnded(srv))
+	events.Subscribe(sessionLocks(srv))
+	checkSessionLocks()
+
+	// Revert anything whose --for period ran out while the daemon was
+	// down, and arm the timer for the rest.
+	liveSrv = srv
+	srv.Update(resumeIfDue)
+	srv.Update(relockIfDue)
+	srv.Update(freezeIfDue)
+	srv.Update(monitorDayIfDue)
+	srv.Update(revertExpired)
+	srv.Update(approveDueExceptions)
+	srv.View(scheduleAllowances)
+	srv.View(scheduleCommands)
+	finishLateSteps(srv, late)
+	runSpooled(srv)
 	go srv.Serve()
 
+	// ── USB device policy (optional) ────────────────────────────────
+	if usbCfg, err := usb.LoadConfig(); err != nil {
+		log.Printf("USB initialization warning: %v", err)
+	} else if usbCfg != nil && subsystem.Enabled(subsystem.Guardian) {
+		usb.Start(usbCfg, usbEnforcing, reportUSBDevice)
+	}
+
+	// ── Multi-host sync (optional) ──────────────────────────────────
+	if syncCfg, err := hostsync.ConfigFromEnv(); err != nil {
+		log.Printf("HostSync initialization warning: %v", err)
+	} else if err := hostsync.Init(syncCfg, syncHooks(srv)); err != nil {
+		log.Printf("HostSync initialization warning: %v", err)
+	}
+
+	// ── Chat bridges (optional) ─────────────────────────────────────
+	if dcCfg, err := discord.LoadConfig(); err != nil {
+		log.Printf("Discord initialization warning: %v", err)
+	} else if dcCfg != nil {
+		if _, err := discord.Start(dcCfg, srv.Dispatch); err != nil {
+			log.Printf("Discord initialization warning: %v", err)
+		}
+	}
+	if mxCfg, err := matrix.LoadConfig(); err != nil {
+		log.Printf("Matrix initialization warning: %v", err)
+	} else if mxCfg != nil {
+		if _, err := matrix.Start(mxCfg, srv.Dispatch); err != nil {
+			log.Printf("Matrix initialization warning: %v", err)
+		}
+	}
+
+	// ── MQTT / Home Assistant (optional) ────────────────────────────
+	var mqttPub *mqtt.Publisher
+	if mqCfg, err := mqtt.LoadConfig(); err != nil {
+		log.Printf("MQTT initialization warning: %v", err)
+	} else if mqCfg != nil {
+		mqttPub = mqtt.Start(mqCfg)
+		srv.View(func(s *state.SystemState) { mqttPub.Update(mqttStatus(s)) })
+		srv.OnChange(func(s *state.SystemState) { mqttPub.Update(mqttStatus(s)) })
+	}
+
+	// ── Heartbeat (optional) ────────────────────────────────────────
+	if hbCfg, err := heartbeat.LoadConfig(); err != nil {
+		log.Printf("Heartbeat initialization warning: %v", err)
+	} else if hbCfg != nil {
+		events.Subscribe(checkinMissed(srv, hbCfg))
+		heartbeat.Start(hbCfg, heartbeatStatus(srv))
+	}
+
+	// ── Stats API (optional) ────────────────────────────────────────
+	if stCfg, err := stats.LoadConfig(); err != nil {
+		log.Printf("Stats initialization warning: %v", err)
+	} else if stCfg != nil {
+		src := stats.Source{LogPath: vexlog.LogFilePath, DailyUsage: surveillance.GetDailyUsage}
+		if err := stats.Start(stCfg, src); err != nil {
+			log.Printf("Stats initialization warning: %v", err)
+		}
+	}
+
+	// ── Browser extension API (optional) ────────────────────────────
+	if exCfg, err := extension.LoadConfig(); err != nil {
+		log.Printf("Extension initialization warning: %v", err)
+	} else if exCfg != nil {
+		if err := extension.Start(exCfg, extensionSource(srv)); err != nil {
+			log.Printf("Extension initialization warning: %v", err)
+		}
+	}
+
+	// ── Time-of-day policies ────────────────────────────────────────
+	scheduler.Set(curfewJob, curfewDue(srv), curfewChanged(srv))
+	scheduleStreak(srv)
+	if calCfg, err := calendar.LoadConfig(); err != nil {
+		log.Printf("Calendar initialization warning: %v", err)
+	} else if calCfg != nil {
+		calendarCfg = calCfg
+		calendar.Start(calCfg, calendarFetched(srv))
+		scheduleCalendar(srv)
+	}
+	if tCfg, err := tiers.LoadConfig(); err != nil {
+		log.Printf("Tiers initialization warning: %v", err)
+	} else if tCfg != nil {
+		tiersCfg = tCfg
+		scheduleTiers(srv)
+	}
+	if cCfg, err := credits.LoadConfig(); err != nil {
+		log.Printf("Credits initialization warning: %v", err)
+	} else if cCfg != nil {
+		creditsCfg = cCfg
+		scheduleCredits(srv)
+	}
+	if cpCfg, err := checkpoint.LoadConfig(); err != nil {
+		log.Printf("Checkpoint initialization warning: %v", err)
+	} else {
+		checkpointCfg = cpCfg
+	}
+	scheduleCheckpoints()
+	if repCfg, err := report.LoadConfig(); err != nil {
+		log.Printf("Report initialization warning: %v", err)
+	} else if repCfg != nil {
+		reportCfg = repCfg
+		scheduleReports(srv)
+	}
+	// Presets removed from the config since the last run are ended here;
+	// the rest are re-evaluated by their jobs.
+	srv.Update(func(s *state.SystemState) {
+		for _, name := range slices.Clone(s.Calendar.Presets) {
+			if calendarCfg != nil {
+				if _, ok := calendarCfg.Presets[name]; ok {
+					continue
+				}
+			}
+			setCalendarPreset(s, name, false)
+		}
+	})
+	scheduler.Start()
+
+	supervisor.Go("vexd.deadline-warnings", func() error { return deadlineWarnLoop(srv) })
+	supervisor.Go("vexd.history", func() error { return historyLoop(srv) })
+	supervisor.Go("vexd.lines-abandon", func() error { return writingAbandonLoop(srv) })
+
+	// ── Usage-based penalty rules ───────────────────────────────────
+	if !dryRun {
+		supervisor.Go("vexd.usage-rules", func() error { return usageRuleLoop(srv) })
+	}
+
+	// ── Tunnel watch ────────────────────────────────────────────────
+	if !dryRun && subsystem.Enabled(subsystem.Guardian) {
+		guardian.WatchTunnels(tunnelDetected(srv))
+	}
+
+	// ── Uplink watch ────────────────────────────────────────────────
+	if !dryRun && subsystem.Enabled(subsystem.Throttler) {
+		throttler.WatchUplink(uplinkMoved(srv))
+	}
+
+	// ── Sandbox ─────────────────────────────────────────────────────
+	// Last, once everything that needs more than the policy has started.
+	// Not in test mode, whose files are elsewhere.
+	if fakeRoot == "" {
+		if err := sandbox.Apply(sandboxPolicy()); err != nil {
+			log.Printf("Sandbox warning: %v", err)
+		}
+	}
+
 	if dryRun {
 		log.Println("All subsystems initialized. Daemon ready. [DRY-RUN — no enforcement]")
 	} else {
 		log.Println("All subsystems initialized. Daemon ready.")
 	}
-	vexlog.LogEvent("DAEMON", "STARTED", fmt.Sprintf("penalty_active=%v, dry_run=%v", penaltyActive, dryRun))
+	vexlog.LogEvent("DAEMON", "STARTED", fmt.Sprintf("version=%s, penalty_active=%v, dry_run=%v", buildinfo.Get().ID(), penaltyActive, dryRun))
 
 	// ── Wait for signal ─────────────────────────────────────────────
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	sig := <-sigCh
+	for sig == syscall.SIGHUP {
+		log.Println("Received SIGHUP, reloading…")
+		if resp := srv.Dispatch(&ipc.Request{Command: ipc.CmdReload}); resp.OK {
+			log.Println(resp.Message)
+		} else {
+			log.Printf("Reload failed: %s", resp.Error)
+		}
+		sig = <-sigCh
+	}
 	log.Printf("Received %s, shutting down…", sig)
 	srv.Close()
+	if mqttPub != nil {
+		mqttPub.Close()
+	}
+
+	// A penalty in force outlives the daemon, or restarting it would lift
+	// it until the new one starts.
+	var keep bool
+	srv.View(func(s *state.SystemState) { keep = s.Compliance.Locked && s.Pause == nil })
 
 	if !dryRun {
-		// Clean up kernel state so rules/qdiscs don't persist after the daemon exits.
-		log.Println("Cleaning up network qdiscs…")
-		if err := throttler.ApplyNetworkProfile(throttler.ProfileStandard); err != nil {
-			log.Printf("Warning: failed to clear qdiscs: %v", err)
+		log.Println("Releasing grabbed keyboards…")
+		if err := surveillance.Shutdown(); err != nil {
+			log.Printf("Warning: surveillance shutdown: %v", err)
 		}
-		log.Println("Cleaning up guardian (nftables + eBPF)…")
-		if err := guardian.Shutdown(); err != nil {
-			log.Printf("Warning: guardian shutdown: %v", err)
+		if keep {
+			log.Println("Locked: leaving qdiscs and nftables rules in place")
+			if err := guardian.Detach(); err != nil {
+				log.Printf("Warning: guardian shutdown: %v", err)
+			}
+		} else {
+			// Clean up kernel state so rules/qdiscs don't persist after the daemon exits.
+			log.Println("Cleaning up network qdiscs…")
+			if err := throttler.ApplyNetworkProfile(throttler.ProfileStandard); err != nil {
+				log.Printf("Warning: failed to clear qdiscs: %v", err)
+			}
+			log.Println("Cleaning up guardian (nftables + eBPF)…")
+			if err := guardian.Shutdown(); err != nil {
+				log.Printf("Warning: guardian shutdown: %v", err)
+			}
+			users.Clear()
+			log.Println("Shutting down enforcement modules…")
+			if err := modules.Shutdown(); err != nil {
+				log.Printf("Warning: modules shutdown: %v", err)
+			}
 		}
 	} else {
 		log.Println("[DRY-RUN] Skipping kernel cleanup (nothing was applied)")
 	}
-	vexlog.LogEvent("DAEMON", "STOPPED", sig.String())
+	vexlog.LogEvent("DAEMON", "STOPPED", fmt.Sprintf("signal=%s, enforcement_kept=%v", sig, keep && !dryRun))
+	os.Remove(runMarker)
+	if endSimulation != nil {
+		endSimulation()
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════
+// Parallel startup
+// ═══════════════════════════════════════════════════════════════════
+
+// initStepTimeout is how long startup waits for a subsystem before going
+// on without it.
+var initStepTimeout = 15 * time.Second
+
+// initStep starts one subsystem.  run reads and records into c, its own
+// deep copy of the state, and returns a function that copies the outcome into
+// the real one.
+type initStep struct {
+	name string
+	run  func(c *state.SystemState) func(s *state.SystemState)
+}
+
+// lateStep is an initStep still running after initStepTimeout.
+type lateStep struct {
+	name string
+	done <-chan func(*state.SystemState)
+}
+
+// runInitSteps runs steps side by side and records, in order, the
+// outcome of each that finishes within initStepTimeout.  The others are
+// returned for finishLateSteps.
+func runInitSteps(s *state.SystemState, steps []initStep) []lateStep {
+	dones := make([]chan func(*state.SystemState), len(steps))
+	for i, step := range steps {
+		c := s.Clone()
+		dones[i] = make(chan func(*state.SystemState), 1)
+		go func() {
+			defer crashOnPanic()
+			dones[i] <- step.run(c)
+		}()
+	}
+
+	deadline := time.Now().Add(initStepTimeout)
+	var late []lateStep
+	for i, step := range steps {
+		select {
+		case record := <-dones[i]:
+			record(s)
+		case <-time.After(time.Until(deadline)):
+			log.Printf("Startup: %s still initializing after %s — continuing in the background", step.name, initStepTimeout)
+			vexlog.LogEvent("DAEMON", "INIT_SLOW", "subsystem="+step.name)
+			late = append(late, lateStep{step.name, dones[i]})
+		}
+	}
+	return late
+}
+
+// finishLateSteps records the outcome of each late step through srv once
+// it finishes.
+func finishLateSteps(srv *ipc.Server, late []lateStep) {
+	for _, l := range late {
+		go func() {
+			record := <-l.done
+			log.Printf("Startup: %s initialized in the background", l.name)
+			vexlog.LogEvent("DAEMON", "INIT_FINISHED", "subsystem="+l.name)
+			srv.Update(record)
+		}()
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════
+// Self-test
+// ═══════════════════════════════════════════════════════════════════
+
+// runSelfTest probes what vexd needs from the host, with config.json
+// applied as it would be at startup, prints the capability matrix and
+// returns the exit status: 1 if a required capability is missing.
+// Nothing is enforced, logged to the log file or persisted.
+func runSelfTest() int {
+	if cfg, err := config.Load(); err != nil {
+		log.Printf("Config warning (using built-in defaults): %v", err)
+	} else if cfg != nil {
+		cfg.Apply()
+	}
+	if err := users.Resolve(); err != nil {
+		log.Printf("Target users warning: %v", err)
+	}
+
+	fmt.Printf("vexd %s\n\n", buildinfo.Get())
+	if !selftest.Print(os.Stdout, selftest.Run(selftest.Checks)) {
+		return 1
+	}
+	return 0
+}
+
+// ═══════════════════════════════════════════════════════════════════
+// Sandbox
+// ═══════════════════════════════════════════════════════════════════
+
+// sandboxPolicy lists what vexd still writes once started: its state,
+// config, socket and log, the cgroups it limits, /proc for its own OOM
+// score and the subject's, input devices, the backlights and sound
+// devices the media caps use, the motd and the terminals the messages
+// go to, the notice's wallpapers, and /tmp for its helpers.  Helpers
+// run from the system's program directories.
+func sandboxPolicy() sandbox.Policy {
+	// Created now, as they cannot be once the sandbox is on.
+	os.MkdirAll(filepath.Dir(messages.MOTDFile), 0755)
+	os.MkdirAll(notice.WallpaperDir, 0755)
+
+	writable := []string{
+		state.StateDir, filepath.Dir(config.File), filepath.Dir(state.SocketPath), filepath.Dir(vexlog.LogFilePath),
+		"/proc", "/dev/input", "/dev/uinput", "/dev/null", "/dev/pts",
+		filepath.Dir(messages.MOTDFile), notice.WallpaperDir, "/tmp", "/dev/snd",
+	}
+	writable = append(writable, media.BacklightPaths()...)
+	writable = append(writable, gpu.Paths()...)
+	writable = append(writable, usb.Paths()...)
+	for _, p := range throttler.CPUMaxCandidates {
+		writable = append(writable, filepath.Dir(p))
+	}
+	if throttler.PenaltySlice {
+		writable = append(writable, "/sys/fs/cgroup") // processes are moved in and out of the slice
+	}
+	return sandbox.Policy{
+		Writable: writable,
+		Exec:     []string{"/nix/store", "/run/wrappers", "/usr", "/bin", "/sbin", "/lib", "/lib64"},
+	}
+}
+
+// ═══════════════════════════════════════════════════════════════════
+// Crash recovery
+// ═══════════════════════════════════════════════════════════════════
+
+// exitPanic is vexd's exit status after a panic, told apart from the 1 of
+// a fatal startup error.  systemd restarts vexd after either.
+const exitPanic = 70
+
+// runMarker exists while vexd runs.  Finding it at startup means the last
+// run crashed, was killed or went down with the machine.
+var runMarker = filepath.Join(state.StateDir, "vexd.running")
+
+// runRecord is the contents of runMarker.
+type runRecord struct {
+	PID     int    `json:"pid"`
+	Started string `json:"started"`
+	Panic   string `json:"panic,omitempty"` // set by crash
+}
+
+// markRunning writes runMarker and returns the record the previous run
+// left in it, or nil if that run stopped cleanly.
+func markRunning() *runRecord {
+	var prev *runRecord
+	if data, err := os.ReadFile(runMarker); err == nil {
+		prev = &runRecord{}
+		json.Unmarshal(data, prev)
+		if prev.Panic != "" {
+			log.Printf("Previous run (pid %d, started %s) crashed: %s", prev.PID, prev.Started, prev.Panic)
+		} else {
+			log.Printf("Previous run (pid %d, started %s) did not stop cleanly", prev.PID, prev.Started)
+		}
+		vexlog.LogEvent("DAEMON", "UNCLEAN_RESTART",
+			fmt.Sprintf("pid=%d, started=%s, panic=%q", prev.PID, prev.Started, prev.Panic))
+	}
+	writeRunMarker(&runRecord{PID: os.Getpid(), Started: time.Now().UTC().Format(time.RFC3339)})
+	return prev
+}
+
+// writeRunMarker replaces runMarker with r.
+func writeRunMarker(r *runRecord) {
+	data, _ := json.Marshal(r)
+	err := os.MkdirAll(state.StateDir, 0750)
+	if err == nil {
+		err = os.WriteFile(runMarker, data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write %s: %v", runMarker, err)
+	}
+}
+
+// reassertKernelState runs at startup after a run that did not stop
+// cleanly.  Startup has just applied the persisted state; this removes a
+// firewall table the crashed run may have left up after lifting the
+// blocklist, reports enforcement modules that no longer agree, and applies
+// the network and CPU settings again if the kernel does not show them.
+func reassertKernelState(s *state.SystemState) {
+	log.Println("Crash recovery: verifying kernel state…")
+	if subsystem.Get(subsystem.Guardian) == subsystem.Enforce && len(guardian.GetBlockedDomains()) == 0 {
+		if err := guardian.ClearFirewall(); err != nil {
+			log.Printf("Crash recovery: %v", err)
+		}
+	}
+	// Modules are applied again straight after, so drift is only reported.
+	if err := modules.Verify(s); err != nil {
+		log.Printf("Crash recovery: modules: %v", err)
+		vexlog.LogEvent("DAEMON", "STATE_DRIFT", err.Error())
+	}
+	if subsystem.Get(subsystem.Throttler) != subsystem.Enforce {
+		return
+	}
+	err := throttler.Verify(throttler.Profile(s.Network.Profile), s.Network.PacketLossPct, s.Compute.CPULimitPct)
+	if err == nil {
+		log.Println("Crash recovery: kernel state matches")
+		return
+	}
+	log.Printf("Crash recovery: %v — applying again", err)
+	vexlog.LogEvent("DAEMON", "STATE_DRIFT", err.Error())
+	applyNetworkState(s)
+	applyComputeState(s)
+	if err := throttler.Verify(throttler.Profile(s.Network.Profile), s.Network.PacketLossPct, s.Compute.CPULimitPct); err != nil {
+		log.Printf("Crash recovery: still differs: %v", err)
+	}
+}
+
+// crashOnPanic is deferred at the top of main, turning a panic on the
+// main goroutine into a crash.
+func crashOnPanic() {
+	if r := recover(); r != nil {
+		crash(r)
+	}
+}
+
+var crashOnce sync.Once
+
+// crash handles a panic that reached the top of main or of an IPC
+// request: it logs the panic and its stack, persists the state, records
+// the panic in runMarker for the next start, releases grabbed keyboards
+// and exits with exitPanic.  Enforcement is left in place for the
+// restarted vexd to verify.
+func crash(v any) {
+	crashOnce.Do(func() {
+		log.Printf("PANIC: %v\n%s", v, debug.Stack())
+		vexlog.LogEvent("DAEMON", "PANIC", fmt.Sprint(v))
+		if liveSrv != nil {
+			liveSrv.Close()
+			liveSrv.View(func(s *state.SystemState) {
+				if err := state.Save(s); err != nil {
+					log.Printf("Failed to persist state: %v", err)
+				}
+			})
+		}
+		rec := &runRecord{PID: os.Getpid(), Panic: fmt.Sprint(v)}
+		if data, err := os.ReadFile(runMarker); err == nil {
+			json.Unmarshal(data, rec)
+			rec.Panic = fmt.Sprint(v)
+		}
+		writeRunMarker(rec)
+		if !dryRun {
+			if err := surveillance.Shutdown(); err != nil {
+				log.Printf("Warning: surveillance shutdown: %v", err)
+			}
+		}
+		vexlog.Close()
+	})
+	os.Exit(exitPanic)
 }
 
 // ═══════════════════════════════════════════════════════════════════
@@ -195,55 +847,369 @@ func main() {
 
 func applyNetworkState(s *state.SystemState) {
 	p := throttler.Profile(s.Network.Profile)
+	var err error
 	if s.Network.PacketLossPct > 0 {
-		if err := throttler.ApplyNetworkProfileWithEntropy(p, s.Network.PacketLossPct); err != nil {
+		if err = throttler.ApplyNetworkProfileWithEntropy(p, s.Network.PacketLossPct); err != nil {
 			log.Printf("Failed to apply network state: %v", err)
 		}
 	} else {
-		if err := throttler.ApplyNetworkProfile(p); err != nil {
+		if err = throttler.ApplyNetworkProfile(p); err != nil {
 			log.Printf("Failed to apply network profile: %v", err)
 		}
 	}
+	s.Network.RecordApply(err)
 }
 
 func applyComputeState(s *state.SystemState) {
+	var errs []error
 	if s.Compute.CPULimitPct > 0 && s.Compute.CPULimitPct <= 100 {
 		if err := throttler.SetCPULimit(s.Compute.CPULimitPct); err != nil {
 			log.Printf("Failed to apply CPU limit: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	if s.Compute.Priority != "" {
+		if err := throttler.SetPriority(s.Compute.Priority); err != nil {
+			log.Printf("Failed to apply priority: %v", err)
+			errs = append(errs, err)
 		}
 	}
 	if s.Compute.OOMScoreAdj != 0 {
 		if err := guardian.SetOOMScore(s.Compute.OOMScoreAdj); err != nil {
 			log.Printf("Failed to apply OOM score: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	s.Compute.RecordApply(errors.Join(errs...))
+}
+
+// initGuardian starts the guardian and restores the persisted blocklist.
+// A persisted blocklist replaces the one Init would set up, so Init then
+// leaves the firewall alone and the domains are resolved only once.
+func initGuardian(s *state.SystemState, penaltyActive bool) {
+	persisted := len(s.Guardian.BlockedDomains) > 0
+	guardianErr := guardian.Init((penaltyActive || s.Guardian.FirewallEnabled) && !persisted)
+	if guardianErr != nil {
+		log.Printf("Guardian initialization warning: %v", guardianErr)
+	}
+	if persisted {
+		if err := guardian.SetBlockedDomains(s.Guardian.BlockedDomains); err != nil {
+			log.Printf("Guardian: failed to restore persisted blocklist: %v", err)
+			guardianErr = err
+		} else {
+			log.Printf("Guardian: Restored %d persisted blocked domains", len(s.Guardian.BlockedDomains))
+		}
+	}
+	s.Guardian.RecordApply(guardianErr)
+	guardian.MonitoredAccess = monitorAccess
+	guardian.SetMonitoredDomains(monitoredNames(s))
+}
+
+// initSurveillance starts the keyboard listeners and restores the input
+// latency and any blackout still running.
+func initSurveillance(s *state.SystemState) {
+	surveillance.EnablePersistence()
+	if err := surveillance.Init(); err != nil {
+		log.Printf("Surveillance initialization warning: %v", err)
+	}
+	if err := netacct.Start(surveillance.RecordNetwork); err != nil {
+		log.Printf("Network accounting unavailable: %v", err)
+	}
+	if c := s.Compute; c.InputLatencyMaxMs > c.InputLatencyMs {
+		if err := surveillance.InjectJitter(c.InputLatencyMs, c.InputLatencyMaxMs); err != nil {
+			log.Printf("Surveillance: failed to restore input latency jitter: %v", err)
+			s.Compute.RecordApply(err)
+		}
+	} else if s.Compute.InputLatencyMs > 0 {
+		if err := surveillance.InjectLatency(s.Compute.InputLatencyMs); err != nil {
+			log.Printf("Surveillance: failed to restore input latency: %v", err)
+			s.Compute.RecordApply(err)
+		}
+	}
+	if until, err := time.Parse(time.RFC3339, s.Compute.InputLockUntil); err == nil {
+		if remaining := clock.Until(until); remaining > 0 {
+			if _, err := surveillance.StartInputBlackout(remaining); err != nil {
+				log.Printf("Surveillance: failed to restore input blackout: %v", err)
+				s.Compute.RecordApply(err)
+			}
+		} else {
+			s.Compute.InputLockUntil = ""
+		}
+	}
+	if s.Writing.Live {
+		// The capture does not survive a restart; the line starts over.
+		startLine(&s.Writing, clock.Now())
+	}
+}
+
+// syncPenanceOverrides records the manifest overrides that penance
+// enforcement just applied (err is the result of that attempt).
+func syncPenanceOverrides(s *state.SystemState, m *penance.Manifest, err error) {
+	s.Network.RecordApply(err)
+	s.Compute.RecordApply(err)
+	s.Network.Profile = m.Overrides.Network.Profile
+	s.Network.PacketLossPct = float32(m.Overrides.Network.PacketLoss)
+	s.Compute.CPULimitPct = m.Overrides.Compute.CPULimit
+	s.Compute.Priority = m.Overrides.Compute.Priority
+	s.Compute.GPULimitPct = m.Overrides.Compute.GPULimit
+	minLat, maxLat := surveillance.GetInputLatencyRange()
+	s.Compute.InputLatencyMs = int(minLat / time.Millisecond)
+	s.Compute.InputLatencyMaxMs = 0
+	if maxLat > minLat {
+		s.Compute.InputLatencyMaxMs = int(maxLat / time.Millisecond)
+	}
+	s.Compute.OOMScoreAdj = m.Overrides.Compute.OOMScoreAdj
+	s.Media = mediaOverrides(m)
+	if until := surveillance.InputBlackoutUntil(); !until.IsZero() {
+		s.Compute.InputLockUntil = until.UTC().Format(time.RFC3339)
+	}
+	s.Guardian.FirewallEnabled = true
+	s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+	s.ChangedBy = "penance"
+}
+
+// mediaOverrides is the media caps m imposes, which the media module
+// enforces once they are in the state.
+func mediaOverrides(m *penance.Manifest) state.MediaState {
+	o := m.Overrides.Media
+	return state.MediaState{BrightnessPct: o.BrightnessPct, VolumePct: o.VolumePct, Muted: o.Mute}
+}
+
+// ═══════════════════════════════════════════════════════════════════
+// Multi-host sync
+// ═══════════════════════════════════════════════════════════════════
+
+// syncHooks connects hostsync to the live daemon state.  Apply adds what
+// another host raised and lifts what another host lifted.  A paused host
+// sits sync out, as what the pause lifted is not for the others to lift.
+func syncHooks(srv *ipc.Server) hostsync.Hooks {
+	host, _ := os.Hostname()
+	return hostsync.Hooks{
+		Local: func() *hostsync.Snapshot {
+			snap := &hostsync.Snapshot{Host: host, ForbiddenApps: guardian.GetForbiddenApps()}
+			paused := false
+			srv.View(func(s *state.SystemState) {
+				paused = s.Pause != nil
+				snap.Locked = s.Compliance.Locked
+				snap.FailureScore = s.Compliance.FailureScore
+				snap.NetworkProfile = s.Network.Profile
+				snap.BlockedDomains = append([]string{}, s.Guardian.BlockedDomains...)
+			})
+			if paused {
+				return nil
+			}
+			return snap
+		},
+		Apply: func(m *hostsync.Snapshot) {
+			srv.Update(func(s *state.SystemState) { applySyncedSnapshot(s, m) })
+		},
+	}
+}
+
+func applySyncedSnapshot(s *state.SystemState, m *hostsync.Snapshot) {
+	if s.Pause != nil {
+		log.Printf("HostSync: enforcement paused, ignoring snapshot from %s", m.Host)
+		return
+	}
+	// 1. Blocklist: the merged list is the whole of it, less what another
+	// host unblocked.
+	wanted := make(map[string]bool)
+	for _, d := range m.BlockedDomains {
+		wanted[d] = true
+	}
+	known := make(map[string]bool)
+	for _, d := range s.Guardian.BlockedDomains {
+		known[strings.ToLower(d)] = true
+	}
+	var blockErrs []error
+	for _, d := range m.BlockedDomains {
+		if known[d] {
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] Would add synced domain to blocklist: %s", d)
+			s.Guardian.BlockedDomains = append(s.Guardian.BlockedDomains, d)
+			continue
+		}
+		if _, err := guardian.AddDomain(d); err != nil {
+			log.Printf("HostSync: failed to block %s: %v", d, err)
+			blockErrs = append(blockErrs, err)
+		}
+	}
+	for _, d := range slices.Clone(s.Guardian.BlockedDomains) {
+		if wanted[strings.ToLower(d)] {
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] Would remove synced domain from blocklist: %s", d)
+			s.Guardian.BlockedDomains = slices.DeleteFunc(s.Guardian.BlockedDomains, func(x string) bool { return x == d })
+			continue
+		}
+		if _, err := guardian.RemoveDomain(d); err != nil {
+			log.Printf("HostSync: failed to unblock %s: %v", d, err)
+			blockErrs = append(blockErrs, err)
+		}
+	}
+	if !dryRun {
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.RecordApply(errors.Join(blockErrs...))
+	}
+	s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+
+	// 2. Forbidden apps, likewise
+	if !dryRun {
+		for _, app := range m.ForbiddenApps {
+			if _, err := guardian.AddForbiddenApp(app); err != nil {
+				log.Printf("HostSync: failed to forbid %s: %v", app, err)
+			}
+		}
+		for _, app := range guardian.GetForbiddenApps() {
+			if slices.Contains(m.ForbiddenApps, strings.ToLower(app)) {
+				continue
+			}
+			if _, err := guardian.RemoveForbiddenApp(app); err != nil {
+				log.Printf("HostSync: failed to allow %s: %v", app, err)
+			}
+		}
+	}
+
+	// 3. Network profile.  As with an unlock here, the curfew keeps the
+	// network black-holed until wake time.
+	p := throttler.Profile(m.NetworkProfile)
+	if s.Curfew.Active && throttler.Severity(p) < throttler.Severity(throttler.Profile(s.Network.Profile)) {
+		log.Printf("HostSync: curfew in force, keeping %s over %s from %s", s.Network.Profile, p, m.Host)
+		p = throttler.Profile(s.Network.Profile)
+	}
+	if p != "" && string(p) != s.Network.Profile {
+		if !dryRun {
+			err := throttler.ApplyNetworkProfile(p)
+			s.Network.RecordApply(err)
+			if err != nil {
+				log.Printf("HostSync: failed to apply profile %s: %v", p, err)
+			}
+		} else {
+			log.Printf("[DRY-RUN] Would apply synced network profile: %s", p)
+		}
+		s.Network.Profile = string(p)
+		s.Network.PacketLossPct = 0
+	}
+
+	// 4. Compliance (score and lock).  A lockuntil deadline here holds
+	// against an unlock elsewhere, as it would against one here.
+	if cs, err := penance.LoadComplianceStatus(); err == nil {
+		locked := m.Locked
+		if _, held := cs.LockedUntil(); held && !locked {
+			log.Printf("HostSync: %s unlocked, but the lockuntil deadline here still holds", m.Host)
+			locked = true
+		}
+		if m.FailureScore != cs.FailureScore || locked != cs.Locked {
+			previous := cs.FailureScore
+			cs.FailureScore = m.FailureScore
+			cs.Locked = locked
+			if err := penance.SaveComplianceStatus(cs); err != nil {
+				log.Printf("HostSync: failed to save compliance: %v", err)
+			} else if previous != cs.FailureScore {
+				penance.RecordScoreChange(previous, cs.FailureScore, "sync:"+m.Host)
+			}
 		}
+		s.Compliance.Locked = cs.Locked
+		s.Compliance.FailureScore = cs.FailureScore
+		s.Compliance.TaskStatus = cs.TaskStatus
 	}
+
+	s.ChangedBy = "sync"
+	vexlog.LogEvent("SYNC", "STATE_MERGED",
+		fmt.Sprintf("host=%s score=%d locked=%v profile=%s domains=%d",
+			m.Host, s.Compliance.FailureScore, s.Compliance.Locked, s.Network.Profile, len(s.Guardian.BlockedDomains)))
 }
 
 // ═══════════════════════════════════════════════════════════════════
 // IPC command handlers — each mutates state + applies side-effects
 // ═══════════════════════════════════════════════════════════════════
 
+// loweringCommands can lift or weaken a restriction, or satisfy a task
+// that keeps one in place, so the daemon only takes them from a
+// known-good vex-cli.  A patched client could otherwise send them with
+// whatever arguments it liked.
+var loweringCommands = []string{
+	ipc.CmdThrottle, ipc.CmdCPU, ipc.CmdPriority, ipc.CmdLatency, ipc.CmdOOM, ipc.CmdUnlock,
+	ipc.CmdResetScore, ipc.CmdBlockRemove, ipc.CmdBlockPass, ipc.CmdAppRemove,
+	ipc.CmdPenanceInput, ipc.CmdLinesClear, ipc.CmdLinesSubmit, ipc.CmdInputLock,
+	ipc.CmdTypingFinish, ipc.CmdCurfewSet, ipc.CmdCurfewOverride, ipc.CmdEarlyRelease,
+	ipc.CmdAllowAdd, ipc.CmdScheduleRemove, ipc.CmdPause, ipc.CmdApprove,
+	ipc.CmdCreditsSpend, ipc.CmdBrightness, ipc.CmdVolume, ipc.CmdGPU, ipc.CmdConfigSet,
+	ipc.CmdScheduleAdd,
+}
+
 func registerHandlers(srv *ipc.Server) {
 	srv.Handle(ipc.CmdStatus, handleStatus)
 	srv.Handle(ipc.CmdState, handleState)
-	srv.Handle(ipc.CmdThrottle, handleThrottle)
-	srv.Handle(ipc.CmdCPU, handleCPU)
-	srv.Handle(ipc.CmdLatency, handleLatency)
-	srv.Handle(ipc.CmdOOM, handleOOM)
-	srv.Handle(ipc.CmdUnlock, handleUnlock)
-	srv.Handle(ipc.CmdCheck, handleCheck)
+	srv.Handle(ipc.CmdThrottle, unlessOff(subsystem.Throttler, unlessPaused(withExpiry(expiryThrottle, handleThrottle))))
+	srv.Handle(ipc.CmdCPU, unlessOff(subsystem.Throttler, unlessPaused(withExpiry(expiryCPU, handleCPU))))
+	srv.Handle(ipc.CmdLatency, unlessOff(subsystem.Surveillance, unlessPaused(withExpiry(expiryLatency, handleLatency))))
+	srv.Handle(ipc.CmdOOM, unlessOff(subsystem.Guardian, unlessPaused(handleOOM)))
+	srv.Handle(ipc.CmdPriority, unlessOff(subsystem.Throttler, unlessPaused(handlePriority)))
+	srv.Handle(ipc.CmdGPU, unlessOff(subsystem.Throttler, unlessPaused(handleGPU)))
+	srv.Handle(ipc.CmdFreeze, unlessOff(subsystem.Throttler, unlessPaused(handleFreeze)))
+	srv.Handle(ipc.CmdBrightness, unlessOff(subsystem.Media, unlessPaused(handleBrightness)))
+	srv.Handle(ipc.CmdVolume, unlessOff(subsystem.Media, unlessPaused(handleVolume)))
+	srv.Handle(ipc.CmdUnlock, withRelock(handleUnlock))
+	srv.Handle(ipc.CmdCheck, unlessOff(subsystem.AntiTamper, handleCheck))
+	srv.Handle(ipc.CmdReload, handleReload)
+	srv.Handle(ipc.CmdConfig, handleConfig)
+	srv.Handle(ipc.CmdConfigSet, handleConfigSet)
+	srv.Handle(ipc.CmdHealth, handleHealth)
+	srv.Handle(ipc.CmdVersion, handleVersion)
+	if handleClock != nil {
+		srv.Handle(ipc.CmdClock, handleClock)
+	}
 	srv.Handle(ipc.CmdResetScore, handleResetScore)
-	srv.Handle(ipc.CmdBlockAdd, handleBlockAdd)
-	srv.Handle(ipc.CmdBlockRemove, handleBlockRemove)
+	srv.Handle(ipc.CmdBlockAdd, unlessOff(subsystem.Guardian, unlessPaused(withExpiry(expiryBlock, handleBlockAdd))))
+	srv.Handle(ipc.CmdBlockRemove, unlessOff(subsystem.Guardian, unlessPaused(withExpiry(expiryBlock, handleBlockRemove))))
 	srv.Handle(ipc.CmdBlockList, handleBlockList)
-	srv.Handle(ipc.CmdAppAdd, handleAppAdd)
-	srv.Handle(ipc.CmdAppRemove, handleAppRemove)
+	srv.Handle(ipc.CmdBlockPass, unlessOff(subsystem.Guardian, unlessPaused(handleBlockPass)))
+	srv.Handle(ipc.CmdBlockMonitor, unlessOff(subsystem.Guardian, unlessPaused(handleBlockMonitor)))
+	srv.Handle(ipc.CmdAppAdd, unlessOff(subsystem.Guardian, unlessPaused(handleAppAdd)))
+	srv.Handle(ipc.CmdAppRemove, unlessOff(subsystem.Guardian, unlessPaused(handleAppRemove)))
 	srv.Handle(ipc.CmdAppList, handleAppList)
 	srv.Handle(ipc.CmdPenanceInput, handlePenanceInput)
+	srv.Handle(ipc.CmdPenanceFailed, handlePenanceFailed)
+	srv.Handle(ipc.CmdReport, handleReport)
+	srv.Handle(ipc.CmdAuditExport, handleAuditExport)
+	srv.Handle(ipc.CmdAuditVerify, handleAuditVerify)
 	srv.Handle(ipc.CmdLinesSet, handleLinesSet)
 	srv.Handle(ipc.CmdLinesClear, handleLinesClear)
 	srv.Handle(ipc.CmdLinesStatus, handleLinesStatus)
 	srv.Handle(ipc.CmdLinesSubmit, handleLinesSubmit)
+	srv.Handle(ipc.CmdLinesRejected, handleLinesRejected)
+	srv.Handle(ipc.CmdMetrics, handleMetrics)
+	srv.Handle(ipc.CmdInputLock, unlessOff(subsystem.Surveillance, unlessPaused(handleInputLock)))
+	srv.Handle(ipc.CmdUsage, handleUsage)
+	srv.Handle(ipc.CmdHistory, handleHistory)
+	srv.Handle(ipc.CmdScoreHistory, handleScoreHistory)
+	srv.Handle(ipc.CmdTypingStart, unlessOff(subsystem.Surveillance, handleTypingStart))
+	srv.Handle(ipc.CmdTypingStatus, handleTypingStatus)
+	srv.Handle(ipc.CmdTypingFinish, handleTypingFinish)
+	srv.Handle(ipc.CmdCurfew, handleCurfew)
+	srv.Handle(ipc.CmdCurfewSet, handleCurfewSet)
+	srv.Handle(ipc.CmdCurfewOverride, handleCurfewOverride)
+	srv.Handle(ipc.CmdLockUntil, unlessPaused(handleLockUntil))
+	srv.Handle(ipc.CmdEarlyRelease, handleEarlyRelease)
+	srv.Handle(ipc.CmdAllowList, handleAllowList)
+	srv.Handle(ipc.CmdAllowAdd, handleAllowAdd)
+	srv.Handle(ipc.CmdAllowRemove, handleAllowRemove)
+	srv.Handle(ipc.CmdScheduleAdd, handleScheduleAdd)
+	srv.Handle(ipc.CmdScheduleList, handleScheduleList)
+	srv.Handle(ipc.CmdScheduleRemove, handleScheduleRemove)
+	srv.Handle(ipc.CmdPause, handlePause)
+	srv.Handle(ipc.CmdResume, handleResume)
+	srv.Handle(ipc.CmdCalendar, handleCalendar)
+	srv.Handle(ipc.CmdExceptionRequest, handleExceptionRequest)
+	srv.Handle(ipc.CmdExceptionList, handleExceptionList)
+	srv.Handle(ipc.CmdExceptionCancel, handleExceptionCancel)
+	srv.Handle(ipc.CmdApprove, handleApprove)
+	srv.Handle(ipc.CmdCredits, handleCredits)
+	srv.Handle(ipc.CmdCreditsSpend, unlessPaused(handleCreditsSpend))
 }
 
 func handleStatus(s *state.SystemState, req *ipc.Request) *ipc.Response {
@@ -252,6 +1218,11 @@ func handleStatus(s *state.SystemState, req *ipc.Request) *ipc.Response {
 		s.Compliance.Locked = cs.Locked
 		s.Compliance.FailureScore = cs.FailureScore
 		s.Compliance.TaskStatus = cs.TaskStatus
+		s.Compliance.LockUntil = cs.LockUntil
+		s.Compliance.Failures = make(map[string]int, len(cs.Categories))
+		for c, t := range cs.Categories {
+			s.Compliance.Failures[c] = t.Count
+		}
 	}
 	return &ipc.Response{OK: true, State: s}
 }
@@ -270,10 +1241,15 @@ func handleThrottle(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	if err != nil {
 		return &ipc.Response{OK: false, Error: err.Error()}
 	}
+	if s.Curfew.Active && throttler.Severity(p) < throttler.Severity(throttler.ProfileBlackHole) {
+		return &ipc.Response{OK: false, Code: ipc.CodeLocked, Error: curfewRefusal(s)}
+	}
 
 	if !dryRun {
-		if err := throttler.ApplyNetworkProfile(p); err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to apply profile: %v", err)}
+		err := throttler.ApplyNetworkProfile(p)
+		s.Network.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to apply profile: %v", err)}
 		}
 	} else {
 		log.Printf("[DRY-RUN] Would apply network profile: %s", p)
@@ -295,8 +1271,10 @@ func handleCPU(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	}
 
 	if !dryRun {
-		if err := throttler.SetCPULimit(pct); err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to set CPU limit: %v", err)}
+		err := throttler.SetCPULimit(pct)
+		s.Compute.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to set CPU limit: %v", err)}
 		}
 	} else {
 		log.Printf("[DRY-RUN] Would set CPU limit: %d%%", pct)
@@ -315,19 +1293,47 @@ func handleLatency(s *state.SystemState, req *ipc.Request) *ipc.Response {
 		return &ipc.Response{OK: false, Error: err.Error()}
 	}
 
+	// Optional max_ms switches to jitter mode: each key press is delayed
+	// by a random amount in [ms, max_ms].
+	maxMs := 0
+	if _, ok := req.Args["max_ms"]; ok {
+		if maxMs, err = ipc.ParseIntArg(req.Args, "max_ms"); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if ms < 0 || maxMs < ms {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid jitter range %d-%dms", ms, maxMs)}
+		}
+		if maxMs == ms {
+			maxMs = 0
+		}
+	}
+
+	desc := fmt.Sprintf("%dms", ms)
+	if maxMs > 0 {
+		desc = fmt.Sprintf("%d-%dms (jitter)", ms, maxMs)
+	}
+
 	if !dryRun {
-		if err := surveillance.InjectLatency(ms); err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to inject latency: %v", err)}
+		var err error
+		if maxMs > 0 {
+			err = surveillance.InjectJitter(ms, maxMs)
+		} else {
+			err = surveillance.InjectLatency(ms)
+		}
+		s.Compute.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to inject latency: %v", err)}
 		}
 	} else {
-		log.Printf("[DRY-RUN] Would set input latency: %dms", ms)
+		log.Printf("[DRY-RUN] Would set input latency: %s", desc)
 	}
 
 	s.Compute.InputLatencyMs = ms
+	s.Compute.InputLatencyMaxMs = maxMs
 	s.ChangedBy = "cli"
-	vexlog.LogEvent("SURVEILLANCE", "LATENCY_CHANGED", fmt.Sprintf("latency=%dms, source=cli", ms))
+	vexlog.LogEvent("SURVEILLANCE", "LATENCY_CHANGED", fmt.Sprintf("latency=%s, source=cli", desc))
 
-	return &ipc.Response{OK: true, Message: fmt.Sprintf("Input latency set to %dms", ms), State: s}
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Input latency set to %s", desc), State: s}
 }
 
 func handleOOM(s *state.SystemState, req *ipc.Request) *ipc.Response {
@@ -337,8 +1343,10 @@ func handleOOM(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	}
 
 	if !dryRun {
-		if err := guardian.SetOOMScore(score); err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to set OOM score: %v", err)}
+		err := guardian.SetOOMScore(score)
+		s.Compute.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to set OOM score: %v", err)}
 		}
 	} else {
 		log.Printf("[DRY-RUN] Would set OOM score: %d", score)
@@ -348,51 +1356,241 @@ func handleOOM(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	s.ChangedBy = "cli"
 	vexlog.LogEvent("GUARDIAN", "OOM_CHANGED", fmt.Sprintf("oom_score=%d, source=cli", score))
 
-	return &ipc.Response{OK: true, Message: fmt.Sprintf("OOM score set to %d", score), State: s}
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("OOM score of the subject's processes set to %d", score), State: s}
 }
 
-func handleUnlock(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	// Check authorization — the CLI already validated the signed payload
-	// before sending the unlock command, so the daemon trusts it.
+// handlePriority lowers the priority of the target sessions' processes;
+// "normal" gives them back their own.
+func handlePriority(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	level := req.Args["level"]
+	if !throttler.ValidPriority(level) {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid priority %q (use %s)", level, strings.Join(throttler.PriorityLevels, ", "))}
+	}
+
+	if !dryRun {
+		err := throttler.SetPriority(level)
+		s.Compute.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to set priority: %v", err)}
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would set priority: %s", level)
+	}
+
+	s.Compute.Priority = ""
+	if level != throttler.PriorityNormal {
+		s.Compute.Priority = level
+	}
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("THROTTLER", "PRIORITY_CHANGED", fmt.Sprintf("priority=%s, source=cli", level))
+
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Priority of the target sessions set to %s", level), State: s}
+}
+
+// handleGPU caps the graphics cards at a percentage of their default
+// limit; 100 lifts the cap.  The gpu module applies it.
+func handleGPU(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	pct, err := ipc.ParseIntArg(req.Args, "percent")
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if pct < 1 || pct > 100 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("GPU cap must be 1-100%%, got %d", pct)}
+	}
+	if dryRun {
+		log.Printf("[DRY-RUN] Would cap the GPUs at %d%%", pct)
+	}
+
+	s.Compute.GPULimitPct = pct
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("THROTTLER", "GPU_CHANGED", fmt.Sprintf("gpu=%d%%, source=cli", pct))
+
+	msg := fmt.Sprintf("GPUs capped at %d%%", pct)
+	if pct == 100 {
+		msg = "GPU cap lifted"
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+// handleBrightness caps the backlight; 100 lifts the cap.  The media
+// module applies the change from the state.
+func handleBrightness(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	pct, err := ipc.ParseIntArg(req.Args, "percent")
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if pct < 1 || pct > 100 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("brightness cap must be 1-100%%, got %d", pct)}
+	}
+	if dryRun {
+		log.Printf("[DRY-RUN] Would cap brightness at %d%%", pct)
+	}
+
+	s.Media.BrightnessPct = pct
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("MEDIA", "BRIGHTNESS_CHANGED", fmt.Sprintf("brightness=%d%%, source=cli", pct))
+
+	msg := fmt.Sprintf("Brightness capped at %d%%", pct)
+	if pct == 100 {
+		msg = "Brightness cap lifted"
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+// handleVolume caps the audio output at a percentage, 100 lifting the
+// cap and unmuting, or mutes it.
+func handleVolume(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	var msg string
+	if req.Args["level"] == "mute" {
+		s.Media.Muted = true
+		msg = "Audio muted"
+	} else {
+		pct, err := ipc.ParseIntArg(req.Args, "level")
+		if err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if pct < 0 || pct > 100 {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("volume cap must be 0-100%% or mute, got %d", pct)}
+		}
+		s.Media.VolumePct = pct
+		s.Media.Muted = pct == 0
+		msg = fmt.Sprintf("Volume capped at %d%%", pct)
+		switch pct {
+		case 0:
+			msg = "Audio muted"
+		case 100:
+			msg = "Volume cap lifted"
+		}
+	}
+	if dryRun {
+		log.Printf("[DRY-RUN] Would apply audio cap: %s", msg)
+	}
+
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("MEDIA", "VOLUME_CHANGED", fmt.Sprintf("volume=%d%%, muted=%v, source=cli", s.Media.VolumePct, s.Media.Muted))
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+func handleUnlock(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	// Check authorization — the CLI already validated the signed payload
+	// before sending the unlock command, so the daemon trusts it.
+
+	// A lockuntil deadline holds regardless of completed tasks; only the
+	// deadline itself or a signed early-release ends it.
+	if cs, err := penance.LoadComplianceStatus(); err == nil {
+		if until, ok := cs.LockedUntil(); ok {
+			return &ipc.Response{OK: false, Code: ipc.CodeLocked, Error: fmt.Sprintf(
+				"locked until %s (%s left); early release requires a signed early-release",
+				until.Local().Format("Mon 15:04"), clock.Until(until).Round(time.Minute))}
+		}
+	}
+
+	if s.Relock != nil {
+		// A permanent unlock supersedes a temporary one.
+		vexlog.LogEvent("SYSTEM", "RELOCK_CANCELLED", "superseded by unlock; was due "+s.Relock.Until)
+		s.Relock = nil
+	}
+
+	unlockDetails := map[string]string{}
+	if until := req.Args["until"]; until != "" {
+		unlockDetails["until"] = until
+	}
+	notify.Keyholder("unlock", unlockDetails)
+
+	if s.Pause != nil {
+		return unlockPaused(s)
+	}
+
+	// An unlock lifts penance, not the curfew: the network stays
+	// black-holed until wake time, which then restores standard.
+	restored := throttler.ProfileStandard
+	if s.Curfew.Active {
+		restored = throttler.ProfileBlackHole
+		s.Curfew.SavedProfile = string(throttler.ProfileStandard)
+		s.Curfew.SavedLossPct = 0
+	}
 
 	if !dryRun {
 		// 1. Restore network
-		if err := throttler.ApplyNetworkProfile(throttler.ProfileStandard); err != nil {
-			log.Printf("Unlock: failed to restore network: %v", err)
+		netErr := throttler.ApplyNetworkProfile(restored)
+		if netErr != nil {
+			log.Printf("Unlock: failed to restore network: %v", netErr)
 		}
+		s.Network.RecordApply(netErr)
+
+		var computeErrs []error
 		// 2. Restore CPU
 		if err := throttler.SetCPULimit(100); err != nil {
 			log.Printf("Unlock: failed to restore CPU: %v", err)
+			computeErrs = append(computeErrs, err)
+		}
+		if err := throttler.SetPriority(throttler.PriorityNormal); err != nil {
+			log.Printf("Unlock: failed to restore priority: %v", err)
+			computeErrs = append(computeErrs, err)
 		}
 		// 3. Restore OOM
 		if err := guardian.SetOOMScore(0); err != nil {
 			log.Printf("Unlock: failed to restore OOM: %v", err)
+			computeErrs = append(computeErrs, err)
+		}
+		// 4. Remove latency, any input blackout and key suppression
+		surveillance.EndInputBlackout(false)
+		if err := surveillance.SetKeySuppression(false); err != nil {
+			log.Printf("Unlock: failed to lift key suppression: %v", err)
+			computeErrs = append(computeErrs, err)
 		}
-		// 4. Remove latency
 		if err := surveillance.InjectLatency(0); err != nil {
 			log.Printf("Unlock: failed to remove latency: %v", err)
+			computeErrs = append(computeErrs, err)
 		}
+		s.Compute.RecordApply(errors.Join(computeErrs...))
+
 		// 5. Clear firewall
-		if err := guardian.ClearFirewall(); err != nil {
-			log.Printf("Unlock: failed to clear firewall: %v", err)
+		fwErr := guardian.ClearFirewall()
+		if fwErr != nil {
+			log.Printf("Unlock: failed to clear firewall: %v", fwErr)
 		}
+		s.Guardian.RecordApply(fwErr)
 	} else {
 		log.Println("[DRY-RUN] Would restore all restrictions to defaults")
 	}
+	// Score the interactive penance session that led to this unlock
+	if surveillance.TypingSampleActive() {
+		match := surveillance.EndTypingSample()
+		vexlog.LogEvent("PENANCE", "TYPING_MATCH", typingMatchDetails(match))
+		reportTypingAnomaly("PENANCE", match)
+	}
+
 	// 5. Persist completion
 	if err := penance.RecordCompletion(); err != nil {
 		log.Printf("Unlock: failed to persist completion: %v", err)
 	}
 
 	// Update state
-	s.Network.Profile = string(throttler.ProfileStandard)
+	s.Network.Profile = string(restored)
 	s.Network.PacketLossPct = 0
 	s.Compute.CPULimitPct = 100
+	s.Compute.Priority = ""
+	s.Compute.GPULimitPct = 0
 	s.Compute.OOMScoreAdj = 0
 	s.Compute.InputLatencyMs = 0
+	s.Compute.InputLatencyMaxMs = 0
+	s.Compute.InputLockUntil = ""
+	s.Media = state.MediaState{}
 	s.Guardian.FirewallEnabled = false
 	s.Guardian.BlockedDomains = []string{}
+	s.Guardian.Monitored = nil
+	guardian.SetMonitoredDomains(nil)
 	s.Compliance.Locked = false
+	s.Expiries = nil
+	s.Exceptions = nil // nothing left to except; a pending timer finds none
+	// The calendar layer is lifted too until its next transition; only
+	// its apps, which unlock leaves alone, are still tracked.
+	s.Calendar.AddedDomains = nil
+	s.Calendar.SavedProfile, s.Calendar.AppliedProfile = "", ""
+	s.Calendar.SavedCPU, s.Calendar.AppliedCPU = 0, 0
+	endFreeze(s, "unlock")
+	releaseUSB()
 	s.ChangedBy = "unlock"
 
 	vexlog.LogEvent("SYSTEM", "RESTRICTIONS_LIFTED", "All restrictions removed and persisted")
@@ -407,7 +1605,7 @@ func handleUnlock(s *state.SystemState, req *ipc.Request) *ipc.Response {
 func handleResetScore(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	cs, err := penance.LoadComplianceStatus()
 	if err != nil {
-		return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to load compliance: %v", err)}
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to load compliance: %v", err)}
 	}
 
 	previous := cs.FailureScore
@@ -415,12 +1613,13 @@ func handleResetScore(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	cs.TotalFailures = 0
 
 	if err := penance.SaveComplianceStatus(cs); err != nil {
-		return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to save compliance: %v", err)}
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to save compliance: %v", err)}
 	}
 
 	s.Compliance.FailureScore = 0
 	s.ChangedBy = "cli"
 
+	penance.RecordScoreChange(previous, 0, "reset")
 	vexlog.LogEvent("PENANCE", "SCORE_RESET", fmt.Sprintf("score %d -> 0", previous))
 
 	return &ipc.Response{
@@ -432,7 +1631,7 @@ func handleResetScore(s *state.SystemState, req *ipc.Request) *ipc.Response {
 
 func handleCheck(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	if err := antitamper.RunAllChecks(); err != nil {
-		return &ipc.Response{OK: false, Error: fmt.Sprintf("INTEGRITY CHECK FAILED: %v", err)}
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("INTEGRITY CHECK FAILED: %v", err)}
 	}
 	return &ipc.Response{OK: true, Message: "All integrity checks PASSED."}
 }
@@ -442,14 +1641,19 @@ func handleBlockAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	if !ok || domain == "" {
 		return &ipc.Response{OK: false, Error: "missing 'domain' argument"}
 	}
+	domain, err := guardian.NormalizeEntry(domain)
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
 
 	if !dryRun {
 		added, err := guardian.AddDomain(domain)
+		s.Guardian.RecordApply(err)
 		if err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to add domain: %v", err)}
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to add domain: %v", err)}
 		}
 		if !added {
-			return &ipc.Response{OK: true, Message: fmt.Sprintf("Domain '%s' is already blocked", domain), State: s}
+			return &ipc.Response{OK: true, Message: fmt.Sprintf("'%s' is already blocked", domain), State: s}
 		}
 	} else {
 		log.Printf("[DRY-RUN] Would add domain to blocklist: %s", domain)
@@ -460,7 +1664,7 @@ func handleBlockAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	s.ChangedBy = "cli"
 	vexlog.LogEvent("GUARDIAN", "DOMAIN_BLOCKED", fmt.Sprintf("domain=%s, source=cli", domain))
 
-	return &ipc.Response{OK: true, Message: fmt.Sprintf("Domain blocked: %s", domain), State: s}
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Blocked: %s", domain), State: s}
 }
 
 func handleBlockRemove(s *state.SystemState, req *ipc.Request) *ipc.Response {
@@ -469,10 +1673,25 @@ func handleBlockRemove(s *state.SystemState, req *ipc.Request) *ipc.Response {
 		return &ipc.Response{OK: false, Error: "missing 'domain' argument"}
 	}
 
+	// A monitored domain stops being monitored, and is unblocked if its
+	// count blocked it.
+	if i := monitoredIndex(s, strings.ToLower(strings.TrimSpace(domain))); i >= 0 {
+		blocked := s.Guardian.Monitored[i].Blocked
+		s.Guardian.Monitored = slices.Delete(s.Guardian.Monitored, i, i+1)
+		guardian.SetMonitoredDomains(monitoredNames(s))
+		armMonitorDay(s)
+		s.ChangedBy = "cli"
+		vexlog.LogEvent("GUARDIAN", "DOMAIN_UNMONITORED", fmt.Sprintf("domain=%s, source=cli", domain))
+		if !blocked {
+			return &ipc.Response{OK: true, Message: fmt.Sprintf("No longer monitored: %s", domain), State: s}
+		}
+	}
+
 	if !dryRun {
 		removed, err := guardian.RemoveDomain(domain)
+		s.Guardian.RecordApply(err)
 		if err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to remove domain: %v", err)}
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to remove domain: %v", err)}
 		}
 		if !removed {
 			return &ipc.Response{OK: true, Message: fmt.Sprintf("Domain '%s' is not in the blocklist", domain), State: s}
@@ -498,6 +1717,199 @@ func handleBlockList(s *state.SystemState, req *ipc.Request) *ipc.Response {
 // suppress unused import lint for strings (used by log formatting)
 var _ = strings.TrimSpace
 
+// ── Health ──────────────────────────────────────────────────────────
+
+// handleHealth reports the supervised background workers: the listeners,
+// refresh loops and periodic checks that are restarted when they fail.
+func handleHealth(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	workers := supervisor.Status()
+	health := make([]ipc.WorkerHealth, len(workers))
+	restarting := 0
+	for i, w := range workers {
+		health[i] = ipc.WorkerHealth{
+			Name:        w.Name,
+			State:       w.State,
+			Since:       w.Since,
+			Restarts:    w.Restarts,
+			LastError:   w.LastError,
+			LastFailure: w.LastFailure,
+		}
+		if w.State == supervisor.Restarting {
+			restarting++
+		}
+	}
+	msg := fmt.Sprintf("%d workers, %d restarting", len(health), restarting)
+	return &ipc.Response{OK: true, Message: msg, Health: health}
+}
+
+// ── Version ─────────────────────────────────────────────────────────
+
+func handleVersion(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	info := buildinfo.Get()
+	return &ipc.Response{OK: true, Message: "vexd " + info.String(), Build: &info}
+}
+
+// ── Reload ──────────────────────────────────────────────────────────
+
+// handleReload re-reads config.json, blocked-domains.json,
+// forbidden-apps.json and the penance manifest, and applies only what
+// changed in them, so enforcement is never lifted on the way.  SIGHUP
+// dispatches it too.
+func handleReload(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	var changes, failures []string
+
+	settings, pending, err := config.Reload()
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("config: %v", err))
+	}
+	if slices.Contains(settings, "guardian.dns_refresh_minutes") {
+		guardian.ResetDNSRefresh()
+	}
+	if len(settings) > 0 {
+		changes = append(changes, "config "+strings.Join(settings, ", "))
+	}
+
+	if !dryRun {
+		lists, err := guardian.ReloadLists()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("blocked domains: %v", err))
+		}
+		if len(lists.DomainsAdded)+len(lists.DomainsRemoved) > 0 {
+			s.Guardian.RecordApply(err)
+			s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+			s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+			changes = append(changes, "domains"+listDelta(lists.DomainsAdded, lists.DomainsRemoved))
+		}
+		if len(lists.AppsAdded)+len(lists.AppsRemoved) > 0 {
+			changes = append(changes, "apps"+listDelta(lists.AppsAdded, lists.AppsRemoved))
+		}
+
+		enforced, err := penance.ReloadManifest(s.Compliance.Locked && s.Pause == nil)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("manifest: %v", err))
+		}
+		checkSessionLocks()
+		if len(enforced) > 0 {
+			syncReloadedOverrides(s, enforced, err)
+			changes = append(changes, "manifest "+strings.Join(enforced, ", "))
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would reload blocked domains, forbidden apps and the penance manifest")
+	}
+
+	if len(changes) > 0 {
+		s.ChangedBy = "reload"
+	}
+	details := strings.Join(changes, "; ")
+	if details == "" {
+		details = "no changes"
+	}
+	vexlog.LogEvent("DAEMON", "RELOADED", details)
+
+	msg := "Reloaded: " + details
+	if len(pending) > 0 {
+		msg += fmt.Sprintf("; restart vexd to apply %s", strings.Join(pending, ", "))
+	}
+	if len(failures) > 0 {
+		vexlog.LogEvent("DAEMON", "RELOAD_FAILED", strings.Join(failures, "; "))
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: msg + "; failed: " + strings.Join(failures, "; "), State: s}
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+// handleConfig shows every setting in effect, one "name = value" per
+// line, or with "name" the value of that one.
+func handleConfig(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	c := config.Current()
+	if name := req.Args["name"]; name != "" {
+		v, err := c.Setting(name)
+		if err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		return &ipc.Response{OK: true, Message: v}
+	}
+	var lines []string
+	for _, name := range config.Names() {
+		v, _ := c.Setting(name)
+		lines = append(lines, name+" = "+v)
+	}
+	return &ipc.Response{OK: true, Message: strings.Join(lines, "\n")}
+}
+
+// handleConfigSet changes one setting in config.json, or takes it out
+// with an empty value, and reloads.  The CLI has already verified the
+// signed payload.
+func handleConfigSet(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	name, value := req.Args["name"], req.Args["value"]
+	if name == "" {
+		return &ipc.Response{OK: false, Error: "missing 'name' argument"}
+	}
+	if err := config.Set(name, value); err != nil {
+		resp := &ipc.Response{OK: false, Error: err.Error()}
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
+			resp.Code = ipc.CodeFailed
+		}
+		return resp
+	}
+	if value == "" {
+		value = "(default)"
+	}
+	vexlog.LogEvent("DAEMON", "CONFIG_SET", fmt.Sprintf("%s=%s", name, value))
+	return handleReload(s, req)
+}
+
+// listDelta formats the entries added to and removed from a list, e.g.
+// " +reddit.com -twitch.tv".
+func listDelta(added, removed []string) string {
+	var b strings.Builder
+	for _, v := range added {
+		b.WriteString(" +" + v)
+	}
+	for _, v := range removed {
+		b.WriteString(" -" + v)
+	}
+	return b.String()
+}
+
+// syncReloadedOverrides records the manifest overrides that a reload
+// enforced (err is the result), leaving the other fields as they are.
+func syncReloadedOverrides(s *state.SystemState, enforced []string, err error) {
+	o := penance.CurrentManifest.Overrides
+	for _, name := range enforced {
+		switch name {
+		case "network":
+			s.Network.RecordApply(err)
+			s.Network.Profile = o.Network.Profile
+			s.Network.PacketLossPct = float32(o.Network.PacketLoss)
+			if s.Curfew.Active {
+				s.Curfew.SavedProfile = s.Network.Profile
+				setCurfew(s, true)
+			}
+		case "cpu":
+			s.Compute.RecordApply(err)
+			s.Compute.CPULimitPct = o.Compute.CPULimit
+		case "priority":
+			s.Compute.RecordApply(err)
+			s.Compute.Priority = o.Compute.Priority
+		case "gpu":
+			s.Compute.GPULimitPct = o.Compute.GPULimit
+		case "oom":
+			s.Compute.RecordApply(err)
+			s.Compute.OOMScoreAdj = o.Compute.OOMScoreAdj
+		case "media":
+			s.Media = mediaOverrides(penance.CurrentManifest)
+		case "latency":
+			s.Compute.RecordApply(err)
+			minLat, maxLat := surveillance.GetInputLatencyRange()
+			s.Compute.InputLatencyMs = int(minLat / time.Millisecond)
+			s.Compute.InputLatencyMaxMs = 0
+			if maxLat > minLat {
+				s.Compute.InputLatencyMaxMs = int(maxLat / time.Millisecond)
+			}
+		}
+	}
+}
+
 // ── Forbidden-app handlers ──────────────────────────────────────────
 
 func handleAppAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
@@ -509,7 +1921,7 @@ func handleAppAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	if !dryRun {
 		added, err := guardian.AddForbiddenApp(app)
 		if err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to add app: %v", err)}
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to add app: %v", err)}
 		}
 		if !added {
 			return &ipc.Response{OK: true, Message: fmt.Sprintf("App '%s' is already in the forbidden list", app), State: s}
@@ -529,11 +1941,14 @@ func handleAppRemove(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	if !ok || app == "" {
 		return &ipc.Response{OK: false, Error: "missing 'app' argument"}
 	}
+	if s.Curfew.Active && containsFold(s.Curfew.AddedApps, app) {
+		return &ipc.Response{OK: false, Code: ipc.CodeLocked, Error: curfewRefusal(s)}
+	}
 
 	if !dryRun {
 		removed, err := guardian.RemoveForbiddenApp(app)
 		if err != nil {
-			return &ipc.Response{OK: false, Error: fmt.Sprintf("failed to remove app: %v", err)}
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to remove app: %v", err)}
 		}
 		if !removed {
 			return &ipc.Response{OK: true, Message: fmt.Sprintf("App '%s' is not in the forbidden list", app), State: s}
@@ -561,124 +1976,4661 @@ func handleAppList(s *state.SystemState, req *ipc.Request) *ipc.Response {
 	return &ipc.Response{OK: true, Message: msg, State: s}
 }
 
-// ── Penance input handler ───────────────────────────────────────────
+// ── Surveillance metrics handler ────────────────────────────────────
 
-func handlePenanceInput(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	line := req.Args["line"]
-	num := req.Args["num"]
+func handleMetrics(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	keystrokes, lines := surveillance.GetMetricSnapshot()
+	kpm1, kpm5 := surveillance.GetRollingKPM()
 
-	vexlog.LogEvent("PENANCE", "INPUT_RECEIVED",
-		fmt.Sprintf("line_num=%s words=%d content=%q", num, len(strings.Fields(line)), line))
+	minLat, maxLat := surveillance.GetInputLatencyRange()
+	m := &ipc.SurveillanceMetrics{
+		Keystrokes:     keystrokes,
+		LinesCompleted: lines,
+		KPM1m:          kpm1,
+		KPM5m:          kpm5,
+		Devices:        surveillance.GetMonitoredDevices(),
+		InputLatencyMs: int(minLat / time.Millisecond),
+		Since:          surveillance.GetStartTime().UTC().Format(time.RFC3339),
+	}
+	if maxLat > minLat {
+		m.InputLatencyMax = int(maxLat / time.Millisecond)
+	}
+	if w, ok := surveillance.GetActiveWindow(); ok {
+		m.ActiveApp = w.App
+	}
+	return &ipc.Response{OK: true, Metrics: m}
+}
+
+// ── Screen-time handler and rules ───────────────────────────────────
+
+func handleUsage(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	days := 1
+	switch req.Args["range"] {
+	case "", "today":
+	case "week":
+		days = 7
+	default:
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("unknown range %q (use today or week)", req.Args["range"])}
+	}
 
-	return &ipc.Response{OK: true, Message: fmt.Sprintf("Line %s logged", num)}
+	var out []ipc.UsageDay
+	for _, d := range surveillance.GetDailyUsage(days) {
+		out = append(out, ipc.UsageDay{
+			Date:          d.Date,
+			ActiveSeconds: d.ActiveSeconds,
+			Keystrokes:    d.Keystrokes,
+			Apps:          d.Apps,
+			Sites:         d.Sites,
+			Network:       d.Network,
+		})
+	}
+	return &ipc.Response{OK: true, Usage: out}
 }
 
-// ── Writing-lines handlers ──────────────────────────────────────────
+// usageRuleInterval is how often usage rules are evaluated.
+const usageRuleInterval = time.Minute
 
-func handleLinesSet(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	phrase, ok := req.Args["phrase"]
-	if !ok || phrase == "" {
-		return &ipc.Response{OK: false, Error: "missing 'phrase' argument"}
+// budgetWarning is how much of a rule's limit is left when budget_low is
+// sent.
+const budgetWarning = 10 * time.Minute
+
+// usageRuleLoop fires each rule in /etc/vex-cli/usage-rules.json at most
+// once per day when today's usage exceeds its limit, after warning with
+// budget_low as the limit approaches.  Rules are re-read every tick so
+// edits take effect without a restart.
+func usageRuleLoop(srv *ipc.Server) error {
+	warned := make(map[string]bool)
+	ticker := time.NewTicker(usageRuleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rules, err := surveillance.LoadUsageRules()
+		if err != nil {
+			log.Printf("Usage rules: %v", err)
+			continue
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		today := surveillance.GetDailyUsage(1)[0]
+		for _, r := range rules {
+			if r.Exceeded(today) && surveillance.MarkUsageRuleTriggered(r.Name) {
+				srv.Update(func(s *state.SystemState) { applyUsageRule(s, r, today) })
+			}
+			left := r.Remaining(today)
+			if key := today.Date + "|" + r.Name; r.MaxMinutes > 0 && left > 0 && left <= budgetWarning && !warned[key] {
+				warned[key] = true
+				notify.Keyholder("budget_low", map[string]string{
+					"rule":  r.Name,
+					"left":  fmt.Sprintf("%dm", int(left.Round(time.Minute).Minutes())),
+					"limit": fmt.Sprintf("%dm", r.MaxMinutes),
+				})
+			}
+		}
 	}
-	count, err := ipc.ParseIntArg(req.Args, "count")
-	if err != nil {
-		return &ipc.Response{OK: false, Error: err.Error()}
+	return nil
+}
+
+// applyUsageRule imposes a rule's penalty.  Like sync, a rule only ever
+// escalates the network profile.
+func applyUsageRule(s *state.SystemState, r surveillance.UsageRule, today surveillance.DayUsage) {
+	usage := fmt.Sprintf("used=%s limit=%dm", r.Usage(today).Round(time.Minute), r.MaxMinutes)
+	if r.OverTraffic(today) {
+		usage = fmt.Sprintf("used=%dMB limit=%dMB", r.Traffic(today)>>20, r.MaxMB)
 	}
-	if count < 1 || count > 10000 {
-		return &ipc.Response{OK: false, Error: "count must be between 1 and 10000"}
+	if s.Pause != nil {
+		vexlog.LogEvent("SURVEILLANCE", "USAGE_LIMIT_EXCEEDED",
+			fmt.Sprintf("rule=%q %s ignored=paused", r.Name, usage))
+		return
 	}
+	vexlog.LogEvent("SURVEILLANCE", "USAGE_LIMIT_EXCEEDED",
+		fmt.Sprintf("rule=%q %s profile=%s", r.Name, usage, r.Profile))
 
-	s.Writing = state.WritingTask{
-		Active:    true,
-		Phrase:    phrase,
-		Required:  count,
-		Completed: 0,
+	if r.Profile != "" {
+		p, err := throttler.ResolveProfile(r.Profile)
+		if err != nil {
+			log.Printf("Usage rules: %s: %v", r.Name, err)
+		} else if throttler.Severity(p) > throttler.Severity(throttler.Profile(s.Network.Profile)) {
+			err := throttler.ApplyNetworkProfile(p)
+			s.Network.RecordApply(err)
+			if err != nil {
+				log.Printf("Usage rules: failed to apply profile %s: %v", p, err)
+			}
+			s.Network.Profile = string(p)
+			s.Network.PacketLossPct = 0
+		}
 	}
-	s.ChangedBy = "cli"
-	vexlog.LogEvent("WRITING", "TASK_SET", fmt.Sprintf("phrase=%q count=%d", phrase, count))
 
-	return &ipc.Response{
-		OK:      true,
-		Message: fmt.Sprintf("Writing task set: %q x %d", phrase, count),
-		State:   s,
+	if r.RecordFailure {
+		if err := penance.RecordFailure("usage_limit:" + r.Name); err != nil {
+			log.Printf("Usage rules: failed to record failure: %v", err)
+		}
+		if cs, err := penance.LoadComplianceStatus(); err == nil {
+			s.Compliance.Locked = cs.Locked
+			s.Compliance.FailureScore = cs.FailureScore
+			s.Compliance.TaskStatus = cs.TaskStatus
+		}
 	}
+
+	s.ChangedBy = "usage"
 }
 
-func handleLinesClear(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	wasActive := s.Writing.Active
-	s.Writing = state.WritingTask{}
-	s.ChangedBy = "cli"
+// ── Uplink watch ────────────────────────────────────────────────────
 
-	if wasActive {
-		vexlog.LogEvent("WRITING", "TASK_CLEARED", "task cancelled by CLI")
-		return &ipc.Response{OK: true, Message: "Writing task cleared.", State: s}
+// uplinkMoved moves the shaping to the interface the default route now
+// uses, such as a tethered phone, and applies the network state there.
+// The firewall needs nothing: its rules match on the way out, whatever
+// the interface.
+func uplinkMoved(srv *ipc.Server) func(from, to string) {
+	return func(from, to string) {
+		srv.Update(func(s *state.SystemState) {
+			log.Printf("Throttler: Default route moved from %s to %s", from, to)
+			throttler.MoveInterface(to)
+			applyNetworkState(s)
+			vexlog.LogEvent("THROTTLER", "UPLINK_MOVED", fmt.Sprintf("from=%s to=%s profile=%s", from, to, s.Network.Profile))
+		})
 	}
-	return &ipc.Response{OK: true, Message: "No active writing task.", State: s}
 }
 
-func handleLinesStatus(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	return &ipc.Response{OK: true, State: s}
-}
+// ── Tunnel watch ────────────────────────────────────────────────────
 
-func handleLinesSubmit(s *state.SystemState, req *ipc.Request) *ipc.Response {
-	if !s.Writing.Active {
-		return &ipc.Response{OK: false, Error: "no active writing task"}
-	}
+// tunnelDetected responds to a tunnel or proxy the guardian found, as
+// guardian.TunnelResponse says, if domains are being blocked.  Otherwise it
+// reports the tunnel unhandled, so that it is looked at again.
+func tunnelDetected(srv *ipc.Server) func(guardian.Tunnel) bool {
+	return func(t guardian.Tunnel) bool {
+		var active bool
+		srv.View(func(s *state.SystemState) { active = s.Guardian.FirewallEnabled })
+		if !active {
+			return false
+		}
 
-	line, ok := req.Args["line"]
-	if !ok {
-		return &ipc.Response{OK: false, Error: "missing 'line' argument"}
+		var response []string
+		srv.Update(func(s *state.SystemState) {
+			log.Printf("Guardian: Tunnel detected while blocks are in force: %s", t)
+			for _, r := range guardian.TunnelResponse {
+				switch r {
+				case guardian.TunnelBlock:
+					err := guardian.BlockTunnel(t)
+					s.Guardian.RecordApply(err)
+					if err != nil {
+						log.Printf("Guardian: failed to block tunnel %s: %v", t, err)
+						continue
+					}
+				case guardian.TunnelEscalate:
+					if err := penance.RecordFailure("tunnel:" + t.Target()); err != nil {
+						log.Printf("Guardian: failed to record failure: %v", err)
+						continue
+					}
+					if cs, err := penance.LoadComplianceStatus(); err == nil {
+						s.Compliance.Locked = cs.Locked
+						s.Compliance.FailureScore = cs.FailureScore
+						s.Compliance.TaskStatus = cs.TaskStatus
+					}
+				}
+				response = append(response, r)
+			}
+			s.ChangedBy = "guardian"
+		})
+		events.Publish(events.TunnelDetected{Target: t.Target(), Detail: t.Detail, Response: response})
+		return true
 	}
+}
 
-	// Normalize and compare (case-sensitive, trimmed)
-	line = strings.TrimSpace(line)
-	expected := strings.TrimSpace(s.Writing.Phrase)
+// ── Metrics history ─────────────────────────────────────────────────
 
-	if line != expected {
-		vexlog.LogEvent("WRITING", "LINE_REJECTED", fmt.Sprintf("got=%q expected=%q", line, expected))
-		return &ipc.Response{
-			OK:    false,
-			Error: fmt.Sprintf("Line does not match. Expected: %q", expected),
+// killCount counts kills since startup, for the history samples.
+var killCount atomic.Int64
+
+// historyLoop records a history sample every history.Interval: the
+// score, lock and profile now, and the kills, screen time and keystrokes
+// since the last sample.
+func historyLoop(srv *ipc.Server) error {
+	prev := surveillance.GetDailyUsage(1)[0]
+	var prevKills int64
+	ticker := time.NewTicker(history.Interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		today := surveillance.GetDailyUsage(1)[0]
+		kills := killCount.Load()
+		sample := history.Sample{
+			Time:          now.Truncate(time.Second),
+			Kills:         int(kills - prevKills),
+			ActiveSeconds: today.ActiveSeconds,
+			Keystrokes:    today.Keystrokes,
+		}
+		if today.Date == prev.Date && today.Keystrokes >= prev.Keystrokes {
+			sample.ActiveSeconds -= prev.ActiveSeconds
+			sample.Keystrokes -= prev.Keystrokes
+		}
+		prev, prevKills = today, kills
+		srv.View(func(s *state.SystemState) {
+			sample.Locked = s.Compliance.Locked
+			sample.Score = s.Compliance.FailureScore
+			sample.Profile = s.Network.Profile
+		})
+		if err := history.Append(sample); err != nil {
+			log.Printf("History: failed to record a sample: %v", err)
 		}
 	}
+	return nil
+}
 
-	s.Writing.Completed++
-	s.ChangedBy = "cli"
-	remaining := s.Writing.Required - s.Writing.Completed
+func handleHistory(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	now := clock.Now()
+	from, buckets, err := history.ParseRange(req.Args["range"], now)
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	samples, err := history.Read(from, now)
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to read history: %v", err)}
+	}
+	var out []ipc.HistoryPoint
+	for _, p := range history.Bucket(samples, from, now, buckets) {
+		out = append(out, ipc.HistoryPoint{
+			Start:         p.Start.Format(time.RFC3339),
+			Samples:       p.Samples,
+			Score:         p.Score,
+			LockedPct:     p.LockedPct,
+			Profile:       p.Profile,
+			Kills:         p.Kills,
+			ActiveSeconds: p.ActiveSeconds,
+			Keystrokes:    p.Keystrokes,
+		})
+	}
+	return &ipc.Response{OK: true, History: out}
+}
 
-	// Mark the compliance task as in-progress on first accepted line
-	if err := penance.MarkInProgress(); err != nil {
-		log.Printf("LinesSubmit: failed to mark in-progress: %v", err)
+// handleScoreHistory returns the score changes of the last day, week or
+// month, or of all time for range "all".
+func handleScoreHistory(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	var from time.Time
+	if rng := req.Args["range"]; rng != "all" {
+		var err error
+		if from, _, err = history.ParseRange(rng, clock.Now()); err != nil {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("unknown range %q (use day, week, month or all)", rng)}
+		}
+	}
+	changes, err := penance.ReadScoreChanges(from)
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to read score history: %v", err)}
 	}
+	resp := &ipc.Response{OK: true, State: s}
+	for _, c := range changes {
+		resp.Scores = append(resp.Scores, ipc.ScoreChange{
+			Time:  c.Time.Format(time.RFC3339),
+			From:  c.From,
+			To:    c.To,
+			Cause: c.Cause,
+		})
+	}
+	return resp
+}
 
-	if remaining <= 0 {
-		// Task complete!
-		vexlog.LogEvent("WRITING", "TASK_COMPLETED",
-			fmt.Sprintf("phrase=%q required=%d", s.Writing.Phrase, s.Writing.Required))
-		s.Writing = state.WritingTask{}
+// ── Input blackout handler ──────────────────────────────────────────
 
-		// Update compliance status to completed
-		if err := penance.RecordCompletion(); err != nil {
-			log.Printf("LinesSubmit: failed to record completion: %v", err)
-		}
-		s.Compliance.Locked = false
-		s.Compliance.TaskStatus = "completed"
+// maxInputLock bounds a single blackout so a typo can't lock the keyboard
+// for days.
+const maxInputLock = 24 * time.Hour
 
-		return &ipc.Response{
-			OK:      true,
-			Message: "Writing task COMPLETE. Well done.",
-			State:   s,
-		}
+func handleInputLock(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	d, err := time.ParseDuration(req.Args["duration"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid duration %q (e.g. 10m, 1h30m)", req.Args["duration"])}
+	}
+	if d < time.Second || d > maxInputLock {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("duration must be between 1s and %s", maxInputLock)}
 	}
 
-	// Sync compliance snapshot so status reflects in-progress
-	if cs, err := penance.LoadComplianceStatus(); err == nil {
-		s.Compliance.TaskStatus = cs.TaskStatus
+	until := clock.Now().Add(d)
+	if !dryRun {
+		until, err = surveillance.StartInputBlackout(d)
+		s.Compute.RecordApply(err)
+		if err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to start input blackout: %v", err)}
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would drop all keyboard input for %s", d)
 	}
 
+	s.Compute.InputLockUntil = until.UTC().Format(time.RFC3339)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("SURVEILLANCE", "INPUT_BLACKOUT_STARTED",
+		fmt.Sprintf("duration=%s until=%s source=cli", d, s.Compute.InputLockUntil))
+
 	return &ipc.Response{
 		OK:      true,
-		Message: fmt.Sprintf("Line accepted. %d/%d remaining.", remaining, s.Writing.Required),
+		Message: fmt.Sprintf("Keyboard input blocked until %s", until.Local().Format("15:04:05")),
 		State:   s,
 	}
 }
+
+// blackoutEnded clears the persisted blackout when it expires or is
+// escaped.  An emergency escape is audited and counted as a failure.
+func blackoutEnded(srv *ipc.Server) func(events.Event) {
+	return func(e events.Event) {
+		b, ok := e.(events.BlackoutEnded)
+		if !ok {
+			return
+		}
+		if b.Escaped {
+			vexlog.LogEvent("SURVEILLANCE", "INPUT_BLACKOUT_ESCAPED", "emergency escape chord used")
+			if err := penance.RecordFailure("input_blackout_escape"); err != nil {
+				log.Printf("Surveillance: failed to record blackout escape: %v", err)
+			}
+		} else {
+			vexlog.LogEvent("SURVEILLANCE", "INPUT_BLACKOUT_ENDED", "blackout expired")
+		}
+
+		srv.Update(func(s *state.SystemState) {
+			s.Compute.InputLockUntil = ""
+			s.ChangedBy = "daemon"
+			if cs, err := penance.LoadComplianceStatus(); err == nil {
+				s.Compliance.FailureScore = cs.FailureScore
+			}
+		})
+	}
+}
+
+// ── Session locks ───────────────────────────────────────────────────
+
+var (
+	// sessionLockHits holds, for each of the manifest's session_locks by
+	// index, when its matching events happened within its window.
+	sessionLockHits = make(map[int][]time.Time)
+	sessionLockMu   sync.Mutex
+)
+
+// checkSessionLocks reports the manifest's invalid session_locks, which
+// are ignored, and starts every count afresh.
+func checkSessionLocks() {
+	sessionLockMu.Lock()
+	clear(sessionLockHits)
+	sessionLockMu.Unlock()
+	if penance.CurrentManifest == nil {
+		return
+	}
+	for _, l := range penance.CurrentManifest.Escalation.SessionLocks {
+		if err := l.Validate(); err != nil {
+			log.Printf("Penance manifest warning: %v (ignored)", err)
+		} else if l.Action == penance.SessionLockBreak && forcedBreakCfg == nil {
+			log.Printf("Penance manifest warning: session lock on %s: break needs %s (ignored)", l.Event, seat.BreakConfigFile)
+		}
+	}
+}
+
+// sessionLocks counts bus events against the manifest's session_locks and
+// acts on each rule that reaches its count, which then starts again.
+// Events may be published under the server lock, so the action runs on
+// its own goroutine.
+func sessionLocks(srv *ipc.Server) func(events.Event) {
+	return func(e events.Event) {
+		m := penance.CurrentManifest
+		if m == nil || len(m.Escalation.SessionLocks) == 0 {
+			return
+		}
+		now := clock.Now()
+		details := e.Details()
+		sessionLockMu.Lock()
+		defer sessionLockMu.Unlock()
+		for i, l := range m.Escalation.SessionLocks {
+			if l.Validate() != nil || !l.Matches(e.Name(), details) {
+				continue
+			}
+			hits := slices.DeleteFunc(sessionLockHits[i], func(t time.Time) bool { return now.Sub(t) >= l.Window() })
+			hits = append(hits, now)
+			if len(hits) >= l.Threshold() {
+				go lockSession(srv, l, e.Name(), len(hits))
+				hits = nil
+			}
+			sessionLockHits[i] = hits
+		}
+	}
+}
+
+// lockSession carries out a session_locks rule that event triggered for
+// the count'th time, unless enforcement is paused.
+func lockSession(srv *ipc.Server, l penance.SessionLock, event string, count int) {
+	var paused bool
+	srv.View(func(s *state.SystemState) { paused = s.Pause != nil })
+	detail := fmt.Sprintf("event=%s count=%d within=%s", event, count, l.Window())
+	if paused {
+		vexlog.LogEvent("SEAT", "SESSION_LOCKED", detail+" ignored=paused")
+		return
+	}
+	if l.Action == penance.SessionLockBreak {
+		forcedBreak(srv, detail)
+		return
+	}
+
+	var target string
+	var err error
+	if l.Action == penance.SessionLockVT {
+		target = fmt.Sprintf("vt%d", l.VT)
+		err = seat.SwitchVT(l.VT)
+	} else {
+		var ids []string
+		ids, err = seat.Lock()
+		target = "sessions " + strings.Join(ids, ",")
+	}
+	if err != nil {
+		log.Printf("Seat: failed to act on %s: %v", event, err)
+		vexlog.LogEvent("SEAT", "SESSION_LOCKED", fmt.Sprintf("%s target=%q error=%q", detail, target, err))
+		return
+	}
+	vexlog.LogEvent("SEAT", "SESSION_LOCKED", fmt.Sprintf("%s target=%q", detail, target))
+	notify.Keyholder("session_locked", map[string]string{
+		"event":  event,
+		"count":  strconv.Itoa(count),
+		"within": l.Window().String(),
+		"target": target,
+	})
+}
+
+// ── Forced break ────────────────────────────────────────────────────
+
+var (
+	// forcedBreakCfg is forced-break.json; nil means the daemon never
+	// suspends or powers off the machine.
+	forcedBreakCfg *seat.BreakConfig
+	breakPending   bool
+	breakMu        sync.Mutex
+)
+
+// breakCurfew is the reason given for a break at the start of the curfew.
+const breakCurfew = "curfew"
+
+// forcedBreak warns the subject and the keyholder and, once the grace
+// period is over, suspends or powers off the machine.  A trigger during
+// the countdown joins the break already pending.
+func forcedBreak(srv *ipc.Server, reason string) {
+	cfg := forcedBreakCfg
+	if cfg == nil {
+		log.Printf("Forced break: %s calls for one, but %s is not set up", reason, seat.BreakConfigFile)
+		return
+	}
+	breakMu.Lock()
+	defer breakMu.Unlock()
+	if breakPending {
+		return
+	}
+	breakPending = true
+	at := clock.Now().Add(cfg.Grace())
+	vexlog.LogEvent("BREAK", "PENDING", fmt.Sprintf("action=%s reason=%q at=%s", cfg.Action, reason, at.Format(time.RFC3339)))
+	notify.Keyholder("forced_break", map[string]string{
+		"action": cfg.Action,
+		"reason": reason,
+		"in":     cfg.Grace().String(),
+		"at":     at.Format(time.RFC3339),
+	})
+	clock.AfterFunc(cfg.Grace(), func() { takeBreak(srv, cfg.Action, reason) })
+}
+
+// takeBreak carries out a forced break whose countdown has run out,
+// unless enforcement was paused meanwhile or the curfew that called for
+// it has ended.
+func takeBreak(srv *ipc.Server, action, reason string) {
+	breakMu.Lock()
+	breakPending = false
+	breakMu.Unlock()
+
+	var paused, woke bool
+	srv.View(func(s *state.SystemState) {
+		paused = s.Pause != nil
+		woke = reason == breakCurfew && !s.Curfew.Active
+	})
+	detail := fmt.Sprintf("action=%s reason=%q", action, reason)
+	switch {
+	case paused:
+		vexlog.LogEvent("BREAK", "TAKEN", detail+" ignored=paused")
+		return
+	case woke:
+		vexlog.LogEvent("BREAK", "TAKEN", detail+" ignored=curfew_ended")
+		return
+	}
+	// Logged first: a shutdown may not leave time afterwards.
+	vexlog.LogEvent("BREAK", "TAKEN", detail)
+	if err := seat.Break(action); err != nil {
+		log.Printf("Forced break: %v", err)
+		vexlog.LogEvent("BREAK", "FAILED", fmt.Sprintf("%s error=%q", detail, err))
+	}
+}
+
+// ── Session freeze ──────────────────────────────────────────────────
+
+const (
+	// minFreeze and maxFreeze bound a session freeze.
+	minFreeze = time.Minute
+	maxFreeze = time.Hour
+
+	// freezeWarning is how long the subject is warned before a freeze
+	// starts.
+	freezeWarning = time.Minute
+)
+
+var freezeTimer *clock.Timer
+
+// handleFreeze announces a freeze of the target sessions, which starts
+// once freezeWarning is over and thaws by itself after the duration.
+func handleFreeze(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	d, err := time.ParseDuration(req.Args["duration"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid duration %q (e.g. 10m, 30m)", req.Args["duration"])}
+	}
+	if d < minFreeze || d > maxFreeze {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("duration must be between %s and %s", minFreeze, maxFreeze)}
+	}
+	if s.Compute.FreezeUntil != "" {
+		return &ipc.Response{OK: false, Error: "a freeze is already pending or in force until " + s.Compute.FreezeUntil}
+	}
+
+	at := clock.Now().Add(freezeWarning)
+	until := at.Add(d)
+	s.Compute.FreezeAt = at.UTC().Format(time.RFC3339)
+	s.Compute.FreezeUntil = until.UTC().Format(time.RFC3339)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("THROTTLER", "FREEZE_PENDING",
+		fmt.Sprintf("duration=%s at=%s until=%s source=cli", d, s.Compute.FreezeAt, s.Compute.FreezeUntil))
+	notify.Keyholder("session_freeze", map[string]string{
+		"in":  freezeWarning.String(),
+		"for": d.String(),
+		"at":  s.Compute.FreezeAt,
+	})
+	armFreeze(s)
+
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("Sessions freeze at %s and thaw at %s", at.Local().Format("15:04:05"), until.Local().Format("15:04:05")),
+		State:   s,
+	}
+}
+
+// freezeIfDue starts a freeze whose warning is over and thaws one whose
+// time is up, then arms the timer for what comes next.  Runs under the
+// server lock.
+func freezeIfDue(s *state.SystemState) {
+	c := &s.Compute
+	if c.FreezeUntil == "" {
+		armFreeze(s)
+		return
+	}
+	at, _ := time.Parse(time.RFC3339, c.FreezeAt)
+	until, _ := time.Parse(time.RFC3339, c.FreezeUntil)
+	now := clock.Now()
+	switch {
+	case !now.Before(until):
+		endFreeze(s, "expired")
+		return
+	case !now.Before(at) && !c.Frozen:
+		err := throttler.FreezeSessions(true)
+		c.RecordApply(err)
+		if err != nil {
+			log.Printf("Freeze: %v", err)
+			vexlog.LogEvent("THROTTLER", "FREEZE_FAILED", fmt.Sprintf("error=%q", err))
+			c.FreezeAt, c.FreezeUntil = "", ""
+			break
+		}
+		c.Frozen = true
+		s.ChangedBy = "daemon"
+		vexlog.LogEvent("THROTTLER", "FREEZE_STARTED", "until="+c.FreezeUntil)
+	}
+	armFreeze(s)
+}
+
+// endFreeze thaws the sessions if the freeze has started and forgets it.
+func endFreeze(s *state.SystemState, reason string) {
+	c := &s.Compute
+	if c.FreezeUntil == "" {
+		return
+	}
+	if c.Frozen {
+		err := throttler.FreezeSessions(false)
+		c.RecordApply(err)
+		if err != nil {
+			log.Printf("Freeze: %v", err)
+		}
+	}
+	vexlog.LogEvent("THROTTLER", "FREEZE_ENDED", fmt.Sprintf("reason=%s started=%t", reason, c.Frozen))
+	c.FreezeAt, c.FreezeUntil, c.Frozen = "", "", false
+	s.ChangedBy = "daemon"
+	armFreeze(s)
+}
+
+// armFreeze sets the timer for the start or the end of the freeze.
+func armFreeze(s *state.SystemState) {
+	if freezeTimer != nil {
+		freezeTimer.Stop()
+		freezeTimer = nil
+	}
+	if liveSrv == nil || s.Compute.FreezeUntil == "" {
+		return
+	}
+	next := s.Compute.FreezeUntil
+	if !s.Compute.Frozen {
+		next = s.Compute.FreezeAt
+	}
+	t, _ := time.Parse(time.RFC3339, next)
+	freezeTimer = clock.AfterFunc(clock.Until(t), func() { liveSrv.Update(freezeIfDue) })
+}
+
+// ── USB device policy ───────────────────────────────────────────────
+
+// usbEnforcing reports whether the USB policy applies now: while locked
+// and not paused.
+func usbEnforcing() bool {
+	enforcing := false
+	liveSrv.View(func(s *state.SystemState) {
+		enforcing = s.Compliance.Locked && s.Pause == nil
+	})
+	return enforcing
+}
+
+// reportUSBDevice logs what the USB policy did with a new device and
+// tells the keyholder unless the policy only logs it.
+func reportUSBDevice(d usb.Device, action string, err error) {
+	details := fmt.Sprintf("kind=%s id=%s interface=%s action=%s", d.Kind, d.ID(), d.Name(), action)
+	if err != nil {
+		log.Printf("USB: %v", err)
+		details += fmt.Sprintf(" error=%q", err)
+		action = "block failed"
+	}
+	vexlog.LogEvent("GUARDIAN", "USB_DEVICE", details)
+	if action != usb.ActionLog {
+		notify.Keyholder("usb_device", map[string]string{
+			"kind":   d.Kind,
+			"id":     d.ID(),
+			"action": action,
+		})
+	}
+}
+
+// releaseUSB lets the USB devices blocked while locked in again.
+func releaseUSB() {
+	if err := usb.Release(); err != nil {
+		log.Printf("USB: %v", err)
+	}
+}
+
+// ── Auto-revert (--for) ─────────────────────────────────────────────
+
+// expiryKind describes a setting that can be applied for a limited time.
+type expiryKind struct {
+	name    string
+	target  func(req *ipc.Request) string                    // "" unless per-item
+	current func(s *state.SystemState, target string) string // setting as a string
+	revert  func(s *state.SystemState, e state.Expiry) *ipc.Response
+}
+
+var (
+	expiryThrottle = expiryKind{
+		name:   "throttle",
+		target: func(*ipc.Request) string { return "" },
+		current: func(s *state.SystemState, _ string) string {
+			if s.Curfew.Active {
+				return s.Curfew.SavedProfile // what wake time will restore
+			}
+			return s.Network.Profile
+		},
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			if s.Curfew.Active {
+				// Restore at wake instead of breaking the curfew.
+				s.Curfew.SavedProfile = e.Previous
+				return &ipc.Response{OK: true}
+			}
+			return handleThrottle(s, &ipc.Request{Args: map[string]string{"profile": e.Previous}})
+		},
+	}
+	expiryCPU = expiryKind{
+		name:    "cpu",
+		target:  func(*ipc.Request) string { return "" },
+		current: func(s *state.SystemState, _ string) string { return strconv.Itoa(s.Compute.CPULimitPct) },
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			return handleCPU(s, &ipc.Request{Args: map[string]string{"percent": e.Previous}})
+		},
+	}
+	expiryLatency = expiryKind{
+		name:   "latency",
+		target: func(*ipc.Request) string { return "" },
+		current: func(s *state.SystemState, _ string) string {
+			if s.Compute.InputLatencyMaxMs > 0 {
+				return fmt.Sprintf("%d-%d", s.Compute.InputLatencyMs, s.Compute.InputLatencyMaxMs)
+			}
+			return strconv.Itoa(s.Compute.InputLatencyMs)
+		},
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			args := map[string]string{"ms": e.Previous}
+			if lo, hi, ok := strings.Cut(e.Previous, "-"); ok {
+				args = map[string]string{"ms": lo, "max_ms": hi}
+			}
+			return handleLatency(s, &ipc.Request{Args: args})
+		},
+	}
+	expiryBlock = expiryKind{
+		name:   "block",
+		target: func(req *ipc.Request) string { return strings.ToLower(req.Args["domain"]) },
+		current: func(s *state.SystemState, domain string) string {
+			if containsFold(s.Guardian.BlockedDomains, domain) {
+				return "blocked"
+			}
+			return ""
+		},
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			return handleBlockRemove(s, &ipc.Request{Args: map[string]string{"domain": e.Target}})
+		},
+	}
+
+	// expiryLock ends a lockuntil sentence at its deadline.  Entries are
+	// created by handleLockUntil, not via withExpiry.
+	expiryLock = expiryKind{
+		name:   "lock",
+		target: func(*ipc.Request) string { return "" },
+		current: func(s *state.SystemState, _ string) string {
+			if s.Compliance.Locked {
+				return "locked"
+			}
+			return "unlocked"
+		},
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			return releaseLock(s, "deadline")
+		},
+	}
+
+	// expiryException re-imposes what an approved exception request
+	// lifted.  Target is "domain:<name>" or "app:<name>"; entries are
+	// created by approveException.  A restriction the curfew or calendar
+	// re-imposed meanwhile still counts as the exception's to end.
+	expiryException = expiryKind{
+		name:   "exception",
+		target: func(*ipc.Request) string { return "" },
+		current: func(s *state.SystemState, target string) string {
+			kind, name, _ := strings.Cut(target, ":")
+			if kind == "domain" {
+				if containsFold(s.Guardian.BlockedDomains, name) && !containsFold(s.Calendar.AddedDomains, name) {
+					return "blocked"
+				}
+				return "allowed"
+			}
+			if containsFold(guardian.GetForbiddenApps(), name) && !containsFold(s.Calendar.AddedApps, name) &&
+				!(s.Curfew.Active && containsFold(s.Curfew.AddedApps, name)) {
+				return "forbidden"
+			}
+			return "allowed"
+		},
+		revert: func(s *state.SystemState, e state.Expiry) *ipc.Response {
+			return reimposeException(s, e.Target)
+		},
+	}
+)
+
+// liveSrv is the running server, for timers and scheduler jobs that are
+// set up from inside a handler and fire after it returns.
+var liveSrv *ipc.Server
+
+var expiryTimer *clock.Timer
+
+// withExpiry adds an optional "for" argument to a handler.  With it, the
+// setting is reverted to its previous value once the period ends; without
+// it, any pending revert of the same setting is dropped because the new
+// value is meant to stay.
+func withExpiry(k expiryKind, h ipc.Handler) ipc.Handler {
+	return func(s *state.SystemState, req *ipc.Request) *ipc.Response {
+		var period time.Duration
+		if v, ok := req.Args["for"]; ok {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid --for duration %q (e.g. 30m, 2h)", v)}
+			}
+			period = d
+		}
+
+		target := k.target(req)
+		previous := k.current(s, target)
+		pending := -1
+		for i, e := range s.Expiries {
+			if e.Kind == k.name && e.Target == target {
+				pending = i
+				// Chained --for keeps the value from before the first one.
+				previous = e.Previous
+			}
+		}
+
+		resp := h(s, req)
+		if !resp.OK {
+			return resp
+		}
+		if pending >= 0 {
+			s.Expiries = append(s.Expiries[:pending], s.Expiries[pending+1:]...)
+		}
+
+		value := k.current(s, target)
+		switch {
+		case period == 0:
+		case value == previous:
+			resp.Message += " (already in effect; --for ignored)"
+		case k.name == "block" && req.Command == ipc.CmdBlockRemove:
+			resp.Message += " (--for only applies to block add)"
+		default:
+			until := clock.Now().Add(period)
+			s.Expiries = append(s.Expiries, state.Expiry{
+				Kind:     k.name,
+				Target:   target,
+				Value:    value,
+				Previous: previous,
+				Until:    until.UTC().Format(time.RFC3339),
+			})
+			resp.Message += fmt.Sprintf(" for %s (reverts at %s)", period, until.Local().Format("15:04:05"))
+			vexlog.LogEvent("EXPIRY", "SCHEDULED",
+				fmt.Sprintf("kind=%s target=%s value=%s revert_to=%s until=%s", k.name, target, value, previous, until.UTC().Format(time.RFC3339)))
+		}
+		armExpiries(s)
+		return resp
+	}
+}
+
+// expiryRetry is how long a revert that failed waits before it is tried
+// again.
+const expiryRetry = time.Minute
+
+// revertExpired restores every setting whose period has ended, then arms
+// the timer for the next one.  A revert that fails is kept and retried
+// after expiryRetry.  During a pause, what the pause lifted is left for
+// the resume to revert.  Runs under the server lock.
+func revertExpired(s *state.SystemState) {
+	kinds := map[string]expiryKind{}
+	for _, k := range []expiryKind{expiryThrottle, expiryCPU, expiryLatency, expiryBlock, expiryLock, expiryException} {
+		kinds[k.name] = k
+	}
+
+	now := clock.Now()
+	var keep []state.Expiry
+	for _, e := range s.Expiries {
+		until, err := time.Parse(time.RFC3339, e.Until)
+		if err == nil && now.Before(until) {
+			keep = append(keep, e)
+			continue
+		}
+		k, ok := kinds[e.Kind]
+		if !ok {
+			log.Printf("Expiry: dropping unknown kind %q", e.Kind)
+			continue
+		}
+		if s.Pause != nil && e.Kind != expiryLock.name {
+			// Compared with the lifted state it would look superseded;
+			// endPause reverts it against the restored one.
+			s.Pause.Saved.Expiries = append(s.Pause.Saved.Expiries, e)
+			vexlog.LogEvent("EXPIRY", "DEFERRED", fmt.Sprintf("kind=%s target=%s until=resume", e.Kind, e.Target))
+			continue
+		}
+		if cur := k.current(s, e.Target); cur != e.Value {
+			vexlog.LogEvent("EXPIRY", "SUPERSEDED",
+				fmt.Sprintf("kind=%s target=%s value=%s now=%s", e.Kind, e.Target, e.Value, cur))
+			continue
+		}
+		if resp := k.revert(s, e); !resp.OK {
+			retry := now.Add(expiryRetry)
+			log.Printf("Expiry: failed to revert %s %s, retrying at %s: %s",
+				e.Kind, e.Target, retry.Local().Format("15:04:05"), resp.Error)
+			vexlog.LogEvent("EXPIRY", "REVERT_FAILED", fmt.Sprintf("kind=%s target=%s error=%s retry=%s",
+				e.Kind, e.Target, resp.Error, retry.UTC().Format(time.RFC3339)))
+			e.Until = retry.UTC().Format(time.RFC3339)
+			keep = append(keep, e)
+			continue
+		}
+		s.ChangedBy = "expiry"
+		vexlog.LogEvent("EXPIRY", "REVERTED",
+			fmt.Sprintf("kind=%s target=%s value=%s restored=%s", e.Kind, e.Target, e.Value, e.Previous))
+	}
+	s.Expiries = keep
+	armExpiries(s)
+}
+
+// armExpiries (re)starts the timer for the earliest pending expiry.
+func armExpiries(s *state.SystemState) {
+	if expiryTimer != nil {
+		expiryTimer.Stop()
+		expiryTimer = nil
+	}
+	if liveSrv == nil || len(s.Expiries) == 0 {
+		return
+	}
+	var next time.Time
+	for _, e := range s.Expiries {
+		until, err := time.Parse(time.RFC3339, e.Until)
+		if err != nil {
+			until = clock.Now()
+		}
+		if next.IsZero() || until.Before(next) {
+			next = until
+		}
+	}
+	expiryTimer = clock.AfterFunc(clock.Until(next), func() { liveSrv.Update(revertExpired) })
+}
+
+// ── Allowance windows ───────────────────────────────────────────────
+
+// allowJobPrefix namespaces allowance jobs in the scheduler.
+const allowJobPrefix = "allow:"
+
+func allowanceWindow(a state.Allowance) scheduler.Window {
+	return scheduler.Window{Start: a.Start, End: a.End, Days: a.Days}
+}
+
+func findAllowance(s *state.SystemState, name string) *state.Allowance {
+	for i := range s.Allowances {
+		if s.Allowances[i].Name == name {
+			return &s.Allowances[i]
+		}
+	}
+	return nil
+}
+
+// scheduleAllowances brings the scheduler's allowance jobs in line with
+// the configured allowances.  Each job looks its allowance up by name, so
+// only additions and removals need re-registering.
+func scheduleAllowances(s *state.SystemState) {
+	want := make(map[string]bool)
+	for _, a := range s.Allowances {
+		want[allowJobPrefix+a.Name] = true
+	}
+	have := make(map[string]bool)
+	for _, job := range scheduler.Jobs() {
+		if strings.HasPrefix(job, allowJobPrefix) {
+			have[job] = true
+			if !want[job] {
+				scheduler.Remove(job)
+			}
+		}
+	}
+	if liveSrv == nil {
+		return
+	}
+	for _, a := range s.Allowances {
+		if have[allowJobPrefix+a.Name] {
+			continue
+		}
+		name := a.Name
+		scheduler.Set(allowJobPrefix+name,
+			func(now time.Time) bool {
+				var open bool
+				liveSrv.View(func(s *state.SystemState) {
+					if a := findAllowance(s, name); a != nil && s.Pause == nil {
+						open = allowanceWindow(*a).Contains(now)
+					}
+				})
+				return open
+			},
+			func(open bool) {
+				liveSrv.Update(func(s *state.SystemState) { setAllowance(s, name, open) })
+			})
+	}
+}
+
+// setAllowance opens or closes an allowance window.  Opening removes the
+// allowance's domains and apps from the blocklists, except apps the
+// curfew forbids; closing puts back exactly what was removed.
+func setAllowance(s *state.SystemState, name string, open bool) {
+	a := findAllowance(s, name)
+	if a == nil || a.Active == open {
+		return
+	}
+
+	if open {
+		a.LiftedDomains, a.LiftedApps = nil, nil
+		blocked := guardian.GetBlockedDomains()
+		forbidden := guardian.GetForbiddenApps()
+		for _, d := range a.Domains {
+			if !containsFold(blocked, d) || containsFold(s.Calendar.AddedDomains, d) {
+				continue
+			}
+			if dryRun {
+				log.Printf("[DRY-RUN] Would allow domain during %s: %s", a.Name, d)
+			} else if _, err := guardian.RemoveDomain(d); err != nil {
+				log.Printf("Allowance %s: failed to unblock %s: %v", a.Name, d, err)
+				continue
+			}
+			a.LiftedDomains = append(a.LiftedDomains, d)
+		}
+		for _, app := range a.Apps {
+			if !containsFold(forbidden, app) || containsFold(s.Calendar.AddedApps, app) ||
+				(s.Curfew.Active && containsFold(s.Curfew.AddedApps, app)) {
+				continue
+			}
+			if dryRun {
+				log.Printf("[DRY-RUN] Would allow app during %s: %s", a.Name, app)
+			} else if _, err := guardian.RemoveForbiddenApp(app); err != nil {
+				log.Printf("Allowance %s: failed to allow %s: %v", a.Name, app, err)
+				continue
+			}
+			a.LiftedApps = append(a.LiftedApps, app)
+		}
+		a.Active = true
+		vexlog.LogEvent("ALLOWANCE", "OPENED", fmt.Sprintf("name=%s window=%s domains=%s apps=%s",
+			a.Name, allowanceWindow(*a), strings.Join(a.LiftedDomains, ","), strings.Join(a.LiftedApps, ",")))
+	} else {
+		var errs []error
+		for _, d := range a.LiftedDomains {
+			if dryRun {
+				log.Printf("[DRY-RUN] Would re-block domain after %s: %s", a.Name, d)
+			} else if _, err := guardian.AddDomain(d); err != nil {
+				log.Printf("Allowance %s: failed to re-block %s: %v", a.Name, d, err)
+				errs = append(errs, err)
+			}
+		}
+		for _, app := range a.LiftedApps {
+			if dryRun {
+				log.Printf("[DRY-RUN] Would re-forbid app after %s: %s", a.Name, app)
+			} else if _, err := guardian.AddForbiddenApp(app); err != nil {
+				log.Printf("Allowance %s: failed to re-forbid %s: %v", a.Name, app, err)
+			}
+			// A curfew that started meanwhile must not un-forbid it at wake.
+			s.Curfew.AddedApps = removeFold(s.Curfew.AddedApps, app)
+		}
+		if len(a.LiftedDomains) > 0 && !dryRun {
+			s.Guardian.RecordApply(errors.Join(errs...))
+		}
+		vexlog.LogEvent("ALLOWANCE", "CLOSED", fmt.Sprintf("name=%s domains=%s apps=%s",
+			a.Name, strings.Join(a.LiftedDomains, ","), strings.Join(a.LiftedApps, ",")))
+		a.Active = false
+		a.LiftedDomains, a.LiftedApps = nil, nil
+	}
+
+	if !dryRun {
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+	}
+	s.ChangedBy = "allowance"
+}
+
+func handleAllowList(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	return &ipc.Response{OK: true, State: s}
+}
+
+func handleAllowAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	name := strings.TrimSpace(req.Args["name"])
+	if name == "" {
+		return &ipc.Response{OK: false, Error: "missing 'name' argument"}
+	}
+	if findAllowance(s, name) != nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("allowance %q already exists", name)}
+	}
+	days, err := scheduler.ParseDays(req.Args["days"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	w := scheduler.Window{Start: req.Args["start"], End: req.Args["end"], Days: days}
+	if err := w.Validate(); err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	a := state.Allowance{Name: name, Start: w.Start, End: w.End, Days: w.Days}
+	for _, d := range strings.Split(req.Args["domains"], ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			a.Domains = append(a.Domains, d)
+		}
+	}
+	for _, app := range strings.Split(req.Args["apps"], ",") {
+		if app = strings.TrimSpace(app); app != "" {
+			a.Apps = append(a.Apps, app)
+		}
+	}
+	if len(a.Domains) == 0 && len(a.Apps) == 0 {
+		return &ipc.Response{OK: false, Error: "an allowance needs at least one domain or app"}
+	}
+
+	s.Allowances = append(s.Allowances, a)
+	scheduleAllowances(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("ALLOWANCE", "ADDED", fmt.Sprintf("name=%s window=%s domains=%s apps=%s source=cli",
+		name, w, strings.Join(a.Domains, ","), strings.Join(a.Apps, ",")))
+
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Allowance %q added: %s", name, w), State: s}
+}
+
+func handleAllowRemove(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	name := req.Args["name"]
+	if findAllowance(s, name) == nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no allowance named %q", name)}
+	}
+
+	setAllowance(s, name, false) // restore anything it lifted
+	var keep []state.Allowance
+	for _, a := range s.Allowances {
+		if a.Name != name {
+			keep = append(keep, a)
+		}
+	}
+	s.Allowances = keep
+	scheduleAllowances(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("ALLOWANCE", "REMOVED", fmt.Sprintf("name=%s source=cli", name))
+
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Allowance %q removed", name), State: s}
+}
+
+// ── Queued commands ─────────────────────────────────────────────────
+
+// runSpooled runs the commands vex-cli queued while the daemon was down,
+// in the order they were queued.  The socket is listening by now, so
+// nothing is queued after the spool is read.
+func runSpooled(srv *ipc.Server) {
+	list, err := ipc.Unspool()
+	if err != nil {
+		log.Printf("Spool: %v", err)
+	}
+	for _, sp := range list {
+		detail := fmt.Sprintf("cmd=%s args=%v uid=%d queued=%s", sp.Request.Command, sp.Request.Args, sp.UID, sp.Queued)
+		if resp := srv.Dispatch(&sp.Request); !resp.OK {
+			vexlog.LogEvent("IPC", "SPOOL_FAILED", fmt.Sprintf("%s error=%q", detail, resp.Error))
+			continue
+		}
+		srv.Update(func(s *state.SystemState) { s.ChangedBy = "spool" })
+		vexlog.LogEvent("IPC", "SPOOL_RAN", detail)
+	}
+}
+
+// ── Scheduled commands ──────────────────────────────────────────────
+
+const scheduleJobPrefix = "schedule:"
+
+// scheduleGrace is how late a scheduled command may still run.  A run
+// missed by more, while the daemon was down or the machine asleep, is
+// skipped rather than applied hours after it was meant to.
+const scheduleGrace = time.Hour
+
+func scheduleDaily(sc state.Schedule) scheduler.Daily {
+	return scheduler.Daily{At: sc.At, Days: sc.Days}
+}
+
+func findSchedule(s *state.SystemState, id int) *state.Schedule {
+	for i := range s.Schedules {
+		if s.Schedules[i].ID == id {
+			return &s.Schedules[i]
+		}
+	}
+	return nil
+}
+
+// scheduleCommands brings the scheduler's jobs in line with the scheduled
+// commands.  A job is due once the last occurrence of its time is after
+// its last run.
+func scheduleCommands(s *state.SystemState) {
+	want := make(map[string]bool)
+	for _, sc := range s.Schedules {
+		want[scheduleJobPrefix+strconv.Itoa(sc.ID)] = true
+	}
+	have := make(map[string]bool)
+	for _, job := range scheduler.Jobs() {
+		if strings.HasPrefix(job, scheduleJobPrefix) {
+			have[job] = true
+			if !want[job] {
+				scheduler.Remove(job)
+			}
+		}
+	}
+	if liveSrv == nil {
+		return
+	}
+	for _, sc := range s.Schedules {
+		job := scheduleJobPrefix + strconv.Itoa(sc.ID)
+		if have[job] {
+			continue
+		}
+		id := sc.ID
+		scheduler.Set(job,
+			func(now time.Time) bool {
+				var due bool
+				liveSrv.View(func(s *state.SystemState) {
+					if sc := findSchedule(s, id); sc != nil {
+						last, err := time.Parse(time.RFC3339, sc.LastRun)
+						due = err != nil || last.Before(scheduleDaily(*sc).Last(now))
+					}
+				})
+				return due
+			},
+			func(due bool) {
+				if due {
+					runSchedule(id)
+				}
+			})
+	}
+}
+
+// runSchedule runs a due scheduled command through the daemon's own
+// handlers, exactly as if it had come from the CLI.
+func runSchedule(id int) {
+	var command, payload string
+	var when scheduler.Daily
+	var late time.Duration
+	now := clock.Now()
+	liveSrv.Update(func(s *state.SystemState) {
+		sc := findSchedule(s, id)
+		if sc == nil {
+			return
+		}
+		command, payload, when = sc.Command, sc.Payload, scheduleDaily(*sc)
+		late = now.Sub(scheduleDaily(*sc).Last(now))
+		sc.LastRun = now.Format(time.RFC3339)
+		sc.NextRun = scheduleDaily(*sc).Next(now).Format(time.RFC3339)
+	})
+	if command == "" {
+		return
+	}
+	if late > scheduleGrace {
+		vexlog.LogEvent("SCHEDULE", "SKIPPED", fmt.Sprintf("id=%d command=%q late=%s", id, command, late.Round(time.Minute)))
+		return
+	}
+
+	req, err := chatops.Parse(strings.Fields(command))
+	if err == nil && slices.Contains(loweringCommands, req.Command) {
+		err = verifySchedule(payload, when, command)
+	}
+	if err != nil {
+		vexlog.LogEvent("SCHEDULE", "FAILED", fmt.Sprintf("id=%d command=%q error=%q", id, command, err))
+		return
+	}
+	if resp := liveSrv.Dispatch(req); !resp.OK {
+		vexlog.LogEvent("SCHEDULE", "FAILED", fmt.Sprintf("id=%d command=%q error=%q", id, command, resp.Error))
+		return
+	}
+	liveSrv.Update(func(s *state.SystemState) { s.ChangedBy = "schedule" })
+	vexlog.LogEvent("SCHEDULE", "RAN", fmt.Sprintf("id=%d command=%q", id, command))
+}
+
+// verifySchedule checks the authorization a scheduled command that can
+// lower restrictions needs: a payload signed for "schedule-add" with the
+// args "<when>|<command>", e.g. "07:00 daily|throttle standard".  The
+// daemon checks the signature itself, as the command runs long after any
+// client is gone.
+func verifySchedule(payload string, when scheduler.Daily, command string) error {
+	if payload == "" {
+		return errors.New("it can lower restrictions, so it needs a payload signed for 'schedule-add' (--signed)")
+	}
+	signed, err := security.ParseSignedCommand([]byte(payload))
+	if err != nil {
+		return err
+	}
+	if signed.Command != "schedule-add" {
+		return fmt.Errorf("payload was signed for '%s', not 'schedule-add'", signed.Command)
+	}
+	at, words, _ := strings.Cut(signed.Args, "|")
+	d, err := scheduler.ParseDaily(at)
+	if err != nil || d.String() != when.String() || strings.Join(strings.Fields(words), " ") != command {
+		return fmt.Errorf("payload was signed for %q, not %q", signed.Args, when.String()+"|"+command)
+	}
+	return security.VerifyCommand(signed)
+}
+
+func handleScheduleList(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	now := clock.Now()
+	for i := range s.Schedules {
+		s.Schedules[i].NextRun = scheduleDaily(s.Schedules[i]).Next(now).Format(time.RFC3339)
+	}
+	return &ipc.Response{OK: true, State: s}
+}
+
+func handleScheduleAdd(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	d, err := scheduler.ParseDaily(req.Args["at"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	words := strings.Fields(req.Args["command"])
+	parsed, err := chatops.Parse(words)
+	if err != nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("cannot schedule %q: %v", strings.Join(words, " "), err)}
+	}
+	var payload string
+	if slices.Contains(loweringCommands, parsed.Command) {
+		payload = req.Args["payload"]
+		if err := verifySchedule(payload, d, strings.Join(words, " ")); err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeUnauthorized, Error: fmt.Sprintf("cannot schedule %q: %v", strings.Join(words, " "), err)}
+		}
+	}
+
+	id := 1
+	for _, sc := range s.Schedules {
+		id = max(id, sc.ID+1)
+	}
+	now := clock.Now()
+	next := d.Next(now)
+	sc := state.Schedule{
+		ID:      id,
+		At:      d.At,
+		Days:    d.Days,
+		Command: strings.Join(words, " "),
+		LastRun: now.Format(time.RFC3339),
+		NextRun: next.Format(time.RFC3339),
+		Payload: payload,
+	}
+	s.Schedules = append(s.Schedules, sc)
+	scheduleCommands(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("SCHEDULE", "ADDED", fmt.Sprintf("id=%d when=%q command=%q source=cli", id, d, sc.Command))
+
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("Scheduled #%d: %s at %s (next run %s)", id, sc.Command, d, next.Format("Mon 2006-01-02 15:04")),
+		State:   s,
+	}
+}
+
+func handleScheduleRemove(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	id, err := ipc.ParseIntArg(req.Args, "id")
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	sc := findSchedule(s, id)
+	if sc == nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no scheduled command #%d", id)}
+	}
+	command := sc.Command
+
+	var keep []state.Schedule
+	for _, sc := range s.Schedules {
+		if sc.ID != id {
+			keep = append(keep, sc)
+		}
+	}
+	s.Schedules = keep
+	scheduleCommands(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("SCHEDULE", "REMOVED", fmt.Sprintf("id=%d command=%q source=cli", id, command))
+
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Removed scheduled command #%d: %s", id, command), State: s}
+}
+
+// ── Keyholder events ────────────────────────────────────────────────
+
+// notifyEvents subscribes to the event bus to record enforcement events
+// in the audit log, where reports find them, and send them to the
+// keyholder.  Which of them actually go out is up to the event filter in
+// notify.json.  Blackout and profile events are handled elsewhere.
+func notifyEvents() {
+	events.Subscribe(func(e events.Event) {
+		switch e := e.(type) {
+		case events.Kill:
+			vexlog.LogEvent("GUARDIAN", "KILLED", fmt.Sprintf("app=%s pid=%d", e.App, e.PID))
+			killCount.Add(1)
+			if err := penance.RecordKill(); err != nil {
+				log.Printf("Streak: failed to record kill: %v", err)
+			}
+		case events.Escalation:
+			vexlog.LogEvent("ANTITAMPER", "ESCALATED", fmt.Sprintf("score=%d reasons=%s", e.Score, strings.Join(e.Reasons, "; ")))
+		case events.Failure:
+			name := "FAILURE"
+			if e.Reported {
+				name = "FAILURE_REPORTED"
+			}
+			vexlog.LogEvent("PENANCE", name, fmt.Sprintf("reason=%s category=%s score=%d", e.Reason, e.Category, e.Score))
+			if e.Topic != "" {
+				vexlog.LogEvent("PENANCE", "TOPIC_ASSIGNED", fmt.Sprintf("topic=%q score=%d", e.Topic, e.Score))
+			}
+		case events.Completion:
+			vexlog.LogEvent("PENANCE", "COMPLETED", fmt.Sprintf("total_completed=%d locked=%v", e.TotalCompleted, e.Locked))
+		case events.CheckinMissed:
+			vexlog.LogEvent("HEARTBEAT", "CHECKIN_MISSED", fmt.Sprintf("since=%s error=%s", e.Since.Format(time.RFC3339), e.Error))
+		case events.CheckinResumed:
+			vexlog.LogEvent("HEARTBEAT", "CHECKIN_RESUMED", fmt.Sprintf("down=%s", e.Down))
+		case events.StreakMilestone:
+			vexlog.LogEvent("PENANCE", "STREAK", fmt.Sprintf("days=%d since=%s", e.Days, e.Since.Format(time.RFC3339)))
+		case events.TunnelDetected:
+			vexlog.LogEvent("GUARDIAN", "TUNNEL_DETECTED", fmt.Sprintf("target=%s detail=%s response=%s", e.Target, e.Detail, strings.Join(e.Response, ",")))
+			if !slices.Contains(e.Response, guardian.TunnelNotify) {
+				return
+			}
+		case events.LineRejected:
+			// Already logged where the line was checked.
+		default:
+			return
+		}
+		notify.Keyholder(e.Name(), e.Details())
+	})
+}
+
+// streamEvents forwards every bus event to IPC watchers that asked for
+// events.
+func streamEvents(srv *ipc.Server) {
+	events.Subscribe(func(e events.Event) {
+		srv.Broadcast(&ipc.Event{
+			Name:    e.Name(),
+			Time:    clock.Now().UTC().Format(time.RFC3339),
+			Details: e.Details(),
+		})
+	})
+}
+
+// deadlineWarning is how long before a deadline deadline_approaching is
+// sent.
+const deadlineWarning = 15 * time.Minute
+
+// deadlineWarnLoop sends deadline_approaching once for each deadline that
+// comes within deadlineWarning.  A restart inside the window may send it
+// again.
+func deadlineWarnLoop(srv *ipc.Server) error {
+	warned := make(map[string]bool)
+	ticker := clock.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var due []map[string]string
+		srv.View(func(s *state.SystemState) { due = approachingDeadlines(s, now) })
+		for _, d := range due {
+			key := d["kind"] + "|" + d["target"] + "|" + d["until"]
+			if !warned[key] {
+				warned[key] = true
+				notify.Keyholder("deadline_approaching", d)
+			}
+		}
+	}
+	return nil
+}
+
+// approachingDeadlines lists what changes within deadlineWarning of now:
+// restrictions returning after a temporary unlock or a pause, exceptions
+// running out, and the end of a lockuntil.
+func approachingDeadlines(s *state.SystemState, now time.Time) []map[string]string {
+	var out []map[string]string
+	add := func(kind, target, until string) {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil || t.Before(now) || t.Sub(now) > deadlineWarning {
+			return
+		}
+		out = append(out, map[string]string{
+			"kind":   kind,
+			"target": target,
+			"until":  until,
+			"in":     fmt.Sprintf("%dm", int(t.Sub(now).Round(time.Minute).Minutes())),
+		})
+	}
+	if s.Relock != nil {
+		add("relock", "", s.Relock.Until)
+	}
+	if s.Pause != nil {
+		add("pause_end", "", s.Pause.Until)
+	}
+	add("lockuntil", "", s.Compliance.LockUntil)
+	for _, e := range s.Expiries {
+		if e.Kind == expiryException.name {
+			add("exception_end", e.Target, e.Until)
+		}
+	}
+	return out
+}
+
+// ── MQTT ────────────────────────────────────────────────────────────
+
+// mqttStatus is the part of the state published over MQTT.
+func mqttStatus(s *state.SystemState) mqtt.Status {
+	return mqtt.Status{
+		Locked:       s.Compliance.Locked,
+		Paused:       s.Pause != nil,
+		Profile:      s.Network.Profile,
+		FailureScore: s.Compliance.FailureScore,
+		TaskStatus:   s.Compliance.TaskStatus,
+		CPULimitPct:  s.Compute.CPULimitPct,
+		Writing: mqtt.Writing{
+			Active:    s.Writing.Active,
+			Phrase:    s.Writing.Phrase,
+			Completed: s.Writing.Completed,
+			Required:  s.Writing.Required,
+		},
+	}
+}
+
+// ── Heartbeat ───────────────────────────────────────────────────────
+
+// heartbeatStatus reports the state sent with each check-in.
+func heartbeatStatus(srv *ipc.Server) func() heartbeat.Beat {
+	return func() heartbeat.Beat {
+		var b heartbeat.Beat
+		srv.View(func(s *state.SystemState) {
+			b.Locked = s.Compliance.Locked
+			b.Paused = s.Pause != nil
+			b.FailureScore = s.Compliance.FailureScore
+			b.Profile = s.Network.Profile
+		})
+		return b
+	}
+}
+
+// checkinMissed applies the precautionary tightening from heartbeat.json
+// when check-ins have failed for too long.  It stays in place after
+// check-ins resume; lifting it is up to the keyholder.
+func checkinMissed(srv *ipc.Server, c *heartbeat.Config) func(events.Event) {
+	return func(e events.Event) {
+		if _, ok := e.(events.CheckinMissed); !ok {
+			return
+		}
+		srv.Update(func(s *state.SystemState) { tightenForMissedCheckin(s, c) })
+	}
+}
+
+func tightenForMissedCheckin(s *state.SystemState, c *heartbeat.Config) {
+	if s.Pause != nil {
+		vexlog.LogEvent("HEARTBEAT", "TIGHTEN_SKIPPED", "reason=paused")
+		return
+	}
+	if c.Profile != "" {
+		p, err := throttler.ResolveProfile(c.Profile)
+		if err != nil {
+			log.Printf("Heartbeat: %v", err)
+		} else if throttler.Severity(p) > throttler.Severity(throttler.Profile(s.Network.Profile)) {
+			if !dryRun {
+				err := throttler.ApplyNetworkProfile(p)
+				s.Network.RecordApply(err)
+				if err != nil {
+					log.Printf("Heartbeat: failed to apply profile %s: %v", p, err)
+				}
+			} else {
+				log.Printf("[DRY-RUN] Would apply heartbeat profile: %s", p)
+			}
+			s.Network.Profile = string(p)
+			s.Network.PacketLossPct = 0
+		}
+	}
+	if c.RecordFailure {
+		if err := penance.RecordFailure("heartbeat_missed"); err != nil {
+			log.Printf("Heartbeat: failed to record failure: %v", err)
+		}
+		if cs, err := penance.LoadComplianceStatus(); err == nil {
+			s.Compliance.Locked = cs.Locked
+			s.Compliance.FailureScore = cs.FailureScore
+			s.Compliance.TaskStatus = cs.TaskStatus
+		}
+	}
+	vexlog.LogEvent("HEARTBEAT", "TIGHTENED", fmt.Sprintf("profile=%s record_failure=%v", s.Network.Profile, c.RecordFailure))
+	s.ChangedBy = "heartbeat"
+}
+
+// ── Browser extension ───────────────────────────────────────────────
+
+// extensionSource gives the extension the domains being blocked, which it
+// enforces while the firewall does, and credits the time it reports.  A
+// pause lifts the firewall and so the extension's blocks with it.
+func extensionSource(srv *ipc.Server) extension.Source {
+	return extension.Source{
+		Blocklist: func() (active bool, domains []string) {
+			srv.View(func(s *state.SystemState) {
+				active = s.Guardian.FirewallEnabled
+				domains = guardian.DomainNames(s.Guardian.BlockedDomains)
+			})
+			return active, domains
+		},
+		Visit: func(site string, elapsed time.Duration) {
+			surveillance.RecordSite(site, elapsed, clock.Now())
+		},
+	}
+}
+
+// ── Reports ─────────────────────────────────────────────────────────
+
+const reportJob = "report"
+
+// reportCfg is /etc/vex-cli/report.json, or nil when reports are only
+// made on request.
+var reportCfg *report.Config
+
+func handleReport(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	format := req.Args["format"]
+	if format != "" && format != "text" && format != "html" {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("unknown format %q (use text or html)", format)}
+	}
+	r, err := compileReport(s, req.Args["period"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if req.Args["send"] == "true" {
+		if err := sendReport(r); err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: err.Error()}
+		}
+	}
+	if format == "html" {
+		return &ipc.Response{OK: true, Message: r.HTML()}
+	}
+	return &ipc.Response{OK: true, Message: r.Text()}
+}
+
+// handleAuditExport returns every audit log record since the given time,
+// archives included, as CSV or JSON.
+func handleAuditExport(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	format := req.Args["format"]
+	if format == "" {
+		format = "csv"
+	}
+	now := clock.Now()
+	since, err := audit.ParseSince(req.Args["since"], now)
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	records, err := audit.Collect(vexlog.LogFilePath, since, now)
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to read the audit log: %v", err)}
+	}
+	var b strings.Builder
+	if err := audit.Write(&b, format, records); err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: err.Error()}
+	}
+	return &ipc.Response{OK: true, Message: b.String()}
+}
+
+// compileReport builds the report for the day or week ending now.
+func compileReport(s *state.SystemState, period string) (*report.Report, error) {
+	p, err := report.ParsePeriod(period, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	r, err := report.Build(vexlog.LogFilePath, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the audit log: %v", err)
+	}
+	r.Host, _ = os.Hostname()
+	r.Locked = s.Compliance.Locked
+	r.Score = s.Compliance.FailureScore
+	r.TaskStatus = s.Compliance.TaskStatus
+	for _, d := range surveillance.GetDailyUsage(p.Days()) {
+		day := report.Day{Date: d.Date, ActiveSeconds: d.ActiveSeconds, Keystrokes: d.Keystrokes}
+		for app, secs := range d.Apps {
+			if day.TopApp == "" || secs > d.Apps[day.TopApp] {
+				day.TopApp = app
+			}
+		}
+		r.Usage = append(r.Usage, day)
+	}
+	if samples, err := history.Read(p.From, p.To); err != nil {
+		log.Printf("Report: failed to read history: %v", err)
+	} else {
+		r.Trend = history.Bucket(samples, p.From, p.To, p.Buckets())
+	}
+	return r, nil
+}
+
+// sendReport posts and/or e-mails r as report.json says.  E-mail goes
+// out in the background.
+func sendReport(r *report.Report) error {
+	cfg := reportCfg
+	if cfg == nil {
+		return fmt.Errorf("reports are not configured (%s)", report.ConfigFile)
+	}
+	if cfg.Post && !notify.Keyholder("report", map[string]string{"period": r.Period.Name, "report": r.Text()}) {
+		log.Printf("Report: no notification channel accepts the report event")
+	}
+	if cfg.Email != nil {
+		go func() {
+			if err := report.Mail(cfg.Email, r); err != nil {
+				vexlog.LogEvent("REPORT", "MAIL_FAILED", err.Error())
+				return
+			}
+			vexlog.LogEvent("REPORT", "MAILED", fmt.Sprintf("period=%s to=%s", r.Period.Name, strings.Join(cfg.Email.To, ",")))
+		}()
+	}
+	vexlog.LogEvent("REPORT", "SENT", fmt.Sprintf("period=%s post=%v email=%v", r.Period.Name, cfg.Post, cfg.Email != nil))
+	return nil
+}
+
+// scheduleReports sends a report at each time report.json names.  A report
+// missed while the daemon was down goes out when it starts; on the very
+// first start, the schedule begins from now.
+func scheduleReports(srv *ipc.Server) {
+	srv.Update(func(s *state.SystemState) {
+		if s.LastReport == "" {
+			s.LastReport = clock.Now().Format(time.RFC3339)
+		}
+	})
+	scheduler.Set(reportJob, func(now time.Time) bool {
+		var due bool
+		srv.View(func(s *state.SystemState) {
+			last, err := time.Parse(time.RFC3339, s.LastReport)
+			due = err != nil || last.Before(reportCfg.LastDue(now))
+		})
+		return due
+	}, func(due bool) {
+		if !due {
+			return
+		}
+		srv.Update(func(s *state.SystemState) {
+			s.LastReport = clock.Now().Format(time.RFC3339)
+			r, err := compileReport(s, reportCfg.Period)
+			if err != nil {
+				log.Printf("Report: %v", err)
+				return
+			}
+			sendReport(r)
+		})
+	})
+}
+
+// ── Streaks ─────────────────────────────────────────────────────────
+
+const streakJob = "streak"
+
+// streakMilestones are the whole clean days, without a penance failure
+// or a kill, that publish events.StreakMilestone.
+var streakMilestones = []int{1, 3, 7, 14, 30, 60, 90, 100, 180, 365}
+
+// scheduleStreak starts a new streak after every failure or kill and
+// announces each milestone the current one reaches.  The first start
+// begins a streak from now.
+func scheduleStreak(srv *ipc.Server) {
+	scheduler.Set(streakJob, func(now time.Time) bool {
+		var due bool
+		srv.View(func(s *state.SystemState) {
+			since := streakStart(s, now)
+			due = since != s.Streak.Since || streakMilestone(since, now) > s.Streak.Milestone
+		})
+		return due
+	}, func(due bool) {
+		if !due {
+			return
+		}
+		srv.Update(func(s *state.SystemState) { advanceStreak(s, clock.Now()) })
+	})
+}
+
+// streakStart is when the current streak began: the last failure or kill
+// or, if there has been neither, when the streak was first tracked.
+func streakStart(s *state.SystemState, now time.Time) string {
+	if cs, err := penance.LoadComplianceStatus(); err == nil && cs.StreakBroken() != "" {
+		return cs.StreakBroken()
+	}
+	if s.Streak.Since != "" {
+		return s.Streak.Since
+	}
+	return now.UTC().Format(time.RFC3339)
+}
+
+// streakMilestone is the highest milestone a streak that began at since
+// has reached by now, 0 for none.
+func streakMilestone(since string, now time.Time) int {
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return 0
+	}
+	days := int(now.Sub(t) / (24 * time.Hour))
+	reached := 0
+	for _, m := range streakMilestones {
+		if days >= m {
+			reached = m
+		}
+	}
+	return reached
+}
+
+// advanceStreak restarts the streak after a failure or kill and
+// publishes the milestone it has reached, if that is new.
+func advanceStreak(s *state.SystemState, now time.Time) {
+	since := streakStart(s, now)
+	if since != s.Streak.Since {
+		s.Streak = state.StreakState{Since: since}
+	}
+	if m := streakMilestone(since, now); m > s.Streak.Milestone {
+		s.Streak.Milestone = m
+		t, _ := time.Parse(time.RFC3339, since)
+		events.Publish(events.StreakMilestone{Days: m, Since: t})
+	}
+}
+
+// ── Log checkpoints ─────────────────────────────────────────────────
+
+// checkpointJob is the scheduler job that seals each day of the log once
+// it is over.
+const checkpointJob = "checkpoint"
+
+// checkpointBackfill is how many missed days are sealed after the daemon
+// was down over midnight.
+const checkpointBackfill = 31
+
+var (
+	// checkpointCfg is the upload endpoint, nil if none.
+	checkpointCfg *checkpoint.Config
+
+	// lastCheckpoint is the newest checkpoint, nil before the first.
+	// Only the checkpoint job and audit-verify touch it.
+	lastCheckpoint *checkpoint.Checkpoint
+	checkpointMu   sync.Mutex
+)
+
+// scheduleCheckpoints registers the job that seals the previous day, due
+// while it has not been sealed.
+func scheduleCheckpoints() {
+	all, err := checkpoint.Read()
+	if err != nil {
+		log.Printf("Checkpoint initialization warning: %v", err)
+	}
+	if len(all) > 0 {
+		lastCheckpoint = &all[len(all)-1]
+	}
+	scheduler.Set(checkpointJob, func(now time.Time) bool {
+		checkpointMu.Lock()
+		defer checkpointMu.Unlock()
+		return lastCheckpoint == nil || lastCheckpoint.Date < checkpointDay(now).Format("2006-01-02")
+	}, func(due bool) {
+		if due {
+			sealCheckpoints(clock.Now())
+		}
+	})
+}
+
+// sealCheckpoints seals every day from the one after the last checkpoint
+// up to yesterday, at most checkpointBackfill of them; the first
+// checkpoint only seals yesterday.
+func sealCheckpoints(now time.Time) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	key, err := checkpoint.LoadKey()
+	if err != nil {
+		log.Printf("Checkpoint: cannot load the signing key: %v", err)
+		return
+	}
+	end := checkpointDay(now)
+	day := end
+	if lastCheckpoint != nil {
+		last, err := time.ParseInLocation("2006-01-02", lastCheckpoint.Date, time.Local)
+		if err == nil && last.AddDate(0, 0, checkpointBackfill).After(end) {
+			day = last.AddDate(0, 0, 1)
+		} else {
+			day = end.AddDate(0, 0, 1-checkpointBackfill)
+		}
+	}
+	for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		c, err := checkpoint.Seal(vexlog.LogFilePath, date, lastCheckpoint, key, now)
+		if err != nil {
+			log.Printf("Checkpoint: failed to seal %s: %v", date, err)
+			return
+		}
+		lastCheckpoint = c
+		vexlog.LogEvent("CHECKPOINT", "SEALED", fmt.Sprintf("date=%s entries=%d digest=%s", c.Date, c.Entries, c.Digest))
+		if checkpointCfg != nil {
+			if err := checkpoint.Upload(checkpointCfg, c); err != nil {
+				vexlog.LogEvent("CHECKPOINT", "UPLOAD_FAILED", fmt.Sprintf("date=%s error=%q", c.Date, err))
+			}
+		}
+	}
+}
+
+// checkpointDay is the start of the day due to be sealed at now:
+// yesterday.
+func checkpointDay(now time.Time) time.Time {
+	t := now.Local()
+	return time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, time.Local)
+}
+
+// handleAuditVerify checks the checkpoints, or only the one for date,
+// against the log as it is now.
+func handleAuditVerify(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	all, err := checkpoint.Read()
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: err.Error()}
+	}
+	date := req.Args["date"]
+	var out []ipc.Checkpoint
+	bad := 0
+	for i := range all {
+		c := &all[i]
+		if date != "" && c.Date != date {
+			continue
+		}
+		var prev *checkpoint.Checkpoint
+		if i > 0 {
+			prev = &all[i-1]
+		}
+		r := ipc.Checkpoint{Date: c.Date, Entries: c.Entries, Digest: c.Digest, Sealed: c.Sealed}
+		if err := checkpoint.Verify(vexlog.LogFilePath, c, prev); err != nil {
+			r.Error = err.Error()
+			bad++
+		}
+		out = append(out, r)
+	}
+	if date != "" && len(out) == 0 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no checkpoint for %s", date)}
+	}
+	if bad > 0 {
+		vexlog.LogEvent("CHECKPOINT", "VERIFY_FAILED", fmt.Sprintf("checked=%d failed=%d", len(out), bad))
+	}
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("%d of %d checkpoints verified", len(out)-bad, len(out)), Checkpoints: out}
+}
+
+// ── Credits ─────────────────────────────────────────────────────────
+
+// creditsJob is the scheduler job that credits each day once it is over.
+const creditsJob = "credits"
+
+// creditsCfg is the loaded credits configuration, nil if none.
+var creditsCfg *credits.Config
+
+// rewardSubsystems is the subsystem each kind of reward goes through.
+var rewardSubsystems = map[string]string{
+	credits.KindUnblock: subsystem.Guardian,
+	credits.KindRelax:   subsystem.Throttler,
+}
+
+// scheduleCredits registers the job that credits the previous day, due
+// while it has not been credited.  The day is tallied from the audit log
+// before taking the server lock.
+func scheduleCredits(srv *ipc.Server) {
+	scheduler.Set(creditsJob, func(now time.Time) bool {
+		var due bool
+		srv.View(func(s *state.SystemState) { due = s.Credits.LastDay != creditsDay(now) })
+		return due
+	}, func(due bool) {
+		if !due {
+			return
+		}
+		now := clock.Now()
+		d, err := tallyDay(now)
+		if err != nil {
+			log.Printf("Credits: failed to tally %s: %v", creditsDay(now), err)
+		}
+		srv.Update(func(s *state.SystemState) { earnCredits(s, creditsDay(now), d, err) })
+	})
+}
+
+// creditsDay is the day due to be credited at now: yesterday.
+func creditsDay(now time.Time) string {
+	return now.Local().AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// tallyDay sums up yesterday: the audit log says whether it was clean and
+// how many tasks were completed, the usage figures whether it stayed
+// within every usage rule.  With no rules there is no budget to stay in.
+func tallyDay(now time.Time) (credits.Day, error) {
+	t := now.Local()
+	to := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	d, err := credits.Tally(vexlog.LogFilePath, to.AddDate(0, 0, -1), to)
+	if err != nil {
+		return credits.Day{}, err
+	}
+	rules, err := surveillance.LoadUsageRules()
+	if err != nil {
+		return credits.Day{}, err
+	}
+	if len(rules) > 0 {
+		yesterday := surveillance.GetDailyUsage(2)[0]
+		d.UnderBudget = !slices.ContainsFunc(rules, func(r surveillance.UsageRule) bool { return r.Exceeded(yesterday) })
+	}
+	return d, nil
+}
+
+// earnCredits credits date with what d earned.  The first day seen only
+// starts the count, so setting credits up earns nothing for the time
+// before; a day that could not be tallied or ends during a pause earns
+// nothing either.
+func earnCredits(s *state.SystemState, date string, d credits.Day, tallyErr error) {
+	if s.Credits.LastDay == date {
+		return
+	}
+	first := s.Credits.LastDay == ""
+	s.Credits.LastDay, s.Credits.Earned = date, 0
+	switch {
+	case first:
+		vexlog.LogEvent("CREDITS", "STARTED", fmt.Sprintf("day=%s balance=%d", date, s.Credits.Balance))
+		return
+	case tallyErr != nil:
+		vexlog.LogEvent("CREDITS", "EARNED", fmt.Sprintf("day=%s earned=0 balance=%d error=%q", date, s.Credits.Balance, tallyErr))
+		return
+	case s.Pause != nil:
+		vexlog.LogEvent("CREDITS", "EARNED", fmt.Sprintf("day=%s earned=0 balance=%d ignored=paused", date, s.Credits.Balance))
+		return
+	}
+	earned := creditsCfg.Earned(d)
+	s.Credits.Earned = earned
+	s.Credits.Balance = creditsCfg.Credit(s.Credits.Balance, earned)
+	s.ChangedBy = "credits"
+	vexlog.LogEvent("CREDITS", "EARNED", fmt.Sprintf("day=%s clean=%v completed=%d under_budget=%v earned=%d balance=%d",
+		date, d.Clean, d.Completions, d.UnderBudget, earned, s.Credits.Balance))
+}
+
+// handleCredits reports the balance and the rewards on offer.
+func handleCredits(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if creditsCfg == nil {
+		return &ipc.Response{OK: false, Error: "no credits configured (" + credits.ConfigFile + ")"}
+	}
+	resp := &ipc.Response{OK: true, State: s}
+	for _, name := range creditsCfg.Names() {
+		r := creditsCfg.Rewards[name]
+		resp.Rewards = append(resp.Rewards, ipc.Reward{
+			Name: name, Kind: r.Kind, Cost: r.Cost, Minutes: r.Minutes,
+			Targets: r.Targets, Profile: r.Profile, Lines: r.Lines,
+		})
+	}
+	return resp
+}
+
+// handleCreditsSpend buys a reward.  The credits are only taken once the
+// reward has been carried out, and the keyholder is told about it.
+func handleCreditsSpend(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if creditsCfg == nil {
+		return &ipc.Response{OK: false, Error: "no credits configured (" + credits.ConfigFile + ")"}
+	}
+	name := req.Args["reward"]
+	r, ok := creditsCfg.Rewards[name]
+	if !ok {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("unknown reward %q (see vex-cli credits)", name)}
+	}
+	if sub, ok := rewardSubsystems[r.Kind]; ok && !subsystem.Enabled(sub) {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("the %s subsystem is off in %s", sub, config.File)}
+	}
+	if s.Credits.Balance < r.Cost {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("%s costs %d credits; the balance is %d", name, r.Cost, s.Credits.Balance)}
+	}
+
+	var msg string
+	var resp *ipc.Response
+	target := strings.TrimSpace(req.Args["target"])
+	switch r.Kind {
+	case credits.KindUnblock:
+		msg, target, resp = spendUnblock(s, name, r, target)
+	case credits.KindRelax:
+		msg, resp = spendRelax(s, r)
+	case credits.KindLines:
+		msg, resp = spendLines(s, r)
+	}
+	if resp != nil {
+		return resp
+	}
+
+	s.Credits.Balance -= r.Cost
+	s.ChangedBy = "credits"
+	vexlog.LogEvent("CREDITS", "SPENT", fmt.Sprintf("reward=%s kind=%s cost=%d target=%s balance=%d",
+		name, r.Kind, r.Cost, target, s.Credits.Balance))
+	notify.Keyholder("credits_spent", map[string]string{
+		"reward": name, "kind": r.Kind, "cost": strconv.Itoa(r.Cost),
+		"target": target, "balance": strconv.Itoa(s.Credits.Balance),
+	})
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("%s (%d credits spent, %d left)", msg, r.Cost, s.Credits.Balance),
+		State:   s,
+	}
+}
+
+// spendUnblock lifts a blocked domain or forbidden app for the reward's
+// minutes, the way an approved exception does, and returns the target as
+// it is logged.
+func spendUnblock(s *state.SystemState, name string, r credits.Reward, target string) (string, string, *ipc.Response) {
+	if target == "" {
+		return "", "", &ipc.Response{OK: false, Error: "missing 'target' argument"}
+	}
+	if !r.Allows(target) {
+		return "", "", &ipc.Response{OK: false, Error: fmt.Sprintf("%s only unblocks %s", name, strings.Join(r.Targets, ", "))}
+	}
+	var kind string
+	switch {
+	case containsFold(s.Guardian.BlockedDomains, target):
+		kind, target = "domain", strings.ToLower(target)
+	case containsFold(guardian.GetForbiddenApps(), target):
+		kind = "app"
+	default:
+		return "", "", &ipc.Response{OK: false, Error: fmt.Sprintf("%s is neither a blocked domain nor a forbidden app", target)}
+	}
+	if why := exceptionRefusal(s, kind, target); why != "" {
+		return "", "", &ipc.Response{OK: false, Error: why}
+	}
+
+	key := kind + ":" + target
+	previous, resp := liftTemporarily(s, key, "reward "+name)
+	if resp != nil {
+		return "", "", resp
+	}
+	until := clock.Now().Add(time.Duration(r.Minutes) * time.Minute)
+	reimposeAt(s, key, previous, until)
+	return fmt.Sprintf("%s allowed until %s", target, until.Local().Format("15:04:05")), key, nil
+}
+
+// spendRelax applies the reward's lighter profile for its minutes, as
+// "throttle --for" would.
+func spendRelax(s *state.SystemState, r credits.Reward) (string, *ipc.Response) {
+	p, _ := throttler.ResolveProfile(r.Profile)
+	if throttler.Severity(p) >= throttler.Severity(throttler.Profile(s.Network.Profile)) {
+		return "", &ipc.Response{OK: false, Error: fmt.Sprintf("the network profile is already %s, no heavier than %s", s.Network.Profile, p)}
+	}
+	period := time.Duration(r.Minutes) * time.Minute
+	resp := withExpiry(expiryThrottle, handleThrottle)(s, &ipc.Request{
+		Command: ipc.CmdThrottle,
+		Args:    map[string]string{"profile": string(p), "for": period.String()},
+	})
+	if !resp.OK {
+		return "", resp
+	}
+	return resp.Message, nil
+}
+
+// spendLines takes lines off the active writing task.  At least one line
+// is always left to write, so the task is still completed by the user.
+func spendLines(s *state.SystemState, r credits.Reward) (string, *ipc.Response) {
+	w := &s.Writing
+	if !w.Active {
+		return "", &ipc.Response{OK: false, Error: "no writing task is active"}
+	}
+	left := w.Required - w.Completed
+	if left <= 1 {
+		return "", &ipc.Response{OK: false, Error: "only one line is left to write"}
+	}
+	cut := min(r.Lines, left-1)
+	w.Required -= cut
+	vexlog.LogEvent("WRITING", "TASK_REDUCED", fmt.Sprintf("phrase=%q by=%d required=%d source=credits", w.Phrase, cut, w.Required))
+	return fmt.Sprintf("%d lines taken off the writing task (%d of %d written)", cut, w.Completed, w.Required), nil
+}
+
+// ── Exception requests ──────────────────────────────────────────────
+
+// exceptionPolicyFile is the keyholder's policy for exception requests.
+var exceptionPolicyFile = "/etc/vex-cli/exceptions.json"
+
+// exceptionPolicy bounds what may be requested and, optionally, approves
+// requests the keyholder has not answered after a delay.
+type exceptionPolicy struct {
+	MaxMinutes         int `json:"max_minutes,omitempty"`          // longest exception (default 240)
+	AutoApproveMinutes int `json:"auto_approve_minutes,omitempty"` // 0 = signed approval only
+	PassWaitMinutes    int `json:"pass_wait_minutes,omitempty"`    // countdown before a block pass (default 10)
+	PassMinutes        int `json:"pass_minutes,omitempty"`         // how long a block pass lasts (default 5)
+}
+
+var defaultExceptionPolicy = exceptionPolicy{MaxMinutes: 240, PassWaitMinutes: 10, PassMinutes: 5}
+
+var exceptionCfg = defaultExceptionPolicy
+
+var exceptionTimer *clock.Timer
+
+// loadExceptionPolicy reads exceptionPolicyFile.  A missing file keeps
+// the defaults.
+func loadExceptionPolicy() error {
+	data, err := os.ReadFile(exceptionPolicyFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p := defaultExceptionPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("%s: %w", exceptionPolicyFile, err)
+	}
+	if p.MaxMinutes <= 0 || p.AutoApproveMinutes < 0 {
+		return fmt.Errorf("%s: max_minutes must be positive and auto_approve_minutes not negative", exceptionPolicyFile)
+	}
+	if p.PassWaitMinutes <= 0 || p.PassMinutes <= 0 {
+		return fmt.Errorf("%s: pass_wait_minutes and pass_minutes must be positive", exceptionPolicyFile)
+	}
+	exceptionCfg = p
+	log.Printf("Exceptions: max %dm, auto-approve after %dm (0 = never); passes %dm after a %dm wait",
+		p.MaxMinutes, p.AutoApproveMinutes, p.PassMinutes, p.PassWaitMinutes)
+	return nil
+}
+
+func findException(s *state.SystemState, id string) int {
+	return slices.IndexFunc(s.Exceptions, func(r state.ExceptionRequest) bool { return r.ID == id })
+}
+
+func exceptionDetails(r state.ExceptionRequest) map[string]string {
+	return map[string]string{
+		"id": r.ID, "kind": r.Kind, "target": r.Target, "for": r.For,
+		"reason": r.Reason, "auto_approve": r.AutoApprove,
+	}
+}
+
+// exceptionRefusal explains why a target cannot be lifted right now:
+// the curfew and calendar presets own what they imposed until they end.
+func exceptionRefusal(s *state.SystemState, kind, target string) string {
+	if kind == "domain" && containsFold(s.Calendar.AddedDomains, target) ||
+		kind == "app" && containsFold(s.Calendar.AddedApps, target) {
+		return fmt.Sprintf("%s is imposed by calendar preset(s) %s until the event ends",
+			target, strings.Join(s.Calendar.Presets, ", "))
+	}
+	if kind == "app" && s.Curfew.Active && containsFold(s.Curfew.AddedApps, target) {
+		return curfewRefusal(s)
+	}
+	return ""
+}
+
+// handleExceptionRequest queues a request to lift one blocked domain or
+// forbidden app and tells the keyholder about it.  Nothing is lifted
+// until a signed approval arrives or the policy's auto-approve delay
+// passes.
+func handleExceptionRequest(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if s.Pause != nil {
+		return &ipc.Response{OK: false, Error: "enforcement is paused; nothing needs an exception"}
+	}
+	target := strings.TrimSpace(req.Args["target"])
+	if target == "" {
+		return &ipc.Response{OK: false, Error: "missing 'target' argument"}
+	}
+	period, err := time.ParseDuration(req.Args["for"])
+	if err != nil || period <= 0 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid --for duration %q (e.g. 30m, 2h)", req.Args["for"])}
+	}
+	if max := time.Duration(exceptionCfg.MaxMinutes) * time.Minute; period > max {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("an exception may last at most %s", max)}
+	}
+
+	var kind string
+	switch {
+	case containsFold(s.Guardian.BlockedDomains, target):
+		kind, target = "domain", strings.ToLower(target)
+	case containsFold(guardian.GetForbiddenApps(), target):
+		kind = "app"
+	default:
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("%s is neither a blocked domain nor a forbidden app", target)}
+	}
+	if why := exceptionRefusal(s, kind, target); why != "" {
+		return &ipc.Response{OK: false, Error: why}
+	}
+	for _, r := range s.Exceptions {
+		if strings.EqualFold(r.Target, target) {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("request %s for %s is already pending", r.ID, r.Target)}
+		}
+	}
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to generate request ID: %v", err)}
+	}
+	now := clock.Now()
+	r := state.ExceptionRequest{
+		ID:        hex.EncodeToString(id),
+		Kind:      kind,
+		Target:    target,
+		For:       req.Args["for"],
+		Reason:    strings.TrimSpace(req.Args["reason"]),
+		Requested: now.UTC().Format(time.RFC3339),
+	}
+	autoAt := now.Add(time.Duration(exceptionCfg.AutoApproveMinutes) * time.Minute)
+	if exceptionCfg.AutoApproveMinutes > 0 {
+		r.AutoApprove = autoAt.UTC().Format(time.RFC3339)
+	}
+	s.Exceptions = append(s.Exceptions, r)
+	armExceptions(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("EXCEPTION", "REQUESTED", fmt.Sprintf("id=%s %s=%s for=%s reason=%q auto_approve=%s",
+		r.ID, r.Kind, r.Target, r.For, r.Reason, r.AutoApprove))
+
+	msg := fmt.Sprintf("Exception %s requested: %s %s for %s.", r.ID, kind, target, r.For)
+	if notify.Keyholder("exception_requested", exceptionDetails(r)) {
+		msg += " The keyholder has been notified."
+	} else {
+		msg += " No notification channel is configured; send the keyholder the request ID."
+	}
+	if r.AutoApprove != "" {
+		msg += fmt.Sprintf(" It is approved automatically at %s unless withdrawn.", autoAt.Local().Format("15:04"))
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+func handleExceptionList(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	return &ipc.Response{OK: true, State: s}
+}
+
+// handleExceptionCancel withdraws a pending request.  Withdrawing only
+// keeps a restriction in place, so it needs no signature.
+func handleExceptionCancel(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	i := findException(s, req.Args["id"])
+	if i < 0 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no pending exception request %q", req.Args["id"])}
+	}
+	r := s.Exceptions[i]
+	s.Exceptions = slices.Delete(s.Exceptions, i, i+1)
+	armExceptions(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("EXCEPTION", "WITHDRAWN", fmt.Sprintf("id=%s %s=%s", r.ID, r.Kind, r.Target))
+	notify.Keyholder("exception_withdrawn", exceptionDetails(r))
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Exception request %s withdrawn", r.ID), State: s}
+}
+
+// handleApprove applies a pending exception.  The CLI has already
+// verified the signed payload; its args are the request ID, which is
+// random, so an old approval cannot be replayed for a new request.
+func handleApprove(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if s.Pause != nil {
+		return &ipc.Response{OK: false, Error: pauseRefusal(s)}
+	}
+	return approveException(s, req.Args["id"], "keyholder")
+}
+
+// approveException lifts the request's target and schedules it to be
+// re-imposed.  On failure the request stays pending.
+func approveException(s *state.SystemState, id, by string) *ipc.Response {
+	i := findException(s, id)
+	if i < 0 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no pending exception request %q", id)}
+	}
+	r := s.Exceptions[i]
+	if why := exceptionRefusal(s, r.Kind, r.Target); why != "" {
+		return &ipc.Response{OK: false, Error: why}
+	}
+	period, err := time.ParseDuration(r.For)
+	if err != nil {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("request %s has an invalid duration %q", r.ID, r.For)}
+	}
+
+	target := r.Kind + ":" + r.Target
+	previous, resp := liftTemporarily(s, target, "exception "+r.ID)
+	if resp != nil {
+		return resp
+	}
+
+	s.Exceptions = slices.Delete(s.Exceptions, i, i+1)
+	armExceptions(s)
+	until := clock.Now().Add(period)
+	s.ChangedBy = "exception"
+	details := exceptionDetails(r)
+	details["by"] = by
+	if previous == "allowed" {
+		vexlog.LogEvent("EXCEPTION", "APPROVED", fmt.Sprintf("id=%s %s=%s by=%s (already allowed)", r.ID, r.Kind, r.Target, by))
+		notify.Keyholder("exception_approved", details)
+		return &ipc.Response{OK: true, Message: fmt.Sprintf("Exception %s approved, but %s is no longer restricted", r.ID, r.Target), State: s}
+	}
+
+	reimposeAt(s, target, previous, until)
+	details["until"] = until.UTC().Format(time.RFC3339)
+	vexlog.LogEvent("EXCEPTION", "APPROVED", fmt.Sprintf("id=%s %s=%s for=%s until=%s by=%s",
+		r.ID, r.Kind, r.Target, r.For, until.UTC().Format(time.RFC3339), by))
+	notify.Keyholder("exception_approved", details)
+
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("Exception %s approved: %s allowed until %s", r.ID, r.Target, until.Local().Format("15:04:05")),
+		State:   s,
+	}
+}
+
+// liftTemporarily lifts a target ("domain:reddit.com" or "app:zoom") for
+// an exception or pass, named by why, and returns what it was before.  A
+// target that is already allowed is left alone.  On failure the response
+// says why.
+func liftTemporarily(s *state.SystemState, target, why string) (string, *ipc.Response) {
+	kind, name, _ := strings.Cut(target, ":")
+	previous := expiryException.current(s, target)
+	if previous == "allowed" {
+		return previous, nil
+	}
+	if dryRun {
+		log.Printf("[DRY-RUN] Would lift %s %s for %s", kind, name, why)
+		if kind == "domain" {
+			s.Guardian.BlockedDomains = removeFold(s.Guardian.BlockedDomains, name)
+		}
+	} else if kind == "domain" {
+		_, err := guardian.RemoveDomain(name)
+		s.Guardian.RecordApply(err)
+		if err != nil {
+			return "", &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to unblock %s: %v", name, err)}
+		}
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+	} else if _, err := guardian.RemoveForbiddenApp(name); err != nil {
+		return "", &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to allow %s: %v", name, err)}
+	}
+	return previous, nil
+}
+
+// reimposeAt schedules a target lifted by liftTemporarily to be
+// re-imposed at until.
+func reimposeAt(s *state.SystemState, target, previous string, until time.Time) {
+	s.Expiries = append(s.Expiries, state.Expiry{
+		Kind:     expiryException.name,
+		Target:   target,
+		Value:    "allowed",
+		Previous: previous,
+		Until:    until.UTC().Format(time.RFC3339),
+	})
+	armExpiries(s)
+}
+
+// reimposeException ends an approved exception.  If the curfew or a
+// calendar preset imposed the same target meanwhile it is handed over
+// rather than re-added, so their end leaves it restricted.
+func reimposeException(s *state.SystemState, target string) *ipc.Response {
+	kind, name, _ := strings.Cut(target, ":")
+	if kind == "domain" {
+		s.Calendar.AddedDomains = removeFold(s.Calendar.AddedDomains, name)
+		return handleBlockAdd(s, &ipc.Request{Args: map[string]string{"domain": name}})
+	}
+	s.Calendar.AddedApps = removeFold(s.Calendar.AddedApps, name)
+	s.Curfew.AddedApps = removeFold(s.Curfew.AddedApps, name)
+	return handleAppAdd(s, &ipc.Request{Args: map[string]string{"app": name}})
+}
+
+// approveDueExceptions approves requests whose auto-approve time has
+// passed.  Nothing is approved during a pause; endPause calls this again.
+// Runs under the server lock.
+func approveDueExceptions(s *state.SystemState) {
+	if s.Pause != nil {
+		return
+	}
+	now := clock.Now()
+	for _, r := range slices.Clone(s.Exceptions) {
+		if r.AutoApprove == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, r.AutoApprove); err == nil && now.Before(t) {
+			continue
+		}
+		if resp := approveException(s, r.ID, "auto"); !resp.OK {
+			// Leave it for the keyholder rather than retrying forever.
+			log.Printf("Exceptions: auto-approve of %s failed: %s", r.ID, resp.Error)
+			vexlog.LogEvent("EXCEPTION", "AUTO_APPROVE_FAILED", fmt.Sprintf("id=%s %s=%s error=%s", r.ID, r.Kind, r.Target, resp.Error))
+			if i := findException(s, r.ID); i >= 0 {
+				s.Exceptions[i].AutoApprove = ""
+			}
+		}
+	}
+	armExceptions(s)
+}
+
+// armExceptions (re)starts the timer for the earliest auto-approval.
+func armExceptions(s *state.SystemState) {
+	if exceptionTimer != nil {
+		exceptionTimer.Stop()
+		exceptionTimer = nil
+	}
+	if liveSrv == nil {
+		return
+	}
+	var next time.Time
+	for _, r := range s.Exceptions {
+		if r.AutoApprove == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, r.AutoApprove)
+		if err != nil {
+			t = clock.Now()
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	if !next.IsZero() {
+		exceptionTimer = clock.AfterFunc(clock.Until(next), func() { liveSrv.Update(approveDueExceptions) })
+	}
+}
+
+// ── Block passes ────────────────────────────────────────────────────
+
+// A block pass lifts one blocked domain for exceptionCfg.PassMinutes, with
+// no keyholder involved, once its countdown of exceptionCfg.PassWaitMinutes
+// has run out.  The countdown only runs while vex-cli watches it: a pass
+// that goes passCheckInGap without checking in is void.  Passes are not
+// persisted; a restart voids any countdown.
+
+// passCheckInGap is the longest a pass may go without checking in.
+const passCheckInGap = 30 * time.Second
+
+// blockPass is a countdown in progress.
+type blockPass struct {
+	id      string
+	domain  string
+	readyAt time.Time
+	seen    time.Time // last check-in
+}
+
+// passes are the countdowns in progress, by domain.  Guarded by the server
+// lock, as handlers run under it.
+var passes = make(map[string]*blockPass)
+
+// handleBlockPass starts a countdown for a blocked domain or, given the
+// pass ID, checks in on it.  The check-in after the countdown has run out
+// grants the pass.  Starting again restarts the countdown.
+func handleBlockPass(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	domain := strings.ToLower(strings.TrimSpace(req.Args["domain"]))
+	if domain == "" {
+		return &ipc.Response{OK: false, Error: "missing 'domain' argument"}
+	}
+	now := clock.Now()
+	for d, p := range passes {
+		if now.Sub(p.seen) > passCheckInGap {
+			delete(passes, d)
+		}
+	}
+
+	id := req.Args["id"]
+	if id == "" {
+		if !containsFold(s.Guardian.BlockedDomains, domain) {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s is not blocked", domain)}
+		}
+		if why := exceptionRefusal(s, "domain", domain); why != "" {
+			return &ipc.Response{OK: false, Error: why}
+		}
+		raw := make([]byte, 4)
+		if _, err := rand.Read(raw); err != nil {
+			return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to generate pass ID: %v", err)}
+		}
+		p := &blockPass{
+			id:      hex.EncodeToString(raw),
+			domain:  domain,
+			readyAt: now.Add(time.Duration(exceptionCfg.PassWaitMinutes) * time.Minute),
+			seen:    now,
+		}
+		passes[domain] = p
+		vexlog.LogEvent("GUARDIAN", "PASS_STARTED", fmt.Sprintf("id=%s domain=%s ready_at=%s",
+			p.id, domain, p.readyAt.UTC().Format(time.RFC3339)))
+		return &ipc.Response{OK: true, Pass: passReport(p, time.Time{})}
+	}
+
+	p, ok := passes[domain]
+	if !ok || p.id != id {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("no countdown %s for %s; it was restarted or went %s without checking in", id, domain, passCheckInGap)}
+	}
+	p.seen = now
+	if now.Before(p.readyAt) {
+		return &ipc.Response{OK: true, Pass: passReport(p, time.Time{})}
+	}
+
+	delete(passes, domain)
+	if why := exceptionRefusal(s, "domain", domain); why != "" {
+		return &ipc.Response{OK: false, Error: why}
+	}
+	target := "domain:" + domain
+	previous, resp := liftTemporarily(s, target, "pass "+p.id)
+	if resp != nil {
+		return resp
+	}
+	if previous == "allowed" {
+		return &ipc.Response{OK: true, Message: fmt.Sprintf("%s is no longer blocked", domain), State: s}
+	}
+	until := now.Add(time.Duration(exceptionCfg.PassMinutes) * time.Minute)
+	reimposeAt(s, target, previous, until)
+	s.ChangedBy = "pass"
+	vexlog.LogEvent("GUARDIAN", "PASS_GRANTED", fmt.Sprintf("id=%s domain=%s until=%s", p.id, domain, until.UTC().Format(time.RFC3339)))
+	notify.Keyholder("pass_granted", map[string]string{"id": p.id, "domain": domain, "until": until.UTC().Format(time.RFC3339)})
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("Pass granted: %s allowed until %s", domain, until.Local().Format("15:04:05")),
+		Pass:    passReport(p, until),
+		State:   s,
+	}
+}
+
+func passReport(p *blockPass, until time.Time) *ipc.BlockPass {
+	r := &ipc.BlockPass{ID: p.id, Domain: p.domain, ReadyAt: p.readyAt.UTC().Format(time.RFC3339)}
+	if !until.IsZero() {
+		r.Until = until.UTC().Format(time.RFC3339)
+	}
+	return r
+}
+
+// ── Monitored domains ───────────────────────────────────────────────
+
+// A monitored domain warns before it blocks, to wean the subject off a
+// site rather than cut it off: the stub resolver reports each access, the
+// first Allowed of a day are sent as warnings, and the next one blocks
+// the domain until the day is over.  The count starts again every day.
+
+var monitorDayTimer *clock.Timer
+
+// handleBlockMonitor puts a domain in monitor mode, or lowers the
+// accesses a day a monitored one is allowed.
+func handleBlockMonitor(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	domain, err := guardian.NormalizeEntry(req.Args["domain"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if len(guardian.DomainNames([]string{domain})) == 0 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("%s: only domain names can be monitored", domain)}
+	}
+	allowed, err := strconv.Atoi(req.Args["allowed"])
+	if err != nil || allowed < 1 {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid access count %q (a positive number)", req.Args["allowed"])}
+	}
+	if !dryRun && !guardian.StubActive() {
+		return &ipc.Response{OK: false, Error: "monitor mode counts accesses through the stub resolver; set guardian.stub_resolver in config.json"}
+	}
+
+	if i := monitoredIndex(s, domain); i >= 0 {
+		m := &s.Guardian.Monitored[i]
+		if allowed > m.Allowed {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s allows %d accesses a day; to allow more, remove it with 'block rm' first", domain, m.Allowed)}
+		}
+		m.Allowed = allowed
+	} else {
+		if containsFold(s.Guardian.BlockedDomains, domain) {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s is already blocked", domain)}
+		}
+		s.Guardian.Monitored = append(s.Guardian.Monitored, state.MonitoredDomain{
+			Domain: domain, Allowed: allowed, Day: clock.Now().Format(time.DateOnly),
+		})
+	}
+	guardian.SetMonitoredDomains(monitoredNames(s))
+	armMonitorDay(s)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("GUARDIAN", "DOMAIN_MONITORED", fmt.Sprintf("domain=%s allowed=%d source=cli", domain, allowed))
+
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Monitoring %s: %d accesses a day warn, the next blocks it until tomorrow", domain, allowed), State: s}
+}
+
+// monitorAccess counts an access to a monitored domain, reported by the
+// stub resolver: a warning while the day's allowance lasts, then the
+// block.  Accesses while paused do not count.
+func monitorAccess(domain string) {
+	if liveSrv == nil {
+		return
+	}
+	liveSrv.Update(func(s *state.SystemState) {
+		i := monitoredIndex(s, domain)
+		if i < 0 || s.Pause != nil {
+			return
+		}
+		m := &s.Guardian.Monitored[i]
+		if m.Blocked {
+			return // an answer cached before the block
+		}
+		m.Accesses++
+		details := map[string]string{"domain": domain, "access": strconv.Itoa(m.Accesses), "allowed": strconv.Itoa(m.Allowed)}
+		if m.Accesses <= m.Allowed {
+			vexlog.LogEvent("GUARDIAN", "MONITOR_WARNING", fmt.Sprintf("domain=%s access=%d allowed=%d", domain, m.Accesses, m.Allowed))
+			notify.Keyholder("domain_warning", details)
+			return
+		}
+		if !dryRun {
+			_, err := guardian.AddDomain(domain)
+			s.Guardian.RecordApply(err)
+			if err != nil {
+				log.Printf("Guardian: failed to block monitored %s: %v", domain, err)
+				return
+			}
+		} else {
+			log.Printf("[DRY-RUN] Would block monitored domain: %s", domain)
+		}
+		m.Blocked = true
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+		s.ChangedBy = "daemon"
+		vexlog.LogEvent("GUARDIAN", "MONITOR_BLOCKED", fmt.Sprintf("domain=%s access=%d allowed=%d", domain, m.Accesses, m.Allowed))
+		details["blocked"] = "true"
+		notify.Keyholder("domain_warning", details)
+	})
+}
+
+// monitorDayIfDue starts a new day's count for the monitored domains and
+// unblocks those yesterday's count blocked, then arms the timer for the
+// next day.  Runs under the server lock.
+func monitorDayIfDue(s *state.SystemState) {
+	today := clock.Now().Format(time.DateOnly)
+	changed := false
+	for i := range s.Guardian.Monitored {
+		m := &s.Guardian.Monitored[i]
+		if m.Day == today {
+			continue
+		}
+		if m.Blocked {
+			if !dryRun {
+				_, err := guardian.RemoveDomain(m.Domain)
+				s.Guardian.RecordApply(err)
+				if err != nil {
+					log.Printf("Guardian: failed to unblock monitored %s: %v", m.Domain, err)
+					continue
+				}
+			}
+			if s.Pause != nil {
+				s.Pause.Saved.Guardian.BlockedDomains = removeFold(s.Pause.Saved.Guardian.BlockedDomains, m.Domain)
+			}
+			vexlog.LogEvent("GUARDIAN", "MONITOR_UNBLOCKED", fmt.Sprintf("domain=%s day=%s", m.Domain, today))
+		}
+		m.Day, m.Accesses, m.Blocked = today, 0, false
+		changed = true
+	}
+	if changed && s.Pause == nil {
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+		s.ChangedBy = "daemon"
+	}
+	armMonitorDay(s)
+}
+
+// armMonitorDay sets the timer for the next local midnight while any
+// domain is monitored.
+func armMonitorDay(s *state.SystemState) {
+	if monitorDayTimer != nil {
+		monitorDayTimer.Stop()
+		monitorDayTimer = nil
+	}
+	if liveSrv == nil || len(s.Guardian.Monitored) == 0 {
+		return
+	}
+	y, mo, d := clock.Now().Date()
+	midnight := time.Date(y, mo, d+1, 0, 0, 0, 0, time.Local)
+	monitorDayTimer = clock.AfterFunc(clock.Until(midnight), func() { liveSrv.Update(monitorDayIfDue) })
+}
+
+func monitoredIndex(s *state.SystemState, domain string) int {
+	return slices.IndexFunc(s.Guardian.Monitored, func(m state.MonitoredDomain) bool {
+		return strings.EqualFold(m.Domain, domain)
+	})
+}
+
+func monitoredNames(s *state.SystemState) []string {
+	names := make([]string, len(s.Guardian.Monitored))
+	for i, m := range s.Guardian.Monitored {
+		names[i] = m.Domain
+	}
+	return names
+}
+
+// ── Lock-until (countdown) ──────────────────────────────────────────
+
+// handleLockUntil locks the system until a deadline that completing a
+// task does not shorten.  An existing deadline is only ever extended.
+func handleLockUntil(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	until, err := parseDeadline(req.Args["until"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+
+	cs, err := penance.LoadComplianceStatus()
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to load compliance: %v", err)}
+	}
+	if current, ok := cs.LockedUntil(); ok && !until.After(current) {
+		return &ipc.Response{OK: false, Code: ipc.CodeLocked, Error: fmt.Sprintf(
+			"already locked until %s; a deadline can only be extended", current.Local().Format(time.RFC3339))}
+	}
+
+	wasLocked := cs.Locked
+	cs.Locked = true
+	cs.LockUntil = until.UTC().Format(time.RFC3339)
+	if err := penance.SaveComplianceStatus(cs); err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to save compliance: %v", err)}
+	}
+
+	// Entering the locked state imposes the manifest's penalty, as at boot.
+	if !wasLocked {
+		if m := penance.CurrentManifest; m != nil {
+			if !dryRun {
+				syncPenanceOverrides(s, m, m.EnforceState())
+				if s.Curfew.Active {
+					s.Curfew.SavedProfile = s.Network.Profile
+					setCurfew(s, true)
+				}
+			} else {
+				log.Printf("[DRY-RUN] Would enforce penance manifest overrides")
+			}
+		}
+	}
+
+	s.Compliance.Locked = true
+	s.Compliance.LockUntil = cs.LockUntil
+	var keep []state.Expiry
+	for _, e := range s.Expiries {
+		if e.Kind != expiryLock.name {
+			keep = append(keep, e)
+		}
+	}
+	s.Expiries = append(keep, state.Expiry{
+		Kind:     expiryLock.name,
+		Value:    "locked",
+		Previous: "unlocked",
+		Until:    cs.LockUntil,
+	})
+	armExpiries(s)
+
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("PENANCE", "LOCK_UNTIL", fmt.Sprintf("until=%s was_locked=%v source=cli", cs.LockUntil, wasLocked))
+
+	return &ipc.Response{
+		OK: true,
+		Message: fmt.Sprintf("System locked until %s (%s)",
+			until.Local().Format("Mon 2006-01-02 15:04"), clock.Until(until).Round(time.Minute)),
+		State: s,
+	}
+}
+
+// parseDeadline accepts an RFC3339 time or a duration from now and
+// requires the result to be in the future.
+func parseDeadline(arg string) (time.Time, error) {
+	until, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		d, derr := time.ParseDuration(arg)
+		if derr != nil {
+			return time.Time{}, fmt.Errorf("invalid deadline %q (RFC3339 time or duration such as 8h)", arg)
+		}
+		until = clock.Now().Add(d)
+	}
+	if !until.After(clock.Now()) {
+		return time.Time{}, fmt.Errorf("deadline must be in the future")
+	}
+	return until, nil
+}
+
+// handleEarlyRelease ends a lockuntil sentence before its deadline and
+// lifts restrictions.  The CLI has already verified the signed payload.
+func handleEarlyRelease(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	cs, err := penance.LoadComplianceStatus()
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to load compliance: %v", err)}
+	}
+	until, ok := cs.LockedUntil()
+	if !ok {
+		return &ipc.Response{OK: false, Error: "no lockuntil deadline is active"}
+	}
+	vexlog.LogEvent("PENANCE", "EARLY_RELEASE",
+		fmt.Sprintf("deadline=%s remaining=%s", cs.LockUntil, clock.Until(until).Round(time.Minute)))
+
+	var keep []state.Expiry
+	for _, e := range s.Expiries {
+		if e.Kind != expiryLock.name {
+			keep = append(keep, e)
+		}
+	}
+	s.Expiries = keep
+	resp := releaseLock(s, "early-release")
+	armExpiries(s)
+	return resp
+}
+
+// releaseLock clears the lockuntil deadline and lifts restrictions.
+func releaseLock(s *state.SystemState, reason string) *ipc.Response {
+	cs, err := penance.LoadComplianceStatus()
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to load compliance: %v", err)}
+	}
+	cs.LockUntil = ""
+	if err := penance.SaveComplianceStatus(cs); err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to save compliance: %v", err)}
+	}
+	s.Compliance.LockUntil = ""
+	vexlog.LogEvent("PENANCE", "LOCK_RELEASED", "reason="+reason)
+
+	resp := handleUnlock(s, &ipc.Request{Command: ipc.CmdUnlock})
+	if resp.OK {
+		resp.Message = "Lock released. " + resp.Message
+	}
+	return resp
+}
+
+// ── Vacation pause ──────────────────────────────────────────────────
+
+// maxPause bounds a single pause; a longer absence needs a new signature.
+const maxPause = 31 * 24 * time.Hour
+
+var pauseTimer *clock.Timer
+
+// pauseRefusal is returned by enforcement commands while paused.
+func pauseRefusal(s *state.SystemState) string {
+	until, _ := time.Parse(time.RFC3339, s.Pause.Until)
+	return fmt.Sprintf("enforcement is paused until %s; run 'vex-cli resume' first",
+		until.Local().Format("Mon 2006-01-02 15:04"))
+}
+
+// unlessPaused refuses a command that would impose something during a
+// pause.
+func unlessPaused(h ipc.Handler) ipc.Handler {
+	return func(s *state.SystemState, req *ipc.Request) *ipc.Response {
+		if s.Pause != nil {
+			return &ipc.Response{OK: false, Error: pauseRefusal(s)}
+		}
+		return h(s, req)
+	}
+}
+
+// unlessOff refuses a command aimed at a subsystem that config.json
+// turns off.  One in dry-run takes commands as usual.
+func unlessOff(name string, h ipc.Handler) ipc.Handler {
+	return func(s *state.SystemState, req *ipc.Request) *ipc.Response {
+		if !subsystem.Enabled(name) {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("the %s subsystem is off in %s", name, config.File)}
+		}
+		return h(s, req)
+	}
+}
+
+// handlePause suspends all enforcement until a deadline.  The CLI has
+// already verified the signed payload.  Pausing again while paused moves
+// the deadline and keeps the original snapshot.
+func handlePause(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	until, err := parseDeadline(req.Args["until"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if clock.Until(until) > maxPause {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("a pause may last at most %s", maxPause)}
+	}
+	untilStr := until.UTC().Format(time.RFC3339)
+
+	if s.Pause != nil {
+		vexlog.LogEvent("SYSTEM", "PAUSE_CHANGED", fmt.Sprintf("until=%s previous=%s", untilStr, s.Pause.Until))
+		s.Pause.Until = untilStr
+		armPause(s)
+		return &ipc.Response{OK: true, Message: "Pause now ends " + until.Local().Format("Mon 2006-01-02 15:04"), State: s}
+	}
+
+	// Wind down the scheduled policies first so the snapshot holds the
+	// standing configuration, not tonight's curfew or an open window.
+	setCurfew(s, false)
+	for _, a := range s.Allowances {
+		setAllowance(s, a.Name, false)
+	}
+	s.Calendar.Presets = nil
+	s.Compliance.Tier = ""
+	applyCalendar(s)
+	endFreeze(s, "paused") // and not taken up again on resume
+	releaseUSB()
+
+	s.Pause = &state.PauseState{
+		Since: clock.Now().UTC().Format(time.RFC3339),
+		Until: untilStr,
+		Saved: state.Snapshot{
+			Network:       s.Network,
+			Compute:       s.Compute,
+			Media:         s.Media,
+			Guardian:      s.Guardian,
+			ForbiddenApps: guardian.GetForbiddenApps(),
+			Expiries:      s.Expiries,
+		},
+	}
+	s.Pause.Saved.Guardian.BlockedDomains = append([]string{}, s.Guardian.BlockedDomains...)
+	s.Expiries = nil
+	armExpiries(s)
+
+	if !dryRun {
+		liftEnforcement(s)
+		for _, app := range s.Pause.Saved.ForbiddenApps {
+			if _, err := guardian.RemoveForbiddenApp(app); err != nil {
+				log.Printf("Pause: failed to un-forbid %s: %v", app, err)
+			}
+		}
+	} else {
+		log.Println("[DRY-RUN] Would lift all enforcement for the pause")
+		s.Network.Profile = string(throttler.ProfileStandard)
+		s.Network.PacketLossPct = 0
+		s.Compute = state.ComputeState{CPULimitPct: 100}
+		s.Media = state.MediaState{}
+		s.Guardian.FirewallEnabled = false
+		s.Guardian.BlockedDomains = []string{}
+	}
+	s.ChangedBy = "pause"
+	armPause(s)
+
+	vexlog.LogEvent("SYSTEM", "PAUSED", fmt.Sprintf("until=%s locked=%v profile=%s blocked=%d apps=%d",
+		untilStr, s.Compliance.Locked, s.Pause.Saved.Network.Profile,
+		len(s.Pause.Saved.Guardian.BlockedDomains), len(s.Pause.Saved.ForbiddenApps)))
+
+	return &ipc.Response{
+		OK: true,
+		Message: fmt.Sprintf("Enforcement paused until %s (%s). Everything is restored automatically.",
+			until.Local().Format("Mon 2006-01-02 15:04"), clock.Until(until).Round(time.Minute)),
+		State: s,
+	}
+}
+
+// liftEnforcement returns the network, compute and firewall to defaults
+// for a pause.  Unlike unlock it clears the blocklist itself, so the DNS
+// refresh cannot bring the rules back, and leaves compliance alone.
+func liftEnforcement(s *state.SystemState) {
+	netErr := throttler.ApplyNetworkProfile(throttler.ProfileStandard)
+	if netErr != nil {
+		log.Printf("Pause: failed to restore network: %v", netErr)
+	}
+	s.Network.RecordApply(netErr)
+
+	var computeErrs []error
+	if err := throttler.SetCPULimit(100); err != nil {
+		computeErrs = append(computeErrs, err)
+	}
+	if err := throttler.SetPriority(throttler.PriorityNormal); err != nil {
+		computeErrs = append(computeErrs, err)
+	}
+	if err := guardian.SetOOMScore(0); err != nil {
+		computeErrs = append(computeErrs, err)
+	}
+	surveillance.EndInputBlackout(false)
+	if err := surveillance.SetKeySuppression(false); err != nil {
+		computeErrs = append(computeErrs, err)
+	}
+	if err := surveillance.InjectLatency(0); err != nil {
+		computeErrs = append(computeErrs, err)
+	}
+	if err := errors.Join(computeErrs...); err != nil {
+		log.Printf("Pause: failed to restore compute: %v", err)
+	}
+	s.Compute.RecordApply(errors.Join(computeErrs...))
+
+	fwErr := guardian.SetBlockedDomains(nil)
+	if fwErr != nil {
+		log.Printf("Pause: failed to clear firewall: %v", fwErr)
+	}
+	s.Guardian.RecordApply(fwErr)
+
+	s.Network.Profile = string(throttler.ProfileStandard)
+	s.Network.PacketLossPct = 0
+	s.Compute.CPULimitPct = 100
+	s.Compute.Priority = ""
+	s.Compute.GPULimitPct = 0
+	s.Compute.OOMScoreAdj = 0
+	s.Compute.InputLatencyMs = 0
+	s.Compute.InputLatencyMaxMs = 0
+	s.Compute.InputLockUntil = ""
+	s.Media = state.MediaState{} // the media module lifts the caps
+	s.Guardian.FirewallEnabled = false
+	s.Guardian.BlockedDomains = []string{}
+}
+
+// handleResume ends a pause early.  Resuming only re-imposes what was
+// in force, so it needs no signature.
+func handleResume(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if s.Pause == nil {
+		return &ipc.Response{OK: false, Error: "enforcement is not paused"}
+	}
+	endPause(s, "resume")
+	return &ipc.Response{OK: true, Message: "Pause ended; enforcement restored.", State: s}
+}
+
+// resumeIfDue ends the pause once its deadline has passed.  Runs under
+// the server lock, at startup and from the pause timer.
+func resumeIfDue(s *state.SystemState) {
+	if s.Pause == nil {
+		return
+	}
+	until, err := time.Parse(time.RFC3339, s.Pause.Until)
+	if err == nil && clock.Now().Before(until) {
+		armPause(s)
+		return
+	}
+	endPause(s, "deadline")
+}
+
+func armPause(s *state.SystemState) {
+	if pauseTimer != nil {
+		pauseTimer.Stop()
+		pauseTimer = nil
+	}
+	if liveSrv == nil || s.Pause == nil {
+		return
+	}
+	until, _ := time.Parse(time.RFC3339, s.Pause.Until)
+	pauseTimer = clock.AfterFunc(clock.Until(until), func() { liveSrv.Update(resumeIfDue) })
+}
+
+// endPause re-applies the snapshot taken when the pause began.  Pending
+// --for reverts come back too, and any that fell due meanwhile run now.
+func endPause(s *state.SystemState, reason string) {
+	p := s.Pause
+	s.Pause = nil
+	armPause(s)
+
+	saved := p.Saved
+	restoreSnapshot(s, saved, "Resume")
+	s.ChangedBy = "pause"
+	vexlog.LogEvent("SYSTEM", "RESUMED", fmt.Sprintf("reason=%s paused_since=%s profile=%s blocked=%d apps=%d",
+		reason, p.Since, s.Network.Profile, len(s.Guardian.BlockedDomains), len(saved.ForbiddenApps)))
+	revertExpired(s)
+	approveDueExceptions(s)
+
+	// Let the curfew and allowance windows catch up now rather than on
+	// the next poll.  Tick reads state, so it must run after this update.
+	go scheduler.Tick(clock.Now())
+}
+
+// restoreSnapshot re-applies saved network, compute and firewall
+// settings, re-forbids its apps and brings back its pending expiries.
+// While the curfew runs the network stays black-holed and the saved
+// profile becomes what wake time restores.  The caller runs
+// revertExpired afterwards.
+func restoreSnapshot(s *state.SystemState, saved state.Snapshot, module string) {
+	if s.Curfew.Active {
+		s.Curfew.SavedProfile = saved.Network.Profile
+		s.Curfew.SavedLossPct = saved.Network.PacketLossPct
+	} else {
+		s.Network.Profile = saved.Network.Profile
+		s.Network.PacketLossPct = saved.Network.PacketLossPct
+	}
+	s.Compute.CPULimitPct = saved.Compute.CPULimitPct
+	s.Compute.Priority = saved.Compute.Priority
+	s.Compute.GPULimitPct = saved.Compute.GPULimitPct
+	s.Compute.OOMScoreAdj = saved.Compute.OOMScoreAdj
+	s.Compute.InputLatencyMs = saved.Compute.InputLatencyMs
+	s.Compute.InputLatencyMaxMs = saved.Compute.InputLatencyMaxMs
+	s.Compute.InputLockUntil = ""
+	s.Media = saved.Media
+	s.Guardian.FirewallEnabled = saved.Guardian.FirewallEnabled
+	s.Guardian.BlockedDomains = append([]string{}, saved.Guardian.BlockedDomains...)
+
+	if !dryRun {
+		applyNetworkState(s)
+		applyComputeState(s)
+		var err error
+		if c := s.Compute; c.InputLatencyMaxMs > c.InputLatencyMs {
+			err = surveillance.InjectJitter(c.InputLatencyMs, c.InputLatencyMaxMs)
+		} else {
+			err = surveillance.InjectLatency(c.InputLatencyMs)
+		}
+		if err != nil {
+			log.Printf("%s: failed to restore input latency: %v", module, err)
+			s.Compute.RecordApply(err)
+		}
+		s.Guardian.RecordApply(guardian.SetBlockedDomains(s.Guardian.BlockedDomains))
+		for _, app := range saved.ForbiddenApps {
+			if _, err := guardian.AddForbiddenApp(app); err != nil {
+				log.Printf("%s: failed to re-forbid %s: %v", module, app, err)
+			}
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would re-apply the saved enforcement (%s)", strings.ToLower(module))
+	}
+
+	s.Expiries = append(s.Expiries, saved.Expiries...)
+}
+
+// unlockPaused handles an unlock while paused: nothing is enforced, so
+// only the snapshot the resume will re-apply is normalised.
+func unlockPaused(s *state.SystemState) *ipc.Response {
+	saved := &s.Pause.Saved
+	saved.Network.Profile = string(throttler.ProfileStandard)
+	saved.Network.PacketLossPct = 0
+	saved.Compute.CPULimitPct = 100
+	saved.Compute.Priority = ""
+	saved.Compute.GPULimitPct = 0
+	saved.Compute.OOMScoreAdj = 0
+	saved.Compute.InputLatencyMs = 0
+	saved.Compute.InputLatencyMaxMs = 0
+	saved.Media = state.MediaState{}
+	saved.Guardian.FirewallEnabled = false
+	saved.Guardian.BlockedDomains = []string{}
+	saved.Expiries = nil
+	s.Exceptions = nil
+
+	if err := penance.RecordCompletion(); err != nil {
+		log.Printf("Unlock: failed to persist completion: %v", err)
+	}
+	s.Compliance.Locked = false
+	s.ChangedBy = "unlock"
+	vexlog.LogEvent("SYSTEM", "RESTRICTIONS_LIFTED", "Unlocked during pause; the penalty is not re-applied at resume")
+
+	return &ipc.Response{
+		OK:      true,
+		Message: "System unlocked. Restrictions stay lifted when the pause ends.",
+		State:   s,
+	}
+}
+
+// ── Temporary unlock ────────────────────────────────────────────────
+
+// maxRelock bounds a temporary unlock.
+const maxRelock = 7 * 24 * time.Hour
+
+var relockTimer *clock.Timer
+
+// withRelock adds an optional "until" argument to unlock.  With it the
+// restrictions in force are saved first and re-applied at the deadline,
+// so "unlock for the evening" needs no reconstruction afterwards.
+// Unlocking again meanwhile only moves the deadline.
+func withRelock(h ipc.Handler) ipc.Handler {
+	return func(s *state.SystemState, req *ipc.Request) *ipc.Response {
+		arg := req.Args["until"]
+		if arg == "" {
+			return h(s, req)
+		}
+		if s.Pause != nil {
+			return &ipc.Response{OK: false, Error: "enforcement is paused; a temporary unlock has nothing to lift"}
+		}
+		until, err := parseDeadline(arg)
+		if err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if clock.Until(until) > maxRelock {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("a temporary unlock may last at most %s", maxRelock)}
+		}
+		untilStr := until.UTC().Format(time.RFC3339)
+
+		if s.Relock != nil {
+			vexlog.LogEvent("SYSTEM", "RELOCK_CHANGED", fmt.Sprintf("until=%s previous=%s", untilStr, s.Relock.Until))
+			s.Relock.Until = untilStr
+			armRelock(s)
+			return &ipc.Response{OK: true, Message: "Re-lock now at " + until.Local().Format("Mon 2006-01-02 15:04"), State: s}
+		}
+
+		r := &state.RelockState{
+			Since:      clock.Now().UTC().Format(time.RFC3339),
+			Until:      untilStr,
+			Locked:     s.Compliance.Locked,
+			TaskStatus: s.Compliance.TaskStatus,
+			Saved:      relockSnapshot(s),
+		}
+		resp := h(s, req)
+		if !resp.OK {
+			return resp
+		}
+		s.Relock = r
+		armRelock(s)
+		vexlog.LogEvent("SYSTEM", "TEMPORARY_UNLOCK", fmt.Sprintf("until=%s locked=%v profile=%s blocked=%d",
+			untilStr, r.Locked, r.Saved.Network.Profile, len(r.Saved.Guardian.BlockedDomains)))
+
+		resp.Message = fmt.Sprintf("System unlocked until %s (%s). The current restrictions are restored automatically.",
+			until.Local().Format("Mon 15:04"), clock.Until(until).Round(time.Minute))
+		return resp
+	}
+}
+
+// relockSnapshot copies the standing restrictions.  What the curfew and
+// calendar presets layer on top is left out; they re-impose it
+// themselves if they are still in force at the re-lock.
+func relockSnapshot(s *state.SystemState) state.Snapshot {
+	c := &s.Calendar
+	saved := state.Snapshot{
+		Network:  s.Network,
+		Compute:  s.Compute,
+		Media:    s.Media,
+		Guardian: s.Guardian,
+		Expiries: slices.Clone(s.Expiries),
+	}
+	saved.Guardian.BlockedDomains = []string{}
+	for _, d := range s.Guardian.BlockedDomains {
+		if !containsFold(c.AddedDomains, d) {
+			saved.Guardian.BlockedDomains = append(saved.Guardian.BlockedDomains, d)
+		}
+	}
+	if s.Curfew.Active {
+		saved.Network.Profile = s.Curfew.SavedProfile
+		saved.Network.PacketLossPct = s.Curfew.SavedLossPct
+	} else if c.AppliedProfile != "" && s.Network.Profile == c.AppliedProfile {
+		saved.Network.Profile = c.SavedProfile
+	}
+	if c.AppliedCPU != 0 && s.Compute.CPULimitPct == c.AppliedCPU {
+		saved.Compute.CPULimitPct = c.SavedCPU
+	}
+	return saved
+}
+
+// relockIfDue re-locks once the temporary unlock has ended.  Runs under
+// the server lock, at startup and from the relock timer.
+func relockIfDue(s *state.SystemState) {
+	if s.Relock == nil {
+		return
+	}
+	until, err := time.Parse(time.RFC3339, s.Relock.Until)
+	if err == nil && clock.Now().Before(until) {
+		armRelock(s)
+		return
+	}
+	relock(s)
+}
+
+func armRelock(s *state.SystemState) {
+	if relockTimer != nil {
+		relockTimer.Stop()
+		relockTimer = nil
+	}
+	if liveSrv == nil || s.Relock == nil {
+		return
+	}
+	until, _ := time.Parse(time.RFC3339, s.Relock.Until)
+	relockTimer = clock.AfterFunc(clock.Until(until), func() { liveSrv.Update(relockIfDue) })
+}
+
+// relock re-applies the snapshot taken by a temporary unlock.  During a
+// pause it replaces what the resume will re-apply instead.
+func relock(s *state.SystemState) {
+	r := s.Relock
+	s.Relock = nil
+	armRelock(s)
+
+	if s.Pause != nil {
+		saved := &s.Pause.Saved
+		saved.Network, saved.Compute, saved.Guardian = r.Saved.Network, r.Saved.Compute, r.Saved.Guardian
+		saved.Expiries = append(saved.Expiries, r.Saved.Expiries...)
+	} else {
+		restoreSnapshot(s, r.Saved, "Relock")
+	}
+
+	if r.Locked {
+		if cs, err := penance.LoadComplianceStatus(); err != nil {
+			log.Printf("Relock: failed to load compliance: %v", err)
+		} else {
+			cs.Locked = true
+			cs.TaskStatus = r.TaskStatus
+			if err := penance.SaveComplianceStatus(cs); err != nil {
+				log.Printf("Relock: failed to save compliance: %v", err)
+			}
+		}
+		s.Compliance.Locked = true
+		s.Compliance.TaskStatus = r.TaskStatus
+	}
+	s.ChangedBy = "relock"
+	vexlog.LogEvent("SYSTEM", "RELOCKED", fmt.Sprintf("unlocked_since=%s locked=%v profile=%s blocked=%d",
+		r.Since, r.Locked, r.Saved.Network.Profile, len(r.Saved.Guardian.BlockedDomains)))
+
+	if s.Pause == nil {
+		revertExpired(s)
+		applyCalendar(s)
+	}
+}
+
+// ── Calendar presets ────────────────────────────────────────────────
+
+// calendarJobPrefix namespaces calendar preset jobs in the scheduler.
+const calendarJobPrefix = "calendar:"
+
+// calendarCfg is the loaded calendar configuration, nil if none.
+var calendarCfg *calendar.Config
+
+// scheduleCalendar registers one scheduler job per preset.  A preset is
+// due while one of its events is in progress and enforcement is not
+// paused.
+func scheduleCalendar(srv *ipc.Server) {
+	for name := range calendarCfg.Presets {
+		scheduler.Set(calendarJobPrefix+name,
+			func(now time.Time) bool {
+				var paused bool
+				srv.View(func(s *state.SystemState) { paused = s.Pause != nil })
+				return !paused && calendar.PresetActive(name, now)
+			},
+			func(active bool) {
+				srv.Update(func(s *state.SystemState) { setCalendarPreset(s, name, active) })
+			})
+	}
+}
+
+// calendarFetched records the outcome of each feed fetch in state.
+func calendarFetched(srv *ipc.Server) func(err error) {
+	return func(err error) {
+		srv.Update(func(s *state.SystemState) {
+			if err != nil {
+				s.Calendar.LastError = err.Error()
+				return
+			}
+			s.Calendar.LastFetch = clock.Now().UTC().Format(time.RFC3339)
+			s.Calendar.LastError = ""
+		})
+	}
+}
+
+// setCalendarPreset starts or ends a preset.
+func setCalendarPreset(s *state.SystemState, name string, active bool) {
+	c := &s.Calendar
+	if slices.Contains(c.Presets, name) == active {
+		return
+	}
+	if active {
+		c.Presets = append(c.Presets, name)
+		sort.Strings(c.Presets)
+		vexlog.LogEvent("CALENDAR", "PRESET_STARTED", "preset="+name)
+	} else {
+		c.Presets = slices.DeleteFunc(c.Presets, func(p string) bool { return p == name })
+		vexlog.LogEvent("CALENDAR", "PRESET_ENDED", "preset="+name)
+	}
+	applyCalendar(s)
+	s.ChangedBy = "calendar"
+}
+
+// presetsInForce returns the restriction sets of the calendar presets in
+// force and of the compliance tier.
+func presetsInForce(s *state.SystemState) []calendar.Preset {
+	var out []calendar.Preset
+	if calendarCfg != nil {
+		for _, name := range s.Calendar.Presets {
+			if p, ok := calendarCfg.Presets[name]; ok {
+				out = append(out, p)
+			}
+		}
+	}
+	if tiersCfg != nil {
+		if t := tiersCfg.Named(s.Compliance.Tier); t != nil {
+			out = append(out, t.Restrictions)
+		}
+	}
+	return out
+}
+
+// applyCalendar brings the calendar layer in line with the presets in
+// force, the compliance tier's among them: the most severe profile, the
+// lowest CPU cap, and the union of their domains and apps.  Only what the
+// layer itself added is removed when a preset ends.  The network is left
+// to the curfew while it runs; wake time calls this again.
+func applyCalendar(s *state.SystemState) {
+	c := &s.Calendar
+	var profile throttler.Profile
+	cpu := 0
+	var domains, apps []string
+	for _, p := range presetsInForce(s) {
+		if rp, err := throttler.ResolveProfile(p.Profile); err == nil && throttler.Severity(rp) > throttler.Severity(profile) {
+			profile = rp
+		}
+		if p.CPULimit > 0 && (cpu == 0 || p.CPULimit < cpu) {
+			cpu = p.CPULimit
+		}
+		domains = append(domains, p.Block...)
+		apps = append(apps, p.Apps...)
+	}
+
+	// 1. Domains
+	var errs []error
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if containsFold(s.Guardian.BlockedDomains, d) || containsFold(c.AddedDomains, d) {
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] Would block domain for calendar preset: %s", d)
+		} else if _, err := guardian.AddDomain(d); err != nil {
+			log.Printf("Calendar: failed to block %s: %v", d, err)
+			errs = append(errs, err)
+			continue
+		}
+		// An open allowance that lifted it re-blocks it at close anyway.
+		if !liftedByAllowance(s, d, false) {
+			c.AddedDomains = append(c.AddedDomains, d)
+		}
+	}
+	var keep []string
+	for _, d := range c.AddedDomains {
+		if containsFold(domains, d) {
+			keep = append(keep, d)
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] Would unblock domain after calendar preset: %s", d)
+		} else if _, err := guardian.RemoveDomain(d); err != nil {
+			log.Printf("Calendar: failed to unblock %s: %v", d, err)
+			errs = append(errs, err)
+		}
+	}
+	c.AddedDomains = keep
+	if !dryRun {
+		s.Guardian.RecordApply(errors.Join(errs...))
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+		s.Guardian.FirewallEnabled = len(s.Guardian.BlockedDomains) > 0
+	}
+
+	// 2. Apps
+	forbidden := guardian.GetForbiddenApps()
+	for _, app := range apps {
+		app = strings.ToLower(strings.TrimSpace(app))
+		if containsFold(forbidden, app) || containsFold(c.AddedApps, app) {
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] Would forbid app for calendar preset: %s", app)
+		} else if _, err := guardian.AddForbiddenApp(app); err != nil {
+			log.Printf("Calendar: failed to forbid %s: %v", app, err)
+			continue
+		}
+		if !liftedByAllowance(s, app, true) {
+			c.AddedApps = append(c.AddedApps, app)
+		}
+	}
+	keep = nil
+	for _, app := range c.AddedApps {
+		switch {
+		case containsFold(apps, app):
+			keep = append(keep, app)
+		case s.Curfew.Active && containsFold(s.Curfew.Apps, app):
+			// Still wanted tonight; the curfew removes it at wake.
+			s.Curfew.AddedApps = append(s.Curfew.AddedApps, app)
+		case dryRun:
+			log.Printf("[DRY-RUN] Would un-forbid app after calendar preset: %s", app)
+		default:
+			if _, err := guardian.RemoveForbiddenApp(app); err != nil {
+				log.Printf("Calendar: failed to un-forbid %s: %v", app, err)
+			}
+		}
+	}
+	c.AddedApps = keep
+
+	if s.Curfew.Active {
+		return
+	}
+
+	// 3. Network profile: raise only, restore only if untouched.
+	current := throttler.Profile(s.Network.Profile)
+	switch {
+	case profile != "":
+		if c.AppliedProfile == "" {
+			c.SavedProfile = s.Network.Profile
+		}
+		target := profile
+		if saved := throttler.Profile(c.SavedProfile); throttler.Severity(saved) > throttler.Severity(target) {
+			target = saved
+		}
+		if target != current && (throttler.Severity(target) > throttler.Severity(current) || s.Network.Profile == c.AppliedProfile) {
+			setCalendarProfile(s, target)
+		}
+		c.AppliedProfile = s.Network.Profile
+	case c.AppliedProfile != "":
+		if s.Network.Profile == c.AppliedProfile && c.SavedProfile != "" {
+			setCalendarProfile(s, throttler.Profile(c.SavedProfile))
+		}
+		c.SavedProfile, c.AppliedProfile = "", ""
+	}
+
+	// 4. CPU cap: lower only, restore only if untouched.
+	switch {
+	case cpu > 0:
+		if c.AppliedCPU == 0 {
+			c.SavedCPU = s.Compute.CPULimitPct
+		}
+		target := cpu
+		if c.SavedCPU > 0 && c.SavedCPU < target {
+			target = c.SavedCPU
+		}
+		if target != s.Compute.CPULimitPct && (target < s.Compute.CPULimitPct || s.Compute.CPULimitPct == c.AppliedCPU) {
+			setCalendarCPU(s, target)
+		}
+		c.AppliedCPU = s.Compute.CPULimitPct
+	case c.AppliedCPU != 0:
+		if s.Compute.CPULimitPct == c.AppliedCPU && c.SavedCPU > 0 {
+			setCalendarCPU(s, c.SavedCPU)
+		}
+		c.SavedCPU, c.AppliedCPU = 0, 0
+	}
+}
+
+// ── Compliance tiers ────────────────────────────────────────────────
+
+// tierJobPrefix namespaces compliance tier jobs in the scheduler.
+const tierJobPrefix = "tier:"
+
+// tiersCfg is the loaded tiers configuration, nil if none.
+var tiersCfg *tiers.Config
+
+// scheduleTiers registers one scheduler job per tier, due while the
+// failure score falls in it and enforcement is not paused.  Every change
+// brings the state to the tier the score is in now, so the order in which
+// one tier's job ends and the next one's starts does not matter.
+func scheduleTiers(srv *ipc.Server) {
+	for _, t := range tiersCfg.Tiers {
+		scheduler.Set(tierJobPrefix+t.Name,
+			func(time.Time) bool {
+				var due string
+				srv.View(func(s *state.SystemState) { due = dueTier(s) })
+				return due == t.Name
+			},
+			func(bool) {
+				srv.Update(func(s *state.SystemState) { setTier(s, dueTier(s)) })
+			})
+	}
+}
+
+// dueTier returns the tier the saved failure score falls in, or "" when
+// it is below every tier or enforcement is paused.
+func dueTier(s *state.SystemState) string {
+	if s.Pause != nil {
+		return ""
+	}
+	cs, err := penance.LoadComplianceStatus()
+	if err != nil {
+		return s.Compliance.Tier
+	}
+	if t := tiersCfg.For(cs.FailureScore); t != nil {
+		return t.Name
+	}
+	return ""
+}
+
+// setTier moves to the named tier ("" for none): the previous tier's
+// restrictions are lifted and the new one's imposed through the calendar
+// layer.
+func setTier(s *state.SystemState, name string) {
+	from := s.Compliance.Tier
+	if name == from {
+		return
+	}
+	s.Compliance.Tier = name
+	vexlog.LogEvent("PENANCE", "TIER_CHANGED", fmt.Sprintf("from=%q to=%q score=%d", from, name, s.Compliance.FailureScore))
+	notify.Keyholder("tier_changed", map[string]string{"from": from, "to": name, "score": strconv.Itoa(s.Compliance.FailureScore)})
+	applyCalendar(s)
+	s.ChangedBy = "tier"
+}
+
+// liftedByAllowance reports whether an open allowance window has lifted
+// the domain (or app).
+func liftedByAllowance(s *state.SystemState, v string, app bool) bool {
+	for _, a := range s.Allowances {
+		lifted := a.LiftedDomains
+		if app {
+			lifted = a.LiftedApps
+		}
+		if a.Active && containsFold(lifted, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setCalendarProfile(s *state.SystemState, p throttler.Profile) {
+	if !dryRun {
+		err := throttler.ApplyNetworkProfile(p)
+		s.Network.RecordApply(err)
+		if err != nil {
+			log.Printf("Calendar: failed to apply profile %s: %v", p, err)
+			return
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would apply network profile for calendar: %s", p)
+	}
+	vexlog.LogEvent("THROTTLER", "PROFILE_CHANGED", fmt.Sprintf("profile=%s (previous=%s), source=calendar", p, s.Network.Profile))
+	s.Network.Profile = string(p)
+	s.Network.PacketLossPct = 0
+}
+
+func setCalendarCPU(s *state.SystemState, pct int) {
+	if !dryRun {
+		err := throttler.SetCPULimit(pct)
+		s.Compute.RecordApply(err)
+		if err != nil {
+			log.Printf("Calendar: failed to set CPU limit %d%%: %v", pct, err)
+			return
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would set CPU limit for calendar: %d%%", pct)
+	}
+	vexlog.LogEvent("THROTTLER", "CPU_CHANGED", fmt.Sprintf("cpu=%d%% (previous=%d%%), source=calendar", pct, s.Compute.CPULimitPct))
+	s.Compute.CPULimitPct = pct
+}
+
+// handleCalendar reports the presets in force and the next week of
+// mapped events.
+func handleCalendar(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if calendarCfg == nil {
+		return &ipc.Response{OK: false, Error: "no calendar configured (" + calendar.ConfigFile + ")"}
+	}
+	resp := &ipc.Response{OK: true, State: s}
+	for _, o := range calendar.Upcoming(clock.Now(), 7*24*time.Hour) {
+		resp.Events = append(resp.Events, ipc.CalendarEvent{
+			Summary: o.Summary,
+			Preset:  o.Preset,
+			Start:   o.Start.UTC().Format(time.RFC3339),
+			End:     o.End.UTC().Format(time.RFC3339),
+		})
+	}
+	return resp
+}
+
+// ── Curfew ──────────────────────────────────────────────────────────
+
+// curfewJob is the scheduler job that enforces the nightly curfew.
+const curfewJob = "curfew"
+
+func curfewWindow(c state.CurfewState) scheduler.Window {
+	return scheduler.Window{Start: c.Start, End: c.End, Days: c.Days}
+}
+
+// curfewDue reports whether the configured curfew should be in force,
+// reading the live state so configuration changes need no re-registration.
+func curfewDue(srv *ipc.Server) func(time.Time) bool {
+	return func(now time.Time) bool {
+		var due bool
+		srv.View(func(s *state.SystemState) {
+			c := s.Curfew
+			if !c.Enabled || s.Pause != nil || !curfewWindow(c).Contains(now) {
+				return
+			}
+			skip, err := time.Parse(time.RFC3339, c.SkipUntil)
+			due = err != nil || !now.Before(skip)
+		})
+		return due
+	}
+}
+
+func curfewChanged(srv *ipc.Server) func(active bool) {
+	return func(active bool) {
+		srv.Update(func(s *state.SystemState) { setCurfew(s, active) })
+		if active && forcedBreakCfg != nil && forcedBreakCfg.Curfew {
+			forcedBreak(srv, breakCurfew)
+		}
+	}
+}
+
+// setCurfew starts or ends the curfew.  Starting is idempotent and also
+// re-imposes the black-hole if something (e.g. penance enforcement at
+// boot) replaced it; ending restores the profile and removes only the
+// apps the curfew itself forbade.
+func setCurfew(s *state.SystemState, active bool) {
+	c := &s.Curfew
+	if !active {
+		if !c.Active {
+			return
+		}
+		endCurfew(s)
+		applyCalendar(s) // wake may have dropped a preset's profile or apps
+		vexlog.LogEvent("CURFEW", "ENDED", fmt.Sprintf("restored profile=%s", s.Network.Profile))
+		return
+	}
+
+	starting := !c.Active
+	if starting {
+		c.SavedProfile = s.Network.Profile
+		c.SavedLossPct = s.Network.PacketLossPct
+		c.AddedApps = nil
+		if !dryRun {
+			for _, app := range c.Apps {
+				added, err := guardian.AddForbiddenApp(app)
+				if err != nil {
+					log.Printf("Curfew: failed to forbid %s: %v", app, err)
+					continue
+				}
+				if added {
+					c.AddedApps = append(c.AddedApps, app)
+				}
+			}
+		} else if len(c.Apps) > 0 {
+			log.Printf("[DRY-RUN] Would forbid apps for curfew: %s", strings.Join(c.Apps, ", "))
+		}
+		c.Active = true
+	} else if s.Network.Profile == string(throttler.ProfileBlackHole) {
+		return
+	}
+
+	if !dryRun {
+		err := throttler.ApplyNetworkProfile(throttler.ProfileBlackHole)
+		s.Network.RecordApply(err)
+		if err != nil {
+			log.Printf("Curfew: failed to black-hole network: %v", err)
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would apply network profile for curfew: %s", throttler.ProfileBlackHole)
+	}
+	s.Network.Profile = string(throttler.ProfileBlackHole)
+	s.Network.PacketLossPct = 0
+	s.ChangedBy = "curfew"
+
+	if starting {
+		vexlog.LogEvent("CURFEW", "STARTED",
+			fmt.Sprintf("window=%s saved_profile=%s apps=%s",
+				curfewWindow(*c), c.SavedProfile, strings.Join(c.AddedApps, ",")))
+	}
+}
+
+// endCurfew restores the pre-curfew network profile and forbidden apps.
+func endCurfew(s *state.SystemState) {
+	c := &s.Curfew
+	s.Network.Profile = c.SavedProfile
+	if s.Network.Profile == "" {
+		s.Network.Profile = string(throttler.ProfileStandard)
+	}
+	s.Network.PacketLossPct = c.SavedLossPct
+
+	if !dryRun {
+		applyNetworkState(s)
+		for _, app := range c.AddedApps {
+			if _, err := guardian.RemoveForbiddenApp(app); err != nil {
+				log.Printf("Curfew: failed to un-forbid %s: %v", app, err)
+			}
+		}
+	} else {
+		log.Printf("[DRY-RUN] Would restore network profile after curfew: %s", s.Network.Profile)
+	}
+
+	c.Active = false
+	c.SavedProfile = ""
+	c.SavedLossPct = 0
+	c.AddedApps = nil
+	s.ChangedBy = "curfew"
+}
+
+// curfewRefusal explains why a command that would loosen the curfew was
+// rejected.
+func curfewRefusal(s *state.SystemState) string {
+	end := curfewWindow(s.Curfew).EndAfter(clock.Now())
+	return fmt.Sprintf("curfew in effect until %s; breaking it requires a signed curfew-override",
+		end.Format("15:04"))
+}
+
+func containsFold(list []string, v string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFold(list []string, v string) []string {
+	var out []string
+	for _, x := range list {
+		if !strings.EqualFold(x, v) {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+func handleCurfew(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	return &ipc.Response{OK: true, State: s}
+}
+
+// handleCurfewSet configures a curfew.  Replacing an existing one could be
+// used to shorten it, so that requires a signed "curfew-override off"
+// first.
+func handleCurfewSet(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if s.Curfew.Enabled {
+		return &ipc.Response{OK: false, Error: fmt.Sprintf(
+			"a curfew is already set (%s); changing it requires a signed curfew-override off first",
+			curfewWindow(s.Curfew))}
+	}
+
+	days, err := scheduler.ParseDays(req.Args["days"])
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	w := scheduler.Window{Start: req.Args["start"], End: req.Args["end"], Days: days}
+	if err := w.Validate(); err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	var apps []string
+	for _, app := range strings.Split(req.Args["apps"], ",") {
+		if app = strings.TrimSpace(app); app != "" {
+			apps = append(apps, app)
+		}
+	}
+
+	s.Curfew = state.CurfewState{
+		Enabled: true,
+		Start:   w.Start,
+		End:     w.End,
+		Days:    w.Days,
+		Apps:    apps,
+	}
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("CURFEW", "SET", fmt.Sprintf("window=%s apps=%s source=cli", w, strings.Join(apps, ",")))
+
+	return &ipc.Response{
+		OK:      true,
+		Message: fmt.Sprintf("Curfew set: %s (network black-hole until wake)", w),
+		State:   s,
+	}
+}
+
+// handleCurfewOverride breaks the curfew.  mode "tonight" (the default)
+// ends the current curfew, or skips the next one if none is running;
+// "off" disables the curfew entirely.  The CLI has already verified the
+// signed payload.
+func handleCurfewOverride(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	c := &s.Curfew
+	if !c.Enabled {
+		return &ipc.Response{OK: false, Error: "no curfew is set"}
+	}
+
+	switch mode := req.Args["mode"]; mode {
+	case "", "tonight":
+		now := clock.Now()
+		w := curfewWindow(*c)
+		end := w.EndAfter(now)
+		if !w.Contains(now) {
+			end = w.EndAfter(w.StartAfter(now))
+		}
+		c.SkipUntil = end.UTC().Format(time.RFC3339)
+		wasActive := c.Active
+		setCurfew(s, false)
+		s.ChangedBy = "override"
+		vexlog.LogEvent("CURFEW", "OVERRIDDEN", fmt.Sprintf("skip_until=%s was_active=%v", c.SkipUntil, wasActive))
+		return &ipc.Response{
+			OK:      true,
+			Message: fmt.Sprintf("Curfew lifted until %s", end.Format("Mon 15:04")),
+			State:   s,
+		}
+	case "off":
+		setCurfew(s, false)
+		s.Curfew = state.CurfewState{}
+		s.ChangedBy = "override"
+		vexlog.LogEvent("CURFEW", "DISABLED", "source=signed override")
+		return &ipc.Response{OK: true, Message: "Curfew disabled", State: s}
+	default:
+		return &ipc.Response{OK: false, Error: fmt.Sprintf("unknown override mode %q (use tonight or off)", mode)}
+	}
+}
+
+// ── Penance input handler ───────────────────────────────────────────
+
+// penanceText is the submission of the running penance session, line by
+// line as the CLI accepted them.  Handlers run under the server lock.
+var penanceText strings.Builder
+
+// handlePenanceInput logs a line of the penance session and answers with
+// where the submission stands, so the subject knows before submitting.
+func handlePenanceInput(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	line := req.Args["line"]
+	num := req.Args["num"]
+
+	// The first line of an interactive penance session starts a typing
+	// sample; it is scored when the session ends in an unlock.
+	if num == "1" {
+		surveillance.BeginTypingSample()
+		penanceText.Reset()
+	}
+	penanceText.WriteString(line + "\n")
+
+	vexlog.LogEvent("PENANCE", "INPUT_RECEIVED",
+		fmt.Sprintf("line_num=%s words=%d content=%q %s", num, len(strings.Fields(line)), line,
+			typingMatchDetails(surveillance.CurrentTypingMatch())))
+
+	resp := &ipc.Response{OK: true, Message: fmt.Sprintf("Line %s logged", num)}
+	if m := penance.CurrentManifest; m != nil {
+		p := penance.CheckProgress(penanceText.String(), m)
+		resp.Penance = &ipc.PenanceProgress{Words: p.Words, MinWords: p.MinWords, Missing: p.Missing, Warnings: p.Warnings}
+	}
+	return resp
+}
+
+// handlePenanceFailed hears about a failure the CLI recorded itself
+// (a rejected line or submission), so that it reaches the keyholder like
+// the daemon's own.
+func handlePenanceFailed(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	reason := req.Args["reason"]
+	if reason == "" {
+		return &ipc.Response{OK: false, Error: "missing 'reason' argument"}
+	}
+	cs, err := penance.LoadComplianceStatus()
+	if err != nil {
+		return &ipc.Response{OK: false, Code: ipc.CodeFailed, Error: fmt.Sprintf("failed to load compliance: %v", err)}
+	}
+	s.Compliance.Locked = cs.Locked
+	s.Compliance.FailureScore = cs.FailureScore
+	s.Compliance.TaskStatus = cs.TaskStatus
+
+	events.Publish(events.Failure{Reason: reason, Category: penance.Categorize(reason), Score: cs.FailureScore, TotalFailures: cs.TotalFailures, Reported: true})
+	if line, err := strconv.Atoi(req.Args["line"]); err == nil {
+		events.Publish(events.LineRejected{Task: "penance", Line: line, Reason: reason})
+	}
+	return &ipc.Response{OK: true, Message: "Failure reported"}
+}
+
+// typingMatchDetails formats a keystroke-dynamics match for the audit log.
+func typingMatchDetails(m surveillance.TypingMatch) string {
+	if !m.Sufficient {
+		return fmt.Sprintf("typing_confidence=n/a typing_samples=%d", m.Samples)
+	}
+	return fmt.Sprintf("typing_confidence=%.2f typing_samples=%d", m.Confidence, m.Samples)
+}
+
+// reportTypingAnomaly flags a submission whose rhythm does not match the
+// subject's baseline profile.
+func reportTypingAnomaly(module string, m surveillance.TypingMatch) {
+	if m.Anomalous() {
+		log.Printf("Surveillance: Typing rhythm does not match profile (confidence %.2f)", m.Confidence)
+		vexlog.LogEvent(module, "TYPING_ANOMALY",
+			fmt.Sprintf("%s threshold=%.2f", typingMatchDetails(m), surveillance.AnomalyThreshold))
+	}
+}
+
+// ── Typing-test handlers ────────────────────────────────────────────
+
+// typingTaskType is the manifest task type satisfied by a typing test.
+const typingTaskType = "typing_test"
+
+// typingTarget is the passage of the running typing test ("" = none).
+// Handlers run under the server lock, so no extra locking is needed.
+var typingTarget string
+
+func handleTypingStart(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if len(surveillance.GetMonitoredDevices()) == 0 {
+		return &ipc.Response{OK: false, Error: "no keyboards are monitored; a typing test needs live keyboard input"}
+	}
+
+	target := strings.TrimSpace(req.Args["text"])
+	if target == "" {
+		target = penance.PickTypingPassage()
+	}
+	typingTarget = target
+	surveillance.BeginTypingCapture()
+	surveillance.BeginTypingSample()
+	vexlog.LogEvent("PENANCE", "TYPING_TEST_STARTED", fmt.Sprintf("chars=%d", len([]rune(target))))
+
+	return &ipc.Response{OK: true, Typing: &ipc.TypingTest{Target: target}}
+}
+
+func handleTypingStatus(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	c, ok := surveillance.TypingCaptureSnapshot()
+	if typingTarget == "" || !ok {
+		return &ipc.Response{OK: false, Error: "no typing test in progress"}
+	}
+	return &ipc.Response{OK: true, Typing: typingReport(typingTarget, c)}
+}
+
+func handleTypingFinish(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	target := typingTarget
+	c, ok := surveillance.EndTypingCapture()
+	typingTarget = ""
+	if s.Writing.Live {
+		// The test took over the live task's capture; its line starts over.
+		startLine(&s.Writing, clock.Now())
+	}
+	if target == "" || !ok {
+		return &ipc.Response{OK: false, Error: "no typing test in progress"}
+	}
+	match := surveillance.EndTypingSample()
+
+	if req.Args["abort"] == "true" {
+		vexlog.LogEvent("PENANCE", "TYPING_TEST_ABORTED", fmt.Sprintf("typed=%d/%d", len([]rune(c.Text)), len([]rune(target))))
+		return &ipc.Response{OK: true, Message: "Typing test aborted.", Typing: typingReport(target, c)}
+	}
+
+	constraints := penance.TaskConstraints{AllowBackspace: true}
+	isPenance := false
+	if m := penance.CurrentManifest; m != nil {
+		constraints = m.Active.Constraints
+		isPenance = s.Compliance.Locked && m.Active.Type == typingTaskType
+	}
+
+	result := penance.ValidateTypingTest(target, c, constraints)
+	report := typingReport(target, c)
+	report.Passed = result.Valid
+	report.Errors = result.Errors
+
+	details := fmt.Sprintf("kpm=%.1f accuracy=%.3f backspaces=%d penance=%v %s",
+		report.KPM, report.Accuracy, c.Backspaces, isPenance, typingMatchDetails(match))
+	reportTypingAnomaly("PENANCE", match)
+
+	if !result.Valid {
+		vexlog.LogEvent("PENANCE", "TYPING_TEST_FAILED", details)
+		if isPenance {
+			if err := penance.RecordFailure("typing_test_failed"); err != nil {
+				log.Printf("TypingTest: failed to record failure: %v", err)
+			}
+			if cs, err := penance.LoadComplianceStatus(); err == nil {
+				s.Compliance.FailureScore = cs.FailureScore
+			}
+		}
+		return &ipc.Response{OK: true, Message: "Typing test FAILED.", Typing: report, State: s}
+	}
+
+	vexlog.LogEvent("PENANCE", "TYPING_TEST_PASSED", details)
+	if isPenance {
+		// The daemon measured the test itself, so it can lift the penance
+		// without trusting the CLI to send the unlock.
+		resp := handleUnlock(s, req)
+		resp.Typing = report
+		resp.Message = "Typing test PASSED. " + resp.Message
+		return resp
+	}
+	return &ipc.Response{OK: true, Message: "Typing test PASSED.", Typing: report, State: s}
+}
+
+func typingReport(target string, c surveillance.TypingCapture) *ipc.TypingTest {
+	return &ipc.TypingTest{
+		Target:     target,
+		Typed:      c.Text,
+		Keystrokes: c.Keystrokes,
+		Backspaces: c.Backspaces,
+		KPM:        c.KPM(),
+		Accuracy:   penance.TypingAccuracy(target, c.Text),
+		Done:       len([]rune(c.Text)) >= len([]rune(target)),
+	}
+}
+
+// ── Writing-lines handlers ──────────────────────────────────────────
+
+func handleLinesSet(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	phrase, ok := req.Args["phrase"]
+	if !ok || phrase == "" {
+		return &ipc.Response{OK: false, Error: "missing 'phrase' argument"}
+	}
+	count, err := ipc.ParseIntArg(req.Args, "count")
+	if err != nil {
+		return &ipc.Response{OK: false, Error: err.Error()}
+	}
+	if count < 1 || count > 10000 {
+		return &ipc.Response{OK: false, Error: "count must be between 1 and 10000"}
+	}
+	var pace [2]int
+	for i, key := range []string{"min_seconds", "max_seconds"} {
+		if _, ok := req.Args[key]; !ok {
+			continue
+		}
+		if pace[i], err = ipc.ParseIntArg(req.Args, key); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if pace[i] < 0 {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s must not be negative", key)}
+		}
+	}
+	if pace[1] > 0 && pace[1] < pace[0] {
+		return &ipc.Response{OK: false, Error: "max_seconds must not be below min_seconds"}
+	}
+	priority := 0
+	if _, ok := req.Args["priority"]; ok {
+		if priority, err = ipc.ParseIntArg(req.Args, "priority"); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+	}
+	live := req.Args["live"] == "true"
+	var kpm [2]int
+	for i, key := range []string{"min_kpm", "max_kpm"} {
+		if _, ok := req.Args[key]; !ok {
+			continue
+		}
+		if !live {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s needs a live task", key)}
+		}
+		if kpm[i], err = ipc.ParseIntArg(req.Args, key); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if kpm[i] < 0 {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("%s must not be negative", key)}
+		}
+	}
+	var clauses []string
+	for _, c := range strings.Split(req.Args["clauses"], "\n") {
+		if c = strings.TrimSpace(c); c != "" {
+			clauses = append(clauses, c)
+		}
+	}
+	stepEvery, paceStep := 0, 0
+	if len(clauses) > 0 || req.Args["pace_step"] != "" {
+		stepEvery = defaultStepEvery
+		if _, ok := req.Args["step_every"]; ok {
+			if stepEvery, err = ipc.ParseIntArg(req.Args, "step_every"); err != nil {
+				return &ipc.Response{OK: false, Error: err.Error()}
+			}
+			if stepEvery < 1 {
+				return &ipc.Response{OK: false, Error: "step_every must be at least 1"}
+			}
+		}
+	}
+	if _, ok := req.Args["pace_step"]; ok {
+		if paceStep, err = ipc.ParseIntArg(req.Args, "pace_step"); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		if paceStep < 1 {
+			return &ipc.Response{OK: false, Error: "pace_step must be at least 1"}
+		}
+		if pace[1] == 0 {
+			return &ipc.Response{OK: false, Error: "pace_step needs max_seconds to tighten"}
+		}
+	}
+	abandonMins, bump := 0, 0
+	var onAbandon []string
+	if v := req.Args["abandon_after"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("abandon_after %q is not a duration of a minute or more", v)}
+		}
+		abandonMins = int(d / time.Minute)
+		onAbandon = []string{abandonFail}
+		if v := req.Args["on_abandon"]; v != "" {
+			onAbandon = nil
+			for _, a := range strings.Split(v, ",") {
+				if a = strings.TrimSpace(a); a != abandonFail && a != abandonBump && a != abandonThrottle {
+					return &ipc.Response{OK: false, Error: fmt.Sprintf("on_abandon: %q is not fail, bump or throttle", a)}
+				}
+				onAbandon = append(onAbandon, strings.TrimSpace(a))
+			}
+		}
+		if slices.Contains(onAbandon, abandonBump) {
+			bump = defaultAbandonBump
+			if _, ok := req.Args["abandon_bump"]; ok {
+				if bump, err = ipc.ParseIntArg(req.Args, "abandon_bump"); err != nil {
+					return &ipc.Response{OK: false, Error: err.Error()}
+				}
+				if bump < 1 {
+					return &ipc.Response{OK: false, Error: "abandon_bump must be at least 1"}
+				}
+			}
+		}
+	} else if req.Args["on_abandon"] != "" || req.Args["abandon_bump"] != "" {
+		return &ipc.Response{OK: false, Error: "on_abandon and abandon_bump need abandon_after"}
+	}
+	if live {
+		if len(surveillance.GetMonitoredDevices()) == 0 {
+			return &ipc.Response{OK: false, Error: "no keyboards are monitored; a live task needs live keyboard input"}
+		}
+		if _, ok := req.Args["max_kpm"]; !ok {
+			kpm[1] = liveMaxKPM
+		}
+		if kpm[1] > 0 && kpm[1] < kpm[0] {
+			return &ipc.Response{OK: false, Error: "max_kpm must not be below min_kpm"}
+		}
+	}
+
+	t := state.WritingTask{
+		Active:      true,
+		Phrase:      phrase,
+		Required:    count,
+		Completed:   0,
+		Priority:    priority,
+		MinSeconds:  pace[0],
+		MaxSeconds:  pace[1],
+		Live:        live,
+		MinKPM:      kpm[0],
+		MaxKPM:      kpm[1],
+		StepEvery:   stepEvery,
+		Clauses:     clauses,
+		PaceStep:    paceStep,
+		AbandonMins: abandonMins,
+		OnAbandon:   onAbandon,
+		AbandonBump: bump,
+	}
+	waiting := s.Writing
+	active := queueWriting(s, t)
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("WRITING", "TASK_SET", fmt.Sprintf("phrase=%q count=%d priority=%d min_seconds=%d max_seconds=%d live=%v min_kpm=%d max_kpm=%d step_every=%d clauses=%d pace_step=%d abandon_minutes=%d on_abandon=%s queued=%v",
+		phrase, count, priority, pace[0], pace[1], live, kpm[0], kpm[1], stepEvery, len(clauses), paceStep,
+		abandonMins, strings.Join(onAbandon, ","), !active))
+	notify.Keyholder("task_assigned", map[string]string{"task": "lines", "phrase": phrase, "count": strconv.Itoa(count)})
+
+	msg := fmt.Sprintf("Writing task set: %q x %d", phrase, count)
+	switch {
+	case !active:
+		msg = fmt.Sprintf("Writing task queued: %q x %d, %d in the queue after %q", phrase, count, len(s.LinesQueue), s.Writing.Phrase)
+	case waiting.Active:
+		msg += fmt.Sprintf("; %q waits with %d/%d done", waiting.Phrase, waiting.Completed, waiting.Required)
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+// handleLinesClear cancels the active writing task, and the next queued
+// one takes over, or with {"all": "true"} cancels the queue too.
+func handleLinesClear(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if !s.Writing.Active {
+		return &ipc.Response{OK: true, Message: "No active writing task.", State: s}
+	}
+	s.ChangedBy = "cli"
+	if req.Args["all"] == "true" {
+		n := 1 + len(s.LinesQueue)
+		s.Writing, s.LinesQueue = state.WritingTask{}, nil
+		stopLineCapture()
+		surveillance.EndTypingSample()
+		vexlog.LogEvent("WRITING", "TASK_CLEARED", fmt.Sprintf("all %d tasks cancelled by CLI", n))
+		return &ipc.Response{OK: true, Message: fmt.Sprintf("%d writing tasks cleared.", n), State: s}
+	}
+
+	vexlog.LogEvent("WRITING", "TASK_CLEARED", fmt.Sprintf("task cancelled by CLI: phrase=%q", s.Writing.Phrase))
+	if !nextWriting(s) {
+		surveillance.EndTypingSample()
+		return &ipc.Response{OK: true, Message: "Writing task cleared.", State: s}
+	}
+	return &ipc.Response{OK: true, Message: fmt.Sprintf("Writing task cleared. Next: %q x %d", s.Writing.Phrase, s.Writing.Required), State: s}
+}
+
+// queueWriting adds a writing task.  With none active it becomes the
+// active one.  Otherwise it waits in the queue behind every task of the
+// same or higher priority, unless its priority is higher than the active
+// task's: then it takes over and the active task, its progress kept,
+// goes first in the queue among its priority.  Reports whether t is now
+// the active task.
+func queueWriting(s *state.SystemState, t state.WritingTask) bool {
+	if s.Writing.Active && t.Priority <= s.Writing.Priority {
+		i := slices.IndexFunc(s.LinesQueue, func(q state.WritingTask) bool { return q.Priority < t.Priority })
+		if i < 0 {
+			i = len(s.LinesQueue)
+		}
+		s.LinesQueue = slices.Insert(s.LinesQueue, i, t)
+		return false
+	}
+	if s.Writing.Active {
+		w := s.Writing
+		i := slices.IndexFunc(s.LinesQueue, func(q state.WritingTask) bool { return q.Priority <= w.Priority })
+		if i < 0 {
+			i = len(s.LinesQueue)
+		}
+		s.LinesQueue = slices.Insert(s.LinesQueue, i, w)
+	} else {
+		surveillance.BeginTypingSample()
+	}
+	s.Writing = t
+	s.Writing.Progressed = clock.Now().UTC().Format(time.RFC3339)
+	startLine(&s.Writing, clock.Now())
+	return true
+}
+
+// nextWriting makes the first queued task the active one.  It reports
+// false, leaving no task active, when the queue is empty.
+func nextWriting(s *state.SystemState) bool {
+	if len(s.LinesQueue) == 0 {
+		s.Writing = state.WritingTask{}
+		stopLineCapture()
+		return false
+	}
+	s.Writing = s.LinesQueue[0]
+	s.LinesQueue = slices.Clone(s.LinesQueue[1:])
+	s.Writing.Progressed = clock.Now().UTC().Format(time.RFC3339)
+	startLine(&s.Writing, clock.Now())
+	return true
+}
+
+func handleLinesStatus(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	return &ipc.Response{OK: true, State: s}
+}
+
+func handleLinesSubmit(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if !s.Writing.Active {
+		return &ipc.Response{OK: false, Error: "no active writing task"}
+	}
+
+	line, ok := req.Args["line"]
+	if !ok {
+		return &ipc.Response{OK: false, Error: "missing 'line' argument"}
+	}
+
+	// Normalize and compare (case-sensitive, trimmed)
+	line = strings.TrimSpace(line)
+	expected := strings.TrimSpace(s.Writing.Phrase)
+
+	now := clock.Now()
+	reason, why := linePaceRefusal(&s.Writing, line, now)
+	if reason == "" {
+		reason, why = liveLineRefusal(&s.Writing, line)
+	}
+	startLine(&s.Writing, now)
+	s.ChangedBy = "cli"
+	if reason != "" {
+		vexlog.LogEvent("WRITING", "LINE_REJECTED", fmt.Sprintf("reason=%s line=%d %s", reason, s.Writing.Completed+1, why))
+		events.Publish(events.LineRejected{Task: "lines", Line: s.Writing.Completed + 1, Reason: reason})
+		return &ipc.Response{OK: false, Error: why + "; start the line again"}
+	}
+
+	if line != expected {
+		vexlog.LogEvent("WRITING", "LINE_REJECTED", fmt.Sprintf("got=%q expected=%q", line, expected))
+		events.Publish(events.LineRejected{Task: "lines", Line: s.Writing.Completed + 1, Reason: "mismatch"})
+		return &ipc.Response{
+			OK:    false,
+			Error: fmt.Sprintf("Line does not match. Expected: %q", expected),
+		}
+	}
+
+	s.Writing.Completed++
+	s.Writing.Progressed = now.UTC().Format(time.RFC3339)
+	s.ChangedBy = "cli"
+	remaining := s.Writing.Required - s.Writing.Completed
+
+	// Mark the compliance task as in-progress on first accepted line
+	if err := penance.MarkInProgress(); err != nil {
+		log.Printf("LinesSubmit: failed to mark in-progress: %v", err)
+	}
+
+	if remaining <= 0 {
+		// Task complete!
+		match := surveillance.EndTypingSample()
+		vexlog.LogEvent("WRITING", "TASK_COMPLETED",
+			fmt.Sprintf("phrase=%q required=%d %s", s.Writing.Phrase, s.Writing.Required, typingMatchDetails(match)))
+		reportTypingAnomaly("WRITING", match)
+		if nextWriting(s) {
+			// The compliance task is done once the queue is.
+			surveillance.BeginTypingSample()
+			return &ipc.Response{
+				OK: true,
+				Message: fmt.Sprintf("Writing task COMPLETE. Next: %q x %d (%d more queued)",
+					s.Writing.Phrase, s.Writing.Required, len(s.LinesQueue)),
+				State: s,
+			}
+		}
+
+		// Update compliance status to completed
+		if err := penance.RecordCompletion(); err != nil {
+			log.Printf("LinesSubmit: failed to record completion: %v", err)
+		}
+		s.Compliance.Locked = false
+		s.Compliance.TaskStatus = "completed"
+
+		return &ipc.Response{
+			OK:      true,
+			Message: "Writing task COMPLETE. Well done.",
+			State:   s,
+		}
+	}
+
+	// Sync compliance snapshot so status reflects in-progress
+	if cs, err := penance.LoadComplianceStatus(); err == nil {
+		s.Compliance.TaskStatus = cs.TaskStatus
+	}
+
+	msg := fmt.Sprintf("Line accepted. %d/%d remaining.", remaining, s.Writing.Required)
+	if grew, faster := stepWriting(&s.Writing); grew || faster {
+		vexlog.LogEvent("WRITING", "TASK_HARDER", fmt.Sprintf("line=%d phrase=%q max_seconds=%d",
+			s.Writing.Completed, s.Writing.Phrase, s.Writing.MaxSeconds))
+		if grew {
+			msg += fmt.Sprintf(" The phrase is now %q.", s.Writing.Phrase)
+		}
+		if faster {
+			msg += fmt.Sprintf(" Each line must now take at most %ds.", s.Writing.MaxSeconds)
+		}
+	}
+	return &ipc.Response{OK: true, Message: msg, State: s}
+}
+
+// What an abandoned writing task sets off, as lines set's on_abandon.
+const (
+	abandonFail     = "fail"     // record a penance failure
+	abandonBump     = "bump"     // add AbandonBump lines to the task
+	abandonThrottle = "throttle" // move the network one profile tighter
+)
+
+// defaultAbandonBump is how many lines bump adds unless lines set gives
+// abandon_bump.
+const defaultAbandonBump = 10
+
+// writingAbandonLoop checks once a minute whether the active writing task
+// has gone its abandon_after period without an accepted line, and if so
+// penalises it.
+func writingAbandonLoop(srv *ipc.Server) error {
+	ticker := clock.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var due bool
+		srv.View(func(s *state.SystemState) { due = writingAbandoned(s.Writing, now) })
+		if due {
+			srv.Update(func(s *state.SystemState) {
+				if writingAbandoned(s.Writing, now) {
+					penalizeAbandonedWriting(s, now)
+				}
+			})
+		}
+	}
+	return nil
+}
+
+// writingAbandoned reports whether w has gone its abandon period without
+// an accepted line at now.
+func writingAbandoned(w state.WritingTask, now time.Time) bool {
+	if !w.Active || w.AbandonMins <= 0 {
+		return false
+	}
+	since, err := time.Parse(time.RFC3339, w.Progressed)
+	return err == nil && now.Sub(since) >= time.Duration(w.AbandonMins)*time.Minute
+}
+
+// penalizeAbandonedWriting applies the active task's on_abandon actions
+// and starts its abandon period over, so a task left alone is penalised
+// again each period.  Time spent paused does not count.
+func penalizeAbandonedWriting(s *state.SystemState, now time.Time) {
+	w := &s.Writing
+	idle := time.Duration(w.AbandonMins) * time.Minute
+	w.Progressed = now.UTC().Format(time.RFC3339)
+	if s.Pause != nil {
+		vexlog.LogEvent("WRITING", "TASK_ABANDONED", fmt.Sprintf("phrase=%q idle=%s ignored=paused", w.Phrase, idle))
+		return
+	}
+	for _, a := range w.OnAbandon {
+		switch a {
+		case abandonFail:
+			if err := penance.RecordFailure("lines_abandoned"); err != nil {
+				log.Printf("Writing: failed to record failure: %v", err)
+			}
+			if cs, err := penance.LoadComplianceStatus(); err == nil {
+				s.Compliance.Locked = cs.Locked
+				s.Compliance.FailureScore = cs.FailureScore
+				s.Compliance.TaskStatus = cs.TaskStatus
+			}
+		case abandonBump:
+			w.Required += w.AbandonBump
+		case abandonThrottle:
+			from := throttler.Profile(s.Network.Profile)
+			p := throttler.Tighter(from)
+			if p == from {
+				break
+			}
+			if !dryRun {
+				err := throttler.ApplyNetworkProfile(p)
+				s.Network.RecordApply(err)
+				if err != nil {
+					log.Printf("Writing: failed to apply profile %s: %v", p, err)
+				}
+			} else {
+				log.Printf("[DRY-RUN] Would apply abandoned-task profile: %s", p)
+			}
+			s.Network.Profile = string(p)
+			s.Network.PacketLossPct = 0
+		}
+	}
+	actions := strings.Join(w.OnAbandon, ",")
+	vexlog.LogEvent("WRITING", "TASK_ABANDONED", fmt.Sprintf("phrase=%q idle=%s actions=%s required=%d profile=%s",
+		w.Phrase, idle, actions, w.Required, s.Network.Profile))
+	notify.Keyholder("task_abandoned", map[string]string{
+		"task":      "lines",
+		"phrase":    w.Phrase,
+		"idle":      idle.String(),
+		"actions":   actions,
+		"remaining": strconv.Itoa(w.Required - w.Completed),
+	})
+	s.ChangedBy = "lines"
+}
+
+// defaultStepEvery is how many accepted lines a task with difficulty
+// steps goes between steps unless lines set gives step_every.
+const defaultStepEvery = 25
+
+// stepWriting makes w harder after every StepEvery accepted lines: the
+// next of its clauses is appended to the phrase, and MaxSeconds drops by
+// PaceStep, down to MinSeconds and never below a second.  It reports
+// whether the phrase grew and whether the pace tightened.
+func stepWriting(w *state.WritingTask) (grew, faster bool) {
+	if w.StepEvery <= 0 || w.Completed%w.StepEvery != 0 {
+		return false, false
+	}
+	if len(w.Clauses) > 0 {
+		w.Phrase = strings.TrimSpace(w.Phrase) + " " + w.Clauses[0]
+		w.Clauses = slices.Clone(w.Clauses[1:])
+		grew = true
+	}
+	if w.PaceStep > 0 && w.MaxSeconds > 0 {
+		if next := max(w.MaxSeconds-w.PaceStep, w.MinSeconds, 1); next < w.MaxSeconds {
+			w.MaxSeconds = next
+			faster = true
+		}
+	}
+	return grew, faster
+}
+
+// lineKeystrokes is the evdev keystroke count when the current line was
+// started, valid while lineKeystrokesSet.  It is not persisted: after a
+// restart the first line goes without a keystroke check.
+var (
+	lineKeystrokes    uint64
+	lineKeystrokesSet bool
+)
+
+// liveMaxKPM is the keystroke rate a line of a live task may not exceed
+// unless lines set gives another max_kpm: well above a fast typist, well
+// below a script typing through uinput.
+const liveMaxKPM = 600
+
+// startLine starts timing the next line of w at now.  For a live task it
+// also starts a fresh keyboard capture for the line, unless a typing test
+// holds the capture.
+func startLine(w *state.WritingTask, now time.Time) {
+	w.LineStarted = now.UTC().Format(time.RFC3339)
+	lineKeystrokes, _ = surveillance.GetMetricSnapshot()
+	lineKeystrokesSet = true
+	if !w.Live {
+		stopLineCapture()
+	} else if typingTarget == "" {
+		surveillance.BeginTypingCapture()
+	}
+}
+
+// stopLineCapture drops a live task's keyboard capture, leaving a typing
+// test's alone.
+func stopLineCapture() {
+	if typingTarget == "" {
+		surveillance.EndTypingCapture()
+	}
+}
+
+// liveLineRefusal checks a line of a live task against what the keyboard
+// typed since the line was started: the same text, at least a keystroke
+// per character, and a rate within the task's KPM limits.  A line sent
+// over the socket by a script, or piped into a terminal, was never typed
+// and fails.  It returns the LineRejected reason and why, or "" if the
+// line may be checked against the phrase.
+func liveLineRefusal(w *state.WritingTask, line string) (string, string) {
+	if !w.Live {
+		return "", ""
+	}
+	if typingTarget != "" {
+		return "not_live", "a typing test is using the keyboard capture; finish it first"
+	}
+	c, ok := surveillance.TypingCaptureSnapshot()
+	if !ok || len(surveillance.GetMonitoredDevices()) == 0 {
+		return "not_live", "the keyboard is not being captured"
+	}
+	if typed := strings.TrimSpace(c.Text); typed != line {
+		return "not_typed", fmt.Sprintf("the line is not what the keyboard typed (%d characters typed, %d submitted)",
+			len([]rune(typed)), len([]rune(line)))
+	}
+	if n := len([]rune(line)); c.Keystrokes < n {
+		return "not_typed", fmt.Sprintf("%d keystrokes for a %d-character line", c.Keystrokes, n)
+	}
+	kpm := c.KPM()
+	if span := c.Last.Sub(c.First); c.Keystrokes > 1 && span < time.Second {
+		// KPM() leaves out bursts too short to measure; a burst is too
+		// fast whatever the limit.
+		kpm = math.Inf(1)
+		if span > 0 {
+			kpm = float64(c.Keystrokes) / span.Minutes()
+		}
+	}
+	if w.MaxKPM > 0 && kpm > float64(w.MaxKPM) {
+		return "kpm_high", fmt.Sprintf("typed at %.0f KPM; the limit is %d KPM", kpm, w.MaxKPM)
+	}
+	if w.MinKPM > 0 && kpm < float64(w.MinKPM) {
+		return "kpm_low", fmt.Sprintf("typed at %.0f KPM; at least %d KPM is required", kpm, w.MinKPM)
+	}
+	return "", ""
+}
+
+// linePaceRefusal checks a submitted line against the task's per-line
+// limits: the time since the line was started, and, with the keyboard
+// monitored, that at least as many keys were pressed as the line has
+// characters.  It returns the LineRejected reason and why, or "" if the
+// line may be checked against the phrase.  Tasks without limits are not
+// checked.
+func linePaceRefusal(w *state.WritingTask, line string, now time.Time) (string, string) {
+	if w.MinSeconds == 0 && w.MaxSeconds == 0 {
+		return "", ""
+	}
+	if started, err := time.Parse(time.RFC3339, w.LineStarted); err == nil {
+		took := now.Sub(started)
+		if min := time.Duration(w.MinSeconds) * time.Second; took < min {
+			return "too_fast", fmt.Sprintf("line took %s; each line must take at least %s", took.Round(time.Second), min)
+		}
+		if max := time.Duration(w.MaxSeconds) * time.Second; max > 0 && took > max {
+			return "too_slow", fmt.Sprintf("line took %s; each line must be finished within %s", took.Round(time.Second), max)
+		}
+	}
+	if lineKeystrokesSet && len(surveillance.GetMonitoredDevices()) > 0 {
+		keys, _ := surveillance.GetMetricSnapshot()
+		return lineTypedRefusal(keys, line)
+	}
+	return "", ""
+}
+
+// lineTypedRefusal checks line against the keys pressed since it was
+// started, keys being the evdev count now.  A count below the baseline
+// was reset mid-line, by a new counting period, so the keys before the
+// reset are lost: the line goes unchecked and the baseline starts over.
+func lineTypedRefusal(keys uint64, line string) (string, string) {
+	if keys < lineKeystrokes {
+		lineKeystrokes = keys
+		return "", ""
+	}
+	if n := uint64(len([]rune(line))); keys-lineKeystrokes < n {
+		return "not_typed", fmt.Sprintf("%d keys pressed for a %d-character line", keys-lineKeystrokes, n)
+	}
+	return "", ""
+}
+
+// handleLinesRejected hears about a line the CLI rejected without
+// submitting it (a pasted one), so that it is logged and reported like a
+// mismatch.
+func handleLinesRejected(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if !s.Writing.Active {
+		return &ipc.Response{OK: false, Error: "no active writing task"}
+	}
+	reason := req.Args["reason"]
+	if reason == "" {
+		return &ipc.Response{OK: false, Error: "missing 'reason' argument"}
+	}
+
+	line := s.Writing.Completed + 1
+	startLine(&s.Writing, clock.Now())
+	s.ChangedBy = "cli"
+	vexlog.LogEvent("WRITING", "LINE_REJECTED", fmt.Sprintf("reason=%s line=%d", reason, line))
+	events.Publish(events.LineRejected{Task: "lines", Line: line, Reason: reason})
+	return &ipc.Response{OK: true, Message: "Rejected line reported"}
+}
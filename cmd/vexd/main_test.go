@@ -0,0 +1,731 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/config"
+	"github.com/adumbdinosaur/vex-cli/internal/fakes"
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// These tests run the handlers in-process against the fake kernel of
+// package fakes, with every file under one temporary root and the
+// virtual clock stopped, so that deadlines pass only when a test
+// advances it.
+
+var (
+	testRoot string
+	testSrv  *ipc.Server
+
+	// testKey signs what the management key would.
+	testKey ed25519.PrivateKey
+
+	// trustClients is what the lowering guard says of any client.
+	trustClients = true
+)
+
+func TestMain(m *testing.M) {
+	code, err := runTests(m)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func runTests(m *testing.M) (int, error) {
+	root, err := os.MkdirTemp("", "vexd-test-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(root)
+	testRoot = root
+
+	fakes.Reroot(root)
+	if err := fakes.Install(root); err != nil {
+		return 0, err
+	}
+	for _, dir := range []string{penance.ConfigDir, state.StateDir, filepath.Dir(vexlog.LogFilePath), filepath.Dir(security.PublicKeyFile)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return 0, err
+		}
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return 0, err
+	}
+	testKey = priv
+	if err := os.WriteFile(security.PublicKeyFile, []byte(hex.EncodeToString(pub)+"\n"), 0o644); err != nil {
+		return 0, err
+	}
+	// The lists are read from the working directory.
+	if err := os.Chdir(penance.ConfigDir); err != nil {
+		return 0, err
+	}
+	os.Setenv("VEX_INTERFACE", fakes.Interface)
+	os.Setenv("VEX_WINDOW_BACKEND", "off")
+	subsystem.Set(subsystem.AntiTamper, subsystem.Off)
+	subsystem.Set(subsystem.Media, subsystem.Off)
+	if err := vexlog.Init(); err != nil {
+		return 0, err
+	}
+	defer vexlog.Close()
+	if err := security.Init(); err != nil {
+		return 0, err
+	}
+
+	clock.Simulate(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	if err := clock.SetRate(0); err != nil {
+		return 0, err
+	}
+	if err := throttler.Init(); err != nil {
+		return 0, err
+	}
+
+	srv, err := ipc.NewServer(state.Default())
+	if err != nil {
+		return 0, err
+	}
+	defer srv.Close()
+	registerHandlers(srv)
+	srv.RequireClient(func(string) bool { return trustClients }, loweringCommands...)
+	liveSrv, testSrv = srv, srv
+	go srv.Serve()
+
+	return m.Run(), nil
+}
+
+// reset starts a test on default state and an open kernel.
+func reset(t *testing.T) {
+	t.Helper()
+	testSrv.SetState(state.Default())
+	testSrv.Update(func(s *state.SystemState) {
+		armExpiries(s)
+		armPause(s)
+		armRelock(s)
+	})
+	if err := penance.SaveComplianceStatus(&penance.ComplianceStatus{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := guardian.SetBlockedDomains(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := throttler.ApplyNetworkProfile(throttler.ProfileStandard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dispatch runs a request through the registered handlers, as one from a
+// trusted client would be, and fails the test if it is refused.
+func dispatch(t *testing.T, command string, args map[string]string) *ipc.Response {
+	t.Helper()
+	resp := testSrv.Dispatch(&ipc.Request{Command: command, Args: args})
+	if !resp.OK {
+		t.Fatalf("%s %v: %s", command, args, resp.Error)
+	}
+	return resp
+}
+
+// sign returns a payload signed by the management key, as JSON.
+func sign(t *testing.T, command, args string) string {
+	t.Helper()
+	cmd := security.SignedCommand{Command: command, Args: args, Timestamp: clock.Now().Unix()}
+	msg := fmt.Sprintf("%s:%s:%d", cmd.Command, cmd.Args, cmd.Timestamp)
+	cmd.Signature = hex.EncodeToString(ed25519.Sign(testKey, []byte(msg)))
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// advance moves the virtual clock forward by d and waits for what falls
+// due on the way to run.
+func advance(t *testing.T, d time.Duration) {
+	t.Helper()
+	if _, err := clock.Advance(d); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		st, err := clock.Current()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Advancing.IsZero() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the clock is still advancing to %s", st.Advancing)
+		}
+	}
+}
+
+// current returns a copy of the daemon's state.
+func current() state.SystemState {
+	var c state.SystemState
+	testSrv.View(func(s *state.SystemState) { c = *s })
+	return c
+}
+
+// kernelBlocked returns the domains the fake firewall blocks.
+func kernelBlocked(t *testing.T) []string {
+	t.Helper()
+	snap, err := fakes.ReadSnapshot(testRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap.Blocked
+}
+
+// restrict puts a throttle and a blocked domain in place.
+func restrict(t *testing.T) {
+	t.Helper()
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "dial-up"})
+	dispatch(t, ipc.CmdBlockAdd, map[string]string{"domain": "example.com"})
+	if got := kernelBlocked(t); !slices.Contains(got, "example.com") {
+		t.Fatalf("firewall blocks %v after block add", got)
+	}
+}
+
+// checkRestricted fails unless what restrict put in place is in force.
+func checkRestricted(t *testing.T, when string) {
+	t.Helper()
+	s := current()
+	if s.Network.Profile != "dial-up" {
+		t.Errorf("%s: profile is %q, want dial-up", when, s.Network.Profile)
+	}
+	if !slices.Contains(s.Guardian.BlockedDomains, "example.com") {
+		t.Errorf("%s: blocklist is %v", when, s.Guardian.BlockedDomains)
+	}
+	if got := kernelBlocked(t); !slices.Contains(got, "example.com") {
+		t.Errorf("%s: firewall blocks %v", when, got)
+	}
+}
+
+// checkLifted fails unless nothing is enforced.
+func checkLifted(t *testing.T, when string) {
+	t.Helper()
+	s := current()
+	if s.Network.Profile != string(throttler.ProfileStandard) {
+		t.Errorf("%s: profile is %q, want standard", when, s.Network.Profile)
+	}
+	if len(s.Guardian.BlockedDomains) != 0 {
+		t.Errorf("%s: blocklist is %v", when, s.Guardian.BlockedDomains)
+	}
+	if got := kernelBlocked(t); len(got) != 0 {
+		t.Errorf("%s: firewall blocks %v", when, got)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	reset(t)
+	restrict(t)
+
+	dispatch(t, ipc.CmdPause, map[string]string{"until": "2h"})
+	if current().Pause == nil {
+		t.Fatal("not paused")
+	}
+	checkLifted(t, "paused")
+
+	resp := testSrv.Dispatch(&ipc.Request{Command: ipc.CmdThrottle, Args: map[string]string{"profile": "choke"}})
+	if resp.OK {
+		t.Error("throttle was taken while paused")
+	}
+
+	dispatch(t, ipc.CmdResume, nil)
+	if current().Pause != nil {
+		t.Fatal("still paused after resume")
+	}
+	checkRestricted(t, "resumed")
+
+	if resp := testSrv.Dispatch(&ipc.Request{Command: ipc.CmdResume}); resp.OK {
+		t.Error("resume succeeded without a pause")
+	}
+}
+
+func TestPauseEndsAtDeadline(t *testing.T) {
+	reset(t)
+	restrict(t)
+
+	dispatch(t, ipc.CmdPause, map[string]string{"until": "2h"})
+	advance(t, time.Hour)
+	if current().Pause == nil {
+		t.Fatal("pause ended early")
+	}
+	checkLifted(t, "an hour into the pause")
+
+	advance(t, time.Hour+time.Minute)
+	if current().Pause != nil {
+		t.Fatal("still paused after the deadline")
+	}
+	checkRestricted(t, "after the pause")
+}
+
+func TestRelock(t *testing.T) {
+	reset(t)
+	restrict(t)
+	testSrv.Update(func(s *state.SystemState) { s.Compliance.Locked = true })
+
+	dispatch(t, ipc.CmdUnlock, map[string]string{"until": "3h"})
+	s := current()
+	if s.Relock == nil {
+		t.Fatal("no relock pending after unlock --until")
+	}
+	if s.Compliance.Locked {
+		t.Error("still locked during the temporary unlock")
+	}
+	checkLifted(t, "unlocked")
+
+	advance(t, 3*time.Hour+time.Minute)
+	s = current()
+	if s.Relock != nil {
+		t.Fatal("relock still pending after its deadline")
+	}
+	if !s.Compliance.Locked {
+		t.Error("not locked again after the relock")
+	}
+	checkRestricted(t, "relocked")
+}
+
+func TestRelockDuringPause(t *testing.T) {
+	reset(t)
+	restrict(t)
+
+	dispatch(t, ipc.CmdUnlock, map[string]string{"until": "1h"})
+	dispatch(t, ipc.CmdPause, map[string]string{"until": "2h"})
+	advance(t, time.Hour+time.Minute)
+	if current().Relock != nil {
+		t.Fatal("relock still pending after its deadline")
+	}
+	checkLifted(t, "relocked while paused")
+
+	dispatch(t, ipc.CmdResume, nil)
+	checkRestricted(t, "resumed after the relock")
+}
+
+func TestLoweringGuard(t *testing.T) {
+	reset(t)
+	restrict(t)
+	trustClients = false
+	defer func() { trustClients = true }()
+
+	c := ipc.NewClient(state.SocketPath)
+	for _, req := range []*ipc.Request{
+		{Command: ipc.CmdThrottle, Args: map[string]string{"profile": "standard"}},
+		{Command: ipc.CmdBlockRemove, Args: map[string]string{"domain": "example.com"}},
+		{Command: ipc.CmdPause, Args: map[string]string{"until": "1h"}},
+		{Command: ipc.CmdUnlock},
+		{Command: ipc.CmdConfigSet, Args: map[string]string{"name": "subsystems.guardian", "value": "off"}},
+		{Command: ipc.CmdScheduleAdd, Args: map[string]string{"at": "07:00 daily", "command": "block add example.org"}},
+		{Command: ipc.CmdSessions},
+		{Command: ipc.CmdSessionRevoke, Args: map[string]string{"id": "00000000"}},
+	} {
+		resp, err := c.Send(req)
+		if err != nil {
+			t.Fatalf("%s: %v", req.Command, err)
+		}
+		if resp.OK {
+			t.Errorf("%s from an untrusted client was carried out", req.Command)
+		} else if !strings.Contains(resp.Error, "known-good") || resp.Code != ipc.CodeUnauthorized {
+			t.Errorf("%s refused for the wrong reason: %s", req.Command, resp.Error)
+		}
+	}
+	checkRestricted(t, "after the refused requests")
+
+	// Raising enforcement needs no trusted client.
+	resp, err := c.Send(&ipc.Request{Command: ipc.CmdBlockAdd, Args: map[string]string{"domain": "example.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.OK {
+		t.Errorf("block add from an untrusted client refused: %s", resp.Error)
+	}
+}
+
+func TestWithExpiry(t *testing.T) {
+	reset(t)
+
+	resp := dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "dial-up", "for": "30m"})
+	if !strings.Contains(resp.Message, "reverts at") {
+		t.Errorf("message %q does not say when it reverts", resp.Message)
+	}
+	// Chained, it still reverts to what came before the first.
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "choke", "for": "1h"})
+	exp := current().Expiries
+	if len(exp) != 1 || exp[0].Value != "choke" || exp[0].Previous != "standard" {
+		t.Fatalf("expiries %+v, want one from choke back to standard", exp)
+	}
+	advance(t, 30*time.Minute+time.Second)
+	if got := current().Network.Profile; got != "choke" {
+		t.Fatalf("profile %q after the first period, want choke", got)
+	}
+	advance(t, 30*time.Minute)
+	if s := current(); s.Network.Profile != "standard" || len(s.Expiries) != 0 {
+		t.Fatalf("profile %q, expiries %+v after an hour; want standard and none", s.Network.Profile, s.Expiries)
+	}
+
+	// Without --for the new value stays.
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "dial-up", "for": "30m"})
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "choke"})
+	if exp := current().Expiries; len(exp) != 0 {
+		t.Fatalf("pending revert %+v kept after a setting without --for", exp)
+	}
+	advance(t, 31*time.Minute)
+	if got := current().Network.Profile; got != "choke" {
+		t.Errorf("profile %q, want choke to stay", got)
+	}
+
+	resp = testSrv.Dispatch(&ipc.Request{Command: ipc.CmdThrottle, Args: map[string]string{"profile": "dial-up", "for": "soon"}})
+	if resp.OK {
+		t.Error("an invalid --for was taken")
+	}
+}
+
+func TestRevertExpiredRetries(t *testing.T) {
+	reset(t)
+
+	// A revert to a profile that does not exist fails.
+	due := clock.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	testSrv.Update(func(s *state.SystemState) {
+		s.Expiries = []state.Expiry{{Kind: "throttle", Value: "standard", Previous: "no-such-profile", Until: due}}
+		revertExpired(s)
+	})
+	exp := current().Expiries
+	if len(exp) != 1 {
+		t.Fatalf("failed revert dropped: expiries %+v", exp)
+	}
+	if want := clock.Now().Add(expiryRetry).UTC().Format(time.RFC3339); exp[0].Until != want {
+		t.Errorf("retry at %s, want %s", exp[0].Until, want)
+	}
+
+	testSrv.Update(func(s *state.SystemState) { s.Expiries[0].Previous = "dial-up" })
+	advance(t, expiryRetry+time.Second)
+	if s := current(); s.Network.Profile != "dial-up" || len(s.Expiries) != 0 {
+		t.Errorf("profile %q, expiries %+v after the retry; want dial-up and none", s.Network.Profile, s.Expiries)
+	}
+}
+
+func TestRevertExpiredSupersedes(t *testing.T) {
+	reset(t)
+
+	dispatch(t, ipc.CmdBlockAdd, map[string]string{"domain": "example.com", "for": "30m"})
+	// Unblocked by hand meanwhile; the revert has nothing to do.
+	testSrv.Update(func(s *state.SystemState) {
+		guardian.RemoveDomain("example.com")
+		s.Guardian.BlockedDomains = guardian.GetBlockedDomains()
+	})
+	dispatch(t, ipc.CmdBlockAdd, map[string]string{"domain": "example.org"})
+	advance(t, 31*time.Minute)
+	s := current()
+	if len(s.Expiries) != 0 {
+		t.Errorf("superseded expiry kept: %+v", s.Expiries)
+	}
+	if !slices.Contains(s.Guardian.BlockedDomains, "example.org") {
+		t.Errorf("blocklist %v lost example.org", s.Guardian.BlockedDomains)
+	}
+}
+
+func TestExpiryDueDuringPause(t *testing.T) {
+	reset(t)
+	restrict(t)
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "choke", "for": "30m"})
+
+	dispatch(t, ipc.CmdPause, map[string]string{"until": "2h"})
+	// One armed while paused that falls due before the resume.
+	until := clock.Now().Add(10 * time.Minute).UTC().Format(time.RFC3339)
+	testSrv.Update(func(s *state.SystemState) {
+		s.Expiries = append(s.Expiries, state.Expiry{Kind: "block", Target: "example.com", Value: "blocked", Until: until})
+		armExpiries(s)
+	})
+	advance(t, time.Hour)
+	s := current()
+	if len(s.Expiries) != 0 || len(s.Pause.Saved.Expiries) != 2 {
+		t.Fatalf("expiries %+v, saved %+v; want both left for the resume", s.Expiries, s.Pause.Saved.Expiries)
+	}
+	checkLifted(t, "paused")
+
+	dispatch(t, ipc.CmdResume, nil)
+	s = current()
+	if s.Network.Profile != "dial-up" {
+		t.Errorf("profile %q after resume, want the revert to dial-up", s.Network.Profile)
+	}
+	if slices.Contains(s.Guardian.BlockedDomains, "example.com") || slices.Contains(kernelBlocked(t), "example.com") {
+		t.Errorf("example.com still blocked after resume")
+	}
+	if len(s.Expiries) != 0 {
+		t.Errorf("expiries %+v left after resume", s.Expiries)
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	reset(t)
+	defer func() {
+		config.Set("guardian.dns_refresh_minutes", "")
+		testSrv.Dispatch(&ipc.Request{Command: ipc.CmdReload})
+	}()
+
+	resp := dispatch(t, ipc.CmdConfigSet, map[string]string{"name": "guardian.dns_refresh_minutes", "value": "15"})
+	if !strings.Contains(resp.Message, "guardian.dns_refresh_minutes") {
+		t.Errorf("reload message %q does not name the setting", resp.Message)
+	}
+	if guardian.DNSRefreshInterval != 15*time.Minute {
+		t.Errorf("DNS refresh every %s, want 15m", guardian.DNSRefreshInterval)
+	}
+	if resp := dispatch(t, ipc.CmdConfig, map[string]string{"name": "guardian.dns_refresh_minutes"}); resp.Message != "15" {
+		t.Errorf("config shows %q, want 15", resp.Message)
+	}
+	if resp := dispatch(t, ipc.CmdConfig, nil); !strings.Contains(resp.Message, "guardian.dns_refresh_minutes = 15\n") {
+		t.Errorf("config show lacks the setting:\n%s", resp.Message)
+	}
+
+	resp = testSrv.Dispatch(&ipc.Request{Command: ipc.CmdConfigSet, Args: map[string]string{"name": "guardian.dns_refresh_minutes", "value": "-5"}})
+	if resp.OK || resp.Code != "" {
+		t.Errorf("invalid value: ok=%v code=%q, want refused as invalid", resp.OK, resp.Code)
+	}
+	if guardian.DNSRefreshInterval != 15*time.Minute {
+		t.Errorf("a refused value changed the refresh to %s", guardian.DNSRefreshInterval)
+	}
+}
+
+func TestScheduleLowering(t *testing.T) {
+	reset(t)
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "dial-up"})
+
+	when := clock.Now().Local().Add(30*time.Minute).Format("15:04") + " daily"
+	add := func(command, payload string) *ipc.Response {
+		return testSrv.Dispatch(&ipc.Request{Command: ipc.CmdScheduleAdd, Args: map[string]string{"at": when, "command": command, "payload": payload}})
+	}
+
+	if resp := add("throttle standard", ""); resp.OK || resp.Code != ipc.CodeUnauthorized {
+		t.Errorf("unsigned schedule of throttle standard: ok=%v code=%q, want refused as unauthorized", resp.OK, resp.Code)
+	}
+	if resp := add("throttle standard", sign(t, "schedule-add", when+"|throttle black-hole")); resp.OK {
+		t.Error("schedule accepted with a payload signed for another command")
+	}
+	if resp := add("throttle standard", sign(t, "unlock", "")); resp.OK {
+		t.Error("schedule accepted with a payload signed for unlock")
+	}
+	if resp := add("block add example.com", ""); !resp.OK {
+		t.Errorf("unsigned schedule of block add refused: %s", resp.Error)
+	}
+	if resp := add("throttle   standard", sign(t, "schedule-add", when+"|throttle standard")); !resp.OK {
+		t.Fatalf("signed schedule refused: %s", resp.Error)
+	}
+	if n := len(current().Schedules); n != 2 {
+		t.Fatalf("%d schedules, want 2", n)
+	}
+
+	advance(t, 31*time.Minute)
+	for _, sc := range current().Schedules {
+		runSchedule(sc.ID)
+	}
+	if got := current().Network.Profile; got != string(throttler.ProfileStandard) {
+		t.Errorf("profile %q after the signed schedule ran, want standard", got)
+	}
+	if got := kernelBlocked(t); !slices.Contains(got, "example.com") {
+		t.Errorf("firewall blocks %v after block add ran", got)
+	}
+
+	// A payload that no longer verifies keeps the command from running,
+	// e.g. one edited into state.json.
+	dispatch(t, ipc.CmdThrottle, map[string]string{"profile": "dial-up"})
+	id := 0
+	testSrv.Update(func(s *state.SystemState) {
+		for i := range s.Schedules {
+			if s.Schedules[i].Payload != "" {
+				s.Schedules[i].Command = "throttle standard"
+				s.Schedules[i].Payload = ""
+				id = s.Schedules[i].ID
+			}
+		}
+	})
+	advance(t, 24*time.Hour)
+	runSchedule(id)
+	if got := current().Network.Profile; got != string(throttler.ProfileDialUp) {
+		t.Errorf("profile %q after a schedule without its payload ran, want dial-up", got)
+	}
+}
+
+func TestRunInitStepsCopies(t *testing.T) {
+	s := state.Default()
+	s.Guardian.BlockedDomains = []string{"example.com"}
+	steps := []initStep{{
+		name: "test",
+		run: func(c *state.SystemState) func(*state.SystemState) {
+			c.Guardian.BlockedDomains[0] = "changed.example"
+			c.Guardian.BlockedDomains = append(c.Guardian.BlockedDomains, "added.example")
+			return func(s *state.SystemState) { s.Network.Profile = "dial-up" }
+		},
+	}}
+	if late := runInitSteps(s, steps); len(late) != 0 {
+		t.Fatalf("%d steps late", len(late))
+	}
+	if !slices.Equal(s.Guardian.BlockedDomains, []string{"example.com"}) {
+		t.Errorf("a step's copy changed the state's domains to %v", s.Guardian.BlockedDomains)
+	}
+	if s.Network.Profile != "dial-up" {
+		t.Errorf("profile %q, want the step's outcome recorded", s.Network.Profile)
+	}
+}
+
+func TestLinePaceRefusal(t *testing.T) {
+	started := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		min, max int
+		took     time.Duration
+		want     string
+	}{
+		{"no limits", 0, 0, time.Second, ""},
+		{"too fast", 10, 0, 5 * time.Second, "too_fast"},
+		{"at the minimum", 10, 0, 10 * time.Second, ""},
+		{"in range", 10, 60, 30 * time.Second, ""},
+		{"at the maximum", 10, 60, time.Minute, ""},
+		{"too slow", 10, 60, 61 * time.Second, "too_slow"},
+		{"no maximum", 10, 0, time.Hour, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &state.WritingTask{MinSeconds: tc.min, MaxSeconds: tc.max, LineStarted: started.Format(time.RFC3339)}
+			if got, why := linePaceRefusal(w, "a line", started.Add(tc.took)); got != tc.want {
+				t.Errorf("refusal %q (%s), want %q", got, why, tc.want)
+			}
+		})
+	}
+}
+
+func TestLineTypedRefusal(t *testing.T) {
+	defer func(k uint64) { lineKeystrokes = k }(lineKeystrokes)
+	for _, tc := range []struct {
+		name       string
+		start, now uint64
+		line       string
+		want       string
+		baseline   uint64
+	}{
+		{"typed", 100, 110, "ten chars!", "", 100},
+		{"more keys than characters", 100, 150, "ten chars!", "", 100},
+		{"too few keys", 100, 105, "ten chars!", "not_typed", 100},
+		{"characters, not bytes", 100, 103, "äöü", "", 100},
+		{"counter reset mid-line", 100, 4, "ten chars!", "", 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			lineKeystrokes = tc.start
+			if got, why := lineTypedRefusal(tc.now, tc.line); got != tc.want {
+				t.Errorf("refusal %q (%s), want %q", got, why, tc.want)
+			}
+			if lineKeystrokes != tc.baseline {
+				t.Errorf("baseline %d, want %d", lineKeystrokes, tc.baseline)
+			}
+		})
+	}
+}
+
+func TestWritingAbandoned(t *testing.T) {
+	progressed := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		w    state.WritingTask
+		idle time.Duration
+		want bool
+	}{
+		{"inactive", state.WritingTask{AbandonMins: 30}, time.Hour, false},
+		{"no abandon period", state.WritingTask{Active: true}, 24 * time.Hour, false},
+		{"within the period", state.WritingTask{Active: true, AbandonMins: 30}, 29 * time.Minute, false},
+		{"at the period", state.WritingTask{Active: true, AbandonMins: 30}, 30 * time.Minute, true},
+		{"past the period", state.WritingTask{Active: true, AbandonMins: 30}, time.Hour, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.w.Progressed = progressed.Format(time.RFC3339)
+			if got := writingAbandoned(tc.w, progressed.Add(tc.idle)); got != tc.want {
+				t.Errorf("abandoned = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStepWriting(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		w            state.WritingTask
+		phrase       string
+		max          int
+		grew, faster bool
+	}{
+		{"between steps", state.WritingTask{Phrase: "a", Clauses: []string{"b"}, Completed: 24, StepEvery: 25, MaxSeconds: 60, PaceStep: 5}, "a", 60, false, false},
+		{"no steps", state.WritingTask{Phrase: "a", Clauses: []string{"b"}, Completed: 25, MaxSeconds: 60, PaceStep: 5}, "a", 60, false, false},
+		{"step", state.WritingTask{Phrase: "a", Clauses: []string{"b", "c"}, Completed: 25, StepEvery: 25, MaxSeconds: 60, PaceStep: 5}, "a b", 55, true, true},
+		{"clauses used up", state.WritingTask{Phrase: "a b c", Completed: 50, StepEvery: 25, MaxSeconds: 60, PaceStep: 5}, "a b c", 55, false, true},
+		{"down to the minimum", state.WritingTask{Phrase: "a", Completed: 25, StepEvery: 25, MinSeconds: 20, MaxSeconds: 22, PaceStep: 5}, "a", 20, false, true},
+		{"at the minimum", state.WritingTask{Phrase: "a", Completed: 25, StepEvery: 25, MinSeconds: 20, MaxSeconds: 20, PaceStep: 5}, "a", 20, false, false},
+		{"never below a second", state.WritingTask{Phrase: "a", Completed: 25, StepEvery: 25, MaxSeconds: 3, PaceStep: 5}, "a", 1, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			grew, faster := stepWriting(&tc.w)
+			if grew != tc.grew || faster != tc.faster {
+				t.Errorf("grew, faster = %v, %v; want %v, %v", grew, faster, tc.grew, tc.faster)
+			}
+			if tc.w.Phrase != tc.phrase || tc.w.MaxSeconds != tc.max {
+				t.Errorf("phrase %q, max %ds; want %q, %ds", tc.w.Phrase, tc.w.MaxSeconds, tc.phrase, tc.max)
+			}
+		})
+	}
+}
+
+func TestSessionBar(t *testing.T) {
+	reset(t)
+	c := ipc.NewClient(state.SocketPath)
+	if resp, err := c.Send(&ipc.Request{Command: ipc.CmdStatus}); err != nil || !resp.OK {
+		t.Fatalf("status: %v %+v", err, resp)
+	}
+	var own ipc.Session
+	for _, sess := range dispatch(t, ipc.CmdSessions, nil).Sessions {
+		if sess.PID == os.Getpid() && !sess.Revoked {
+			own = sess
+		}
+	}
+	if own.Hash == "" {
+		t.Fatal("no session of this process with its executable's hash")
+	}
+
+	revoke := &ipc.Request{Command: ipc.CmdSessionRevoke, Args: map[string]string{"id": own.ID, "exe": "true"}}
+	if resp := testSrv.Dispatch(revoke); resp.OK {
+		t.Fatal("a known-good client was barred")
+	}
+	trustClients = false
+	defer func() { trustClients = true }()
+	dispatch(t, ipc.CmdSessionRevoke, revoke.Args)
+	if !slices.Contains(current().Barred, own.Hash) {
+		t.Fatalf("barred %v, want the executable's hash", current().Barred)
+	}
+
+	// A new process running the same executable gets no session either.
+	resp, err := ipc.NewClient(state.SocketPath).Send(&ipc.Request{Command: ipc.CmdStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OK || resp.Code != ipc.CodeUnauthorized {
+		t.Fatalf("barred executable: ok=%v code=%q, want refused as unauthorized", resp.OK, resp.Code)
+	}
+
+	dispatch(t, ipc.CmdSessionUnbar, map[string]string{"hash": own.Hash})
+	if resp, err := ipc.NewClient(state.SocketPath).Send(&ipc.Request{Command: ipc.CmdStatus}); err != nil || !resp.OK {
+		t.Errorf("status after unbar: %v %+v", err, resp)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build vextest
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/calendar"
+	"github.com/adumbdinosaur/vex-cli/internal/checkpoint"
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/config"
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+	"github.com/adumbdinosaur/vex-cli/internal/discord"
+	"github.com/adumbdinosaur/vex-cli/internal/extension"
+	"github.com/adumbdinosaur/vex-cli/internal/heartbeat"
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/matrix"
+	"github.com/adumbdinosaur/vex-cli/internal/messages"
+	"github.com/adumbdinosaur/vex-cli/internal/modules"
+	"github.com/adumbdinosaur/vex-cli/internal/mqtt"
+	"github.com/adumbdinosaur/vex-cli/internal/notice"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/push"
+	"github.com/adumbdinosaur/vex-cli/internal/report"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/stats"
+)
+
+// ═══════════════════════════════════════════════════════════════════
+// Simulation
+// ═══════════════════════════════════════════════════════════════════
+
+// simDir is the scratch directory of --simulate, removed on exit.  It is
+// "" in test mode, whose directory belongs to the test.
+var simDir string
+
+func init() {
+	startSimulation = setUpSimulation
+	endSimulation = func() {
+		if simDir != "" {
+			os.RemoveAll(simDir)
+		}
+	}
+	handleClock = clockHandler
+}
+
+// setUpSimulation sets vexd up for --simulate, a preview of how the
+// keyholder's manifest and schedules play out: a copy of the
+// configuration and the current state in a scratch directory, the fake
+// kernel, nothing sent off the machine, and the virtual clock of package
+// clock, moved with vex-cli clock.  In test mode the test's directory is
+// used as it is.
+func setUpSimulation() {
+	if fakeRoot == "" {
+		dir, err := os.MkdirTemp("", "vexd-simulate-")
+		if err != nil {
+			log.Fatalf("Simulation: %v", err)
+		}
+		simDir = dir
+		if err := seedSimulation(dir); err != nil {
+			os.RemoveAll(dir)
+			log.Fatalf("Simulation: failed to copy the configuration: %v", err)
+		}
+		if err := runOnFakes(dir); err != nil {
+			os.RemoveAll(dir)
+			log.Fatalf("Simulation: %v", err)
+		}
+	}
+	clock.Simulate(time.Now())
+	log.Printf("Simulation: running under %s on a virtual clock; talk to it with vex-cli --socket %s", fakeRoot, state.SocketPath)
+}
+
+// seedSimulation copies the configuration directory, less what would
+// reach anyone outside (notifications, bots, reports, uploads, servers and
+// external modules), and the state file under dir.  Desktop notifications
+// are turned off, as they are on unless configured.
+func seedSimulation(dir string) error {
+	outward := []string{
+		notify.ConfigFile, push.ConfigFile, desktop.ConfigFile, discord.ConfigFile,
+		matrix.ConfigFile, mqtt.ConfigFile, heartbeat.ConfigFile, checkpoint.ConfigFile,
+		checkpoint.KeyFile, report.ConfigFile, stats.ConfigFile, extension.ConfigFile,
+		calendar.ConfigFile, messages.ConfigFile, notice.ConfigFile, modules.ConfigFile,
+		vexlog.ForwardConfigFile,
+	}
+	err := filepath.WalkDir(penance.ConfigDir, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case !d.Type().IsRegular() || slices.Contains(outward, path):
+			return nil
+		}
+		return copyFile(path, filepath.Join(dir, path))
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := copyFile(state.StateFile, filepath.Join(dir, state.StateFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// The real daemon's socket is not for the simulation to take over.
+	if err := dropSocketPath(filepath.Join(dir, config.File)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	off := filepath.Join(dir, desktop.ConfigFile)
+	if err := os.MkdirAll(filepath.Dir(off), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(off, []byte(`{"disabled": true}`+"\n"), 0o644)
+}
+
+// dropSocketPath removes socket_path from the config.json at path.
+func dropSocketPath(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if _, ok := cfg["socket_path"]; !ok {
+		return nil
+	}
+	delete(cfg, "socket_path")
+	if data, err = json.MarshalIndent(cfg, "", "  "); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0o600)
+}
+
+// clockHandler shows the virtual clock or, with rate or advance, changes
+// it.  An advance runs in the background, as what falls due on the way
+// needs the state this handler holds; the clock reports it until done.
+func clockHandler(s *state.SystemState, req *ipc.Request) *ipc.Response {
+	if !clock.Simulated() {
+		return &ipc.Response{OK: false, Error: "the clock can only be changed under vexd --simulate"}
+	}
+	var msg string
+	if arg, ok := req.Args["rate"]; ok {
+		rate, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid rate %q", arg)}
+		}
+		if err := clock.SetRate(rate); err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		vexlog.LogEvent("CLOCK", "RATE", "rate="+arg)
+		msg = fmt.Sprintf("Clock running at %gx.", rate)
+	}
+	if arg, ok := req.Args["advance"]; ok {
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return &ipc.Response{OK: false, Error: fmt.Sprintf("invalid duration %q (e.g. 90m or 72h)", arg)}
+		}
+		target, err := clock.Advance(d)
+		if err != nil {
+			return &ipc.Response{OK: false, Error: err.Error()}
+		}
+		vexlog.LogEvent("CLOCK", "ADVANCE", fmt.Sprintf("by=%s to=%s", d, target.UTC().Format(time.RFC3339)))
+		msg = fmt.Sprintf("Advancing the clock to %s.", target.Local().Format("Mon 2006-01-02 15:04"))
+	}
+
+	st, _ := clock.Current()
+	c := &ipc.ClockStatus{Now: st.Now.UTC().Format(time.RFC3339), Rate: st.Rate, Timers: st.Pending}
+	if !st.Advancing.IsZero() {
+		c.Advancing = st.Advancing.UTC().Format(time.RFC3339)
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("Clock at %s, running at %gx.", st.Now.Local().Format("Mon 2006-01-02 15:04"), st.Rate)
+	}
+	return &ipc.Response{OK: true, Message: msg, Clock: c}
+}
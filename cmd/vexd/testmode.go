@@ -0,0 +1,71 @@
+//go:build vextest
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/adumbdinosaur/vex-cli/internal/fakes"
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+)
+
+// Test mode.  A vexd built with the vextest tag and started with
+// VEX_TEST_ROOT set runs without root against the fake kernel of package
+// fakes, with every file it reads or writes under that directory (see
+// runOnFakes).  Production builds have none of this.
+func init() {
+	root := os.Getenv("VEX_TEST_ROOT")
+	if root == "" {
+		return
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		log.Fatalf("Test mode: %v", err)
+	}
+	if err := runOnFakes(root); err != nil {
+		log.Fatalf("Test mode: %v", err)
+	}
+	log.Printf("Test mode: running under %s", root)
+}
+
+// runOnFakes moves every file under root and puts vexd on the fake
+// kernel there, for test mode and --simulate.  The anti-tamper checks and
+// the media caps, which would look at or change the real machine, are
+// off.
+func runOnFakes(root string) error {
+	fakeRoot = root
+	fakes.Reroot(root)
+	if err := fakes.Install(root); err != nil {
+		return fmt.Errorf("failed to install the fake kernel: %w", err)
+	}
+	runMarker = filepath.Join(state.StateDir, "vexd.running")
+	exceptionPolicyFile = filepath.Join(penance.ConfigDir, "exceptions.json")
+
+	for _, dir := range []string{penance.ConfigDir, filepath.Dir(vexlog.LogFilePath)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	// As the systemd unit has it, the lists are read from the working
+	// directory.
+	if err := os.Chdir(penance.ConfigDir); err != nil {
+		return err
+	}
+
+	os.Setenv("VEX_INTERFACE", fakes.Interface)
+	os.Setenv("VEX_WINDOW_BACKEND", "off")
+	for _, name := range []string{"VEX_DEVICE_PATH", "VEX_INPUT_INCLUDE", "VEX_INPUT_EXCLUDE", "VEX_SYNC_ROLE"} {
+		os.Unsetenv(name)
+	}
+	guardian.SetMonitorMode("proc")
+	subsystem.Set(subsystem.AntiTamper, subsystem.Off)
+	subsystem.Set(subsystem.Media, subsystem.Off)
+	return nil
+}
@@ -9,10 +9,11 @@
   let
     system = "x86_64-linux";
     pkgs = nixpkgs.legacyPackages.${system};
+    version = "2.0-V";
 
     # Common build attributes shared by both packages
     commonAttrs = {
-      version = "2.0-V";
+      inherit version;
       src = self;
       vendorHash = null;
       env.CGO_ENABLED = 1;
@@ -34,6 +35,10 @@
       ldflags = [
         "-s" "-w"
         "-X github.com/adumbdinosaur/vex-cli/internal/antitamper.ExpectedBinaryHash=SET_AT_RUNTIME"
+        # Reported by `vex-cli version`; both binaries must agree.
+        "-X github.com/adumbdinosaur/vex-cli/internal/buildinfo.Version=${version}"
+        "-X github.com/adumbdinosaur/vex-cli/internal/buildinfo.Commit=${self.rev or self.dirtyRev or ""}"
+        "-X github.com/adumbdinosaur/vex-cli/internal/buildinfo.BuildHash=${self.narHash}"
       ];
     };
 
@@ -41,6 +46,8 @@
     vexd = pkgs.buildGoModule (commonAttrs // {
       pname = "vexd";
       subPackages = [ "cmd/vexd" ];
+      # Without cgo, so the Landlock sandbox can restrict every thread.
+      env = commonAttrs.env // { CGO_ENABLED = 0; };
       meta = {
         description = "VEX enforcement daemon (Protocol 106-V)";
         mainProgram = "vexd";
@@ -108,6 +115,12 @@
           description = "Path to the Ed25519 public key file for command authorization.";
         };
 
+        usageRulesFile = lib.mkOption {
+          type = lib.types.nullOr lib.types.path;
+          default = null;
+          description = "Path to usage-rules.json (automatic penalties for excessive screen time).";
+        };
+
         monitorMode = lib.mkOption {
           type = lib.types.enum [ "ebpf" "proc" "auto" ];
           default = "auto";
@@ -118,6 +131,36 @@
             - "auto": Try eBPF first, fallback to /proc if eBPF fails
           '';
         };
+
+        windowBackend = lib.mkOption {
+          type = lib.types.enum [ "auto" "hyprland" "sway" "x11" "off" ];
+          default = "auto";
+          description = ''
+            Source for active-window (per-app screen time) tracking:
+            - "auto": Detect from the graphical session's environment
+            - "hyprland" / "sway": Query the compositor via hyprctl / swaymsg
+            - "x11": Query the X server via xprop (EWMH)
+            - "off": Disable window tracking
+          '';
+        };
+
+        inputInclude = lib.mkOption {
+          type = lib.types.listOf lib.types.str;
+          default = [ ];
+          example = [ "/dev/input/by-id/usb-Keychron_K2-event-kbd" ];
+          description = ''
+            If non-empty, surveillance attaches only to input devices matching
+            one of these patterns: a /dev/input path (glob allowed), a USB
+            "vendor:product" ID in hex, or a case-insensitive name glob.
+          '';
+        };
+
+        inputExclude = lib.mkOption {
+          type = lib.types.listOf lib.types.str;
+          default = [ ];
+          example = [ "*macro pad*" "1d50:615e" ];
+          description = "Input devices surveillance must never attach to (same pattern syntax as inputInclude).";
+        };
       };
 
       config = lib.mkIf cfg.enable {
@@ -136,6 +179,12 @@
               mode = "0644";
             };
           })
+          (lib.mkIf (cfg.usageRulesFile != null) {
+            "vex-cli/usage-rules.json" = {
+              source = cfg.usageRulesFile;
+              mode = "0644";
+            };
+          })
           (lib.mkIf (cfg.managementKeyFile != null) {
             "vex-cli/vex_management_key.pub" = {
               source = cfg.managementKeyFile;
@@ -151,18 +200,26 @@
           after = [ "network-online.target" "systemd-resolved.service" ];
           wants = [ "network-online.target" ];
 
-          # Ensure Nix CLI tools and coreutils are in PATH for anti-tamper checks
-          path = with pkgs; [ nix coreutils systemd ];
+          # Ensure Nix CLI tools and coreutils are in PATH for anti-tamper checks.
+          # xprop and the system profile (hyprctl/swaymsg) serve window tracking.
+          path = with pkgs; [ nix coreutils systemd xorg.xprop "/run/current-system/sw" ];
 
           serviceConfig = {
             Type = "simple";
             ExecStart = "${cfg.daemonPackage}/bin/vexd";
+            ExecReload = "${pkgs.coreutils}/bin/kill -HUP $MAINPID";
             WorkingDirectory = "/etc/vex-cli";
             Restart = "always";
             RestartSec = 5;
             
             Environment = [
               "VEX_MONITOR_MODE=${cfg.monitorMode}"
+              "VEX_WINDOW_BACKEND=${cfg.windowBackend}"
+              "VEX_INPUT_INCLUDE=${lib.concatStringsSep "," cfg.inputInclude}"
+              "VEX_INPUT_EXCLUDE=${lib.concatStringsSep "," cfg.inputExclude}"
+              # The anti-tamper nix checks go through nix-daemon, as the
+              # sandbox keeps vexd from writing the store itself.
+              "NIX_REMOTE=daemon"
             ];
 
             # ── Root + capabilities ──────────────────────────────────
@@ -181,7 +238,7 @@
 
             # ── Hardening ────────────────────────────────────────────
             ProtectSystem = "full";
-            ProtectHome = true;
+            ProtectHome = "read-only";  # X11 window tracking reads ~/.Xauthority
             NoNewPrivileges = true;
             LockPersonality = true;
             ProtectClock = true;
@@ -200,7 +257,10 @@
             StateDirectory = "vex-cli";           # creates /var/lib/vex-cli
 
             SupplementaryGroups = [ "input" ];
-            DeviceAllow = [ "/dev/input/* rw" ];
+            DeviceAllow = [
+              "/dev/input/* rw"
+              "/dev/uinput rw"      # latency injection relay
+            ];
           };
         };
 
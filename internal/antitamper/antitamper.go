@@ -3,13 +3,19 @@ package antitamper
 import (
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/events"
 	"github.com/adumbdinosaur/vex-cli/internal/penance"
 	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
 	"github.com/adumbdinosaur/vex-cli/internal/throttler"
 )
 
@@ -45,6 +51,14 @@ var (
 	// MaxFailureScore caps the failure score to prevent runaway inflation.
 	MaxFailureScore = 500
 
+	// ClientHashes are the SHA-256 sums of the vex-cli builds the daemon
+	// accepts restriction-lowering commands from.  Empty trusts the
+	// vex-cli installed beside vexd.
+	ClientHashes []string
+
+	installedClient     string
+	installedClientOnce sync.Once
+
 	lastEscalation   time.Time
 	escalationMu     sync.Mutex
 )
@@ -60,7 +74,7 @@ func Init() error {
 	}
 
 	// Start periodic monitoring
-	go periodicMonitor()
+	supervisor.Go("antitamper.checks", periodicMonitor)
 
 	log.Println("Anti-Tamper: Monitoring active")
 	return nil
@@ -158,6 +172,50 @@ func verifyServiceIntegrity() error {
 	return nil
 }
 
+// installedClientHash hashes the vex-cli installed in the same directory
+// as the running daemon, once.  It is empty when there is none.
+func installedClientHash() string {
+	installedClientOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		path := filepath.Join(filepath.Dir(exe), "vex-cli")
+		hash, err := security.HashFile(path)
+		if err != nil {
+			log.Printf("Anti-Tamper: No installed vex-cli to trust at %s: %v", path, err)
+			return
+		}
+		installedClient = hash
+		log.Printf("Anti-Tamper: Trusting vex-cli %s (sha256 %s)", path, hash)
+	})
+	return installedClient
+}
+
+// KnownClients returns the vex-cli builds that are trusted: ClientHashes
+// if set, otherwise the installed one.  Empty means clients cannot be
+// verified at all.
+func KnownClients() []string {
+	if len(ClientHashes) > 0 {
+		return ClientHashes
+	}
+	if h := installedClientHash(); h != "" {
+		return []string{h}
+	}
+	return nil
+}
+
+// TrustedClient reports whether a client running the executable with the
+// given hash may lower restrictions.  With no known build there is
+// nothing to compare against and every client is trusted.
+func TrustedClient(hash string) bool {
+	known := KnownClients()
+	if len(known) == 0 || slices.ContainsFunc(known, func(k string) bool { return strings.EqualFold(k, hash) }) {
+		return true
+	}
+	return subsystem.Skip(subsystem.AntiTamper, "refuse a command from vex-cli build %s", hash)
+}
+
 // escalate triggers automatic escalation when tampering is detected.
 // It enforces a cooldown so that repeated periodic-check failures cannot
 // compound the score in an exponential loop, and caps the score to
@@ -167,6 +225,9 @@ func escalate(reasons []string) {
 	defer escalationMu.Unlock()
 
 	log.Printf("Anti-Tamper: ⚠️ ESCALATION TRIGGERED: %v", reasons)
+	if subsystem.Skip(subsystem.AntiTamper, "black-hole the network and double the failure score") {
+		return
+	}
 
 	// Cooldown: suppress score inflation if we already escalated recently.
 	if !lastEscalation.IsZero() && time.Since(lastEscalation) < EscalationCooldown {
@@ -200,18 +261,23 @@ func escalate(reasons []string) {
 	}
 	cs.Locked = true
 	cs.TaskStatus = "failed"
+	cs.CountFailure(penance.CategoryTamper, "escalation", cs.FailureScore-previousScore)
 
 	if err := penance.SaveComplianceStatus(cs); err != nil {
 		log.Printf("Anti-Tamper: Could not save escalated compliance: %v", err)
+	} else {
+		penance.RecordScoreChange(previousScore, cs.FailureScore, "escalation")
 	}
 
 	lastEscalation = time.Now()
 	log.Printf("Anti-Tamper: Failure score DOUBLED: %d -> %d (cap: %d)",
 		previousScore, cs.FailureScore, MaxFailureScore)
+	events.Publish(events.Escalation{Reasons: reasons, Score: cs.FailureScore})
 }
 
-// periodicMonitor runs integrity checks on a regular interval
-func periodicMonitor() {
+// periodicMonitor runs integrity checks on a regular interval.  It never
+// stops on its own; a panic in a check is left to the supervisor.
+func periodicMonitor() error {
 	ticker := time.NewTicker(CheckInterval)
 	defer ticker.Stop()
 
@@ -220,4 +286,5 @@ func periodicMonitor() {
 			log.Printf("Anti-Tamper: Periodic check failed: %v", err)
 		}
 	}
+	return nil
 }
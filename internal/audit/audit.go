@@ -0,0 +1,145 @@
+// Package audit exports the audit log as machine-readable records for the
+// keyholder to review offline.
+//
+// Every line of the log, rotated archives included, becomes one Record
+// classified by Kind: CLI commands and IPC requests, signature checks,
+// kills, state changes and other daemon events, and free-form log lines.
+// Nothing is dropped, so an export is a complete copy of the log in CSV
+// or JSON.
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+)
+
+// Kinds of record.
+const (
+	KindCommand       = "command"       // a CLI command or an IPC request
+	KindAuthorization = "authorization" // a signed command accepted or denied
+	KindKill          = "kill"          // the guardian killed a forbidden process
+	KindState         = "state"         // any other event logged by a subsystem
+	KindLog           = "log"           // a free-form log line
+)
+
+// Record is one audit log entry.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Module  string    `json:"module,omitempty"`
+	Event   string    `json:"event,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+// Collect reads the records logged at path in [from, to), oldest first.
+func Collect(path string, from, to time.Time) ([]Record, error) {
+	var out []Record
+	err := vexlog.ReadEntries(path, from, to, func(e vexlog.Entry) {
+		out = append(out, classify(e))
+	})
+	return out, err
+}
+
+// classify turns an entry into a record.  CLI command lines, written by
+// LogCommand as "CMD: name | ARGS: ... | COMPLIANCE: ... | TIME: ...",
+// are split into their fields.
+func classify(e vexlog.Entry) Record {
+	r := Record{Time: e.Time, Module: e.Module, Event: e.Event, Details: e.Details}
+	switch {
+	case e.Module == "":
+		if rest, ok := strings.CutPrefix(e.Text, "CMD: "); ok {
+			fields := strings.Split(rest, " | ")
+			r.Kind, r.Module, r.Event = KindCommand, "CLI", fields[0]
+			var details []string
+			for _, f := range fields[1:] {
+				if k, v, ok := strings.Cut(f, ": "); ok && k != "TIME" {
+					details = append(details, strings.ToLower(k)+"="+v)
+				}
+			}
+			r.Details = strings.Join(details, " ")
+			return r
+		}
+		r.Kind, r.Details = KindLog, e.Text
+		if strings.Contains(e.Text, "AUTHORIZATION DENIED") {
+			r.Kind = KindAuthorization
+		}
+	case e.Module == "IPC" && e.Event == "REQUEST":
+		r.Kind = KindCommand
+	case e.Module == "SECURITY":
+		r.Kind = KindAuthorization
+	case e.Module == "GUARDIAN" && e.Event == "KILLED":
+		r.Kind = KindKill
+	default:
+		r.Kind = KindState
+	}
+	return r
+}
+
+// WriteCSV writes records with a header row.  Times are RFC3339.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "kind", "module", "event", "details"})
+	for _, r := range records {
+		cw.Write([]string{r.Time.Format(time.RFC3339), r.Kind, r.Module, r.Event, r.Details})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes records as a JSON array, one record per line.
+func WriteJSON(w io.Writer, records []Record) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		sep := ",\n "
+		if i == 0 {
+			sep = "\n "
+		}
+		if _, err := io.WriteString(w, sep+string(line)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// Write writes records in format, "csv" or "json".
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case "csv":
+		return WriteCSV(w, records)
+	case "json":
+		return WriteJSON(w, records)
+	}
+	return fmt.Errorf("unknown format %q (use csv or json)", format)
+}
+
+// ParseSince reads a start time given as a date (2006-01-02, local
+// midnight), an RFC3339 time or a duration before now such as 72h.  An
+// empty string means the beginning of the log.
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use a date, an RFC3339 time or a duration)", s)
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLog(t *testing.T, start time.Time, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	var b strings.Builder
+	for i, text := range lines {
+		b.WriteString("[VEX-CLI] " + start.Add(time.Duration(i)*time.Minute).Format("2006/01/02 15:04:05") + " " + text + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCollect(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	path := writeLog(t, start,
+		"CMD: throttle | ARGS: choke | COMPLIANCE: score=0,status=pending,locked=true | TIME: 2026-03-01T12:00:00Z",
+		"[IPC] REQUEST: cmd=throttle args=map[profile:choke]",
+		"[SECURITY] AUTHORIZED: command=unlock args= timestamp=1772366520",
+		"AUTHORIZATION DENIED: payload was signed for 'pause', not 'unlock'",
+		"[GUARDIAN] KILLED: app=steam pid=42",
+		"[SYSTEM] UNLOCK: profile=standard",
+		"Guardian: eBPF monitor attached",
+	)
+
+	records, err := Collect(path, start.Add(time.Minute), start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make([]string, len(records))
+	for i, r := range records {
+		kinds[i] = r.Kind
+	}
+	want := []string{KindCommand, KindAuthorization, KindAuthorization, KindKill, KindState, KindLog}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected kinds %v, got %v", want, kinds)
+	}
+
+	all, _ := Collect(path, time.Time{}, start.Add(time.Hour))
+	cmd := all[0]
+	if cmd.Kind != KindCommand || cmd.Module != "CLI" || cmd.Event != "throttle" ||
+		cmd.Details != "args=choke compliance=score=0,status=pending,locked=true" {
+		t.Errorf("Unexpected CLI command record %+v", cmd)
+	}
+	if r := all[6]; r.Details != "Guardian: eBPF monitor attached" || r.Module != "" {
+		t.Errorf("Unexpected log record %+v", r)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	records := []Record{
+		{Time: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Kind: KindKill, Module: "GUARDIAN", Event: "KILLED", Details: "app=steam pid=42"},
+		{Time: time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC), Kind: KindLog, Details: `said "hi", then left`},
+	}
+
+	var b strings.Builder
+	if err := Write(&b, "csv", records); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV does not parse: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "time" || rows[1][0] != "2026-03-01T12:00:00Z" || rows[2][4] != `said "hi", then left` {
+		t.Errorf("Unexpected CSV rows %q", rows)
+	}
+
+	for _, recs := range [][]Record{records, nil} {
+		b.Reset()
+		if err := Write(&b, "json", recs); err != nil {
+			t.Fatal(err)
+		}
+		var got []Record
+		if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+			t.Fatalf("JSON does not parse: %v\n%s", err, b.String())
+		}
+		if len(got) != len(recs) {
+			t.Errorf("Expected %d records, got %d", len(recs), len(got))
+		}
+	}
+
+	if err := Write(&b, "xml", records); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"", time.Time{}, true},
+		{"2026-03-01", time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local), true},
+		{"2026-03-01T08:00:00Z", time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC), true},
+		{"72h", now.Add(-72 * time.Hour), true},
+		{"-1h", time.Time{}, false},
+		{"last week", time.Time{}, false},
+	} {
+		got, err := ParseSince(tc.in, now)
+		if (err == nil) != tc.ok || (tc.ok && !got.Equal(tc.want)) {
+			t.Errorf("%q: got %s, %v", tc.in, got, err)
+		}
+	}
+}
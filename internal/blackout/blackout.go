@@ -0,0 +1,223 @@
+// Package blackout makes the black-hole network profile total.  The
+// throttler shapes one interface, the uplink the default route goes
+// through, so a second NIC, a WWAN modem or a tethered phone would carry
+// on regardless.  While the profile is black-hole, the secondary uplinks
+// named in ConfigFile are cut as well: brought down, or, for a link that
+// has to stay up, such as a modem that should keep its registration,
+// filtered with a qdisc that drops every packet.  They are restored
+// together when the profile is lifted.
+//
+// The uplinks cut are kept in SavedFile, so that lifting the blackout
+// after a restart still restores them.  Module is the enforcement module
+// (see package modules) vexd registers when ConfigFile exists.
+package blackout
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/vishvananda/netlink"
+)
+
+// -- Interfaces for Testing --
+
+type NetlinkOps interface {
+	LinkList() ([]netlink.Link, error)
+	LinkSetUp(link netlink.Link) error
+	LinkSetDown(link netlink.Link) error
+	QdiscList(link netlink.Link) ([]netlink.Qdisc, error)
+	QdiscReplace(qdisc netlink.Qdisc) error
+	QdiscDel(qdisc netlink.Qdisc) error
+}
+
+type RealNetlinkOps struct{}
+
+func (r *RealNetlinkOps) LinkList() ([]netlink.Link, error) {
+	return netlink.LinkList()
+}
+func (r *RealNetlinkOps) LinkSetUp(link netlink.Link) error {
+	return netlink.LinkSetUp(link)
+}
+func (r *RealNetlinkOps) LinkSetDown(link netlink.Link) error {
+	return netlink.LinkSetDown(link)
+}
+func (r *RealNetlinkOps) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	return netlink.QdiscList(link)
+}
+func (r *RealNetlinkOps) QdiscReplace(qdisc netlink.Qdisc) error {
+	return netlink.QdiscReplace(qdisc)
+}
+func (r *RealNetlinkOps) QdiscDel(qdisc netlink.Qdisc) error {
+	return netlink.QdiscDel(qdisc)
+}
+
+var nlOps NetlinkOps = &RealNetlinkOps{}
+
+// SetOps replaces the netlink operations, for tests outside the package
+// (see package fakes).
+func SetOps(nl NetlinkOps) {
+	nlOps = nl
+}
+
+var (
+	// ConfigFile names the secondary uplinks.  Optional.
+	ConfigFile = "/etc/vex-cli/blackout.json"
+
+	// SavedFile keeps the uplinks cut, and how, by interface.
+	SavedFile = "/run/vex-cli/blackout-saved.json"
+)
+
+// Ways to cut an uplink.
+const (
+	ActionDown   = "down"   // set the link down
+	ActionFilter = "filter" // drop every packet it sends, leaving it up
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Uplinks []Uplink `json:"uplinks"`
+}
+
+// Uplink is one or more secondary uplinks and how to cut them.
+type Uplink struct {
+	Match  string `json:"match"`            // interface name or glob, e.g. "wwan*"
+	Action string `json:"action,omitempty"` // default down
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// blackout covers the throttled uplink only and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	for i, u := range c.Uplinks {
+		if _, err := filepath.Match(u.Match, ""); err != nil || u.Match == "" {
+			return nil, fmt.Errorf("%s: uplink %d has no valid match", ConfigFile, i+1)
+		}
+		switch u.Action {
+		case "":
+			c.Uplinks[i].Action = ActionDown
+		case ActionDown, ActionFilter:
+		default:
+			return nil, fmt.Errorf("%s: %s: action must be down or filter", ConfigFile, u.Match)
+		}
+	}
+	return &c, nil
+}
+
+// Action is how the uplink named iface is cut: that of the first entry
+// it matches, or "" if it is not a secondary uplink.
+func (c *Config) Action(iface string) string {
+	for _, u := range c.Uplinks {
+		if ok, _ := filepath.Match(u.Match, iface); ok {
+			return u.Action
+		}
+	}
+	return ""
+}
+
+// isUp reports whether link is administratively up.
+func isUp(link netlink.Link) bool {
+	return link.Attrs().Flags&net.FlagUp != 0
+}
+
+// dropQdisc is the root qdisc that filters link.
+func dropQdisc(link netlink.Link) netlink.Qdisc {
+	return netlink.NewNetem(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(0xdead, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	}, netlink.NetemQdiscAttrs{Loss: 100, Limit: 1})
+}
+
+// filtered returns the root qdisc dropQdisc added to link, if it is there.
+func filtered(link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := nlOps.QdiscList(link)
+	if err != nil {
+		return nil, err
+	}
+	handle := netlink.MakeHandle(0xdead, 0)
+	for _, q := range qdiscs {
+		if q.Attrs().Parent == netlink.HANDLE_ROOT && q.Attrs().Handle == handle && q.Type() == "netem" {
+			return q, nil
+		}
+	}
+	return nil, nil
+}
+
+// cut cuts link as action says.  It reports whether anything changed.
+func cut(link netlink.Link, action string) (bool, error) {
+	name := link.Attrs().Name
+	if action == ActionFilter {
+		if q, err := filtered(link); err != nil || q != nil {
+			return false, err
+		}
+		if err := nlOps.QdiscReplace(dropQdisc(link)); err != nil {
+			return false, fmt.Errorf("failed to filter %s: %w", name, err)
+		}
+		return true, nil
+	}
+	if !isUp(link) {
+		return false, nil
+	}
+	if err := nlOps.LinkSetDown(link); err != nil {
+		return false, fmt.Errorf("failed to bring %s down: %w", name, err)
+	}
+	return true, nil
+}
+
+// restore undoes cut.
+func restore(link netlink.Link, action string) error {
+	name := link.Attrs().Name
+	if action == ActionFilter {
+		q, err := filtered(link)
+		if err != nil || q == nil {
+			return err
+		}
+		if err := nlOps.QdiscDel(q); err != nil {
+			return fmt.Errorf("failed to unfilter %s: %w", name, err)
+		}
+		return nil
+	}
+	if err := nlOps.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring %s up: %w", name, err)
+	}
+	return nil
+}
+
+// loadSaved reads the uplinks cut, and how, by interface.
+func loadSaved() map[string]string {
+	saved := make(map[string]string)
+	if data, err := os.ReadFile(SavedFile); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	return saved
+}
+
+func storeSaved(saved map[string]string) error {
+	if len(saved) == 0 {
+		if err := os.Remove(SavedFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(SavedFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(SavedFile, data, 0o600)
+}
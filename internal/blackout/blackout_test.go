@@ -0,0 +1,123 @@
+package blackout
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// fakeNetlink keeps links and their root qdiscs in memory.
+type fakeNetlink struct {
+	links  []netlink.Link
+	qdiscs map[int][]netlink.Qdisc
+}
+
+func (f *fakeNetlink) LinkList() ([]netlink.Link, error) { return f.links, nil }
+func (f *fakeNetlink) LinkSetUp(link netlink.Link) error {
+	link.Attrs().Flags |= net.FlagUp
+	return nil
+}
+func (f *fakeNetlink) LinkSetDown(link netlink.Link) error {
+	link.Attrs().Flags &^= net.FlagUp
+	return nil
+}
+func (f *fakeNetlink) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	return f.qdiscs[link.Attrs().Index], nil
+}
+func (f *fakeNetlink) QdiscReplace(q netlink.Qdisc) error {
+	f.qdiscs[q.Attrs().LinkIndex] = []netlink.Qdisc{q}
+	return nil
+}
+func (f *fakeNetlink) QdiscDel(q netlink.Qdisc) error {
+	delete(f.qdiscs, q.Attrs().LinkIndex)
+	return nil
+}
+
+func link(index int, name string, up bool) netlink.Link {
+	attrs := netlink.LinkAttrs{Index: index, Name: name}
+	if up {
+		attrs.Flags = net.FlagUp
+	}
+	return &netlink.Dummy{LinkAttrs: attrs}
+}
+
+func TestLoadConfig(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "blackout.json")
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+	os.WriteFile(ConfigFile, []byte(`{"uplinks": [{"match": "wwan*", "action": "unplug"}]}`), 0o644)
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+	os.WriteFile(ConfigFile, []byte(`{"uplinks": [{"match": "wwan["}]}`), 0o644)
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected a malformed glob to be rejected")
+	}
+	os.WriteFile(ConfigFile, []byte(`{"uplinks": [{"match": "wwan*"}, {"match": "enp4s0", "action": "filter"}]}`), 0o644)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if c.Action("wwan0") != ActionDown || c.Action("enp4s0") != ActionFilter || c.Action("enp3s0") != "" {
+		t.Errorf("Got actions %q/%q/%q", c.Action("wwan0"), c.Action("enp4s0"), c.Action("enp3s0"))
+	}
+}
+
+func TestModule(t *testing.T) {
+	SavedFile = filepath.Join(t.TempDir(), "blackout-saved.json")
+	wwan0, wwan1, enp4s0, eth0 := link(2, "wwan0", true), link(3, "wwan1", false), link(4, "enp4s0", true), link(5, "eth0", true)
+	nl := &fakeNetlink{links: []netlink.Link{wwan0, wwan1, enp4s0, eth0}, qdiscs: make(map[int][]netlink.Qdisc)}
+	old := nlOps
+	nlOps = nl
+	t.Cleanup(func() { nlOps = old })
+	throttler.MoveInterface("eth0")
+
+	m := NewModule(&Config{Uplinks: []Uplink{
+		{Match: "wwan*", Action: ActionDown},
+		{Match: "enp4s0", Action: ActionFilter},
+		{Match: "eth*", Action: ActionDown},
+	}})
+	st := &state.SystemState{Network: state.NetworkState{Profile: string(throttler.ProfileBlackHole)}}
+	if err := m.Verify(st); err == nil {
+		t.Error("Expected Verify to report the uplinks not yet cut")
+	}
+	if err := m.Apply(st); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if isUp(wwan0) || len(nl.qdiscs[4]) != 1 {
+		t.Error("Expected wwan0 down and enp4s0 filtered")
+	}
+	if !isUp(eth0) {
+		t.Error("Expected the throttled uplink to be left alone")
+	}
+	if err := m.Verify(st); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// Brought back behind the module's back, then cut again.
+	nl.LinkSetUp(wwan0)
+	if err := m.enforce(); err != nil || isUp(wwan0) {
+		t.Errorf("Expected wwan0 cut again, got %v", err)
+	}
+
+	st.Network.Profile = string(throttler.ProfileStandard)
+	if err := m.Apply(st); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !isUp(wwan0) || len(nl.qdiscs[4]) != 0 {
+		t.Error("Expected wwan0 up and enp4s0 unfiltered")
+	}
+	if isUp(wwan1) {
+		t.Error("Expected wwan1, down before the blackout, to stay down")
+	}
+	if _, err := os.Stat(SavedFile); !os.IsNotExist(err) {
+		t.Error("Expected the saved uplinks to be removed")
+	}
+}
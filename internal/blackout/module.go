@@ -0,0 +1,183 @@
+package blackout
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// Interval is how often Module cuts an uplink again that was brought back
+// behind its back, e.g. by NetworkManager, or that appeared since, such as
+// a modem plugged in during the blackout.
+var Interval = 10 * time.Second
+
+// Module cuts the secondary uplinks while the network profile is
+// black-hole.  It is part of the throttler subsystem.
+type Module struct {
+	cfg *Config
+
+	mu      sync.Mutex
+	on      bool
+	lastErr string
+}
+
+// NewModule returns a Module for c that cuts nothing yet.
+func NewModule(c *Config) *Module {
+	return &Module{cfg: c}
+}
+
+func (m *Module) Name() string { return "blackout" }
+
+// Init starts the worker that keeps the uplinks cut.
+func (m *Module) Init() error {
+	supervisor.Go("blackout.watch", m.watch)
+	return nil
+}
+
+// Apply cuts the secondary uplinks if st calls for a blackout, and
+// restores them otherwise or while enforcement is paused.
+func (m *Module) Apply(st *state.SystemState) error {
+	on := Due(st)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if on && subsystem.Skip(subsystem.Throttler, "cut the secondary uplinks") {
+		return nil
+	}
+	m.on = on
+	return m.enforce()
+}
+
+// Verify reports a secondary uplink that is not cut during a blackout.
+func (m *Module) Verify(st *state.SystemState) error {
+	if !Due(st) {
+		return nil
+	}
+	links, err := nlOps.LinkList()
+	if err != nil {
+		return err
+	}
+	primary := throttler.Interface()
+	var errs []error
+	for _, link := range links {
+		name := link.Attrs().Name
+		action := m.cfg.Action(name)
+		if name == primary || action == "" {
+			continue
+		}
+		if action == ActionDown && isUp(link) {
+			errs = append(errs, fmt.Errorf("uplink %s is up during the blackout", name))
+		}
+		if action == ActionFilter {
+			if q, err := filtered(link); err == nil && q == nil {
+				errs = append(errs, fmt.Errorf("uplink %s is not filtered during the blackout", name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown restores the uplinks.
+func (m *Module) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.on = false
+	return m.enforce()
+}
+
+// Due reports whether st calls for a blackout.
+func Due(st *state.SystemState) bool {
+	return st.Network.Profile == string(throttler.ProfileBlackHole) && st.Pause == nil
+}
+
+// watch enforces the blackout every Interval, logging an error only when
+// it differs from the last one.
+func (m *Module) watch() error {
+	for range time.Tick(Interval) {
+		if subsystem.Get(subsystem.Throttler) != subsystem.Enforce {
+			continue
+		}
+		m.mu.Lock()
+		err := m.enforce()
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+			if msg != m.lastErr {
+				log.Printf("Blackout: %v", err)
+			}
+		}
+		m.lastErr = msg
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// enforce cuts every secondary uplink while m.on, and otherwise restores
+// those it cut.  An uplink that was already down when the blackout began
+// is left down.  The throttled uplink is never cut, even if it matches:
+// the black-hole profile has it.  The caller holds m.mu.
+func (m *Module) enforce() error {
+	saved := loadSaved()
+	if !m.on && len(saved) == 0 {
+		return nil
+	}
+	links, err := nlOps.LinkList()
+	if err != nil {
+		return err
+	}
+	primary := throttler.Interface()
+	var errs []error
+	present := make(map[string]bool)
+	for _, link := range links {
+		name := link.Attrs().Name
+		present[name] = true
+		if !m.on {
+			action, ok := saved[name]
+			if !ok {
+				continue
+			}
+			if err := restore(link, action); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(saved, name)
+			vexlog.LogEvent("THROTTLER", "UPLINK_RESTORED", fmt.Sprintf("iface=%s action=%s", name, action))
+			continue
+		}
+		action := m.cfg.Action(name)
+		if name == primary || action == "" {
+			continue
+		}
+		changed, err := cut(link, action)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if _, ok := saved[name]; !ok {
+			saved[name] = action
+		}
+		vexlog.LogEvent("THROTTLER", "UPLINK_CUT", fmt.Sprintf("iface=%s action=%s", name, action))
+	}
+	if !m.on {
+		// Those unplugged since have nothing to restore.
+		for name := range saved {
+			if !present[name] {
+				delete(saved, name)
+			}
+		}
+	}
+	if err := storeSaved(saved); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
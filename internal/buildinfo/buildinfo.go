@@ -0,0 +1,70 @@
+// Package buildinfo identifies the build of vexd or vex-cli that is
+// running, so that the two can tell when they were built apart.
+//
+// Version, Commit and BuildHash are set at link time:
+//
+//	go build -ldflags "-X github.com/adumbdinosaur/vex-cli/internal/buildinfo.Version=2.0-V \
+//	  -X github.com/adumbdinosaur/vex-cli/internal/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/...
+//
+// A plain go build inside the git checkout still records the commit, and
+// Get falls back to it.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildHash = "" // e.g. the Nix output hash
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+	BuildHash string `json:"build_hash,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build's Info.
+func Get() Info {
+	i := Info{Version: Version, Commit: Commit, BuildHash: BuildHash, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok && i.Commit == "" {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				i.Commit = s.Value
+			case "vcs.modified":
+				i.Modified = s.Value == "true"
+			}
+		}
+	}
+	return i
+}
+
+// ID is the version and short commit, e.g. "2.0-V+3f2c1ab", which two
+// builds share only if they were built from the same source.
+func (i Info) ID() string {
+	id := i.Version
+	if i.Commit != "" {
+		id += "+" + i.Commit[:min(7, len(i.Commit))]
+	}
+	if i.Modified {
+		id += "-dirty"
+	}
+	return id
+}
+
+func (i Info) String() string {
+	s := fmt.Sprintf("%s (%s", i.ID(), i.GoVersion)
+	if i.BuildHash != "" {
+		s += ", build " + i.BuildHash
+	}
+	return s + ")"
+}
@@ -0,0 +1,28 @@
+package buildinfo
+
+import "testing"
+
+func TestID(t *testing.T) {
+	for _, tc := range []struct {
+		info Info
+		want string
+	}{
+		{Info{Version: "dev"}, "dev"},
+		{Info{Version: "2.0-V", Commit: "3f2c1ab9d0e4"}, "2.0-V+3f2c1ab"},
+		{Info{Version: "2.0-V", Commit: "3f2c", Modified: true}, "2.0-V+3f2c-dirty"},
+	} {
+		if got := tc.info.ID(); got != tc.want {
+			t.Errorf("ID of %+v = %q, expected %q", tc.info, got, tc.want)
+		}
+	}
+}
+
+func TestGetUsesLinkerValues(t *testing.T) {
+	Version, Commit, BuildHash = "2.0-V", "3f2c1ab9d0e4", "sha256-abc"
+	defer func() { Version, Commit, BuildHash = "dev", "", "" }()
+
+	i := Get()
+	if i.ID() != "2.0-V+3f2c1ab" || i.BuildHash != "sha256-abc" || i.GoVersion == "" {
+		t.Errorf("Unexpected build info %+v", i)
+	}
+}
@@ -0,0 +1,281 @@
+// Package calendar lets a shared iCalendar (ICS) feed drive enforcement.
+//
+// vexd fetches the feed on an interval and maps event titles to presets
+// — named bundles of restrictions such as a network profile, a CPU cap,
+// blocked domains and forbidden apps.  A preset is in force while any
+// event mapped to it is in progress, so the keyholder can schedule a
+// "Focus block" or an "Exam week" from any calendar app.  The last good
+// copy of the feed is cached on disk and used while the source is
+// unreachable.
+package calendar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+var (
+	// ConfigFile holds the feed URL, presets and event mappings.
+	ConfigFile = "/etc/vex-cli/calendar.json"
+
+	// CacheFile holds the last feed fetched successfully.
+	CacheFile = "/var/lib/vex-cli/calendar.ics"
+
+	// DefaultRefresh is used when the config does not set refresh_minutes.
+	DefaultRefresh = 15 * time.Minute
+
+	// FetchTimeout bounds a single HTTP fetch.
+	FetchTimeout = 30 * time.Second
+)
+
+// Preset is a bundle of restrictions imposed while a mapped event runs.
+type Preset struct {
+	Profile  string   `json:"profile,omitempty"`   // network profile (only ever raised)
+	CPULimit int      `json:"cpu_limit,omitempty"` // CPU cap in percent (only ever lowered)
+	Block    []string `json:"block,omitempty"`     // domains to block
+	Apps     []string `json:"apps,omitempty"`      // apps to forbid
+}
+
+// Validate checks the profile name and the CPU cap.
+func (p Preset) Validate() error {
+	if p.Profile != "" {
+		if _, err := throttler.ResolveProfile(p.Profile); err != nil {
+			return err
+		}
+	}
+	if p.CPULimit < 0 || p.CPULimit > 100 {
+		return fmt.Errorf("cpu_limit must be 1-100")
+	}
+	return nil
+}
+
+// Mapping selects events by title.  Match is a case-insensitive glob
+// ("exam*") against the event SUMMARY; the first matching mapping wins.
+type Mapping struct {
+	Match  string `json:"match"`
+	Preset string `json:"preset"`
+}
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	URL            string            `json:"url"` // http(s)://, webcal:// or a local path
+	RefreshMinutes int               `json:"refresh_minutes,omitempty"`
+	Presets        map[string]Preset `json:"presets"`
+	Events         []Mapping         `json:"events"`
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// calendar is not configured and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the feed URL, every preset and that every mapping
+// names a defined preset.
+func (c *Config) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+	for name, p := range c.Presets {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("preset %q: %w", name, err)
+		}
+	}
+	for _, m := range c.Events {
+		if _, err := path.Match(m.Match, ""); err != nil {
+			return fmt.Errorf("event match %q: %w", m.Match, err)
+		}
+		if _, ok := c.Presets[m.Preset]; !ok {
+			return fmt.Errorf("event match %q: unknown preset %q", m.Match, m.Preset)
+		}
+	}
+	return nil
+}
+
+// PresetFor returns the preset an event title maps to, or "".
+func (c *Config) PresetFor(summary string) string {
+	summary = strings.ToLower(strings.TrimSpace(summary))
+	for _, m := range c.Events {
+		if ok, _ := path.Match(strings.ToLower(m.Match), summary); ok {
+			return m.Preset
+		}
+	}
+	return ""
+}
+
+func (c *Config) refresh() time.Duration {
+	if c.RefreshMinutes > 0 {
+		return time.Duration(c.RefreshMinutes) * time.Minute
+	}
+	return DefaultRefresh
+}
+
+// -- Feed --
+
+// Occurrence is one run of a mapped event.
+type Occurrence struct {
+	Summary string
+	Preset  string
+	Start   time.Time
+	End     time.Time
+}
+
+type mappedEvent struct {
+	Event
+	preset string
+}
+
+var (
+	mu     sync.Mutex
+	events []mappedEvent
+)
+
+// Load replaces the current events with those parsed from an ICS feed,
+// keeping only events that map to a preset.  It returns how many did.
+func Load(c *Config, feed []byte) (int, error) {
+	parsed, err := Parse(bytes.NewReader(feed))
+	if err != nil {
+		return 0, err
+	}
+	var mapped []mappedEvent
+	for _, e := range parsed {
+		if p := c.PresetFor(e.Summary); p != "" {
+			mapped = append(mapped, mappedEvent{Event: e, preset: p})
+		}
+	}
+	mu.Lock()
+	events = mapped
+	mu.Unlock()
+	return len(mapped), nil
+}
+
+// PresetActive reports whether an event mapped to the preset is in
+// progress at now.
+func PresetActive(preset string, now time.Time) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range events {
+		if e.preset == preset && e.OccursAt(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Upcoming returns the occurrences of mapped events that overlap
+// [from, from+window), sorted by start.
+func Upcoming(from time.Time, window time.Duration) []Occurrence {
+	mu.Lock()
+	defer mu.Unlock()
+	var out []Occurrence
+	for _, e := range events {
+		e.Between(from, from.Add(window), func(start, end time.Time) {
+			out = append(out, Occurrence{Summary: e.Summary, Preset: e.preset, Start: start, End: end})
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// Start loads the cached feed, then fetches the live one now and every
+// refresh interval.  onFetch is called after every attempt with its
+// result; the previous events stay in use when a fetch fails.
+func Start(c *Config, onFetch func(err error)) {
+	if data, err := os.ReadFile(CacheFile); err == nil {
+		if n, err := Load(c, data); err != nil {
+			log.Printf("Calendar: ignoring cached feed: %v", err)
+		} else {
+			log.Printf("Calendar: restored %d mapped events from cache", n)
+		}
+	}
+
+	supervisor.Go("calendar", func() error {
+		ticker := time.NewTicker(c.refresh())
+		defer ticker.Stop()
+		for {
+			err := refresh(c)
+			if err != nil {
+				log.Printf("Calendar: fetch failed: %v", err)
+			}
+			onFetch(err)
+			<-ticker.C
+		}
+	})
+}
+
+func refresh(c *Config) error {
+	data, err := fetch(c.URL)
+	if err != nil {
+		return err
+	}
+	n, err := Load(c, data)
+	if err != nil {
+		return fmt.Errorf("invalid feed: %w", err)
+	}
+	log.Printf("Calendar: loaded %d mapped events", n)
+	if err := writeFileAtomic(CacheFile, data); err != nil {
+		log.Printf("Calendar: failed to cache feed: %v", err)
+	}
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(url, "webcal://"); ok {
+		url = "https://" + rest
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return os.ReadFile(strings.TrimPrefix(url, "file://"))
+	}
+
+	client := &http.Client{Timeout: FetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+}
+
+// writeFileAtomic writes via a temp file and rename so a crash mid-write
+// never leaves a truncated cache.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,144 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const feed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:focus@example\r\n" +
+	"SUMMARY:Focus block\r\n" +
+	"DTSTART:20240101T090000\r\n" +
+	"DTEND:20240101T120000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=5\r\n" +
+	"EXDATE:20240103T090000\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:focus@example\r\n" +
+	"RECURRENCE-ID:20240108T090000\r\n" +
+	"SUMMARY:Focus block\r\n" +
+	"DTSTART:20240108T130000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:exam@example\r\n" +
+	"SUMMARY:Exam week\\, spring\r\n" +
+	"DTSTART;VALUE=DATE:20240115\r\n" +
+	"DTEND;VALUE=DATE:20240120\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:lunch@example\r\n" +
+	"SUMMARY:Lunch with a very long title that is folded\r\n" +
+	"  across two lines\r\n" +
+	"DTSTART:20240101T120000Z\r\n" +
+	"DTEND:20240101T130000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+}
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	if events[2].Summary != "Exam week, spring" {
+		t.Errorf("Unescaped summary = %q", events[2].Summary)
+	}
+	if !events[2].End.Equal(at(20, 0, 0)) {
+		t.Errorf("All-day end = %s", events[2].End)
+	}
+	if !strings.HasSuffix(events[3].Summary, "folded across two lines") {
+		t.Errorf("Unfolded summary = %q", events[3].Summary)
+	}
+	if got := events[1].End.Sub(events[1].Start); got != time.Hour {
+		t.Errorf("DURATION gave %s, want 1h", got)
+	}
+}
+
+func TestOccursAt_Recurring(t *testing.T) {
+	events, err := Parse(strings.NewReader(feed))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	series, override := events[0], events[1]
+
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(1, 8, 59), false},
+		{at(1, 9, 0), true},   // Monday
+		{at(1, 12, 0), false}, // end is exclusive
+		{at(2, 10, 0), false}, // Tuesday
+		{at(3, 10, 0), false}, // Wednesday, excluded
+		{at(8, 10, 0), false}, // moved by the override
+		{at(10, 10, 0), true}, // Wednesday, 4th occurrence
+		{at(15, 10, 0), true}, // Monday, 5th (EXDATEs still count)
+		{at(17, 10, 0), false},
+	}
+	for _, c := range cases {
+		if got := series.OccursAt(c.t); got != c.want {
+			t.Errorf("OccursAt(%s) = %v, want %v", c.t.Format("Mon 02 15:04"), got, c.want)
+		}
+	}
+	if !override.OccursAt(at(8, 13, 30)) {
+		t.Error("Expected the override to occur at its new time")
+	}
+}
+
+func TestParseRule_Unsupported(t *testing.T) {
+	ics := "BEGIN:VEVENT\nSUMMARY:x\nDTSTART:20240101T090000\nDTEND:20240101T100000\nRRULE:FREQ=HOURLY\nEND:VEVENT\n"
+	events, err := Parse(strings.NewReader(ics))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected one event and no error, got %d, %v", len(events), err)
+	}
+	if events[0].OccursAt(at(1, 10, 30)) {
+		t.Error("Unsupported rule should be treated as a single event")
+	}
+}
+
+func TestPresetMapping(t *testing.T) {
+	c := &Config{
+		URL: "/tmp/feed.ics",
+		Presets: map[string]Preset{
+			"focus": {Profile: "choke", Block: []string{"reddit.com"}},
+			"exam":  {Profile: "black-hole", CPULimit: 50},
+		},
+		Events: []Mapping{
+			{Match: "focus*", Preset: "focus"},
+			{Match: "Exam week*", Preset: "exam"},
+		},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if n, err := Load(c, []byte(feed)); err != nil || n != 3 {
+		t.Fatalf("Load: %d events, %v", n, err)
+	}
+
+	if !PresetActive("focus", at(10, 9, 30)) || PresetActive("exam", at(10, 9, 30)) {
+		t.Error("Expected only focus on Wednesday the 10th")
+	}
+	if !PresetActive("exam", at(16, 23, 0)) {
+		t.Error("Expected exam preset during exam week")
+	}
+
+	up := Upcoming(at(8, 0, 0), 8*24*time.Hour)
+	if len(up) != 4 || up[0].Preset != "focus" || !up[0].Start.Equal(at(8, 13, 0)) || up[2].Preset != "exam" {
+		t.Errorf("Unexpected upcoming occurrences: %+v", up)
+	}
+
+	c.Events = append(c.Events, Mapping{Match: "x", Preset: "missing"})
+	if err := c.Validate(); err == nil {
+		t.Error("Expected an unknown preset to be rejected")
+	}
+}
@@ -0,0 +1,387 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------
+// ICS Parsing
+// ---------------------------------------------------------------------
+//
+// Only what is needed to answer "which events are on at time t" is
+// understood: SUMMARY, DTSTART/DTEND/DURATION, RRULE (DAILY, WEEKLY with
+// BYDAY, MONTHLY and YEARLY on the start date), EXDATE, and RECURRENCE-ID
+// overrides.  Anything else is ignored.
+
+// maxExpand bounds how many occurrences of a rule are walked.
+const maxExpand = 20000
+
+// Event is one VEVENT.  Recurring events keep their rule and are expanded
+// on demand.
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+
+	rule         *rrule
+	exdates      []time.Time
+	recurrenceID time.Time
+}
+
+type rrule struct {
+	freq     string // DAILY, WEEKLY, MONTHLY or YEARLY
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+// Parse reads the VEVENTs of an iCalendar stream.  An override instance
+// (RECURRENCE-ID) replaces that occurrence of its series.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var cur *Event
+	var duration time.Duration
+	var allDay bool
+	for _, line := range lines {
+		name, params, value := splitProperty(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			cur, duration, allDay = &Event{}, 0, false
+			continue
+		case name == "END" && value == "VEVENT":
+			if cur == nil {
+				continue
+			}
+			if cur.End.IsZero() {
+				switch {
+				case duration > 0:
+					cur.End = cur.Start.Add(duration)
+				case allDay:
+					cur.End = cur.Start.AddDate(0, 0, 1)
+				}
+			}
+			if !cur.Start.IsZero() && cur.End.After(cur.Start) {
+				events = append(events, *cur)
+			}
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			cur.UID = value
+		case "SUMMARY":
+			cur.Summary = unescapeText(value)
+		case "DTSTART":
+			if cur.Start, err = parseTime(value, params); err != nil {
+				return nil, err
+			}
+			allDay = params["VALUE"] == "DATE" || len(value) == 8
+		case "DTEND":
+			if cur.End, err = parseTime(value, params); err != nil {
+				return nil, err
+			}
+		case "DURATION":
+			if duration, err = parseDuration(value); err != nil {
+				return nil, err
+			}
+		case "RRULE":
+			rule, err := parseRule(value)
+			if err != nil {
+				// One odd event must not take the whole feed down.
+				log.Printf("Calendar: %s: %v (treated as a single event)", cur.UID, err)
+			}
+			cur.rule = rule
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, err := parseTime(v, params)
+				if err != nil {
+					return nil, err
+				}
+				cur.exdates = append(cur.exdates, t)
+			}
+		case "RECURRENCE-ID":
+			if cur.recurrenceID, err = parseTime(value, params); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Overrides replace the occurrence they name.
+	for _, o := range events {
+		if o.recurrenceID.IsZero() {
+			continue
+		}
+		for i := range events {
+			if events[i].UID == o.UID && events[i].rule != nil {
+				events[i].exdates = append(events[i].exdates, o.recurrenceID)
+			}
+		}
+	}
+	return events, nil
+}
+
+// unfold joins continuation lines (RFC 5545 §3.1).
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, sc.Err()
+}
+
+// splitProperty splits `NAME;P1=a;P2="b:c":VALUE`.
+func splitProperty(line string) (string, map[string]string, string) {
+	inQuote := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuote = !inQuote
+		} else if c == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// parseTime handles UTC ("...Z"), TZID-qualified, floating (local) and
+// all-day (DATE) values.  An unknown TZID falls back to local time.
+func parseTime(value string, params map[string]string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		return time.ParseInLocation("20060102", value, time.Local)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	loc := time.Local
+	if tz := params["TZID"]; tz != "" {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// parseDuration parses an ISO 8601 duration such as PT1H30M or P1W.
+func parseDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range s {
+		switch {
+		case c == 'T':
+			inTime = true
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			n, err := strconv.Atoi(num)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			num = ""
+			switch {
+			case c == 'W':
+				d += time.Duration(n) * 7 * 24 * time.Hour
+			case c == 'D':
+				d += time.Duration(n) * 24 * time.Hour
+			case c == 'H' && inTime:
+				d += time.Duration(n) * time.Hour
+			case c == 'M' && inTime:
+				d += time.Duration(n) * time.Minute
+			case c == 'S' && inTime:
+				d += time.Duration(n) * time.Second
+			default:
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+		}
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+var icsDays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRule(value string) (*rrule, error) {
+	r := &rrule{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(k) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid RRULE interval %q", v)
+			}
+			r.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid RRULE count %q", v)
+			}
+			r.count = n
+		case "UNTIL":
+			t, err := parseTime(v, nil)
+			if err != nil {
+				return nil, err
+			}
+			r.until = t
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				d = strings.TrimLeft(d, "+-0123456789") // ordinals only matter for MONTHLY
+				wd, ok := icsDays[strings.ToUpper(d)]
+				if !ok {
+					return nil, fmt.Errorf("invalid RRULE day %q", d)
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		}
+	}
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	default:
+		return nil, fmt.Errorf("unsupported RRULE frequency %q", r.freq)
+	}
+	return r, nil
+}
+
+// each calls fn with the start of every occurrence, in order, until fn
+// returns false or the series ends.
+func (e Event) each(fn func(start time.Time) bool) {
+	if e.rule == nil {
+		fn(e.Start)
+		return
+	}
+	r := e.rule
+	emitted := 0
+	emit := func(t time.Time) bool {
+		if t.Before(e.Start) {
+			return true
+		}
+		if !r.until.IsZero() && t.After(r.until) {
+			return false
+		}
+		if r.count > 0 && emitted >= r.count {
+			return false
+		}
+		emitted++
+		for _, ex := range e.exdates {
+			if ex.Equal(t) {
+				return true
+			}
+		}
+		return fn(t)
+	}
+
+	s := e.Start
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, s.Hour(), s.Minute(), s.Second(), 0, s.Location())
+	}
+	for i := 0; i < maxExpand; i++ {
+		switch r.freq {
+		case "DAILY":
+			if !emit(at(s.Year(), s.Month(), s.Day()+i*r.interval)) {
+				return
+			}
+		case "WEEKLY":
+			days := r.byDay
+			if len(days) == 0 {
+				days = []time.Weekday{s.Weekday()}
+			}
+			offsets := make([]int, 0, len(days))
+			for _, d := range days {
+				offsets = append(offsets, (int(d)+6)%7) // Monday-based, WKST=MO
+			}
+			sort.Ints(offsets)
+			monday := s.Day() - (int(s.Weekday())+6)%7 + i*7*r.interval
+			for _, off := range offsets {
+				if !emit(at(s.Year(), s.Month(), monday+off)) {
+					return
+				}
+			}
+		case "MONTHLY":
+			t := at(s.Year(), s.Month()+time.Month(i*r.interval), s.Day())
+			if t.Day() == s.Day() && !emit(t) {
+				return
+			}
+		case "YEARLY":
+			t := at(s.Year()+i*r.interval, s.Month(), s.Day())
+			if t.Day() == s.Day() && !emit(t) {
+				return
+			}
+		}
+	}
+}
+
+// OccursAt reports whether an occurrence of the event covers t.
+func (e Event) OccursAt(t time.Time) bool {
+	found := false
+	e.Between(t, t.Add(time.Nanosecond), func(time.Time, time.Time) { found = true })
+	return found
+}
+
+// Between calls fn for every occurrence that overlaps [from, to).
+func (e Event) Between(from, to time.Time, fn func(start, end time.Time)) {
+	length := e.End.Sub(e.Start)
+	e.each(func(start time.Time) bool {
+		if !start.Before(to) {
+			return false
+		}
+		if end := start.Add(length); end.After(from) {
+			fn(start, end)
+		}
+		return true
+	})
+}
@@ -0,0 +1,384 @@
+// Package chatops turns chat messages into daemon commands for the chat
+// bridges (Discord, Matrix).
+//
+// A message addressed to the bot ("!vex block add reddit.com") is parsed
+// into the same IPC request the CLI would send and run through the
+// daemon's handlers.  Each bridge decides who may run which command.
+// Commands that lower restrictions additionally need a payload signed by
+// the management key, exactly as on the command line, and each payload
+// is accepted only once, since chat history is visible to everyone in the
+// room.  The payloads accepted are recorded in UsedFile, so that one is
+// not accepted again after a restart or in another bridge's room.
+package chatops
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+)
+
+// DefaultPrefix introduces a command in a chat message.
+const DefaultPrefix = "!vex"
+
+// verify checks signed payloads; replaced in tests.
+var verify = security.VerifyCommand
+
+// UsedFile keeps the signatures of the payloads the bridges accepted.
+var UsedFile = "/var/lib/vex-cli/chatops-used.json"
+
+var (
+	usedMu sync.Mutex
+	used   map[string]bool // signatures already accepted; read from UsedFile on first use
+)
+
+// Dispatcher runs a request through the daemon's handlers.
+type Dispatcher func(req *ipc.Request) *ipc.Response
+
+// Bridge parses and runs chat commands.
+type Bridge struct {
+	Prefix   string
+	Dispatch Dispatcher
+}
+
+// NewBridge returns a bridge for messages starting with prefix ("" for
+// DefaultPrefix).
+func NewBridge(prefix string, d Dispatcher) *Bridge {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	return &Bridge{Prefix: prefix, Dispatch: d}
+}
+
+// command describes one chat command.  build turns the words after the
+// command name into a request; signed commands receive the verified
+// payload instead.
+type command struct {
+	usage  string
+	signed bool
+	build  func(args []string, signed *security.SignedCommand) (*ipc.Request, error)
+}
+
+var commands = map[string]command{
+	"status": {"status", false, func([]string, *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdStatus}, nil
+	}},
+	"throttle": {"throttle <profile> [--for <duration>]", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		a, period := takeFor(a)
+		if len(a) != 1 {
+			return nil, errUsage
+		}
+		return &ipc.Request{Command: ipc.CmdThrottle, Args: withFor(map[string]string{"profile": a[0]}, period)}, nil
+	}},
+	"cpu": {"cpu <percent> [--for <duration>]", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		a, period := takeFor(a)
+		if len(a) != 1 {
+			return nil, errUsage
+		}
+		return &ipc.Request{Command: ipc.CmdCPU, Args: withFor(map[string]string{"percent": a[0]}, period)}, nil
+	}},
+	"block": {"block [add <domain> [--for <duration>] | rm <domain>]", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		a, period := takeFor(a)
+		switch {
+		case len(a) == 0:
+			return &ipc.Request{Command: ipc.CmdBlockList}, nil
+		case len(a) == 2 && a[0] == "add":
+			return &ipc.Request{Command: ipc.CmdBlockAdd, Args: withFor(map[string]string{"domain": a[1]}, period)}, nil
+		case len(a) == 2 && a[0] == "rm" && period == "":
+			return &ipc.Request{Command: ipc.CmdBlockRemove, Args: map[string]string{"domain": a[1]}}, nil
+		}
+		return nil, errUsage
+	}},
+	"app": {"app [add <name> | rm <name>]", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		switch {
+		case len(a) == 0:
+			return &ipc.Request{Command: ipc.CmdAppList}, nil
+		case len(a) == 2 && a[0] == "add":
+			return &ipc.Request{Command: ipc.CmdAppAdd, Args: map[string]string{"app": a[1]}}, nil
+		case len(a) == 2 && a[0] == "rm":
+			return &ipc.Request{Command: ipc.CmdAppRemove, Args: map[string]string{"app": a[1]}}, nil
+		}
+		return nil, errUsage
+	}},
+	"inputlock": {"inputlock <duration>", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		if len(a) != 1 {
+			return nil, errUsage
+		}
+		return &ipc.Request{Command: ipc.CmdInputLock, Args: map[string]string{"duration": a[0]}}, nil
+	}},
+	"freeze": {"freeze <duration>", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		if len(a) != 1 {
+			return nil, errUsage
+		}
+		return &ipc.Request{Command: ipc.CmdFreeze, Args: map[string]string{"duration": a[0]}}, nil
+	}},
+	"lockuntil": {"lockuntil <RFC3339|duration>", false, func(a []string, _ *security.SignedCommand) (*ipc.Request, error) {
+		if len(a) != 1 {
+			return nil, errUsage
+		}
+		return &ipc.Request{Command: ipc.CmdLockUntil, Args: map[string]string{"until": a[0]}}, nil
+	}},
+	"requests": {"requests", false, func([]string, *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdExceptionList}, nil
+	}},
+	"resume": {"resume", false, func([]string, *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdResume}, nil
+	}},
+
+	// Restriction-lowering: the payload decides the arguments.
+	"unlock": {"unlock <signed_json>", true, func(_ []string, s *security.SignedCommand) (*ipc.Request, error) {
+		req := &ipc.Request{Command: ipc.CmdUnlock}
+		if s.Args != "" {
+			req.Args = map[string]string{"until": s.Args}
+		}
+		return req, nil
+	}},
+	"reset-score": {"reset-score <signed_json>", true, func([]string, *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdResetScore}, nil
+	}},
+	"early-release": {"early-release <signed_json>", true, func([]string, *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdEarlyRelease}, nil
+	}},
+	"pause": {"pause <signed_json>", true, func(_ []string, s *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdPause, Args: map[string]string{"until": s.Args}}, nil
+	}},
+	"approve": {"approve <signed_json>", true, func(_ []string, s *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdApprove, Args: map[string]string{"id": s.Args}}, nil
+	}},
+	"curfew-override": {"curfew-override <signed_json>", true, func(_ []string, s *security.SignedCommand) (*ipc.Request, error) {
+		return &ipc.Request{Command: ipc.CmdCurfewOverride, Args: map[string]string{"mode": s.Args}}, nil
+	}},
+}
+
+var errUsage = fmt.Errorf("usage")
+
+// Commands lists the command names a bridge understands, for permission
+// configuration.
+func Commands() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse turns the words of a command, e.g. ["throttle", "black-hole"],
+// into its request without running it.  Commands that need a signed
+// payload are refused.  vexd uses it for scheduled commands.
+func Parse(words []string) (*ipc.Request, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	c, ok := commands[words[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", words[0])
+	}
+	if c.signed {
+		return nil, fmt.Errorf("%q needs a signed authorization payload", words[0])
+	}
+	req, err := c.build(words[1:], nil)
+	if err == errUsage {
+		return nil, fmt.Errorf("usage: %s", c.usage)
+	}
+	return req, err
+}
+
+// Handle runs the command in a chat message.  allowed reports whether the
+// sender may run a command by name.  It returns the reply to post, and
+// false if the message was not addressed to the bot at all.
+func (b *Bridge) Handle(text string, allowed func(name string) bool) (string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(text), b.Prefix)
+	if !ok || (rest != "" && rest[0] != ' ') {
+		return "", false
+	}
+	words := strings.Fields(rest)
+	if len(words) == 0 || words[0] == "help" {
+		return b.help(allowed), true
+	}
+
+	name := words[0]
+	c, ok := commands[name]
+	if !ok {
+		return fmt.Sprintf("Unknown command %q. Try `%s help`.", name, b.Prefix), true
+	}
+	if !allowed(name) {
+		return fmt.Sprintf("You are not permitted to run %q.", name), true
+	}
+
+	var signed *security.SignedCommand
+	if c.signed {
+		// The payload may contain spaces; take everything after the name.
+		payload := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), name))
+		var err error
+		if signed, err = b.verifySigned(name, payload); err != nil {
+			return "Authorization denied: " + err.Error(), true
+		}
+	}
+
+	req, err := c.build(words[1:], signed)
+	if err == errUsage {
+		return fmt.Sprintf("Usage: `%s %s`", b.Prefix, c.usage), true
+	}
+	if err != nil {
+		return err.Error(), true
+	}
+	return Reply(b.Dispatch(req)), true
+}
+
+// verifySigned checks that payload is a signed command for name that has
+// not been used before, in any bridge, and records it as used.
+func (b *Bridge) verifySigned(name, payload string) (*security.SignedCommand, error) {
+	if payload == "" {
+		return nil, fmt.Errorf("%q needs a signed authorization payload", name)
+	}
+	s, err := security.ParseSignedCommand([]byte(strings.Trim(payload, "`")))
+	if err != nil {
+		return nil, err
+	}
+	if s.Command != name {
+		return nil, fmt.Errorf("payload was signed for %q, not %q", s.Command, name)
+	}
+	if err := verify(s); err != nil {
+		return nil, err
+	}
+
+	if err := markUsed(strings.ToLower(s.Signature)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// markUsed records sig as used in UsedFile, or fails if it already is.
+// A record that cannot be read or written refuses every payload rather
+// than let one be replayed.
+func markUsed(sig string) error {
+	usedMu.Lock()
+	defer usedMu.Unlock()
+	if used == nil {
+		loaded := make(map[string]bool)
+		data, err := os.ReadFile(UsedFile)
+		if err == nil {
+			var sigs []string
+			if err := json.Unmarshal(data, &sigs); err != nil {
+				return fmt.Errorf("cannot check the payload against %s: %w", UsedFile, err)
+			}
+			for _, s := range sigs {
+				loaded[s] = true
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("cannot check the payload against %s: %w", UsedFile, err)
+		}
+		used = loaded
+	}
+	if used[sig] {
+		return fmt.Errorf("this payload has already been used")
+	}
+
+	sigs := make([]string, 0, len(used)+1)
+	for s := range used {
+		sigs = append(sigs, s)
+	}
+	sigs = append(sigs, sig)
+	sort.Strings(sigs)
+	data, err := json.Marshal(sigs)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(UsedFile), 0o755)
+	}
+	if err == nil {
+		tmp := UsedFile + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, UsedFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot record the payload as used: %w", err)
+	}
+	used[sig] = true
+	return nil
+}
+
+func (b *Bridge) help(allowed func(name string) bool) string {
+	var sb strings.Builder
+	sb.WriteString("Commands:\n")
+	for _, name := range Commands() {
+		if allowed(name) {
+			fmt.Fprintf(&sb, "  %s %s\n", b.Prefix, commands[name].usage)
+		}
+	}
+	return sb.String()
+}
+
+// Reply renders a daemon response as chat text.
+func Reply(resp *ipc.Response) string {
+	if resp == nil {
+		return "No response from the daemon."
+	}
+	if !resp.OK {
+		return "Error: " + resp.Error
+	}
+	if s := resp.State; s != nil && resp.Message == "" {
+		return fmt.Sprintf("Profile %s, CPU %d%%, %d blocked domains. Locked: %v (score %d, task %s).",
+			s.Network.Profile, s.Compute.CPULimitPct, len(s.Guardian.BlockedDomains),
+			s.Compliance.Locked, s.Compliance.FailureScore, s.Compliance.TaskStatus)
+	}
+	return resp.Message
+}
+
+// Permissions maps a sender's identities (user IDs, role IDs) to the
+// commands they may run; "*" allows all.
+type Permissions map[string][]string
+
+// Allowed returns the permission check for a sender with the given
+// identities.
+func (p Permissions) Allowed(ids ...string) func(name string) bool {
+	return func(name string) bool {
+		for _, id := range ids {
+			for _, c := range p[id] {
+				if c == "*" || c == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// Validate rejects command names no bridge understands.
+func (p Permissions) Validate() error {
+	for id, names := range p {
+		for _, name := range names {
+			if _, ok := commands[name]; !ok && name != "*" {
+				return fmt.Errorf("%s: unknown command %q", id, name)
+			}
+		}
+	}
+	return nil
+}
+
+func takeFor(args []string) ([]string, string) {
+	var rest []string
+	period := ""
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--for" && i+1 < len(args) {
+			period = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	return rest, period
+}
+
+func withFor(args map[string]string, period string) map[string]string {
+	if period != "" {
+		args["for"] = period
+	}
+	return args
+}
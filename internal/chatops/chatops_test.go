@@ -0,0 +1,106 @@
+package chatops
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+)
+
+func allowAll(string) bool { return true }
+
+func newTestBridge(got *[]*ipc.Request) *Bridge {
+	return NewBridge("", func(req *ipc.Request) *ipc.Response {
+		*got = append(*got, req)
+		return &ipc.Response{OK: true, Message: "done"}
+	})
+}
+
+func TestHandle_BuildsRequest(t *testing.T) {
+	var got []*ipc.Request
+	b := newTestBridge(&got)
+
+	reply, ok := b.Handle("!vex block add reddit.com --for 2h", allowAll)
+	if !ok || reply != "done" {
+		t.Fatalf("Handle = %q, %v", reply, ok)
+	}
+	if len(got) != 1 || got[0].Command != ipc.CmdBlockAdd || got[0].Args["domain"] != "reddit.com" || got[0].Args["for"] != "2h" {
+		t.Errorf("Unexpected request %+v", got)
+	}
+
+	if _, ok := b.Handle("!vexing is not a command", allowAll); ok {
+		t.Error("Expected messages without the prefix as a word to be ignored")
+	}
+	if reply, _ := b.Handle("!vex throttle", allowAll); !strings.HasPrefix(reply, "Usage:") {
+		t.Errorf("Expected usage, got %q", reply)
+	}
+}
+
+func TestParse(t *testing.T) {
+	req, err := Parse([]string{"throttle", "black-hole"})
+	if err != nil || req.Command != ipc.CmdThrottle || req.Args["profile"] != "black-hole" {
+		t.Errorf("Unexpected request %+v, %v", req, err)
+	}
+	for _, words := range [][]string{nil, {"reboot"}, {"unlock"}, {"cpu"}} {
+		if _, err := Parse(words); err == nil {
+			t.Errorf("Expected %q to be refused", words)
+		}
+	}
+}
+
+func TestHandle_Permissions(t *testing.T) {
+	var got []*ipc.Request
+	b := newTestBridge(&got)
+	perms := Permissions{"friend": {"status", "block"}}
+
+	if reply, _ := b.Handle("!vex cpu 10", perms.Allowed("friend")); !strings.Contains(reply, "not permitted") {
+		t.Errorf("Expected cpu to be denied, got %q", reply)
+	}
+	if _, _ = b.Handle("!vex status", perms.Allowed("stranger", "friend")); len(got) != 1 {
+		t.Error("Expected status to be allowed through the friend role")
+	}
+	if help, _ := b.Handle("!vex help", perms.Allowed("friend")); strings.Contains(help, "cpu") || !strings.Contains(help, "block") {
+		t.Errorf("Expected help to list only permitted commands, got %q", help)
+	}
+	if err := (Permissions{"x": {"rm -rf"}}).Validate(); err == nil {
+		t.Error("Expected an unknown command to be rejected")
+	}
+}
+
+func TestHandle_SignedOnce(t *testing.T) {
+	verify = func(*security.SignedCommand) error { return nil }
+	defer func() { verify = security.VerifyCommand }()
+	defer func(f string) { UsedFile, used = f, nil }(UsedFile)
+	UsedFile, used = filepath.Join(t.TempDir(), "chatops-used.json"), nil
+
+	var got []*ipc.Request
+	b := newTestBridge(&got)
+	payload := `{"command":"unlock","args":"2h","timestamp":1700000000,"signature":"ABCD"}`
+
+	if reply, _ := b.Handle("!vex unlock", allowAll); !strings.Contains(reply, "needs a signed") {
+		t.Errorf("Expected unsigned unlock to be refused, got %q", reply)
+	}
+	if reply, _ := b.Handle("!vex unlock `"+payload+"`", allowAll); reply != "done" {
+		t.Fatalf("Expected signed unlock to run, got %q", reply)
+	}
+	if got[0].Command != ipc.CmdUnlock || got[0].Args["until"] != "2h" {
+		t.Errorf("Unexpected request %+v", got[0])
+	}
+	if reply, _ := b.Handle("!vex unlock "+strings.ToLower(payload), allowAll); !strings.Contains(reply, "already been used") {
+		t.Errorf("Expected the payload to be refused a second time, got %q", reply)
+	}
+	if reply, _ := b.Handle("!vex pause "+payload, allowAll); !strings.Contains(reply, "signed for") {
+		t.Errorf("Expected a payload for another command to be refused, got %q", reply)
+	}
+
+	// As after a restart, and in another bridge.
+	used = nil
+	if reply, _ := newTestBridge(&got).Handle("!vex unlock `"+payload+"`", allowAll); !strings.Contains(reply, "already been used") {
+		t.Errorf("Expected the payload to be refused after a restart, got %q", reply)
+	}
+	if len(got) != 1 {
+		t.Errorf("Expected one dispatched request, got %d", len(got))
+	}
+}
@@ -0,0 +1,258 @@
+// Package checkpoint seals each day of the audit log with an Ed25519
+// signature, so that the log can be checked for edits long after the day
+// is over, including once it has been rotated into a gzip archive.
+//
+// A checkpoint records the SHA-256 of the day's entries, as the log has
+// them, and the signature of the checkpoint before it, so that dropping
+// or rewriting one breaks the chain.  The signing key is the daemon's
+// own, generated on first use and readable only by root.  The
+// checkpoints can also be POSTed to a keyholder endpoint as they are
+// made, which is what keeps a root user from re-signing a rewritten log.
+package checkpoint
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+)
+
+var (
+	// ConfigFile holds the upload endpoint.  Optional; checkpoints are
+	// made either way.
+	ConfigFile = "/etc/vex-cli/checkpoint.json"
+
+	// KeyFile holds the hex seed of the daemon's signing key.
+	KeyFile = "/var/lib/vex-cli/checkpoint.key"
+
+	// File is the append-only list of checkpoints, one JSON object per
+	// line, oldest first.
+	File = "/var/lib/vex-cli/checkpoints.jsonl"
+
+	// Timeout bounds a single upload.
+	Timeout = 15 * time.Second
+)
+
+// dateLayout names a day in local time.
+const dateLayout = "2006-01-02"
+
+// entryLayout is the timestamp layout of the log, as logging writes it.
+const entryLayout = "2006/01/02 15:04:05"
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"` // signs the body as notify.json does
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means
+// checkpoints are kept locally only and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return nil, fmt.Errorf("%s: url must be http:// or https://", ConfigFile)
+	}
+	return &c, nil
+}
+
+// Checkpoint seals one day of the log.
+type Checkpoint struct {
+	Date      string `json:"date"` // local day, YYYY-MM-DD
+	Entries   int    `json:"entries"`
+	Digest    string `json:"digest"` // hex SHA-256 of the day's entries
+	Prev      string `json:"prev"`   // signature of the checkpoint before, empty for the first
+	Sealed    string `json:"sealed"` // RFC3339
+	Key       string `json:"key"`    // hex Ed25519 public key
+	Signature string `json:"signature"`
+}
+
+// message is what the signature covers.
+func (c *Checkpoint) message() []byte {
+	return fmt.Appendf(nil, "vex-checkpoint:%s:%d:%s:%s:%s", c.Date, c.Entries, c.Digest, c.Prev, c.Sealed)
+}
+
+// LoadKey reads the signing key, generating and saving one if there is
+// none yet.
+func LoadKey() (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(KeyFile)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s: not a hex Ed25519 seed", KeyFile)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(KeyFile), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(KeyFile, []byte(hex.EncodeToString(seed)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Digest hashes the entries of the log at logPath written on the local
+// day date, archives included.  Each entry is hashed as its timestamp and
+// text, newline-terminated.
+func Digest(logPath, date string) (string, int, error) {
+	day, err := time.ParseInLocation(dateLayout, date, time.Local)
+	if err != nil {
+		return "", 0, err
+	}
+	h := sha256.New()
+	n := 0
+	err = vexlog.ReadEntries(logPath, day, day.AddDate(0, 0, 1), func(e vexlog.Entry) {
+		fmt.Fprintf(h, "%s %s\n", e.Time.Format(entryLayout), e.Text)
+		n++
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// Seal makes the checkpoint for date, signs it with key, chaining it to
+// prev (nil for the first), and appends it to File.
+func Seal(logPath, date string, prev *Checkpoint, key ed25519.PrivateKey, now time.Time) (*Checkpoint, error) {
+	digest, n, err := Digest(logPath, date)
+	if err != nil {
+		return nil, err
+	}
+	c := &Checkpoint{
+		Date:    date,
+		Entries: n,
+		Digest:  digest,
+		Sealed:  now.UTC().Format(time.RFC3339),
+		Key:     hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+	if prev != nil {
+		c.Prev = prev.Signature
+	}
+	c.Signature = hex.EncodeToString(ed25519.Sign(key, c.message()))
+	if err := appendCheckpoint(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func appendCheckpoint(c *Checkpoint) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Read returns every checkpoint in File, oldest first.  A missing file
+// has none.
+func Read() ([]Checkpoint, error) {
+	f, err := os.Open(File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Checkpoint
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var c Checkpoint
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			return out, fmt.Errorf("%s: %w", File, err)
+		}
+		out = append(out, c)
+	}
+	return out, sc.Err()
+}
+
+// Verify checks c against the log at logPath: its signature, its link to
+// prev (nil for the first checkpoint), and that the day's entries still
+// hash to its digest.
+func Verify(logPath string, c, prev *Checkpoint) error {
+	pub, err := hex.DecodeString(c.Key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("malformed key")
+	}
+	sig, err := hex.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(pub, c.message(), sig) {
+		return errors.New("bad signature")
+	}
+	if prev != nil {
+		if c.Prev != prev.Signature {
+			return fmt.Errorf("does not follow the checkpoint for %s", prev.Date)
+		}
+		if c.Key != prev.Key {
+			return fmt.Errorf("signed with a different key than %s", prev.Date)
+		}
+	}
+	digest, n, err := Digest(logPath, c.Date)
+	if err != nil {
+		return fmt.Errorf("cannot read the log: %w", err)
+	}
+	if digest != c.Digest {
+		return fmt.Errorf("log changed: %d entries now, %d when sealed", n, c.Entries)
+	}
+	return nil
+}
+
+// Upload POSTs c to the configured endpoint.
+func Upload(cfg *Config, c *Checkpoint) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Secret != "" {
+		req.Header.Set(notify.SignatureHeader, notify.Sign([]byte(cfg.Secret), body))
+	}
+	resp, err := (&http.Client{Timeout: Timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", cfg.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSealAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	KeyFile = filepath.Join(dir, "checkpoint.key")
+	File = filepath.Join(dir, "checkpoints.jsonl")
+	logPath := filepath.Join(dir, "vex-cli.log")
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	line := func(t time.Time, text string) string {
+		return "[VEX-CLI] " + t.Format("2006/01/02 15:04:05") + " " + text + "\n"
+	}
+	log := line(day.Add(9*time.Hour), "[GUARDIAN] KILLED: app=steam pid=10") +
+		line(day.Add(30*time.Hour), "[PENANCE] COMPLETED: total_completed=3 locked=false") +
+		line(day.Add(50*time.Hour), "[IPC] REQUEST: cmd=status args=map[]")
+	if err := os.WriteFile(logPath, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadKey()
+	if err != nil {
+		t.Fatalf("LoadKey: %v", err)
+	}
+	if again, err := LoadKey(); err != nil || !again.Equal(key) {
+		t.Fatalf("Expected the saved key back, got %v", err)
+	}
+
+	first, err := Seal(logPath, "2026-03-01", nil, key, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	second, err := Seal(logPath, "2026-03-02", first, key, day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if first.Entries != 1 || second.Entries != 1 || second.Prev != first.Signature {
+		t.Fatalf("Unexpected checkpoints %+v, %+v", first, second)
+	}
+
+	all, err := Read()
+	if err != nil || len(all) != 2 {
+		t.Fatalf("Read = %d checkpoints, %v", len(all), err)
+	}
+	if err := Verify(logPath, &all[0], nil); err != nil {
+		t.Errorf("Verify first: %v", err)
+	}
+	if err := Verify(logPath, &all[1], &all[0]); err != nil {
+		t.Errorf("Verify second: %v", err)
+	}
+
+	// Editing a sealed day is caught; the later day is unaffected.
+	edited := strings.Replace(log, "pid=10", "pid=11", 1)
+	if err := os.WriteFile(logPath, []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(logPath, &all[0], nil); err == nil || !strings.Contains(err.Error(), "log changed") {
+		t.Errorf("Expected the edit to be caught, got %v", err)
+	}
+	if err := Verify(logPath, &all[1], &all[0]); err != nil {
+		t.Errorf("Verify second after editing the first day: %v", err)
+	}
+
+	// So is a checkpoint that was re-signed out of the chain or forged.
+	if err := Verify(logPath, &all[1], nil); err != nil {
+		t.Errorf("Verify without prev: %v", err)
+	}
+	forged := all[1]
+	forged.Entries = 5
+	if err := Verify(logPath, &forged, &all[0]); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Expected a bad signature, got %v", err)
+	}
+	unchained := all[1]
+	unchained.Prev = ""
+	if err := Verify(logPath, &unchained, &all[0]); err == nil {
+		t.Error("Expected a checkpoint outside the chain to fail")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+	os.WriteFile(ConfigFile, []byte(`{"url": "ftp://example.com"}`), 0o644)
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected a non-HTTP url to be rejected")
+	}
+}
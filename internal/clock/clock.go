@@ -0,0 +1,358 @@
+// Package clock is the time vexd's deadlines and periodic work run by.
+// Normally it is the wall clock.  Under vexd --simulate it is a virtual
+// clock instead: it runs at an adjustable rate and can be moved forward
+// by hand, firing every timer and ticker that falls due on the way in
+// order, so that days of escalation play out in seconds.
+//
+// Code that should follow the simulation takes the time from Now and
+// arms its timers with AfterFunc and NewTicker here rather than in
+// package time.  What measures real durations, such as timeouts and
+// retries, keeps to package time.
+package clock
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// sim is the virtual clock, or nil for the wall clock.
+var sim *virtual
+
+// Simulate switches to a virtual clock that starts at start and runs at
+// the wall clock's pace.  It is called once, before any timer is armed.
+func Simulate(start time.Time) {
+	sim = &virtual{base: start, anchor: time.Now(), rate: 1, wake: make(chan struct{}, 1)}
+	go sim.run()
+}
+
+// Simulated reports whether the clock is virtual.
+func Simulated() bool { return sim != nil }
+
+// Now returns the current time.
+func Now() time.Time {
+	if sim == nil {
+		return time.Now()
+	}
+	return sim.now()
+}
+
+// Since returns the time elapsed since t.
+func Since(t time.Time) time.Duration { return Now().Sub(t) }
+
+// Until returns the duration until t.
+func Until(t time.Time) time.Duration { return t.Sub(Now()) }
+
+// Timer is a time.Timer on the clock.
+type Timer struct {
+	t *time.Timer
+	e *event
+}
+
+// AfterFunc calls f in its own goroutine once d has passed, as
+// time.AfterFunc.  On the virtual clock f runs in the goroutine that
+// moves the clock, and the clock waits for it.
+func AfterFunc(d time.Duration, f func()) *Timer {
+	if sim == nil {
+		return &Timer{t: time.AfterFunc(d, f)}
+	}
+	return &Timer{e: sim.add(d, 0, f, nil)}
+}
+
+// Stop prevents the timer from firing.  It returns false if the timer
+// has already fired or been stopped.
+func (t *Timer) Stop() bool {
+	if t.t != nil {
+		return t.t.Stop()
+	}
+	return sim.remove(t.e)
+}
+
+// Ticker is a time.Ticker on the clock.
+type Ticker struct {
+	C <-chan time.Time
+	t *time.Ticker
+	e *event
+}
+
+// NewTicker returns a ticker that sends the time on C every d, as
+// time.NewTicker.  d must be positive.
+func NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	if sim == nil {
+		t := time.NewTicker(d)
+		return &Ticker{C: t.C, t: t}
+	}
+	c := make(chan time.Time, 1)
+	return &Ticker{C: c, e: sim.add(d, d, nil, c)}
+}
+
+// Stop turns the ticker off.
+func (t *Ticker) Stop() {
+	if t.t != nil {
+		t.t.Stop()
+		return
+	}
+	sim.remove(t.e)
+}
+
+// Reset stops the ticker and restarts it with the interval d.
+func (t *Ticker) Reset(d time.Duration) {
+	if t.t != nil {
+		t.t.Reset(d)
+		return
+	}
+	sim.reset(t.e, d)
+}
+
+// -- The virtual clock --
+
+// Status is the state of the virtual clock.
+type Status struct {
+	Now       time.Time
+	Rate      float64   // virtual seconds per real second; 0 when stopped
+	Advancing time.Time // where a running Advance is taking the clock, or zero
+	Pending   int       // timers and tickers armed
+}
+
+// ErrNotSimulated is returned for changes to the wall clock.
+var ErrNotSimulated = errors.New("the clock is not simulated (vexd --simulate)")
+
+// Current returns the state of the virtual clock.
+func Current() (Status, error) {
+	if sim == nil {
+		return Status{}, ErrNotSimulated
+	}
+	v := sim
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return Status{Now: v.nowLocked(), Rate: v.rate, Advancing: v.target, Pending: len(v.events)}, nil
+}
+
+// SetRate makes the virtual clock run rate times as fast as the wall
+// clock; 0 stops it.
+func SetRate(rate float64) error {
+	if sim == nil {
+		return ErrNotSimulated
+	}
+	if rate < 0 {
+		return errors.New("the rate must not be negative")
+	}
+	v := sim
+	v.mu.Lock()
+	v.setLocked(v.nowLocked())
+	v.rate = rate
+	v.mu.Unlock()
+	v.poke()
+	return nil
+}
+
+// Advance starts moving the virtual clock forward by d, in the
+// background, and returns where it is going.  Timers and tickers due on
+// the way fire one at a time in order, each at its own time, and Current
+// reports the target until the clock gets there.  Only one Advance runs
+// at a time.
+func Advance(d time.Duration) (time.Time, error) {
+	if sim == nil {
+		return time.Time{}, ErrNotSimulated
+	}
+	if d <= 0 {
+		return time.Time{}, errors.New("the clock only moves forward")
+	}
+	v := sim
+	v.mu.Lock()
+	if !v.target.IsZero() {
+		defer v.mu.Unlock()
+		return time.Time{}, errors.New("the clock is already advancing to " + v.target.Format(time.RFC3339))
+	}
+	target := v.nowLocked().Add(d)
+	v.target = target
+	v.mu.Unlock()
+
+	go func() {
+		v.fireMu.Lock()
+		for v.fireNext(target) {
+		}
+		v.mu.Lock()
+		if v.nowLocked().Before(target) {
+			v.setLocked(target)
+		}
+		v.target = time.Time{}
+		v.mu.Unlock()
+		v.fireMu.Unlock()
+		v.poke()
+	}()
+	return target, nil
+}
+
+// tickWait bounds how long Advance waits for a ticker's reader to take a
+// tick before it moves on without it.
+const tickWait = time.Second
+
+// event is an armed timer or ticker.  A timer calls f; a ticker sends on
+// c every period.
+type event struct {
+	at     time.Time
+	period time.Duration
+	f      func()
+	c      chan time.Time
+}
+
+type virtual struct {
+	mu     sync.Mutex
+	base   time.Time // the virtual time at anchor
+	anchor time.Time // on the wall clock
+	rate   float64
+	events []*event
+	target time.Time // of the running Advance
+	wake   chan struct{}
+
+	// fireMu is held while events fire, so that one fires at a time and
+	// run stays out of the way of Advance.
+	fireMu sync.Mutex
+}
+
+func (v *virtual) now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.nowLocked()
+}
+
+func (v *virtual) nowLocked() time.Time {
+	return v.base.Add(time.Duration(float64(time.Since(v.anchor)) * v.rate))
+}
+
+// setLocked puts the clock at t.
+func (v *virtual) setLocked(t time.Time) {
+	v.base, v.anchor = t, time.Now()
+}
+
+// poke wakes run to look at the events again.
+func (v *virtual) poke() {
+	select {
+	case v.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (v *virtual) add(d, period time.Duration, f func(), c chan time.Time) *event {
+	v.mu.Lock()
+	e := &event{at: v.nowLocked().Add(d), period: period, f: f, c: c}
+	v.events = append(v.events, e)
+	v.mu.Unlock()
+	v.poke()
+	return e
+}
+
+func (v *virtual) remove(e *event) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.removeLocked(e)
+}
+
+func (v *virtual) removeLocked(e *event) bool {
+	for i, x := range v.events {
+		if x == e {
+			v.events = append(v.events[:i], v.events[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (v *virtual) reset(e *event, d time.Duration) {
+	v.mu.Lock()
+	v.removeLocked(e)
+	e.at, e.period = v.nowLocked().Add(d), d
+	v.events = append(v.events, e)
+	v.mu.Unlock()
+	v.poke()
+}
+
+// nextLocked returns the event due first, or nil.
+func (v *virtual) nextLocked() *event {
+	var next *event
+	for _, e := range v.events {
+		if next == nil || e.at.Before(next.at) {
+			next = e
+		}
+	}
+	return next
+}
+
+// fireNext fires the first event due by limit, with the clock put at its
+// time, and reports whether there was one.  A zero limit means now, and
+// the clock is left alone.
+func (v *virtual) fireNext(limit time.Time) bool {
+	v.mu.Lock()
+	now := v.nowLocked()
+	e := v.nextLocked()
+	switch {
+	case e == nil:
+		v.mu.Unlock()
+		return false
+	case limit.IsZero():
+		if e.at.After(now) {
+			v.mu.Unlock()
+			return false
+		}
+	case e.at.After(limit):
+		v.mu.Unlock()
+		return false
+	case e.at.After(now):
+		v.setLocked(e.at)
+	}
+	at := e.at
+	if e.period > 0 {
+		e.at = e.at.Add(e.period)
+		if limit.IsZero() && !e.at.After(now) {
+			// Behind the clock, as when the rate went up: like a
+			// time.Ticker, drop the ticks missed.
+			e.at = now.Add(e.period - now.Sub(e.at)%e.period)
+		}
+	} else {
+		v.removeLocked(e)
+	}
+	v.mu.Unlock()
+
+	switch {
+	case e.f != nil:
+		e.f()
+	case limit.IsZero():
+		select {
+		case e.c <- at:
+		default:
+		}
+	default:
+		select {
+		case e.c <- at:
+		case <-time.After(tickWait):
+		}
+	}
+	return true
+}
+
+// run fires the events as the virtual clock reaches them.
+func (v *virtual) run() {
+	t := time.NewTimer(time.Hour)
+	for {
+		v.fireMu.Lock()
+		for v.fireNext(time.Time{}) {
+		}
+		v.fireMu.Unlock()
+
+		wait := time.Hour // until poked
+		v.mu.Lock()
+		if e := v.nextLocked(); e != nil && v.rate > 0 && v.target.IsZero() {
+			wait = max(time.Duration(float64(e.at.Sub(v.nowLocked()))/v.rate), 0)
+		}
+		v.mu.Unlock()
+		t.Reset(wait)
+		select {
+		case <-t.C:
+		case <-v.wake:
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package clock
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	Simulate(start)
+	os.Exit(m.Run())
+}
+
+// advance moves the stopped clock forward by d and waits until it gets
+// there.
+func advance(t *testing.T, d time.Duration) {
+	t.Helper()
+	if err := SetRate(0); err != nil {
+		t.Fatal(err)
+	}
+	target, err := Advance(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, _ := Current()
+		if st.Advancing.IsZero() {
+			if !st.Now.Equal(target) {
+				t.Fatalf("clock at %s, want %s", st.Now, target)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("still advancing to %s", target)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdvanceFiresInOrder(t *testing.T) {
+	SetRate(0)
+	from := Now()
+	var mu sync.Mutex
+	var got []time.Duration
+	for _, d := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		AfterFunc(d, func() {
+			mu.Lock()
+			got = append(got, Since(from))
+			mu.Unlock()
+		})
+	}
+	stopped := AfterFunc(90*time.Minute, func() { t.Error("a stopped timer fired") })
+	if !stopped.Stop() {
+		t.Error("Stop of a pending timer returned false")
+	}
+	late := AfterFunc(5*time.Hour, func() { t.Error("a timer past the target fired") })
+	defer late.Stop()
+
+	advance(t, 4*time.Hour)
+	mu.Lock()
+	defer mu.Unlock()
+	want := []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour}
+	if len(got) != len(want) {
+		t.Fatalf("fired at %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("timer %d fired at +%s, want +%s", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTickerDuringAdvance(t *testing.T) {
+	SetRate(0)
+	from := Now()
+	tk := NewTicker(time.Minute)
+	defer tk.Stop()
+
+	var ticks []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for now := range tk.C {
+			ticks = append(ticks, now)
+			if len(ticks) == 10 {
+				return
+			}
+		}
+	}()
+	advance(t, 10*time.Minute)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("got %d ticks, want 10", len(ticks))
+	}
+	for i, now := range ticks {
+		if want := from.Add(time.Duration(i+1) * time.Minute); !now.Equal(want) {
+			t.Errorf("tick %d at %s, want %s", i, now, want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	if err := SetRate(3600); err != nil { // an hour a second
+		t.Fatal(err)
+	}
+	defer SetRate(0)
+	fired := make(chan struct{})
+	AfterFunc(time.Hour, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("an hour did not pass in five seconds at 3600x")
+	}
+	if err := SetRate(-1); err == nil {
+		t.Error("a negative rate was accepted")
+	}
+}
@@ -0,0 +1,584 @@
+// Package config reads the daemon configuration: the tunables that are
+// otherwise built into each subsystem, such as check intervals, the
+// failure score cap and the IPC socket path, and whether each subsystem
+// enforces, runs dry or is off.
+//
+// Every field is optional; a field left out, or a missing File, keeps the
+// built-in default.  Apply copies the values that are set into the
+// subsystems' package variables, so it must run before their Init.
+// Reload does the same for a running daemon and reports what changed.
+// Setting and Set read and change one setting by its dotted name, for
+// vex-cli config.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/antitamper"
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	"github.com/adumbdinosaur/vex-cli/internal/sandbox"
+	"github.com/adumbdinosaur/vex-cli/internal/scheduler"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// File holds the daemon configuration.  Optional.
+var File = "/etc/vex-cli/config.json"
+
+// Config is the contents of File.
+type Config struct {
+	SocketPath               string `json:"socket_path,omitempty"`
+	SchedulerIntervalSeconds int    `json:"scheduler_interval_seconds,omitempty"`
+
+	// Subsystems sets how each subsystem runs, e.g. {"guardian": "dry-run",
+	// "surveillance": "off"}.  Those left out enforce.
+	Subsystems map[string]subsystem.Mode `json:"subsystems,omitempty"`
+
+	// TargetUsers limits enforcement to these accounts, as names or UIDs,
+	// leaving anyone else on the machine alone.  Empty means everyone.
+	TargetUsers []string `json:"target_users,omitempty"`
+
+	AntiTamper   AntiTamper   `json:"antitamper"`
+	Guardian     Guardian     `json:"guardian"`
+	Throttler    Throttler    `json:"throttler"`
+	Surveillance Surveillance `json:"surveillance"`
+	History      History      `json:"history"`
+	Sandbox      Sandbox      `json:"sandbox"`
+}
+
+// AntiTamper tunes the integrity checks and escalation.
+type AntiTamper struct {
+	CheckIntervalSeconds      int `json:"check_interval_seconds,omitempty"`
+	EscalationCooldownMinutes int `json:"escalation_cooldown_minutes,omitempty"`
+	MaxFailureScore           int `json:"max_failure_score,omitempty"`
+
+	// ClientHashes are the SHA-256 sums of the vex-cli builds trusted to
+	// lower restrictions.  Empty trusts the vex-cli installed beside vexd.
+	ClientHashes []string `json:"client_hashes,omitempty"`
+}
+
+// Guardian tunes the network firewall and the tunnel watch.
+type Guardian struct {
+	DNSRefreshMinutes     int      `json:"dns_refresh_minutes,omitempty"`
+	DefaultBlockedDomains []string `json:"default_blocked_domains,omitempty"` // replaces the built-in list
+	TunnelResponse        []string `json:"tunnel_response,omitempty"`         // any of block, escalate, notify
+	TunnelCheckSeconds    int      `json:"tunnel_check_seconds,omitempty"`
+	AllowedTunnels        []string `json:"allowed_tunnels,omitempty"` // interfaces never reported
+	RelayPrefixes         []string `json:"relay_prefixes,omitempty"`  // IPv4 prefixes of known relays
+	StubResolver          bool     `json:"stub_resolver,omitempty"`   // answer DNS in vexd, refusing blocked names
+	StubUpstreams         []string `json:"stub_upstreams,omitempty"`  // servers the stub asks; default from resolv.conf
+	OOMTargets            []string `json:"oom_targets,omitempty"`     // command globs the OOM penalty is limited to
+}
+
+// Throttler tunes the CPU limiter.
+type Throttler struct {
+	CgroupTargets   []string `json:"cgroup_targets,omitempty"`   // cpu.max files to try, in order
+	PenaltySlice    bool     `json:"penalty_slice,omitempty"`    // limit vex-penalty.slice instead, moving sessions into it
+	PriorityTargets []string `json:"priority_targets,omitempty"` // command globs a priority level is limited to
+}
+
+// Surveillance tunes activity tracking.
+type Surveillance struct {
+	WindowPollSeconds  int `json:"window_poll_seconds,omitempty"`
+	IdleTimeoutMinutes int `json:"idle_timeout_minutes,omitempty"`
+}
+
+// History tunes the metrics time series.
+type History struct {
+	IntervalMinutes int `json:"interval_minutes,omitempty"`
+	RetentionDays   int `json:"retention_days,omitempty"`
+}
+
+// Sandbox tunes the restrictions vexd puts on itself once started.
+type Sandbox struct {
+	Disabled      bool     `json:"disabled,omitempty"`
+	WritablePaths []string `json:"writable_paths,omitempty"` // on top of the built-in ones
+}
+
+// Load reads and validates File.  A missing file returns nil, meaning
+// every default stands.
+func Load() (*Config, error) {
+	data, err := os.ReadFile(File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", File, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", File, err)
+	}
+	return &c, nil
+}
+
+// Validate rejects negative numbers, a relative socket path and empty
+// list entries.
+func (c *Config) Validate() error {
+	for name, v := range map[string]int{
+		"scheduler_interval_seconds":             c.SchedulerIntervalSeconds,
+		"antitamper.check_interval_seconds":      c.AntiTamper.CheckIntervalSeconds,
+		"antitamper.escalation_cooldown_minutes": c.AntiTamper.EscalationCooldownMinutes,
+		"antitamper.max_failure_score":           c.AntiTamper.MaxFailureScore,
+		"guardian.dns_refresh_minutes":           c.Guardian.DNSRefreshMinutes,
+		"guardian.tunnel_check_seconds":          c.Guardian.TunnelCheckSeconds,
+		"surveillance.window_poll_seconds":       c.Surveillance.WindowPollSeconds,
+		"surveillance.idle_timeout_minutes":      c.Surveillance.IdleTimeoutMinutes,
+		"history.interval_minutes":               c.History.IntervalMinutes,
+		"history.retention_days":                 c.History.RetentionDays,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if c.SocketPath != "" && !strings.HasPrefix(c.SocketPath, "/") {
+		return errors.New("socket_path must be absolute")
+	}
+	for name, m := range c.Subsystems {
+		if !subsystem.Known(name) {
+			return fmt.Errorf("subsystems: unknown subsystem %q (known: %s)", name, strings.Join(subsystem.Names, ", "))
+		}
+		if !subsystem.Valid(m) {
+			return fmt.Errorf("subsystems.%s: %q is not enforce, dry-run or off", name, m)
+		}
+	}
+	for _, u := range c.TargetUsers {
+		if strings.TrimSpace(u) == "" {
+			return errors.New("target_users has an empty entry")
+		}
+	}
+	for _, d := range c.Guardian.DefaultBlockedDomains {
+		if strings.TrimSpace(d) == "" {
+			return errors.New("guardian.default_blocked_domains has an empty entry")
+		}
+	}
+	for _, r := range c.Guardian.TunnelResponse {
+		if r != guardian.TunnelBlock && r != guardian.TunnelEscalate && r != guardian.TunnelNotify {
+			return fmt.Errorf("guardian.tunnel_response: %q is not block, escalate or notify", r)
+		}
+	}
+	for _, p := range c.Guardian.RelayPrefixes {
+		if _, ipnet, err := net.ParseCIDR(p); err != nil || ipnet.IP.To4() == nil {
+			return fmt.Errorf("guardian.relay_prefixes: %q is not an IPv4 prefix", p)
+		}
+	}
+	for _, u := range c.Guardian.StubUpstreams {
+		host := u
+		if h, _, err := net.SplitHostPort(u); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("guardian.stub_upstreams: %q is not an IP address or address:port", u)
+		}
+	}
+	for _, h := range c.AntiTamper.ClientHashes {
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("antitamper.client_hashes: %q is not a SHA-256 hex digest", h)
+		}
+	}
+	for _, p := range c.Throttler.CgroupTargets {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("throttler.cgroup_targets: %q is not an absolute path", p)
+		}
+	}
+	for _, p := range c.Sandbox.WritablePaths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("sandbox.writable_paths: %q is not an absolute path", p)
+		}
+	}
+	return nil
+}
+
+// builtin holds the values compiled into the subsystems, taken before
+// anything is applied.
+var builtin = Current()
+
+// Current returns the values the subsystems are using now, with every
+// field set.
+func Current() *Config {
+	modes := make(map[string]subsystem.Mode, len(subsystem.Names))
+	for _, name := range subsystem.Names {
+		modes[name] = subsystem.Get(name)
+	}
+	return &Config{
+		SocketPath:               state.SocketPath,
+		SchedulerIntervalSeconds: int(scheduler.Interval / time.Second),
+		Subsystems:               modes,
+		TargetUsers:              users.Targets,
+		AntiTamper: AntiTamper{
+			CheckIntervalSeconds:      int(antitamper.CheckInterval / time.Second),
+			EscalationCooldownMinutes: int(antitamper.EscalationCooldown / time.Minute),
+			MaxFailureScore:           antitamper.MaxFailureScore,
+			ClientHashes:              antitamper.ClientHashes,
+		},
+		Guardian: Guardian{
+			DNSRefreshMinutes:     int(guardian.DNSRefreshInterval / time.Minute),
+			DefaultBlockedDomains: guardian.DefaultBlockedDomains,
+			TunnelResponse:        guardian.TunnelResponse,
+			TunnelCheckSeconds:    int(guardian.TunnelCheckInterval / time.Second),
+			AllowedTunnels:        guardian.AllowedTunnels,
+			RelayPrefixes:         guardian.RelayPrefixes,
+			StubResolver:          guardian.StubResolver,
+			StubUpstreams:         guardian.StubUpstreams,
+			OOMTargets:            guardian.OOMTargets,
+		},
+		Throttler: Throttler{
+			CgroupTargets:   throttler.CPUMaxCandidates,
+			PenaltySlice:    throttler.PenaltySlice,
+			PriorityTargets: throttler.PriorityTargets,
+		},
+		Surveillance: Surveillance{
+			WindowPollSeconds:  int(surveillance.WindowPollInterval / time.Second),
+			IdleTimeoutMinutes: int(surveillance.IdleTimeout / time.Minute),
+		},
+		History: History{
+			IntervalMinutes: int(history.Interval / time.Minute),
+			RetentionDays:   int(history.Retention / (24 * time.Hour)),
+		},
+		Sandbox: Sandbox{Disabled: sandbox.Disabled, WritablePaths: sandbox.ExtraWritable},
+	}
+}
+
+// restartOnly are the settings read once, when vexd or a subsystem
+// starts.  Reload leaves them as they are, and the subsystem modes too.
+var restartOnly = map[string]bool{
+	"socket_path":                       true,
+	"scheduler_interval_seconds":        true,
+	"target_users":                      true,
+	"throttler.penalty_slice":           true,
+	"guardian.tunnel_check_seconds":     true,
+	"guardian.stub_resolver":            true,
+	"antitamper.check_interval_seconds": true,
+	"surveillance.window_poll_seconds":  true,
+	"history.interval_minutes":          true,
+	"sandbox.disabled":                  true,
+	"sandbox.writable_paths":            true,
+}
+
+// Reload reads File again and applies it over the built-in values, so a
+// field removed from the file goes back to its default.  It returns the
+// names of the settings that changed, e.g. "guardian.dns_refresh_minutes",
+// and of those that differ but only take effect on a restart.  If the
+// file does not load, nothing changes.
+func Reload() (changed, pending []string, err error) {
+	c, err := Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c == nil {
+		c = &Config{}
+	}
+	before := Current()
+	builtin.Apply()
+	c.Apply()
+	for _, name := range Diff(before, Current()) {
+		if restartOnly[name] || strings.HasPrefix(name, "subsystems.") {
+			pending = append(pending, name)
+		} else {
+			changed = append(changed, name)
+		}
+	}
+	running := &Config{
+		SocketPath:               before.SocketPath,
+		SchedulerIntervalSeconds: before.SchedulerIntervalSeconds,
+		Subsystems:               before.Subsystems,
+		TargetUsers:              before.TargetUsers,
+		Guardian:                 Guardian{TunnelCheckSeconds: before.Guardian.TunnelCheckSeconds, StubResolver: before.Guardian.StubResolver},
+		Throttler:                Throttler{PenaltySlice: before.Throttler.PenaltySlice},
+		AntiTamper:               AntiTamper{CheckIntervalSeconds: before.AntiTamper.CheckIntervalSeconds},
+		Surveillance:             Surveillance{WindowPollSeconds: before.Surveillance.WindowPollSeconds},
+		History:                  History{IntervalMinutes: before.History.IntervalMinutes},
+		Sandbox:                  before.Sandbox,
+	}
+	running.Apply()
+	// The upstreams and the OOM and priority targets apply at once, but
+	// running would have cleared them.
+	guardian.StubUpstreams = c.Guardian.StubUpstreams
+	guardian.OOMTargets = c.Guardian.OOMTargets
+	throttler.PriorityTargets = c.Throttler.PriorityTargets
+	return changed, pending, nil
+}
+
+// Diff returns the names of the settings that differ between a and b,
+// sorted.
+func Diff(a, b *Config) []string {
+	fa, fb := flatten(a), flatten(b)
+	var changed []string
+	for name, v := range fb {
+		if fa[name] != v {
+			changed = append(changed, name)
+		}
+	}
+	for name := range fa {
+		if _, ok := fb[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flatten maps each setting's dotted JSON name to its JSON value.
+func flatten(c *Config) map[string]string {
+	data, _ := json.Marshal(c)
+	var top map[string]json.RawMessage
+	json.Unmarshal(data, &top)
+	out := make(map[string]string)
+	for name, raw := range top {
+		var section map[string]json.RawMessage
+		if json.Unmarshal(raw, &section) != nil {
+			out[name] = string(raw)
+			continue
+		}
+		for field, v := range section {
+			out[name+"."+field] = string(v)
+		}
+	}
+	return out
+}
+
+// Apply sets the subsystem variables for the fields that are set.
+func (c *Config) Apply() {
+	if c.SocketPath != "" {
+		state.SocketPath = c.SocketPath
+	}
+	setDuration(&scheduler.Interval, c.SchedulerIntervalSeconds, time.Second)
+	for name, m := range c.Subsystems {
+		subsystem.Set(name, m)
+	}
+
+	setDuration(&antitamper.CheckInterval, c.AntiTamper.CheckIntervalSeconds, time.Second)
+	setDuration(&antitamper.EscalationCooldown, c.AntiTamper.EscalationCooldownMinutes, time.Minute)
+	if c.AntiTamper.MaxFailureScore > 0 {
+		antitamper.MaxFailureScore = c.AntiTamper.MaxFailureScore
+	}
+
+	setDuration(&guardian.DNSRefreshInterval, c.Guardian.DNSRefreshMinutes, time.Minute)
+	if len(c.Guardian.DefaultBlockedDomains) > 0 {
+		guardian.DefaultBlockedDomains = c.Guardian.DefaultBlockedDomains
+	}
+	if len(c.Guardian.TunnelResponse) > 0 {
+		guardian.TunnelResponse = c.Guardian.TunnelResponse
+	}
+	setDuration(&guardian.TunnelCheckInterval, c.Guardian.TunnelCheckSeconds, time.Second)
+
+	if len(c.Throttler.CgroupTargets) > 0 {
+		throttler.CPUMaxCandidates = c.Throttler.CgroupTargets
+	}
+
+	setDuration(&surveillance.WindowPollInterval, c.Surveillance.WindowPollSeconds, time.Second)
+	setDuration(&surveillance.IdleTimeout, c.Surveillance.IdleTimeoutMinutes, time.Minute)
+
+	setDuration(&history.Interval, c.History.IntervalMinutes, time.Minute)
+	setDuration(&history.Retention, c.History.RetentionDays, 24*time.Hour)
+
+	// These default to their zero value, so unset is the default.
+	users.Targets = c.TargetUsers
+	antitamper.ClientHashes = c.AntiTamper.ClientHashes
+	guardian.AllowedTunnels = c.Guardian.AllowedTunnels
+	guardian.RelayPrefixes = c.Guardian.RelayPrefixes
+	guardian.StubResolver = c.Guardian.StubResolver
+	guardian.StubUpstreams = c.Guardian.StubUpstreams
+	guardian.OOMTargets = c.Guardian.OOMTargets
+	throttler.PenaltySlice = c.Throttler.PenaltySlice
+	throttler.PriorityTargets = c.Throttler.PriorityTargets
+	sandbox.Disabled = c.Sandbox.Disabled
+	sandbox.ExtraWritable = c.Sandbox.WritablePaths
+}
+
+// setDuration sets *d to n units, unless n is zero.
+func setDuration(d *time.Duration, n int, unit time.Duration) {
+	if n > 0 {
+		*d = time.Duration(n) * unit
+	}
+}
+
+// -- Single settings --
+
+// Names returns the dotted name of every setting, e.g.
+// "guardian.dns_refresh_minutes" and "subsystems.guardian", sorted.
+func Names() []string {
+	var names []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			for j := 0; j < f.Type.NumField(); j++ {
+				names = append(names, name+"."+jsonName(f.Type.Field(j)))
+			}
+		case reflect.Map:
+			for _, sub := range subsystem.Names {
+				names = append(names, name+"."+sub)
+			}
+		default:
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Setting returns the setting called name as JSON, e.g. "30" or
+// ["example.com"].
+func (c *Config) Setting(name string) (string, error) {
+	var v any
+	if sub, ok := strings.CutPrefix(name, "subsystems."); ok {
+		if !subsystem.Known(sub) {
+			return "", unknownSetting(name)
+		}
+		m, ok := c.Subsystems[sub]
+		if !ok {
+			m = subsystem.Enforce
+		}
+		v = m
+	} else {
+		f, err := c.field(name)
+		if err != nil {
+			return "", err
+		}
+		v = f.Interface()
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Set changes the setting called name in File, or takes it out when value
+// is empty so that the default applies again.  value is a number, true or
+// false, a string, or for a list a JSON array or comma-separated items.
+// The file is only written if the result is valid; it takes effect on a
+// reload.
+func Set(name, value string) error {
+	c, err := Load()
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		c = &Config{}
+	}
+	if sub, ok := strings.CutPrefix(name, "subsystems."); ok {
+		if !subsystem.Known(sub) {
+			return unknownSetting(name)
+		}
+		if value == "" {
+			delete(c.Subsystems, sub)
+		} else {
+			if c.Subsystems == nil {
+				c.Subsystems = make(map[string]subsystem.Mode)
+			}
+			c.Subsystems[sub] = subsystem.Mode(value)
+		}
+	} else {
+		f, err := c.field(name)
+		if err != nil {
+			return err
+		}
+		if err := parseSetting(f, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := File + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, File)
+}
+
+// field returns the field of c a dotted name other than a subsystem's
+// refers to.
+func (c *Config) field(name string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	for _, part := range strings.Split(name, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, unknownSetting(name)
+		}
+		i := slices.IndexFunc(reflect.VisibleFields(v.Type()), func(f reflect.StructField) bool {
+			return jsonName(f) == part
+		})
+		if i < 0 {
+			return reflect.Value{}, unknownSetting(name)
+		}
+		v = v.Field(i)
+	}
+	if k := v.Kind(); k == reflect.Struct || k == reflect.Map {
+		return reflect.Value{}, fmt.Errorf("%s is a section; name one of its settings (see vex-cli config show)", name)
+	}
+	return v, nil
+}
+
+// parseSetting sets f from value, as Set describes.
+func parseSetting(f reflect.Value, value string) error {
+	if value == "" {
+		f.SetZero()
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		f.SetBool(b)
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Slice:
+		var list []string
+		if strings.HasPrefix(value, "[") {
+			if err := json.Unmarshal([]byte(value), &list); err != nil {
+				return fmt.Errorf("%q is not a JSON list of strings", value)
+			}
+		} else {
+			for _, item := range strings.Split(value, ",") {
+				list = append(list, strings.TrimSpace(item))
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("cannot set a %s", f.Type())
+	}
+	return nil
+}
+
+// jsonName returns the name a field has in File.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+func unknownSetting(name string) error {
+	return fmt.Errorf("unknown setting %q (see vex-cli config show)", name)
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/antitamper"
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+func TestLoad(t *testing.T) {
+	File = filepath.Join(t.TempDir(), "config.json")
+	if c, err := Load(); c != nil || err != nil {
+		t.Fatalf("Expected nil for a missing file, got %+v, %v", c, err)
+	}
+
+	for _, bad := range []string{
+		`{"antitamper": {"max_failure_score": -1}}`,
+		`{"antitamper": {"client_hashes": ["deadbeef"]}}`,
+		`{"socket_path": "vexd.sock"}`,
+		`{"guardian": {"default_blocked_domains": ["", "example.com"]}}`,
+		`{"guardian": {"tunnel_response": ["block", "kill"]}}`,
+		`{"guardian": {"relay_prefixes": ["2001:db8::/32"]}}`,
+		`{"throttler": {"cgroup_targets": ["cpu.max"]}}`,
+		`{"history": "daily"}`,
+		`{"subsystems": {"network": "off"}}`,
+		`{"subsystems": {"guardian": "disabled"}}`,
+		`{"sandbox": {"writable_paths": ["srv/vex"]}}`,
+		`{"target_users": ["alice", " "]}`,
+	} {
+		os.WriteFile(File, []byte(bad), 0644)
+		if _, err := Load(); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+
+	os.WriteFile(File, []byte(`{
+		"socket_path": "/tmp/vexd.sock",
+		"antitamper": {"check_interval_seconds": 10, "max_failure_score": 900},
+		"guardian": {"default_blocked_domains": ["example.com"]},
+		"history": {"retention_days": 7}
+	}`), 0644)
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket, cooldown := state.SocketPath, antitamper.EscalationCooldown
+	defer func(check time.Duration, score int, domains []string, retention time.Duration) {
+		state.SocketPath = socket
+		antitamper.CheckInterval, antitamper.MaxFailureScore = check, score
+		guardian.DefaultBlockedDomains = domains
+		history.Retention = retention
+	}(antitamper.CheckInterval, antitamper.MaxFailureScore, guardian.DefaultBlockedDomains, history.Retention)
+
+	c.Apply()
+	if state.SocketPath != "/tmp/vexd.sock" || antitamper.CheckInterval != 10*time.Second ||
+		antitamper.MaxFailureScore != 900 || history.Retention != 7*24*time.Hour {
+		t.Errorf("Tunables not applied: socket=%s check=%s score=%d retention=%s",
+			state.SocketPath, antitamper.CheckInterval, antitamper.MaxFailureScore, history.Retention)
+	}
+	if len(guardian.DefaultBlockedDomains) != 1 {
+		t.Errorf("Expected the blocked domains to be replaced, got %v", guardian.DefaultBlockedDomains)
+	}
+	if antitamper.EscalationCooldown != cooldown {
+		t.Errorf("Unset field changed the cooldown to %s", antitamper.EscalationCooldown)
+	}
+}
+
+func TestReload(t *testing.T) {
+	File = filepath.Join(t.TempDir(), "config.json")
+	defer Current().Apply()
+
+	os.WriteFile(File, []byte(`{"antitamper": {"max_failure_score": 900}}`), 0644)
+	changed, pending, err := Reload()
+	if err != nil || !slices.Equal(changed, []string{"antitamper.max_failure_score"}) || pending != nil {
+		t.Fatalf("Unexpected first reload: %v, %v, %v", changed, pending, err)
+	}
+
+	os.WriteFile(File, []byte(`{
+		"socket_path": "/tmp/other.sock",
+		"subsystems": {"surveillance": "off"},
+		"history": {"retention_days": 7}
+	}`), 0644)
+	changed, pending, err = Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"antitamper.max_failure_score", "history.retention_days"}; !slices.Equal(changed, want) {
+		t.Errorf("Expected %v to change, got %v", want, changed)
+	}
+	if !slices.Equal(pending, []string{"socket_path", "subsystems.surveillance"}) ||
+		state.SocketPath == "/tmp/other.sock" || !subsystem.Enabled(subsystem.Surveillance) {
+		t.Errorf("Expected the socket and surveillance to wait for a restart, got %v with %s", pending, state.SocketPath)
+	}
+	if antitamper.MaxFailureScore != builtin.AntiTamper.MaxFailureScore {
+		t.Errorf("Expected a removed field to go back to its default, got %d", antitamper.MaxFailureScore)
+	}
+
+	os.WriteFile(File, []byte(`{"history": {"retention_days": -1}}`), 0644)
+	if _, _, err := Reload(); err == nil || history.Retention != 7*24*time.Hour {
+		t.Errorf("Expected a bad file to change nothing, got %v with retention %s", err, history.Retention)
+	}
+
+	os.WriteFile(File, []byte(`{"guardian": {"stub_resolver": true, "stub_upstreams": ["192.0.2.1"], "oom_targets": ["steam*"]}, "throttler": {"priority_targets": ["steam*"]}}`), 0644)
+	changed, pending, err = Reload()
+	if err != nil || !slices.Contains(changed, "guardian.stub_upstreams") || !slices.Equal(pending, []string{"guardian.stub_resolver"}) {
+		t.Errorf("Unexpected stub resolver reload: %v, %v, %v", changed, pending, err)
+	}
+	if guardian.StubResolver || !slices.Equal(guardian.StubUpstreams, []string{"192.0.2.1"}) {
+		t.Errorf("Expected only the upstreams to apply, got %v, %v", guardian.StubResolver, guardian.StubUpstreams)
+	}
+	if !slices.Equal(guardian.OOMTargets, []string{"steam*"}) {
+		t.Errorf("Expected the OOM targets to apply at once, got %v", guardian.OOMTargets)
+	}
+	if !slices.Equal(throttler.PriorityTargets, []string{"steam*"}) || !slices.Contains(changed, "throttler.priority_targets") {
+		t.Errorf("Expected the priority targets to apply at once, got %v, %v", throttler.PriorityTargets, changed)
+	}
+}
+
+func TestSet(t *testing.T) {
+	File = filepath.Join(t.TempDir(), "config.json")
+
+	for name, value := range map[string]string{
+		"guardian.dns_refresh_minutes": "15",
+		"guardian.relay_prefixes":      "192.0.2.0/24, 198.51.100.0/24",
+		"guardian.stub_resolver":       "true",
+		"subsystems.surveillance":      "off",
+		"target_users":                 `["alice"]`,
+	} {
+		if err := Set(name, value); err != nil {
+			t.Errorf("Set %s=%s: %v", name, value, err)
+		}
+	}
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Guardian.DNSRefreshMinutes != 15 || !c.Guardian.StubResolver || c.Subsystems["surveillance"] != subsystem.Off ||
+		!slices.Equal(c.Guardian.RelayPrefixes, []string{"192.0.2.0/24", "198.51.100.0/24"}) ||
+		!slices.Equal(c.TargetUsers, []string{"alice"}) {
+		t.Errorf("Settings not written: %+v", c)
+	}
+	if v, err := c.Setting("guardian.relay_prefixes"); err != nil || v != `["192.0.2.0/24","198.51.100.0/24"]` {
+		t.Errorf("Unexpected setting %s, %v", v, err)
+	}
+	if v, err := c.Setting("subsystems.guardian"); err != nil || v != `"enforce"` {
+		t.Errorf("Expected a subsystem left out to enforce, got %s, %v", v, err)
+	}
+
+	for name, value := range map[string]string{
+		"guardian.dns_refresh_minutes": "soon",
+		"guardian.relay_prefixes":      "2001:db8::/32",
+		"history.retention_days":       "-1",
+		"guardian":                     "{}",
+		"guardian.nope":                "1",
+		"subsystems.network":           "off",
+		"subsystems.guardian":          "disabled",
+	} {
+		if err := Set(name, value); err == nil {
+			t.Errorf("Expected %s=%s to be rejected", name, value)
+		}
+	}
+	if after, _ := Load(); after.Guardian.DNSRefreshMinutes != 15 || after.History.RetentionDays != 0 {
+		t.Errorf("A rejected setting changed the file: %+v", after)
+	}
+
+	if err := Set("guardian.dns_refresh_minutes", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Set("subsystems.surveillance", ""); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := Load(); c.Guardian.DNSRefreshMinutes != 0 || len(c.Subsystems) != 0 {
+		t.Errorf("Expected the settings to be taken out, got %+v", c)
+	}
+
+	for _, name := range Names() {
+		if _, err := Current().Setting(name); err != nil {
+			t.Errorf("Setting %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package credits rewards good behaviour with credits that can be spent
+// on sanctioned rewards.
+//
+// Credits are earned once a day, for the previous day: for a clean day
+// (no failures, kills, escalations or abandoned tasks in the audit log),
+// for each task completed and for staying within every usage rule's
+// limit.  The rewards they buy are fixed by the keyholder in ConfigFile:
+// a temporary unblock, a relaxed network profile for a while, or fewer
+// lines to write.  vexd keeps the balance and carries out every purchase.
+package credits
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// ConfigFile holds the earning rules and rewards.  Optional.
+var ConfigFile = "/etc/vex-cli/credits.json"
+
+// Reward kinds.
+const (
+	KindUnblock = "unblock" // lift a blocked domain or forbidden app for Minutes
+	KindRelax   = "relax"   // apply the lighter Profile for Minutes
+	KindLines   = "lines"   // take Lines off the active writing task
+)
+
+// Earn is how many credits each kind of good behaviour is worth.
+type Earn struct {
+	CleanDay      int `json:"clean_day,omitempty"`      // a day without failures, kills, escalations or abandoned tasks
+	TaskCompleted int `json:"task_completed,omitempty"` // per penance or writing task completed
+	UnderBudget   int `json:"under_budget,omitempty"`   // a day within every usage rule's limit
+}
+
+// Reward is something credits can be spent on.
+type Reward struct {
+	Kind    string   `json:"kind"`
+	Cost    int      `json:"cost"`
+	Minutes int      `json:"minutes,omitempty"` // unblock, relax: how long it lasts
+	Targets []string `json:"targets,omitempty"` // unblock: the domains and apps it may lift; empty means any
+	Profile string   `json:"profile,omitempty"` // relax: the profile applied
+	Lines   int      `json:"lines,omitempty"`   // lines: how many fewer to write
+}
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Earn       Earn              `json:"earn"`
+	MaxBalance int               `json:"max_balance,omitempty"` // 0 means no cap
+	Rewards    map[string]Reward `json:"rewards"`
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means
+// credits are not in use and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate requires non-negative earnings and a positive cost for every
+// reward, plus what its kind needs to be carried out.
+func (c *Config) Validate() error {
+	if c.Earn.CleanDay < 0 || c.Earn.TaskCompleted < 0 || c.Earn.UnderBudget < 0 {
+		return fmt.Errorf("earnings must not be negative")
+	}
+	if c.MaxBalance < 0 {
+		return fmt.Errorf("max_balance must not be negative")
+	}
+	for name, r := range c.Rewards {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " \t") {
+			return fmt.Errorf("invalid reward name %q", name)
+		}
+		if r.Cost <= 0 {
+			return fmt.Errorf("reward %q: cost must be positive", name)
+		}
+		switch r.Kind {
+		case KindUnblock:
+			if r.Minutes <= 0 {
+				return fmt.Errorf("reward %q: minutes must be positive", name)
+			}
+		case KindRelax:
+			if r.Minutes <= 0 {
+				return fmt.Errorf("reward %q: minutes must be positive", name)
+			}
+			if _, err := throttler.ResolveProfile(r.Profile); err != nil {
+				return fmt.Errorf("reward %q: %w", name, err)
+			}
+		case KindLines:
+			if r.Lines <= 0 {
+				return fmt.Errorf("reward %q: lines must be positive", name)
+			}
+		default:
+			return fmt.Errorf("reward %q: unknown kind %q (use unblock, relax or lines)", name, r.Kind)
+		}
+	}
+	return nil
+}
+
+// Names returns the reward names, sorted.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Rewards))
+	for name := range c.Rewards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Allows reports whether an unblock reward may lift target.
+func (r Reward) Allows(target string) bool {
+	if len(r.Targets) == 0 {
+		return true
+	}
+	for _, t := range r.Targets {
+		if strings.EqualFold(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Day is what a day earns credits for.
+type Day struct {
+	Clean       bool
+	Completions int
+	UnderBudget bool
+}
+
+// Earned is what d is worth.
+func (c *Config) Earned(d Day) int {
+	n := d.Completions * c.Earn.TaskCompleted
+	if d.Clean {
+		n += c.Earn.CleanDay
+	}
+	if d.UnderBudget {
+		n += c.Earn.UnderBudget
+	}
+	return n
+}
+
+// Credit adds earned to balance, up to MaxBalance.
+func (c *Config) Credit(balance, earned int) int {
+	balance += earned
+	if c.MaxBalance > 0 && balance > c.MaxBalance {
+		balance = c.MaxBalance
+	}
+	return balance
+}
+
+// badEvents spoil a clean day.
+var badEvents = map[string]bool{
+	"PENANCE/FAILURE":          true,
+	"PENANCE/FAILURE_REPORTED": true,
+	"GUARDIAN/KILLED":          true,
+	"ANTITAMPER/ESCALATED":     true,
+	"WRITING/TASK_ABANDONED":   true,
+}
+
+// completionEvents are completed tasks.
+var completionEvents = map[string]bool{
+	"PENANCE/COMPLETED":      true,
+	"WRITING/TASK_COMPLETED": true,
+}
+
+// Tally reads the audit log at logPath between from and to for whether
+// the period was clean and how many tasks were completed.  UnderBudget is
+// left to the caller, which has the usage figures.
+func Tally(logPath string, from, to time.Time) (Day, error) {
+	d := Day{Clean: true}
+	err := vexlog.ReadEntries(logPath, from, to, func(e vexlog.Entry) {
+		key := e.Module + "/" + e.Event
+		if badEvents[key] && !strings.Contains(e.Details, "ignored=paused") {
+			d.Clean = false
+		}
+		if completionEvents[key] {
+			d.Completions++
+		}
+	})
+	return d, err
+}
@@ -0,0 +1,98 @@
+package credits
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "credits.json")
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+
+	data := `{"earn": {"clean_day": 5, "task_completed": 2, "under_budget": 3}, "max_balance": 20,
+		"rewards": {
+			"reddit-hour": {"kind": "unblock", "cost": 10, "minutes": 60, "targets": ["reddit.com"]},
+			"fast-evening": {"kind": "relax", "cost": 8, "minutes": 120, "profile": "standard"},
+			"fewer-lines": {"kind": "lines", "cost": 4, "lines": 20}
+		}}`
+	if err := os.WriteFile(ConfigFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := strings.Join(c.Names(), ","); got != "fast-evening,fewer-lines,reddit-hour" {
+		t.Errorf("Names() = %s", got)
+	}
+	if r := c.Rewards["reddit-hour"]; !r.Allows("Reddit.com") || r.Allows("youtube.com") {
+		t.Errorf("Unexpected targets for reddit-hour: %v", r.Targets)
+	}
+	if !c.Rewards["fewer-lines"].Allows("anything") {
+		t.Error("A reward without targets should allow any")
+	}
+}
+
+func TestEarnedAndCredit(t *testing.T) {
+	c := &Config{Earn: Earn{CleanDay: 5, TaskCompleted: 2, UnderBudget: 3}, MaxBalance: 20}
+	if got := c.Earned(Day{Clean: true, Completions: 2, UnderBudget: true}); got != 12 {
+		t.Errorf("Earned = %d, want 12", got)
+	}
+	if got := c.Earned(Day{Completions: 1}); got != 2 {
+		t.Errorf("Earned = %d, want 2", got)
+	}
+	if got := c.Credit(15, 12); got != 20 {
+		t.Errorf("Credit = %d, want the cap of 20", got)
+	}
+	c.MaxBalance = 0
+	if got := c.Credit(15, 12); got != 27 {
+		t.Errorf("Credit = %d, want 27 without a cap", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, c := range map[string]Config{
+		"negative earning": {Earn: Earn{CleanDay: -1}},
+		"free reward":      {Rewards: map[string]Reward{"x": {Kind: KindLines, Lines: 5}}},
+		"unknown kind":     {Rewards: map[string]Reward{"x": {Kind: "cash", Cost: 1}}},
+		"unblock forever":  {Rewards: map[string]Reward{"x": {Kind: KindUnblock, Cost: 1}}},
+		"bad profile":      {Rewards: map[string]Reward{"x": {Kind: KindRelax, Cost: 1, Minutes: 5, Profile: "warp"}}},
+		"no lines":         {Rewards: map[string]Reward{"x": {Kind: KindLines, Cost: 1}}},
+		"spaced name":      {Rewards: map[string]Reward{"a b": {Kind: KindLines, Cost: 1, Lines: 1}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	line := func(ago time.Duration, text string) string {
+		return "[VEX-CLI] " + now.Add(-ago).Format("2006/01/02 15:04:05") + " " + text + "\n"
+	}
+	log := line(30*time.Hour, "[GUARDIAN] KILLED: app=steam pid=10") +
+		line(5*time.Hour, "[PENANCE] COMPLETED: total_completed=3 locked=false") +
+		line(4*time.Hour, `[WRITING] TASK_ABANDONED: phrase="x" idle=2h ignored=paused`) +
+		line(3*time.Hour, `[WRITING] TASK_COMPLETED: phrase="x" count=10`)
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Tally(path, now.Add(-24*time.Hour), now)
+	if err != nil {
+		t.Fatalf("Tally: %v", err)
+	}
+	if !d.Clean || d.Completions != 2 {
+		t.Errorf("Last day = %+v, want clean with 2 completions", d)
+	}
+	if d, _ := Tally(path, now.Add(-48*time.Hour), now); d.Clean {
+		t.Error("A day with a kill should not be clean")
+	}
+}
@@ -0,0 +1,235 @@
+// Package desktop shows daemon events to the subject as desktop
+// notifications: a forbidden app being closed, a usage budget running
+// low, a task being assigned or restrictions about to return.
+//
+// The daemon runs as root, outside any login session, and a session bus
+// only accepts connections from its own user.  Each notification is
+// therefore sent by running gdbus as the subject, with the session bus
+// address taken from their graphical session, calling
+// org.freedesktop.Notifications.Notify.  When nobody is logged in
+// graphically the notification is dropped.
+package desktop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+)
+
+var (
+	// ConfigFile overrides which events are shown.  Optional.
+	ConfigFile = "/etc/vex-cli/desktop.json"
+
+	// Timeout bounds a single gdbus call.
+	Timeout = 10 * time.Second
+)
+
+// DefaultEvents are the events shown when ConfigFile does not list any;
+// they are the ones the subject can act on.
+var DefaultEvents = []string{"kill", "budget_low", "task_assigned", "task_abandoned", "deadline_approaching", "failure", "completion", "forced_break", "session_freeze", "usb_device", "domain_warning"}
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Disabled bool     `json:"disabled,omitempty"`
+	Events   []string `json:"events,omitempty"` // as in notify.json; empty uses DefaultEvents
+}
+
+// titles are the notification summaries for known events.
+var titles = map[string]string{
+	"kill":                 "Forbidden app closed",
+	"budget_low":           "Usage budget running out",
+	"checkin_missed":       "Keyholder unreachable",
+	"task_assigned":        "Penance assigned",
+	"task_abandoned":       "Penance neglected",
+	"deadline_approaching": "Deadline approaching",
+	"failure":              "Penance failed",
+	"completion":           "Task completed",
+	"line_rejected":        "Line rejected",
+	"streak":               "Streak milestone",
+	"unlock":               "Restrictions lifted",
+	"exception_approved":   "Exception approved",
+	"forced_break":         "Forced break",
+	"session_freeze":       "Session freeze",
+	"usb_device":           "USB device",
+	"domain_warning":       "Monitored site",
+}
+
+// critical events stay on screen until dismissed.
+var critical = map[string]bool{"kill": true, "budget_low": true, "deadline_approaching": true, "failure": true, "forced_break": true, "session_freeze": true}
+
+// findSession and run are replaced in tests.
+var (
+	findSession = surveillance.FindSession
+	run         = runAs
+)
+
+// Init reads ConfigFile and registers the desktop as a notification sink.
+func Init() error {
+	var c Config
+	data, err := os.ReadFile(ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("%s: %w", ConfigFile, err)
+		}
+		if err := notify.ValidateFilter(c.Events); err != nil {
+			return fmt.Errorf("%s: %w", ConfigFile, err)
+		}
+	}
+	if c.Disabled {
+		log.Println("Desktop: Notifications disabled")
+		return nil
+	}
+	events := c.Events
+	if len(events) == 0 {
+		events = DefaultEvents
+	}
+	notify.AddSink(notify.Sink{Name: "desktop", Events: events, Send: Send})
+	log.Printf("Desktop: Showing %s to the subject", strings.Join(events, ", "))
+	return nil
+}
+
+// Send shows one message in the subject's graphical session.
+func Send(m notify.Message) error {
+	title := titles[m.Event]
+	if title == "" {
+		title = m.Event
+	}
+	return Show(title, Body(m), critical[m.Event])
+}
+
+// Show puts up a notification with the given title and body.  A critical
+// one stays on screen until dismissed.
+func Show(title, body string, critical bool) error {
+	s := findSession()
+	if s == nil {
+		return nil // nobody to show it to
+	}
+	urgency, expire := 1, 10000
+	if critical {
+		urgency, expire = 2, 0
+	}
+	return run(s, "gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		quote("vex-cli"), "0", quote("dialog-warning"), quote(title), quote(body),
+		"@as []", fmt.Sprintf("{'urgency': <byte %d>}", urgency), fmt.Sprint(expire))
+}
+
+// Body is the notification text for m, written for the subject.
+func Body(m notify.Message) string {
+	d := m.Details
+	switch m.Event {
+	case "kill":
+		return fmt.Sprintf("%s was closed because it is forbidden.", d["app"])
+	case "budget_low":
+		return fmt.Sprintf("%s left of today's %s budget (%s).", d["left"], d["rule"], d["limit"])
+	case "task_assigned":
+		if d["task"] == "lines" {
+			return fmt.Sprintf("Write %q %s times.", d["phrase"], d["count"])
+		}
+	case "task_abandoned":
+		return fmt.Sprintf("No line of %q for %s: %s. %s lines left.", d["phrase"], d["idle"], d["actions"], d["remaining"])
+	case "deadline_approaching":
+		switch d["kind"] {
+		case "relock":
+			return fmt.Sprintf("Restrictions return in %s.", d["in"])
+		case "pause_end":
+			return fmt.Sprintf("The pause ends in %s and restrictions return.", d["in"])
+		case "exception_end":
+			return fmt.Sprintf("The exception for %s ends in %s.", d["target"], d["in"])
+		case "lockuntil":
+			return fmt.Sprintf("The lock ends in %s.", d["in"])
+		}
+	case "failure":
+		return fmt.Sprintf("Failure recorded: %s. Score is now %s.", d["reason"], d["score"])
+	case "completion":
+		return fmt.Sprintf("Task completed, %s in total.", d["total_completed"])
+	case "line_rejected":
+		return fmt.Sprintf("Line %s was rejected (%s). Type it again.", d["line"], d["reason"])
+	case "streak":
+		return fmt.Sprintf("%s days without a failure.", d["days"])
+	case "forced_break":
+		verb := "suspend"
+		if d["action"] == "shutdown" {
+			verb = "shut down"
+		}
+		return fmt.Sprintf("The machine will %s in %s. Save your work.", verb, d["in"])
+	case "session_freeze":
+		return fmt.Sprintf("Your session freezes in %s for %s. Save your work.", d["in"], d["for"])
+	case "domain_warning":
+		if d["blocked"] == "true" {
+			return fmt.Sprintf("%s is blocked for the rest of the day.", d["domain"])
+		}
+		return fmt.Sprintf("Visit %s of %s to %s today. The next one blocks it.", d["access"], d["allowed"], d["domain"])
+	case "usb_device":
+		if d["action"] == "block" {
+			return fmt.Sprintf("The %s device %s was blocked until the lock ends.", d["kind"], d["id"])
+		}
+		return fmt.Sprintf("The %s device %s was reported to your keyholder.", d["kind"], d["id"])
+	}
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(d)) {
+		parts = append(parts, k+": "+d[k])
+	}
+	return strings.Join(parts, "\n")
+}
+
+// quote writes s as a GVariant string literal, so gdbus never reads it
+// as some other type.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// ErrNoSession is returned by Output when nobody is logged in
+// graphically.
+var ErrNoSession = errors.New("no graphical session")
+
+// Output runs a command in the subject's graphical session, as for a
+// notification, and returns its standard output.
+func Output(name string, args ...string) ([]byte, error) {
+	s := findSession()
+	if s == nil {
+		return nil, ErrNoSession
+	}
+	return outputAs(s, name, args...)
+}
+
+// runAs runs a command as the session's user with its environment.
+func runAs(s *surveillance.Session, name string, args ...string) error {
+	_, err := outputAs(s, name, args...)
+	return err
+}
+
+func outputAs(s *surveillance.Session, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = s.Env
+	if s.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		cmd.Env = append(cmd.Env, "DBUS_SESSION_BUS_ADDRESS=unix:path="+s.Getenv("XDG_RUNTIME_DIR")+"/bus")
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: s.UID, Gid: s.GID}}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
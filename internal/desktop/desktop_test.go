@@ -0,0 +1,66 @@
+package desktop
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+)
+
+func TestSend(t *testing.T) {
+	defer func(f func() *surveillance.Session, r func(*surveillance.Session, string, ...string) error) {
+		findSession, run = f, r
+	}(findSession, run)
+
+	var got []string
+	run = func(s *surveillance.Session, name string, args ...string) error {
+		got = append([]string{name}, args...)
+		return nil
+	}
+
+	findSession = func() *surveillance.Session { return nil }
+	if err := Send(notify.Message{Event: "kill"}); err != nil || got != nil {
+		t.Fatalf("Expected nothing sent without a session, got %v, %v", got, err)
+	}
+
+	findSession = func() *surveillance.Session { return &surveillance.Session{UID: 1000, GID: 1000} }
+	err := Send(notify.Message{Event: "budget_low", Details: map[string]string{"rule": "games", "left": "10m", "limit": "60m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := strings.Join(got, " ")
+	for _, want := range []string{
+		"gdbus call --session",
+		"--method org.freedesktop.Notifications.Notify",
+		`"Usage budget running out"`,
+		`"10m left of today's games budget (60m)."`,
+		"{'urgency': <byte 2>} 0",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("Expected %q in %s", want, cmd)
+		}
+	}
+}
+
+func TestBody(t *testing.T) {
+	for _, tc := range []struct {
+		m    notify.Message
+		want string
+	}{
+		{notify.Message{Event: "kill", Details: map[string]string{"app": "steam", "pid": "42"}}, "steam was closed because it is forbidden."},
+		{notify.Message{Event: "deadline_approaching", Details: map[string]string{"kind": "exception_end", "target": "discord", "in": "15m"}}, "The exception for discord ends in 15m."},
+		{notify.Message{Event: "forced_break", Details: map[string]string{"action": "shutdown", "in": "2m0s"}}, "The machine will shut down in 2m0s. Save your work."},
+		{notify.Message{Event: "session_freeze", Details: map[string]string{"in": "1m0s", "for": "15m0s"}}, "Your session freezes in 1m0s for 15m0s. Save your work."},
+		{notify.Message{Event: "domain_warning", Details: map[string]string{"domain": "reddit.com", "access": "2", "allowed": "3"}}, "Visit 2 of 3 to reddit.com today. The next one blocks it."},
+		{notify.Message{Event: "usb_device", Details: map[string]string{"kind": "storage", "id": "0781:5581", "action": "block"}}, "The storage device 0781:5581 was blocked until the lock ends."},
+		{notify.Message{Event: "unlock", Details: map[string]string{"source": "cli", "profile": "standard"}}, "profile: standard\nsource: cli"},
+	} {
+		if got := Body(tc.m); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.m.Event, tc.want, got)
+		}
+	}
+	if got := quote("a \"b\"\nc\\"); got != `"a \"b\"\nc\\"` {
+		t.Errorf("Unexpected quoting: %s", got)
+	}
+}
@@ -0,0 +1,272 @@
+// Package discord connects vexd to a private Discord channel.
+//
+// The bot posts keyholder events (kills, failures, unlocks, ...) to the
+// channel and runs the chat commands in package chatops that members post
+// there.  What a member may run is decided by their guild roles.  Only the
+// REST API is used: the channel is polled for new messages, so the daemon
+// needs nothing but outbound HTTPS.  The bot needs the Message Content
+// intent to read commands.
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+var (
+	// ConfigFile holds the bot token, channel and role permissions.
+	ConfigFile = "/etc/vex-cli/discord.json"
+
+	// APIBase is the Discord REST endpoint.
+	APIBase = "https://discord.com/api/v10"
+
+	// DefaultPoll is used when the config does not set poll_seconds.
+	DefaultPoll = 5 * time.Second
+
+	// roleCacheTTL bounds how stale a member's roles may be.
+	roleCacheTTL = time.Minute
+)
+
+// maxContent is Discord's message length limit.
+const maxContent = 2000
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Token       string              `json:"token"`
+	GuildID     string              `json:"guild_id"`
+	ChannelID   string              `json:"channel_id"`
+	Prefix      string              `json:"prefix,omitempty"` // default "!vex"
+	PollSeconds int                 `json:"poll_seconds,omitempty"`
+	Events      []string            `json:"events,omitempty"` // as in notify.json; empty sends all
+	Roles       chatops.Permissions `json:"roles"`            // role ID -> commands
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// bot is not configured and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks that the required fields are set and that every role
+// maps to known commands.
+func (c *Config) Validate() error {
+	if c.Token == "" || c.GuildID == "" || c.ChannelID == "" {
+		return fmt.Errorf("token, guild_id and channel_id are required")
+	}
+	if err := notify.ValidateFilter(c.Events); err != nil {
+		return err
+	}
+	return c.Roles.Validate()
+}
+
+func (c *Config) poll() time.Duration {
+	if c.PollSeconds > 0 {
+		return time.Duration(c.PollSeconds) * time.Second
+	}
+	return DefaultPoll
+}
+
+// Bot is a running Discord connection.
+type Bot struct {
+	cfg    *Config
+	bridge *chatops.Bridge
+	client *http.Client
+	lastID string // newest message seen
+
+	mu    sync.Mutex
+	roles map[string]cachedRoles // user ID -> roles
+}
+
+type cachedRoles struct {
+	roles   []string
+	fetched time.Time
+}
+
+type message struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+	Author  struct {
+		ID  string `json:"id"`
+		Bot bool   `json:"bot"`
+	} `json:"author"`
+}
+
+// Start registers the bot as a notification sink and starts polling the
+// channel.  Messages already in the channel are skipped, so old commands
+// are never replayed after a restart.
+func Start(c *Config, dispatch chatops.Dispatcher) (*Bot, error) {
+	b := &Bot{
+		cfg:    c,
+		bridge: chatops.NewBridge(c.Prefix, dispatch),
+		client: &http.Client{Timeout: 30 * time.Second},
+		roles:  make(map[string]cachedRoles),
+	}
+	latest, err := b.messages(url.Values{"limit": {"1"}})
+	if err != nil {
+		return nil, err
+	}
+	if len(latest) > 0 {
+		b.lastID = latest[0].ID
+	}
+
+	notify.AddSink(notify.Sink{Name: "discord", Events: c.Events, Send: func(m notify.Message) error {
+		return b.post(m.String(), "")
+	}})
+	supervisor.Go("discord", func() error {
+		for {
+			time.Sleep(c.poll())
+			if err := b.Poll(); err != nil {
+				log.Printf("Discord: poll failed: %v", err)
+			}
+		}
+	})
+	log.Printf("Discord: Bot watching channel %s", c.ChannelID)
+	return b, nil
+}
+
+// Poll runs the commands posted since the last poll, oldest first.
+func (b *Bot) Poll() error {
+	q := url.Values{"limit": {"50"}}
+	if b.lastID != "" {
+		q.Set("after", b.lastID)
+	}
+	msgs, err := b.messages(q)
+	if err != nil {
+		return err
+	}
+	for i := len(msgs) - 1; i >= 0; i-- { // the API returns newest first
+		m := msgs[i]
+		b.lastID = m.ID
+		if m.Author.Bot {
+			continue
+		}
+		reply, ok := b.bridge.Handle(m.Content, b.allowed(m.Author.ID))
+		if !ok {
+			continue
+		}
+		vexlog.LogEvent("DISCORD", "COMMAND", fmt.Sprintf("user=%s text=%q", m.Author.ID, m.Content))
+		if err := b.post(reply, m.ID); err != nil {
+			log.Printf("Discord: failed to reply: %v", err)
+		}
+	}
+	return nil
+}
+
+// allowed checks a member's commands against their guild roles.
+func (b *Bot) allowed(userID string) func(name string) bool {
+	roles, err := b.memberRoles(userID)
+	if err != nil {
+		log.Printf("Discord: could not look up roles of %s: %v", userID, err)
+		return func(string) bool { return false }
+	}
+	return b.cfg.Roles.Allowed(roles...)
+}
+
+func (b *Bot) memberRoles(userID string) ([]string, error) {
+	b.mu.Lock()
+	c, ok := b.roles[userID]
+	b.mu.Unlock()
+	if ok && time.Since(c.fetched) < roleCacheTTL {
+		return c.roles, nil
+	}
+
+	var member struct {
+		Roles []string `json:"roles"`
+	}
+	if err := b.do(http.MethodGet, "/guilds/"+b.cfg.GuildID+"/members/"+userID, nil, &member); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.roles[userID] = cachedRoles{roles: member.Roles, fetched: time.Now()}
+	b.mu.Unlock()
+	return member.Roles, nil
+}
+
+func (b *Bot) messages(q url.Values) ([]message, error) {
+	var msgs []message
+	err := b.do(http.MethodGet, "/channels/"+b.cfg.ChannelID+"/messages?"+q.Encode(), nil, &msgs)
+	return msgs, err
+}
+
+// post sends text to the channel, as a reply to replyTo if set.
+func (b *Bot) post(text, replyTo string) error {
+	if len(text) > maxContent {
+		text = text[:maxContent-1] + "…"
+	}
+	body := map[string]any{"content": text, "allowed_mentions": map[string]any{"parse": []string{}}}
+	if replyTo != "" {
+		body["message_reference"] = map[string]string{"message_id": replyTo}
+	}
+	return b.do(http.MethodPost, "/channels/"+b.cfg.ChannelID+"/messages", body, nil)
+}
+
+// do makes an API call, waiting out one rate limit if Discord asks.
+func (b *Bot) do(method, path string, in, out any) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, APIBase+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bot "+b.cfg.Token)
+		req.Header.Set("User-Agent", "DiscordBot (https://github.com/adumbdinosaur/vex-cli, 1.0)")
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt == 0 {
+			var rl struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.Unmarshal(data, &rl)
+			time.Sleep(time.Duration(rl.RetryAfter*1000) * time.Millisecond)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s %s: %s: %.200s", method, path, resp.Status, data)
+		}
+		if out != nil {
+			return json.Unmarshal(data, out)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+)
+
+// fakeDiscord serves one channel with the given messages (newest first)
+// and records what the bot posts.
+type fakeDiscord struct {
+	mu     sync.Mutex
+	msgs   []map[string]any
+	posted []map[string]any
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bot secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/channels/c1/messages":
+		json.NewEncoder(w).Encode(f.msgs)
+	case r.Method == http.MethodGet && r.URL.Path == "/guilds/g1/members/keyholder":
+		json.NewEncoder(w).Encode(map[string]any{"roles": []string{"r-keyholder"}})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/guilds/g1/members/"):
+		json.NewEncoder(w).Encode(map[string]any{"roles": []string{}})
+	case r.Method == http.MethodPost && r.URL.Path == "/channels/c1/messages":
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		f.posted = append(f.posted, body)
+		w.Write([]byte("{}"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func msg(id, author, content string, bot bool) map[string]any {
+	return map[string]any{"id": id, "content": content, "author": map[string]any{"id": author, "bot": bot}}
+}
+
+func TestPoll_RunsPermittedCommands(t *testing.T) {
+	fake := &fakeDiscord{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	APIBase = srv.URL
+	defer func() { APIBase = "https://discord.com/api/v10" }()
+
+	var got []string
+	b := &Bot{
+		cfg: &Config{Token: "secret", GuildID: "g1", ChannelID: "c1",
+			Roles: chatops.Permissions{"r-keyholder": {"*"}}},
+		bridge: chatops.NewBridge("", func(req *ipc.Request) *ipc.Response {
+			got = append(got, req.Command)
+			return &ipc.Response{OK: true, Message: "ok"}
+		}),
+		client: srv.Client(),
+		roles:  make(map[string]cachedRoles),
+		lastID: "100",
+	}
+	fake.msgs = []map[string]any{
+		msg("104", "bot", "!vex status", true),
+		msg("103", "stranger", "!vex cpu 100", false),
+		msg("102", "keyholder", "just chatting", false),
+		msg("101", "keyholder", "!vex throttle dial-up", false),
+	}
+
+	if err := b.Poll(); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if len(got) != 1 || got[0] != ipc.CmdThrottle {
+		t.Errorf("Expected only the keyholder's throttle to run, got %v", got)
+	}
+	if b.lastID != "104" {
+		t.Errorf("lastID = %s, want 104", b.lastID)
+	}
+	if len(fake.posted) != 2 {
+		t.Fatalf("Expected replies to the two commands, got %v", fake.posted)
+	}
+	ref, _ := fake.posted[0]["message_reference"].(map[string]any)
+	if fake.posted[0]["content"] != "ok" || ref["message_id"] != "101" {
+		t.Errorf("Unexpected reply %v", fake.posted[0])
+	}
+	if c, _ := fake.posted[1]["content"].(string); !strings.Contains(c, "not permitted") {
+		t.Errorf("Expected the stranger to be refused, got %v", fake.posted[1])
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	ok := Config{Token: "t", GuildID: "g", ChannelID: "c", Roles: chatops.Permissions{"r": {"status"}}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	for _, c := range []Config{
+		{GuildID: "g", ChannelID: "c"},
+		{Token: "t", GuildID: "g", ChannelID: "c", Roles: chatops.Permissions{"r": {"format-disk"}}},
+		{Token: "t", GuildID: "g", ChannelID: "c", Events: []string{"[kill"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
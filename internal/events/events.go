@@ -0,0 +1,242 @@
+// Package events is the daemon's internal event bus.
+//
+// Enforcement subsystems publish a typed event when something happens — a
+// forbidden process is killed, anti-tamper escalates the score, a penance
+// fails or completes, a line is rejected, a streak reaches a milestone, a
+// blackout ends, a network profile is applied, the heartbeat stops
+// getting through, a VPN turns up while blocks are on — and never need to
+// know who listens.
+// vexd subscribes to write them to the audit log, forward them to the
+// keyholder, count kills for the history and stream them to IPC
+// watchers.  Publishing with no subscribers, as in the CLI, does nothing.
+package events
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is anything published on the bus.
+type Event interface {
+	// Name is the event's notification name, such as "kill".
+	Name() string
+	// Details are its fields as strings, for logs and notifications.
+	Details() map[string]string
+}
+
+// Kill is published after a forbidden process has been killed, by either
+// the eBPF monitor or the /proc reaper.
+type Kill struct {
+	App string
+	PID int
+}
+
+func (Kill) Name() string { return "kill" }
+
+func (e Kill) Details() map[string]string {
+	return map[string]string{"app": e.App, "pid": strconv.Itoa(e.PID)}
+}
+
+// Escalation is published after anti-tamper has raised the failure score.
+// Escalations suppressed by the cooldown are not published.
+type Escalation struct {
+	Reasons []string
+	Score   int
+}
+
+func (Escalation) Name() string { return "escalation" }
+
+func (e Escalation) Details() map[string]string {
+	return map[string]string{"reasons": strings.Join(e.Reasons, "; "), "score": strconv.Itoa(e.Score)}
+}
+
+// Failure is published after a penance failure has been saved.  Reported
+// marks a failure the CLI recorded itself and reported to the daemon.
+// Topic is the essay topic drawn for the task the failure assigned, if
+// any.
+type Failure struct {
+	Reason        string
+	Category      string // penance.Categorize(Reason)
+	Score         int
+	TotalFailures int
+	Reported      bool
+	Topic         string
+}
+
+func (Failure) Name() string { return "failure" }
+
+func (e Failure) Details() map[string]string {
+	d := map[string]string{
+		"reason":         e.Reason,
+		"category":       e.Category,
+		"score":          strconv.Itoa(e.Score),
+		"total_failures": strconv.Itoa(e.TotalFailures),
+	}
+	if e.Topic != "" {
+		d["topic"] = e.Topic
+	}
+	return d
+}
+
+// Completion is published after a completed task has been saved.  Locked
+// is still true when a lock-until deadline outlasts the task.
+type Completion struct {
+	TotalCompleted int
+	Locked         bool
+}
+
+func (Completion) Name() string { return "completion" }
+
+func (e Completion) Details() map[string]string {
+	return map[string]string{
+		"total_completed": strconv.Itoa(e.TotalCompleted),
+		"locked":          strconv.FormatBool(e.Locked),
+	}
+}
+
+// BlackoutEnded is published when an input blackout expires or is broken
+// with the escape chord, but not when the daemon lifts it.
+type BlackoutEnded struct {
+	Escaped bool
+}
+
+func (BlackoutEnded) Name() string { return "blackout_ended" }
+
+func (e BlackoutEnded) Details() map[string]string {
+	return map[string]string{"escaped": strconv.FormatBool(e.Escaped)}
+}
+
+// ProfileApplied is published after the throttler has tried to apply a
+// network profile.  Error is empty on success.
+type ProfileApplied struct {
+	Profile string
+	Loss    float32 // entropy packet loss, 0 without
+	Error   string
+}
+
+func (ProfileApplied) Name() string { return "profile_applied" }
+
+func (e ProfileApplied) Details() map[string]string {
+	d := map[string]string{"profile": e.Profile}
+	if e.Loss > 0 {
+		d["loss"] = strconv.FormatFloat(float64(e.Loss), 'g', -1, 32)
+	}
+	if e.Error != "" {
+		d["error"] = e.Error
+	}
+	return d
+}
+
+// CheckinMissed is published when heartbeat check-ins have failed for
+// longer than the configured limit, once per outage.
+type CheckinMissed struct {
+	Since time.Time // last successful check-in, or the daemon's start
+	Error string    // why the latest check-in failed
+}
+
+func (CheckinMissed) Name() string { return "checkin_missed" }
+
+func (e CheckinMissed) Details() map[string]string {
+	return map[string]string{
+		"since": e.Since.UTC().Format(time.RFC3339),
+		"error": e.Error,
+	}
+}
+
+// CheckinResumed is published when check-ins succeed again after a
+// CheckinMissed.
+type CheckinResumed struct {
+	Down time.Duration
+}
+
+func (CheckinResumed) Name() string { return "checkin_resumed" }
+
+func (e CheckinResumed) Details() map[string]string {
+	return map[string]string{"down": e.Down.String()}
+}
+
+// LineRejected is published when a submitted line is rejected: a
+// writing-lines line that does not match the phrase, was pasted, broke
+// the task's per-line time limits, or was not typed on the keyboard, or a
+// penance line typed with a forbidden key.
+type LineRejected struct {
+	Task   string // "lines" or "penance"
+	Line   int    // the number the line would have had
+	Reason string
+}
+
+func (LineRejected) Name() string { return "line_rejected" }
+
+func (e LineRejected) Details() map[string]string {
+	return map[string]string{"task": e.Task, "line": strconv.Itoa(e.Line), "reason": e.Reason}
+}
+
+// StreakMilestone is published when the time since the last penance
+// failure or kill reaches a milestone, once per milestone and streak.
+type StreakMilestone struct {
+	Days  int
+	Since time.Time // the last failure or kill, or when streaks were first tracked
+}
+
+func (StreakMilestone) Name() string { return "streak" }
+
+func (e StreakMilestone) Details() map[string]string {
+	return map[string]string{"days": strconv.Itoa(e.Days), "since": e.Since.UTC().Format(time.RFC3339)}
+}
+
+// TunnelDetected is published when the guardian's tunnel watch finds a
+// tunnel interface or proxy connection while blocks are in force.
+// Response lists what was done about it.
+type TunnelDetected struct {
+	Target   string // the interface, or the relay's address and port
+	Detail   string // the link kind, or why the connection looks like a proxy
+	Response []string
+}
+
+func (TunnelDetected) Name() string { return "tunnel_detected" }
+
+func (e TunnelDetected) Details() map[string]string {
+	return map[string]string{"target": e.Target, "detail": e.Detail, "response": strings.Join(e.Response, ",")}
+}
+
+type subscriber struct {
+	id int
+	fn func(Event)
+}
+
+var (
+	mu     sync.Mutex
+	nextID int
+	subs   []subscriber
+)
+
+// Subscribe calls fn for every event published from now on and returns a
+// function that stops it.  fn runs on the publisher's goroutine, possibly
+// with the daemon's state lock held, so it must return quickly and must
+// not wait on the IPC server.
+func Subscribe(fn func(Event)) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	subs = append(subs, subscriber{id, fn})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		subs = slices.DeleteFunc(slices.Clone(subs), func(s subscriber) bool { return s.id == id })
+	}
+}
+
+// Publish hands e to every subscriber in the order they subscribed.
+// Subscribers may publish further events.
+func Publish(e Event) {
+	mu.Lock()
+	list := subs
+	mu.Unlock()
+	for _, s := range list {
+		s.fn(e)
+	}
+}
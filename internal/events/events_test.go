@@ -0,0 +1,61 @@
+package events
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPublish_OrderAndUnsubscribe(t *testing.T) {
+	var got []string
+	stopA := Subscribe(func(e Event) { got = append(got, "a:"+e.Name()) })
+	stopB := Subscribe(func(e Event) { got = append(got, "b:"+e.Name()) })
+	defer stopB()
+
+	Publish(Kill{App: "steam", PID: 42})
+	stopA()
+	Publish(Completion{TotalCompleted: 3})
+
+	want := []string{"a:kill", "b:kill", "b:completion"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestPublish_FromSubscriber(t *testing.T) {
+	var got []string
+	defer Subscribe(func(e Event) {
+		got = append(got, e.Name())
+		if b, ok := e.(BlackoutEnded); ok && b.Escaped {
+			Publish(Failure{Reason: "input_blackout_escape", Score: 10, TotalFailures: 1})
+		}
+	})()
+
+	Publish(BlackoutEnded{Escaped: true})
+	if !slices.Equal(got, []string{"blackout_ended", "failure"}) {
+		t.Errorf("Expected a nested publish to be delivered, got %v", got)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	for _, tc := range []struct {
+		e    Event
+		key  string
+		want string
+	}{
+		{Kill{App: "steam", PID: 42}, "pid", "42"},
+		{Escalation{Reasons: []string{"binary modified", "unit disabled"}, Score: 40}, "reasons", "binary modified; unit disabled"},
+		{Failure{Reason: "timeout", Score: 20, TotalFailures: 2}, "total_failures", "2"},
+		{Completion{TotalCompleted: 3, Locked: true}, "locked", "true"},
+		{ProfileApplied{Profile: "choke", Loss: 2.5}, "loss", "2.5"},
+		{ProfileApplied{Profile: "choke", Error: "no such device"}, "error", "no such device"},
+		{LineRejected{Task: "lines", Line: 4, Reason: "mismatch"}, "line", "4"},
+		{StreakMilestone{Days: 7}, "days", "7"},
+	} {
+		if got := tc.e.Details()[tc.key]; got != tc.want {
+			t.Errorf("%s: expected %s=%q, got %q", tc.e.Name(), tc.key, tc.want, got)
+		}
+	}
+	if _, ok := (ProfileApplied{Profile: "standard"}).Details()["error"]; ok {
+		t.Error("Expected no error detail on success")
+	}
+}
@@ -0,0 +1,230 @@
+// Package extension serves the companion API a browser extension talks
+// to.  The firewall blocks by address, so it cannot tell one path on a
+// site from another, and screen time is only known per app.  The
+// extension asks for the blocklist, closing tabs on a blocked domain or
+// under a blocked URL while the blocks are in force, and reports the time
+// its focused tab spends on each site, which is added to the daily usage
+// totals.
+//
+// The listener binds to localhost unless extension.json says otherwise,
+// and every request must carry the configured token.
+package extension
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+var (
+	// ConfigFile holds the listen address, token and blocked URLs.
+	// Optional.
+	ConfigFile = "/etc/vex-cli/extension.json"
+
+	// DefaultListen is used when the config does not set listen.
+	DefaultListen = "127.0.0.1:7109"
+
+	// MaxReport bounds the seconds one visit report may credit.
+	MaxReport = 10 * time.Minute
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Listen      string   `json:"listen,omitempty"`       // host:port, default DefaultListen
+	Token       string   `json:"token"`                  // required as "Authorization: Bearer <token>"
+	BlockedURLs []string `json:"blocked_urls,omitempty"` // host[/path prefix], e.g. youtube.com/shorts
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// API is not served and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the listen address, requires a token and normalises
+// the blocked URLs to lower-case host and path.
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		c.Listen = DefaultListen
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen must be host:port: %w", err)
+	}
+	if c.Token == "" {
+		return errors.New("token is required: any local process can reach the API")
+	}
+	for i, u := range c.BlockedURLs {
+		if strings.Contains(u, "://") {
+			return fmt.Errorf("blocked_urls: %q must be host/path, without a scheme", u)
+		}
+		u = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(u), "www."))
+		if u == "" || strings.HasPrefix(u, "/") {
+			return fmt.Errorf("blocked_urls: %q has no host", c.BlockedURLs[i])
+		}
+		c.BlockedURLs[i] = u
+	}
+	return nil
+}
+
+// Source supplies what the daemon holds.
+type Source struct {
+	// Blocklist reports whether the blocks are in force and the domain
+	// names blocked.
+	Blocklist func() (active bool, domains []string)
+	// Visit credits time on a site to today's usage.
+	Visit func(site string, elapsed time.Duration)
+}
+
+// Start serves the API on c.Listen in the background.
+func Start(c *Config, src Source) error {
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: Handler(c, src), ReadHeaderTimeout: 10 * time.Second}
+	supervisor.Go("extension", func() error {
+		if ln == nil { // restarted: listen again
+			if ln, err = net.Listen("tcp", c.Listen); err != nil {
+				return err
+			}
+		}
+		err := srv.Serve(ln)
+		ln = nil
+		return fmt.Errorf("server stopped: %w", err)
+	})
+	log.Printf("Extension: Serving on %s", ln.Addr())
+	return nil
+}
+
+// Blocklist is what the extension enforces.  Both lists are empty while
+// the blocks are not in force.
+type Blocklist struct {
+	Active  bool     `json:"active"`
+	Domains []string `json:"domains"` // the domain and every name below it
+	URLs    []string `json:"urls"`    // host/path prefixes
+}
+
+// Visit is a report of time spent on a URL.
+type Visit struct {
+	URL     string  `json:"url"`
+	Seconds float64 `json:"seconds,omitempty"` // since the last report for the tab
+}
+
+// Verdict answers a Visit.
+type Verdict struct {
+	Blocked bool   `json:"blocked"`
+	Rule    string `json:"rule,omitempty"` // the domain or URL that blocks it
+}
+
+// Handler serves the API.  The token must be presented as a bearer token
+// on every request.
+func Handler(c *Config, src Source) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/blocklist", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.blocklist(src))
+	})
+	mux.HandleFunc("POST /api/visit", func(w http.ResponseWriter, r *http.Request) {
+		var v Visit
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&v); err != nil {
+			http.Error(w, "malformed visit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(v.URL)
+		if err != nil || u.Hostname() == "" {
+			http.Error(w, "url must be absolute", http.StatusBadRequest)
+			return
+		}
+		if v.Seconds < 0 || v.Seconds > MaxReport.Seconds() {
+			http.Error(w, fmt.Sprintf("seconds must be between 0 and %.0f", MaxReport.Seconds()), http.StatusBadRequest)
+			return
+		}
+		site := siteOf(u)
+		if v.Seconds > 0 {
+			src.Visit(site, time.Duration(v.Seconds*float64(time.Second)))
+		}
+		verdict := c.blocklist(src).check(site, u.EscapedPath())
+		if verdict.Blocked {
+			vexlog.LogEvent("EXTENSION", "URL_BLOCKED", fmt.Sprintf("site=%s rule=%s", site, verdict.Rule))
+		}
+		writeJSON(w, verdict)
+	})
+
+	want := []byte("Bearer " + c.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (c *Config) blocklist(src Source) Blocklist {
+	active, domains := src.Blocklist()
+	if !active {
+		return Blocklist{Domains: []string{}, URLs: []string{}}
+	}
+	b := Blocklist{Active: true, Domains: []string{}, URLs: []string{}}
+	for _, d := range domains {
+		b.Domains = append(b.Domains, strings.TrimPrefix(strings.ToLower(d), "www."))
+	}
+	b.URLs = append(b.URLs, c.BlockedURLs...)
+	return b
+}
+
+// check finds the rule, if any, that blocks path on site.
+func (b Blocklist) check(site, path string) Verdict {
+	for _, d := range b.Domains {
+		if onHost(site, d) {
+			return Verdict{Blocked: true, Rule: d}
+		}
+	}
+	for _, rule := range b.URLs {
+		host, prefix, _ := strings.Cut(rule, "/")
+		if onHost(site, host) && strings.HasPrefix(strings.ToLower(path), "/"+prefix) {
+			return Verdict{Blocked: true, Rule: rule}
+		}
+	}
+	return Verdict{}
+}
+
+// onHost reports whether site is host or a name below it.
+func onHost(site, host string) bool {
+	return site == host || strings.HasSuffix(site, "."+host)
+}
+
+// siteOf is the lower-case host of u, without a leading www.
+func siteOf(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,96 @@
+package extension
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func do(t *testing.T, h http.Handler, method, path, token, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	c := &Config{Token: "s3cret", BlockedURLs: []string{"www.YouTube.com/shorts", "news.example.com"}}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	active := true
+	visits := make(map[string]time.Duration)
+	h := Handler(c, Source{
+		Blocklist: func() (bool, []string) { return active, []string{"www.reddit.com", "twitch.tv"} },
+		Visit:     func(site string, d time.Duration) { visits[site] += d },
+	})
+
+	if code := do(t, h, "GET", "/api/blocklist", "wrong", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong token to be refused, got %d", code)
+	}
+
+	var b Blocklist
+	do(t, h, "GET", "/api/blocklist", "s3cret", "", &b)
+	if !b.Active || strings.Join(b.Domains, ",") != "reddit.com,twitch.tv" || strings.Join(b.URLs, ",") != "youtube.com/shorts,news.example.com" {
+		t.Errorf("Unexpected blocklist %+v", b)
+	}
+
+	for url, rule := range map[string]string{
+		"https://old.reddit.com/r/all":          "reddit.com",
+		"https://www.youtube.com/shorts/abc123": "youtube.com/shorts",
+		"https://m.youtube.com/Shorts/x":        "youtube.com/shorts",
+		"https://news.example.com/":             "news.example.com",
+		"https://www.youtube.com/watch?v=1":     "",
+		"https://example.com/":                  "",
+	} {
+		var v Verdict
+		if code := do(t, h, "POST", "/api/visit", "s3cret", `{"url": "`+url+`", "seconds": 30}`, &v); code != http.StatusOK {
+			t.Fatalf("%s: status %d", url, code)
+		}
+		if v.Blocked != (rule != "") || v.Rule != rule {
+			t.Errorf("%s: expected rule %q, got %+v", url, rule, v)
+		}
+	}
+	if visits["youtube.com"] != 60*time.Second || visits["m.youtube.com"] != 30*time.Second {
+		t.Errorf("Unexpected site time %v", visits)
+	}
+
+	for _, body := range []string{`{"url": "/r/all"}`, `{"url": "https://a.com", "seconds": -1}`, `{"url": "https://a.com", "seconds": 3600}`, `{`} {
+		if code := do(t, h, "POST", "/api/visit", "s3cret", body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+
+	active = false
+	var v Verdict
+	do(t, h, "POST", "/api/visit", "s3cret", `{"url": "https://reddit.com/"}`, &v)
+	do(t, h, "GET", "/api/blocklist", "s3cret", "", &b)
+	if v.Blocked || b.Active || len(b.Domains)+len(b.URLs) != 0 {
+		t.Errorf("Expected nothing blocked while the blocks are off, got %+v, %+v", v, b)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{Token: "x", Listen: "7109"},
+		{Token: "x", BlockedURLs: []string{"https://reddit.com"}},
+		{Token: "x", BlockedURLs: []string{"/shorts"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
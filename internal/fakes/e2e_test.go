@@ -0,0 +1,338 @@
+package fakes_test
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/fakes"
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// These tests build vexd and vex-cli with the vextest tag and run them
+// against the fake kernel, one daemon per test.
+
+var (
+	binDir   string
+	buildErr error
+	build    sync.Once
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if binDir != "" {
+		os.RemoveAll(binDir)
+	}
+	os.Exit(code)
+}
+
+// binaries builds vexd and vex-cli once, side by side, as vexd trusts
+// only the vex-cli next to it.
+func binaries(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds and runs the daemon")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain")
+	}
+	build.Do(func() {
+		if binDir, buildErr = os.MkdirTemp("", "vex-e2e-"); buildErr != nil {
+			return
+		}
+		for _, name := range []string{"vexd", "vex-cli"} {
+			cmd := exec.Command("go", "build", "-tags", "vextest", "-o", filepath.Join(binDir, name),
+				"github.com/adumbdinosaur/vex-cli/cmd/"+name)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				buildErr = fmt.Errorf("building %s: %v\n%s", name, err, out)
+				return
+			}
+		}
+	})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return binDir
+}
+
+// daemon is a test-mode vexd and the root it runs under.
+type daemon struct {
+	t    *testing.T
+	bin  string
+	root string
+	env  []string
+}
+
+// start runs vexd with args under a fresh root holding files, by path
+// under the root, and waits for its socket.
+func start(t *testing.T, files map[string]string, args ...string) *daemon {
+	t.Helper()
+	d := &daemon{t: t, bin: binaries(t), root: t.TempDir()}
+
+	// No desktop notifications from the tests.
+	files["etc/vex-cli/desktop.json"] = `{"disabled": true}`
+	for name, data := range files {
+		path := filepath.Join(d.root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "VEX_") {
+			d.env = append(d.env, kv)
+		}
+	}
+	d.env = append(d.env, "VEX_TEST_ROOT="+d.root, "NO_COLOR=1")
+
+	logPath := filepath.Join(d.root, "vexd.out")
+	out, err := os.Create(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(filepath.Join(d.bin, "vexd"), args...)
+	cmd.Env, cmd.Stdout, cmd.Stderr = d.env, out, out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() { cmd.Wait(); out.Close(); close(exited) }()
+
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			<-exited
+		}
+		if t.Failed() {
+			data, _ := os.ReadFile(logPath)
+			t.Logf("vexd output:\n%s", data)
+		}
+	})
+
+	socket := filepath.Join(d.root, state.SocketPath)
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return d
+		}
+		select {
+		case <-exited:
+			t.Fatal("vexd exited before it was ready")
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("vexd did not open its socket")
+		}
+	}
+}
+
+// cli runs vex-cli and returns its output and exit status.
+func (d *daemon) cli(args ...string) (string, int) {
+	d.t.Helper()
+	cmd := exec.Command(filepath.Join(d.bin, "vex-cli"), args...)
+	cmd.Env = d.env
+	out, err := cmd.CombinedOutput()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		return string(out), exit.ExitCode()
+	case err != nil:
+		d.t.Fatalf("vex-cli %s: %v", strings.Join(args, " "), err)
+	}
+	return string(out), 0
+}
+
+// send sends req to the daemon directly, as vex-cli's interactive
+// commands would.
+func (d *daemon) send(req *ipc.Request) *ipc.Response {
+	d.t.Helper()
+	resp, err := ipc.NewClient(filepath.Join(d.root, state.SocketPath)).Send(req)
+	if err != nil {
+		d.t.Fatalf("%s: %v", req.Command, err)
+	}
+	return resp
+}
+
+func (d *daemon) state() *state.SystemState {
+	d.t.Helper()
+	resp := d.send(&ipc.Request{Command: ipc.CmdStatus})
+	if !resp.OK || resp.State == nil {
+		d.t.Fatalf("status: %s", resp.Error)
+	}
+	return resp.State
+}
+
+func (d *daemon) kernel() *fakes.Snapshot {
+	d.t.Helper()
+	k, err := fakes.ReadSnapshot(d.root)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	return k
+}
+
+func TestThrottle(t *testing.T) {
+	d := start(t, map[string]string{})
+
+	if out, code := d.cli("throttle", "choke"); code != 0 {
+		t.Fatalf("throttle choke: exit %d\n%s", code, out)
+	}
+	if got := d.state().Network.Profile; got != "choke" {
+		t.Errorf("profile = %q, want choke", got)
+	}
+	q := d.kernel().Qdiscs[fakes.Interface]
+	if len(q) != 1 || q[0].Type != "tbf" || q[0].Rate != 125000 {
+		t.Errorf("choke qdiscs = %+v, want one tbf at 125000 B/s", q)
+	}
+
+	if out, code := d.cli("throttle", "dial-up"); code != 0 {
+		t.Fatalf("throttle dial-up: exit %d\n%s", code, out)
+	}
+	q = d.kernel().Qdiscs[fakes.Interface]
+	if len(q) != 1 || q[0].Type != "netem" || q[0].Rate != 7000 {
+		t.Errorf("dial-up qdiscs = %+v, want one netem at 7000 B/s", q)
+	}
+
+	if _, code := d.cli("throttle", "warp"); code != 4 {
+		t.Errorf("throttle warp: exit %d, want 4", code)
+	}
+}
+
+func TestLines(t *testing.T) {
+	d := start(t, map[string]string{})
+
+	if out, code := d.cli("lines", "set", "2", "I", "will", "focus."); code != 0 {
+		t.Fatalf("lines set: exit %d\n%s", code, out)
+	}
+	w := d.state().Writing
+	if !w.Active || w.Phrase != "I will focus." || w.Required != 2 {
+		t.Fatalf("task = %+v, want 2 x %q", w, "I will focus.")
+	}
+
+	submit := func(line string) *ipc.Response {
+		return d.send(&ipc.Request{Command: ipc.CmdLinesSubmit, Args: map[string]string{"line": line}})
+	}
+	if resp := submit("I will focus later."); resp.OK {
+		t.Error("a wrong line was accepted")
+	}
+	for i := 0; i < 2; i++ {
+		if resp := submit("I will focus."); !resp.OK {
+			t.Fatalf("line %d: %s", i+1, resp.Error)
+		}
+	}
+
+	s := d.state()
+	if s.Writing.Active {
+		t.Errorf("task still active after every line: %+v", s.Writing)
+	}
+	if s.Compliance.Locked || s.Compliance.TaskStatus != "completed" {
+		t.Errorf("compliance = %+v, want unlocked and completed", s.Compliance)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := strings.TrimPrefix(security.PublicKeyFile, "/")
+	d := start(t, map[string]string{keyFile: hex.EncodeToString(pub) + "\n"})
+
+	if out, code := d.cli("throttle", "choke"); code != 0 {
+		t.Fatalf("throttle choke: exit %d\n%s", code, out)
+	}
+
+	sign := func(key ed25519.PrivateKey) string {
+		cmd := security.SignedCommand{Command: "unlock", Timestamp: time.Now().Unix()}
+		msg := fmt.Sprintf("%s:%s:%d", cmd.Command, cmd.Args, cmd.Timestamp)
+		cmd.Signature = hex.EncodeToString(ed25519.Sign(key, []byte(msg)))
+		data, _ := json.Marshal(cmd)
+		return string(data)
+	}
+
+	_, other, _ := ed25519.GenerateKey(nil)
+	if _, code := d.cli("unlock", sign(other)); code != 3 {
+		t.Errorf("unlock signed by another key: exit %d, want 3", code)
+	}
+	if got := d.state().Network.Profile; got != "choke" {
+		t.Fatalf("profile = %q after a refused unlock, want choke", got)
+	}
+
+	if out, code := d.cli("unlock", sign(priv)); code != 0 {
+		t.Fatalf("unlock: exit %d\n%s", code, out)
+	}
+	s := d.state()
+	if s.Network.Profile != "standard" || s.Compliance.Locked {
+		t.Errorf("after unlock: profile %q, locked %v", s.Network.Profile, s.Compliance.Locked)
+	}
+	k := d.kernel()
+	if q := k.Qdiscs[fakes.Interface]; len(q) != 0 {
+		t.Errorf("qdiscs after unlock = %+v, want none", q)
+	}
+	if k.Firewall {
+		t.Error("firewall still up after unlock")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	d := start(t, map[string]string{}, "--simulate")
+
+	if out, code := d.cli("lockuntil", "48h"); code != 0 {
+		t.Fatalf("lockuntil: exit %d\n%s", code, out)
+	}
+	if s := d.state(); !s.Compliance.Locked || s.Compliance.LockUntil == "" {
+		t.Fatalf("compliance = %+v, want locked with a deadline", s.Compliance)
+	}
+
+	if out, code := d.cli("clock", "advance", "47h"); code != 0 {
+		t.Fatalf("clock advance 47h: exit %d\n%s", code, out)
+	}
+	if s := d.state(); !s.Compliance.Locked {
+		t.Error("unlocked an hour before the deadline")
+	}
+	if out, code := d.cli("clock", "advance", "2h"); code != 0 {
+		t.Fatalf("clock advance 2h: exit %d\n%s", code, out)
+	}
+	if s := d.state(); s.Compliance.Locked || s.Compliance.LockUntil != "" {
+		t.Errorf("compliance = %+v after the deadline, want unlocked", s.Compliance)
+	}
+
+	resp := d.send(&ipc.Request{Command: ipc.CmdClock})
+	if resp.Clock == nil {
+		t.Fatal("no clock in the response")
+	}
+	now, err := time.Parse(time.RFC3339, resp.Clock.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead := time.Until(now); ahead < 48*time.Hour || ahead > 50*time.Hour {
+		t.Errorf("virtual clock %s ahead, want 49h", ahead.Round(time.Minute))
+	}
+}
+
+func TestClockRefusedOnRealTime(t *testing.T) {
+	d := start(t, map[string]string{})
+	if _, code := d.cli("clock", "advance", "1h"); code != 4 {
+		t.Errorf("clock advance without --simulate: exit %d, want 4", code)
+	}
+}
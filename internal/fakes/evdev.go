@@ -0,0 +1,100 @@
+package fakes
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	evdev "github.com/holoplot/go-evdev"
+
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+)
+
+// Keyboards are named pipes in root/dev/input that carry input events as
+// the kernel lays them out, struct input_event.
+
+// AddKeyboard plugs in a keyboard: a pipe named name, e.g. "event0".
+func AddKeyboard(root, name string) error {
+	path := filepath.Join(root, "dev/input", name)
+	if err := syscall.Mkfifo(path, 0o600); err != nil && !os.IsExist(err) {
+		return &os.PathError{Op: "mkfifo", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Evdev finds the keyboards under Root.
+type Evdev struct {
+	Root string
+}
+
+func (e *Evdev) ListInputDevices() ([]surveillance.InputDevice, error) {
+	paths, err := filepath.Glob(filepath.Join(e.Root, "dev/input/event*"))
+	if err != nil {
+		return nil, err
+	}
+	var devices []surveillance.InputDevice
+	for _, p := range paths {
+		if d, err := e.Open(p); err == nil {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+// Open opens the keyboard at path, under Root or, as hotplug names it,
+// in /dev/input.
+func (e *Evdev) Open(path string) (surveillance.InputDevice, error) {
+	path = FS{Root: e.Root}.path(path)
+	// Read and write, so that the pipe neither blocks the open nor ends
+	// when a test closes its end.
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyboard{f: f}, nil
+}
+
+func (e *Evdev) CreateVirtual(name string, src surveillance.InputDevice) (surveillance.VirtualDevice, error) {
+	return discard{}, nil
+}
+
+// Keyboard is a keyboard whose events come from its pipe.
+type Keyboard struct {
+	f *os.File
+}
+
+func (k *Keyboard) ReadOne() (*evdev.InputEvent, error) {
+	var ev evdev.InputEvent
+	if err := binary.Read(k.f, binary.NativeEndian, &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (k *Keyboard) Close() error { return k.f.Close() }
+func (k *Keyboard) Name() string {
+	return "Fake Keyboard " + strings.TrimPrefix(filepath.Base(k.f.Name()), "event")
+}
+func (k *Keyboard) Fn() string    { return k.f.Name() }
+func (k *Keyboard) Grab() error   { return nil }
+func (k *Keyboard) Ungrab() error { return nil }
+
+func (k *Keyboard) InputID() (evdev.InputID, error) {
+	return evdev.InputID{BusType: 0x03, Vendor: 0x1d6b, Product: 0x0104}, nil // USB
+}
+
+func (k *Keyboard) Capabilities() map[evdev.EvType][]evdev.EvCode {
+	var keys []evdev.EvCode
+	for code := evdev.EvCode(evdev.KEY_ESC); code <= evdev.KEY_SPACE; code++ {
+		keys = append(keys, code)
+	}
+	return map[evdev.EvType][]evdev.EvCode{evdev.EV_SYN: nil, evdev.EV_KEY: keys}
+}
+
+// discard is the uinput clone of a keyboard, whose events go nowhere.
+type discard struct{}
+
+func (discard) WriteOne(*evdev.InputEvent) error { return nil }
+func (discard) Close() error                     { return nil }
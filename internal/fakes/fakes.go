@@ -0,0 +1,127 @@
+// Package fakes is a kernel for vexd to run against without root: links
+// and qdiscs, the firewall, processes, thread priorities, the pseudo
+// filesystems and keyboards, all kept in memory or in files under one
+// directory.  With the vextest build tag, vexd and vex-cli run in test
+// mode when VEX_TEST_ROOT names such a directory (see their testmode.go),
+// so that the tests here can drive the CLI ↔ daemon flows end to end.
+//
+// The state of the fake kernel is written to SnapshotFile under the root
+// after every change, for the tests to check.
+package fakes
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adumbdinosaur/vex-cli/internal/blackout"
+	"github.com/adumbdinosaur/vex-cli/internal/calendar"
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	"github.com/adumbdinosaur/vex-cli/internal/checkpoint"
+	"github.com/adumbdinosaur/vex-cli/internal/config"
+	"github.com/adumbdinosaur/vex-cli/internal/credits"
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+	"github.com/adumbdinosaur/vex-cli/internal/discord"
+	"github.com/adumbdinosaur/vex-cli/internal/extension"
+	"github.com/adumbdinosaur/vex-cli/internal/gpu"
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/heartbeat"
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	"github.com/adumbdinosaur/vex-cli/internal/hostsync"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/matrix"
+	"github.com/adumbdinosaur/vex-cli/internal/media"
+	"github.com/adumbdinosaur/vex-cli/internal/messages"
+	"github.com/adumbdinosaur/vex-cli/internal/modules"
+	"github.com/adumbdinosaur/vex-cli/internal/mqtt"
+	"github.com/adumbdinosaur/vex-cli/internal/netacct"
+	"github.com/adumbdinosaur/vex-cli/internal/notice"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/push"
+	"github.com/adumbdinosaur/vex-cli/internal/report"
+	"github.com/adumbdinosaur/vex-cli/internal/seat"
+	"github.com/adumbdinosaur/vex-cli/internal/security"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/stats"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+	"github.com/adumbdinosaur/vex-cli/internal/tiers"
+	"github.com/adumbdinosaur/vex-cli/internal/usb"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// Interface is the uplink of the fake kernel, for VEX_INTERFACE.
+const Interface = "eth0"
+
+// Reroot moves every file vex-cli and vexd read or write, their own and
+// the kernel's, under root: /etc/vex-cli/config.json becomes
+// root/etc/vex-cli/config.json.  It is called before anything is read.
+func Reroot(root string) {
+	paths := []*string{
+		// State, the socket and the log
+		&state.StateDir, &state.StateFile, &state.SpoolDir, &state.SocketPath,
+		&vexlog.LogFilePath, &vexlog.RotationConfigFile, &vexlog.ForwardConfigFile,
+		&history.File, &throttler.StateFile, &throttler.PrioritySavedFile,
+		&surveillance.MetricsFile, &surveillance.UsageFile, &surveillance.UsageRulesFile,
+		&surveillance.TypingProfileFile, &guardian.OOMSavedFile, &hostsync.LedgerFile,
+		&chatops.UsedFile,
+
+		// Configuration and the keyholder's files
+		&config.File, &modules.ConfigFile, &penance.ConfigDir, &penance.ManifestFile,
+		&penance.ComplianceStatusFile, &penance.ScoreHistoryFile, &security.PublicKeyFile,
+		&blackout.ConfigFile, &blackout.SavedFile, &calendar.ConfigFile, &calendar.CacheFile,
+		&checkpoint.ConfigFile, &checkpoint.KeyFile, &checkpoint.File, &credits.ConfigFile,
+		&desktop.ConfigFile, &discord.ConfigFile, &extension.ConfigFile, &gpu.SavedFile,
+		&heartbeat.ConfigFile, &matrix.ConfigFile, &messages.ConfigFile,
+		&messages.MOTDFile, &mqtt.ConfigFile, &notice.ConfigFile, &notice.MOTDFile,
+		&notice.WallpaperDir, &notice.SavedFile, &notify.ConfigFile, &push.ConfigFile,
+		&report.ConfigFile, &seat.BreakConfigFile, &stats.ConfigFile, &tiers.ConfigFile,
+		&usb.ConfigFile, &usb.BlockedFile,
+
+		// The kernel's, for what is read without the ops
+		&users.ProcDir, &users.SeatFile, &media.BacklightDir, &gpu.DRMDir, &usb.SysDir,
+		&netacct.CgroupRoot,
+	}
+	for _, p := range paths {
+		*p = filepath.Join(root, *p)
+	}
+	for i, f := range guardian.ResolvConfFiles {
+		guardian.ResolvConfFiles[i] = filepath.Join(root, f)
+	}
+	// So that root can be removed.
+	vexlog.AppendOnly = false
+}
+
+// Install puts a fresh fake kernel under root in place of the real one:
+// one uplink, Interface, with the default route, no firewall, an empty
+// process table and one keyboard.  Call Reroot first.
+func Install(root string) error {
+	for _, dir := range []string{"proc/self", "sys/fs/cgroup", "dev/input"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	for name, data := range map[string]string{
+		"proc/self/oom_score_adj": "0\n",
+		"sys/fs/cgroup/cpu.max":   "max 100000\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0o644); err != nil {
+			return err
+		}
+	}
+	if err := AddKeyboard(root, "event0"); err != nil {
+		return err
+	}
+
+	k := NewKernel(root)
+	if err := k.save(); err != nil {
+		return fmt.Errorf("failed to write the snapshot: %w", err)
+	}
+	fs := FS{Root: root}
+	throttler.SetOps(&Netlink{k}, fs, &Priority{k})
+	blackout.SetOps(&Netlink{k})
+	guardian.SetOps(fs, &System{k}, &Firewall{k})
+	surveillance.SetOps(&Evdev{Root: root})
+	return nil
+}
@@ -0,0 +1,33 @@
+package fakes
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS is the kernel's pseudo filesystems, /proc, /sys and /dev, under
+// Root.  Other names, such as the daemon's own files (see Reroot) or the
+// lists the guardian reads from its working directory, are left as they
+// are.  It is the file operations of the guardian and the throttler.
+type FS struct {
+	Root string
+}
+
+func (f FS) path(name string) string {
+	for _, dir := range []string{"/proc", "/sys", "/dev"} {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			return filepath.Join(f.Root, name)
+		}
+	}
+	return name
+}
+
+func (f FS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(f.path(name)) }
+func (f FS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(f.path(name)) }
+func (f FS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(f.path(name), data, perm)
+}
+func (f FS) Stat(name string) (os.FileInfo, error)        { return os.Stat(f.path(name)) }
+func (f FS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(f.path(path), perm) }
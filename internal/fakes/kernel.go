@@ -0,0 +1,278 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// SnapshotFile is where, under the root, the kernel's state is written.
+const SnapshotFile = "kernel.json"
+
+// Snapshot is the contents of SnapshotFile.
+type Snapshot struct {
+	Links    map[string]bool    `json:"links"`    // administratively up, by name
+	Qdiscs   map[string][]Qdisc `json:"qdiscs"`   // by link name
+	Firewall bool               `json:"firewall"` // the table is in place
+	Blocked  []string           `json:"blocked"`  // domains it blocks
+	Killed   []int              `json:"killed"`   // pids, in order
+}
+
+// Qdisc is a qdisc as the tests see it.
+type Qdisc struct {
+	Type   string `json:"type"`
+	Handle string `json:"handle"`         // e.g. "1:0"
+	Parent string `json:"parent"`         // e.g. "root"
+	Rate   uint64 `json:"rate,omitempty"` // bytes/s
+	Loss   uint32 `json:"loss,omitempty"` // as netlink.Netem has it
+}
+
+// ReadSnapshot reads the state of the fake kernel under root.
+func ReadSnapshot(root string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(root, SnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Kernel is the state behind the fake operations.
+type Kernel struct {
+	root string
+
+	mu       sync.Mutex
+	links    []netlink.Link
+	qdiscs   map[int][]netlink.Qdisc // by link index
+	filters  map[int][]netlink.Filter
+	firewall bool
+	blocked  []string
+	killed   []int
+	prio     map[int]throttler.TaskPriority // by tid
+}
+
+// NewKernel returns a kernel with the loopback and Interface, both up.
+func NewKernel(root string) *Kernel {
+	return &Kernel{
+		root: root,
+		links: []netlink.Link{
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 1, Name: "lo", Flags: net.FlagUp | net.FlagLoopback}},
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 2, Name: Interface, Flags: net.FlagUp}},
+		},
+		qdiscs:  make(map[int][]netlink.Qdisc),
+		filters: make(map[int][]netlink.Filter),
+		prio:    make(map[int]throttler.TaskPriority),
+	}
+}
+
+// save writes SnapshotFile.  The caller holds k.mu.
+func (k *Kernel) save() error {
+	snap := Snapshot{
+		Links:    make(map[string]bool),
+		Qdiscs:   make(map[string][]Qdisc),
+		Firewall: k.firewall,
+		Blocked:  k.blocked,
+		Killed:   k.killed,
+	}
+	for _, link := range k.links {
+		name := link.Attrs().Name
+		snap.Links[name] = link.Attrs().Flags&net.FlagUp != 0
+		for _, q := range k.qdiscs[link.Attrs().Index] {
+			snap.Qdiscs[name] = append(snap.Qdiscs[name], describe(q))
+		}
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Renamed into place, so that a test never reads half of it.
+	file := filepath.Join(k.root, SnapshotFile)
+	if err := os.WriteFile(file+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func describe(q netlink.Qdisc) Qdisc {
+	d := Qdisc{Type: q.Type(), Handle: netlink.HandleStr(q.Attrs().Handle), Parent: netlink.HandleStr(q.Attrs().Parent)}
+	switch q := q.(type) {
+	case *netlink.Tbf:
+		d.Rate = q.Rate
+	case *netlink.Netem:
+		d.Rate, d.Loss = q.Rate64, q.Loss
+	}
+	return d
+}
+
+func (k *Kernel) link(match func(netlink.Link) bool) (netlink.Link, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, link := range k.links {
+		if match(link) {
+			return link, nil
+		}
+	}
+	return nil, netlink.LinkNotFoundError{}
+}
+
+// -- Netlink --
+
+// Netlink is the kernel's netlink, for the throttler and the blackout.
+type Netlink struct{ k *Kernel }
+
+func (n *Netlink) LinkByName(name string) (netlink.Link, error) {
+	return n.k.link(func(l netlink.Link) bool { return l.Attrs().Name == name })
+}
+
+func (n *Netlink) LinkByIndex(index int) (netlink.Link, error) {
+	return n.k.link(func(l netlink.Link) bool { return l.Attrs().Index == index })
+}
+
+func (n *Netlink) LinkList() ([]netlink.Link, error) {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	return slices.Clone(n.k.links), nil
+}
+
+func (n *Netlink) LinkSetUp(link netlink.Link) error {
+	return n.setFlags(link, true)
+}
+
+func (n *Netlink) LinkSetDown(link netlink.Link) error {
+	return n.setFlags(link, false)
+}
+
+func (n *Netlink) setFlags(link netlink.Link, up bool) error {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	if up {
+		link.Attrs().Flags |= net.FlagUp
+	} else {
+		link.Attrs().Flags &^= net.FlagUp
+	}
+	return n.k.save()
+}
+
+// RouteList has the default route go through Interface.
+func (n *Netlink) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	if link != nil && link.Attrs().Index != 2 {
+		return nil, nil
+	}
+	return []netlink.Route{{LinkIndex: 2, Family: family}}, nil
+}
+
+func (n *Netlink) QdiscList(link netlink.Link) ([]netlink.Qdisc, error) {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	return slices.Clone(n.k.qdiscs[link.Attrs().Index]), nil
+}
+
+// QdiscAdd fails, as the kernel does, if the parent already has a qdisc.
+func (n *Netlink) QdiscAdd(qdisc netlink.Qdisc) error {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	index := qdisc.Attrs().LinkIndex
+	for _, q := range n.k.qdiscs[index] {
+		if q.Attrs().Parent == qdisc.Attrs().Parent {
+			return fmt.Errorf("parent %s has qdisc %s: %w", netlink.HandleStr(q.Attrs().Parent), netlink.HandleStr(q.Attrs().Handle), syscall.EEXIST)
+		}
+	}
+	n.k.qdiscs[index] = append(n.k.qdiscs[index], qdisc)
+	return n.k.save()
+}
+
+func (n *Netlink) QdiscReplace(qdisc netlink.Qdisc) error {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	index := qdisc.Attrs().LinkIndex
+	n.k.qdiscs[index] = slices.DeleteFunc(n.k.qdiscs[index], func(q netlink.Qdisc) bool {
+		return q.Attrs().Parent == qdisc.Attrs().Parent
+	})
+	n.k.qdiscs[index] = append(n.k.qdiscs[index], qdisc)
+	return n.k.save()
+}
+
+// QdiscDel deletes qdisc and, as the kernel does, what hangs from it.
+func (n *Netlink) QdiscDel(qdisc netlink.Qdisc) error {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	index := qdisc.Attrs().LinkIndex
+	n.k.qdiscs[index] = slices.DeleteFunc(n.k.qdiscs[index], func(q netlink.Qdisc) bool {
+		return q.Attrs().Handle == qdisc.Attrs().Handle || q.Attrs().Parent != netlink.HANDLE_ROOT && qdisc.Attrs().Parent == netlink.HANDLE_ROOT
+	})
+	if qdisc.Attrs().Parent == netlink.HANDLE_ROOT {
+		delete(n.k.filters, index)
+	}
+	return n.k.save()
+}
+
+func (n *Netlink) FilterAdd(filter netlink.Filter) error {
+	n.k.mu.Lock()
+	defer n.k.mu.Unlock()
+	index := filter.Attrs().LinkIndex
+	n.k.filters[index] = append(n.k.filters[index], filter)
+	return nil
+}
+
+// -- Firewall --
+
+// Firewall is the kernel's nftables table.
+type Firewall struct{ k *Kernel }
+
+func (f *Firewall) Setup(blockedDomains []string) error {
+	f.k.mu.Lock()
+	defer f.k.mu.Unlock()
+	f.k.firewall = true
+	f.k.blocked = slices.Sorted(slices.Values(blockedDomains))
+	return f.k.save()
+}
+
+func (f *Firewall) Clear() error {
+	f.k.mu.Lock()
+	defer f.k.mu.Unlock()
+	f.k.firewall = false
+	f.k.blocked = nil
+	return f.k.save()
+}
+
+// -- Processes --
+
+// System records the processes killed instead of killing them.
+type System struct{ k *Kernel }
+
+func (s *System) Getpid() int { return os.Getpid() }
+
+func (s *System) Kill(pid int, sig syscall.Signal) error {
+	s.k.mu.Lock()
+	defer s.k.mu.Unlock()
+	s.k.killed = append(s.k.killed, pid)
+	return s.k.save()
+}
+
+// Priority keeps thread priorities instead of setting them.
+type Priority struct{ k *Kernel }
+
+func (p *Priority) Get(tid int) (throttler.TaskPriority, error) {
+	p.k.mu.Lock()
+	defer p.k.mu.Unlock()
+	return p.k.prio[tid], nil
+}
+
+func (p *Priority) Set(tid int, prio throttler.TaskPriority) error {
+	p.k.mu.Lock()
+	defer p.k.mu.Unlock()
+	p.k.prio[tid] = prio
+	return nil
+}
@@ -0,0 +1,265 @@
+// Package gpu caps how hard the graphics cards may run, as a penalty
+// aimed at games: the power limit of amdgpu cards, through hwmon, the
+// highest clock of Intel (i915) cards, and the power limit of NVIDIA
+// cards, through nvidia-smi.  A cap is a percentage of the card's default
+// limit, or for Intel of its clock range.
+//
+// The limits a cap replaced are kept in SavedFile, so that lifting the
+// cap after a restart still puts them back.
+package gpu
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -- Interfaces for Testing --
+
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type RealCommandRunner struct{}
+
+func (r *RealCommandRunner) Run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+var cmdRunner CommandRunner = &RealCommandRunner{}
+
+var (
+	// DRMDir is the sysfs DRM class the cards are found in.
+	DRMDir = "/sys/class/drm"
+
+	// SavedFile keeps the limits a cap replaced, by card.
+	SavedFile = "/run/vex-cli/gpu-saved.json"
+
+	// Timeout bounds a single nvidia-smi call.
+	Timeout = 10 * time.Second
+)
+
+// Drivers a cap works with.
+const (
+	DriverAMD    = "amdgpu"
+	DriverIntel  = "i915"
+	DriverNVIDIA = "nvidia"
+)
+
+// Card is one graphics card's limit: microwatts for amdgpu, MHz for
+// i915, watts for NVIDIA.
+type Card struct {
+	Name    string // card0, or nvidia:0 for an NVIDIA card
+	Driver  string
+	Limit   int // in force now
+	Default int // what a cap is a percentage of
+	Min     int // the lowest the card accepts
+	path    string
+}
+
+// Cap is the highest limit allowed at pct percent, never below Min.
+func (c Card) Cap(pct int) int {
+	if c.Driver == DriverIntel {
+		return c.Min + (c.Default-c.Min)*pct/100
+	}
+	return max(c.Default*pct/100, c.Min)
+}
+
+// Unit is what Limit is counted in.
+func (c Card) Unit() string {
+	switch c.Driver {
+	case DriverAMD:
+		return "µW"
+	case DriverIntel:
+		return "MHz"
+	}
+	return "W"
+}
+
+var cardRe = regexp.MustCompile(`^card\d+$`)
+
+// Cards finds every card a cap works with.  A machine without one has
+// none, and neither has a card whose driver offers no limit.
+func Cards() ([]Card, error) {
+	entries, err := os.ReadDir(DRMDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cards []Card
+	nvidia := false
+	for _, e := range entries {
+		if !cardRe.MatchString(e.Name()) {
+			continue // a connector, such as card0-HDMI-A-1
+		}
+		dir := filepath.Join(DRMDir, e.Name())
+		driver, _ := os.Readlink(filepath.Join(dir, "device", "driver"))
+		var c Card
+		switch filepath.Base(driver) {
+		case DriverAMD:
+			c, err = amdCard(e.Name(), dir)
+		case DriverIntel:
+			c, err = intelCard(e.Name(), dir)
+		case DriverNVIDIA:
+			nvidia = true
+			continue
+		default:
+			continue
+		}
+		if err != nil {
+			continue // no limit to set, as on some APUs
+		}
+		cards = append(cards, c)
+	}
+	if nvidia {
+		list, err := nvidiaCards()
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, list...)
+	}
+	return cards, nil
+}
+
+// amdCard reads the power cap of an amdgpu card from its hwmon.
+func amdCard(name, dir string) (Card, error) {
+	caps, _ := filepath.Glob(filepath.Join(dir, "device", "hwmon", "hwmon*", "power1_cap"))
+	if len(caps) == 0 {
+		return Card{}, fmt.Errorf("no power1_cap under %s", filepath.Join(dir, "device", "hwmon"))
+	}
+	c := Card{Name: name, Driver: DriverAMD, path: caps[0]}
+	var err error
+	if c.Limit, err = readInt(c.path); err != nil {
+		return Card{}, err
+	}
+	// Older kernels have no power1_cap_default; the maximum stands in.
+	if c.Default, err = readInt(c.path + "_default"); err != nil {
+		if c.Default, err = readInt(c.path + "_max"); err != nil {
+			return Card{}, err
+		}
+	}
+	c.Min, _ = readInt(c.path + "_min")
+	return c, nil
+}
+
+// intelCard reads the highest clock of an i915 card, and its range.
+func intelCard(name, dir string) (Card, error) {
+	c := Card{Name: name, Driver: DriverIntel, path: filepath.Join(dir, "gt_max_freq_mhz")}
+	var err error
+	if c.Limit, err = readInt(c.path); err != nil {
+		return Card{}, err
+	}
+	if c.Default, err = readInt(filepath.Join(dir, "gt_RP0_freq_mhz")); err != nil {
+		return Card{}, err
+	}
+	if c.Min, err = readInt(filepath.Join(dir, "gt_RPn_freq_mhz")); err != nil {
+		return Card{}, err
+	}
+	return c, nil
+}
+
+// nvidiaCards asks nvidia-smi for the power limits of every NVIDIA card.
+func nvidiaCards() ([]Card, error) {
+	out, err := cmdRunner.Run("nvidia-smi", "--query-gpu=index,power.limit,power.default_limit,power.min_limit",
+		"--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	r := csv.NewReader(strings.NewReader(string(out)))
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %w", err)
+	}
+	var cards []Card
+	for _, row := range rows {
+		if len(row) != 4 {
+			return nil, fmt.Errorf("nvidia-smi: unexpected line %q", strings.Join(row, ", "))
+		}
+		c := Card{Name: "nvidia:" + row[0], Driver: DriverNVIDIA}
+		for i, v := range []*int{&c.Limit, &c.Default, &c.Min} {
+			w, err := strconv.ParseFloat(row[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("nvidia-smi: GPU %s has no power limit (%q)", row[0], row[i+1])
+			}
+			*v = int(w)
+		}
+		cards = append(cards, c)
+	}
+	return cards, nil
+}
+
+// SetLimit sets a card's limit.
+func SetLimit(c Card, v int) error {
+	if c.Driver == DriverNVIDIA {
+		index := strings.TrimPrefix(c.Name, "nvidia:")
+		if out, err := cmdRunner.Run("nvidia-smi", "-i", index, "-pl", strconv.Itoa(v)); err != nil {
+			return fmt.Errorf("nvidia-smi -i %s -pl %d: %w: %s", index, v, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return os.WriteFile(c.path, []byte(strconv.Itoa(v)), 0o644)
+}
+
+// Paths returns what a cap writes to, for the sandbox to leave writable:
+// the sysfs device directory of each card in DRMDir, which holds both its
+// DRM entry and its hwmon, and the NVIDIA device nodes nvidia-smi opens.
+func Paths() []string {
+	entries, _ := os.ReadDir(DRMDir)
+	paths, _ := filepath.Glob("/dev/nvidia*")
+	for _, e := range entries {
+		if !cardRe.MatchString(e.Name()) {
+			continue
+		}
+		if p, err := filepath.EvalSymlinks(filepath.Join(DRMDir, e.Name(), "device")); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// loadSaved reads the limits a cap replaced, by card.
+func loadSaved() map[string]int {
+	saved := make(map[string]int)
+	if data, err := os.ReadFile(SavedFile); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	return saved
+}
+
+func storeSaved(saved map[string]int) error {
+	if len(saved) == 0 {
+		if err := os.Remove(SavedFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(SavedFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(SavedFile, data, 0o600)
+}
@@ -0,0 +1,118 @@
+package gpu
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// fakeSMI answers nvidia-smi calls for one card from a limit it keeps.
+type fakeSMI struct{ limit int }
+
+func (f *fakeSMI) Run(name string, args ...string) ([]byte, error) {
+	if strings.HasPrefix(args[0], "--query-gpu=") {
+		return fmt.Appendf(nil, "0, %d.00, 300.00, 100.00\n", f.limit), nil
+	}
+	if _, err := fmt.Sscanf(strings.Join(args, " "), "-i 0 -pl %d", &f.limit); err != nil {
+		return nil, fmt.Errorf("unexpected nvidia-smi %v", args)
+	}
+	return nil, nil
+}
+
+// setup builds an amdgpu card0, an i915 card1, an NVIDIA card2 and a
+// connector under a temporary DRMDir.
+func setup(t *testing.T) (amd, intel string, smi *fakeSMI) {
+	DRMDir = t.TempDir()
+	SavedFile = filepath.Join(t.TempDir(), "gpu-saved.json")
+	write := func(path, v string) {
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(v+"\n"), 0o644)
+	}
+	card := func(name, driver string) string {
+		dir := filepath.Join(DRMDir, name)
+		os.MkdirAll(filepath.Join(dir, "device"), 0o755)
+		os.Symlink("../../bus/pci/drivers/"+driver, filepath.Join(dir, "device", "driver"))
+		return dir
+	}
+
+	amd = filepath.Join(card("card0", "amdgpu"), "device", "hwmon", "hwmon3", "power1_cap")
+	write(amd, "200000000")
+	write(amd+"_default", "200000000")
+	write(amd+"_min", "50000000")
+	intel = filepath.Join(card("card1", "i915"), "gt_max_freq_mhz")
+	write(intel, "1300")
+	write(filepath.Join(DRMDir, "card1", "gt_RP0_freq_mhz"), "1300")
+	write(filepath.Join(DRMDir, "card1", "gt_RPn_freq_mhz"), "300")
+	card("card2", "nvidia")
+	os.Mkdir(filepath.Join(DRMDir, "card0-HDMI-A-1"), 0o755)
+
+	smi = &fakeSMI{limit: 300}
+	old := cmdRunner
+	cmdRunner = smi
+	t.Cleanup(func() {
+		cmdRunner = old
+		DRMDir, SavedFile = "/sys/class/drm", "/run/vex-cli/gpu-saved.json"
+	})
+	return amd, intel, smi
+}
+
+func TestCards(t *testing.T) {
+	setup(t)
+	cards, err := Cards()
+	if err != nil || len(cards) != 3 {
+		t.Fatalf("Expected three cards, got %+v, %v", cards, err)
+	}
+	for _, tc := range []struct {
+		card Card
+		name string
+		want int
+	}{
+		{cards[0], "card0", 100000000}, // half the default power
+		{cards[1], "card1", 800},       // half way between 300 and 1300 MHz
+		{cards[2], "nvidia:0", 150},
+	} {
+		if tc.card.Name != tc.name || tc.card.Cap(50) != tc.want {
+			t.Errorf("Expected %s capped at %d, got %s at %d", tc.name, tc.want, tc.card.Name, tc.card.Cap(50))
+		}
+	}
+	if c := cards[0].Cap(10); c != 50000000 {
+		t.Errorf("Expected the cap to stop at the card's minimum, got %d", c)
+	}
+}
+
+func TestModule(t *testing.T) {
+	amd, intel, smi := setup(t)
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return strings.TrimSpace(string(data))
+	}
+
+	m := NewModule()
+	st := &state.SystemState{}
+	st.Compute.GPULimitPct = 25
+	if err := m.Apply(st); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if read(amd) != "50000000" || read(intel) != "550" || smi.limit != 100 {
+		t.Errorf("Expected every card capped, got %s, %s, %d", read(amd), read(intel), smi.limit)
+	}
+	if err := m.Verify(st); err != nil {
+		t.Errorf("Expected the caps to verify, got %v", err)
+	}
+
+	// A new module, as after a restart, still knows what to put back.
+	st.Compute.GPULimitPct = 0
+	if err := NewModule().Apply(st); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if read(amd) != "200000000" || read(intel) != "1300" || smi.limit != 300 {
+		t.Errorf("Expected every card restored, got %s, %s, %d", read(amd), read(intel), smi.limit)
+	}
+	if _, err := os.Stat(SavedFile); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed once everything is restored", SavedFile)
+	}
+}
@@ -0,0 +1,154 @@
+package gpu
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// Interval is how often Module puts back a cap that was lifted behind its
+// back, e.g. by reloading the NVIDIA driver.
+var Interval = 30 * time.Second
+
+// Module enforces state.ComputeState.GPULimitPct as an enforcement
+// module (see package modules).  It is part of the throttler subsystem.
+type Module struct {
+	mu      sync.Mutex
+	pct     int
+	lastErr string
+}
+
+// NewModule returns a Module that enforces nothing yet.
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string { return "gpu" }
+
+// Init starts the worker that keeps the cap in place.
+func (m *Module) Init() error {
+	supervisor.Go("gpu.watch", m.watch)
+	return nil
+}
+
+// Apply enforces st.Compute.GPULimitPct, or nothing while enforcement is
+// paused.
+func (m *Module) Apply(st *state.SystemState) error {
+	pct := st.Compute.GPULimitPct
+	if st.Pause != nil {
+		pct = 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if capped(pct) && subsystem.Skip(subsystem.Throttler, "cap the GPUs at %d%%", pct) {
+		return nil
+	}
+	m.pct = pct
+	return m.enforce()
+}
+
+// Verify reports a card above its cap.
+func (m *Module) Verify(st *state.SystemState) error {
+	pct := st.Compute.GPULimitPct
+	if st.Pause != nil || !capped(pct) {
+		return nil
+	}
+	cards, err := Cards()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, c := range cards {
+		if c.Limit > c.Cap(pct) {
+			errs = append(errs, fmt.Errorf("%s limit %d%s above %d%%", c.Name, c.Limit, c.Unit(), pct))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown lifts the cap.
+func (m *Module) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pct = 0
+	return m.enforce()
+}
+
+// watch enforces the cap every Interval, logging an error only when it
+// differs from the last one.
+func (m *Module) watch() error {
+	for range time.Tick(Interval) {
+		if subsystem.Get(subsystem.Throttler) != subsystem.Enforce {
+			continue
+		}
+		m.mu.Lock()
+		err := m.enforce()
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+			if msg != m.lastErr {
+				log.Printf("GPU: %v", err)
+			}
+		}
+		m.lastErr = msg
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// capped reports whether pct restricts the cards.
+func capped(pct int) bool {
+	return pct > 0 && pct < 100
+}
+
+// enforce brings every card within m.pct and puts back the limits a
+// lifted cap had replaced.  The caller holds m.mu.
+func (m *Module) enforce() error {
+	saved := loadSaved()
+	if !capped(m.pct) && len(saved) == 0 {
+		return nil
+	}
+	cards, err := Cards()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, c := range cards {
+		own, ok := saved[c.Name]
+		if !capped(m.pct) {
+			if !ok {
+				continue
+			}
+			if err := SetLimit(c, own); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(saved, c.Name)
+			vexlog.LogEvent("THROTTLER", "GPU_RESTORED", fmt.Sprintf("card=%s limit=%d%s", c.Name, own, c.Unit()))
+			continue
+		}
+		limit := c.Cap(m.pct)
+		if c.Limit <= limit {
+			continue
+		}
+		if err := SetLimit(c, limit); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			saved[c.Name] = c.Limit
+		}
+		vexlog.LogEvent("THROTTLER", "GPU_CAPPED", fmt.Sprintf("card=%s from=%d%s to=%d%s", c.Name, c.Limit, c.Unit(), limit, c.Unit()))
+	}
+	if err := storeSaved(saved); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -13,6 +13,10 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/perf"
@@ -71,6 +75,17 @@ func NewEBPFMonitor() (*EBPFMonitor, error) {
 	return m, nil
 }
 
+// ProbeEBPF loads and attaches the exec monitor, then closes it before
+// any event is handled.
+func ProbeEBPF() (string, error) {
+	m, err := NewEBPFMonitor()
+	if err != nil {
+		return "", err
+	}
+	m.Close()
+	return "tracepoint sched/sched_process_exec", nil
+}
+
 // load compiles and loads the eBPF program from embedded bytecode.
 // TODO: This currently uses a manual CollectionSpec with stub instructions.
 // Run 'go generate ./internal/guardian' to generate proper eBPF bytecode,
@@ -157,19 +172,19 @@ func (m *EBPFMonitor) Start() error {
 		return errors.New("eBPF monitor not initialized")
 	}
 
-	go m.eventLoop()
+	supervisor.Go("guardian.ebpf", m.eventLoop)
 	log.Println("Guardian: eBPF event loop started")
 	return nil
 }
 
 // eventLoop continuously reads execution events from the eBPF perf buffer
 // and terminates forbidden processes.
-func (m *EBPFMonitor) eventLoop() {
+func (m *EBPFMonitor) eventLoop() error {
 	for {
 		record, err := m.reader.Read()
 		if err != nil {
 			if errors.Is(err, perf.ErrClosed) {
-				return
+				return nil
 			}
 			log.Printf("Guardian: eBPF read error: %v", err)
 			continue
@@ -200,9 +215,17 @@ func (m *EBPFMonitor) handleExecEvent(event *execEvent) {
 	for _, app := range m.forbiddenApps {
 		appLower := strings.ToLower(app)
 		if strings.Contains(commLower, appLower) || strings.Contains(filenameLower, appLower) {
+			if !users.OwnsPID(int(event.PID)) {
+				return
+			}
+			if subsystem.Skip(subsystem.Guardian, "kill forbidden process %s (PID %d)", comm, event.PID) {
+				return
+			}
 			log.Printf("Guardian: ⚔️ [eBPF] Terminating forbidden process: %s (PID %d)", comm, event.PID)
 			if err := sysOps.Kill(int(event.PID), syscall.SIGKILL); err != nil {
 				log.Printf("Guardian: Failed to kill PID %d: %v", event.PID, err)
+			} else {
+				events.Publish(events.Kill{App: comm, PID: int(event.PID)})
 			}
 			return
 		}
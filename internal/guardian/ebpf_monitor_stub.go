@@ -10,6 +10,11 @@ func NewEBPFMonitor() (*EBPFMonitor, error) {
 	return nil, errors.New("eBPF monitor not implemented in this build")
 }
 
+// ProbeEBPF reports that this build has no eBPF monitor.
+func ProbeEBPF() (string, error) {
+	return "", errors.New("not in this build (needs -tags ebpf); forbidden apps are reaped by polling")
+}
+
 func (m *EBPFMonitor) Start() error {
 	return errors.New("eBPF monitor not implemented in this build")
 }
@@ -1,6 +1,8 @@
 package guardian
 
 import (
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -8,12 +10,20 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
@@ -33,14 +43,32 @@ type SystemOps interface {
 }
 
 type FirewallOps interface {
-	Setup(blockedDomains []string) error
+	Setup(blockedDomains []string) error // replaces any rules already in place
 	Clear() error
 }
 
 // -- State tracking --
 
-// activeDomains is the live set of blocked domains (kept in sync with nftables).
-var activeDomains []string
+// activeDomains is the live set of blocked domains (kept in sync with
+// nftables).  It is replaced, never changed in place, under domainsMu,
+// as the DNS refresh reads it from its own goroutine.
+var (
+	activeDomains []string
+	domainsMu     sync.Mutex
+)
+
+func setActiveDomains(domains []string) {
+	domainsMu.Lock()
+	activeDomains = domains
+	domainsMu.Unlock()
+}
+
+// listDomains and listApps are the lists as last read from disk, so that a
+// reload can tell what was added to or removed from the files.
+var (
+	listDomains []string
+	listApps    []string
+)
 
 // -- Real Implementations --
 
@@ -61,6 +89,13 @@ func (r *RealSystemOps) Kill(pid int, sig syscall.Signal) error { return syscall
 type RealFirewallOps struct{}
 
 func (r *RealFirewallOps) Setup(blockedDomains []string) error {
+	if subsystem.Skip(subsystem.Guardian, "replace the nftables rules to block %d domains", len(blockedDomains)) {
+		return nil
+	}
+	// One Setup at a time, so that the last to start is the one in force.
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
 	conn, err := nftables.New()
 	if err != nil {
 		return fmt.Errorf("failed to open nftables connection: %w", err)
@@ -76,13 +111,20 @@ func (r *RealFirewallOps) Setup(blockedDomains []string) error {
 		Priority: nftables.ChainPriorityFilter,
 	}
 	conn.AddChain(chain)
+	// Drop the rules of a previous Setup in the same transaction, so they
+	// stay in force until the new set is committed.
+	conn.FlushChain(chain)
 
 	// Resolve each blocked domain to IPs and add drop rules per IP.
 	// This replaces the previous (broken) SNI payload matching approach
 	// which lacked a Cmp expression and dropped ALL port-443 traffic.
+	domains, prefixes := splitEntries(blockedDomains)
+	setStubBlocked(domains)
+	addStubRedirect(conn, table)
 	totalRules := 0
-	for _, domain := range blockedDomains {
-		ips := resolveDomain(domain)
+	resolved := resolveAll(domains)
+	for i, domain := range domains {
+		ips := resolved[i]
 		if len(ips) == 0 {
 			log.Printf("Guardian: WARNING — could not resolve %s, skipping", domain)
 			continue
@@ -102,15 +144,51 @@ func (r *RealFirewallOps) Setup(blockedDomains []string) error {
 		log.Printf("Guardian: Blocked %s (%d IPs resolved)", domain, len(ips))
 	}
 
+	// Tunnel interfaces found by the tunnel watch: everything through them.
+	tunnels, relays := blockedTunnelRules()
+	for _, name := range tunnels {
+		conn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: buildTunnelBlockExprs(name),
+		})
+		totalRules++
+	}
+	prefixes = append(prefixes, relays...)
+
+	// Prefixes, ASes and relays share one interval set and one rule.  The set is
+	// emptied and refilled in the same transaction as the chain.
+	set := &nftables.Set{Table: table, Name: prefixSetName, KeyType: nftables.TypeIPAddr, Interval: true}
+	if err := conn.AddSet(set, nil); err != nil {
+		return fmt.Errorf("failed to add the prefix set: %w", err)
+	}
+	conn.FlushSet(set)
+	ranges := mergePrefixes(prefixes)
+	if len(ranges) > 0 {
+		if err := conn.SetAddElements(set, buildPrefixSetElements(ranges)); err != nil {
+			return fmt.Errorf("failed to add %d prefixes: %w", len(ranges), err)
+		}
+		conn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: buildPrefixBlockExprs(set),
+		})
+		totalRules++
+	}
+
 	if err := conn.Flush(); err != nil {
 		return fmt.Errorf("failed to apply firewall rules: %w", err)
 	}
 
-	log.Printf("Guardian: NFTables 'vex-guardian' initialized with %d IP block rules for %d domains.", totalRules, len(blockedDomains))
+	log.Printf("Guardian: NFTables 'vex-guardian' initialized with %d IP block rules for %d domains and %d prefix ranges.", totalRules, len(domains), len(ranges))
 	return nil
 }
 
 func (r *RealFirewallOps) Clear() error {
+	if subsystem.Skip(subsystem.Guardian, "delete the nftables table") {
+		return nil
+	}
+	setStubBlocked(nil)
 	conn, err := nftables.New()
 	if err != nil {
 		return fmt.Errorf("failed to open nftables connection: %w", err)
@@ -126,11 +204,73 @@ func (r *RealFirewallOps) Clear() error {
 	return nil
 }
 
+// ProbeFirewall checks that nftables can be read, without changing the
+// ruleset.
+func ProbeFirewall() (string, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return "", fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+	tables, err := conn.ListTables()
+	if err != nil {
+		return "", fmt.Errorf("list tables: %w", err)
+	}
+	return fmt.Sprintf("%d tables", len(tables)), nil
+}
+
 // buildIPBlockExprs creates nftables expressions that drop all outbound TCP
 // traffic to the given IPv4 address.  This replaces the previous broken SNI
 // matching which lacked a comparison expression and dropped all port-443 traffic.
 func buildIPBlockExprs(ip4 net.IP) []expr.Any {
-	return []expr.Any{
+	return append(blockMatchExprs(),
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte(ip4.To4())},
+
+		// Drop verdict
+		&expr.Verdict{Kind: expr.VerdictDrop},
+	)
+}
+
+// buildPrefixBlockExprs creates nftables expressions that drop all outbound
+// TCP traffic to an address in the prefix set.
+func buildPrefixBlockExprs(set *nftables.Set) []expr.Any {
+	return append(blockMatchExprs(),
+		&expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID},
+		&expr.Verdict{Kind: expr.VerdictDrop},
+	)
+}
+
+// buildTunnelBlockExprs creates nftables expressions that drop everything
+// sent through the named interface.
+func buildTunnelBlockExprs(name string) []expr.Any {
+	var exprs []expr.Any
+	if users.Scoped() {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(users.Mark)},
+		)
+	}
+	ifname := make([]byte, unix.IFNAMSIZ)
+	copy(ifname, name)
+	return append(exprs,
+		// meta oifname
+		&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname},
+		&expr.Verdict{Kind: expr.VerdictDrop},
+	)
+}
+
+// blockMatchExprs matches outbound TCP packets and loads their destination
+// address into register 1.
+func blockMatchExprs() []expr.Any {
+	var exprs []expr.Any
+	if users.Scoped() {
+		// meta mark == users.Mark: only the target users' packets
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(users.Mark)},
+		)
+	}
+	return append(exprs,
 		// meta l4proto tcp
 		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
 		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
@@ -142,11 +282,32 @@ func buildIPBlockExprs(ip4 net.IP) []expr.Any {
 			Offset:       16,
 			Len:          4,
 		},
-		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte(ip4.To4())},
+	)
+}
 
-		// Drop verdict
-		&expr.Verdict{Kind: expr.VerdictDrop},
+// buildPrefixSetElements turns ranges into interval set elements: each
+// range opens at its first address and closes after its last.
+func buildPrefixSetElements(ranges []ipRange) []nftables.SetElement {
+	var elems []nftables.SetElement
+	for _, r := range ranges {
+		elems = append(elems, nftables.SetElement{Key: binary.BigEndian.AppendUint32(nil, r.first)})
+		if r.last != 0xffffffff { // a range to the end of the space stays open
+			elems = append(elems, nftables.SetElement{Key: binary.BigEndian.AppendUint32(nil, r.last+1), IntervalEnd: true})
+		}
+	}
+	return elems
+}
+
+// resolveAll resolves the domains side by side, so that an unreachable
+// resolver costs one DNSLookupTimeout rather than one per domain.
+func resolveAll(domains []string) [][]net.IP {
+	ips := make([][]net.IP, len(domains))
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		wg.Go(func() { ips[i] = resolveDomain(domain) })
 	}
+	wg.Wait()
+	return ips
 }
 
 // resolveDomain resolves a domain name (and its www. variant) to IP addresses.
@@ -160,7 +321,9 @@ func resolveDomain(domain string) []net.IP {
 	}
 
 	for _, d := range candidates {
-		addrs, err := net.LookupHost(d)
+		ctx, cancel := context.WithTimeout(context.Background(), DNSLookupTimeout)
+		addrs, err := lookupHost(ctx, d)
+		cancel()
 		if err != nil {
 			log.Printf("Guardian: DNS lookup for %s: %v", d, err)
 			continue
@@ -192,15 +355,30 @@ var (
 
 	// DNS refresh: periodically re-resolve blocked domains so that
 	// IP-based firewall rules stay current when CDN addresses rotate.
-	refreshTicker *time.Ticker
+	refreshTicker *clock.Ticker
 	refreshDone   chan struct{}
+
+	// DNSRefreshInterval is how often blocked domains are re-resolved.
+	DNSRefreshInterval = 30 * time.Minute
+
+	// DNSLookupTimeout bounds each lookup of a blocked domain.
+	DNSLookupTimeout = 5 * time.Second
+	lookupHost       = net.DefaultResolver.LookupHost
+
+	setupMu sync.Mutex // held by RealFirewallOps.Setup
 )
 
+// SetOps replaces the file, process and firewall operations, for tests
+// outside the package (see package fakes).
+func SetOps(fs FileSystem, sys SystemOps, fw FirewallOps) {
+	fsOps, sysOps, fwOps = fs, sys, fw
+}
+
 // Init initializes the guardian subsystem
 func Init(penaltyActive bool) error {
 	log.Println("Initializing Guardian Subsystem...")
 
-	if err := SetOOMScore(-1000); err != nil {
+	if err := ShieldDaemon(); err != nil {
 		log.Printf("Guardian: Failed to engage OOM shield: %v", err)
 	} else {
 		log.Println("Guardian: OOM Shield Engaged (-1000)")
@@ -217,7 +395,7 @@ func Init(penaltyActive bool) error {
 		if err != nil {
 			log.Printf("Guardian: eBPF monitor failed to initialize: %v", err)
 			log.Println("Guardian: Falling back to /proc polling")
-			go startReaper()
+			supervisor.Go("guardian.reaper", runReaper)
 		} else {
 			ebpfMon = mon
 			if err := ebpfMon.Start(); err != nil {
@@ -225,26 +403,35 @@ func Init(penaltyActive bool) error {
 				log.Println("Guardian: Falling back to /proc polling")
 				ebpfMon.Close()
 				ebpfMon = nil
-				go startReaper()
+				supervisor.Go("guardian.reaper", runReaper)
 			} else {
 				log.Println("Guardian: Using eBPF-based process monitoring (high-performance mode)")
 			}
 		}
 	} else {
-		go startReaper()
+		supervisor.Go("guardian.reaper", runReaper)
+	}
+
+	listDomains = loadBlockedDomains()
+	listApps = loadForbiddenApps()
+
+	if StubResolver {
+		if err := startStub(); err != nil {
+			log.Printf("Guardian: Stub resolver not started, blocking by address only: %v", err)
+		}
 	}
 
 	if penaltyActive {
-		blockedDomains := loadBlockedDomains()
-		activeDomains = blockedDomains
+		blockedDomains := slices.Clone(listDomains)
+		setActiveDomains(blockedDomains)
 		if err := fwOps.Setup(blockedDomains); err != nil {
 			log.Printf("Guardian: Firewall initialization failed: %v", err)
 		} else if len(blockedDomains) > 0 {
 			startDNSRefresh()
 		}
 	} else {
-		activeDomains = nil
-		log.Println("Guardian: No active penalty — skipping domain block rules")
+		setActiveDomains(nil)
+		log.Println("Guardian: Firewall not enabled at startup — skipping domain block rules")
 	}
 	return nil
 }
@@ -276,12 +463,8 @@ func GetMonitorStatus() string {
 // Shutdown performs cleanup of guardian resources: eBPF monitor, DNS refresh, and nftables rules.
 func Shutdown() error {
 	var errs []string
-	stopDNSRefresh()
-	if ebpfMon != nil {
-		log.Println("Guardian: Shutting down eBPF monitor...")
-		if err := ebpfMon.Close(); err != nil {
-			errs = append(errs, fmt.Sprintf("ebpf close: %v", err))
-		}
+	if err := Detach(); err != nil {
+		errs = append(errs, err.Error())
 	}
 	// Always attempt to remove the nftables table so rules don't persist.
 	if err := fwOps.Clear(); err != nil {
@@ -293,6 +476,24 @@ func Shutdown() error {
 	return nil
 }
 
+// Detach stops the eBPF monitor and the DNS refresh but leaves the
+// nftables rules in force, for a daemon that stops while a penalty is on;
+// only the DNS redirect goes, as no stub is left to answer.  The next Init
+// replaces them.
+func Detach() error {
+	stopDNSRefresh()
+	if err := removeStubRedirect(); err != nil {
+		log.Printf("Guardian: Failed to remove the DNS redirect: %v", err)
+	}
+	if ebpfMon != nil {
+		log.Println("Guardian: Shutting down eBPF monitor...")
+		if err := ebpfMon.Close(); err != nil {
+			return fmt.Errorf("ebpf close: %w", err)
+		}
+	}
+	return nil
+}
+
 // ClearFirewall removes the vex-guardian nftables table (idempotent).
 func ClearFirewall() error {
 	return fwOps.Clear()
@@ -300,17 +501,35 @@ func ClearFirewall() error {
 
 // GetBlockedDomains returns the currently active domain blocklist.
 func GetBlockedDomains() []string {
-	out := make([]string, len(activeDomains))
-	copy(out, activeDomains)
-	return out
+	domainsMu.Lock()
+	defer domainsMu.Unlock()
+	return slices.Clone(activeDomains)
 }
 
-// AddDomain adds a domain to the live blocklist and rebuilds the firewall.
+// DomainNames returns the domain names among blocklist entries, without
+// the IPv4 prefixes and ASes.
+func DomainNames(entries []string) []string {
+	var names []string
+	for _, e := range entries {
+		if _, ok := parseASN(e); ok {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(e); err == nil {
+			continue
+		}
+		names = append(names, e)
+	}
+	return names
+}
+
+// AddDomain adds a domain, IPv4 prefix or AS to the live blocklist (see
+// NormalizeEntry) and rebuilds the firewall.  An AS is looked up first, and
+// one that cannot be is not added.
 // Returns true if the domain was actually added (false if already present).
 func AddDomain(domain string) (bool, error) {
-	domain = strings.ToLower(strings.TrimSpace(domain))
-	if domain == "" {
-		return false, fmt.Errorf("empty domain")
+	domain, err := NormalizeEntry(domain)
+	if err != nil {
+		return false, err
 	}
 
 	// Check for duplicate
@@ -320,10 +539,17 @@ func AddDomain(domain string) (bool, error) {
 		}
 	}
 
-	activeDomains = append(activeDomains, domain)
+	if asn, ok := parseASN(domain); ok {
+		if _, err := resolveASN(asn, false); err != nil {
+			return false, fmt.Errorf("look up %s: %w", domain, err)
+		}
+	}
+
+	old := activeDomains
+	setActiveDomains(append(slices.Clone(old), domain))
 	if err := rebuildFirewall(); err != nil {
 		// Roll back
-		activeDomains = activeDomains[:len(activeDomains)-1]
+		setActiveDomains(old)
 		return false, err
 	}
 	log.Printf("Guardian: Domain added to blocklist: %s (total: %d)", domain, len(activeDomains))
@@ -333,7 +559,9 @@ func AddDomain(domain string) (bool, error) {
 // RemoveDomain removes a domain from the live blocklist and rebuilds the firewall.
 // Returns true if the domain was actually removed (false if not found).
 func RemoveDomain(domain string) (bool, error) {
-	domain = strings.ToLower(strings.TrimSpace(domain))
+	if d, err := NormalizeEntry(domain); err == nil {
+		domain = d
+	}
 	idx := -1
 	for i, d := range activeDomains {
 		if d == domain {
@@ -346,17 +574,18 @@ func RemoveDomain(domain string) (bool, error) {
 	}
 
 	old := activeDomains
-	activeDomains = append(activeDomains[:idx], activeDomains[idx+1:]...)
+	setActiveDomains(slices.Delete(slices.Clone(old), idx, idx+1))
 
 	if len(activeDomains) == 0 {
 		// No domains left — just clear the table
 		if err := fwOps.Clear(); err != nil {
-			activeDomains = old
+			setActiveDomains(old)
 			return false, err
 		}
+		forgetTunnels()
 	} else {
 		if err := rebuildFirewall(); err != nil {
-			activeDomains = old
+			setActiveDomains(old)
 			return false, err
 		}
 	}
@@ -367,20 +596,21 @@ func RemoveDomain(domain string) (bool, error) {
 // SetBlockedDomains replaces the live blocklist entirely and rebuilds the firewall.
 // Used on daemon startup to restore persisted state.
 func SetBlockedDomains(domains []string) error {
-	activeDomains = domains
+	setActiveDomains(domains)
 	if len(domains) == 0 {
 		return fwOps.Clear()
 	}
 	return rebuildFirewall()
 }
 
-// rebuildFirewall clears the existing table and rebuilds it with activeDomains.
-// DNS resolution is performed inside fwOps.Setup to obtain current IPs.
+// rebuildFirewall replaces the rules with ones for activeDomains, or
+// removes the table when there are none.  DNS resolution is performed
+// inside fwOps.Setup to obtain current IPs.
 func rebuildFirewall() error {
-	// Clear first (ignore errors — table might not exist yet)
-	_ = fwOps.Clear()
 	if len(activeDomains) == 0 {
 		stopDNSRefresh()
+		forgetTunnels()
+		_ = fwOps.Clear() // the table might not exist
 		return nil
 	}
 	if err := fwOps.Setup(activeDomains); err != nil {
@@ -397,25 +627,35 @@ func rebuildFirewall() error {
 // blocked domains and rebuilds the firewall rules so IP changes are tracked.
 func startDNSRefresh() {
 	stopDNSRefresh()
-	refreshDone = make(chan struct{})
-	refreshTicker = time.NewTicker(30 * time.Minute)
-	go func() {
+	done := make(chan struct{})
+	ticker := clock.NewTicker(DNSRefreshInterval)
+	refreshDone, refreshTicker = done, ticker
+	supervisor.Go("guardian.dns-refresh", func() error {
 		for {
 			select {
-			case <-refreshTicker.C:
-				if len(activeDomains) > 0 {
+			case <-ticker.C:
+				if domains := GetBlockedDomains(); len(domains) > 0 {
 					log.Println("Guardian: Refreshing domain IP resolutions...")
-					_ = fwOps.Clear()
-					if err := fwOps.Setup(activeDomains); err != nil {
+					refreshASNs(domains)
+					if err := fwOps.Setup(domains); err != nil {
 						log.Printf("Guardian: IP refresh failed: %v", err)
 					}
 				}
-			case <-refreshDone:
-				return
+			case <-done:
+				return nil
 			}
 		}
-	}()
-	log.Println("Guardian: DNS refresh goroutine started (30m interval)")
+	})
+	log.Printf("Guardian: DNS refresh goroutine started (%s interval)", DNSRefreshInterval)
+}
+
+// ResetDNSRefresh applies a changed DNSRefreshInterval to the running
+// refresh, if any.
+func ResetDNSRefresh() {
+	if refreshTicker != nil {
+		refreshTicker.Reset(DNSRefreshInterval)
+		log.Printf("Guardian: DNS refresh interval now %s", DNSRefreshInterval)
+	}
 }
 
 // stopDNSRefresh tears down the periodic DNS resolution goroutine.
@@ -435,8 +675,8 @@ func stopDNSRefresh() {
 	}
 }
 
-// SNI block list default domains
-var defaultBlockedDomains = []string{
+// DefaultBlockedDomains are always blocked, on top of blocked-domains.json.
+var DefaultBlockedDomains = []string{
 	"store.steampowered.com",
 	"reddit.com",
 	"twitch.tv",
@@ -447,8 +687,8 @@ var defaultBlockedDomains = []string{
 // plus a hardcoded set of known entertainment/distraction domains.
 func loadBlockedDomains() []string {
 	// Start with default blocked domains
-	domains := make([]string, len(defaultBlockedDomains))
-	copy(domains, defaultBlockedDomains)
+	domains := make([]string, len(DefaultBlockedDomains))
+	copy(domains, DefaultBlockedDomains)
 
 	// Load the blocked-domains.json if it exists
 	data, err := fsOps.ReadFile("blocked-domains.json")
@@ -480,19 +720,76 @@ func loadBlockedDomains() []string {
 	return domains
 }
 
-// -- Logic --
+// ListChanges is what a reload found added to and removed from the
+// domain and app lists.
+type ListChanges struct {
+	DomainsAdded, DomainsRemoved []string
+	AppsAdded, AppsRemoved       []string
+}
+
+// Empty reports whether the lists were unchanged.
+func (c ListChanges) Empty() bool {
+	return len(c.DomainsAdded)+len(c.DomainsRemoved)+len(c.AppsAdded)+len(c.AppsRemoved) == 0
+}
+
+// ReloadLists reads blocked-domains.json (with DefaultBlockedDomains) and
+// forbidden-apps.json again.  While domains are being blocked, the ones
+// added to the list are blocked and the ones taken off it unblocked; other
+// blocked domains, such as those added with AddDomain, are kept.  The
+// firewall is replaced in one transaction, so blocking never lapses.
+func ReloadLists() (ListChanges, error) {
+	var ch ListChanges
+
+	domains := loadBlockedDomains()
+	ch.DomainsAdded, ch.DomainsRemoved = diffLists(listDomains, domains)
+	if len(activeDomains) > 0 && len(ch.DomainsAdded)+len(ch.DomainsRemoved) > 0 {
+		old := activeDomains
+		next := slices.DeleteFunc(slices.Clone(old), func(d string) bool {
+			return slices.Contains(ch.DomainsRemoved, d)
+		})
+		for _, d := range ch.DomainsAdded {
+			if !slices.Contains(next, d) {
+				next = append(next, d)
+			}
+		}
+		setActiveDomains(next)
+		if err := rebuildFirewall(); err != nil {
+			setActiveDomains(old)
+			return ch, err
+		}
+	}
+	listDomains = domains
+
+	apps := loadForbiddenApps()
+	ch.AppsAdded, ch.AppsRemoved = diffLists(listApps, apps)
+	listApps = apps
+	if len(ch.AppsAdded)+len(ch.AppsRemoved) > 0 && ebpfMon != nil && ebpfMon.IsEnabled() {
+		ebpfMon.UpdateForbiddenApps()
+	}
+	return ch, nil
+}
 
-// SetOOMScore adjusts the OOM score of the current process.
-// score: -1000 (invincible) to 1000 (first to die)
-func SetOOMScore(score int) error {
-	path := "/proc/self/oom_score_adj"
-	if _, err := fsOps.Stat(path); os.IsNotExist(err) {
-		return fmt.Errorf("%s not found", path)
+// diffLists returns the entries of next missing from prev, and the other
+// way round.
+func diffLists(prev, next []string) (added, removed []string) {
+	for _, v := range next {
+		if !slices.Contains(prev, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range prev {
+		if !slices.Contains(next, v) {
+			removed = append(removed, v)
+		}
 	}
-	return fsOps.WriteFile(path, []byte(strconv.Itoa(score)), 0644)
+	return added, removed
 }
 
-func startReaper() {
+// -- Logic --
+
+// runReaper scans /proc for forbidden apps every two seconds.  It only
+// returns by panicking, which the supervisor turns into a restart.
+func runReaper() error {
 	log.Println("Guardian: Process Reaper Started")
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
@@ -554,6 +851,7 @@ func saveForbiddenApps(apps []string) error {
 	if err := fsOps.WriteFile("forbidden-apps.json", data, 0644); err != nil {
 		return fmt.Errorf("failed to write forbidden-apps.json: %w", err)
 	}
+	listApps = apps // not a change for ReloadLists to report
 	return nil
 }
 
@@ -628,6 +926,10 @@ func RemoveForbiddenApp(app string) (bool, error) {
 	return true, nil
 }
 
+// dryRunSpared holds the forbidden processes a dry-run reaper has
+// reported, so that each is reported once rather than on every scan.
+var dryRunSpared = make(map[int]bool)
+
 func scanAndReap() {
 	apps := loadForbiddenApps()
 
@@ -644,19 +946,35 @@ func scanAndReap() {
 		if err != nil {
 			continue
 		}
-		if pid == sysOps.Getpid() || pid == 1 {
+		if pid == sysOps.Getpid() || pid == 1 || !users.OwnsPID(pid) {
 			continue
 		}
 
-		if isForbidden(pid, apps) {
+		if isForbidden(pid, apps) && !dryRunSpared[pid] {
+			name := procComm(pid) // read before the process is gone
+			if subsystem.Skip(subsystem.Guardian, "kill forbidden process %s (PID %d)", name, pid) {
+				dryRunSpared[pid] = true
+				continue
+			}
 			log.Printf("Guardian: ⚔️ Terminating forbidden process PID %d", pid)
 			if err := sysOps.Kill(pid, syscall.SIGKILL); err != nil {
 				log.Printf("Guardian: Failed to kill process %d: %v", pid, err)
+			} else {
+				events.Publish(events.Kill{App: name, PID: pid})
 			}
 		}
 	}
 }
 
+// procComm returns the command name of a process, or "" if it is gone.
+func procComm(pid int) string {
+	b, err := fsOps.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
 func isForbidden(pid int, apps []string) bool {
 	commPath := filepath.Join("/proc", strconv.Itoa(pid), "comm")
 	commBytes, err := fsOps.ReadFile(commPath)
@@ -1,10 +1,22 @@
 package guardian
 
 import (
+	"context"
 	"io/fs"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+	"github.com/vishvananda/netlink"
 )
 
 // -- Mocks --
@@ -100,13 +112,13 @@ func (m mockDirEntry) Info() (fs.FileInfo, error) { return nil, nil }
 
 // -- Tests --
 
-func TestSetOOMScore(t *testing.T) {
+func TestShieldDaemon(t *testing.T) {
 	mockFS := &MockFileSystem{}
 	fsOps = mockFS
 
-	err := SetOOMScore(-1000)
+	err := ShieldDaemon()
 	if err != nil {
-		t.Fatalf("SetOOMScore failed: %v", err)
+		t.Fatalf("ShieldDaemon failed: %v", err)
 	}
 
 	content, ok := mockFS.WrittenFiles["/proc/self/oom_score_adj"]
@@ -118,6 +130,74 @@ func TestSetOOMScore(t *testing.T) {
 	}
 }
 
+func TestSetOOMScore(t *testing.T) {
+	OOMSavedFile = filepath.Join(t.TempDir(), "oom-saved.json")
+	defer func() { OOMTargets = nil }()
+
+	// 100 is firefox in the subject's session, 200 a system service and
+	// 300 chrome, which sets its own score.
+	scores := map[string]string{"100": "0", "200": "0", "300": "300"}
+	mockFS := &MockFileSystem{
+		ReadDirFunc: func(name string) ([]fs.DirEntry, error) {
+			return []fs.DirEntry{
+				mockDirEntry{name: "100", isDir: true},
+				mockDirEntry{name: "200", isDir: true},
+				mockDirEntry{name: "300", isDir: true},
+				mockDirEntry{name: "self", isDir: true},
+			}, nil
+		},
+		ReadFileFunc: func(name string) ([]byte, error) {
+			pid, file := filepath.Base(filepath.Dir(name)), filepath.Base(name)
+			switch file {
+			case "cgroup":
+				if pid == "200" {
+					return []byte("0::/system.slice/cups.service\n"), nil
+				}
+				return []byte("0::/user.slice/user-1000.slice/session-2.scope\n"), nil
+			case "comm":
+				return []byte(map[string]string{"100": "firefox", "200": "cupsd", "300": "chrome"}[pid]), nil
+			case "oom_score_adj":
+				return []byte(scores[pid]), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	mockFS.WriteFileFunc = func(name string, data []byte, perm os.FileMode) error {
+		scores[filepath.Base(filepath.Dir(name))] = string(data)
+		return nil
+	}
+	fsOps = mockFS
+	sysOps = &MockSystemOps{GetpidFunc: func() int { return 999 }}
+
+	if err := SetOOMScore(1000); err != nil {
+		t.Fatalf("SetOOMScore failed: %v", err)
+	}
+	want := map[string]string{"100": "1000", "200": "0", "300": "1000"}
+	if !reflect.DeepEqual(scores, want) {
+		t.Errorf("Expected the penalty on the session's processes only, got %v", scores)
+	}
+	if _, ok := mockFS.WrittenFiles["/proc/self/oom_score_adj"]; ok {
+		t.Error("The penalty must not touch vexd's own score")
+	}
+
+	// Back to their own scores.
+	if err := SetOOMScore(0); err != nil {
+		t.Fatalf("SetOOMScore(0) failed: %v", err)
+	}
+	want = map[string]string{"100": "0", "200": "0", "300": "300"}
+	if !reflect.DeepEqual(scores, want) {
+		t.Errorf("Expected the original scores back, got %v", scores)
+	}
+
+	OOMTargets = []string{"Chrom*"}
+	SetOOMScore(500)
+	want = map[string]string{"100": "0", "200": "0", "300": "500"}
+	if !reflect.DeepEqual(scores, want) {
+		t.Errorf("Expected the penalty on chrome only, got %v", scores)
+	}
+	SetOOMScore(0)
+}
+
 func TestScanAndReap_KillsForbidden(t *testing.T) {
 	// Setup Mocks
 	mockFS := &MockFileSystem{
@@ -166,6 +246,75 @@ func TestScanAndReap_KillsForbidden(t *testing.T) {
 	}
 }
 
+func TestScanAndReap_OtherUser(t *testing.T) {
+	users.ProcDir = t.TempDir()
+	os.Mkdir(filepath.Join(users.ProcDir, "300"), 0755)
+	defer func() {
+		users.ProcDir = "/proc"
+		users.Targets = nil
+		users.Resolve()
+	}()
+
+	fsOps = &MockFileSystem{
+		ReadDirFunc: func(name string) ([]fs.DirEntry, error) {
+			return []fs.DirEntry{mockDirEntry{name: "300", isDir: true}}, nil
+		},
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if name == "/proc/300/comm" {
+				return []byte("steam"), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	mockSys := &MockSystemOps{GetpidFunc: func() int { return 999 }}
+	sysOps = mockSys
+
+	users.Targets = []string{strconv.Itoa(os.Getuid() + 1)}
+	users.Resolve()
+	scanAndReap()
+	if len(mockSys.KilledPids) != 0 {
+		t.Errorf("Expected another user's process to be spared, killed %v", mockSys.KilledPids)
+	}
+
+	users.Targets = []string{strconv.Itoa(os.Getuid())}
+	users.Resolve()
+	scanAndReap()
+	if !slices.Equal(mockSys.KilledPids, []int{300}) {
+		t.Errorf("Expected the target user's process to be killed, killed %v", mockSys.KilledPids)
+	}
+}
+
+func TestScanAndReap_DryRun(t *testing.T) {
+	subsystem.Set(subsystem.Guardian, subsystem.DryRun)
+	defer func() {
+		subsystem.Set(subsystem.Guardian, subsystem.Enforce)
+		dryRunSpared = make(map[int]bool)
+	}()
+
+	fsOps = &MockFileSystem{
+		ReadDirFunc: func(name string) ([]fs.DirEntry, error) {
+			return []fs.DirEntry{mockDirEntry{name: "300", isDir: true}}, nil
+		},
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if name == "/proc/300/comm" {
+				return []byte("steam"), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	mockSys := &MockSystemOps{GetpidFunc: func() int { return 999 }}
+	sysOps = mockSys
+
+	scanAndReap()
+	scanAndReap()
+	if len(mockSys.KilledPids) != 0 {
+		t.Errorf("Expected a dry run to kill nothing, killed %v", mockSys.KilledPids)
+	}
+	if !dryRunSpared[300] {
+		t.Error("Expected the forbidden process to be reported as spared")
+	}
+}
+
 func TestIsForbidden_MatchesCmdline(t *testing.T) {
 	mockFS := &MockFileSystem{
 		ReadFileFunc: func(name string) ([]byte, error) {
@@ -255,3 +404,348 @@ func TestScanAndReap_CreatesDefaultConfig(t *testing.T) {
 		t.Error("Expected forbidden-apps.json to be created, but it was not")
 	}
 }
+
+func TestReloadLists(t *testing.T) {
+	files := map[string]string{
+		"blocked-domains.json": `{"blocked_domains": ["a.com", "b.com"]}`,
+		"forbidden-apps.json":  `{"forbidden_apps": ["steam"]}`,
+	}
+	fsOps = &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if data, ok := files[name]; ok {
+				return []byte(data), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	var setups, clears int
+	var rules []string
+	fwOps = &MockFirewallOps{
+		SetupFunc: func(domains []string) error { setups++; rules = domains; return nil },
+		ClearFunc: func() error { clears++; return nil },
+	}
+	defaults := DefaultBlockedDomains
+	DefaultBlockedDomains = nil
+	defer func() {
+		DefaultBlockedDomains = defaults
+		stopDNSRefresh()
+	}()
+
+	listDomains, listApps = loadBlockedDomains(), loadForbiddenApps()
+	activeDomains = []string{"a.com", "b.com", "manual.com"}
+
+	if ch, err := ReloadLists(); err != nil || !ch.Empty() || setups != 0 {
+		t.Fatalf("Expected an unchanged reload to do nothing, got %+v, %v, %d setups", ch, err, setups)
+	}
+
+	files["blocked-domains.json"] = `{"blocked_domains": ["b.com", "c.com"]}`
+	files["forbidden-apps.json"] = `{"forbidden_apps": ["steam", "lutris"]}`
+	ch, err := ReloadLists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ch.DomainsAdded, []string{"c.com"}) || !slices.Equal(ch.DomainsRemoved, []string{"a.com"}) ||
+		!slices.Equal(ch.AppsAdded, []string{"lutris"}) || len(ch.AppsRemoved) != 0 {
+		t.Errorf("Unexpected changes %+v", ch)
+	}
+	if want := []string{"b.com", "manual.com", "c.com"}; !slices.Equal(rules, want) {
+		t.Errorf("Expected the firewall to be set up for %v, got %v", want, rules)
+	}
+	if clears != 0 {
+		t.Errorf("Expected the rules to be replaced without clearing the table, got %d clears", clears)
+	}
+}
+
+func TestResolveAll_SlowResolver(t *testing.T) {
+	DNSLookupTimeout = 50 * time.Millisecond
+	defer func() { DNSLookupTimeout, lookupHost = 5*time.Second, net.DefaultResolver.LookupHost }()
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "reddit.com" {
+			return []string{"151.101.1.140"}, nil
+		}
+		<-ctx.Done() // an unreachable resolver
+		return nil, ctx.Err()
+	}
+
+	domains := []string{"reddit.com", "twitch.tv", "youtube.com", "store.steampowered.com"}
+	start := time.Now()
+	ips := resolveAll(domains)
+	if took := time.Since(start); took > 4*DNSLookupTimeout {
+		t.Errorf("Expected the lookups to time out together, took %s", took)
+	}
+	if len(ips[0]) != 1 || len(ips[1]) != 0 {
+		t.Errorf("Unexpected resolutions %v", ips)
+	}
+}
+
+func TestNormalizeEntry(t *testing.T) {
+	for in, want := range map[string]string{
+		" Reddit.com ":    "reddit.com",
+		"157.240.12.0/16": "157.240.0.0/16",
+		"1.2.3.4":         "1.2.3.4/32",
+		"as32934":         "AS32934",
+	} {
+		if got, err := NormalizeEntry(in); err != nil || got != want {
+			t.Errorf("NormalizeEntry(%q) = %q, %v; expected %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "2a03:2880::/32", "10.0.0.0/33"} {
+		if _, err := NormalizeEntry(in); err == nil {
+			t.Errorf("Expected NormalizeEntry(%q) to fail", in)
+		}
+	}
+}
+
+func TestMergePrefixes(t *testing.T) {
+	var prefixes []*net.IPNet
+	for _, p := range []string{"10.0.1.0/24", "10.0.0.0/16", "10.1.0.0/16", "192.168.0.0/24", "255.255.255.0/24"} {
+		_, ipnet, _ := net.ParseCIDR(p)
+		prefixes = append(prefixes, ipnet)
+	}
+	ranges := mergePrefixes(prefixes)
+	want := []ipRange{{0x0a000000, 0x0a01ffff}, {0xc0a80000, 0xc0a800ff}, {0xffffff00, 0xffffffff}}
+	if !slices.Equal(ranges, want) {
+		t.Fatalf("Expected %x, got %x", want, ranges)
+	}
+	if elems := buildPrefixSetElements(ranges); len(elems) != 5 || !elems[1].IntervalEnd || elems[4].IntervalEnd {
+		t.Errorf("Unexpected set elements %+v", elems)
+	}
+}
+
+func TestAddDomain_ASN(t *testing.T) {
+	var setups int
+	fwOps = &MockFirewallOps{SetupFunc: func([]string) error { setups++; return nil }}
+	lookups := 0
+	lookupASN = func(ctx context.Context, asn uint32) ([]*net.IPNet, error) {
+		lookups++
+		if asn != 32934 {
+			return nil, os.ErrDeadlineExceeded
+		}
+		_, ipnet, _ := net.ParseCIDR("157.240.0.0/16")
+		return []*net.IPNet{ipnet}, nil
+	}
+	defer func() {
+		lookupASN = fetchASNPrefixes
+		clear(asnCache)
+		activeDomains = nil
+		stopDNSRefresh()
+	}()
+	activeDomains = nil
+
+	if added, err := AddDomain("AS64496"); err == nil || added || setups != 0 {
+		t.Errorf("Expected an AS that cannot be looked up to be refused, got %v, %v", added, err)
+	}
+	if added, err := AddDomain("as32934"); err != nil || !added {
+		t.Fatalf("Expected AS32934 to be added, got %v, %v", added, err)
+	}
+	if !slices.Equal(activeDomains, []string{"AS32934"}) {
+		t.Errorf("Unexpected blocklist %v", activeDomains)
+	}
+	if _, prefixes := splitEntries(activeDomains); len(prefixes) != 1 || lookups != 2 {
+		t.Errorf("Expected the cached prefix, got %v after %d lookups", prefixes, lookups)
+	}
+	if removed, err := RemoveDomain("as32934"); err != nil || !removed {
+		t.Errorf("Expected AS32934 to be removed, got %v, %v", removed, err)
+	}
+}
+
+func TestFindTunnels(t *testing.T) {
+	up := net.FlagUp
+	listLinks = func() ([]netlink.Link, error) {
+		return []netlink.Link{
+			&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: up}},
+			&netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: "wg0", Flags: up}},
+			&netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: "tailscale0", Flags: up}},
+			&netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "tun0"}}, // down
+		}, nil
+	}
+	const header = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	files := map[string]string{
+		"/proc/net/tcp": header +
+			"   0: 0100007F:C350 0100007F:235A 01 00000000:00000000 00:00000000 00000000  1000        0 1\n" + // 127.0.0.1:9050
+			"   1: 0200000A:C351 057100CB:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 2\n" + // 203.0.113.5:443
+			"   2: 0200000A:C352 08080808:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 3\n" + // 8.8.8.8:443
+			"   3: 00000000:2382 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4\n", // listening
+		"/proc/net/udp": header +
+			"   0: 0200000A:D431 0D0000C6:CA6C 01 00000000:00000000 00:00000000 00000000  1000        0 5\n", // 198.0.0.13:51820
+	}
+	fsOps = &MockFileSystem{ReadFileFunc: func(name string) ([]byte, error) {
+		if data, ok := files[name]; ok {
+			return []byte(data), nil
+		}
+		return nil, os.ErrNotExist
+	}}
+	AllowedTunnels, RelayPrefixes = []string{"tailscale0"}, []string{"203.0.113.0/24"}
+	defer func() { listLinks, AllowedTunnels, RelayPrefixes = netlink.LinkList, nil, nil }()
+
+	var got []string
+	for _, tun := range findTunnels() {
+		got = append(got, tun.String())
+	}
+	want := []string{
+		"wg0 (wireguard)",
+		"127.0.0.1:9050 (Tor SOCKS port)",
+		"203.0.113.5:443 (known relay 203.0.113.0/24)",
+		"198.0.0.13:51820 (WireGuard port)",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestBlockTunnel(t *testing.T) {
+	var clears int
+	fwOps = &MockFirewallOps{ClearFunc: func() error { clears++; return nil }}
+	activeDomains = []string{"reddit.com"}
+	defer func() {
+		activeDomains = nil
+		forgetTunnels()
+		stopDNSRefresh()
+	}()
+
+	for _, tun := range []Tunnel{
+		{Interface: "wg0", Detail: "wireguard"},
+		{Remote: "127.0.0.1:9050", Detail: "Tor SOCKS port"},
+		{Remote: "203.0.113.5:443", Detail: "known relay"},
+	} {
+		if err := BlockTunnel(tun); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tunnels, relays := blockedTunnelRules()
+	if !slices.Equal(tunnels, []string{"wg0"}) || len(relays) != 1 || relays[0].String() != "203.0.113.5/32" {
+		t.Errorf("Unexpected tunnel rules %v, %v", tunnels, relays)
+	}
+
+	if _, err := RemoveDomain("reddit.com"); err != nil {
+		t.Fatal(err)
+	}
+	if tunnels, relays := blockedTunnelRules(); clears != 1 || tunnels != nil || relays != nil {
+		t.Errorf("Expected lifting the blocks to forget the tunnels, got %v, %v", tunnels, relays)
+	}
+}
+
+// dnsQuery builds a query for name with the given ID.
+func dnsQuery(id uint16, name string) []byte {
+	q := []byte{byte(id >> 8), byte(id), 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, l := range strings.Split(name, ".") {
+		q = append(q, byte(len(l)))
+		q = append(q, l...)
+	}
+	return append(q, 0, 0, 1, 0, 1) // A, IN
+}
+
+func TestStubRefusesBlocked(t *testing.T) {
+	setStubBlocked([]string{"Reddit.com", "twitch.tv"})
+	defer setStubBlocked(nil)
+
+	for name, want := range map[string]bool{
+		"reddit.com": true, "old.reddit.com": true, "www.twitch.tv": true,
+		"notreddit.com": false, "example.org": false,
+	} {
+		if got := stubRefuses(name); got != want {
+			t.Errorf("stubRefuses(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	q := dnsQuery(0xbeef, "WWW.Reddit.com")
+	resp := stubAnswer(q, "udp")
+	if len(resp) != len(q) || resp[0] != 0xbe || resp[1] != 0xef {
+		t.Fatalf("Expected a response to the query, got % x", resp)
+	}
+	if resp[2]&0x80 == 0 || resp[2]&0x01 == 0 || resp[3]&0x0f != 5 {
+		t.Errorf("Expected QR, RD and REFUSED, got flags % x", resp[2:4])
+	}
+	if name, _, ok := questionName(resp); !ok || name != "www.reddit.com" {
+		t.Errorf("Expected the question kept, got %q", name)
+	}
+}
+
+func TestNoteLookup(t *testing.T) {
+	SetMonitoredDomains([]string{"Reddit.com."})
+	defer SetMonitoredDomains(nil)
+	accesses := make(chan string, 4)
+	MonitoredAccess = func(domain string) { accesses <- domain }
+	defer func() { MonitoredAccess = nil }()
+	defer func(gap time.Duration) { AccessGap = gap }(AccessGap)
+
+	noteLookup("example.org")
+	noteLookup("old.reddit.com")
+	noteLookup("www.reddit.com") // the same access
+	if got := <-accesses; got != "reddit.com" {
+		t.Errorf("Expected an access to reddit.com, got %q", got)
+	}
+	AccessGap = 0
+	noteLookup("reddit.com")
+	if got := <-accesses; got != "reddit.com" {
+		t.Errorf("Expected a second access after the gap, got %q", got)
+	}
+	select {
+	case got := <-accesses:
+		t.Errorf("Unexpected access to %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestQuestionNameMalformed(t *testing.T) {
+	q := dnsQuery(1, "example.org")
+	for _, bad := range [][]byte{q[:10], q[:len(q)-2], append(q[:12:12], 0xc0, 0x0c, 0, 1, 0, 1)} {
+		if _, _, ok := questionName(bad); ok {
+			t.Errorf("Expected % x to be rejected", bad)
+		}
+	}
+}
+
+func TestStubUpstreams(t *testing.T) {
+	fsOps = &MockFileSystem{ReadFileFunc: func(name string) ([]byte, error) {
+		if name == "/etc/resolv.conf" {
+			return []byte("# generated\nnameserver 127.0.0.53\nnameserver 192.0.2.1\nnameserver 2001:db8::1\nsearch lan\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}}
+	got, err := upstreams()
+	if err != nil || !slices.Equal(got, []string{"192.0.2.1:53", "[2001:db8::1]:53"}) {
+		t.Errorf("Expected the non-loopback nameservers, got %v, %v", got, err)
+	}
+
+	StubUpstreams = []string{"198.51.100.7", "198.51.100.8:5353"}
+	defer func() { StubUpstreams = nil }()
+	if got, _ := upstreams(); !slices.Equal(got, []string{"198.51.100.7:53", "198.51.100.8:5353"}) {
+		t.Errorf("Expected the configured upstreams, got %v", got)
+	}
+
+	StubUpstreams = nil
+	fsOps = &MockFileSystem{}
+	if _, err := upstreams(); err == nil {
+		t.Error("Expected an error without any upstream")
+	}
+}
+
+func TestStubForwards(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("marking the upstream socket needs CAP_NET_ADMIN")
+	}
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		resp := append([]byte{}, buf[:n]...)
+		resp[2] |= 0x80
+		pc.WriteTo(resp, from)
+	}()
+	StubUpstreams = []string{pc.LocalAddr().String()}
+	defer func() { StubUpstreams = nil }()
+
+	q := dnsQuery(7, "example.org")
+	resp := stubAnswer(q, "udp")
+	if len(resp) != len(q) || resp[2]&0x80 == 0 || resp[3]&0x0f != 0 {
+		t.Errorf("Expected the upstream's answer, got % x", resp)
+	}
+}
@@ -0,0 +1,77 @@
+package guardian
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -- Monitored domains --
+//
+// A domain in monitor mode is not blocked: the stub resolver answers for
+// it as for any other, and reports each access to MonitoredAccess, which
+// decides when the warnings are over and the block starts.  A browser
+// looks a site up again and again while it is open, so lookups less than
+// AccessGap apart count as one access.
+
+var (
+	// MonitoredAccess is called, in its own goroutine, with each access to
+	// a monitored domain.  Set by vexd.
+	MonitoredAccess func(domain string)
+
+	// AccessGap is how long a monitored domain must go without a lookup
+	// for the next one to count as a new access.
+	AccessGap = 5 * time.Minute
+)
+
+var (
+	// stubMonitored are the monitored domains, lower case.
+	stubMonitored atomic.Pointer[[]string]
+
+	// lastLookup is when each monitored domain was last looked up.
+	lastLookupMu sync.Mutex
+	lastLookup   = make(map[string]time.Time)
+)
+
+// SetMonitoredDomains replaces the domains the stub resolver reports
+// accesses to.
+func SetMonitoredDomains(domains []string) {
+	list := make([]string, len(domains))
+	for i, d := range domains {
+		list[i] = strings.ToLower(strings.TrimSuffix(d, "."))
+	}
+	stubMonitored.Store(&list)
+}
+
+// monitoredDomain returns the monitored domain name is, or is below.
+func monitoredDomain(name string) (string, bool) {
+	list := stubMonitored.Load()
+	if list == nil {
+		return "", false
+	}
+	for _, d := range *list {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// noteLookup reports an access to the monitored domain name belongs to,
+// unless it was looked up less than AccessGap ago.
+func noteLookup(name string) {
+	domain, ok := monitoredDomain(name)
+	if !ok || MonitoredAccess == nil {
+		return
+	}
+	now := time.Now()
+	lastLookupMu.Lock()
+	last, seen := lastLookup[domain]
+	lastLookup[domain] = now
+	lastLookupMu.Unlock()
+	if seen && now.Sub(last) < AccessGap {
+		return
+	}
+	go MonitoredAccess(domain)
+}
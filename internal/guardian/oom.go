@@ -0,0 +1,210 @@
+package guardian
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// ---------------------------------------------------------------------
+// OOM Score
+// ---------------------------------------------------------------------
+//
+// vexd shields itself with -1000 at startup.  The OOM penalty is put on
+// the subject's processes instead: those in user.slice, of the target
+// users if set, and, with OOMTargets, only the matching apps.  Processes
+// started later get it within OOMInterval.  Each process's own score is
+// saved first and put back when the penalty goes to 0.
+
+var (
+	// OOMTargets are command globs the penalty is limited to, e.g.
+	// "firefox" or "steam*".  Empty means every process of the subject.
+	OOMTargets []string
+
+	// OOMInterval is how often the penalty is put on new processes.
+	OOMInterval = 10 * time.Second
+
+	// OOMSavedFile keeps the scores the penalty replaced, so that a
+	// restart in between can still put them back.  It is in /run as the
+	// PIDs mean nothing after a reboot.
+	OOMSavedFile = "/run/vex-cli/oom-saved.json"
+
+	oomMu      sync.Mutex
+	oomScore   int
+	oomRunning bool
+)
+
+// ShieldDaemon sets vexd's own OOM score to -1000, so the kernel never
+// picks it.
+func ShieldDaemon() error {
+	if subsystem.Skip(subsystem.Guardian, "set vexd's OOM score to -1000") {
+		return nil
+	}
+	path := "/proc/self/oom_score_adj"
+	if _, err := fsOps.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("%s not found", path)
+	}
+	return writeOOMScore(path, -1000)
+}
+
+// SetOOMScore puts the OOM score penalty on the subject's processes.
+// score: -1000 (never killed) to 1000 (killed first); 0 lifts it.
+func SetOOMScore(score int) error {
+	if subsystem.Skip(subsystem.Guardian, "set the subject's OOM score to %d", score) {
+		return nil
+	}
+	oomMu.Lock()
+	defer oomMu.Unlock()
+	oomScore = score
+	n, err := applyOOMScoreLocked()
+	if score == 0 {
+		log.Printf("Guardian: OOM score restored on %d processes", n)
+		return err
+	}
+	log.Printf("Guardian: OOM score %d on %d processes", score, n)
+	if !oomRunning {
+		oomRunning = true
+		supervisor.Go("guardian.oom", runOOM)
+	}
+	return err
+}
+
+// runOOM puts the penalty on new processes every OOMInterval until it is
+// lifted.
+func runOOM() error {
+	ticker := time.NewTicker(OOMInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		oomMu.Lock()
+		if oomScore == 0 {
+			oomRunning = false
+			oomMu.Unlock()
+			return nil
+		}
+		_, err := applyOOMScoreLocked()
+		oomMu.Unlock()
+		if err != nil {
+			log.Printf("Guardian: OOM score: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyOOMScoreLocked brings the subject's processes to oomScore, or
+// back to their own scores when it is 0.  It returns how many processes
+// it changed.
+func applyOOMScoreLocked() (int, error) {
+	saved := loadOOMSaved()
+	if oomScore == 0 {
+		var errs []error
+		for pid, own := range saved {
+			if err := writeOOMScore(oomPath(pid), own); err != nil && !exited(err) {
+				errs = append(errs, err)
+			}
+		}
+		os.Remove(OOMSavedFile)
+		return len(saved), errors.Join(errs...)
+	}
+
+	entries, err := fsOps.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	n := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == sysOps.Getpid() || pid == 1 || !isOOMTarget(pid) {
+			continue
+		}
+		data, err := fsOps.ReadFile(oomPath(pid))
+		if err != nil {
+			continue // gone
+		}
+		cur, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if cur == oomScore {
+			continue
+		}
+		if _, ok := saved[pid]; !ok {
+			saved[pid] = cur
+		}
+		if err := writeOOMScore(oomPath(pid), oomScore); err != nil {
+			if !exited(err) {
+				errs = append(errs, fmt.Errorf("PID %d: %w", pid, err))
+			}
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		if err := saveOOMSaved(saved); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// isOOMTarget reports whether the penalty applies to a process.
+func isOOMTarget(pid int) bool {
+	cg, err := fsOps.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil || !strings.Contains(string(cg), "/user.slice/") || !users.OwnsPID(pid) {
+		return false
+	}
+	if len(OOMTargets) == 0 {
+		return true
+	}
+	comm := strings.ToLower(procComm(pid))
+	for _, pattern := range OOMTargets {
+		if ok, _ := path.Match(strings.ToLower(pattern), comm); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func oomPath(pid int) string {
+	return filepath.Join("/proc", strconv.Itoa(pid), "oom_score_adj")
+}
+
+func writeOOMScore(path string, score int) error {
+	return fsOps.WriteFile(path, []byte(strconv.Itoa(score)), 0644)
+}
+
+// exited reports whether err is from writing to a process that is gone.
+func exited(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
+
+// loadOOMSaved reads the scores the penalty replaced, by PID.
+func loadOOMSaved() map[int]int {
+	saved := make(map[int]int)
+	if data, err := os.ReadFile(OOMSavedFile); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	return saved
+}
+
+func saveOOMSaved(saved map[int]int) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(OOMSavedFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(OOMSavedFile, data, 0o600)
+}
@@ -0,0 +1,205 @@
+package guardian
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -- Prefix and ASN entries --
+//
+// Besides domains, the blocklist takes IPv4 prefixes ("157.240.0.0/16",
+// or a bare address for a /32) and autonomous systems ("AS32934"), to take
+// out a whole provider.  An AS is resolved to the prefixes it announces
+// when it is added and again on every DNS refresh.  Prefixes of both kinds
+// go into one interval set, matched by a single rule.
+
+const prefixSetName = "blocked-prefixes"
+
+var (
+	// ASNPrefixURL is queried for the prefixes an AS announces; %d is the
+	// AS number.
+	ASNPrefixURL = "https://stat.ripe.net/data/announced-prefixes/data.json?resource=AS%d"
+
+	// ASNLookupTimeout bounds one ASN lookup.
+	ASNLookupTimeout = 15 * time.Second
+
+	lookupASN = fetchASNPrefixes
+
+	asnMu    sync.Mutex
+	asnCache = make(map[uint32][]*net.IPNet) // last successful lookup per AS
+)
+
+// NormalizeEntry checks a blocklist entry and returns it in the form it
+// is stored in: a lower-case domain, a prefix with its host bits cleared,
+// or "AS" and a number.
+func NormalizeEntry(entry string) (string, error) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	switch {
+	case entry == "":
+		return "", fmt.Errorf("empty domain")
+	case strings.Contains(entry, "/"):
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return "", fmt.Errorf("invalid prefix %q", entry)
+		}
+		if ipnet.IP.To4() == nil {
+			return "", fmt.Errorf("%s: only IPv4 prefixes can be blocked", entry)
+		}
+		return ipnet.String(), nil
+	case net.ParseIP(entry) != nil:
+		if net.ParseIP(entry).To4() == nil {
+			return "", fmt.Errorf("%s: only IPv4 addresses can be blocked", entry)
+		}
+		return entry + "/32", nil
+	}
+	if asn, ok := parseASN(entry); ok {
+		return "AS" + strconv.FormatUint(uint64(asn), 10), nil
+	}
+	return entry, nil
+}
+
+// parseASN parses "as32934" or "AS32934".
+func parseASN(entry string) (uint32, bool) {
+	digits, ok := strings.CutPrefix(strings.ToLower(entry), "as")
+	if !ok || digits == "" {
+		return 0, false
+	}
+	asn, err := strconv.ParseUint(digits, 10, 32)
+	return uint32(asn), err == nil
+}
+
+// splitEntries separates the domains from the prefixes, with the prefixes
+// of the ASes looked up.
+func splitEntries(entries []string) (domains []string, prefixes []*net.IPNet) {
+	var asns []uint32
+	for _, e := range entries {
+		if asn, ok := parseASN(e); ok {
+			asns = append(asns, asn)
+		} else if _, ipnet, err := net.ParseCIDR(e); err == nil {
+			prefixes = append(prefixes, ipnet)
+		} else {
+			domains = append(domains, e)
+		}
+	}
+	for _, asn := range asns {
+		p, err := resolveASN(asn, false)
+		if err != nil {
+			log.Printf("Guardian: WARNING — could not look up AS%d, skipping: %v", asn, err)
+			continue
+		}
+		prefixes = append(prefixes, p...)
+	}
+	return domains, prefixes
+}
+
+// resolveASN returns the IPv4 prefixes asn announces, from the cache
+// unless refresh is set or it has not been looked up yet.  A failed
+// refresh keeps the cached prefixes.
+func resolveASN(asn uint32, refresh bool) ([]*net.IPNet, error) {
+	asnMu.Lock()
+	cached, ok := asnCache[asn]
+	asnMu.Unlock()
+	if ok && !refresh {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ASNLookupTimeout)
+	defer cancel()
+	prefixes, err := lookupASN(ctx, asn)
+	if err == nil && len(prefixes) == 0 {
+		err = fmt.Errorf("AS%d announces no IPv4 prefixes", asn)
+	}
+	if err != nil {
+		if ok {
+			log.Printf("Guardian: AS%d lookup failed, keeping %d known prefixes: %v", asn, len(cached), err)
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	asnMu.Lock()
+	asnCache[asn] = prefixes
+	asnMu.Unlock()
+	log.Printf("Guardian: AS%d announces %d IPv4 prefixes", asn, len(prefixes))
+	return prefixes, nil
+}
+
+// refreshASNs looks up the ASes among entries again.
+func refreshASNs(entries []string) {
+	for _, e := range entries {
+		if asn, ok := parseASN(e); ok {
+			resolveASN(asn, true)
+		}
+	}
+}
+
+// fetchASNPrefixes asks ASNPrefixURL (RIPEstat) for the IPv4 prefixes an
+// AS announces.
+func fetchASNPrefixes(ctx context.Context, asn uint32) ([]*net.IPNet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(ASNPrefixURL, asn), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("AS%d lookup: %s", asn, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Prefixes []struct {
+				Prefix string `json:"prefix"`
+			} `json:"prefixes"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("AS%d lookup: %w", asn, err)
+	}
+	var prefixes []*net.IPNet
+	for _, p := range body.Data.Prefixes {
+		if _, ipnet, err := net.ParseCIDR(p.Prefix); err == nil && ipnet.IP.To4() != nil {
+			prefixes = append(prefixes, ipnet)
+		}
+	}
+	return prefixes, nil
+}
+
+// ipRange is an inclusive range of IPv4 addresses.
+type ipRange struct{ first, last uint32 }
+
+// mergePrefixes turns prefixes into sorted ranges that neither overlap
+// nor touch, which an interval set requires.
+func mergePrefixes(prefixes []*net.IPNet) []ipRange {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		first := binary.BigEndian.Uint32(p.IP.To4())
+		ones, bits := p.Mask.Size()
+		ranges = append(ranges, ipRange{first, first | uint32(uint64(1)<<(bits-ones)-1)})
+	}
+	slices.SortFunc(ranges, func(a, b ipRange) int { return cmp.Compare(a.first, b.first) })
+
+	var merged []ipRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && uint64(r.first) <= uint64(merged[n-1].last)+1 {
+			merged[n-1].last = max(merged[n-1].last, r.last)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,444 @@
+package guardian
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// -- Stub resolver --
+//
+// The per-address rules only hold as long as the addresses a domain
+// resolves to, re-resolved every DNSRefreshInterval, are the ones the
+// subject is served.  With StubResolver on, vexd also answers DNS itself:
+// a nat chain in the vex-guardian table redirects queries to port 53 on
+// any non-loopback address to a stub on 127.0.0.1:StubPort, which refuses
+// names under a blocked domain and passes the rest to the upstream
+// servers.  A local cache such as systemd-resolved keeps working, as its
+// own queries to the network are the ones redirected.
+
+var (
+	// StubResolver turns the stub resolver on.  Read by Init.
+	StubResolver bool
+
+	// StubUpstreams are the servers the stub asks, as host or host:port.
+	// Empty means the non-loopback nameservers in ResolvConfFiles.
+	StubUpstreams []string
+
+	// StubPort is the port the stub listens on.
+	StubPort = 5300
+
+	// StubTimeout bounds a query to one upstream server.
+	StubTimeout = 5 * time.Second
+
+	// ResolvConfFiles are read, in order, for the default upstreams; the
+	// first is where systemd-resolved lists the servers behind its stub.
+	ResolvConfFiles = []string{"/run/systemd/resolve/resolv.conf", "/etc/resolv.conf"}
+)
+
+// StubMark is the firewall mark on the stub's own queries, which the
+// redirect lets through.
+const StubMark = 0x107
+
+const natChainName = "nat-output"
+
+var (
+	stubRunning atomic.Bool
+	// stubBlocked are the blocked domains the stub refuses, lower case.
+	stubBlocked atomic.Pointer[[]string]
+
+	// stubLogged are the names already logged as refused since the
+	// blocklist last changed, so a retrying client does not flood the log.
+	stubLoggedMu sync.Mutex
+	stubLogged   = make(map[string]bool)
+)
+
+// StubActive reports whether the stub resolver is listening.
+func StubActive() bool { return stubRunning.Load() }
+
+// setStubBlocked replaces the domains the stub refuses.
+func setStubBlocked(domains []string) {
+	list := make([]string, len(domains))
+	for i, d := range domains {
+		list[i] = strings.ToLower(strings.TrimSuffix(d, "."))
+	}
+	stubBlocked.Store(&list)
+	stubLoggedMu.Lock()
+	clear(stubLogged)
+	stubLoggedMu.Unlock()
+}
+
+// stubRefuses reports whether name is a blocked domain or below one.
+func stubRefuses(name string) bool {
+	list := stubBlocked.Load()
+	if list == nil {
+		return false
+	}
+	for _, d := range *list {
+		if name == d || strings.HasSuffix(name, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// startStub listens for queries on 127.0.0.1:StubPort, over UDP and TCP.
+// Without a listener the redirect is left out and the per-address rules
+// block on their own.
+func startStub() error {
+	if subsystem.Skip(subsystem.Guardian, "answer DNS on 127.0.0.1:%d", StubPort) {
+		return nil
+	}
+	if _, err := upstreams(); err != nil {
+		return err
+	}
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(StubPort))
+	pc, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	supervisor.Go("guardian.dns-stub", func() error { return serveStubUDP(pc) })
+	supervisor.Go("guardian.dns-stub-tcp", func() error { return serveStubTCP(ln) })
+	stubRunning.Store(true)
+	// Blocked domains are still resolved for the per-address rules, which
+	// the local resolver would now have refused.
+	lookupHost = (&net.Resolver{PreferGo: true, Dial: dialUpstream}).LookupHost
+	log.Printf("Guardian: Stub resolver listening on %s", addr)
+	return nil
+}
+
+func serveStubUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		q := bytes.Clone(buf[:n])
+		go func() {
+			if resp := stubAnswer(q, "udp"); resp != nil {
+				pc.WriteTo(resp, from)
+			}
+		}()
+	}
+}
+
+func serveStubTCP(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				c.SetDeadline(time.Now().Add(2 * StubTimeout))
+				q, err := readTCPMessage(r)
+				if err != nil {
+					return
+				}
+				resp := stubAnswer(q, "tcp")
+				if resp == nil || writeTCPMessage(c, resp) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// stubAnswer refuses a query for a blocked name and forwards any other,
+// over network.  It returns nil when no upstream answered.
+func stubAnswer(q []byte, network string) []byte {
+	name, end, ok := questionName(q)
+	if ok && stubRefuses(name) {
+		stubLoggedMu.Lock()
+		first := !stubLogged[name]
+		stubLogged[name] = true
+		stubLoggedMu.Unlock()
+		if first {
+			log.Printf("Guardian: Stub resolver refused %s", name)
+		}
+		return refusal(q[:end])
+	}
+	if ok {
+		noteLookup(name)
+	}
+	resp, err := forward(q, network)
+	if err != nil {
+		log.Printf("Guardian: Stub resolver could not forward %s: %v", name, err)
+		return nil
+	}
+	return resp
+}
+
+// questionName returns the lower-case name of q's first question and the
+// offset just past that question.
+func questionName(q []byte) (string, int, bool) {
+	if len(q) < 12 || binary.BigEndian.Uint16(q[4:6]) == 0 {
+		return "", 0, false
+	}
+	var labels []string
+	i := 12
+	for {
+		if i >= len(q) {
+			return "", 0, false
+		}
+		l := int(q[i])
+		i++
+		if l == 0 {
+			break
+		}
+		if l&0xc0 != 0 || i+l > len(q) { // a question name is never compressed
+			return "", 0, false
+		}
+		labels = append(labels, strings.ToLower(string(q[i:i+l])))
+		i += l
+	}
+	if i+4 > len(q) { // type and class
+		return "", 0, false
+	}
+	return strings.Join(labels, "."), i + 4, true
+}
+
+// refusal turns a query, cut after its first question, into a REFUSED
+// response to it.
+func refusal(q []byte) []byte {
+	resp := bytes.Clone(q)
+	resp[2] = 0x80 | resp[2]&0x79 // QR, keeping the opcode and RD
+	resp[3] = 0x80 | 5            // RA, RCODE REFUSED
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	clear(resp[6:12])
+	return resp
+}
+
+// forward asks each upstream in turn until one answers.
+func forward(q []byte, network string) ([]byte, error) {
+	servers, err := upstreams()
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: StubTimeout, Control: markStubSocket}
+	var errs []error
+	for _, s := range servers {
+		resp, err := exchange(&d, network, s, q)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func exchange(d *net.Dialer, network, server string, q []byte) ([]byte, error) {
+	c, err := d.Dial(network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(StubTimeout))
+	if network == "tcp" {
+		if err := writeTCPMessage(c, q); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(bufio.NewReader(c))
+	}
+	if _, err := c.Write(q); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && bytes.Equal(buf[:2], q[:2]) { // the ID of the query
+			return bytes.Clone(buf[:n]), nil
+		}
+	}
+}
+
+// dialUpstream connects to the first upstream server, past the redirect,
+// whatever address the resolver asked for.
+func dialUpstream(ctx context.Context, network, _ string) (net.Conn, error) {
+	servers, err := upstreams()
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Control: markStubSocket}
+	return d.DialContext(ctx, network, servers[0])
+}
+
+// markStubSocket sets StubMark on an upstream socket.
+func markStubSocket(network, address string, rc syscall.RawConn) error {
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, StubMark)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// upstreams returns the servers to forward to, as host:port.
+func upstreams() ([]string, error) {
+	list := StubUpstreams
+	if len(list) == 0 {
+		for _, f := range ResolvConfFiles {
+			if list = nameservers(f); len(list) > 0 {
+				break
+			}
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no upstream nameserver: set guardian.stub_upstreams")
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = s
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			out[i] = net.JoinHostPort(s, "53")
+		}
+	}
+	return out, nil
+}
+
+// nameservers returns the non-loopback nameservers listed in a
+// resolv.conf.
+func nameservers(path string) []string {
+	data, err := fsOps.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var list []string
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 || f[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(f[1]); ip != nil && !ip.IsLoopback() {
+			list = append(list, f[1])
+		}
+	}
+	return list
+}
+
+// addStubRedirect adds the nat chain that sends DNS to the stub, or, with
+// the stub not running, drops one a previous run left.
+func addStubRedirect(conn *nftables.Conn, table *nftables.Table) {
+	chain := &nftables.Chain{
+		Name:     natChainName,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityNATDest,
+	}
+	if !stubRunning.Load() {
+		if existing, _ := conn.ListChain(table, natChainName); existing != nil {
+			conn.FlushChain(chain)
+			conn.DelChain(chain)
+		}
+		return
+	}
+	conn.AddChain(chain)
+	conn.FlushChain(chain)
+	for _, proto := range []byte{unix.IPPROTO_UDP, unix.IPPROTO_TCP} {
+		conn.AddRule(&nftables.Rule{Table: table, Chain: chain, Exprs: buildStubRedirectExprs(proto)})
+	}
+}
+
+// removeStubRedirect deletes the nat chain, so that DNS goes straight to
+// the servers while the stub is not there to answer.
+func removeStubRedirect() error {
+	if !stubRunning.Load() {
+		return nil
+	}
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+	table := &nftables.Table{Name: "vex-guardian", Family: nftables.TableFamilyIPv4}
+	if existing, _ := conn.ListChain(table, natChainName); existing == nil {
+		return nil
+	}
+	chain := &nftables.Chain{Name: natChainName, Table: table}
+	conn.FlushChain(chain)
+	conn.DelChain(chain)
+	return conn.Flush()
+}
+
+// buildStubRedirectExprs redirects proto packets to port 53 on a
+// non-loopback address to StubPort, other than the stub's own.
+func buildStubRedirectExprs(proto byte) []expr.Any {
+	var exprs []expr.Any
+	if users.Scoped() {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(users.Mark)},
+		)
+	} else {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(StubMark)},
+		)
+	}
+	return append(exprs,
+		// meta l4proto proto
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+
+		// ip daddr != 127.0.0.0/8
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{255, 0, 0, 0}, Xor: []byte{0, 0, 0, 0}},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{127, 0, 0, 0}},
+
+		// th dport 53
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(53)},
+
+		// redirect to :StubPort
+		&expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(StubPort))},
+		&expr.Redir{RegisterProtoMin: 1},
+	)
+}
@@ -0,0 +1,264 @@
+package guardian
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+	"github.com/vishvananda/netlink"
+)
+
+// -- Tunnel and proxy detection --
+//
+// A VPN, Tor or a SOCKS proxy routes around the per-address rules.  While
+// blocks are in force, the tunnel watch looks every TunnelCheckInterval
+// for tunnel interfaces that are up (tun/tap, WireGuard, IP-in-IP and GRE)
+// and for connections that look like a proxy or relay: to a port in
+// TunnelPorts, or to an address in RelayPrefixes.  vexd responds to each
+// new one as TunnelResponse says.
+
+// Tunnel responses.
+const (
+	TunnelBlock    = "block"    // drop traffic through the interface or to the relay
+	TunnelEscalate = "escalate" // record a penance failure
+	TunnelNotify   = "notify"   // send tunnel_detected to the keyholder
+)
+
+// Tunnel is an interface or connection found by the tunnel watch.
+type Tunnel struct {
+	Interface string // set for a tunnel interface
+	Remote    string // set for a connection: the relay's address and port
+	Detail    string // the link kind, or why the connection looks like a proxy
+}
+
+// Target is the interface name or the remote address.
+func (t Tunnel) Target() string {
+	if t.Interface != "" {
+		return t.Interface
+	}
+	return t.Remote
+}
+
+func (t Tunnel) String() string { return fmt.Sprintf("%s (%s)", t.Target(), t.Detail) }
+
+var (
+	// TunnelResponse is what vexd does about a tunnel: any of TunnelBlock,
+	// TunnelEscalate and TunnelNotify.
+	TunnelResponse = []string{TunnelNotify}
+
+	// TunnelCheckInterval is how often the tunnel watch looks.
+	TunnelCheckInterval = 10 * time.Second
+
+	// AllowedTunnels are interfaces never reported, such as a mesh VPN
+	// the machine needs.
+	AllowedTunnels []string
+
+	// RelayPrefixes are IPv4 prefixes of known relays and VPN endpoints,
+	// e.g. the Tor relay list.
+	RelayPrefixes []string
+
+	// TunnelPorts are remote ports proxies and tunnels are reached on.
+	TunnelPorts = map[int]string{
+		1080:  "SOCKS",
+		1194:  "OpenVPN",
+		9001:  "Tor ORPort",
+		9030:  "Tor DirPort",
+		9050:  "Tor SOCKS",
+		9150:  "Tor Browser SOCKS",
+		51820: "WireGuard",
+	}
+
+	tunnelKinds = []string{"tuntap", "wireguard", "ipip", "gre", "gretap", "sit", "ip6tnl", "vti", "vti6"}
+
+	listLinks  = netlink.LinkList
+	procNetDir = "/proc/net"
+
+	tunnelMu       sync.Mutex
+	blockedTunnels []string     // interfaces the firewall drops everything through
+	blockedRelays  []*net.IPNet // relays added to the prefix set
+)
+
+// WatchTunnels starts the tunnel watch.  detected is called for each
+// tunnel that was not there at the previous look, and again at every look
+// while it returns false, e.g. because no blocks are in force.
+func WatchTunnels(detected func(Tunnel) bool) {
+	supervisor.Go("guardian.tunnel-watch", func() error {
+		reported := make(map[Tunnel]bool)
+		ticker := time.NewTicker(TunnelCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			found := findTunnels()
+			next := make(map[Tunnel]bool, len(found))
+			for _, t := range found {
+				next[t] = reported[t] || detected(t)
+			}
+			reported = next
+		}
+		return nil
+	})
+	log.Printf("Guardian: Tunnel watch started (%s interval)", TunnelCheckInterval)
+}
+
+// findTunnels lists the tunnel interfaces and proxy connections there are
+// now.
+func findTunnels() []Tunnel {
+	var found []Tunnel
+	links, err := listLinks()
+	if err != nil {
+		log.Printf("Guardian: Tunnel watch: list links: %v", err)
+	}
+	for _, l := range links {
+		a := l.Attrs()
+		if a.Flags&net.FlagUp == 0 || !slices.Contains(tunnelKinds, l.Type()) || slices.Contains(AllowedTunnels, a.Name) {
+			continue
+		}
+		found = append(found, Tunnel{Interface: a.Name, Detail: l.Type()})
+	}
+
+	relays := parsePrefixes(RelayPrefixes)
+	for _, proto := range []string{"tcp", "udp"} {
+		for _, c := range readConnections(filepath.Join(procNetDir, proto)) {
+			if users.Scoped() && !users.Includes(c.uid) {
+				continue
+			}
+			if why, ok := proxyConnection(c, relays); ok {
+				found = append(found, Tunnel{Remote: fmt.Sprintf("%s:%d", c.ip, c.port), Detail: why})
+			}
+		}
+	}
+	return found
+}
+
+// proxyConnection reports whether c looks like a proxy or relay, and why.
+func proxyConnection(c connection, relays []*net.IPNet) (string, bool) {
+	if why, ok := TunnelPorts[c.port]; ok {
+		return why + " port", true
+	}
+	for _, p := range relays {
+		if p.Contains(c.ip) {
+			return "known relay " + p.String(), true
+		}
+	}
+	return "", false
+}
+
+// parsePrefixes parses prefixes, skipping any that do not parse.
+func parsePrefixes(prefixes []string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, p := range prefixes {
+		if _, ipnet, err := net.ParseCIDR(p); err == nil {
+			out = append(out, ipnet)
+		}
+	}
+	return out
+}
+
+// connection is an established IPv4 socket from /proc/net/tcp or udp.
+type connection struct {
+	ip   net.IP
+	port int
+	uid  uint32
+}
+
+// readConnections lists the established sockets in a /proc/net/tcp or
+// /proc/net/udp table.  A connected UDP socket counts as established.
+func readConnections(path string) []connection {
+	data, err := fsOps.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var out []connection
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Scan() // header
+	for sc.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid ...
+		f := strings.Fields(sc.Text())
+		if len(f) < 8 || f[3] != "01" {
+			continue
+		}
+		ip, port, ok := parseProcAddr(f[2])
+		if !ok {
+			continue
+		}
+		uid, _ := strconv.ParseUint(f[7], 10, 32)
+		out = append(out, connection{ip, port, uint32(uid)})
+	}
+	return out
+}
+
+// parseProcAddr parses an address such as "0100007F:2382", whose IPv4
+// part is in host byte order.
+func parseProcAddr(s string) (net.IP, int, bool) {
+	addr, portHex, ok := strings.Cut(s, ":")
+	raw, err := hex.DecodeString(addr)
+	if !ok || err != nil || len(raw) != 4 {
+		return nil, 0, false
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+	return ip, int(port), true
+}
+
+// BlockTunnel drops everything sent through a tunnel interface, or TCP to
+// a relay, until the blocks are lifted.  It rebuilds the firewall.  A
+// proxy on the machine itself is left alone, as blocking its address
+// would cut off localhost.
+func BlockTunnel(t Tunnel) error {
+	var relay net.IP
+	if t.Interface == "" {
+		host, _, _ := net.SplitHostPort(t.Remote)
+		if relay = net.ParseIP(host).To4(); relay == nil || relay.IsLoopback() {
+			log.Printf("Guardian: Not blocking local proxy %s", t)
+			return nil
+		}
+	}
+
+	tunnelMu.Lock()
+	oldTunnels, oldRelays := blockedTunnels, blockedRelays
+	if relay != nil {
+		blockedRelays = append(slices.Clone(blockedRelays), &net.IPNet{IP: relay, Mask: net.CIDRMask(32, 32)})
+	} else if !slices.Contains(blockedTunnels, t.Interface) {
+		blockedTunnels = append(slices.Clone(blockedTunnels), t.Interface)
+	}
+	tunnelMu.Unlock()
+
+	if err := rebuildFirewall(); err != nil {
+		tunnelMu.Lock()
+		blockedTunnels, blockedRelays = oldTunnels, oldRelays
+		tunnelMu.Unlock()
+		return err
+	}
+	log.Printf("Guardian: Blocked tunnel %s", t)
+	return nil
+}
+
+// blockedTunnelRules returns the tunnels and relays to block.
+func blockedTunnelRules() ([]string, []*net.IPNet) {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	return blockedTunnels, blockedRelays
+}
+
+// forgetTunnels drops the blocked tunnels and relays once the blocks are
+// lifted.
+func forgetTunnels() {
+	tunnelMu.Lock()
+	defer tunnelMu.Unlock()
+	blockedTunnels, blockedRelays = nil, nil
+}
@@ -0,0 +1,215 @@
+// Package heartbeat checks in with a keyholder-controlled endpoint so the
+// keyholder can notice silence.
+//
+// vexd POSTs a small JSON beat to the configured URL on an interval.  A
+// machine that is switched off, offline or running without the daemon
+// stops checking in, and the endpoint — a dead man's switch service or
+// the keyholder's own server — raises the alarm; detecting the silence is
+// its job, not ours.  Locally, check-ins that keep failing for longer
+// than tighten_after_minutes are treated as a possible attempt to hide
+// from the keyholder: an events.CheckinMissed is published once per
+// outage, and vexd applies the configured precautionary tightening.
+package heartbeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+var (
+	// ConfigFile holds the endpoint and the tightening.  Optional.
+	ConfigFile = "/etc/vex-cli/heartbeat.json"
+
+	// DefaultInterval is used when the config does not set
+	// interval_minutes.
+	DefaultInterval = 5 * time.Minute
+
+	// Timeout bounds a single check-in.
+	Timeout = 15 * time.Second
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	URL                 string `json:"url"`
+	Secret              string `json:"secret,omitempty"` // signs the body as notify.json does
+	IntervalMinutes     int    `json:"interval_minutes,omitempty"`
+	TightenAfterMinutes int    `json:"tighten_after_minutes,omitempty"` // 0 never tightens
+	Profile             string `json:"profile,omitempty"`               // network profile to impose
+	RecordFailure       bool   `json:"record_failure,omitempty"`        // add to the failure score
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means no
+// heartbeat is configured and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the URL, the intervals and the profile.
+func (c *Config) Validate() error {
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("url must be http:// or https://")
+	}
+	if c.IntervalMinutes < 0 || c.TightenAfterMinutes < 0 {
+		return fmt.Errorf("interval_minutes and tighten_after_minutes must not be negative")
+	}
+	if c.TightenAfterMinutes > 0 && c.TightenAfter() <= c.Interval() {
+		return fmt.Errorf("tighten_after_minutes must be longer than the check-in interval")
+	}
+	if c.Profile != "" {
+		if _, err := throttler.ResolveProfile(c.Profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Interval is how often vexd checks in.
+func (c *Config) Interval() time.Duration {
+	if c.IntervalMinutes > 0 {
+		return time.Duration(c.IntervalMinutes) * time.Minute
+	}
+	return DefaultInterval
+}
+
+// TightenAfter is how long check-ins may fail before tightening, 0 for
+// never.
+func (c *Config) TightenAfter() time.Duration {
+	return time.Duration(c.TightenAfterMinutes) * time.Minute
+}
+
+// Beat is the JSON body of a check-in.  The daemon fills in the state
+// fields; the rest are set here.
+type Beat struct {
+	Host          string `json:"host"`
+	Time          string `json:"time"`           // RFC3339
+	Seq           uint64 `json:"seq"`            // check-ins since vexd started
+	UptimeSeconds int64  `json:"uptime_seconds"` // since vexd started
+	Locked        bool   `json:"locked"`
+	Paused        bool   `json:"paused,omitempty"`
+	FailureScore  int    `json:"failure_score"`
+	Profile       string `json:"profile,omitempty"`
+}
+
+// monitor tracks the outcome of check-ins for one daemon run.
+type monitor struct {
+	cfg     *Config
+	status  func() Beat
+	client  *http.Client
+	host    string
+	started time.Time
+
+	seq    uint64
+	lastOK time.Time // last successful check-in, or the start
+	missed bool      // CheckinMissed published for the current outage
+	failed bool      // the last check-in failed
+}
+
+// Start checks in now and then every interval in the background.  status
+// returns the state to report.
+func Start(c *Config, status func() Beat) {
+	m := newMonitor(c, status, time.Now())
+	log.Printf("Heartbeat: Checking in with %s every %s", c.URL, c.Interval())
+	supervisor.Go("heartbeat", func() error {
+		ticker := time.NewTicker(c.Interval())
+		defer ticker.Stop()
+		for {
+			m.beat(time.Now())
+			<-ticker.C
+		}
+	})
+}
+
+func newMonitor(c *Config, status func() Beat, now time.Time) *monitor {
+	host, _ := os.Hostname()
+	return &monitor{
+		cfg:     c,
+		status:  status,
+		client:  &http.Client{Timeout: Timeout},
+		host:    host,
+		started: now,
+		lastOK:  now,
+	}
+}
+
+// beat performs one check-in at now and publishes CheckinMissed or
+// CheckinResumed when the outage state changes.
+func (m *monitor) beat(now time.Time) {
+	m.seq++
+	b := m.status()
+	b.Host = m.host
+	b.Time = now.UTC().Format(time.RFC3339)
+	b.Seq = m.seq
+	b.UptimeSeconds = int64(now.Sub(m.started) / time.Second)
+
+	err := m.send(&b)
+	if err == nil {
+		if m.failed {
+			down := now.Sub(m.lastOK).Round(time.Second)
+			log.Printf("Heartbeat: Check-ins resumed after %s", down)
+			if m.missed {
+				events.Publish(events.CheckinResumed{Down: down})
+			}
+		}
+		m.lastOK, m.missed, m.failed = now, false, false
+		return
+	}
+
+	if !m.failed {
+		log.Printf("Heartbeat: Check-in failed: %v", err)
+	}
+	m.failed = true
+	if after := m.cfg.TightenAfter(); after > 0 && !m.missed && now.Sub(m.lastOK) >= after {
+		m.missed = true
+		log.Printf("Heartbeat: No check-in since %s", m.lastOK.Format(time.RFC3339))
+		events.Publish(events.CheckinMissed{Since: m.lastOK, Error: err.Error()})
+	}
+}
+
+func (m *monitor) send(b *Beat) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, m.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.cfg.Secret != "" {
+		req.Header.Set(notify.SignatureHeader, notify.Sign([]byte(m.cfg.Secret), body))
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", m.cfg.URL, resp.Status)
+	}
+	return nil
+}
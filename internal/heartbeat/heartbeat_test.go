@@ -0,0 +1,114 @@
+package heartbeat
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+)
+
+func TestBeat_MissedAndResumed(t *testing.T) {
+	var up atomic.Bool
+	beats := make(chan Beat, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(notify.SignatureHeader) != notify.Sign([]byte("s3cret"), body) {
+			t.Error("Signature header does not match the body")
+		}
+		if !up.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		var b Beat
+		if err := json.Unmarshal(body, &b); err != nil {
+			t.Errorf("Malformed beat: %v", err)
+		}
+		beats <- b
+	}))
+	defer srv.Close()
+
+	var got []events.Event
+	defer events.Subscribe(func(e events.Event) { got = append(got, e) })()
+
+	c := &Config{URL: srv.URL, Secret: "s3cret", IntervalMinutes: 5, TightenAfterMinutes: 30}
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := newMonitor(c, func() Beat { return Beat{Locked: true, FailureScore: 20} }, start)
+
+	up.Store(true)
+	m.beat(start)
+	b := <-beats
+	if b.Seq != 1 || !b.Locked || b.FailureScore != 20 || b.Time != "2026-03-01T12:00:00Z" {
+		t.Errorf("Unexpected beat %+v", b)
+	}
+
+	up.Store(false)
+	for i := 1; i <= 8; i++ {
+		m.beat(start.Add(time.Duration(i) * 5 * time.Minute))
+	}
+	if len(got) != 1 {
+		t.Fatalf("Expected one CheckinMissed per outage, got %v", got)
+	}
+	if missed, ok := got[0].(events.CheckinMissed); !ok || !missed.Since.Equal(start) {
+		t.Errorf("Expected CheckinMissed since %s, got %#v", start, got[0])
+	}
+
+	up.Store(true)
+	m.beat(start.Add(45 * time.Minute))
+	b = <-beats
+	if b.Seq != 10 || b.UptimeSeconds != 45*60 {
+		t.Errorf("Unexpected beat after the outage %+v", b)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected CheckinResumed, got %v", got)
+	}
+	if resumed, ok := got[1].(events.CheckinResumed); !ok || resumed.Down != 45*time.Minute {
+		t.Errorf("Expected 45m down, got %#v", got[1])
+	}
+}
+
+func TestBeat_ShortOutage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var got []events.Event
+	defer events.Subscribe(func(e events.Event) { got = append(got, e) })()
+
+	start := time.Now()
+	m := newMonitor(&Config{URL: srv.URL, TightenAfterMinutes: 30}, func() Beat { return Beat{} }, start)
+	m.beat(start.Add(29 * time.Minute))
+	if len(got) != 0 {
+		t.Errorf("Expected no event before tighten_after_minutes, got %v", got)
+	}
+
+	m = newMonitor(&Config{URL: srv.URL}, func() Beat { return Beat{} }, start)
+	m.beat(start.Add(24 * time.Hour))
+	if len(got) != 0 {
+		t.Errorf("Expected no event without tighten_after_minutes, got %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		c  Config
+		ok bool
+	}{
+		{Config{URL: "https://hc.example/ping/abc"}, true},
+		{Config{URL: "https://hc.example/ping/abc", TightenAfterMinutes: 60, Profile: "choke", RecordFailure: true}, true},
+		{Config{URL: "hc.example/ping"}, false},
+		{Config{URL: "https://hc.example", TightenAfterMinutes: 5}, false},
+		{Config{URL: "https://hc.example", IntervalMinutes: -1}, false},
+		{Config{URL: "https://hc.example", TightenAfterMinutes: 60, Profile: "warp"}, false},
+	} {
+		if err := tc.c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result %v", tc.c, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Package history keeps a small time series of compliance metrics so
+// reports and the CLI can show trends rather than only the current value.
+//
+// vexd appends one Sample every Interval to File, a JSON-lines file: the
+// failure score, lock state and network profile at that moment, and the
+// kills, screen time and keystrokes since the previous sample.  Samples
+// older than Retention are dropped once a day.  Readers bucket a range of
+// samples into Points for tables and sparklines.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// File holds the samples, oldest first.
+	File = "/var/lib/vex-cli/history.jsonl"
+
+	// Interval is how often vexd records a sample.
+	Interval = 5 * time.Minute
+
+	// Retention is how long samples are kept.
+	Retention = 90 * 24 * time.Hour
+)
+
+// Sample is the state at Time plus the activity since the last sample.
+type Sample struct {
+	Time          time.Time `json:"t"`
+	Locked        bool      `json:"locked,omitempty"`
+	Score         int       `json:"score"`
+	Profile       string    `json:"profile,omitempty"`
+	Kills         int       `json:"kills,omitempty"`
+	ActiveSeconds float64   `json:"active,omitempty"`
+	Keystrokes    uint64    `json:"keys,omitempty"`
+}
+
+var (
+	mu        sync.Mutex
+	lastPrune time.Time
+)
+
+// Append adds a sample to File, first dropping expired samples if that
+// has not been done today.
+func Append(s Sample) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if s.Time.Sub(lastPrune) >= 24*time.Hour {
+		if err := prune(s.Time.Add(-Retention)); err != nil {
+			return err
+		}
+		lastPrune = s.Time
+	}
+	if err := os.MkdirAll(filepath.Dir(File), 0755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// prune rewrites File without the samples taken before cutoff.
+func prune(cutoff time.Time) error {
+	var keep []byte
+	dropped := false
+	err := scan(func(s Sample, line []byte) {
+		if s.Time.Before(cutoff) {
+			dropped = true
+			return
+		}
+		keep = append(keep, line...)
+		keep = append(keep, '\n')
+	})
+	if os.IsNotExist(err) || (err == nil && !dropped) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tmp := File + ".tmp"
+	if err := os.WriteFile(tmp, keep, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, File)
+}
+
+// Read returns the samples taken in [from, to), oldest first.  A missing
+// file has no samples.
+func Read(from, to time.Time) ([]Sample, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var out []Sample
+	err := scan(func(s Sample, _ []byte) {
+		if !s.Time.Before(from) && s.Time.Before(to) {
+			out = append(out, s)
+		}
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return out, err
+}
+
+// scan calls fn for every well-formed line of File.  A line cut short by
+// a crash is skipped rather than failing the whole read.
+func scan(fn func(s Sample, line []byte)) error {
+	f, err := os.Open(File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s Sample
+		if json.Unmarshal(sc.Bytes(), &s) == nil && !s.Time.IsZero() {
+			fn(s, sc.Bytes())
+		}
+	}
+	return sc.Err()
+}
+
+// Point aggregates the samples of one bucket.
+type Point struct {
+	Start         time.Time
+	Samples       int    // 0 when the daemon recorded nothing in the bucket
+	Score         int    // highest score seen
+	LockedPct     int    // share of samples taken while locked
+	Profile       string // profile of the last sample
+	Kills         int    // totals over the bucket
+	ActiveSeconds float64
+	Keystrokes    uint64
+}
+
+// Bucket splits [from, to) into n equal buckets and aggregates samples,
+// which must be in time order, into them.
+func Bucket(samples []Sample, from, to time.Time, n int) []Point {
+	if n <= 0 || !to.After(from) {
+		return nil
+	}
+	width := to.Sub(from) / time.Duration(n)
+	points := make([]Point, n)
+	locked := make([]int, n)
+	for i := range points {
+		points[i].Start = from.Add(time.Duration(i) * width)
+	}
+	for _, s := range samples {
+		if s.Time.Before(from) || !s.Time.Before(to) {
+			continue
+		}
+		i := min(int(s.Time.Sub(from)/width), n-1)
+		p := &points[i]
+		p.Samples++
+		p.Score = max(p.Score, s.Score)
+		p.Profile = s.Profile
+		p.Kills += s.Kills
+		p.ActiveSeconds += s.ActiveSeconds
+		p.Keystrokes += s.Keystrokes
+		if s.Locked {
+			locked[i]++
+		}
+	}
+	for i := range points {
+		if points[i].Samples > 0 {
+			points[i].LockedPct = locked[i] * 100 / points[i].Samples
+		}
+	}
+	return points
+}
+
+var ticks = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline draws values scaled to the largest, with a space wherever
+// has[i] is false.
+func Sparkline(values []float64, has []bool) string {
+	var top float64
+	for _, v := range values {
+		top = max(top, v)
+	}
+	var b strings.Builder
+	for i, v := range values {
+		switch {
+		case !has[i]:
+			b.WriteRune(' ')
+		case top <= 0:
+			b.WriteRune(ticks[0])
+		default:
+			b.WriteRune(ticks[min(int(v/top*float64(len(ticks)-1)+0.5), len(ticks)-1)])
+		}
+	}
+	return b.String()
+}
+
+// Series extracts one metric from points for Sparkline.
+func Series(points []Point, metric func(Point) float64) ([]float64, []bool) {
+	values := make([]float64, len(points))
+	has := make([]bool, len(points))
+	for i, p := range points {
+		values[i], has[i] = metric(p), p.Samples > 0
+	}
+	return values, has
+}
+
+// ParseRange returns the start of the day, week or 30-day month ending
+// at now, and how many buckets to split it into.
+func ParseRange(name string, now time.Time) (time.Time, int, error) {
+	switch name {
+	case "", "day":
+		return now.Add(-24 * time.Hour), 24, nil
+	case "week":
+		return now.AddDate(0, 0, -7), 28, nil
+	case "month":
+		return now.AddDate(0, 0, -30), 30, nil
+	}
+	return time.Time{}, 0, fmt.Errorf("unknown range %q (use day, week or month)", name)
+}
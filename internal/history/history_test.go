@@ -0,0 +1,84 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAppendRead(t *testing.T) {
+	File = filepath.Join(t.TempDir(), "history.jsonl")
+	lastPrune = time.Time{}
+
+	if samples, err := Read(time.Time{}, time.Now()); err != nil || samples != nil {
+		t.Fatalf("Expected no samples without a file, got %v, %v", samples, err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	old := now.Add(-Retention - time.Hour)
+	for _, s := range []Sample{
+		{Time: old, Score: 1},
+		{Time: now.Add(-2 * time.Hour), Score: 10, Kills: 1},
+		{Time: now.Add(-time.Hour), Score: 20, Locked: true, Profile: "choke"},
+	} {
+		lastPrune = now // keep the expired sample for now
+		if err := Append(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A line cut short by a crash is skipped.
+	f, _ := os.OpenFile(File, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"t":"20`)
+	f.Close()
+
+	samples, err := Read(now.Add(-3*time.Hour), now)
+	if err != nil || len(samples) != 2 || samples[1].Profile != "choke" {
+		t.Fatalf("Unexpected samples %+v, %v", samples, err)
+	}
+
+	lastPrune = time.Time{}
+	if err := Append(Sample{Time: now, Score: 30}); err != nil {
+		t.Fatal(err)
+	}
+	samples, _ = Read(time.Time{}, now.Add(time.Second))
+	if len(samples) != 3 || samples[0].Score != 10 {
+		t.Errorf("Expected the expired sample to be pruned, got %+v", samples)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Hour)
+	points := Bucket([]Sample{
+		{Time: from.Add(10 * time.Minute), Score: 5, Kills: 1, ActiveSeconds: 60},
+		{Time: from.Add(20 * time.Minute), Score: 3, Kills: 2, ActiveSeconds: 30, Locked: true},
+		{Time: from.Add(3*time.Hour + 59*time.Minute), Score: 8, Profile: "dial-up"},
+		{Time: to, Score: 99}, // outside the range
+	}, from, to, 4)
+
+	if len(points) != 4 || !points[1].Start.Equal(from.Add(time.Hour)) {
+		t.Fatalf("Unexpected buckets %+v", points)
+	}
+	p := points[0]
+	if p.Samples != 2 || p.Score != 5 || p.Kills != 3 || p.ActiveSeconds != 90 || p.LockedPct != 50 {
+		t.Errorf("Unexpected first bucket %+v", p)
+	}
+	if points[1].Samples != 0 || points[3].Score != 8 || points[3].Profile != "dial-up" {
+		t.Errorf("Unexpected buckets %+v", points)
+	}
+
+	if got := Sparkline(Series(points, func(p Point) float64 { return float64(p.Score) })); got != "▅  █" {
+		t.Errorf("Sparkline = %q", got)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	now := time.Now()
+	if from, n, err := ParseRange("week", now); err != nil || n != 28 || !from.Equal(now.AddDate(0, 0, -7)) {
+		t.Errorf("week: %v %d %v", from, n, err)
+	}
+	if _, _, err := ParseRange("year", now); err == nil {
+		t.Error("Expected an error for an unknown range")
+	}
+}
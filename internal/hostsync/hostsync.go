@@ -0,0 +1,540 @@
+// Package hostsync keeps several vexd instances (e.g. a desktop and a
+// laptop) on one compliance score and one blocklist.
+//
+// One daemon runs as the primary and serves the sync endpoint; replicas
+// periodically POST their local snapshot to it and receive the merged
+// result back.  Merging raises restrictions (union of blocks, highest
+// score, most severe profile, locked if either side is locked), except
+// where a host lifted one: every field carries when it last changed and
+// when it was last lowered, and removed domains and apps leave tombstones,
+// so that an unlock, a score reset or a block rm on one host is carried
+// out on the others instead of being undone by them.  A lowering only
+// wins over changes older than itself, so a stale peer cannot lift a
+// penalty raised since, and lowering still takes a signed or verified
+// command on the host where it happens.
+package hostsync
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+)
+
+// -- Configuration --
+
+const (
+	// SecretFile holds the shared HMAC secret every participating host
+	// must have.  Sync is refused without it.
+	SecretFile = "/etc/vex-cli/sync-secret"
+
+	// DefaultListenAddr is where a primary serves the sync endpoint.
+	DefaultListenAddr = ":7106"
+
+	// SignatureHeader carries the hex HMAC-SHA256 of the request body.
+	SignatureHeader = "X-Vex-Signature"
+
+	syncPath = "/v1/sync"
+)
+
+var (
+	// Interval controls how often a replica reconciles with the primary.
+	Interval = 30 * time.Second
+
+	// MaxClockSkew bounds how old a signed snapshot may be before it is
+	// rejected as a replay.
+	MaxClockSkew = 5 * time.Minute
+
+	// TombstoneTTL is how long a removed domain or app is remembered, so
+	// that a host that comes back within it does not restore it.
+	TombstoneTTL = 30 * 24 * time.Hour
+
+	// LedgerFile keeps this host's stamps, and the values they were taken
+	// against, across restarts.
+	LedgerFile = "/var/lib/vex-cli/sync-ledger.json"
+)
+
+// Role selects how this daemon participates in sync.
+type Role string
+
+const (
+	RoleDisabled Role = ""
+	RolePrimary  Role = "primary"
+	RoleReplica  Role = "replica"
+)
+
+// Config describes this host's sync participation.  It is read from the
+// environment by ConfigFromEnv.
+type Config struct {
+	Role       Role
+	ListenAddr string // primary only
+	PrimaryURL string // replica only, e.g. http://desktop:7106
+	Secret     []byte
+}
+
+// ConfigFromEnv reads VEX_SYNC_ROLE, VEX_SYNC_LISTEN and VEX_SYNC_PRIMARY
+// plus the shared secret from SecretFile.
+func ConfigFromEnv() (*Config, error) {
+	cfg := &Config{
+		Role:       Role(strings.ToLower(strings.TrimSpace(os.Getenv("VEX_SYNC_ROLE")))),
+		ListenAddr: os.Getenv("VEX_SYNC_LISTEN"),
+		PrimaryURL: strings.TrimRight(os.Getenv("VEX_SYNC_PRIMARY"), "/"),
+	}
+	switch cfg.Role {
+	case RoleDisabled:
+		return cfg, nil
+	case RolePrimary:
+		if cfg.ListenAddr == "" {
+			cfg.ListenAddr = DefaultListenAddr
+		}
+	case RoleReplica:
+		if cfg.PrimaryURL == "" {
+			return nil, fmt.Errorf("VEX_SYNC_ROLE=replica requires VEX_SYNC_PRIMARY")
+		}
+	default:
+		return nil, fmt.Errorf("invalid VEX_SYNC_ROLE %q (expected primary or replica)", cfg.Role)
+	}
+
+	secret, err := os.ReadFile(SecretFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync secret %s: %w", SecretFile, err)
+	}
+	cfg.Secret = bytes.TrimSpace(secret)
+	if len(cfg.Secret) < 16 {
+		return nil, fmt.Errorf("sync secret in %s is too short (need at least 16 bytes)", SecretFile)
+	}
+	return cfg, nil
+}
+
+// -- Data --
+
+// Snapshot is the restriction set exchanged between hosts.
+type Snapshot struct {
+	Host           string   `json:"host"`
+	Timestamp      int64    `json:"timestamp"`
+	Locked         bool     `json:"locked"`
+	FailureScore   int      `json:"failure_score"`
+	NetworkProfile string   `json:"network_profile"`
+	BlockedDomains []string `json:"blocked_domains"`
+	ForbiddenApps  []string `json:"forbidden_apps"`
+	Stamps         Stamps   `json:"stamps"`
+}
+
+// Stamps date the changes to each field of a snapshot.  Hooks.Local
+// leaves them empty; they are kept in the ledger.
+type Stamps struct {
+	Locked  Stamp            `json:"locked"`
+	Score   Stamp            `json:"score"`
+	Profile Stamp            `json:"profile"`
+	Domains map[string]Stamp `json:"domains,omitempty"`
+	Apps    map[string]Stamp `json:"apps,omitempty"`
+}
+
+// Stamp holds when a field last changed and when it was last lowered, in
+// Unix nanoseconds.  For a domain or app the change is its addition and
+// the lowering its removal: it is in the list while Changed is the later.
+type Stamp struct {
+	Changed int64 `json:"changed,omitempty"`
+	Lowered int64 `json:"lowered,omitempty"`
+}
+
+func (s Stamp) present() bool { return s.Changed > s.Lowered }
+
+// Hooks connect the sync loop to the daemon.  Local returns this host's
+// current snapshot, or nil while it sits sync out; Apply enforces a
+// merged snapshot locally.
+type Hooks struct {
+	Local func() *Snapshot
+	Apply func(merged *Snapshot)
+}
+
+// Merge folds remote into local and reports whether the result differs
+// from local.  Each field takes the more restrictive value of the two,
+// unless one side lowered it after the other last changed it: then that
+// side's value stands.  A domain or app is kept unless its removal is
+// newer than its addition on either side.
+func Merge(local, remote *Snapshot) (*Snapshot, bool) {
+	merged := *local
+	ls, rs := local.Stamps, remote.Stamps
+	merged.Locked, merged.Stamps.Locked = mergeField(local.Locked, remote.Locked, ls.Locked, rs.Locked, lockRank)
+	merged.FailureScore, merged.Stamps.Score = mergeField(local.FailureScore, remote.FailureScore, ls.Score, rs.Score, scoreRank)
+	merged.NetworkProfile, merged.Stamps.Profile = mergeField(local.NetworkProfile, remote.NetworkProfile, ls.Profile, rs.Profile, profileRank)
+	merged.BlockedDomains, merged.Stamps.Domains = mergeEntries(local.BlockedDomains, remote.BlockedDomains, ls.Domains, rs.Domains)
+	merged.ForbiddenApps, merged.Stamps.Apps = mergeEntries(local.ForbiddenApps, remote.ForbiddenApps, ls.Apps, rs.Apps)
+
+	changed := merged.Locked != local.Locked ||
+		merged.FailureScore != local.FailureScore ||
+		merged.NetworkProfile != local.NetworkProfile ||
+		!slices.Equal(merged.BlockedDomains, normalize(local.BlockedDomains)) ||
+		!slices.Equal(merged.ForbiddenApps, normalize(local.ForbiddenApps))
+	return &merged, changed
+}
+
+func lockRank(locked bool) int {
+	if locked {
+		return 1
+	}
+	return 0
+}
+
+func scoreRank(score int) int { return score }
+
+func profileRank(p string) int { return throttler.Severity(throttler.Profile(p)) }
+
+// mergeField merges one field of two snapshots; rank orders its values
+// from the least to the most restrictive.
+func mergeField[T comparable](l, r T, ls, rs Stamp, rank func(T) int) (T, Stamp) {
+	stamp := Stamp{Changed: max(ls.Changed, rs.Changed), Lowered: max(ls.Lowered, rs.Lowered)}
+	higher := l
+	if rank(r) > rank(l) {
+		higher = r
+	}
+	switch {
+	case rs.Lowered > ls.Lowered && ls.Changed <= rs.Lowered:
+		// Lowered there since it last changed here.
+		return r, stamp
+	case ls.Lowered > rs.Lowered && rs.Changed <= ls.Lowered:
+		return l, stamp
+	}
+	return higher, stamp
+}
+
+// mergeEntries merges two lists and their stamps.  An entry listed
+// without a stamp, as from a peer that keeps none, counts as added at the
+// beginning of time.
+func mergeEntries(l, r []string, ls, rs map[string]Stamp) ([]string, map[string]Stamp) {
+	stamps := make(map[string]Stamp)
+	for _, side := range []struct {
+		list   []string
+		stamps map[string]Stamp
+	}{{l, ls}, {r, rs}} {
+		for e, s := range side.stamps {
+			m := stamps[e]
+			stamps[e] = Stamp{Changed: max(m.Changed, s.Changed), Lowered: max(m.Lowered, s.Lowered)}
+		}
+		for _, e := range normalize(side.list) {
+			if _, ok := side.stamps[e]; !ok {
+				m := stamps[e]
+				m.Changed = max(m.Changed, 1)
+				stamps[e] = m
+			}
+		}
+	}
+	list := []string{}
+	for e, s := range stamps {
+		if s.present() {
+			list = append(list, e)
+		}
+	}
+	sort.Strings(list)
+	return list, stamps
+}
+
+// observe stamps the changes between last, this host's values as of the
+// previous call, and cur, its values now, with now, and puts the result
+// in cur.Stamps.  Tombstones older than TombstoneTTL are dropped.
+func observe(last, cur *Snapshot, now time.Time) {
+	t := now.UnixNano()
+	st := last.Stamps
+	stampField(&st.Locked, lockRank(last.Locked), lockRank(cur.Locked), last.Locked != cur.Locked, t)
+	stampField(&st.Score, last.FailureScore, cur.FailureScore, last.FailureScore != cur.FailureScore, t)
+	stampField(&st.Profile, profileRank(last.NetworkProfile), profileRank(cur.NetworkProfile),
+		last.NetworkProfile != cur.NetworkProfile, t)
+	st.Domains = stampEntries(st.Domains, last.BlockedDomains, cur.BlockedDomains, now)
+	st.Apps = stampEntries(st.Apps, last.ForbiddenApps, cur.ForbiddenApps, now)
+	cur.Stamps = st
+}
+
+func stampField(s *Stamp, was, is int, changed bool, t int64) {
+	if !changed {
+		return
+	}
+	s.Changed = t
+	if is < was {
+		s.Lowered = t
+	}
+}
+
+func stampEntries(stamps map[string]Stamp, was, is []string, now time.Time) map[string]Stamp {
+	out := maps.Clone(stamps)
+	if out == nil {
+		out = make(map[string]Stamp)
+	}
+	before, after := normalize(was), normalize(is)
+	for _, e := range after {
+		if !slices.Contains(before, e) {
+			s := out[e]
+			s.Changed = now.UnixNano()
+			out[e] = s
+		}
+	}
+	for _, e := range before {
+		if !slices.Contains(after, e) {
+			s := out[e]
+			s.Lowered = now.UnixNano()
+			out[e] = s
+		}
+	}
+	expired := now.Add(-TombstoneTTL).UnixNano()
+	for e, s := range out {
+		if !s.present() && s.Lowered < expired {
+			delete(out, e)
+		}
+	}
+	return out
+}
+
+func normalize(in []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, v := range in {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// -- Signing --
+
+// Sign returns the hex HMAC-SHA256 of body under secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and freshness of a signed snapshot body.
+func verify(secret, body []byte, signature string) (*Snapshot, error) {
+	if !hmac.Equal([]byte(Sign(secret, body)), []byte(signature)) {
+		return nil, fmt.Errorf("sync signature mismatch")
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(body, &snap); err != nil {
+		return nil, fmt.Errorf("malformed snapshot: %w", err)
+	}
+	age := time.Since(time.Unix(snap.Timestamp, 0))
+	if age > MaxClockSkew || age < -MaxClockSkew {
+		return nil, fmt.Errorf("snapshot from %s is stale (age %s)", snap.Host, age.Round(time.Second))
+	}
+	return &snap, nil
+}
+
+func signedBody(secret []byte, snap *Snapshot) ([]byte, string, error) {
+	snap.Timestamp = time.Now().Unix()
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, Sign(secret, body), nil
+}
+
+// -- Runtime --
+
+var (
+	cfg   *Config
+	hooks Hooks
+	mu    sync.Mutex // serializes merge+apply between the server and loop
+	seen  *Snapshot  // the ledger: this host's values at the last observation, and its stamps
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Init starts sync according to c.  It is a no-op when sync is disabled.
+func Init(c *Config, h Hooks) error {
+	if c == nil || c.Role == RoleDisabled {
+		log.Println("HostSync: Disabled (VEX_SYNC_ROLE not set)")
+		return nil
+	}
+	cfg = c
+	hooks = h
+
+	switch c.Role {
+	case RolePrimary:
+		mux := http.NewServeMux()
+		mux.HandleFunc(syncPath, handleSync)
+		srv := &http.Server{Addr: c.ListenAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		supervisor.Go("hostsync.primary", func() error {
+			return fmt.Errorf("primary endpoint stopped: %w", srv.ListenAndServe())
+		})
+		log.Printf("HostSync: Serving as primary on %s", c.ListenAddr)
+	case RoleReplica:
+		supervisor.Go("hostsync.replica", replicaLoop)
+		log.Printf("HostSync: Replicating with primary %s every %s", c.PrimaryURL, Interval)
+	}
+	return nil
+}
+
+// handleSync is the primary's endpoint: merge the replica's snapshot into
+// ours, apply anything new locally and answer with the merged result.
+func handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "read error", http.StatusBadRequest)
+		return
+	}
+	remote, err := verify(cfg.Secret, body, r.Header.Get(SignatureHeader))
+	if err != nil {
+		log.Printf("HostSync: Rejected sync from %s: %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	merged := mergeAndApply(remote)
+
+	respBody, sig, err := signedBody(cfg.Secret, merged)
+	if err != nil {
+		http.Error(w, "encode error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(SignatureHeader, sig)
+	w.Write(respBody)
+}
+
+func replicaLoop() error {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		if err := syncOnce(); err != nil {
+			log.Printf("HostSync: Sync with %s failed: %v", cfg.PrimaryURL, err)
+		}
+		<-ticker.C
+	}
+}
+
+// syncOnce performs a single replica → primary round trip.
+func syncOnce() error {
+	mu.Lock()
+	local := localSnapshot()
+	mu.Unlock()
+	if local == nil {
+		return nil
+	}
+
+	body, sig, err := signedBody(cfg.Secret, local)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.PrimaryURL+syncPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, sig)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned %s", resp.Status)
+	}
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	remote, err := verify(cfg.Secret, respBody, resp.Header.Get(SignatureHeader))
+	if err != nil {
+		return err
+	}
+	mergeAndApply(remote)
+	return nil
+}
+
+func mergeAndApply(remote *Snapshot) *Snapshot {
+	mu.Lock()
+	defer mu.Unlock()
+
+	local := localSnapshot()
+	if local == nil {
+		return remote
+	}
+	merged, changed := Merge(local, remote)
+	if changed {
+		log.Printf("HostSync: Adopting restrictions from %s (score=%d, locked=%v, profile=%s, domains=%d, apps=%d)",
+			remote.Host, merged.FailureScore, merged.Locked, merged.NetworkProfile,
+			len(merged.BlockedDomains), len(merged.ForbiddenApps))
+		hooks.Apply(merged)
+		// What the merge changed here is not a change of this host's to
+		// stamp.  Whatever failed to apply is still stamped as merged, so
+		// the next merge tries it again.
+		if now := hooks.Local(); now != nil {
+			local = now
+		}
+	}
+	local.Stamps = merged.Stamps
+	seen = local
+	saveLedger()
+	return merged
+}
+
+// localSnapshot returns this host's snapshot, stamped against the ledger.
+// Call with mu held.
+func localSnapshot() *Snapshot {
+	if seen == nil {
+		seen = loadLedger()
+	}
+	cur := hooks.Local()
+	if cur == nil {
+		return nil
+	}
+	observe(seen, cur, time.Now())
+	seen = cur
+	saveLedger()
+	return cur
+}
+
+func loadLedger() *Snapshot {
+	snap := &Snapshot{}
+	data, err := os.ReadFile(LedgerFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("HostSync: failed to read %s: %v", LedgerFile, err)
+		}
+		return snap
+	}
+	if err := json.Unmarshal(data, snap); err != nil {
+		log.Printf("HostSync: ignoring malformed %s: %v", LedgerFile, err)
+		return &Snapshot{}
+	}
+	return snap
+}
+
+func saveLedger() {
+	data, err := json.Marshal(seen)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(LedgerFile), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(LedgerFile, data, 0o600)
+	}
+	if err != nil {
+		log.Printf("HostSync: failed to save %s: %v", LedgerFile, err)
+	}
+}
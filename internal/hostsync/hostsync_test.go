@@ -0,0 +1,160 @@
+package hostsync
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMerge_OnlyRaisesRestrictions(t *testing.T) {
+	local := &Snapshot{
+		Host:           "desktop",
+		Locked:         true,
+		FailureScore:   40,
+		NetworkProfile: "dial-up",
+		BlockedDomains: []string{"reddit.com"},
+		ForbiddenApps:  []string{"steam"},
+	}
+	remote := &Snapshot{
+		Host:           "laptop",
+		Locked:         false,
+		FailureScore:   10,
+		NetworkProfile: "choke",
+		BlockedDomains: []string{"twitch.tv", "Reddit.com"},
+		ForbiddenApps:  []string{"steam", "lutris"},
+	}
+
+	merged, changed := Merge(local, remote)
+	if !changed {
+		t.Error("Expected merge to report a change (new domain and app)")
+	}
+	if !merged.Locked {
+		t.Error("Remote unlocked state must not unlock the local host")
+	}
+	if merged.FailureScore != 40 {
+		t.Errorf("Expected score to stay at 40, got %d", merged.FailureScore)
+	}
+	if merged.NetworkProfile != "dial-up" {
+		t.Errorf("Expected the more severe profile dial-up, got %s", merged.NetworkProfile)
+	}
+	if len(merged.BlockedDomains) != 2 {
+		t.Errorf("Expected 2 blocked domains after union, got %v", merged.BlockedDomains)
+	}
+	if len(merged.ForbiddenApps) != 2 {
+		t.Errorf("Expected 2 forbidden apps after union, got %v", merged.ForbiddenApps)
+	}
+}
+
+func TestMerge_AdoptsHigherPenalty(t *testing.T) {
+	local := &Snapshot{FailureScore: 0, NetworkProfile: "standard"}
+	remote := &Snapshot{Locked: true, FailureScore: 80, NetworkProfile: "black-hole"}
+
+	merged, changed := Merge(local, remote)
+	if !changed {
+		t.Fatal("Expected merge to report a change")
+	}
+	if !merged.Locked || merged.FailureScore != 80 || merged.NetworkProfile != "black-hole" {
+		t.Errorf("Expected remote penalty to be adopted, got %+v", merged)
+	}
+}
+
+func TestMerge_NoChange(t *testing.T) {
+	snap := &Snapshot{FailureScore: 5, NetworkProfile: "choke", BlockedDomains: []string{"a.com"}}
+	if _, changed := Merge(snap, snap); changed {
+		t.Error("Merging a snapshot with itself should not report a change")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef0123")
+	body, sig, err := signedBody(secret, &Snapshot{Host: "laptop", FailureScore: 30})
+	if err != nil {
+		t.Fatalf("signedBody failed: %v", err)
+	}
+
+	snap, err := verify(secret, body, sig)
+	if err != nil {
+		t.Fatalf("verify rejected a valid snapshot: %v", err)
+	}
+	if snap.Host != "laptop" || snap.FailureScore != 30 {
+		t.Errorf("Unexpected snapshot contents: %+v", snap)
+	}
+
+	if _, err := verify([]byte("another-secret-entirely"), body, sig); err == nil {
+		t.Error("Expected signature mismatch with a different secret")
+	}
+
+	stale, _ := json.Marshal(&Snapshot{Host: "laptop", Timestamp: time.Now().Add(-time.Hour).Unix()})
+	if _, err := verify(secret, stale, Sign(secret, stale)); err == nil {
+		t.Error("Expected stale snapshot to be rejected")
+	}
+}
+
+// stamped returns cur stamped against last at t.
+func stamped(last, cur *Snapshot, t time.Time) *Snapshot {
+	observe(last, cur, t)
+	return cur
+}
+
+func TestUnlockThenSync(t *testing.T) {
+	t0 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	penalised := func(host string) *Snapshot {
+		return &Snapshot{
+			Host: host, Locked: true, FailureScore: 40, NetworkProfile: "dial-up",
+			BlockedDomains: []string{"reddit.com"}, ForbiddenApps: []string{"steam"},
+		}
+	}
+	desktop := stamped(&Snapshot{}, penalised("desktop"), t0)
+	laptop := stamped(&Snapshot{}, penalised("laptop"), t0)
+
+	// A signed unlock, a score reset, throttle standard, block rm and
+	// app rm on the desktop.
+	lifted := stamped(desktop, &Snapshot{Host: "desktop", NetworkProfile: "standard"}, t0.Add(time.Hour))
+
+	merged, changed := Merge(laptop, lifted)
+	if !changed {
+		t.Fatal("Expected the laptop to take the lowering")
+	}
+	if merged.Locked || merged.FailureScore != 0 || merged.NetworkProfile != "standard" {
+		t.Errorf("Expected the laptop unlocked at 0 on standard, got %+v", merged)
+	}
+	if len(merged.BlockedDomains) != 0 || len(merged.ForbiddenApps) != 0 {
+		t.Errorf("Expected the removals to reach the laptop, got %v and %v", merged.BlockedDomains, merged.ForbiddenApps)
+	}
+
+	// The laptop has not synced yet: its older snapshot must not restore
+	// what the desktop lifted.
+	back, changed := Merge(lifted, laptop)
+	if changed || back.Locked || back.FailureScore != 0 || len(back.BlockedDomains) != 0 {
+		t.Errorf("A stale peer restored a lifted restriction: %+v", back)
+	}
+}
+
+func TestMerge_RaiseAfterLoweringStands(t *testing.T) {
+	t0 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	penalised := func() *Snapshot {
+		return &Snapshot{Locked: true, FailureScore: 40, BlockedDomains: []string{"reddit.com"}, ForbiddenApps: []string{"steam"}}
+	}
+	desktop := stamped(&Snapshot{}, penalised(), t0)
+	laptop := stamped(&Snapshot{}, penalised(), t0)
+
+	lifted := stamped(desktop, &Snapshot{}, t0.Add(time.Hour))
+	// Before the hosts sync, the laptop records a failure and forbids
+	// steam again after an app rm.
+	laptop = stamped(laptop, &Snapshot{Locked: true, FailureScore: 40, BlockedDomains: []string{"reddit.com"}}, t0.Add(2*time.Hour))
+	laptop = stamped(laptop, &Snapshot{Locked: true, FailureScore: 55, BlockedDomains: []string{"reddit.com"}, ForbiddenApps: []string{"steam"}}, t0.Add(3*time.Hour))
+
+	merged, _ := Merge(lifted, laptop)
+	if merged.Locked {
+		t.Error("Expected the desktop's unlock to stand: the laptop's lock is older")
+	}
+	if merged.FailureScore != 55 {
+		t.Errorf("Expected the later score of 55 to stand, got %d", merged.FailureScore)
+	}
+	if len(merged.BlockedDomains) != 0 {
+		t.Errorf("Expected reddit.com unblocked, got %v", merged.BlockedDomains)
+	}
+	if len(merged.ForbiddenApps) != 1 {
+		t.Errorf("Expected steam forbidden again, got %v", merged.ForbiddenApps)
+	}
+}
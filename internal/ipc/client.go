@@ -2,8 +2,12 @@ package ipc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/adumbdinosaur/vex-cli/internal/state"
@@ -13,21 +17,124 @@ import (
 type Client struct {
 	socketPath string
 	timeout    time.Duration
+
+	// mu guards token, the session from CmdHello, so that one client can
+	// be shared by goroutines.
+	mu    sync.Mutex
+	token string
+}
+
+// SocketEnv is the environment variable that overrides the socket path.
+const SocketEnv = "VEX_SOCKET"
+
+// retryDelays are the pauses between connection attempts while the daemon
+// is restarting, i.e. while its socket is missing or refuses connections.
+// Together they cover about three seconds.
+var retryDelays = []time.Duration{
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	400 * time.Millisecond,
+	800 * time.Millisecond,
+	1600 * time.Millisecond,
 }
 
-// NewClient creates a client that talks to the daemon.
-func NewClient() *Client {
+// NewClient creates a client that talks to the daemon at socketPath, or
+// when that is empty at $VEX_SOCKET or state.SocketPath.
+func NewClient(socketPath string) *Client {
+	if socketPath == "" {
+		socketPath = os.Getenv(SocketEnv)
+	}
+	if socketPath == "" {
+		socketPath = state.SocketPath
+	}
 	return &Client{
-		socketPath: state.SocketPath,
+		socketPath: socketPath,
 		timeout:    10 * time.Second,
 	}
 }
 
-// Send sends a request to the daemon and returns the response.
+// dial connects to the daemon, retrying with backoff while it restarts.
+// Only the connection is retried, never a request, so a command cannot
+// run twice.
+func (c *Client) dial() (net.Conn, error) {
+	for i := 0; ; i++ {
+		conn, err := net.DialTimeout("unix", c.socketPath, c.timeout)
+		if err == nil {
+			return conn, nil
+		}
+		restarting := errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED)
+		if !restarting || i == len(retryDelays) {
+			return nil, fmt.Errorf("could not connect to vexd at %s: %w (is the service running?)", c.socketPath, err)
+		}
+		time.Sleep(retryDelays[i])
+	}
+}
+
+// Send sends a request to the daemon and returns the response.  The
+// first request opens a session; if the daemon has since let it expire or
+// revoked it, Send opens another and sends the request once more, which
+// is safe because the daemon refused it without running it.
 func (c *Client) Send(req *Request) (*Response, error) {
-	conn, err := net.DialTimeout("unix", c.socketPath, c.timeout)
+	token, err := c.session("")
+	if err != nil {
+		return refusal(err)
+	}
+	req.Token = token
+	resp, err := c.send(req)
+	if err != nil || resp.Code != CodeSession {
+		return resp, err
+	}
+	if req.Token, err = c.session(token); err != nil {
+		return refusal(err)
+	}
+	return c.send(req)
+}
+
+// sessionRefused is the error session returns when the daemon will not
+// open a session for the client.
+type sessionRefused struct{ resp *Response }
+
+func (e *sessionRefused) Error() string { return "vexd refused a session: " + e.resp.Error }
+
+// refusal answers for the daemon when it refused a session, so that the
+// caller sees the refusal's code as for any other refused request.
+// Other errors are returned as they are.
+func refusal(err error) (*Response, error) {
+	var r *sessionRefused
+	if !errors.As(err, &r) {
+		return nil, err
+	}
+	resp := *r.resp
+	resp.Error = r.Error()
+	if resp.Code == "" {
+		resp.Code = CodeSession
+	}
+	return &resp, nil
+}
+
+// session returns the client's session token, opening a session with the
+// daemon if it has none or if it is still the stale one.
+func (c *Client) session(stale string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && c.token != stale {
+		return c.token, nil
+	}
+	resp, err := c.send(&Request{Command: CmdHello})
+	if err != nil {
+		return "", err
+	}
+	if !resp.OK {
+		return "", &sessionRefused{resp}
+	}
+	c.token = resp.Token
+	return c.token, nil
+}
+
+func (c *Client) send(req *Request) (*Response, error) {
+	conn, err := c.dial()
 	if err != nil {
-		return nil, fmt.Errorf("could not connect to vexd at %s: %w (is the service running?)", c.socketPath, err)
+		return nil, err
 	}
 	defer conn.Close()
 
@@ -49,3 +156,64 @@ func (c *Client) Send(req *Request) (*Response, error) {
 
 	return &resp, nil
 }
+
+// Watch subscribes to state change notifications.  fn is invoked with the
+// current state immediately and again every time the daemon's state
+// changes; returning false from fn ends the subscription.  Watch blocks
+// until then or until the connection drops.
+func (c *Client) Watch(fn func(resp *Response) bool) error {
+	return c.watch(&Request{Command: CmdWatch}, fn)
+}
+
+// WatchEvents is Watch that also receives daemon events as they happen,
+// in responses whose Event is set and State is not.
+func (c *Client) WatchEvents(fn func(resp *Response) bool) error {
+	return c.watch(&Request{Command: CmdWatch, Args: map[string]string{"events": "true"}}, fn)
+}
+
+func (c *Client) watch(req *Request, fn func(resp *Response) bool) error {
+	token, err := c.session("")
+	if err != nil {
+		return err
+	}
+	req.Token = token
+	err = c.watchOnce(req, fn)
+	if errors.Is(err, errSession) {
+		if req.Token, err = c.session(token); err != nil {
+			return err
+		}
+		err = c.watchOnce(req, fn)
+	}
+	return err
+}
+
+// errSession is returned by watchOnce when the daemon refused the session.
+var errSession = errors.New("session refused")
+
+func (c *Client) watchOnce(req *Request, fn func(resp *Response) bool) error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	// No deadline: the connection stays open for as long as the caller
+	// wants updates.
+	dec := json.NewDecoder(conn)
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err != nil {
+			return fmt.Errorf("watch stream closed: %w", err)
+		}
+		if resp.Code == CodeSession {
+			return fmt.Errorf("%w: %s", errSession, resp.Error)
+		}
+		if !fn(&resp) {
+			return nil
+		}
+	}
+}
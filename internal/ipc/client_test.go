@@ -0,0 +1,91 @@
+package ipc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClientSocket(t *testing.T) {
+	t.Setenv(SocketEnv, "/tmp/env.sock")
+	if c := NewClient(""); c.socketPath != "/tmp/env.sock" {
+		t.Errorf("Expected $%s to be used, got %s", SocketEnv, c.socketPath)
+	}
+	if c := NewClient("/tmp/flag.sock"); c.socketPath != "/tmp/flag.sock" {
+		t.Errorf("Expected the argument to win over $%s, got %s", SocketEnv, c.socketPath)
+	}
+}
+
+func TestSendRetriesWhileDaemonStarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vexd.sock")
+	go func() {
+		time.Sleep(250 * time.Millisecond)
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ln.Close()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			json.NewDecoder(conn).Decode(&req)
+			json.NewEncoder(conn).Encode(&Response{OK: true, Message: req.Command, Token: "t"})
+			conn.Close()
+			if req.Command != CmdHello {
+				return
+			}
+		}
+	}()
+
+	resp, err := NewClient(path).Send(&Request{Command: CmdStatus})
+	if err != nil || resp.Message != CmdStatus {
+		t.Fatalf("Expected the request to succeed once the daemon listens, got %+v, %v", resp, err)
+	}
+}
+
+func TestSendReopensExpiredSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vexd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var seen []string
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			json.NewDecoder(conn).Decode(&req)
+			seen = append(seen, req.Command+":"+req.Token)
+			resp := &Response{OK: true, Message: req.Command}
+			switch {
+			case req.Command == CmdHello:
+				resp.Token = fmt.Sprintf("t%d", n)
+			case req.Token == "t0":
+				resp = &Response{OK: false, Code: CodeSession, Error: "session expired"}
+			}
+			json.NewEncoder(conn).Encode(resp)
+			conn.Close()
+		}
+	}()
+
+	resp, err := NewClient(path).Send(&Request{Command: CmdStatus})
+	if err != nil || !resp.OK {
+		t.Fatalf("Expected the request to succeed in a new session, got %+v, %v", resp, err)
+	}
+	want := "hello:,status:t0,hello:,status:t2"
+	if got := strings.Join(seen, ","); got != want {
+		t.Errorf("Requests = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,81 @@
+package ipc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Peer is the process on the other end of a socket connection, as the
+// kernel reports it.
+type Peer struct {
+	PID  int
+	UID  int
+	GID  int
+	Exe  string // resolved path of /proc/PID/exe
+	Hash string // hex SHA-256 of the executable
+}
+
+func (p *Peer) String() string {
+	return fmt.Sprintf("pid=%d uid=%d exe=%s sha256=%s", p.PID, p.UID, p.Exe, p.Hash)
+}
+
+// PeerOf identifies the process connected on conn through SO_PEERCRED
+// and hashes the executable it is running.  The binary is read through
+// /proc/PID/exe, so a client that replaced or deleted its file after
+// starting is still hashed as what it actually runs.
+func PeerOf(conn net.Conn) (*Peer, error) {
+	cred, err := peerCred(conn)
+	if err != nil {
+		return nil, err
+	}
+	return peerByPID(int(cred.Pid), int(cred.Uid), int(cred.Gid))
+}
+
+// peerCred returns the credentials of the process connected on conn.
+func peerCred(conn net.Conn) (*syscall.Ucred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a unix socket connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+	return cred, nil
+}
+
+func peerByPID(pid, uid, gid int) (*Peer, error) {
+	exe := "/proc/" + strconv.Itoa(pid) + "/exe"
+	p := &Peer{PID: pid, UID: uid, GID: gid, Exe: exe}
+	if target, err := os.Readlink(exe); err == nil {
+		p.Exe = target
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return p, fmt.Errorf("cannot read executable of pid %d: %w", pid, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return p, fmt.Errorf("cannot hash executable of pid %d: %w", pid, err)
+	}
+	p.Hash = hex.EncodeToString(h.Sum(nil))
+	return p, nil
+}
@@ -0,0 +1,77 @@
+package ipc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeerOf(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "vexd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, err := PeerOf(conn)
+	if err != nil {
+		t.Fatalf("PeerOf: %v", err)
+	}
+	if p.PID != os.Getpid() || p.UID != os.Getuid() {
+		t.Errorf("Expected this process, got %s", p)
+	}
+	exe, _ := os.Executable()
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if want := hex.EncodeToString(sum[:]); p.Hash != want {
+		t.Errorf("Hash = %s, want %s", p.Hash, want)
+	}
+}
+
+func TestVerifyClient(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "vexd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := &Server{}
+	s.RequireClient(func(hash string) bool { return false }, CmdUnlock)
+	if !s.guarded[CmdUnlock] || s.guarded[CmdStatus] {
+		t.Errorf("Unexpected guarded commands: %v", s.guarded)
+	}
+	if err := s.verifyClient(conn); err == nil {
+		t.Error("Expected an untrusted client to be refused")
+	}
+	s.RequireClient(func(hash string) bool { return hash != "" }, CmdUnlock)
+	if err := s.verifyClient(conn); err != nil {
+		t.Errorf("Expected a trusted client to pass, got %v", err)
+	}
+}
@@ -2,7 +2,10 @@
 // domain socket between vex-cli (client) and vexd (server).
 package ipc
 
-import "github.com/adumbdinosaur/vex-cli/internal/state"
+import (
+	"github.com/adumbdinosaur/vex-cli/internal/buildinfo"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
 
 // ── Command constants ───────────────────────────────────────────────
 
@@ -12,10 +15,17 @@ const (
 	CmdCPU         = "cpu"
 	CmdLatency     = "latency"
 	CmdOOM         = "oom"
+	CmdPriority    = "priority"
+	CmdGPU         = "gpu" // cap the graphics cards
+	CmdFreeze      = "freeze" // freeze the target sessions for a while
+	CmdBrightness  = "brightness"  // cap the backlight
+	CmdVolume      = "volume"      // cap or mute the audio output
 	CmdBlock       = "block"       // legacy: show guardian status
 	CmdBlockAdd    = "block-add"   // add a domain to the SNI blocklist
 	CmdBlockRemove = "block-rm"    // remove a domain from the SNI blocklist
 	CmdBlockList   = "block-list"  // list currently blocked domains
+	CmdBlockPass   = "block-pass"  // wait out a countdown for a short pass through a block
+	CmdBlockMonitor = "block-monitor" // warn on a domain's first accesses each day, then block it
 	CmdUnlock      = "unlock"
 	CmdPenance     = "penance"
 	CmdCheck       = "check"
@@ -29,18 +39,244 @@ const (
 	CmdAppRemove     = "app-rm"         // remove an app from the forbidden list
 	CmdAppList       = "app-list"       // list forbidden apps
 	CmdPenanceInput  = "penance-input"  // log a penance input line to daemon
+	CmdWatch         = "watch"          // stream state snapshots whenever state changes (and events with {"events":"true"})
+	CmdMetrics       = "metrics"        // surveillance metrics snapshot
+	CmdInputLock     = "inputlock"      // drop all keyboard input for a duration
+	CmdUsage         = "usage"          // daily screen-time totals
+	CmdTypingStart   = "typing-start"   // begin a keyboard-captured typing test
+	CmdTypingStatus  = "typing-status"  // progress of the running typing test
+	CmdTypingFinish  = "typing-finish"  // score (or abort) the running typing test
+	CmdCurfew        = "curfew"         // show the curfew
+	CmdCurfewSet     = "curfew-set"     // configure a nightly curfew
+	CmdCurfewOverride = "curfew-override" // end tonight's curfew or disable it (signed)
+	CmdLockUntil     = "lockuntil"      // stay locked until a deadline regardless of tasks
+	CmdEarlyRelease  = "early-release"  // end a lockuntil before its deadline (signed)
+	CmdAllowList     = "allow-list"     // list allowance windows
+	CmdAllowAdd      = "allow-add"      // permit domains/apps during a recurring window
+	CmdAllowRemove   = "allow-rm"       // delete an allowance window
+	CmdPause         = "pause"          // suspend all enforcement until a deadline (signed)
+	CmdResume        = "resume"         // end a pause early
+	CmdCalendar      = "calendar"       // calendar feed status and upcoming events
+	CmdExceptionRequest = "exception-request" // ask the keyholder to lift a domain/app for a while
+	CmdExceptionList    = "exception-list"    // pending exception requests
+	CmdExceptionCancel  = "exception-cancel"  // withdraw a pending request
+	CmdApprove          = "approve"           // approve a pending exception request (signed)
+	CmdPenanceFailed    = "penance-failed"    // report a failure the CLI recorded itself
+	CmdReport           = "report"            // compile a compliance report, optionally sending it
+	CmdHistory          = "history"           // bucketed metrics history for trends
+	CmdAuditExport      = "audit-export"      // the audit log as CSV or JSON records
+	CmdLinesRejected    = "lines-rejected"    // report a line the CLI rejected itself
+	CmdScheduleAdd      = "schedule-add"      // run a command at a time of day
+	CmdScheduleList     = "schedule-list"     // scheduled commands with their next runs
+	CmdScheduleRemove   = "schedule-rm"       // delete a scheduled command
+	CmdReload           = "reload"            // re-read config.json, the domain/app lists and the manifest
+	CmdConfig           = "config"            // the daemon configuration in effect, or one setting of it
+	CmdConfigSet        = "config-set"        // change one setting in config.json and reload (signed)
+	CmdHealth           = "health"            // liveness of the daemon's supervised workers
+	CmdVersion          = "version"           // the daemon's build
+	CmdCredits          = "credits"           // credit balance and the rewards on offer
+	CmdCreditsSpend     = "credits-spend"     // spend credits on a reward
+	CmdScoreHistory     = "score-history"     // every change of the failure score, with its cause
+	CmdHello            = "hello"             // open a session; its token goes with every later request
+	CmdSessions         = "sessions"          // open sessions, with the client behind each
+	CmdSessionRevoke    = "session-revoke"    // end a session and bar its process, or its executable, from opening another
+	CmdSessionUnbar     = "session-unbar"     // lift the bar on an executable
+	CmdAuditVerify      = "audit-verify"      // check the log against its signed daily checkpoints
+	CmdClock            = "clock"             // show or move the virtual clock of vexd --simulate
 )
 
 // Request is sent from the CLI to the daemon over the socket.
 type Request struct {
 	Command string            `json:"command"`
 	Args    map[string]string `json:"args,omitempty"`
+	Token   string            `json:"token,omitempty"` // from CmdHello; required on every other socket request
 }
 
 // Response is sent from the daemon back to the CLI.
 type Response struct {
-	OK      bool               `json:"ok"`
-	Message string             `json:"message,omitempty"`
-	Error   string             `json:"error,omitempty"`
-	State   *state.SystemState `json:"state,omitempty"` // included for status/state commands
+	OK          bool                 `json:"ok"`
+	Message     string               `json:"message,omitempty"`
+	Error       string               `json:"error,omitempty"`
+	Code        string               `json:"code,omitempty"`        // why a request failed; see the Code constants
+	State       *state.SystemState   `json:"state,omitempty"`       // included for status/state commands
+	Metrics     *SurveillanceMetrics `json:"metrics,omitempty"`     // included for the metrics command
+	Usage       []UsageDay           `json:"usage,omitempty"`       // included for the usage command, oldest first
+	Typing      *TypingTest          `json:"typing,omitempty"`      // included for typing-test commands
+	Penance     *PenanceProgress     `json:"penance,omitempty"`     // included for the penance-input command
+	Pass        *BlockPass           `json:"pass,omitempty"`        // included for the block-pass command
+	Events      []CalendarEvent      `json:"events,omitempty"`      // included for the calendar command
+	History     []HistoryPoint       `json:"history,omitempty"`     // included for the history command, oldest first
+	Health      []WorkerHealth       `json:"health,omitempty"`      // included for the health command, by name
+	Rewards     []Reward             `json:"rewards,omitempty"`     // included for the credits command, by name
+	Scores      []ScoreChange        `json:"scores,omitempty"`      // included for the score-history command, oldest first
+	Sessions    []Session            `json:"sessions,omitempty"`    // included for the sessions command, oldest first
+	Token       string               `json:"token,omitempty"`       // included for the hello command
+	Checkpoints []Checkpoint         `json:"checkpoints,omitempty"` // included for the audit-verify command, oldest first
+	Clock       *ClockStatus         `json:"clock,omitempty"`       // included for the clock command
+	Event       *Event               `json:"event,omitempty"`       // streamed to watchers that asked for events
+	Build       *buildinfo.Info      `json:"build,omitempty"`       // included for the version command
+	Daemon      string               `json:"daemon,omitempty"`      // the daemon's buildinfo ID, on every socket response
+	Queued      bool                 `json:"queued,omitempty"`      // set by vex-cli itself: vexd was down and the request is in the spool
+}
+
+// Codes classify failed responses so that clients need not parse Error.
+// A failed response without a code was refused as invalid: bad or missing
+// arguments, or a request that makes no sense in the current state.
+const (
+	CodeLocked  = "locked"  // refused because a lockuntil deadline or curfew is in force
+	CodeFailed  = "failed"  // valid, but carrying it out failed
+	CodeSession = "session" // the session token is missing, expired, revoked or not the sender's
+
+	CodeUnauthorized = "unauthorized" // the client is not a known-good build, or a signed payload was rejected
+)
+
+// Event is a daemon event streamed to CmdWatch connections opened with
+// {"events":"true"}, in a Response whose Message is "event".
+type Event struct {
+	Name    string            `json:"name"` // as in notify.json, e.g. "kill"
+	Time    string            `json:"time"` // RFC3339
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// CalendarEvent is an upcoming calendar event that maps to a preset.
+type CalendarEvent struct {
+	Summary string `json:"summary"`
+	Preset  string `json:"preset"`
+	Start   string `json:"start"` // RFC3339
+	End     string `json:"end"`   // RFC3339
+}
+
+// TypingTest reports a typing test whose input is read from the keyboard
+// by the daemon rather than from the CLI's stdin.
+type TypingTest struct {
+	Target     string   `json:"target"`
+	Typed      string   `json:"typed"`
+	Keystrokes int      `json:"keystrokes"`
+	Backspaces int      `json:"backspaces"`
+	KPM        float64  `json:"kpm"`
+	Accuracy   float64  `json:"accuracy"` // 0..1, position-by-position
+	Done       bool     `json:"done"`     // at least as many characters typed as the target
+	Passed     bool     `json:"passed,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// PenanceProgress reports where the penance submission being written
+// stands after each line; see penance.Progress.
+type PenanceProgress struct {
+	Words    int      `json:"words"`
+	MinWords int      `json:"min_words"`
+	Missing  []string `json:"missing,omitempty"`  // required phrases not written yet
+	Warnings []string `json:"warnings,omitempty"` // rhythm that would fail the submission now
+}
+
+// BlockPass reports a block pass: a countdown that must be checked in on
+// until ReadyAt and, once granted, the end of the pass.
+type BlockPass struct {
+	ID      string `json:"id"`
+	Domain  string `json:"domain"`
+	ReadyAt string `json:"ready_at"`        // RFC3339
+	Until   string `json:"until,omitempty"` // RFC3339; set once granted
+}
+
+// Reward is a reward on offer for credits; see internal/credits.
+type Reward struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"` // unblock, relax or lines
+	Cost    int      `json:"cost"`
+	Minutes int      `json:"minutes,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Profile string   `json:"profile,omitempty"`
+	Lines   int      `json:"lines,omitempty"`
+}
+
+// ScoreChange is one change of the failure score; see
+// penance.ScoreChange.
+type ScoreChange struct {
+	Time  string `json:"time"` // RFC3339
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+	Cause string `json:"cause"`
+}
+
+// Checkpoint is the result of checking one daily log checkpoint; see
+// checkpoint.Checkpoint.  Error is empty if it verified.
+type Checkpoint struct {
+	Date    string `json:"date"` // YYYY-MM-DD
+	Entries int    `json:"entries"`
+	Digest  string `json:"digest"`
+	Sealed  string `json:"sealed"` // RFC3339
+	Error   string `json:"error,omitempty"`
+}
+
+// Session is a client session opened with CmdHello.  It is bound to the
+// process that opened it: a token presented from any other PID or UID is
+// refused.
+type Session struct {
+	ID       string `json:"id"` // for logs and revocation; not the token
+	PID      int    `json:"pid"`
+	UID      int    `json:"uid"`
+	Exe      string `json:"exe"`
+	Hash     string `json:"sha256"`  // of the executable
+	Opened   string `json:"opened"`  // RFC3339
+	Expires  string `json:"expires"` // RFC3339
+	Requests int    `json:"requests"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+// HistoryPoint is one bucket of the metrics history returned by
+// CmdHistory.  Samples is 0 when the daemon recorded nothing in it.
+type HistoryPoint struct {
+	Start         string  `json:"start"` // RFC3339
+	Samples       int     `json:"samples"`
+	Score         int     `json:"score"`      // highest failure score
+	LockedPct     int     `json:"locked_pct"` // share of the bucket spent locked
+	Profile       string  `json:"profile,omitempty"`
+	Kills         int     `json:"kills"`
+	ActiveSeconds float64 `json:"active_seconds"`
+	Keystrokes    uint64  `json:"keystrokes"`
+}
+
+// ClockStatus is the virtual clock of vexd --simulate, returned by
+// CmdClock.
+type ClockStatus struct {
+	Now       string  `json:"now"`                 // RFC3339
+	Rate      float64 `json:"rate"`                // virtual seconds per real second; 0 when stopped
+	Advancing string  `json:"advancing,omitempty"` // RFC3339; where a running advance is taking the clock
+	Timers    int     `json:"timers"`              // deadlines and periodic jobs armed on it
+}
+
+// WorkerHealth is one supervised background worker returned by
+// CmdHealth, e.g. "guardian.dns-refresh".  A worker that failed is
+// Restarting until its backoff runs out.
+type WorkerHealth struct {
+	Name        string `json:"name"`
+	State       string `json:"state"` // "running" or "restarting"
+	Since       string `json:"since"` // RFC3339
+	Restarts    int    `json:"restarts"`
+	LastError   string `json:"last_error,omitempty"`
+	LastFailure string `json:"last_failure,omitempty"` // RFC3339
+}
+
+// UsageDay is one day of screen time returned by CmdUsage.
+type UsageDay struct {
+	Date          string             `json:"date"` // YYYY-MM-DD, daemon local time
+	ActiveSeconds float64            `json:"active_seconds"`
+	Keystrokes    uint64             `json:"keystrokes"`
+	Apps          map[string]float64 `json:"apps,omitempty"`  // focused seconds per app
+	Sites         map[string]float64 `json:"sites,omitempty"`   // seconds per site, from the browser extension
+	Network       map[string]uint64  `json:"network,omitempty"` // bytes sent and received per app
+}
+
+// SurveillanceMetrics is the live keyboard-surveillance snapshot returned
+// by CmdMetrics.
+type SurveillanceMetrics struct {
+	Keystrokes      uint64   `json:"keystrokes"`
+	LinesCompleted  uint64   `json:"lines_completed"`
+	KPM1m           float64  `json:"kpm_1m"`
+	KPM5m           float64  `json:"kpm_5m"`
+	Devices         []string `json:"devices"`
+	InputLatencyMs  int      `json:"input_latency_ms"`
+	InputLatencyMax int      `json:"input_latency_max_ms,omitempty"` // set when jitter is active
+	ActiveApp       string   `json:"active_app,omitempty"`
+	Since           string   `json:"since"` // RFC3339 start of the counting period
 }
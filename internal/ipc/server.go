@@ -7,9 +7,14 @@ import (
 	"net"
 	"os"
 	"os/user"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/buildinfo"
 	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
 	"github.com/adumbdinosaur/vex-cli/internal/state"
 )
@@ -25,6 +30,30 @@ type Server struct {
 	listener net.Listener
 	handlers map[string]Handler
 	state    *state.SystemState
+
+	// mu serializes handler invocations so concurrent clients never
+	// mutate the shared state at the same time.
+	mu sync.Mutex
+
+	// watchers are long-lived CmdWatch connections that receive a fresh
+	// state snapshot every time the state changes.
+	watchMu  sync.Mutex
+	watchers map[net.Conn]*watcher
+	lastSig  string
+
+	// onChange callbacks run after watchers are told of a change.
+	onChange []func(st *state.SystemState)
+
+	// onPanic, if set, is handed a panic raised while serving a request.
+	onPanic func(v any)
+
+	// guarded commands are only honoured from a client whose executable
+	// trusted accepts; see RequireClient.
+	guarded map[string]bool
+	trusted func(hash string) bool
+
+	// sessions are the tokens handed out by CmdHello.
+	sessions *sessions
 }
 
 // NewServer creates a server bound to the well-known socket path.
@@ -61,11 +90,18 @@ func NewServer(sysState *state.SystemState) (*Server, error) {
 		log.Printf("IPC: Socket group set to 'vex' — non-root group members can connect")
 	}
 
-	return &Server{
+	s := &Server{
 		listener: ln,
 		handlers: make(map[string]Handler),
 		state:    sysState,
-	}, nil
+		watchers: make(map[net.Conn]*watcher),
+		lastSig:  stateSignature(sysState),
+		sessions: newSessions(),
+	}
+	s.Handle(CmdSessions, s.handleSessions)
+	s.Handle(CmdSessionRevoke, s.handleSessionRevoke)
+	s.Handle(CmdSessionUnbar, s.handleSessionUnbar)
+	return s, nil
 }
 
 // Handle registers a handler for a command name.
@@ -93,6 +129,7 @@ func (s *Server) Close() error {
 }
 
 // GetState returns a pointer to the current state (for the daemon to read).
+// Callers that mutate it should go through Update instead.
 func (s *Server) GetState() *state.SystemState {
 	return s.state
 }
@@ -100,11 +137,181 @@ func (s *Server) GetState() *state.SystemState {
 // SetState replaces the in-memory state (for the daemon to call after
 // applying settings imperatively, e.g. from penance enforcement).
 func (s *Server) SetState(st *state.SystemState) {
+	s.mu.Lock()
 	s.state = st
+	s.mu.Unlock()
+	s.Notify()
+}
+
+// Update runs fn against the live state under the handler lock, persists
+// the result and notifies watchers.  The daemon uses it to mutate state
+// outside of an IPC request (timers, escalation, background checks).
+// The lock is released even if fn panics, so a failing supervised worker
+// does not wedge the daemon.
+func (s *Server) Update(fn func(st *state.SystemState)) {
+	s.View(func(st *state.SystemState) {
+		fn(st)
+		if err := state.Save(st); err != nil {
+			log.Printf("IPC: Failed to persist state after update: %v", err)
+		}
+	})
+	s.Notify()
+}
+
+// View runs fn against the live state under the handler lock without
+// persisting anything, for background readers that need a consistent copy.
+func (s *Server) View(fn func(st *state.SystemState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.state)
+}
+
+// OnChange registers fn to run, with the handler lock held, whenever
+// Notify sees a changed state.  fn must not block or call back into the
+// server.
+func (s *Server) OnChange(fn func(st *state.SystemState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// OnPanic registers fn to receive the value of any panic raised while a
+// connection is served, after the handler lock is released.  Without it
+// the panic takes the process down as usual.  Call it before Serve.
+func (s *Server) OnPanic(fn func(v any)) {
+	s.onPanic = fn
+}
+
+// RequireClient makes the server refuse the given commands unless the
+// process sending them runs an executable whose SHA-256 trusted accepts,
+// so that a patched vex-cli cannot lower restrictions.  Requests handed
+// to Dispatch directly, as the chat bridges do, are not checked.  Call it
+// before Serve.  The server's own session commands are always checked,
+// as they can shut clients out or let them back in.
+func (s *Server) RequireClient(trusted func(hash string) bool, commands ...string) {
+	s.trusted = trusted
+	s.guarded = make(map[string]bool, len(commands)+3)
+	for _, c := range append(commands, CmdSessions, CmdSessionRevoke, CmdSessionUnbar) {
+		s.guarded[c] = true
+	}
+}
+
+// verifyClient checks the peer on conn against the trusted builds.
+func (s *Server) verifyClient(conn net.Conn) error {
+	p, err := PeerOf(conn)
+	if err != nil {
+		return err
+	}
+	if !s.trusted(p.Hash) {
+		return fmt.Errorf("untrusted client %s", p)
+	}
+	return nil
+}
+
+// Notify pushes the current state to every watcher if it changed since
+// the last push.  It must not be called while a handler is running.  The
+// state is encoded under the handler lock and written by each watcher's
+// own goroutine, so a watcher that stops reading holds up nobody.
+func (s *Server) Notify() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sig := stateSignature(s.state)
+	if sig == s.lastSig {
+		return
+	}
+	s.lastSig = sig
+
+	data, err := encodeLine(&Response{OK: true, Message: "state-changed", State: s.state})
+	if err != nil {
+		log.Printf("IPC: Failed to encode state for watchers: %v", err)
+	} else {
+		s.watchMu.Lock()
+		for _, w := range s.watchers {
+			s.queueLocked(w, data)
+		}
+		s.watchMu.Unlock()
+	}
+	for _, fn := range s.onChange {
+		fn(s.state)
+	}
+}
+
+// Broadcast streams e to every watcher that asked for events.  It takes
+// only the watcher lock, so it may be called while a handler is running.
+func (s *Server) Broadcast(e *Event) {
+	data, err := encodeLine(&Response{OK: true, Message: "event", Event: e})
+	if err != nil {
+		log.Printf("IPC: Failed to encode event for watchers: %v", err)
+		return
+	}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for _, w := range s.watchers {
+		if w.events {
+			s.queueLocked(w, data)
+		}
+	}
+}
+
+// watcherBacklog is how many messages a watcher may fall behind by before
+// it is dropped.
+const watcherBacklog = 64
+
+// watcher is a CmdWatch connection and the messages waiting to be
+// written to it.
+type watcher struct {
+	conn   net.Conn
+	events bool // whether it asked for events as well
+	out    chan []byte
+}
+
+// write writes the queued messages to the connection until out is closed
+// or a write fails.
+func (w *watcher) write() {
+	for data := range w.out {
+		w.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		if _, err := w.conn.Write(data); err != nil {
+			log.Printf("IPC: Dropping watcher: %v", err)
+			w.conn.Close()
+			for range w.out {
+			}
+			return
+		}
+	}
+}
+
+// queueLocked queues data, one JSON line, for w, dropping w if it is too
+// far behind.  Call with watchMu held.
+func (s *Server) queueLocked(w *watcher, data []byte) {
+	select {
+	case w.out <- data:
+	default:
+		log.Printf("IPC: Dropping watcher: more than %d messages behind", watcherBacklog)
+		s.dropLocked(w)
+	}
+}
+
+// dropLocked closes w and forgets it.  Call with watchMu held.
+func (s *Server) dropLocked(w *watcher) {
+	if s.watchers[w.conn] != w {
+		return
+	}
+	delete(s.watchers, w.conn)
+	close(w.out)
+	w.conn.Close()
 }
 
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			if s.onPanic == nil {
+				panic(r)
+			}
+			s.onPanic(r)
+		}
+	}()
 
 	// Decode request
 	dec := json.NewDecoder(conn)
@@ -114,23 +321,151 @@ func (s *Server) handle(conn net.Conn) {
 		return
 	}
 
-	vexlog.LogEvent("IPC", "REQUEST", fmt.Sprintf("cmd=%s args=%v", req.Command, req.Args))
+	if req.Command == CmdHello {
+		writeResp(conn, s.hello(conn))
+		return
+	}
+
+	cred, err := peerCred(conn)
+	if err != nil {
+		writeResp(conn, &Response{OK: false, Error: err.Error(), Daemon: buildinfo.Get().ID()})
+		return
+	}
+	sess, err := s.sessions.check(req.Token, cred)
+	if err != nil {
+		vexlog.LogEvent("IPC", "SESSION_REFUSED", fmt.Sprintf("cmd=%s pid=%d uid=%d reason=%q", req.Command, cred.Pid, cred.Uid, err))
+		writeResp(conn, &Response{OK: false, Code: CodeSession, Error: err.Error(), Daemon: buildinfo.Get().ID()})
+		return
+	}
+
+	vexlog.LogEvent("IPC", "REQUEST", fmt.Sprintf("cmd=%s args=%v session=%s", req.Command, req.Args, sess.ID))
+
+	if req.Command == CmdWatch {
+		s.watch(conn, req.Args["events"] == "true")
+		return
+	}
+
+	if s.guarded[req.Command] {
+		if err := s.verifyClient(conn); err != nil {
+			vexlog.LogEvent("IPC", "PEER_REJECTED", fmt.Sprintf("cmd=%s %v", req.Command, err))
+			writeResp(conn, &Response{OK: false, Code: CodeUnauthorized, Error: "refused: this vex-cli is not a known-good build", Daemon: buildinfo.Get().ID()})
+			return
+		}
+	}
+
+	resp := s.Dispatch(&req)
+	resp.Daemon = buildinfo.Get().ID()
+	writeResp(conn, resp)
+}
 
+// Dispatch runs a request through its handler exactly as if it had
+// arrived on the socket.  The chat bridges use it to run commands inside
+// the daemon.
+func (s *Server) Dispatch(req *Request) *Response {
 	h, ok := s.handlers[req.Command]
 	if !ok {
-		writeResp(conn, &Response{OK: false, Error: fmt.Sprintf("unknown command: %s", req.Command)})
+		return &Response{OK: false, Error: fmt.Sprintf("unknown command: %s", req.Command)}
+	}
+
+	var resp *Response
+	s.View(func(st *state.SystemState) {
+		resp = h(st, req)
+
+		// Persist state after every mutation (handlers that are read-only
+		// can simply not modify the state struct).
+		if err := state.Save(st); err != nil {
+			log.Printf("IPC: Failed to persist state after %s: %v", req.Command, err)
+		}
+	})
+
+	s.Notify()
+	return resp
+}
+
+// hello opens a session for the process on conn.
+func (s *Server) hello(conn net.Conn) *Response {
+	resp := &Response{Daemon: buildinfo.Get().ID()}
+	p, err := PeerOf(conn)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	var barred bool
+	s.View(func(st *state.SystemState) { barred = slices.Contains(st.Barred, p.Hash) })
+	if barred {
+		vexlog.LogEvent("IPC", "SESSION_REFUSED", fmt.Sprintf("cmd=%s %v reason=barred", CmdHello, p))
+		resp.Code = CodeUnauthorized
+		resp.Error = fmt.Sprintf("executable %s (sha256 %s) is barred from the daemon", p.Exe, p.Hash)
+		return resp
+	}
+	sess, err := s.sessions.open(p)
+	if err != nil {
+		vexlog.LogEvent("IPC", "SESSION_REFUSED", fmt.Sprintf("cmd=%s %v reason=%q", CmdHello, p, err))
+		resp.Error = err.Error()
+		return resp
+	}
+	vexlog.LogEvent("IPC", "SESSION_OPENED", fmt.Sprintf("session=%s %v", sess.ID, p))
+	resp.OK = true
+	resp.Message = sess.ID
+	resp.Token = sess.token
+	return resp
+}
+
+// watch registers conn as a watcher, sends the current state immediately,
+// and blocks until the client hangs up.
+func (s *Server) watch(conn net.Conn, events bool) {
+	w := &watcher{conn: conn, events: events, out: make(chan []byte, watcherBacklog)}
+	s.mu.Lock()
+	data, err := encodeLine(&Response{OK: true, Message: "watching", State: s.state})
+	if err == nil {
+		s.watchMu.Lock()
+		s.watchers[conn] = w
+		w.out <- data
+		s.watchMu.Unlock()
+	}
+	s.mu.Unlock()
+	if err != nil {
 		return
 	}
+	go w.write()
+	s.watchMu.Lock()
+	active := len(s.watchers)
+	s.watchMu.Unlock()
+	log.Printf("IPC: Watcher attached (%d active)", active)
 
-	resp := h(s.state, &req)
+	// Watchers never send anything after the initial request; a read
+	// returning means the peer closed the connection.
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
 
-	// Persist state after every mutation (handlers that are read-only
-	// can simply not modify the state struct).
-	if err := state.Save(s.state); err != nil {
-		log.Printf("IPC: Failed to persist state after %s: %v", req.Command, err)
+	s.watchMu.Lock()
+	s.dropLocked(w)
+	s.watchMu.Unlock()
+}
+
+// stateSignature fingerprints the state, ignoring the LastUpdated stamp
+// which changes on every save even when nothing else did.
+func stateSignature(st *state.SystemState) string {
+	if st == nil {
+		return ""
+	}
+	snap := *st
+	snap.LastUpdated = ""
+	data, err := json.Marshal(&snap)
+	if err != nil {
+		return ""
 	}
+	return string(data)
+}
 
-	writeResp(conn, resp)
+// encodeLine encodes v as a line of JSON, as json.Encoder writes it.
+func encodeLine(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return append(data, '\n'), err
 }
 
 func writeResp(conn net.Conn, resp *Response) {
@@ -140,6 +475,62 @@ func writeResp(conn net.Conn, resp *Response) {
 
 // ── Built-in handler helpers ────────────────────────────────────────
 
+func (s *Server) handleSessions(st *state.SystemState, _ *Request) *Response {
+	return &Response{OK: true, Sessions: s.sessions.list(), State: st}
+}
+
+// handleSessionRevoke ends a session and bars its process until the
+// session would have expired.  A new process gets a new session, so with
+// exe=true it bars the session's executable instead, persistently, and
+// ends all its sessions.  A known-good vex-cli cannot be barred, so that
+// a revocation cannot shut out the keyholder.
+func (s *Server) handleSessionRevoke(st *state.SystemState, req *Request) *Response {
+	id := req.Args["id"]
+	if id == "" {
+		return &Response{OK: false, Error: "missing 'id' argument"}
+	}
+	if req.Args["exe"] != "true" {
+		sess, err := s.sessions.revoke(id)
+		if err != nil {
+			return &Response{OK: false, Error: err.Error()}
+		}
+		vexlog.LogEvent("IPC", "SESSION_REVOKED", fmt.Sprintf("session=%s pid=%d uid=%d exe=%s sha256=%s", sess.ID, sess.PID, sess.UID, sess.Exe, sess.Hash))
+		return &Response{OK: true, Message: fmt.Sprintf("Session %s (pid %d) revoked", sess.ID, sess.PID)}
+	}
+
+	open := s.sessions.list()
+	i := slices.IndexFunc(open, func(sess Session) bool { return sess.ID == id && !sess.Revoked })
+	if i < 0 {
+		return &Response{OK: false, Error: fmt.Sprintf("no open session %q", id)}
+	}
+	sess := open[i]
+	if s.trusted != nil && s.trusted(sess.Hash) {
+		return &Response{OK: false, Error: fmt.Sprintf("session %s runs a known-good vex-cli, which cannot be barred; revoke it without exe", id)}
+	}
+	if !slices.Contains(st.Barred, sess.Hash) {
+		st.Barred = append(st.Barred, sess.Hash)
+	}
+	n := s.sessions.revokeExe(sess.Hash)
+	vexlog.LogEvent("IPC", "CLIENT_BARRED", fmt.Sprintf("session=%s pid=%d uid=%d exe=%s sha256=%s sessions=%d", sess.ID, sess.PID, sess.UID, sess.Exe, sess.Hash, n))
+	return &Response{OK: true, Message: fmt.Sprintf("%s (sha256 %s) barred; %d session(s) revoked", sess.Exe, sess.Hash, n)}
+}
+
+// handleSessionUnbar lets an executable barred by handleSessionRevoke
+// open sessions again.
+func (s *Server) handleSessionUnbar(st *state.SystemState, req *Request) *Response {
+	hash := strings.ToLower(req.Args["hash"])
+	if hash == "" {
+		return &Response{OK: false, Error: "missing 'hash' argument"}
+	}
+	i := slices.Index(st.Barred, hash)
+	if i < 0 {
+		return &Response{OK: false, Error: fmt.Sprintf("no executable with sha256 %s is barred", hash)}
+	}
+	st.Barred = slices.Delete(st.Barred, i, i+1)
+	vexlog.LogEvent("IPC", "CLIENT_UNBARRED", "sha256="+hash)
+	return &Response{OK: true, Message: fmt.Sprintf("Executable with sha256 %s may open sessions again", hash)}
+}
+
 // ParseIntArg is a convenience for handlers that need an integer arg.
 func ParseIntArg(args map[string]string, key string) (int, error) {
 	v, ok := args[key]
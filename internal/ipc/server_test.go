@@ -0,0 +1,48 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+func TestNotifyDoesNotWaitForWatchers(t *testing.T) {
+	s := &Server{
+		handlers: make(map[string]Handler),
+		state:    &state.SystemState{},
+		watchers: make(map[net.Conn]*watcher),
+		sessions: newSessions(),
+	}
+	// A watcher that never reads: every write to a pipe blocks.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go s.watch(conn, true)
+	for {
+		s.watchMu.Lock()
+		n := len(s.watchers)
+		s.watchMu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	for i := 0; i < 2*watcherBacklog; i++ {
+		s.View(func(st *state.SystemState) { st.ChangedBy = fmt.Sprint(i) })
+		s.Notify()
+		s.Broadcast(&Event{Name: "test"})
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Notify and Broadcast took %s with a stuck watcher", d)
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if len(s.watchers) != 0 {
+		t.Error("Expected the stuck watcher to be dropped once it fell behind")
+	}
+}
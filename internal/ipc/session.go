@@ -0,0 +1,158 @@
+package ipc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SessionTTL is how long a session token is good for.  Clients open a
+// new session when theirs runs out, so it only bounds how long a leaked
+// or revoked token could matter.
+var SessionTTL = 15 * time.Minute
+
+// session is a Session with the secret its client presents.
+type session struct {
+	Session
+	token   string
+	expires time.Time
+}
+
+// sessions tracks the sessions the server has issued.
+type sessions struct {
+	mu     sync.Mutex
+	byTok  map[string]*session
+	barred map[int]time.Time // PID -> until, for revoked sessions
+}
+
+func newSessions() *sessions {
+	return &sessions{byTok: make(map[string]*session), barred: make(map[int]time.Time)}
+}
+
+// open issues a session to p, unless one of p's sessions was revoked
+// within the last SessionTTL.
+func (ss *sessions) open(p *Peer) (*session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	now := time.Now()
+	ss.prune(now)
+	if until, ok := ss.barred[p.PID]; ok {
+		return nil, fmt.Errorf("pid %d had its session revoked; try again after %s", p.PID, until.Format("15:04:05"))
+	}
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{
+		Session: Session{ID: id, PID: p.PID, UID: p.UID, Exe: p.Exe, Hash: p.Hash, Opened: now.Format(time.RFC3339)},
+		token:   token,
+		expires: now.Add(SessionTTL),
+	}
+	sess.Expires = sess.expires.Format(time.RFC3339)
+	ss.byTok[token] = sess
+	return sess, nil
+}
+
+// check returns the session token belongs to, counting the request, if
+// it is live and was opened by the process with the given credentials.
+func (ss *sessions) check(token string, cred *syscall.Ucred) (*session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sess, ok := ss.byTok[token]
+	switch {
+	case token == "" || !ok:
+		return nil, errors.New("no session; send hello first")
+	case sess.Revoked:
+		return nil, fmt.Errorf("session %s was revoked", sess.ID)
+	case time.Now().After(sess.expires):
+		return nil, fmt.Errorf("session %s expired", sess.ID)
+	case int(cred.Pid) != sess.PID || int(cred.Uid) != sess.UID:
+		return nil, fmt.Errorf("session %s belongs to pid %d, not pid %d", sess.ID, sess.PID, cred.Pid)
+	}
+	sess.Requests++
+	return sess, nil
+}
+
+// revoke ends the session with the given ID and bars its process from
+// opening another until the session would have expired.
+func (ss *sessions) revoke(id string) (*Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, sess := range ss.byTok {
+		if sess.ID != id || sess.Revoked {
+			continue
+		}
+		sess.Revoked = true
+		ss.barred[sess.PID] = sess.expires
+		info := sess.Session
+		return &info, nil
+	}
+	return nil, fmt.Errorf("no open session %q", id)
+}
+
+// revokeExe ends every open session of the executable with the given
+// SHA-256 and returns how many there were.
+func (ss *sessions) revokeExe(hash string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	n := 0
+	for _, sess := range ss.byTok {
+		if sess.Hash == hash && !sess.Revoked {
+			sess.Revoked = true
+			n++
+		}
+	}
+	return n
+}
+
+// list returns the sessions that have not expired, oldest first.
+func (ss *sessions) list() []Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	now := time.Now()
+	ss.prune(now)
+	var out []Session
+	for _, sess := range ss.byTok {
+		if now.Before(sess.expires) {
+			out = append(out, sess.Session)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Opened != out[j].Opened {
+			return out[i].Opened < out[j].Opened
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// prune forgets sessions and bars that ran out.  The caller holds mu.
+func (ss *sessions) prune(now time.Time) {
+	for token, sess := range ss.byTok {
+		if now.After(sess.expires) {
+			delete(ss.byTok, token)
+		}
+	}
+	for pid, until := range ss.barred {
+		if now.After(until) {
+			delete(ss.barred, pid)
+		}
+	}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
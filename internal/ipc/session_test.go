@@ -0,0 +1,62 @@
+package ipc
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	ss := newSessions()
+	sess, err := ss.open(&Peer{PID: 100, UID: 1000, Exe: "/usr/bin/vex-cli"})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if sess.token == "" || sess.ID == "" || sess.token == sess.ID {
+		t.Fatalf("Expected a secret token apart from the ID, got %+v", sess)
+	}
+
+	owner := &syscall.Ucred{Pid: 100, Uid: 1000}
+	if _, err := ss.check(sess.token, owner); err != nil {
+		t.Errorf("Expected the owner's token to pass, got %v", err)
+	}
+	if _, err := ss.check(sess.token, &syscall.Ucred{Pid: 101, Uid: 1000}); err == nil {
+		t.Error("Expected the token to be refused from another process")
+	}
+	if _, err := ss.check("", owner); err == nil {
+		t.Error("Expected a request without a token to be refused")
+	}
+	if got := ss.list(); len(got) != 1 || got[0].Requests != 1 {
+		t.Errorf("list = %+v, want one session with one request", got)
+	}
+
+	if _, err := ss.revoke(sess.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := ss.check(sess.token, owner); err == nil {
+		t.Error("Expected a revoked token to be refused")
+	}
+	if _, err := ss.open(&Peer{PID: 100, UID: 1000}); err == nil {
+		t.Error("Expected the revoked process to be barred from a new session")
+	}
+	if _, err := ss.open(&Peer{PID: 200, UID: 1000}); err != nil {
+		t.Errorf("Expected another process to get a session, got %v", err)
+	}
+
+	other, err := ss.open(&Peer{PID: 300, UID: 1000, Hash: "ab12"})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if n := ss.revokeExe("ab12"); n != 1 {
+		t.Errorf("revokeExe ended %d sessions, want 1", n)
+	}
+	if _, err := ss.check(other.token, &syscall.Ucred{Pid: 300, Uid: 1000}); err == nil {
+		t.Error("Expected a session of the barred executable to be refused")
+	}
+
+	ss.byTok[sess.token].expires = time.Now().Add(-time.Second)
+	ss.barred[100] = time.Now().Add(-time.Second)
+	if _, err := ss.open(&Peer{PID: 100, UID: 1000}); err != nil {
+		t.Errorf("Expected the bar to lift with the session's expiry, got %v", err)
+	}
+}
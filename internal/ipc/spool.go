@@ -0,0 +1,136 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// -- Spool --
+//
+// While vexd is down, vex-cli queues the commands that can only tighten
+// the restrictions in state.SpoolDir instead of failing, and vexd runs
+// them when it starts.  Nothing that lowers a restriction is queued: it
+// would run later, without the daemon's checks at the time it was given.
+
+// spoolable are the commands vex-cli may queue.
+var spoolable = []string{CmdBlockAdd, CmdLinesSet}
+
+// Spoolable reports whether command may be queued.
+func Spoolable(command string) bool {
+	return slices.Contains(spoolable, command)
+}
+
+// Unreachable reports whether err means the daemon is not running, as
+// opposed to a request that failed on the way.  Only then has the daemon
+// certainly not seen the request, so that queueing it cannot run it twice.
+func Unreachable(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Spooled is a request queued in state.SpoolDir.
+type Spooled struct {
+	Queued  string  `json:"queued"` // RFC3339
+	Request Request `json:"request"`
+	UID     int     `json:"-"` // who queued it: the owner of its file
+	File    string  `json:"-"`
+}
+
+// Spool queues req in state.SpoolDir and returns the file it is in.
+func Spool(req *Request) (string, error) {
+	if !Spoolable(req.Command) {
+		return "", fmt.Errorf("%s cannot be queued", req.Command)
+	}
+	data, err := json.Marshal(Spooled{
+		Queued:  time.Now().UTC().Format(time.RFC3339),
+		Request: Request{Command: req.Command, Args: req.Args},
+	})
+	if err != nil {
+		return "", err
+	}
+	// Written under a dot name and renamed, so that vexd never reads half
+	// a file.
+	f, err := os.CreateTemp(state.SpoolDir, ".queue-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to queue %s: %w", req.Command, err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to queue %s: %w", req.Command, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to queue %s: %w", req.Command, err)
+	}
+	name := filepath.Join(state.SpoolDir, fmt.Sprintf("%d-%d.json", time.Now().UnixNano(), os.Getpid()))
+	if err := os.Rename(f.Name(), name); err != nil {
+		return "", fmt.Errorf("failed to queue %s: %w", req.Command, err)
+	}
+	return name, nil
+}
+
+// Unspool removes every request queued in state.SpoolDir and returns
+// them, oldest first.  A request is removed before it is run, so that one
+// that brings the daemon down cannot run again at every start.  Files
+// that do not hold a request that may be queued are removed and reported.
+func Unspool() ([]Spooled, error) {
+	entries, err := os.ReadDir(state.SpoolDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Spooled
+	var errs []error
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || !e.Type().IsRegular() {
+			continue
+		}
+		file := filepath.Join(state.SpoolDir, e.Name())
+		sp, err := readSpooled(file)
+		if rmErr := os.Remove(file); rmErr != nil {
+			errs = append(errs, rmErr)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+			continue
+		}
+		list = append(list, sp)
+	}
+	// Names start with the time queued.
+	slices.SortFunc(list, func(a, b Spooled) int { return strings.Compare(a.File, b.File) })
+	return list, errors.Join(errs...)
+}
+
+func readSpooled(file string) (Spooled, error) {
+	var sp Spooled
+	fi, err := os.Lstat(file)
+	if err != nil {
+		return sp, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return sp, err
+	}
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return sp, err
+	}
+	if !Spoolable(sp.Request.Command) {
+		return sp, fmt.Errorf("%q cannot be queued", sp.Request.Command)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		sp.UID = int(st.Uid)
+	}
+	sp.File = file
+	return sp, nil
+}
@@ -0,0 +1,58 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+func TestSpool(t *testing.T) {
+	old := state.SpoolDir
+	state.SpoolDir = t.TempDir()
+	t.Cleanup(func() { state.SpoolDir = old })
+
+	if _, err := Spool(&Request{Command: CmdUnlock}); err == nil {
+		t.Error("Expected unlock not to be queued")
+	}
+	if _, err := Spool(&Request{Command: CmdBlockAdd, Args: map[string]string{"domain": "reddit.com"}, Token: "t"}); err != nil {
+		t.Fatalf("Spool: %v", err)
+	}
+	if _, err := Spool(&Request{Command: CmdLinesSet, Args: map[string]string{"count": "50", "phrase": "I will focus."}}); err != nil {
+		t.Fatalf("Spool: %v", err)
+	}
+	// Written by hand, as vex-cli never would.
+	os.WriteFile(filepath.Join(state.SpoolDir, "1-1.json"), []byte(`{"request": {"command": "unlock"}}`), 0o600)
+
+	list, err := Unspool()
+	if err == nil {
+		t.Error("Expected the unlock file to be reported")
+	}
+	if len(list) != 2 || list[0].Request.Command != CmdBlockAdd || list[1].Request.Command != CmdLinesSet {
+		t.Fatalf("Got %+v; want block-add then lines-set", list)
+	}
+	if list[0].Request.Token != "" || list[0].Request.Args["domain"] != "reddit.com" || list[0].UID != os.Getuid() {
+		t.Errorf("Got %+v", list[0])
+	}
+	if entries, _ := os.ReadDir(state.SpoolDir); len(entries) != 0 {
+		t.Errorf("Expected an empty spool, got %d files", len(entries))
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	c := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+	saved := retryDelays
+	retryDelays = nil
+	t.Cleanup(func() { retryDelays = saved })
+
+	_, err := c.Send(&Request{Command: CmdStatus})
+	if !Unreachable(err) {
+		t.Errorf("Expected a missing socket to be unreachable, got %v", err)
+	}
+	if Unreachable(fmt.Errorf("failed to read response: %w", net.ErrClosed)) {
+		t.Error("Expected a failed exchange not to count as unreachable")
+	}
+}
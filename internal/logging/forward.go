@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------
+// Remote forwarding
+// ---------------------------------------------------------------------
+//
+// Audit entries written by the daemon can be copied to a remote syslog
+// server run by the keyholder, so that wiping the local log does not
+// erase the record.  Messages are RFC 5424 and
+// are framed by octet counting over TCP and TLS (RFC 5425) or sent one
+// per datagram over UDP.  Delivery never blocks logging: entries queue in
+// memory while the server is unreachable, and when the queue is full the
+// oldest undelivered ones are dropped and counted.
+
+var (
+	// ForwardConfigFile holds the remote syslog endpoint.  Optional.
+	ForwardConfigFile = "/etc/vex-cli/syslog.json"
+
+	// ForwardQueue is how many entries are held while the server is down.
+	ForwardQueue = 1000
+
+	// ForwardTimeout bounds a single connect or write.
+	ForwardTimeout = 10 * time.Second
+)
+
+// syslogFacility is "log audit" (13) and syslogSeverity "notice" (5).
+const (
+	syslogFacility = 13
+	syslogSeverity = 5
+)
+
+// ForwardConfig is the contents of ForwardConfigFile.
+type ForwardConfig struct {
+	Address   string `json:"address"`             // host:port
+	Transport string `json:"transport,omitempty"` // tls (default), tcp or udp
+	CAFile    string `json:"ca_file,omitempty"`   // CA for the server; system roots otherwise
+	CertFile  string `json:"cert_file,omitempty"` // client certificate, if the server wants one
+	KeyFile   string `json:"key_file,omitempty"`
+}
+
+type forwarder struct {
+	cfg     ForwardConfig
+	tls     *tls.Config
+	host    string
+	queue   chan string
+	dropped atomic.Int64
+}
+
+var fwd atomic.Pointer[forwarder]
+
+// StartForwarding loads ForwardConfigFile and starts copying audit
+// entries to the server it names.  A missing file leaves forwarding off.
+// Only the daemon calls this.
+func StartForwarding() error {
+	data, err := os.ReadFile(ForwardConfigFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c ForwardConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("%s: %w", ForwardConfigFile, err)
+	}
+	f, err := newForwarder(c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ForwardConfigFile, err)
+	}
+	fwd.Store(f)
+	go f.run()
+	log.Printf("Logging: Forwarding audit events to %s (%s)", c.Address, f.cfg.Transport)
+	return nil
+}
+
+func newForwarder(c ForwardConfig) (*forwarder, error) {
+	if c.Transport == "" {
+		c.Transport = "tls"
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return nil, fmt.Errorf("address: %w", err)
+	}
+	f := &forwarder{cfg: c, queue: make(chan string, ForwardQueue)}
+	f.host, _ = os.Hostname()
+	if f.host == "" {
+		f.host = "-"
+	}
+
+	switch c.Transport {
+	case "tcp", "udp":
+	case "tls":
+		f.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.CAFile != "" {
+			pem, err := os.ReadFile(c.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			f.tls.RootCAs = x509.NewCertPool()
+			if !f.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", c.CAFile)
+			}
+		}
+		if c.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			f.tls.Certificates = []tls.Certificate{cert}
+		}
+	default:
+		return nil, fmt.Errorf("transport must be tls, tcp or udp")
+	}
+	return f, nil
+}
+
+// forward queues an audit entry for the remote server, if there is one.
+func forward(msgID, msg string) {
+	f := fwd.Load()
+	if f == nil {
+		return
+	}
+	line := f.format(time.Now(), msgID, msg)
+	for {
+		select {
+		case f.queue <- line:
+			return
+		default:
+		}
+		// Full: make room by dropping the oldest entry.
+		select {
+		case <-f.queue:
+			f.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// format renders an RFC 5424 message.
+func (f *forwarder) format(t time.Time, msgID, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s vex-cli %d %s - %s",
+		syslogFacility*8+syslogSeverity, t.UTC().Format(time.RFC3339Nano),
+		f.host, os.Getpid(), syslogToken(msgID), msg)
+}
+
+// syslogToken makes s a valid MSGID: printable ASCII, no spaces, at most
+// 32 characters.
+func syslogToken(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (f *forwarder) run() {
+	var conn net.Conn
+	delay := time.Second
+	down := false
+	for line := range f.queue {
+		for {
+			if conn == nil {
+				c, err := f.dial()
+				if err != nil {
+					if !down {
+						log.Printf("Logging: WARNING - %s unreachable, queueing audit events: %v", f.cfg.Address, err)
+						down = true
+					}
+					time.Sleep(delay)
+					delay = min(delay*2, time.Minute)
+					continue
+				}
+				conn, delay = c, time.Second
+				if down {
+					down = false
+					LogEvent("LOGGING", "FORWARD_RESTORED",
+						fmt.Sprintf("server=%s dropped=%d", f.cfg.Address, f.dropped.Swap(0)))
+				}
+			}
+			if err := f.write(conn, line); err != nil {
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+func (f *forwarder) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: ForwardTimeout}
+	if f.tls != nil {
+		return tls.DialWithDialer(d, "tcp", f.cfg.Address, f.tls)
+	}
+	return d.Dial(f.cfg.Transport, f.cfg.Address)
+}
+
+func (f *forwarder) write(conn net.Conn, line string) error {
+	conn.SetWriteDeadline(time.Now().Add(ForwardTimeout))
+	if f.cfg.Transport != "udp" {
+		line = fmt.Sprintf("%d %s", len(line), line) // octet counting
+	}
+	_, err := conn.Write([]byte(line))
+	return err
+}
+
+// flushForward gives queued entries until the deadline to reach the
+// server before the daemon exits.
+func flushForward(deadline time.Time) {
+	f := fwd.Load()
+	if f == nil {
+		return
+	}
+	for len(f.queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
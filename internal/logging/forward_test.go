@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForward_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := newForwarder(ForwardConfig{Address: ln.Addr().String(), Transport: "tcp"})
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	fwd.Store(f)
+	defer fwd.Store(nil)
+	go f.run()
+
+	LogEvent("SYSTEM", "UNLOCK", "all restrictions lifted")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	var n int
+	if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+		t.Fatalf("Expected an octet-counted frame: %v", err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(msg), "<109>1 ") {
+		t.Errorf("Expected RFC 5424 with PRI 109, got %q", msg)
+	}
+	if !strings.Contains(string(msg), " vex-cli ") || !strings.HasSuffix(string(msg), " UNLOCK - [SYSTEM] UNLOCK: all restrictions lifted") {
+		t.Errorf("Unexpected message %q", msg)
+	}
+}
+
+func TestForward_DropsOldestWhenFull(t *testing.T) {
+	ForwardQueue = 2
+	defer func() { ForwardQueue = 1000 }()
+	f, err := newForwarder(ForwardConfig{Address: "127.0.0.1:9", Transport: "udp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd.Store(f) // not running, so nothing drains the queue
+	defer fwd.Store(nil)
+
+	for i := 0; i < 3; i++ {
+		forward("TEST", fmt.Sprintf("entry %d", i))
+	}
+	if got := f.dropped.Load(); got != 1 {
+		t.Errorf("dropped = %d, want 1", got)
+	}
+	if first := <-f.queue; !strings.HasSuffix(first, "entry 1") {
+		t.Errorf("Expected the oldest entry to be dropped, queue starts with %q", first)
+	}
+}
+
+func TestNewForwarder_Invalid(t *testing.T) {
+	for _, c := range []ForwardConfig{
+		{Address: "logs.example.com"},
+		{Address: "logs.example.com:514", Transport: "http"},
+		{Address: "logs.example.com:6514", CAFile: "/nonexistent/ca.pem"},
+	} {
+		if _, err := newForwarder(c); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
+
+func TestSyslogToken(t *testing.T) {
+	if got := syslogToken("TASK FAILED\n"); got != "TASK_FAILED_" {
+		t.Errorf("syslogToken = %q", got)
+	}
+	if got := syslogToken(strings.Repeat("X", 40)); len(got) != 32 {
+		t.Errorf("Expected MSGID to be cut to 32 characters, got %d", len(got))
+	}
+}
@@ -11,13 +11,25 @@ import (
 	"time"
 )
 
-const (
+var (
+	// LogFilePath is the audit log vexd and vex-cli append to.
 	LogFilePath = "/var/log/vex-cli.log"
+
+	// AppendOnly has the log set chattr +a.  Off for a log that has to be
+	// removed afterwards, as in the tests.
+	AppendOnly = true
 )
 
+const logPrefix = "[VEX-CLI] "
+
+// Console is where log lines are echoed besides the log file.  vex-cli
+// sets it to os.Stderr before Init so that its stdout carries only
+// command output.
+var Console = os.Stdout
+
 var (
 	logger   *log.Logger
-	logFile  *os.File
+	out      *dualWriter
 	logMu    sync.Mutex
 	initOnce sync.Once
 )
@@ -32,10 +44,9 @@ func Init() error {
 		if err != nil {
 			// If we can't open the system log, fall back to stdout-only
 			log.Printf("Logging: WARNING - Could not open %s: %v (using stdout only)", LogFilePath, err)
-			logger = log.New(os.Stdout, "[VEX-CLI] ", log.LstdFlags)
+			logger = log.New(Console, logPrefix, log.LstdFlags)
 			return
 		}
-		logFile = f
 
 		// Set log file group to 'vex' so non-root group members can
 		// append.  This only works when the daemon (root) creates the file;
@@ -48,12 +59,12 @@ func Init() error {
 		}
 
 		// Create a multi-writer that logs to both stdout and file
-		multiWriter := &dualWriter{stdout: os.Stdout, file: f}
-		logger = log.New(multiWriter, "[VEX-CLI] ", log.LstdFlags)
+		out = &dualWriter{stdout: Console, file: f}
+		logger = log.New(out, logPrefix, log.LstdFlags)
 
 		// Override the default logger
-		log.SetOutput(multiWriter)
-		log.SetPrefix("[VEX-CLI] ")
+		log.SetOutput(out)
+		log.SetPrefix(logPrefix)
 
 		logger.Println("Logging subsystem initialized.")
 	})
@@ -73,6 +84,7 @@ func LogCommand(command string, args string, complianceState string) {
 	} else {
 		log.Println(entry)
 	}
+	forward("CMD", entry)
 }
 
 // LogEvent logs a generic event with context
@@ -87,12 +99,20 @@ func LogEvent(module string, event string, details string) {
 	} else {
 		log.Println(entry)
 	}
+	forward(event, entry)
 }
 
-// Close cleanly closes the log file
+// Close cleanly closes the log file, after giving forwarded entries a
+// moment to reach the remote server.
 func Close() {
-	if logFile != nil {
-		logFile.Close()
+	flushForward(time.Now().Add(2 * time.Second))
+	if out != nil {
+		out.mu.Lock()
+		defer out.mu.Unlock()
+		if out.file != nil {
+			out.file.Close()
+			out.file = nil
+		}
 	}
 }
 
@@ -119,20 +139,32 @@ func setLogGroupToVex(path string) {
 
 // enforceAppendOnly sets the append-only attribute on the log file
 func enforceAppendOnly(path string) error {
-	cmd := exec.Command("chattr", "+a", path)
+	if !AppendOnly {
+		return nil
+	}
+	return chattr("+a", path)
+}
+
+func chattr(flag, path string) error {
+	cmd := exec.Command("chattr", flag, path)
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("chattr +a failed: %w", err)
+		return fmt.Errorf("chattr %s failed: %w", flag, err)
 	}
 	return nil
 }
 
-// dualWriter writes to both stdout and the log file
+// dualWriter writes to both stdout and the log file.  mu guards the file
+// against a concurrent rotation.
 type dualWriter struct {
+	mu     sync.Mutex
 	stdout *os.File
 	file   *os.File
 }
 
 func (w *dualWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
 	// Always write to stdout
 	n, err = w.stdout.Write(p)
 	if err != nil {
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entryTime is the timestamp layout of log.LstdFlags.
+const entryTime = "2006/01/02 15:04:05"
+
+// Entry is one line of the audit log.  Module and Event are set for
+// entries written by LogEvent; other lines only have Text.
+type Entry struct {
+	Time    time.Time
+	Module  string
+	Event   string
+	Details string
+	Text    string // everything after the timestamp
+}
+
+// ReadEntries calls fn for each entry of the log at path written in
+// [from, to), oldest first, including the rotated archives.
+func ReadEntries(path string, from, to time.Time, fn func(Entry)) error {
+	archives, _ := filepath.Glob(path + ".*.gz")
+	sort.Strings(archives)
+	for _, name := range archives {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+		t, err := time.Parse(archiveStamp, stamp)
+		if err != nil || t.Before(from) {
+			continue // not ours, or rotated before the range began
+		}
+		if err := readArchive(name, from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanEntries(f, from, to, fn)
+}
+
+func readArchive(name string, from, to time.Time, fn func(Entry)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return scanEntries(zr, from, to, fn)
+}
+
+func scanEntries(r io.Reader, from, to time.Time, fn func(Entry)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		e, ok := parseEntry(sc.Text())
+		if ok && !e.Time.Before(from) && e.Time.Before(to) {
+			fn(e)
+		}
+	}
+	return sc.Err()
+}
+
+// parseEntry splits "[VEX-CLI] 2006/01/02 15:04:05 [MODULE] EVENT: details".
+func parseEntry(line string) (Entry, bool) {
+	line, ok := strings.CutPrefix(line, logPrefix)
+	if !ok || len(line) < len(entryTime)+1 {
+		return Entry{}, false
+	}
+	t, err := time.ParseInLocation(entryTime, line[:len(entryTime)], time.Local)
+	if err != nil {
+		return Entry{}, false
+	}
+	e := Entry{Time: t, Text: line[len(entryTime)+1:]}
+	if rest, ok := strings.CutPrefix(e.Text, "["); ok {
+		if module, rest, ok := strings.Cut(rest, "] "); ok {
+			if event, details, ok := strings.Cut(rest, ": "); ok && !strings.Contains(event, " ") {
+				e.Module, e.Event, e.Details = module, event, details
+			}
+		}
+	}
+	return e, true
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadEntries_IncludesArchives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.Local)
+	line := func(t time.Time, text string) string {
+		return logPrefix + t.Format(entryTime) + " " + text + "\n"
+	}
+
+	// Rotated two days ago; its entries are older than the archive name.
+	archive := path + "." + now.AddDate(0, 0, -2).UTC().Format(archiveStamp) + ".gz"
+	f, _ := os.Create(archive)
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(line(now.AddDate(0, 0, -10), "[GUARDIAN] KILLED: app=steam pid=1") +
+		line(now.AddDate(0, 0, -3), "[GUARDIAN] KILLED: app=steam pid=2")))
+	zw.Close()
+	f.Close()
+	os.WriteFile(path, []byte(line(now.Add(-time.Hour), "Guardian: Terminating forbidden process PID 3")+
+		line(now.Add(-time.Minute), "[PENANCE] FAILURE: reason=submission_rejected score=20")+
+		"garbage\n"), 0664)
+
+	var got []Entry
+	err := ReadEntries(path, now.AddDate(0, 0, -7), now, func(e Entry) { got = append(got, e) })
+	if err != nil {
+		t.Fatalf("ReadEntries: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 entries in the last week, got %+v", got)
+	}
+	if got[0].Module != "GUARDIAN" || got[0].Event != "KILLED" || got[0].Details != "app=steam pid=2" {
+		t.Errorf("Unexpected archived entry %+v", got[0])
+	}
+	if got[1].Event != "" || got[1].Text != "Guardian: Terminating forbidden process PID 3" {
+		t.Errorf("Expected a plain line without an event, got %+v", got[1])
+	}
+	if got[2].Module != "PENANCE" || got[2].Event != "FAILURE" {
+		t.Errorf("Unexpected entry %+v", got[2])
+	}
+}
@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------
+// Rotation
+// ---------------------------------------------------------------------
+//
+// The active log is append-only, so it cannot be truncated or renamed by
+// an outside tool such as logrotate.  The daemon rotates it instead: the
+// attribute is lifted just long enough to rename the file, a fresh
+// append-only file takes its place, and the old one is gzipped alongside
+// as LogFilePath.<UTC timestamp>.gz.  Archives beyond the retention
+// policy are deleted.
+
+var (
+	// RotationConfigFile overrides DefaultRotation.  Optional.
+	RotationConfigFile = "/etc/vex-cli/logging.json"
+
+	// RotationCheckInterval is how often the daemon checks the active file.
+	RotationCheckInterval = time.Minute
+
+	// DefaultRotation applies when RotationConfigFile does not exist.
+	DefaultRotation = Rotation{MaxSizeMB: 10, MaxAgeHours: 7 * 24, Keep: 10, RetainDays: 90}
+)
+
+// archiveStamp names archives so that they sort chronologically.
+const archiveStamp = "20060102T150405Z"
+
+// Rotation is the contents of RotationConfigFile.  A zero field turns
+// that limit off.
+type Rotation struct {
+	MaxSizeMB   int `json:"max_size_mb"`   // rotate once the active file is this big
+	MaxAgeHours int `json:"max_age_hours"` // rotate once its first entry is this old
+	Keep        int `json:"keep"`          // archives to keep
+	RetainDays  int `json:"retain_days"`   // delete archives older than this
+}
+
+// LoadRotation reads RotationConfigFile.  Fields it omits keep their
+// DefaultRotation value.
+func LoadRotation() (Rotation, error) {
+	r := DefaultRotation
+	data, err := os.ReadFile(RotationConfigFile)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("%s: %w", RotationConfigFile, err)
+	}
+	if r.MaxSizeMB < 0 || r.MaxAgeHours < 0 || r.Keep < 0 || r.RetainDays < 0 {
+		return r, fmt.Errorf("%s: limits must not be negative", RotationConfigFile)
+	}
+	return r, nil
+}
+
+// StartRotation checks the active file now and every
+// RotationCheckInterval, rotating it when it outgrows the policy.  Only
+// the daemon calls this; the CLI simply appends to whichever file is
+// active.
+func StartRotation() error {
+	r, err := LoadRotation()
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil // stdout only
+	}
+	log.Printf("Logging: Rotating at %d MB / %dh, keeping %d archives for %d days",
+		r.MaxSizeMB, r.MaxAgeHours, r.Keep, r.RetainDays)
+	go func() {
+		for {
+			rotateIfDue(LogFilePath, r)
+			time.Sleep(RotationCheckInterval)
+		}
+	}()
+	return nil
+}
+
+func rotateIfDue(path string, r Rotation) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	var reason string
+	switch {
+	case r.MaxSizeMB > 0 && info.Size() >= int64(r.MaxSizeMB)<<20:
+		reason = fmt.Sprintf("size=%d", info.Size())
+	case r.MaxAgeHours > 0 && fileAge(path) >= time.Duration(r.MaxAgeHours)*time.Hour:
+		reason = fmt.Sprintf("age=%s", fileAge(path).Round(time.Hour))
+	}
+	if reason != "" {
+		archive, attrErr, err := out.rotate(path)
+		if err != nil {
+			log.Printf("Logging: WARNING - Rotation failed: %v", err)
+			return
+		}
+		if attrErr != nil {
+			log.Printf("Logging: WARNING - Could not set chattr +a on the new log: %v", attrErr)
+		}
+		LogEvent("LOGGING", "ROTATED", fmt.Sprintf("archive=%s.gz %s", filepath.Base(archive), reason))
+		if err := compress(archive); err != nil {
+			log.Printf("Logging: WARNING - Could not compress %s: %v", archive, err)
+		}
+	}
+	for _, name := range prune(path, r, time.Now()) {
+		LogEvent("LOGGING", "ARCHIVE_DELETED", filepath.Base(name))
+	}
+}
+
+// rotate renames the active file to a timestamped archive and opens a
+// fresh one in its place.  Writers are held off meanwhile, so no entry
+// lands between the two files.  attrErr reports a failure to make the
+// new file append-only; the rotation itself still succeeded.
+func (w *dualWriter) rotate(path string) (archive string, attrErr, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	archive = path + "." + time.Now().UTC().Format(archiveStamp)
+	clearAppendOnly(path) // without chattr the rename below simply works
+	if err := os.Rename(path, archive); err != nil {
+		enforceAppendOnly(path)
+		return "", nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		// Keep writing to the renamed file rather than lose entries.
+		return "", nil, err
+	}
+	setLogGroupToVex(path)
+	attrErr = enforceAppendOnly(path)
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = f
+	return archive, attrErr, nil
+}
+
+// compress gzips an archive and removes the uncompressed copy.
+func compress(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// prune deletes archives beyond r.Keep or older than r.RetainDays and
+// returns their names.
+func prune(path string, r Rotation, now time.Time) []string {
+	archives, _ := filepath.Glob(path + ".*.gz")
+	sort.Sort(sort.Reverse(sort.StringSlice(archives))) // newest first
+
+	var removed []string
+	kept := 0
+	for _, name := range archives {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, path+"."), ".gz")
+		t, err := time.Parse(archiveStamp, stamp)
+		if err != nil {
+			continue // not ours
+		}
+		tooMany := r.Keep > 0 && kept >= r.Keep
+		tooOld := r.RetainDays > 0 && now.Sub(t) > time.Duration(r.RetainDays)*24*time.Hour
+		if !tooMany && !tooOld {
+			kept++
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			log.Printf("Logging: WARNING - Could not delete %s: %v", name, err)
+			continue
+		}
+		removed = append(removed, name)
+	}
+	return removed
+}
+
+// fileAge is how long ago the first entry of the file was written, or 0
+// if that cannot be told.
+func fileAge(path string) time.Duration {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	buf := make([]byte, 64)
+	n, _ := io.ReadFull(f, buf)
+	line, ok := strings.CutPrefix(string(buf[:n]), logPrefix)
+	if !ok || len(line) < len(entryTime) {
+		return 0
+	}
+	t, err := time.ParseInLocation(entryTime, line[:len(entryTime)], time.Local)
+	if err != nil {
+		return 0
+	}
+	return time.Since(t)
+}
+
+// clearAppendOnly lifts chattr +a so that the file can be renamed.
+func clearAppendOnly(path string) error {
+	return chattr("-a", path)
+}
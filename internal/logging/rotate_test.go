@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotate_ArchivesAndCompresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clearAppendOnly(path) }) // rotate sets it where chattr works
+	devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	w := &dualWriter{stdout: devNull, file: f}
+	w.Write([]byte("before\n"))
+
+	archive, _, err := w.rotate(path)
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	w.Write([]byte("after\n"))
+	w.file.Close()
+
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("Active file = %q, want only the entry written after rotation", data)
+	}
+	if err := compress(archive); err != nil {
+		t.Fatalf("compress: %v", err)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Error("Expected the uncompressed archive to be removed")
+	}
+	gz, err := os.Open(archive + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "before\n" {
+		t.Errorf("Archive = %q, want the entry written before rotation", data)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, days := range []int{1, 2, 3, 40} {
+		name := path + "." + now.AddDate(0, 0, -days).Format(archiveStamp) + ".gz"
+		os.WriteFile(name, nil, 0640)
+	}
+	os.WriteFile(path+".old.gz", nil, 0640) // not an archive of ours
+
+	removed := prune(path, Rotation{Keep: 2, RetainDays: 30}, now)
+	if len(removed) != 2 {
+		t.Fatalf("Expected the 3- and 40-day-old archives to go, removed %v", removed)
+	}
+	left, _ := filepath.Glob(path + ".*.gz")
+	if len(left) != 3 {
+		t.Errorf("Expected 2 archives and the foreign file to remain, got %v", left)
+	}
+}
+
+func TestFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	first := time.Now().Add(-50 * time.Hour).Format("2006/01/02 15:04:05")
+	os.WriteFile(path, []byte(logPrefix+first+" Logging subsystem initialized.\n"), 0664)
+
+	if age := fileAge(path); age < 49*time.Hour || age > 51*time.Hour {
+		t.Errorf("fileAge = %s, want about 50h", age)
+	}
+	os.WriteFile(path, []byte("garbage\n"), 0664)
+	if age := fileAge(path); age != 0 {
+		t.Errorf("fileAge of an unrecognised file = %s, want 0", age)
+	}
+}
@@ -0,0 +1,264 @@
+// Package matrix connects vexd to a Matrix room.
+//
+// Like the Discord bot, it posts keyholder events to the room and runs the
+// chat commands in package chatops that permitted users send there.  It
+// speaks the client-server API directly and does no end-to-end encryption
+// itself: for an encrypted room, point homeserver at an E2EE proxy such as
+// pantalaimon, which encrypts and decrypts transparently.
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+var (
+	// ConfigFile holds the homeserver, access token and permissions.
+	ConfigFile = "/etc/vex-cli/matrix.json"
+
+	// SyncTimeout is how long a sync request waits for new events.
+	SyncTimeout = 30 * time.Second
+
+	// retryDelay is the pause after a failed sync.
+	retryDelay = 10 * time.Second
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Homeserver  string              `json:"homeserver"` // or the E2EE proxy in front of it
+	AccessToken string              `json:"access_token"`
+	RoomID      string              `json:"room_id"`
+	Prefix      string              `json:"prefix,omitempty"` // default "!vex"
+	Events      []string            `json:"events,omitempty"` // as in notify.json; empty sends all
+	Users       chatops.Permissions `json:"users"`            // user ID -> commands
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// bridge is not configured and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks that the required fields are set and that every user
+// maps to known commands.
+func (c *Config) Validate() error {
+	if c.Homeserver == "" || c.AccessToken == "" || c.RoomID == "" {
+		return fmt.Errorf("homeserver, access_token and room_id are required")
+	}
+	if u, err := url.Parse(c.Homeserver); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("homeserver must be an http:// or https:// URL")
+	}
+	if err := notify.ValidateFilter(c.Events); err != nil {
+		return err
+	}
+	return c.Users.Validate()
+}
+
+// Bot is a running Matrix connection.
+type Bot struct {
+	cfg    *Config
+	bridge *chatops.Bridge
+	client *http.Client
+	userID string // our own ID, whose messages are ignored
+	since  string // sync token
+	txn    atomic.Int64
+
+	warnedEncrypted bool
+}
+
+type event struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id"`
+	Sender  string `json:"sender"`
+	Content struct {
+		MsgType string `json:"msgtype"`
+		Body    string `json:"body"`
+	} `json:"content"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// Start registers the bot as a notification sink and starts syncing the
+// room.  Messages sent before the bot started are skipped, so old
+// commands are never replayed after a restart.
+func Start(c *Config, dispatch chatops.Dispatcher) (*Bot, error) {
+	b := newBot(c, dispatch)
+	var who struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.do(http.MethodGet, "/account/whoami", nil, &who); err != nil {
+		return nil, err
+	}
+	b.userID = who.UserID
+	if _, err := b.sync(0); err != nil {
+		return nil, err
+	}
+
+	notify.AddSink(notify.Sink{Name: "matrix", Events: c.Events, Send: func(m notify.Message) error {
+		return b.send(m.String(), "")
+	}})
+	supervisor.Go("matrix", func() error {
+		for {
+			if err := b.Poll(); err != nil {
+				log.Printf("Matrix: sync failed: %v", err)
+				time.Sleep(retryDelay)
+			}
+		}
+	})
+	log.Printf("Matrix: Bot %s watching room %s", b.userID, c.RoomID)
+	return b, nil
+}
+
+func newBot(c *Config, dispatch chatops.Dispatcher) *Bot {
+	b := &Bot{
+		cfg:    c,
+		bridge: chatops.NewBridge(c.Prefix, dispatch),
+		client: &http.Client{Timeout: SyncTimeout + 30*time.Second},
+	}
+	b.txn.Store(time.Now().UnixNano())
+	return b
+}
+
+// Poll waits for new events in the room and runs the commands among them.
+func (b *Bot) Poll() error {
+	events, err := b.sync(SyncTimeout)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Sender == b.userID {
+			continue
+		}
+		if e.Type == "m.room.encrypted" {
+			if !b.warnedEncrypted {
+				log.Printf("Matrix: WARNING - %s is encrypted; commands can only be read through an E2EE proxy", b.cfg.RoomID)
+				b.warnedEncrypted = true
+			}
+			continue
+		}
+		if e.Type != "m.room.message" || e.Content.MsgType != "m.text" {
+			continue
+		}
+		reply, ok := b.bridge.Handle(e.Content.Body, b.cfg.Users.Allowed(e.Sender))
+		if !ok {
+			continue
+		}
+		vexlog.LogEvent("MATRIX", "COMMAND", fmt.Sprintf("user=%s text=%q", e.Sender, e.Content.Body))
+		if err := b.send(reply, e.EventID); err != nil {
+			log.Printf("Matrix: failed to reply: %v", err)
+		}
+	}
+	return nil
+}
+
+// sync returns the room's new timeline events.  The first call only
+// records where the timeline ends.
+func (b *Bot) sync(timeout time.Duration) ([]event, error) {
+	filter := fmt.Sprintf(`{"presence":{"types":[]},"room":{"rooms":[%q],"timeline":{"limit":50}}}`, b.cfg.RoomID)
+	q := url.Values{"timeout": {strconv.FormatInt(timeout.Milliseconds(), 10)}, "filter": {filter}}
+	if b.since != "" {
+		q.Set("since", b.since)
+	}
+	var resp syncResponse
+	if err := b.do(http.MethodGet, "/sync?"+q.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	first := b.since == ""
+	b.since = resp.NextBatch
+	if first {
+		return nil, nil
+	}
+	return resp.Rooms.Join[b.cfg.RoomID].Timeline.Events, nil
+}
+
+// send posts text to the room as a notice, in reply to replyTo if set.
+// Notices are never answered by other bots.
+func (b *Bot) send(text, replyTo string) error {
+	body := map[string]any{"msgtype": "m.notice", "body": text}
+	if replyTo != "" {
+		body["m.relates_to"] = map[string]any{"m.in_reply_to": map[string]string{"event_id": replyTo}}
+	}
+	txn := strconv.FormatInt(b.txn.Add(1), 10)
+	return b.do(http.MethodPut, "/rooms/"+url.PathEscape(b.cfg.RoomID)+"/send/m.room.message/"+txn, body, nil)
+}
+
+// do makes a client-server API call, waiting out one rate limit if the
+// homeserver asks.
+func (b *Bot) do(method, path string, in, out any) error {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, b.cfg.Homeserver+"/_matrix/client/v3"+path, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+b.cfg.AccessToken)
+		if in != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := b.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt == 0 {
+			var rl struct {
+				RetryAfterMs int64 `json:"retry_after_ms"`
+			}
+			json.Unmarshal(data, &rl)
+			time.Sleep(time.Duration(rl.RetryAfterMs) * time.Millisecond)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s %s: %s: %.200s", method, path, resp.Status, data)
+		}
+		if out != nil {
+			return json.Unmarshal(data, out)
+		}
+		return nil
+	}
+}
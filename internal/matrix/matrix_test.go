@@ -0,0 +1,123 @@
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/chatops"
+	"github.com/adumbdinosaur/vex-cli/internal/ipc"
+)
+
+// fakeHomeserver returns the history on the first sync and the new
+// events on the next, and records what the bot sends.
+type fakeHomeserver struct {
+	mu      sync.Mutex
+	history []map[string]any
+	events  []map[string]any
+	sent    []map[string]any
+	syncs   int
+}
+
+func (f *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/_matrix/client/v3/account/whoami":
+		json.NewEncoder(w).Encode(map[string]string{"user_id": "@vex:example.org"})
+	case r.URL.Path == "/_matrix/client/v3/sync":
+		evs := f.history
+		if r.URL.Query().Get("since") != "" {
+			evs = f.events
+		}
+		f.syncs++
+		json.NewEncoder(w).Encode(map[string]any{
+			"next_batch": "s" + string(rune('0'+f.syncs)),
+			"rooms": map[string]any{"join": map[string]any{
+				"!room:example.org": map[string]any{"timeline": map[string]any{"events": evs}},
+			}},
+		})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/"):
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		f.sent = append(f.sent, body)
+		w.Write([]byte(`{"event_id":"$reply"}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func text(id, sender, body string) map[string]any {
+	return map[string]any{"type": "m.room.message", "event_id": id, "sender": sender,
+		"content": map[string]any{"msgtype": "m.text", "body": body}}
+}
+
+func TestPoll_RunsPermittedCommands(t *testing.T) {
+	fake := &fakeHomeserver{
+		history: []map[string]any{text("$old", "@kh:example.org", "!vex cpu 100")},
+		events: []map[string]any{
+			text("$1", "@kh:example.org", "!vex throttle dial-up"),
+			text("$2", "@friend:example.org", "!vex cpu 100"),
+			text("$3", "@vex:example.org", "!vex status"),
+			{"type": "m.room.encrypted", "event_id": "$4", "sender": "@kh:example.org"},
+		},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	var got []string
+	c := &Config{Homeserver: srv.URL, AccessToken: "secret", RoomID: "!room:example.org",
+		Users: chatops.Permissions{"@kh:example.org": {"*"}}}
+	b := newBot(c, func(req *ipc.Request) *ipc.Response {
+		got = append(got, req.Command)
+		return &ipc.Response{OK: true, Message: "ok"}
+	})
+	b.userID = "@vex:example.org"
+
+	if _, err := b.sync(0); err != nil {
+		t.Fatalf("initial sync: %v", err)
+	}
+	if err := b.Poll(); err != nil {
+		t.Fatalf("Poll: %v", err)
+	}
+	if len(got) != 1 || got[0] != ipc.CmdThrottle {
+		t.Errorf("Expected only the keyholder's new throttle to run, got %v", got)
+	}
+	if len(fake.sent) != 2 {
+		t.Fatalf("Expected replies to the two commands, got %v", fake.sent)
+	}
+	rel, _ := fake.sent[0]["m.relates_to"].(map[string]any)
+	reply, _ := rel["m.in_reply_to"].(map[string]any)
+	if fake.sent[0]["body"] != "ok" || fake.sent[0]["msgtype"] != "m.notice" || reply["event_id"] != "$1" {
+		t.Errorf("Unexpected reply %v", fake.sent[0])
+	}
+	if body, _ := fake.sent[1]["body"].(string); !strings.Contains(body, "not permitted") {
+		t.Errorf("Expected the friend to be refused, got %v", fake.sent[1])
+	}
+	if !b.warnedEncrypted {
+		t.Error("Expected a warning about the encrypted event")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	ok := Config{Homeserver: "http://localhost:8009", AccessToken: "t", RoomID: "!r:x", Users: chatops.Permissions{"@kh:x": {"*"}}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	for _, c := range []Config{
+		{AccessToken: "t", RoomID: "!r:x"},
+		{Homeserver: "matrix.org", AccessToken: "t", RoomID: "!r:x"},
+		{Homeserver: "https://matrix.org", AccessToken: "t", RoomID: "!r:x", Users: chatops.Permissions{"@kh:x": {"shutdown"}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", c)
+		}
+	}
+}
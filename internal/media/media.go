@@ -0,0 +1,158 @@
+// Package media caps the display brightness, through the sysfs backlight
+// class, and the audio output, through the ALSA mixer, as a penalty of
+// their own.
+//
+// PipeWire and PulseAudio drive the hardware mixer control for their
+// own volume, so capping the ALSA control caps them too; Module puts the
+// caps back every Interval when the subject raises either again.
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -- Interfaces for Testing --
+
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type RealCommandRunner struct{}
+
+func (r *RealCommandRunner) Run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+var cmdRunner CommandRunner = &RealCommandRunner{}
+
+var (
+	// BacklightDir is the sysfs backlight class.
+	BacklightDir = "/sys/class/backlight"
+
+	// Control is the ALSA simple mixer control that is capped.
+	Control = "Master"
+
+	// Timeout bounds a single amixer call.
+	Timeout = 10 * time.Second
+)
+
+// Backlight is one panel's backlight.
+type Backlight struct {
+	Name       string
+	Brightness int
+	Max        int
+}
+
+// Backlights reads every panel under BacklightDir.  A machine without a
+// backlight has none.
+func Backlights() ([]Backlight, error) {
+	entries, err := os.ReadDir(BacklightDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Backlight
+	for _, e := range entries {
+		b := Backlight{Name: e.Name()}
+		if b.Max, err = readInt(filepath.Join(BacklightDir, b.Name, "max_brightness")); err != nil {
+			return nil, err
+		}
+		if b.Brightness, err = readInt(filepath.Join(BacklightDir, b.Name, "brightness")); err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return list, nil
+}
+
+// BacklightPaths returns the sysfs directory each panel's entry in
+// BacklightDir links to, for the sandbox to leave writable.
+func BacklightPaths() []string {
+	entries, _ := os.ReadDir(BacklightDir)
+	var paths []string
+	for _, e := range entries {
+		if p, err := filepath.EvalSymlinks(filepath.Join(BacklightDir, e.Name())); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Cap is the highest brightness allowed at pct percent of the panel's
+// maximum, never fully dark.
+func (b Backlight) Cap(pct int) int {
+	return max(b.Max*pct/100, 1)
+}
+
+// SetBrightness writes the raw brightness of the named panel.
+func SetBrightness(name string, v int) error {
+	return os.WriteFile(filepath.Join(BacklightDir, name, "brightness"), []byte(strconv.Itoa(v)), 0o644)
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// levelRe finds a channel's "[NN%]" in amixer output; switchRe its
+// "[on]" or "[off]".
+var (
+	levelRe  = regexp.MustCompile(`\[(\d+)%\]`)
+	switchRe = regexp.MustCompile(`\[(on|off)\]`)
+)
+
+// Volume reads Control: the loudest channel's level and whether any
+// channel is switched on.
+func Volume() (pct int, on bool, err error) {
+	out, err := cmdRunner.Run("amixer", "get", Control)
+	if err != nil {
+		return 0, false, fmt.Errorf("amixer get %s: %w: %s", Control, err, strings.TrimSpace(string(out)))
+	}
+	levels := levelRe.FindAllStringSubmatch(string(out), -1)
+	if len(levels) == 0 {
+		return 0, false, fmt.Errorf("amixer get %s: no playback level", Control)
+	}
+	for _, l := range levels {
+		v, _ := strconv.Atoi(l[1])
+		pct = max(pct, v)
+	}
+	for _, s := range switchRe.FindAllStringSubmatch(string(out), -1) {
+		on = on || s[1] == "on"
+	}
+	return pct, on, nil
+}
+
+// SetVolume sets every channel of Control to pct percent.
+func SetVolume(pct int) error {
+	return amixer("sset", Control, strconv.Itoa(pct)+"%")
+}
+
+// SetMute switches Control off, or back on.
+func SetMute(mute bool) error {
+	if mute {
+		return amixer("sset", Control, "mute")
+	}
+	return amixer("sset", Control, "unmute")
+}
+
+func amixer(args ...string) error {
+	if out, err := cmdRunner.Run("amixer", append([]string{"-q"}, args...)...); err != nil {
+		return fmt.Errorf("amixer %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
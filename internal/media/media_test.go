@@ -0,0 +1,103 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// fakeMixer answers amixer calls from a level and a switch it keeps.
+type fakeMixer struct {
+	level int
+	on    bool
+}
+
+func (f *fakeMixer) Run(name string, args ...string) ([]byte, error) {
+	switch strings.Join(args, " ") {
+	case "get Master":
+		sw := "off"
+		if f.on {
+			sw = "on"
+		}
+		return fmt.Appendf(nil, "Simple mixer control 'Master',0\n  Front Left: Playback 40 [%d%%] [-10.00dB] [%s]\n  Front Right: Playback 40 [%d%%] [-10.00dB] [%s]\n", f.level, sw, f.level, sw), nil
+	case "-q sset Master mute":
+		f.on = false
+	case "-q sset Master unmute":
+		f.on = true
+	default:
+		if _, err := fmt.Sscanf(strings.Join(args, " "), "-q sset Master %d%%", &f.level); err != nil {
+			return nil, fmt.Errorf("unexpected amixer %v", args)
+		}
+	}
+	return nil, nil
+}
+
+func setup(t *testing.T, brightness, max int, mixer *fakeMixer) string {
+	BacklightDir = t.TempDir()
+	panel := filepath.Join(BacklightDir, "intel_backlight")
+	os.Mkdir(panel, 0o755)
+	os.WriteFile(filepath.Join(panel, "max_brightness"), fmt.Appendf(nil, "%d\n", max), 0o644)
+	os.WriteFile(filepath.Join(panel, "brightness"), fmt.Appendf(nil, "%d\n", brightness), 0o644)
+	old := cmdRunner
+	cmdRunner = mixer
+	t.Cleanup(func() {
+		cmdRunner = old
+		BacklightDir = "/sys/class/backlight"
+	})
+	return filepath.Join(panel, "brightness")
+}
+
+func TestVolume(t *testing.T) {
+	setup(t, 0, 100, &fakeMixer{level: 65, on: true})
+	pct, on, err := Volume()
+	if err != nil || pct != 65 || !on {
+		t.Errorf("Volume = %d, %v, %v; want 65, true", pct, on, err)
+	}
+}
+
+func TestCapAndRestore(t *testing.T) {
+	mixer := &fakeMixer{level: 80, on: true}
+	file := setup(t, 900, 1000, mixer)
+	m := NewModule()
+
+	st := &state.SystemState{Media: state.MediaState{BrightnessPct: 30, VolumePct: 20, Muted: true}}
+	if err := m.Apply(st); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if v, _ := readInt(file); v != 300 {
+		t.Errorf("Brightness %d, want 300", v)
+	}
+	if mixer.level != 20 || mixer.on {
+		t.Errorf("Mixer at %d%% on=%v, want 20%% muted", mixer.level, mixer.on)
+	}
+	if err := m.Verify(st); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// Turning the brightness back up is caught on the next pass.
+	os.WriteFile(file, []byte("1000"), 0o644)
+	if err := m.Verify(st); err == nil {
+		t.Error("Expected Verify to report the raised brightness")
+	}
+	m.mu.Lock()
+	m.enforce()
+	m.mu.Unlock()
+	if v, _ := readInt(file); v != 300 {
+		t.Errorf("Brightness %d after re-enforcing, want 300", v)
+	}
+
+	// Lifting the caps, as an unlock or a pause does, restores the levels.
+	if err := m.Apply(&state.SystemState{Media: st.Media, Pause: &state.PauseState{}}); err != nil {
+		t.Fatalf("Apply while paused: %v", err)
+	}
+	if v, _ := readInt(file); v != 900 {
+		t.Errorf("Brightness %d after lifting, want 900", v)
+	}
+	if mixer.level != 80 || !mixer.on {
+		t.Errorf("Mixer at %d%% on=%v after lifting, want 80%% on", mixer.level, mixer.on)
+	}
+}
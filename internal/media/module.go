@@ -0,0 +1,219 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// Interval is how often Module puts back caps the subject got round.
+var Interval = 5 * time.Second
+
+// Module enforces state.MediaState as an enforcement module (see package
+// modules).  It remembers the brightness and volume it lowered and
+// whether it muted, and puts them back when the caps are lifted.
+type Module struct {
+	mu    sync.Mutex
+	caps  state.MediaState
+	saved map[string]int // panel brightness before the cap, by panel
+	// volume is the level before the cap, or -1; muted is whether the
+	// module switched the output off.
+	volume  int
+	muted   bool
+	lastErr string
+}
+
+// NewModule returns a Module that enforces nothing yet.
+func NewModule() *Module {
+	return &Module{saved: make(map[string]int), volume: -1}
+}
+
+func (m *Module) Name() string { return subsystem.Media }
+
+// Init starts the worker that keeps the caps in place.
+func (m *Module) Init() error {
+	supervisor.Go("media.watch", m.watch)
+	return nil
+}
+
+// Apply enforces st.Media, or nothing while enforcement is paused.
+func (m *Module) Apply(st *state.SystemState) error {
+	caps := st.Media
+	if st.Pause != nil {
+		caps = state.MediaState{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if caps.Capped() && subsystem.Skip(subsystem.Media, "cap brightness at %d%%, volume at %d%%, mute %v", caps.BrightnessPct, caps.VolumePct, caps.Muted) {
+		return nil
+	}
+	m.caps = caps
+	return m.enforce()
+}
+
+// Verify reports a panel or the volume above its cap, or the output on
+// while it should be muted.
+func (m *Module) Verify(st *state.SystemState) error {
+	if st.Pause != nil || !st.Media.Capped() {
+		return nil
+	}
+	c := st.Media
+	var errs []error
+	if capped(c.BrightnessPct) {
+		list, err := Backlights()
+		if err != nil {
+			return err
+		}
+		for _, b := range list {
+			if b.Brightness > b.Cap(c.BrightnessPct) {
+				errs = append(errs, fmt.Errorf("%s brightness %d/%d above %d%%", b.Name, b.Brightness, b.Max, c.BrightnessPct))
+			}
+		}
+	}
+	if capped(c.VolumePct) || c.Muted {
+		pct, on, err := Volume()
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if capped(c.VolumePct) && pct > c.VolumePct {
+			errs = append(errs, fmt.Errorf("volume %d%% above %d%%", pct, c.VolumePct))
+		}
+		if c.Muted && on {
+			errs = append(errs, errors.New("output not muted"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown lifts the caps.
+func (m *Module) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caps = state.MediaState{}
+	return m.enforce()
+}
+
+// watch enforces the caps every Interval, logging an error only when it
+// differs from the last one.
+func (m *Module) watch() error {
+	for range time.Tick(Interval) {
+		if subsystem.Get(subsystem.Media) != subsystem.Enforce {
+			continue
+		}
+		m.mu.Lock()
+		err := m.enforce()
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+			if msg != m.lastErr {
+				log.Printf("Media: %v", err)
+			}
+		}
+		m.lastErr = msg
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// capped reports whether pct restricts its setting.
+func capped(pct int) bool {
+	return pct > 0 && pct < 100
+}
+
+// enforce brings the backlight and volume within m.caps and restores
+// what a lifted cap had lowered.  The caller holds m.mu.
+func (m *Module) enforce() error {
+	var errs []error
+	if capped(m.caps.BrightnessPct) || len(m.saved) > 0 {
+		errs = append(errs, m.enforceBacklight())
+	}
+	if capped(m.caps.VolumePct) || m.caps.Muted || m.volume >= 0 || m.muted {
+		errs = append(errs, m.enforceVolume())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Module) enforceBacklight() error {
+	list, err := Backlights()
+	if err != nil {
+		return err
+	}
+	pct := m.caps.BrightnessPct
+	var errs []error
+	for _, b := range list {
+		if !capped(pct) {
+			if v, ok := m.saved[b.Name]; ok {
+				if err := SetBrightness(b.Name, v); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				delete(m.saved, b.Name)
+				vexlog.LogEvent("MEDIA", "RESTORED", fmt.Sprintf("target=backlight:%s level=%d", b.Name, v))
+			}
+			continue
+		}
+		limit := b.Cap(pct)
+		if b.Brightness <= limit {
+			continue
+		}
+		if err := SetBrightness(b.Name, limit); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, ok := m.saved[b.Name]; !ok {
+			m.saved[b.Name] = b.Brightness
+		}
+		vexlog.LogEvent("MEDIA", "CAPPED", fmt.Sprintf("target=backlight:%s from=%d to=%d", b.Name, b.Brightness, limit))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Module) enforceVolume() error {
+	pct, on, err := Volume()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	switch {
+	case capped(m.caps.VolumePct) && pct > m.caps.VolumePct:
+		if err := SetVolume(m.caps.VolumePct); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if m.volume < 0 {
+			m.volume = pct
+		}
+		vexlog.LogEvent("MEDIA", "CAPPED", fmt.Sprintf("target=volume from=%d%% to=%d%%", pct, m.caps.VolumePct))
+	case !capped(m.caps.VolumePct) && m.volume >= 0:
+		if err := SetVolume(m.volume); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		vexlog.LogEvent("MEDIA", "RESTORED", fmt.Sprintf("target=volume level=%d%%", m.volume))
+		m.volume = -1
+	}
+	switch {
+	case m.caps.Muted && on:
+		if err := SetMute(true); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		m.muted = true
+		vexlog.LogEvent("MEDIA", "CAPPED", "target=volume muted=true")
+	case !m.caps.Muted && m.muted:
+		if err := SetMute(false); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		m.muted = false
+		vexlog.LogEvent("MEDIA", "RESTORED", "target=volume muted=false")
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,241 @@
+// Package messages shows the subject taunting or encouraging messages
+// when things happen: a forbidden app is killed, a line is rejected, a
+// streak without failures reaches a milestone.
+//
+// messages.json maps bus events to text templates.  When an event is
+// published, the first rule that matches it picks one of its templates at
+// random, fills in the event's details and shows the result through the
+// rule's outputs: a desktop notification, a wall broadcast to every
+// terminal, or the message of the day shown at the next login.
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+)
+
+var (
+	// ConfigFile holds the rules.  Optional.
+	ConfigFile = "/etc/vex-cli/messages.json"
+
+	// MOTDFile is the motd fragment replaced by the motd output.  pam_motd
+	// shows the files in /run/motd.d at login.
+	MOTDFile = "/run/motd.d/vex-cli"
+
+	// Timeout bounds a single wall broadcast.
+	Timeout = 10 * time.Second
+)
+
+// Outputs.
+const (
+	OutputDesktop = "desktop" // a desktop notification in the subject's session
+	OutputWall    = "wall"    // a broadcast to every terminal
+	OutputMOTD    = "motd"    // the message of the day at the next login
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Outputs  []string `json:"outputs,omitempty"` // for rules without their own; default desktop
+	Messages []Rule   `json:"messages"`
+}
+
+// Rule turns one kind of event into a message.
+type Rule struct {
+	Event           string            `json:"event"`           // event name, a glob as in notify.json
+	When            map[string]string `json:"when,omitempty"`  // details that must be equal, e.g. {"days": "30"}
+	Templates       []string          `json:"templates"`       // text/template over the details; one is picked at random
+	Title           string            `json:"title,omitempty"` // desktop notification title, default "vex-cli"
+	Outputs         []string          `json:"outputs,omitempty"`
+	CooldownSeconds int               `json:"cooldown_seconds,omitempty"` // minimum gap between this rule's messages
+
+	parsed []*template.Template
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means no
+// messages are shown and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the outputs and parses the templates.
+func (c *Config) Validate() error {
+	if err := validateOutputs(c.Outputs); err != nil {
+		return err
+	}
+	for i := range c.Messages {
+		r := &c.Messages[i]
+		if r.Event == "" {
+			return fmt.Errorf("message %d: event is required", i+1)
+		}
+		if _, err := path.Match(r.Event, ""); err != nil {
+			return fmt.Errorf("message %d: event %q: %w", i+1, r.Event, err)
+		}
+		if len(r.Templates) == 0 {
+			return fmt.Errorf("message %d (%s): at least one template is required", i+1, r.Event)
+		}
+		if r.CooldownSeconds < 0 {
+			return fmt.Errorf("message %d (%s): cooldown_seconds must not be negative", i+1, r.Event)
+		}
+		if err := validateOutputs(r.Outputs); err != nil {
+			return fmt.Errorf("message %d (%s): %w", i+1, r.Event, err)
+		}
+		r.parsed = nil
+		for _, text := range r.Templates {
+			t, err := template.New(r.Event).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return fmt.Errorf("message %d (%s): %w", i+1, r.Event, err)
+			}
+			r.parsed = append(r.parsed, t)
+		}
+	}
+	return nil
+}
+
+func validateOutputs(outputs []string) error {
+	for _, o := range outputs {
+		switch o {
+		case OutputDesktop, OutputWall, OutputMOTD:
+		default:
+			return fmt.Errorf("unknown output %q (use desktop, wall or motd)", o)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to e.
+func (r *Rule) matches(e events.Event) bool {
+	if ok, _ := path.Match(r.Event, e.Name()); !ok {
+		return false
+	}
+	d := e.Details()
+	for k, v := range r.When {
+		if d[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// engine delivers the messages of one configuration.
+type engine struct {
+	cfg  *Config
+	mu   sync.Mutex
+	last map[int]time.Time // when each rule last produced a message
+}
+
+// deliver and pick are replaced in tests.
+var (
+	deliver = deliverTo
+	pick    = rand.IntN
+)
+
+// Start shows messages for events published from now on.
+func Start(c *Config) {
+	e := &engine{cfg: c, last: make(map[int]time.Time)}
+	events.Subscribe(e.handle)
+	log.Printf("Messages: %d rules loaded", len(c.Messages))
+}
+
+// handle renders the message for ev, if any, and delivers it in the
+// background so the publisher is not held up.
+func (e *engine) handle(ev events.Event) {
+	title, text, outputs, ok := e.render(ev, time.Now())
+	if !ok {
+		return
+	}
+	for _, o := range outputs {
+		go func() {
+			if err := deliver(o, title, text); err != nil {
+				log.Printf("Messages: %s: %v", o, err)
+			}
+		}()
+	}
+}
+
+// render picks the message for ev at now.  Only the first matching rule
+// is considered, so specific rules go before general ones.
+func (e *engine) render(ev events.Event, now time.Time) (title, text string, outputs []string, ok bool) {
+	for i := range e.cfg.Messages {
+		r := &e.cfg.Messages[i]
+		if !r.matches(ev) {
+			continue
+		}
+		e.mu.Lock()
+		if last, seen := e.last[i]; seen && now.Sub(last) < time.Duration(r.CooldownSeconds)*time.Second {
+			e.mu.Unlock()
+			return "", "", nil, false
+		}
+		e.last[i] = now
+		e.mu.Unlock()
+
+		var b strings.Builder
+		if err := r.parsed[pick(len(r.parsed))].Execute(&b, ev.Details()); err != nil {
+			log.Printf("Messages: %s: %v", r.Event, err)
+			return "", "", nil, false
+		}
+		title = r.Title
+		if title == "" {
+			title = "vex-cli"
+		}
+		outputs = r.Outputs
+		if len(outputs) == 0 {
+			outputs = e.cfg.Outputs
+		}
+		if len(outputs) == 0 {
+			outputs = []string{OutputDesktop}
+		}
+		return title, strings.TrimSpace(b.String()), outputs, true
+	}
+	return "", "", nil, false
+}
+
+// deliverTo shows text through one output.
+func deliverTo(output, title, text string) error {
+	switch output {
+	case OutputDesktop:
+		return desktop.Show(title, text, false)
+	case OutputWall:
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "wall")
+		cmd.Stdin = strings.NewReader(text + "\n")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("wall: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	case OutputMOTD:
+		if err := os.MkdirAll(filepath.Dir(MOTDFile), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(MOTDFile, []byte(text+"\n"), 0644)
+	}
+	return fmt.Errorf("unknown output %q", output)
+}
@@ -0,0 +1,83 @@
+package messages
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		c  Config
+		ok bool
+	}{
+		{Config{Messages: []Rule{{Event: "kill", Templates: []string{"{{.app}} again?"}}}}, true},
+		{Config{Outputs: []string{"wall", "motd"}, Messages: []Rule{{Event: "check*", Templates: []string{"x"}, Outputs: []string{"desktop"}}}}, true},
+		{Config{Messages: []Rule{{Templates: []string{"x"}}}}, false},
+		{Config{Messages: []Rule{{Event: "kill"}}}, false},
+		{Config{Messages: []Rule{{Event: "kill", Templates: []string{"{{.app"}}}}, false},
+		{Config{Messages: []Rule{{Event: "kill", Templates: []string{"x"}, Outputs: []string{"email"}}}}, false},
+		{Config{Outputs: []string{"sms"}}, false},
+		{Config{Messages: []Rule{{Event: "[", Templates: []string{"x"}}}}, false},
+	} {
+		if err := tc.c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result %v", tc.c, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	c := &Config{
+		Outputs: []string{"motd"},
+		Messages: []Rule{
+			{Event: "streak", When: map[string]string{"days": "30"}, Templates: []string{"A month clean."}, Outputs: []string{"wall", "desktop"}},
+			{Event: "streak", Templates: []string{"{{.days}} days without a failure."}, Title: "Well done"},
+			{Event: "kill", Templates: []string{"Closed {{.app}}.", "{{.app}} again?{{.missing}}"}, CooldownSeconds: 60},
+		},
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	e := &engine{cfg: c, last: make(map[int]time.Time)}
+	now := time.Now()
+
+	title, text, outputs, ok := e.render(events.StreakMilestone{Days: 30}, now)
+	if !ok || text != "A month clean." || title != "vex-cli" || !slices.Equal(outputs, []string{"wall", "desktop"}) {
+		t.Errorf("Expected the specific rule first, got %q %q %v", title, text, outputs)
+	}
+	title, text, outputs, _ = e.render(events.StreakMilestone{Days: 7}, now)
+	if text != "7 days without a failure." || title != "Well done" || !slices.Equal(outputs, []string{"motd"}) {
+		t.Errorf("Unexpected streak message %q %q %v", title, text, outputs)
+	}
+
+	defer func(orig func(int) int) { pick = orig }(pick)
+	pick = func(n int) int { return n - 1 }
+	if _, text, _, _ = e.render(events.Kill{App: "steam"}, now); text != "steam again?" {
+		t.Errorf("Expected the last template with missing keys empty, got %q", text)
+	}
+	if _, _, _, ok = e.render(events.Kill{App: "steam"}, now.Add(30*time.Second)); ok {
+		t.Error("Expected no message within the cooldown")
+	}
+	if _, _, _, ok = e.render(events.Kill{App: "steam"}, now.Add(61*time.Second)); !ok {
+		t.Error("Expected a message after the cooldown")
+	}
+	if _, _, _, ok = e.render(events.Completion{}, now); ok {
+		t.Error("Expected no message for an event without a rule")
+	}
+}
+
+func TestDeliverMOTD(t *testing.T) {
+	MOTDFile = filepath.Join(t.TempDir(), "motd.d", "vex-cli")
+	for _, text := range []string{"first", "second"} {
+		if err := deliverTo(OutputMOTD, "vex-cli", text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(MOTDFile); string(data) != "second\n" {
+		t.Errorf("Expected the latest message only, got %q", data)
+	}
+}
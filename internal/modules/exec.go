@@ -0,0 +1,147 @@
+package modules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// ConfigFile lists the exec modules.  Optional.
+var ConfigFile = "/etc/vex-cli/modules.json"
+
+// defaultTimeout bounds a call into an exec module that sets no timeout.
+const defaultTimeout = 30 * time.Second
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Modules []ExecConfig `json:"modules"`
+}
+
+// ExecConfig describes one exec module.
+type ExecConfig struct {
+	Name           string   `json:"name"`
+	Command        string   `json:"command"` // absolute path
+	Args           []string `json:"args,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"` // per call; default 30
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means there
+// are no exec modules and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate requires each module to have a unique name and an absolute
+// command.
+func (c *Config) Validate() error {
+	seen := make(map[string]bool)
+	for i, m := range c.Modules {
+		switch {
+		case m.Name == "":
+			return fmt.Errorf("modules[%d] has no name", i)
+		case seen[m.Name]:
+			return fmt.Errorf("module %q is listed twice", m.Name)
+		case !strings.HasPrefix(m.Command, "/"):
+			return fmt.Errorf("module %q: command must be an absolute path", m.Name)
+		case m.TimeoutSeconds < 0:
+			return fmt.Errorf("module %q: timeout_seconds must not be negative", m.Name)
+		}
+		seen[m.Name] = true
+	}
+	return nil
+}
+
+// execRequest is written to an exec module's stdin.  State is left out of
+// init and shutdown.
+type execRequest struct {
+	Action string             `json:"action"` // init, apply, verify or shutdown
+	State  *state.SystemState `json:"state,omitempty"`
+}
+
+// execResponse is what an exec module may print on stdout.  Printing
+// nothing and exiting 0 is success too.
+type execResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// execModule runs a program for every call.
+type execModule struct {
+	cfg ExecConfig
+}
+
+func (m *execModule) Name() string                       { return m.cfg.Name }
+func (m *execModule) Init() error                        { return m.call("init", nil) }
+func (m *execModule) Apply(st *state.SystemState) error  { return m.call("apply", st) }
+func (m *execModule) Verify(st *state.SystemState) error { return m.call("verify", st) }
+func (m *execModule) Shutdown() error                    { return m.call("shutdown", nil) }
+
+// call runs the command with the request on stdin and turns a reported
+// error, a failed exit or a timeout into an error.
+func (m *execModule) call(action string, st *state.SystemState) error {
+	req, err := json.Marshal(execRequest{Action: action, State: st})
+	if err != nil {
+		return err
+	}
+	timeout := defaultTimeout
+	if m.cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(m.cfg.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.cfg.Command, m.cfg.Args...)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	var resp execResponse
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return fmt.Errorf("%s: unreadable response %q", action, truncate(string(out)))
+		}
+	}
+	switch {
+	case resp.Error != "":
+		return fmt.Errorf("%s: %s", action, resp.Error)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s: timed out after %s", action, timeout)
+	case runErr != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", action, runErr, truncate(msg))
+		}
+		return fmt.Errorf("%s: %w", action, runErr)
+	}
+	return nil
+}
+
+// truncate shortens module output for a log line.
+func truncate(s string) string {
+	if len(s) > 200 {
+		return s[:200] + "…"
+	}
+	return s
+}
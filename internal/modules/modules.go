@@ -0,0 +1,212 @@
+// Package modules lets enforcement that vexd does not build in, such as
+// switching off a smart plug or throttling through a router's API, follow
+// the same state as the throttler and guardian without a fork.
+//
+// A module is an EnforcementModule.  Go code adds one with Register, from
+// an init function in a file dropped into cmd/vexd; anything else is an
+// exec module, a program listed in ConfigFile that vexd runs with a JSON
+// request on stdin for every call (see exec.go).  vexd calls Init once at
+// startup, Apply with the whole state whenever it changes, Verify after a
+// run that did not stop cleanly, and Shutdown when it stops unlocked.
+// Apply runs on a worker of its own, so a slow module never holds up a
+// command.
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// EnforcementModule is a piece of enforcement outside vexd.
+type EnforcementModule interface {
+	// Name identifies the module in logs, e.g. "smart-plug".
+	Name() string
+	// Init prepares the module.  A module whose Init fails is not called
+	// again.
+	Init() error
+	// Apply enforces st.  While st.Pause is set, or st.Compliance is not
+	// locked if the module only punishes, nothing should be enforced.
+	Apply(st *state.SystemState) error
+	// Verify reports where what the module enforces differs from st.
+	Verify(st *state.SystemState) error
+	// Shutdown lifts whatever the module enforces.
+	Shutdown() error
+}
+
+var (
+	mu         sync.Mutex
+	registered []EnforcementModule
+	active     []EnforcementModule // those whose Init succeeded
+
+	// latest is the state waiting for the apply worker, which wake
+	// rouses.  Changes that arrive while it is busy replace one another.
+	latest *state.SystemState
+	wake   = make(chan struct{}, 1)
+
+	// callMu keeps calls into the modules one at a time; stopped is set
+	// by Shutdown so that nothing is applied after it.
+	callMu  sync.Mutex
+	stopped bool
+)
+
+// Register adds m to the modules Init starts.  It panics if a module of
+// the same name is already registered.
+func Register(m EnforcementModule) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range registered {
+		if r.Name() == m.Name() {
+			panic("modules: " + m.Name() + " registered twice")
+		}
+	}
+	registered = append(registered, m)
+}
+
+// Init registers the exec modules in ConfigFile, calls every module's
+// Init and starts the apply worker.  Modules that fail to load or start
+// are reported and left out.
+func Init() error {
+	var errs []error
+	if c, err := LoadConfig(); err != nil {
+		errs = append(errs, err)
+	} else if c != nil {
+		for _, ec := range c.Modules {
+			Register(&execModule{cfg: ec})
+		}
+	}
+
+	mu.Lock()
+	mods := append([]EnforcementModule(nil), registered...)
+	mu.Unlock()
+	if len(mods) == 0 {
+		return errors.Join(errs...)
+	}
+
+	var started []EnforcementModule
+	callMu.Lock()
+	for _, m := range mods {
+		if err := m.Init(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
+			continue
+		}
+		log.Printf("Modules: %s initialized", m.Name())
+		started = append(started, m)
+	}
+	stopped = false
+	callMu.Unlock()
+
+	mu.Lock()
+	active = started
+	mu.Unlock()
+	supervisor.Go("modules.apply", applyLoop)
+	return errors.Join(errs...)
+}
+
+// Active returns the names of the modules whose Init succeeded.
+func Active() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, len(active))
+	for i, m := range active {
+		names[i] = m.Name()
+	}
+	return names
+}
+
+// Apply hands a copy of st to the apply worker and returns at once.
+func Apply(st *state.SystemState) {
+	snap, err := clone(st)
+	if err != nil {
+		log.Printf("Modules: cannot copy the state: %v", err)
+		return
+	}
+	mu.Lock()
+	if len(active) == 0 {
+		mu.Unlock()
+		return
+	}
+	latest = snap
+	mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// applyLoop applies each state handed over by Apply to every module.
+func applyLoop() error {
+	for range wake {
+		mu.Lock()
+		st, mods := latest, active
+		latest = nil
+		mu.Unlock()
+		if st == nil {
+			continue
+		}
+
+		callMu.Lock()
+		if !stopped {
+			for _, m := range mods {
+				if err := m.Apply(st); err != nil {
+					log.Printf("Modules: %s failed to apply: %v", m.Name(), err)
+					vexlog.LogEvent("MODULES", "APPLY_FAILED", fmt.Sprintf("module=%s, error=%v", m.Name(), err))
+				}
+			}
+		}
+		callMu.Unlock()
+	}
+	return nil
+}
+
+// Verify asks every module whether it still enforces st.
+func Verify(st *state.SystemState) error {
+	callMu.Lock()
+	defer callMu.Unlock()
+	return each(func(m EnforcementModule) error { return m.Verify(st) })
+}
+
+// Shutdown has every module lift its enforcement.  Nothing is applied
+// afterwards.
+func Shutdown() error {
+	callMu.Lock()
+	defer callMu.Unlock()
+	stopped = true
+	return each(EnforcementModule.Shutdown)
+}
+
+// each calls fn on every active module in turn, joining the errors.  The
+// caller holds callMu.
+func each(fn func(EnforcementModule) error) error {
+	mu.Lock()
+	mods := active
+	mu.Unlock()
+
+	var errs []error
+	for _, m := range mods {
+		if err := fn(m); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clone deep-copies st so the worker never reads it while a handler
+// changes it.
+func clone(st *state.SystemState) (*state.SystemState, error) {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	var c state.SystemState
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,148 @@
+package modules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+// fakeModule records the calls made to it.
+type fakeModule struct {
+	name    string
+	initErr error
+
+	mu      sync.Mutex
+	calls   []string
+	applied chan string
+}
+
+func (f *fakeModule) record(call string) {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+}
+
+func (f *fakeModule) Name() string { return f.name }
+func (f *fakeModule) Init() error  { f.record("init"); return f.initErr }
+func (f *fakeModule) Apply(st *state.SystemState) error {
+	f.record("apply")
+	f.applied <- st.Network.Profile
+	return nil
+}
+func (f *fakeModule) Verify(st *state.SystemState) error { f.record("verify"); return nil }
+func (f *fakeModule) Shutdown() error                    { f.record("shutdown"); return nil }
+
+func reset(t *testing.T) {
+	t.Helper()
+	ConfigFile = filepath.Join(t.TempDir(), "modules.json")
+	t.Cleanup(func() {
+		mu.Lock()
+		registered, active, latest = nil, nil, nil
+		mu.Unlock()
+		ConfigFile = "/etc/vex-cli/modules.json"
+	})
+}
+
+func TestLifecycle(t *testing.T) {
+	reset(t)
+	good := &fakeModule{name: "plug", applied: make(chan string, 4)}
+	bad := &fakeModule{name: "router", initErr: os.ErrPermission, applied: make(chan string, 4)}
+	Register(good)
+	Register(bad)
+
+	if err := Init(); err == nil || !strings.Contains(err.Error(), "router") {
+		t.Errorf("Expected the router's Init to fail, got %v", err)
+	}
+	if got := Active(); len(got) != 1 || got[0] != "plug" {
+		t.Fatalf("Expected only plug to be active, got %v", got)
+	}
+
+	st := &state.SystemState{Network: state.NetworkState{Profile: "choke"}}
+	Apply(st)
+	st.Network.Profile = "standard" // the module must have a copy
+	select {
+	case got := <-good.applied:
+		if got != "choke" {
+			t.Errorf("Expected the applied profile to be choke, got %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Apply never reached the module")
+	}
+
+	if err := Verify(st); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := Shutdown(); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	good.mu.Lock()
+	defer good.mu.Unlock()
+	if strings.Join(good.calls, ",") != "init,apply,verify,shutdown" {
+		t.Errorf("Unexpected calls %v", good.calls)
+	}
+	if len(bad.calls) != 1 {
+		t.Errorf("Expected a module that failed Init not to be called again, got %v", bad.calls)
+	}
+}
+
+func TestExecModule(t *testing.T) {
+	reset(t)
+	dir := t.TempDir()
+	script := filepath.Join(dir, "plug.sh")
+	log := filepath.Join(dir, "requests")
+	os.WriteFile(script, []byte(`#!/bin/sh
+req=$(cat)
+echo "$req" >> `+log+`
+case "$req" in
+*'"action":"verify"'*) echo '{"error": "plug is on"}' ;;
+*'"action":"shutdown"'*) echo 'oops' >&2; exit 3 ;;
+esac
+`), 0755)
+
+	m := &execModule{cfg: ExecConfig{Name: "plug", Command: script}}
+	if err := m.Init(); err != nil {
+		t.Errorf("init: %v", err)
+	}
+	st := &state.SystemState{Network: state.NetworkState{Profile: "black-hole"}}
+	if err := m.Apply(st); err != nil {
+		t.Errorf("apply: %v", err)
+	}
+	if err := m.Verify(st); err == nil || !strings.Contains(err.Error(), "plug is on") {
+		t.Errorf("Expected the reported error, got %v", err)
+	}
+	if err := m.Shutdown(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected the exit status and stderr, got %v", err)
+	}
+
+	data, _ := os.ReadFile(log)
+	if !strings.Contains(string(data), `"profile":"black-hole"`) {
+		t.Errorf("Expected the state on stdin, got %s", data)
+	}
+
+	slow := &execModule{cfg: ExecConfig{Name: "slow", Command: "/bin/sleep", Args: []string{"5"}, TimeoutSeconds: 1}}
+	if err := slow.Init(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	reset(t)
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Expected nil for a missing file, got %+v, %v", c, err)
+	}
+	for _, bad := range []string{
+		`{"modules": [{"command": "/bin/true"}]}`,
+		`{"modules": [{"name": "plug", "command": "plug.sh"}]}`,
+		`{"modules": [{"name": "plug", "command": "/bin/true"}, {"name": "plug", "command": "/bin/false"}]}`,
+	} {
+		os.WriteFile(ConfigFile, []byte(bad), 0644)
+		if _, err := LoadConfig(); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
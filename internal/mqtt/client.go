@@ -0,0 +1,204 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// The subset of MQTT 3.1.1 vexd needs: connect with a last will, publish
+// at QoS 0 and keep the connection alive.  Nothing is ever subscribed to.
+
+const (
+	packetConnect    = 0x10
+	packetConnack    = 0x20
+	packetPublish    = 0x30
+	packetPingreq    = 0xc0
+	packetPingresp   = 0xd0
+	packetDisconnect = 0xe0
+)
+
+// KeepAlive is the keep-alive interval announced to the broker.
+var KeepAlive = 60 * time.Second
+
+// connackErrors are the CONNACK return codes.
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// message is a retained or plain publish, used for the last will too.
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+// dial connects to broker (mqtt://host[:1883] or mqtts://host[:8883]) and
+// completes the CONNECT handshake.
+func dial(broker, clientID, username, password string, will message) (*client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		conn, err = d.Dial("tcp", hostPort(u, "1883"))
+	case "mqtts", "ssl", "tls":
+		conn, err = tls.DialWithDialer(d, "tcp", hostPort(u, "8883"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &client{conn: conn, r: bufio.NewReader(conn)}
+	if err := c.connect(clientID, username, password, will); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (c *client) connect(clientID, username, password string, will message) error {
+	flags := byte(0x02) // clean session
+	if will.topic != "" {
+		flags |= 0x04
+		if will.retain {
+			flags |= 0x20
+		}
+	}
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 = 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(KeepAlive/time.Second))
+	body = appendString(body, clientID)
+	if will.topic != "" {
+		body = appendString(body, will.topic)
+		body = appendString(body, string(will.payload))
+	}
+	if username != "" {
+		body = appendString(body, username)
+		if password != "" {
+			body = appendString(body, password)
+		}
+	}
+	c.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.write(packetConnect, body); err != nil {
+		return err
+	}
+	kind, ack, err := c.read()
+	if err != nil {
+		return err
+	}
+	if kind != packetConnack || len(ack) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %#x", kind)
+	}
+	if ack[1] != 0 {
+		if msg, ok := connackErrors[ack[1]]; ok {
+			return fmt.Errorf("broker refused connection: %s", msg)
+		}
+		return fmt.Errorf("broker refused connection: code %d", ack[1])
+	}
+	return nil
+}
+
+// publish sends m at QoS 0.
+func (c *client) publish(m message) error {
+	kind := byte(packetPublish)
+	if m.retain {
+		kind |= 0x01
+	}
+	return c.write(kind, append(appendString(nil, m.topic), m.payload...))
+}
+
+func (c *client) ping() error { return c.write(packetPingreq, nil) }
+
+// disconnect ends the session cleanly; the broker does not send the will.
+func (c *client) disconnect() {
+	c.write(packetDisconnect, nil)
+	c.conn.Close()
+}
+
+func (c *client) write(kind byte, body []byte) error {
+	pkt := appendLength([]byte{kind}, len(body))
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(append(pkt, body...))
+	return err
+}
+
+// read returns the next packet's type (upper four bits) and body.
+func (c *client) read() (byte, []byte, error) {
+	kind, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, fmt.Errorf("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return kind & 0xf0, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendLength appends the variable-length "remaining length" field.
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
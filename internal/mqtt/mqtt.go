@@ -0,0 +1,383 @@
+// Package mqtt publishes vexd's state to an MQTT broker for smart-home
+// automations.
+//
+// Each value (locked, paused, network profile, failure score, task
+// status, CPU limit, writing progress) is a retained topic under the
+// configured prefix, republished when it changes, and an availability
+// topic goes "offline" through the last will when the daemon drops off.
+// Home Assistant discovery messages describe the values as sensors of one
+// device, so they show up without any YAML.  Keyholder events are also
+// published, not retained, on <prefix>/event for automations to trigger
+// on.
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+var (
+	// ConfigFile holds the broker and topic settings.  Optional.
+	ConfigFile = "/etc/vex-cli/mqtt.json"
+
+	// retryDelay is the pause before reconnecting to the broker.
+	retryDelay = 30 * time.Second
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Broker           string   `json:"broker"` // mqtt://host[:1883] or mqtts://host[:8883]
+	Username         string   `json:"username,omitempty"`
+	Password         string   `json:"password,omitempty"`
+	ClientID         string   `json:"client_id,omitempty"`         // default vex-cli-<host>
+	TopicPrefix      string   `json:"topic_prefix,omitempty"`      // default vex-cli/<host>
+	DiscoveryPrefix  string   `json:"discovery_prefix,omitempty"`  // default homeassistant
+	DisableDiscovery bool     `json:"disable_discovery,omitempty"` // publish the topics only
+	Events           []string `json:"events,omitempty"`            // as in notify.json; empty sends all
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means MQTT
+// is not configured and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the broker URL, the topic prefix and the event filter.
+func (c *Config) Validate() error {
+	u, err := url.Parse(c.Broker)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("broker must be a URL such as mqtt://host:1883")
+	}
+	switch u.Scheme {
+	case "mqtt", "tcp", "mqtts", "ssl", "tls":
+	default:
+		return fmt.Errorf("broker scheme must be mqtt:// or mqtts://")
+	}
+	if strings.ContainsAny(c.TopicPrefix+c.DiscoveryPrefix, "+#") {
+		return fmt.Errorf("topic prefixes must not contain wildcards")
+	}
+	return notify.ValidateFilter(c.Events)
+}
+
+// Status is the state published to the broker.
+type Status struct {
+	Locked       bool
+	Paused       bool
+	Profile      string
+	FailureScore int
+	TaskStatus   string
+	CPULimitPct  int
+	Writing      Writing
+}
+
+// Writing is the progress of the writing-lines task.
+type Writing struct {
+	Active    bool   `json:"active"`
+	Phrase    string `json:"phrase,omitempty"`
+	Completed int    `json:"completed"`
+	Required  int    `json:"required"`
+}
+
+// Progress is the percentage of lines written, 0 without a task.
+func (w Writing) Progress() int {
+	if w.Required <= 0 {
+		return 0
+	}
+	return min(w.Completed*100/w.Required, 100)
+}
+
+// payloads maps each value's topic (below the prefix) to its payload.
+func (s Status) payloads() map[string]string {
+	attrs, _ := json.Marshal(s.Writing)
+	return map[string]string{
+		"locked":           onOff(s.Locked),
+		"paused":           onOff(s.Paused),
+		"profile":          s.Profile,
+		"failure_score":    strconv.Itoa(s.FailureScore),
+		"task_status":      s.TaskStatus,
+		"cpu_limit":        strconv.Itoa(s.CPULimitPct),
+		"writing_progress": strconv.Itoa(s.Writing.Progress()),
+		"writing":          string(attrs),
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// entity is one Home Assistant discovery entry.
+type entity struct {
+	component string // binary_sensor or sensor
+	topic     string // below the prefix
+	name      string
+	icon      string
+	unit      string
+	measured  bool   // state_class measurement, for history graphs
+	attrs     string // topic of a JSON attributes object
+}
+
+var entities = []entity{
+	{component: "binary_sensor", topic: "locked", name: "Locked", icon: "mdi:lock"},
+	{component: "binary_sensor", topic: "paused", name: "Paused", icon: "mdi:pause-circle"},
+	{component: "sensor", topic: "profile", name: "Network profile", icon: "mdi:speedometer-slow"},
+	{component: "sensor", topic: "failure_score", name: "Failure score", icon: "mdi:alert-octagon", measured: true},
+	{component: "sensor", topic: "task_status", name: "Task status", icon: "mdi:clipboard-check"},
+	{component: "sensor", topic: "cpu_limit", name: "CPU limit", icon: "mdi:cpu-64-bit", unit: "%", measured: true},
+	{component: "sensor", topic: "writing_progress", name: "Writing progress", icon: "mdi:pencil", unit: "%", measured: true, attrs: "writing"},
+}
+
+// Publisher keeps the broker up to date with the latest Status.
+type Publisher struct {
+	cfg    *Config
+	host   string
+	prefix string
+	node   string // Home Assistant node and device ID
+
+	mu     sync.Mutex
+	status Status
+
+	wake   chan struct{}
+	events chan notify.Message
+	stop   chan chan struct{}
+}
+
+// Start registers the publisher as a notification sink and connects to
+// the broker in the background, retrying until it succeeds.  Call Update
+// with the initial state.
+func Start(c *Config) *Publisher {
+	p := newPublisher(c)
+	notify.AddSink(notify.Sink{Name: "mqtt", Events: c.Events, Send: func(m notify.Message) error {
+		select {
+		case p.events <- m:
+			return nil
+		default:
+			return fmt.Errorf("event queue full")
+		}
+	}})
+	supervisor.Go("mqtt", func() error { p.run(); return nil })
+	return p
+}
+
+func newPublisher(c *Config) *Publisher {
+	host, _ := os.Hostname()
+	p := &Publisher{
+		cfg:    c,
+		host:   host,
+		prefix: strings.TrimSuffix(c.TopicPrefix, "/"),
+		node:   "vex_" + idFrom(host),
+		wake:   make(chan struct{}, 1),
+		events: make(chan notify.Message, 16),
+		stop:   make(chan chan struct{}),
+	}
+	if p.prefix == "" {
+		p.prefix = "vex-cli/" + host
+	}
+	return p
+}
+
+// idFrom reduces s to the characters Home Assistant allows in IDs.
+func idFrom(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(s))
+}
+
+// Update records the latest state; changed values are published shortly
+// after.  It never blocks.
+func (p *Publisher) Update(s Status) {
+	p.mu.Lock()
+	p.status = s
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close marks the daemon offline and disconnects.
+func (p *Publisher) Close() {
+	done := make(chan struct{})
+	select {
+	case p.stop <- done:
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	case <-time.After(time.Second):
+	}
+}
+
+func (p *Publisher) availability() string { return p.prefix + "/availability" }
+
+func (p *Publisher) run() {
+	clientID := p.cfg.ClientID
+	if clientID == "" {
+		clientID = "vex-cli-" + p.host
+	}
+	will := message{topic: p.availability(), payload: []byte("offline"), retain: true}
+	failing := false
+	for {
+		c, err := dial(p.cfg.Broker, clientID, p.cfg.Username, p.cfg.Password, will)
+		if err == nil {
+			log.Printf("MQTT: Connected to %s, publishing under %s", p.cfg.Broker, p.prefix)
+			failing = false
+			var stopped bool
+			stopped, err = p.serve(c)
+			if stopped {
+				return
+			}
+			c.conn.Close()
+		}
+		if !failing {
+			log.Printf("MQTT: %v; retrying every %s", err, retryDelay)
+			failing = true
+		}
+		select {
+		case done := <-p.stop:
+			close(done)
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// serve publishes discovery and state over one connection until it fails
+// or Close is called.
+func (p *Publisher) serve(c *client) (stopped bool, err error) {
+	if !p.cfg.DisableDiscovery {
+		for _, m := range p.discovery() {
+			if err := c.publish(m); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := c.publish(message{topic: p.availability(), payload: []byte("online"), retain: true}); err != nil {
+		return false, err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			c.conn.SetReadDeadline(time.Now().Add(KeepAlive * 3 / 2))
+			if _, _, err := c.read(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	sent := make(map[string]string)
+	flush := func() error {
+		p.mu.Lock()
+		payloads := p.status.payloads()
+		p.mu.Unlock()
+		for topic, payload := range payloads {
+			if v, ok := sent[topic]; ok && v == payload {
+				continue
+			}
+			if err := c.publish(message{topic: p.prefix + "/" + topic, payload: []byte(payload), retain: true}); err != nil {
+				return err
+			}
+			sent[topic] = payload
+		}
+		return nil
+	}
+	if err := flush(); err != nil {
+		return false, err
+	}
+
+	ping := time.NewTicker(KeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case <-p.wake:
+			err = flush()
+		case m := <-p.events:
+			body, _ := json.Marshal(m)
+			err = c.publish(message{topic: p.prefix + "/event", payload: body})
+		case <-ping.C:
+			err = c.ping()
+		case err = <-readErr:
+		case done := <-p.stop:
+			c.publish(message{topic: p.availability(), payload: []byte("offline"), retain: true})
+			c.disconnect()
+			close(done)
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// discovery returns the retained Home Assistant discovery messages.
+func (p *Publisher) discovery() []message {
+	prefix := p.cfg.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+	device := map[string]any{
+		"identifiers":  []string{p.node},
+		"name":         "vex-cli " + p.host,
+		"manufacturer": "vex-cli",
+		"model":        "vexd",
+	}
+	var out []message
+	for _, e := range entities {
+		cfg := map[string]any{
+			"name":               e.name,
+			"unique_id":          p.node + "_" + e.topic,
+			"object_id":          p.node + "_" + e.topic,
+			"state_topic":        p.prefix + "/" + e.topic,
+			"availability_topic": p.availability(),
+			"icon":               e.icon,
+			"device":             device,
+		}
+		if e.unit != "" {
+			cfg["unit_of_measurement"] = e.unit
+		}
+		if e.measured {
+			cfg["state_class"] = "measurement"
+		}
+		if e.attrs != "" {
+			cfg["json_attributes_topic"] = p.prefix + "/" + e.attrs
+		}
+		payload, _ := json.Marshal(cfg)
+		out = append(out, message{
+			topic:   fmt.Sprintf("%s/%s/%s/%s/config", prefix, e.component, p.node, e.topic),
+			payload: payload,
+			retain:  true,
+		})
+	}
+	return out
+}
@@ -0,0 +1,152 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+)
+
+// fakeBroker accepts one connection, checks the CONNECT and forwards
+// every PUBLISH as topic -> payload.
+func fakeBroker(t *testing.T) (string, chan [2]string, chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	pubs := make(chan [2]string, 64)
+	connects := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		c := &client{conn: conn, r: bufio.NewReader(conn)}
+		kind, body, err := c.read()
+		if err != nil || kind != packetConnect {
+			return
+		}
+		connects <- body
+		c.write(packetConnack, []byte{0, 0})
+		for {
+			kind, body, err := c.read()
+			if err != nil {
+				close(pubs)
+				return
+			}
+			if kind == packetPublish {
+				n := int(binary.BigEndian.Uint16(body))
+				pubs <- [2]string{string(body[2 : 2+n]), string(body[2+n:])}
+			}
+		}
+	}()
+	return "mqtt://" + ln.Addr().String(), pubs, connects
+}
+
+func TestPublisher(t *testing.T) {
+	broker, pubs, connects := fakeBroker(t)
+	p := newPublisher(&Config{Broker: broker, Username: "ha", Password: "pw", TopicPrefix: "vex/desk"})
+	p.host, p.node = "desk", "vex_desk"
+	p.Update(Status{Locked: true, Profile: "choke", FailureScore: 7, Writing: Writing{Active: true, Phrase: "x", Completed: 5, Required: 20}})
+	go p.run()
+
+	connect := <-connects
+	if !strings.Contains(string(connect), "vex/desk/availability") || !strings.Contains(string(connect), "offline") {
+		t.Errorf("Expected the availability topic as last will, got %q", connect)
+	}
+	if connect[7]&0xc4 != 0xc4 {
+		t.Errorf("Expected will, username and password flags, got %#x", connect[7])
+	}
+
+	got := make(map[string]string)
+	wait := func(topic string) string {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			if v, ok := got[topic]; ok {
+				return v
+			}
+			select {
+			case m, ok := <-pubs:
+				if !ok {
+					t.Fatalf("Connection closed before %s was published", topic)
+				}
+				got[m[0]] = m[1]
+			case <-timeout:
+				t.Fatalf("Timed out waiting for %s; got %v", topic, got)
+			}
+		}
+	}
+
+	var disc map[string]any
+	if err := json.Unmarshal([]byte(wait("homeassistant/binary_sensor/vex_desk/locked/config")), &disc); err != nil {
+		t.Fatal(err)
+	}
+	if disc["state_topic"] != "vex/desk/locked" || disc["unique_id"] != "vex_desk_locked" {
+		t.Errorf("Unexpected discovery config: %v", disc)
+	}
+	for topic, want := range map[string]string{
+		"vex/desk/availability":     "online",
+		"vex/desk/locked":           "ON",
+		"vex/desk/profile":          "choke",
+		"vex/desk/failure_score":    "7",
+		"vex/desk/writing_progress": "25",
+	} {
+		if v := wait(topic); v != want {
+			t.Errorf("%s: expected %q, got %q", topic, want, v)
+		}
+	}
+
+	delete(got, "vex/desk/locked")
+	p.Update(Status{Profile: "choke", FailureScore: 7})
+	if v := wait("vex/desk/locked"); v != "OFF" {
+		t.Errorf("Expected locked to change to OFF, got %q", v)
+	}
+
+	p.events <- notify.Message{Event: "kill", Details: map[string]string{"app": "steam"}}
+	if v := wait("vex/desk/event"); !strings.Contains(v, `"event":"kill"`) {
+		t.Errorf("Unexpected event payload %s", v)
+	}
+
+	delete(got, "vex/desk/availability")
+	p.Close()
+	if v := wait("vex/desk/availability"); v != "offline" {
+		t.Errorf("Expected offline on close, got %q", v)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		c  Config
+		ok bool
+	}{
+		{Config{Broker: "mqtt://ha.local"}, true},
+		{Config{Broker: "mqtts://ha.local:8883", TopicPrefix: "home/vex"}, true},
+		{Config{Broker: "http://ha.local"}, false},
+		{Config{Broker: "ha.local:1883"}, false},
+		{Config{Broker: "mqtt://ha.local", TopicPrefix: "vex/#"}, false},
+	} {
+		if err := tc.c.Validate(); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result %v", tc.c, err)
+		}
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	for _, n := range []int{0, 127, 128, 16383, 16384, 2097151} {
+		b := appendLength([]byte{packetPublish}, n)
+		c := &client{r: bufio.NewReader(strings.NewReader(string(b) + strings.Repeat("x", n)))}
+		_, body, err := c.read()
+		if err != nil || len(body) != n {
+			t.Errorf("%d: got %d bytes, %v", n, len(body), err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+// Package netacct counts the network traffic of each app.  A cgroup_skb
+// program attached at the root of the cgroup hierarchy adds the length of
+// every packet, in and out, to a counter keyed by the cgroup of the socket
+// it belongs to.  Every Interval the counters are read and what each
+// cgroup sent and received since the last read is credited to its app:
+// the command name of the cgroup's first process, which for an app the
+// desktop launched in a scope of its own is the app.
+//
+// Only the subject's cgroups are counted: those under user.slice, or
+// under the target users' slices when targets are set.  System services
+// are left out.
+package netacct
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+var (
+	// CgroupRoot is the cgroup v2 hierarchy the program is attached to.
+	CgroupRoot = "/sys/fs/cgroup"
+
+	// Interval is how often the counters are read.
+	Interval = time.Minute
+
+	// MaxCgroups bounds the counters the kernel keeps.  The least
+	// recently used is dropped when it is full.
+	MaxCgroups = 16384
+)
+
+// Record credits bytes of traffic ending at now to an app.
+type Record func(app string, bytes uint64, now time.Time)
+
+// Start loads the program, attaches it for both directions and reads the
+// counters every Interval in the background.
+func Start(record Record) error {
+	if subsystem.Skip(subsystem.Surveillance, "attach the network accounting program to %s", CgroupRoot) {
+		return nil
+	}
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("failed to remove memlock limit: %w", err)
+	}
+	coll, err := ebpf.NewCollection(spec())
+	if err != nil {
+		return fmt.Errorf("failed to load the program: %w", err)
+	}
+	prog := coll.Programs["count"]
+	for _, attach := range []ebpf.AttachType{ebpf.AttachCGroupInetEgress, ebpf.AttachCGroupInetIngress} {
+		// The links are closed, and the program detached, when vexd
+		// exits.
+		if _, err := link.AttachCgroup(link.CgroupOptions{Path: CgroupRoot, Attach: attach, Program: prog}); err != nil {
+			coll.Close()
+			return fmt.Errorf("failed to attach to %s: %w", CgroupRoot, err)
+		}
+	}
+
+	a := newAccountant(record)
+	counters := coll.Maps["bytes"]
+	supervisor.Go("netacct", func() error {
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			totals, err := readCounters(counters)
+			if err != nil {
+				return err
+			}
+			for _, id := range a.sample(totals, now) {
+				counters.Delete(id)
+			}
+		}
+		return nil
+	})
+	log.Printf("Network accounting: Counting traffic per app under %s", CgroupRoot)
+	return nil
+}
+
+// spec is the counter map and the program that fills it.
+func spec() *ebpf.CollectionSpec {
+	return &ebpf.CollectionSpec{
+		Maps: map[string]*ebpf.MapSpec{
+			"bytes": {
+				Type:       ebpf.LRUHash,
+				KeySize:    8, // cgroup id
+				ValueSize:  8, // bytes
+				MaxEntries: uint32(MaxCgroups),
+			},
+		},
+		Programs: map[string]*ebpf.ProgramSpec{
+			"count": {
+				Type:         ebpf.CGroupSKB,
+				License:      "GPL",
+				Instructions: instructions(),
+			},
+		},
+	}
+}
+
+// instructions add skb->len to the counter of the cgroup of the packet's
+// socket, creating it if need be, and always let the packet through.
+func instructions() asm.Instructions {
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMem(asm.R7, asm.R6, 0, asm.Word), // skb->len
+		asm.FnSkbCgroupId.Call(),
+		asm.JEq.Imm(asm.R0, 0, "out"), // no socket
+		asm.StoreMem(asm.RFP, -8, asm.R0, asm.DWord),
+		asm.StoreMem(asm.RFP, -16, asm.R7, asm.DWord),
+
+		asm.LoadMapPtr(asm.R1, 0).WithReference("bytes"),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -8),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "insert"),
+		asm.StoreXAdd(asm.R0, asm.R7, asm.DWord),
+		asm.Ja.Label("out"),
+
+		// A packet that races another to create the counter is lost;
+		// BPF_NOEXIST keeps it from overwriting the other.
+		asm.LoadMapPtr(asm.R1, 0).WithReference("bytes").WithSymbol("insert"),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -8),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, -16),
+		asm.Mov.Imm(asm.R4, 1), // BPF_NOEXIST
+		asm.FnMapUpdateElem.Call(),
+
+		asm.Mov.Imm(asm.R0, 1).WithSymbol("out"), // allow
+		asm.Return(),
+	}
+}
+
+// readCounters reads every counter, by cgroup id.
+func readCounters(m *ebpf.Map) (map[uint64]uint64, error) {
+	totals := make(map[uint64]uint64)
+	var id, n uint64
+	it := m.Iterate()
+	for it.Next(&id, &n) {
+		totals[id] = n
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the counters: %w", err)
+	}
+	return totals, nil
+}
+
+// accountant turns the running totals into traffic per app.
+type accountant struct {
+	record Record
+	seen   map[uint64]uint64 // the total already credited, by cgroup id
+	groups map[uint64]cgroup // the cgroups named so far
+}
+
+// cgroup is a cgroup the accountant has named.
+type cgroup struct {
+	path string // under CgroupRoot
+	app  string // "" if not counted
+}
+
+func newAccountant(record Record) *accountant {
+	return &accountant{
+		record: record,
+		seen:   make(map[uint64]uint64),
+		groups: make(map[uint64]cgroup),
+	}
+}
+
+// sample credits what each cgroup's total grew by since the last sample.
+// It returns the cgroups that are gone, whose counters can be deleted.
+func (a *accountant) sample(totals map[uint64]uint64, now time.Time) []uint64 {
+	unknown := make(map[uint64]bool)
+	for id := range totals {
+		if _, ok := a.groups[id]; !ok {
+			unknown[id] = true
+		}
+	}
+	if len(unknown) > 0 {
+		for id, cg := range findCgroups(unknown) {
+			a.groups[id] = cg
+		}
+	}
+
+	var gone []uint64
+	used := make(map[string]uint64)
+	for id, total := range totals {
+		cg, ok := a.groups[id]
+		if !ok {
+			// Removed before it could be named: there is no one to
+			// credit.
+			gone = append(gone, id)
+			continue
+		}
+		if total > a.seen[id] && cg.app != "" {
+			used[cg.app] += total - a.seen[id]
+		}
+		a.seen[id] = total
+	}
+	for app, n := range used {
+		a.record(app, n, now)
+	}
+
+	// Now that their last traffic is credited, forget the cgroups that
+	// have been removed, and those the kernel dropped.
+	for id, cg := range a.groups {
+		_, counting := totals[id]
+		if counting && inode(filepath.Join(CgroupRoot, cg.path)) == id {
+			continue
+		}
+		if counting {
+			gone = append(gone, id)
+		}
+		delete(a.groups, id)
+		delete(a.seen, id)
+	}
+	return gone
+}
+
+// counted reports whether the cgroup at path, under CgroupRoot, belongs
+// to the subject.
+func counted(path string) bool {
+	if !strings.HasPrefix(path, "user.slice/") {
+		return false
+	}
+	if !users.Scoped() {
+		return true
+	}
+	for _, uid := range users.UIDs() {
+		if strings.HasPrefix(path, fmt.Sprintf("user.slice/user-%d.slice/", uid)) {
+			return true
+		}
+	}
+	return false
+}
+
+// inode is the inode number of path, or 0.  On cgroup v2 a cgroup's id
+// is the inode number of its directory.
+func inode(path string) uint64 {
+	var st syscall.Stat_t
+	if syscall.Stat(path, &st) != nil {
+		return 0
+	}
+	return st.Ino
+}
+
+// findCgroups walks CgroupRoot for the cgroups with the given ids.
+func findCgroups(ids map[uint64]bool) map[uint64]cgroup {
+	found := make(map[uint64]cgroup)
+	filepath.WalkDir(CgroupRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		id := inode(path)
+		if !ids[id] {
+			return nil
+		}
+		rel, _ := filepath.Rel(CgroupRoot, path)
+		cg := cgroup{path: rel}
+		if counted(rel) {
+			cg.app = appOf(path)
+		}
+		found[id] = cg
+		if len(found) == len(ids) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// appOf names the app of the cgroup at dir: the lower-case command name
+// of its first process, or, once they have all exited, the unit.
+func appOf(dir string) string {
+	if f, err := os.Open(filepath.Join(dir, "cgroup.procs")); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if pid, err := strconv.Atoi(s.Text()); err == nil {
+				if comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm")); err == nil {
+					f.Close()
+					return strings.ToLower(strings.TrimSpace(string(comm)))
+				}
+			}
+		}
+		f.Close()
+	}
+	return unitApp(filepath.Base(dir))
+}
+
+// unitApp guesses the app from a systemd unit: app-gnome-firefox-3125.scope
+// and app-firefox@3a9c.service are firefox.
+func unitApp(unit string) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(unit, ".scope"), ".service")
+	if !strings.HasPrefix(name, "app-") {
+		return strings.ToLower(name)
+	}
+	name, _, _ = strings.Cut(strings.TrimPrefix(name, "app-"), "@")
+	if i := strings.LastIndex(name, "-"); i > 0 && isNumber(name[i+1:]) {
+		name = name[:i]
+	}
+	for _, launcher := range []string{"gnome-", "kde-", "flatpak-"} {
+		name = strings.TrimPrefix(name, launcher)
+	}
+	return strings.ToLower(name)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// Probe loads the program without attaching it.
+func Probe() (string, error) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return "", err
+	}
+	coll, err := ebpf.NewCollection(spec())
+	if err != nil {
+		return "", err
+	}
+	coll.Close()
+	return "cgroup_skb byte counter", nil
+}
@@ -0,0 +1,83 @@
+package netacct
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSample(t *testing.T) {
+	oldRoot := CgroupRoot
+	defer func() { CgroupRoot = oldRoot }()
+	CgroupRoot = t.TempDir()
+
+	mkdir := func(path string) uint64 {
+		dir := filepath.Join(CgroupRoot, path)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return inode(dir)
+	}
+	firefox := mkdir("user.slice/user-1000.slice/user@1000.service/app.slice/app-gnome-firefox-3125.scope")
+	steam := mkdir("user.slice/user-1000.slice/user@1000.service/app.slice/app-steam@3a9c.service")
+	daemon := mkdir("system.slice/nix-daemon.service")
+
+	used := make(map[string]uint64)
+	a := newAccountant(func(app string, n uint64, now time.Time) { used[app] += n })
+
+	a.sample(map[uint64]uint64{firefox: 1000, steam: 500, daemon: 9000}, time.Now())
+	want := map[string]uint64{"firefox": 1000, "steam": 500}
+	if !reflect.DeepEqual(used, want) {
+		t.Errorf("Expected %v after the first sample, got %v", want, used)
+	}
+
+	// Only the growth is credited; a cgroup gone before it was named is
+	// credited to no one and its counter deleted.
+	clear(used)
+	gone := a.sample(map[uint64]uint64{firefox: 1500, steam: 500, daemon: 9900, 424242: 70}, time.Now())
+	want = map[string]uint64{"firefox": 500}
+	if !reflect.DeepEqual(used, want) {
+		t.Errorf("Expected %v after the second sample, got %v", want, used)
+	}
+	if !reflect.DeepEqual(gone, []uint64{424242}) {
+		t.Errorf("Expected the unknown cgroup to be gone, got %v", gone)
+	}
+
+	// A removed cgroup's last traffic is still credited.
+	os.Remove(filepath.Join(CgroupRoot, "user.slice/user-1000.slice/user@1000.service/app.slice/app-steam@3a9c.service"))
+	clear(used)
+	gone = a.sample(map[uint64]uint64{firefox: 1500, steam: 800, daemon: 9900}, time.Now())
+	if used["steam"] != 300 {
+		t.Errorf("Expected steam's last 300 bytes, got %v", used)
+	}
+	if !reflect.DeepEqual(gone, []uint64{steam}) {
+		t.Errorf("Expected steam's cgroup to be gone, got %v", gone)
+	}
+	if _, ok := a.groups[steam]; ok {
+		t.Error("Expected steam's cgroup to be forgotten")
+	}
+}
+
+func TestUnitApp(t *testing.T) {
+	for unit, want := range map[string]string{
+		"app-gnome-firefox-3125.scope":                "firefox",
+		"app-steam@3a9c.service":                      "steam",
+		"app-flatpak-com.discordapp.Discord-77.scope": "com.discordapp.discord",
+		"session-2.scope":                             "session-2",
+	} {
+		if got := unitApp(unit); got != want {
+			t.Errorf("unitApp(%q) = %q, want %q", unit, got, want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("loading a program needs root")
+	}
+	if _, err := Probe(); err != nil {
+		t.Errorf("Expected the program to load, got %v", err)
+	}
+}
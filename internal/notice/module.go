@@ -0,0 +1,153 @@
+package notice
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// Refresh is how often the notice is rendered again, for the time left.
+var Refresh = time.Minute
+
+// Module shows the notice while the state calls for it.
+type Module struct {
+	cfg *Config
+
+	mu        sync.Mutex
+	st        *state.SystemState // the latest state applied
+	motd      string             // the notice in MOTDFile, "" if none
+	wallpaper string             // the notice on the wallpaper, "" if none
+	lastErr   string
+}
+
+// NewModule returns a Module for c.
+func NewModule(c *Config) *Module {
+	return &Module{cfg: c}
+}
+
+func (m *Module) Name() string { return "notice" }
+
+// Init starts the worker that keeps the time left current.
+func (m *Module) Init() error {
+	supervisor.Go("notice.refresh", m.refresh)
+	return nil
+}
+
+// Apply shows the notice for st, or takes it down.
+func (m *Module) Apply(st *state.SystemState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.st = st
+	return m.update(time.Now())
+}
+
+// Verify reports a notice missing from the motd while it is due.
+func (m *Module) Verify(st *state.SystemState) error {
+	if !Due(st) || !m.cfg.has(OutputMOTD) {
+		return nil
+	}
+	if _, err := os.Stat(MOTDFile); err != nil {
+		return errors.New("compliance notice missing from the motd")
+	}
+	return nil
+}
+
+// Shutdown takes the notice down.
+func (m *Module) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hide()
+}
+
+// refresh renders the notice again every Refresh, logging an error only
+// when it differs from the last one.
+func (m *Module) refresh() error {
+	for range time.Tick(Refresh) {
+		m.mu.Lock()
+		err := m.update(time.Now())
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+			if msg != m.lastErr {
+				log.Printf("Notice: %v", err)
+			}
+		}
+		m.lastErr = msg
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// update brings the outputs in line with m.st at now.  The caller holds
+// m.mu.
+func (m *Module) update(now time.Time) error {
+	if m.st == nil {
+		return nil
+	}
+	if !Due(m.st) {
+		return m.hide()
+	}
+	text, err := m.cfg.Render(m.st, now)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	if m.cfg.has(OutputMOTD) && text != m.motd {
+		if err := writeMOTD(text); err != nil {
+			errs = append(errs, err)
+		} else {
+			if m.motd == "" {
+				vexlog.LogEvent("NOTICE", "SHOWN", "output=motd")
+			}
+			m.motd = text
+		}
+	}
+	if m.cfg.has(OutputWallpaper) && text != m.wallpaper {
+		if _, err := m.cfg.showWallpaper(text, now); err != nil {
+			errs = append(errs, fmt.Errorf("wallpaper: %w", err))
+		} else {
+			if m.wallpaper == "" {
+				vexlog.LogEvent("NOTICE", "SHOWN", "output=wallpaper")
+			}
+			m.wallpaper = text
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// hide removes the notice from the motd and puts the wallpaper back.
+// The caller holds m.mu.
+func (m *Module) hide() error {
+	var errs []error
+	if err := os.Remove(MOTDFile); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	} else if m.motd != "" {
+		vexlog.LogEvent("NOTICE", "REMOVED", "output=motd")
+		m.motd = ""
+	}
+	if _, err := os.Stat(SavedFile); err == nil {
+		if err := hideWallpaper(); err != nil {
+			errs = append(errs, fmt.Errorf("wallpaper: %w", err))
+		} else {
+			vexlog.LogEvent("NOTICE", "REMOVED", "output=wallpaper")
+		}
+	}
+	m.wallpaper = ""
+	return errors.Join(errs...)
+}
+
+func writeMOTD(text string) error {
+	if err := os.MkdirAll(filepath.Dir(MOTDFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(MOTDFile, []byte(strings.TrimRight(text, "\n")+"\n"), 0o644)
+}
@@ -0,0 +1,145 @@
+// Package notice keeps a compliance notice in front of the subject while
+// they are locked: the failure score, the task to finish and the time
+// left, as the message of the day and, optionally, as the desktop
+// wallpaper.  It is ambient pressure rather than a notification: nothing
+// pops up, the notice is simply there at every login and on an empty
+// desktop, and it goes away, with the wallpaper put back, on unlock.
+//
+// Module is the enforcement module (see package modules) vexd registers
+// when ConfigFile exists.
+package notice
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+var (
+	// ConfigFile enables the notice.  Optional.
+	ConfigFile = "/etc/vex-cli/notice.json"
+
+	// MOTDFile is the motd fragment that holds the notice, apart from the
+	// one package messages writes.
+	MOTDFile = "/run/motd.d/vex-cli-compliance"
+)
+
+// Outputs.
+const (
+	OutputMOTD      = "motd"      // the message of the day at every login
+	OutputWallpaper = "wallpaper" // the GNOME desktop background
+)
+
+// DefaultTemplate is the notice when the config has no template.
+const DefaultTemplate = `COMPLIANCE NOTICE
+Failure score: {{.Score}}{{if .Tier}} ({{.Tier}}){{end}}
+Task: {{.Task}}
+Time remaining: {{.Remaining}}`
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Outputs    []string `json:"outputs,omitempty"`    // default motd
+	Template   string   `json:"template,omitempty"`   // text/template over Notice; default DefaultTemplate
+	Background string   `json:"background,omitempty"` // wallpaper colour, default #1a0000
+	Foreground string   `json:"foreground,omitempty"` // wallpaper text colour, default #ff4040
+
+	parsed *template.Template
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means no
+// notice is shown and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the outputs and parses the template.
+func (c *Config) Validate() error {
+	for _, o := range c.Outputs {
+		if o != OutputMOTD && o != OutputWallpaper {
+			return fmt.Errorf("unknown output %q (use motd or wallpaper)", o)
+		}
+	}
+	text := c.Template
+	if text == "" {
+		text = DefaultTemplate
+	}
+	t, err := template.New("notice").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return err
+	}
+	c.parsed = t
+	return nil
+}
+
+// has reports whether the notice goes to output o.
+func (c *Config) has(o string) bool {
+	if len(c.Outputs) == 0 {
+		return o == OutputMOTD
+	}
+	for _, x := range c.Outputs {
+		if x == o {
+			return true
+		}
+	}
+	return false
+}
+
+// Notice is what a template can use.
+type Notice struct {
+	Score     int
+	Tier      string
+	Task      string // the writing task's phrase and progress, or the penance task's status
+	Remaining string // until a lockuntil deadline, or "until the task is done"
+}
+
+// Due reports whether st calls for the notice: locked and not paused.
+func Due(st *state.SystemState) bool {
+	return st.Compliance.Locked && st.Pause == nil
+}
+
+// From describes st at now.
+func From(st *state.SystemState, now time.Time) Notice {
+	n := Notice{
+		Score:     st.Compliance.FailureScore,
+		Tier:      st.Compliance.Tier,
+		Task:      "penance " + strings.ReplaceAll(st.Compliance.TaskStatus, "_", " "),
+		Remaining: "until the task is done",
+	}
+	if w := st.Writing; w.Active {
+		n.Task = fmt.Sprintf("write %q (%d/%d lines)", w.Phrase, w.Completed, w.Required)
+	}
+	if until, err := time.Parse(time.RFC3339, st.Compliance.LockUntil); err == nil {
+		if left := until.Sub(now).Round(time.Minute); left > 0 {
+			n.Remaining = strings.TrimSuffix(left.String(), "0s")
+		}
+	}
+	return n
+}
+
+// Render is the notice text for st at now.
+func (c *Config) Render(st *state.SystemState, now time.Time) (string, error) {
+	var b strings.Builder
+	if err := c.parsed.Execute(&b, From(st, now)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,138 @@
+package notice
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+	"github.com/adumbdinosaur/vex-cli/internal/state"
+)
+
+func lockedState() *state.SystemState {
+	st := &state.SystemState{}
+	st.Compliance.Locked = true
+	st.Compliance.FailureScore = 42
+	st.Compliance.Tier = "severe"
+	st.Compliance.LockUntil = "2026-01-01T14:00:00Z"
+	st.Writing.Active = true
+	st.Writing.Phrase = "I will focus"
+	st.Writing.Completed = 3
+	st.Writing.Required = 50
+	return st
+}
+
+func TestRender(t *testing.T) {
+	c := &Config{}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 11, 30, 0, 0, time.UTC)
+	text, err := c.Render(lockedState(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Failure score: 42 (severe)", `write "I will focus" (3/50 lines)`, "Time remaining: 2h30m"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("notice %q lacks %q", text, want)
+		}
+	}
+
+	if err := (&Config{Outputs: []string{"banner"}}).Validate(); err == nil {
+		t.Error("unknown output accepted")
+	}
+	if err := (&Config{Template: "{{.Score"}).Validate(); err == nil {
+		t.Error("bad template accepted")
+	}
+}
+
+func TestModuleMOTD(t *testing.T) {
+	MOTDFile = filepath.Join(t.TempDir(), "motd.d", "vex-cli-compliance")
+	SavedFile = filepath.Join(t.TempDir(), "wallpaper-saved.json")
+	c := &Config{Template: "score {{.Score}}"}
+	c.Validate()
+	m := NewModule(c)
+
+	st := lockedState()
+	if err := m.Apply(st); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(MOTDFile); string(data) != "score 42\n" {
+		t.Errorf("motd = %q", data)
+	}
+	if err := m.Verify(st); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	st.Pause = &state.PauseState{}
+	if err := m.Apply(st); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(MOTDFile); !os.IsNotExist(err) {
+		t.Error("notice left in the motd while paused")
+	}
+}
+
+func TestWallpaper(t *testing.T) {
+	WallpaperDir = t.TempDir()
+	SavedFile = filepath.Join(t.TempDir(), "wallpaper-saved.json")
+	settings := map[string]string{
+		"picture-uri":      "'file:///home/sub/a.jpg'",
+		"picture-uri-dark": "'file:///home/sub/b.jpg'",
+	}
+	old := sessionOutput
+	sessionOutput = func(name string, args ...string) ([]byte, error) {
+		switch args[0] {
+		case "get":
+			return []byte(settings[args[2]] + "\n"), nil
+		case "set":
+			settings[args[2]] = args[3]
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() { sessionOutput = old })
+
+	c := &Config{}
+	now := time.Now()
+	first, err := c.showWallpaper("one", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.showWallpaper("two", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["picture-uri"] != "file://"+second {
+		t.Errorf("picture-uri = %q, want the second notice", settings["picture-uri"])
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Error("earlier notice not removed")
+	}
+
+	if err := hideWallpaper(); err != nil {
+		t.Fatal(err)
+	}
+	if settings["picture-uri"] != "'file:///home/sub/a.jpg'" || settings["picture-uri-dark"] != "'file:///home/sub/b.jpg'" {
+		t.Errorf("wallpaper not restored: %v", settings)
+	}
+	if _, err := os.Stat(SavedFile); !os.IsNotExist(err) {
+		t.Error("saved wallpaper left behind")
+	}
+}
+
+func TestWallpaperNoSession(t *testing.T) {
+	WallpaperDir = t.TempDir()
+	SavedFile = filepath.Join(t.TempDir(), "wallpaper-saved.json")
+	old := sessionOutput
+	sessionOutput = func(string, ...string) ([]byte, error) { return nil, desktop.ErrNoSession }
+	t.Cleanup(func() { sessionOutput = old })
+
+	if _, err := (&Config{}).showWallpaper("x", time.Now()); err != desktop.ErrNoSession {
+		t.Errorf("showWallpaper = %v, want ErrNoSession", err)
+	}
+	if _, err := os.Stat(SavedFile); !os.IsNotExist(err) {
+		t.Error("nothing saved without a session, yet SavedFile written")
+	}
+}
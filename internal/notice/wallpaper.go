@@ -0,0 +1,137 @@
+package notice
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/desktop"
+)
+
+var (
+	// WallpaperDir holds the generated wallpapers.  Each notice gets a
+	// new file, as GNOME does not reload a picture-uri it already shows.
+	WallpaperDir = "/run/vex-cli/wallpaper"
+
+	// SavedFile keeps the subject's own wallpaper while the notice
+	// replaces it, so that a restart in between does not lose it.
+	SavedFile = "/var/lib/vex-cli/wallpaper-saved.json"
+)
+
+// backgroundKeys are the GNOME settings the notice replaces.
+var backgroundKeys = []string{"picture-uri", "picture-uri-dark"}
+
+const backgroundSchema = "org.gnome.desktop.background"
+
+// sessionOutput runs a command in the subject's session; replaced in
+// tests.
+var sessionOutput = desktop.Output
+
+// svg draws text as a 1920x1080 wallpaper, each line centred.
+func (c *Config) svg(text string) []byte {
+	bg, fg := c.Background, c.Foreground
+	if bg == "" {
+		bg = "#1a0000"
+	}
+	if fg == "" {
+		fg = "#ff4040"
+	}
+	lines := strings.Split(text, "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 1920 1080" width="1920" height="1080">`+"\n")
+	fmt.Fprintf(&b, `<rect width="1920" height="1080" fill="%s"/>`+"\n", escape(bg))
+	fmt.Fprintf(&b, `<text x="960" y="%d" fill="%s" font-family="monospace" font-size="48" text-anchor="middle">`+"\n", 540-len(lines)*34, escape(fg))
+	for _, l := range lines {
+		fmt.Fprintf(&b, `<tspan x="960" dy="68">%s</tspan>`+"\n", escape(l))
+	}
+	b.WriteString("</text>\n</svg>\n")
+	return []byte(b.String())
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// showWallpaper writes text as a new wallpaper and makes it the
+// background, saving the subject's own first.  It returns the file.
+func (c *Config) showWallpaper(text string, now time.Time) (string, error) {
+	if _, err := os.Stat(SavedFile); os.IsNotExist(err) {
+		saved := make(map[string]string)
+		for _, k := range backgroundKeys {
+			out, err := sessionOutput("gsettings", "get", backgroundSchema, k)
+			if err != nil {
+				return "", err
+			}
+			saved[k] = strings.TrimSpace(string(out))
+		}
+		data, _ := json.Marshal(saved)
+		if err := os.WriteFile(SavedFile, data, 0o600); err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(WallpaperDir, 0o755); err != nil {
+		return "", err
+	}
+	file := filepath.Join(WallpaperDir, fmt.Sprintf("notice-%d.svg", now.Unix()))
+	if err := os.WriteFile(file, c.svg(text), 0o644); err != nil {
+		return "", err
+	}
+	for _, k := range backgroundKeys {
+		if _, err := sessionOutput("gsettings", "set", backgroundSchema, k, "file://"+file); err != nil {
+			return "", err
+		}
+	}
+	removeWallpapers(file)
+	return file, nil
+}
+
+// hideWallpaper puts the subject's own wallpaper back.  Without a saved
+// one there is nothing to undo.
+func hideWallpaper() error {
+	data, err := os.ReadFile(SavedFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %w", SavedFile, err)
+	}
+	var errs []error
+	for _, k := range backgroundKeys {
+		v, ok := saved[k]
+		if !ok {
+			continue
+		}
+		// gsettings get prints a GVariant, e.g. 'file:///...'; set takes
+		// the same form back.
+		if _, err := sessionOutput("gsettings", "set", backgroundSchema, k, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	removeWallpapers("")
+	return os.Remove(SavedFile)
+}
+
+// removeWallpapers deletes the generated wallpapers other than keep.
+func removeWallpapers(keep string) {
+	old, _ := filepath.Glob(filepath.Join(WallpaperDir, "notice-*.svg"))
+	for _, f := range old {
+		if f != keep {
+			os.Remove(f)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package notify tells the keyholder about events that need their
+// attention, such as an exception request waiting for approval, a killed
+// process or a failed penance.  Events can be filtered by name.  Chat
+// bridges register as additional sinks and get the same events.
+//
+// Messages are POSTed as JSON to the URL in ConfigFile.  When a secret
+// is configured the body is signed with HMAC-SHA256 in SignatureHeader,
+// the same scheme hostsync uses, so the receiving end can tell genuine
+// messages from forged ones.  Delivery is best-effort and never blocks
+// the caller; failures are logged.
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ConfigFile holds the keyholder's notification endpoint.
+	ConfigFile = "/etc/vex-cli/notify.json"
+
+	// Timeout bounds a single delivery attempt.
+	Timeout = 10 * time.Second
+
+	// Retries is how many times a failed delivery is retried.  Like
+	// RetryDelay it is read when an event is sent, so a change does not
+	// reach deliveries already under way.
+	Retries = 3
+
+	// RetryDelay is the wait before the first retry; it doubles after each.
+	RetryDelay = 5 * time.Second
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body.
+const SignatureHeader = "X-Vex-Signature"
+
+// Config is the contents of ConfigFile.  Events lists the event names
+// to send, as globs such as "exception_*"; empty sends everything.
+type Config struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// matches reports whether a filter lets event through; an empty filter
+// lets everything through.
+func matches(filter []string, event string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, pattern := range filter {
+		if ok, _ := path.Match(pattern, event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFilter checks that every pattern in an event filter is a
+// valid glob.
+func ValidateFilter(filter []string) error {
+	for _, pattern := range filter {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("event %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Sink receives the events that pass its filter, alongside the webhook.
+type Sink struct {
+	Name   string
+	Events []string
+	Send   func(m Message) error
+}
+
+// Message is the JSON body sent to the keyholder.
+type Message struct {
+	Event   string            `json:"event"`
+	Host    string            `json:"host"`
+	Time    string            `json:"time"` // RFC3339
+	Details map[string]string `json:"details,omitempty"`
+}
+
+var (
+	mu         sync.Mutex
+	cfg        *Config
+	sinks      []Sink
+	httpClient = &http.Client{Timeout: Timeout}
+
+	// pending counts the deliveries under way, for the tests to wait on.
+	pending sync.WaitGroup
+)
+
+// Init loads ConfigFile.  A missing file leaves notifications disabled.
+func Init() error {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		log.Printf("Notify: Disabled (%s not found)", ConfigFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return fmt.Errorf("%s: url must be http:// or https://", ConfigFile)
+	}
+	if err := ValidateFilter(c.Events); err != nil {
+		return fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	Configure(&c)
+	log.Printf("Notify: Keyholder notifications go to %s", c.URL)
+	return nil
+}
+
+// Configure sets the endpoint directly; nil disables notifications.
+func Configure(c *Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg = c
+}
+
+// AddSink registers an additional receiver of events.
+func AddSink(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks = append(sinks, s)
+}
+
+// Enabled reports whether a keyholder endpoint or sink is configured.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return cfg != nil || len(sinks) > 0
+}
+
+// Keyholder sends an event to the keyholder in the background.  It
+// reports whether a message was queued anywhere at all.
+func Keyholder(event string, details map[string]string) bool {
+	mu.Lock()
+	c := cfg
+	targets := slices.Clone(sinks)
+	retry := retryPolicy{delay: RetryDelay, retries: Retries}
+	mu.Unlock()
+
+	host, _ := os.Hostname()
+	msg := Message{Event: event, Host: host, Time: time.Now().UTC().Format(time.RFC3339), Details: details}
+	queued := false
+	if c != nil && matches(c.Events, event) {
+		pending.Add(1)
+		go retry.deliver("webhook", event, func() error { return send(c, &msg) })
+		queued = true
+	}
+	for _, s := range targets {
+		if matches(s.Events, event) {
+			pending.Add(1)
+			go retry.deliver(s.Name, event, func() error { return s.Send(msg) })
+			queued = true
+		}
+	}
+	return queued
+}
+
+// retryPolicy is RetryDelay and Retries as they were when an event was
+// sent.
+type retryPolicy struct {
+	delay   time.Duration
+	retries int
+}
+
+// deliver retries fn with backoff.
+func (p retryPolicy) deliver(to, event string, fn func() error) {
+	defer pending.Done()
+	delay := p.delay
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if attempt >= p.retries {
+			log.Printf("Notify: giving up on %s to %s: %v", event, to, err)
+			return
+		}
+		log.Printf("Notify: %s to %s failed (retrying in %s): %v", event, to, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// String renders the message as chat text: one line of key=value pairs,
+// followed by any multi-line values (such as a report) in full.
+func (m Message) String() string {
+	var keys, blocks []string
+	for k, v := range m.Details {
+		switch {
+		case strings.Contains(v, "\n"):
+			blocks = append(blocks, k)
+		case v != "":
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(blocks)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s on %s", m.Event, m.Host)
+	for i, k := range keys {
+		if i == 0 {
+			sb.WriteString(":")
+		}
+		fmt.Fprintf(&sb, " %s=%s", k, m.Details[k])
+	}
+	for _, k := range blocks {
+		sb.WriteString("\n\n" + strings.TrimRight(m.Details[k], "\n"))
+	}
+	return sb.String()
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func send(c *Config, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(c.Secret), body))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", c.URL, resp.Status)
+	}
+	return nil
+}
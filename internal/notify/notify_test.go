@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyholder_SignedDelivery(t *testing.T) {
+	got := make(chan Message, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign([]byte("s3cret"), body) {
+			t.Error("Signature header does not match the body")
+		}
+		var m Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Errorf("Malformed body: %v", err)
+		}
+		got <- m
+	}))
+	defer srv.Close()
+
+	Configure(&Config{URL: srv.URL, Secret: "s3cret"})
+	defer Configure(nil)
+
+	if !Keyholder("exception_requested", map[string]string{"id": "ab12"}) {
+		t.Fatal("Expected the message to be queued")
+	}
+	select {
+	case m := <-got:
+		if m.Event != "exception_requested" || m.Details["id"] != "ab12" {
+			t.Errorf("Unexpected message: %+v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message was not delivered")
+	}
+}
+
+func TestKeyholder_Retries(t *testing.T) {
+	defer func(d time.Duration) { RetryDelay = d }(RetryDelay)
+	RetryDelay = time.Millisecond
+	calls := make(chan int32, 4)
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := n.Add(1)
+		calls <- attempt
+		if attempt < 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	Configure(&Config{URL: srv.URL})
+	defer Configure(nil)
+	defer pending.Wait()
+
+	Keyholder("test", nil)
+	for want := 1; want <= 2; want++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected attempt %d", want)
+		}
+	}
+}
+
+func TestKeyholder_EventFilter(t *testing.T) {
+	Configure(&Config{URL: "http://127.0.0.1:9", Events: []string{"exception_*", "kill"}})
+	defer Configure(nil)
+	defer func(d time.Duration, n int) { RetryDelay, Retries = d, n }(RetryDelay, Retries)
+	RetryDelay, Retries = time.Millisecond, 0 // nothing listens; give up quietly
+	defer pending.Wait()
+
+	if !Keyholder("exception_approved", nil) || !Keyholder("kill", nil) {
+		t.Error("Expected events matching the filter to be sent")
+	}
+	if Keyholder("completion", nil) {
+		t.Error("Expected an event outside the filter to be dropped")
+	}
+}
+
+func TestKeyholder_Disabled(t *testing.T) {
+	Configure(nil)
+	if Enabled() || Keyholder("test", nil) {
+		t.Error("Expected nothing to be sent without a configured endpoint")
+	}
+}
+
+func TestKeyholder_Sink(t *testing.T) {
+	got := make(chan Message, 1)
+	AddSink(Sink{Name: "chat", Events: []string{"kill"}, Send: func(m Message) error {
+		got <- m
+		return nil
+	}})
+	defer func() { sinks = nil }()
+
+	if Keyholder("completion", nil) {
+		t.Error("Expected an event outside the sink's filter to be dropped")
+	}
+	if !Keyholder("kill", map[string]string{"app": "steam", "pid": "42"}) {
+		t.Fatal("Expected the sink to take the event")
+	}
+	select {
+	case m := <-got:
+		if s := m.String(); !strings.HasPrefix(s, "kill on ") || !strings.HasSuffix(s, ": app=steam pid=42") {
+			t.Errorf("Unexpected chat text %q", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sink was not called")
+	}
+}
@@ -1,14 +1,22 @@
 package penance
 
 import (
+	"bufio"
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/events"
 	"github.com/adumbdinosaur/vex-cli/internal/guardian"
 	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
 	"github.com/adumbdinosaur/vex-cli/internal/throttler"
@@ -69,6 +77,7 @@ type TaskConstraints struct {
 type SystemStateOverrides struct {
 	Network NetworkState `json:"network"`
 	Compute ComputeState `json:"compute"`
+	Media   MediaState   `json:"media"`
 }
 
 type NetworkState struct {
@@ -78,23 +87,109 @@ type NetworkState struct {
 }
 
 type ComputeState struct {
-	CPULimit     int `json:"cpu_limit_pct"`
-	OOMScoreAdj  int `json:"oom_score_adj"`
-	InputLatency int `json:"input_latency_ms"`
+	CPULimit        int    `json:"cpu_limit_pct"`
+	OOMScoreAdj     int    `json:"oom_score_adj"`
+	InputLatency    int    `json:"input_latency_ms"`
+	InputLatencyMax int    `json:"input_latency_max_ms,omitempty"` // > input_latency_ms enables jitter
+	InputLockMin    int    `json:"input_lock_minutes,omitempty"`   // forced break before the task
+	Priority        string `json:"priority,omitempty"`             // "low", "lowest" or "idle": a softer alternative to a CPU cap
+	GPULimit        int    `json:"gpu_limit_pct,omitempty"`        // GPU power/clock cap; applied by vexd's gpu module
+}
+
+// MediaState caps the backlight and audio; vexd's media module enforces
+// it, not the penance package.
+type MediaState struct {
+	BrightnessPct int  `json:"brightness_pct,omitempty"`
+	VolumePct     int  `json:"volume_pct,omitempty"`
+	Mute          bool `json:"mute,omitempty"`
 }
 
 type EscalationMatrix struct {
-	Thresholds map[string]EscalationLevel `json:"score_thresholds"`
+	Thresholds        map[string]EscalationLevel `json:"score_thresholds"`
+	CategoryPenalties map[string]int             `json:"category_penalties,omitempty"` // points a failure adds, by category; default FailurePenalty
+	SessionLocks      []SessionLock              `json:"session_locks,omitempty"`
+}
+
+// SessionLock locks the subject's graphical session, switches the
+// console to a penance VT or forces a break once Event has happened Count
+// times within WithinMinutes, e.g. the third forbidden-app kill in an
+// hour.
+type SessionLock struct {
+	Event         string            `json:"event"`                    // event name, a glob as in notify.json, e.g. "kill"
+	When          map[string]string `json:"when,omitempty"`           // details that must be equal, e.g. {"app": "steam"}
+	Count         int               `json:"count,omitempty"`          // default 1
+	WithinMinutes int               `json:"within_minutes,omitempty"` // default 60
+	Action        string            `json:"action,omitempty"`         // SessionLockSession (default), SessionLockVT or SessionLockBreak
+	VT            int               `json:"vt,omitempty"`             // the terminal SessionLockVT switches to
+}
+
+// Session lock actions.
+const (
+	SessionLockSession = "lock"
+	SessionLockVT      = "vt"
+	SessionLockBreak   = "break" // the forced break, if forced-break.json enables it
+)
+
+// Validate rejects a rule without an event, with negative numbers, or
+// with an unknown action or a VT outside 1-63.
+func (l SessionLock) Validate() error {
+	switch {
+	case l.Event == "":
+		return fmt.Errorf("session lock without an event")
+	case !validGlob(l.Event):
+		return fmt.Errorf("session lock: bad event pattern %q", l.Event)
+	case l.Count < 0 || l.WithinMinutes < 0:
+		return fmt.Errorf("session lock on %s: count and within_minutes must not be negative", l.Event)
+	case l.Action != "" && l.Action != SessionLockSession && l.Action != SessionLockVT && l.Action != SessionLockBreak:
+		return fmt.Errorf("session lock on %s: action %q is not lock, vt or break", l.Event, l.Action)
+	case l.Action == SessionLockVT && (l.VT < 1 || l.VT > 63):
+		return fmt.Errorf("session lock on %s: vt must be 1-63", l.Event)
+	}
+	return nil
+}
+
+// Matches reports whether an event with the given name and details
+// counts towards the rule.
+func (l SessionLock) Matches(name string, details map[string]string) bool {
+	if ok, _ := path.Match(l.Event, name); !ok {
+		return false
+	}
+	for k, v := range l.When {
+		if details[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func validGlob(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// Threshold is how many matching events trigger the rule.
+func (l SessionLock) Threshold() int {
+	return max(l.Count, 1)
+}
+
+// Window is how far back matching events are counted.
+func (l SessionLock) Window() time.Duration {
+	if l.WithinMinutes > 0 {
+		return time.Duration(l.WithinMinutes) * time.Minute
+	}
+	return time.Hour
 }
 
 type EscalationLevel struct {
-	TaskPool []string `json:"task_pool"`
-	Latency  int      `json:"latency"`
+	TaskPool  []string `json:"task_pool"`
+	TopicPool []string `json:"topic_pool,omitempty"` // essay topics, one drawn per task; default the active penance's topic
+	Latency   int      `json:"latency"`
+	Jitter    int      `json:"jitter_ms,omitempty"` // widens the latency range at this score
 }
 
-// -- Constants --
+// -- Paths --
 
-const (
+var (
 	ConfigDir    = "/etc/vex-cli"
 	ManifestFile = ConfigDir + "/penance-manifest.json"
 )
@@ -137,6 +232,108 @@ func Init() error {
 	return nil
 }
 
+// ReloadManifest reads ManifestFile again and makes it CurrentManifest.
+// With enforce set (the system is locked), the overrides that differ from
+// the previous manifest are enforced and the rest are left alone, so a
+// reload neither resets restrictions changed since nor imposes the input
+// blackout again.  It returns the names of the overrides it enforced:
+// "network", "cpu", "priority", "gpu", "oom", "latency", "keys" and
+// "media".
+func ReloadManifest(enforce bool) ([]string, error) {
+	m, err := LoadManifest(ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load manifest: %w", err)
+	}
+	old := CurrentManifest
+	CurrentManifest = m
+	log.Printf("Penance: Reloaded Manifest %s for %s", m.Version, m.Meta.TargetID)
+	if !enforce || old == nil {
+		return nil, nil
+	}
+	return m.enforceChanges(old)
+}
+
+// enforceChanges enforces the overrides of m that differ from old.
+func (m *Manifest) enforceChanges(old *Manifest) ([]string, error) {
+	var changed []string
+	var errs []error
+	o, n := old.Overrides, m.Overrides
+
+	if o.Network != n.Network {
+		changed = append(changed, "network")
+		log.Printf("Penance: Enforcing Network Profile: %s (Packet Loss: %.2f%%)", n.Network.Profile, n.Network.PacketLoss)
+		if err := throttler.ApplyNetworkProfileWithEntropy(throttler.Profile(n.Network.Profile), float32(n.Network.PacketLoss)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply network profile with entropy: %w", err))
+		}
+	}
+	if o.Compute.CPULimit != n.Compute.CPULimit && n.Compute.CPULimit > 0 {
+		changed = append(changed, "cpu")
+		log.Printf("Penance: Setting CPU Limit: %d%%", n.Compute.CPULimit)
+		if err := throttler.SetCPULimit(n.Compute.CPULimit); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set cpu limit: %w", err))
+		}
+	}
+	if o.Compute.Priority != n.Compute.Priority {
+		changed = append(changed, "priority")
+		level := cmp.Or(n.Compute.Priority, throttler.PriorityNormal)
+		log.Printf("Penance: Setting Priority: %s", level)
+		if err := throttler.SetPriority(level); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set priority: %w", err))
+		}
+	}
+	if len(changed) > 0 {
+		if err := throttler.SaveState(&throttler.ThrottlerState{
+			ActiveProfile: n.Network.Profile,
+			PacketLossPct: float32(n.Network.PacketLoss),
+			CPULimitPct:   n.Compute.CPULimit,
+			ChangedBy:     "penance",
+		}); err != nil {
+			log.Printf("Penance: Warning - failed to persist throttler state: %v", err)
+		}
+	}
+	if o.Media != n.Media {
+		changed = append(changed, "media") // applied by vexd through the state
+	}
+	if o.Compute.GPULimit != n.Compute.GPULimit {
+		changed = append(changed, "gpu") // likewise
+	}
+	if o.Compute.OOMScoreAdj != n.Compute.OOMScoreAdj {
+		changed = append(changed, "oom")
+		log.Printf("Penance: Adjusting OOM Score: %d", n.Compute.OOMScoreAdj)
+		if err := guardian.SetOOMScore(n.Compute.OOMScoreAdj); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set oom score: %w", err))
+		}
+	}
+
+	score := 0
+	if cs, err := LoadComplianceStatus(); err == nil {
+		score = cs.FailureScore
+	}
+	oldMin, oldMax := old.LatencyRange(score)
+	if minMs, maxMs := m.LatencyRange(score); minMs != oldMin || maxMs != oldMax {
+		changed = append(changed, "latency")
+		var err error
+		if maxMs > minMs {
+			log.Printf("Penance: Injecting Input Latency Jitter: %d-%dms", minMs, maxMs)
+			err = surveillance.InjectJitter(minMs, maxMs)
+		} else {
+			log.Printf("Penance: Injecting Input Latency: %dms", minMs)
+			err = surveillance.InjectLatency(minMs)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to inject input latency: %w", err))
+		}
+	}
+
+	if allow := m.Active.Constraints.AllowBackspace; allow != old.Active.Constraints.AllowBackspace {
+		changed = append(changed, "keys")
+		if err := surveillance.SetKeySuppression(!allow); err != nil {
+			errs = append(errs, fmt.Errorf("failed to set editing key suppression: %w", err))
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
 // IsPenaltyActive returns whether the system currently has an active penalty.
 // Returns true as a fail-safe if compliance status cannot be determined.
 func IsPenaltyActive() bool {
@@ -154,7 +351,7 @@ func DefaultManifest() *Manifest {
 		Version: "1.0-DEFAULT",
 		Meta: ManifestMeta{
 			TargetID:      "unset",
-			LastUpdated:   time.Now().UTC().Format(time.RFC3339),
+			LastUpdated:   clock.Now().UTC().Format(time.RFC3339),
 			Authorization: "none",
 		},
 		Overrides: SystemStateOverrides{
@@ -235,6 +432,13 @@ func (m *Manifest) EnforceState() error {
 		}
 	}
 
+	if overrides.Compute.Priority != "" {
+		log.Printf("Penance: Setting Priority: %s", overrides.Compute.Priority)
+		if err := throttler.SetPriority(overrides.Compute.Priority); err != nil {
+			return fmt.Errorf("failed to set priority: %w", err)
+		}
+	}
+
 	// Persist the enforced state so it survives reboots
 	state := &throttler.ThrottlerState{
 		ActiveProfile: overrides.Network.Profile,
@@ -254,20 +458,46 @@ func (m *Manifest) EnforceState() error {
 		}
 	}
 
-	// 3. Input Latency
-	if overrides.Compute.InputLatency > 0 {
-		log.Printf("Penance: Injecting Input Latency: %dms", overrides.Compute.InputLatency)
-		if err := surveillance.InjectLatency(overrides.Compute.InputLatency); err != nil {
+	// 3. Input Latency (jittered when a range is configured or the
+	// escalation matrix adds jitter at the current score)
+	score := 0
+	if cs, err := LoadComplianceStatus(); err == nil {
+		score = cs.FailureScore
+	}
+	if minMs, maxMs := m.LatencyRange(score); maxMs > minMs {
+		log.Printf("Penance: Injecting Input Latency Jitter: %d-%dms", minMs, maxMs)
+		if err := surveillance.InjectJitter(minMs, maxMs); err != nil {
+			return fmt.Errorf("failed to inject input latency jitter: %w", err)
+		}
+	} else if minMs > 0 {
+		log.Printf("Penance: Injecting Input Latency: %dms", minMs)
+		if err := surveillance.InjectLatency(minMs); err != nil {
 			return fmt.Errorf("failed to inject input latency: %w", err)
 		}
 	}
 
+	// 4. Input Blackout
+	if overrides.Compute.InputLockMin > 0 {
+		log.Printf("Penance: Imposing Input Blackout: %d minutes", overrides.Compute.InputLockMin)
+		if _, err := surveillance.StartInputBlackout(time.Duration(overrides.Compute.InputLockMin) * time.Minute); err != nil {
+			return fmt.Errorf("failed to start input blackout: %w", err)
+		}
+	}
+
+	// 5. Editing keys
+	if !m.Active.Constraints.AllowBackspace {
+		log.Println("Penance: Suppressing Backspace/Delete/paste keys")
+		if err := surveillance.SetKeySuppression(true); err != nil {
+			return fmt.Errorf("failed to suppress editing keys: %w", err)
+		}
+	}
+
 	return nil
 }
 
 // -- Compliance Status Tracking --
 
-var complianceStatusFile = ConfigDir + "/compliance-status.json"
+var ComplianceStatusFile = ConfigDir + "/compliance-status.json"
 
 // ComplianceStatus tracks the subject's compliance state and failure score
 type ComplianceStatus struct {
@@ -278,11 +508,64 @@ type ComplianceStatus struct {
 	TotalFailures  int    `json:"total_failures"`
 	TotalCompleted int    `json:"total_completed"`
 	Locked         bool   `json:"locked"`
+	LockUntil      string `json:"lock_until,omitempty"`   // RFC3339; stays locked until then regardless of tasks
+	LastFailure    string `json:"last_failure,omitempty"` // RFC3339 time of the latest failure; ends the streak
+	LastKill       string `json:"last_kill,omitempty"`    // RFC3339 time the guardian last killed a forbidden app; also ends it
+	Topic          string `json:"topic,omitempty"`        // drawn from the topic pool for the open task
+
+	Categories map[string]*CategoryTally `json:"categories,omitempty"` // failures by category
+}
+
+// CategoryTally is the failure record of one category.
+type CategoryTally struct {
+	Count  int    `json:"count"`
+	Points int    `json:"points"` // added to the failure score in total
+	Last   string `json:"last"`   // RFC3339
+	Reason string `json:"reason"` // of the last failure
+}
+
+// CountFailure adds a failure to its category's tally.
+func (cs *ComplianceStatus) CountFailure(category, reason string, points int) {
+	if cs.Categories == nil {
+		cs.Categories = make(map[string]*CategoryTally)
+	}
+	t := cs.Categories[category]
+	if t == nil {
+		t = &CategoryTally{}
+		cs.Categories[category] = t
+	}
+	t.Count++
+	t.Points += points
+	t.Last = clock.Now().UTC().Format(time.RFC3339)
+	t.Reason = reason
+}
+
+// StreakBroken returns when the run of clean days was last broken: the
+// later of the last failure and the last kill, "" if neither happened.
+func (cs *ComplianceStatus) StreakBroken() string {
+	f, errF := time.Parse(time.RFC3339, cs.LastFailure)
+	k, errK := time.Parse(time.RFC3339, cs.LastKill)
+	switch {
+	case errK != nil:
+		return cs.LastFailure
+	case errF != nil || k.After(f):
+		return cs.LastKill
+	}
+	return cs.LastFailure
+}
+
+// LockedUntil returns the lockuntil deadline if it has not passed yet.
+func (cs *ComplianceStatus) LockedUntil() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, cs.LockUntil)
+	if err != nil || !clock.Now().Before(t) {
+		return time.Time{}, false
+	}
+	return t, true
 }
 
 // LoadComplianceStatus reads the current compliance status from disk
 func LoadComplianceStatus() (*ComplianceStatus, error) {
-	data, err := fsOps.ReadFile(complianceStatusFile)
+	data, err := fsOps.ReadFile(ComplianceStatusFile)
 	if err != nil {
 		// If not found, create default
 		if os.IsNotExist(err) {
@@ -290,7 +573,7 @@ func LoadComplianceStatus() (*ComplianceStatus, error) {
 				FailureScore: 0,
 				TaskStatus:   "pending",
 				Locked:       true,
-				LastUpdated:  time.Now().UTC().Format(time.RFC3339),
+				LastUpdated:  clock.Now().UTC().Format(time.RFC3339),
 			}
 			return cs, nil
 		}
@@ -306,27 +589,105 @@ func LoadComplianceStatus() (*ComplianceStatus, error) {
 
 // SaveComplianceStatus persists the compliance status to disk
 func SaveComplianceStatus(cs *ComplianceStatus) error {
-	cs.LastUpdated = time.Now().UTC().Format(time.RFC3339)
+	cs.LastUpdated = clock.Now().UTC().Format(time.RFC3339)
 	data, err := json.MarshalIndent(cs, "", "  ")
 	if err != nil {
 		return err
 	}
-	return fsOps.WriteFile(complianceStatusFile, data, 0644)
+	return fsOps.WriteFile(ComplianceStatusFile, data, 0644)
 }
 
-// RecordFailure increments the failure score and total failures
+// Failure categories group the free-text reasons failures are recorded
+// with, so that stats and the escalation matrix can tell them apart.
+const (
+	CategoryBackspace    = "backspace"     // backspace in a task that forbids it
+	CategoryPaste        = "paste"         // pasted rather than typed input
+	CategoryDeadline     = "deadline"      // a task left undone past its time
+	CategoryTamper       = "tamper"        // evading or disabling enforcement
+	CategoryForbiddenApp = "forbidden_app" // running a forbidden app
+	CategoryUsage        = "usage"         // exceeding a usage rule
+	CategorySubmission   = "submission"    // a penance submission rejected
+	CategoryOther        = "other"
+)
+
+// FailurePenalty is what a failure adds to the score unless the
+// manifest's category_penalties says otherwise.
+const FailurePenalty = 10
+
+// Categorize returns the category of a failure reason.  The part of the
+// reason after a colon, as in "usage_limit:games", is a detail.
+func Categorize(reason string) string {
+	kind, _, _ := strings.Cut(reason, ":")
+	switch kind {
+	case "backspace_violation":
+		return CategoryBackspace
+	case "paste", "pasted":
+		return CategoryPaste
+	case "lines_abandoned", "deadline_missed":
+		return CategoryDeadline
+	case "tunnel", "input_blackout_escape", "heartbeat_missed", "escalation":
+		return CategoryTamper
+	case "forbidden_app":
+		return CategoryForbiddenApp
+	case "usage_limit":
+		return CategoryUsage
+	case "submission_rejected":
+		return CategorySubmission
+	}
+	return CategoryOther
+}
+
+// Penalty is what a failure of category adds to the score.
+func (m *Manifest) Penalty(category string) int {
+	if p, ok := m.Escalation.CategoryPenalties[category]; ok {
+		return p
+	}
+	return FailurePenalty
+}
+
+// RecordFailure adds the penalty for the reason's category to the failure
+// score and counts the failure in its category.
 func RecordFailure(reason string) error {
 	cs, err := LoadComplianceStatus()
 	if err != nil {
 		return fmt.Errorf("failed to load compliance status: %w", err)
 	}
 
-	cs.FailureScore += 10
+	category := Categorize(reason)
+	points := FailurePenalty
+	if CurrentManifest != nil {
+		points = CurrentManifest.Penalty(category)
+	}
+	previous := cs.FailureScore
+	cs.FailureScore += points
 	cs.TotalFailures++
 	cs.TaskStatus = "failed"
 	cs.Locked = true
+	cs.LastFailure = clock.Now().UTC().Format(time.RFC3339)
+	cs.CountFailure(category, reason, points)
+	topic := ""
+	if cs.Topic == "" && CurrentManifest != nil {
+		topic = CurrentManifest.DrawTopic(cs.FailureScore)
+		cs.Topic = topic
+	}
+
+	log.Printf("Penance: FAILURE recorded (%s, %s). Score: %d", reason, category, cs.FailureScore)
+	if err := SaveComplianceStatus(cs); err != nil {
+		return err
+	}
+	RecordScoreChange(previous, cs.FailureScore, "failure:"+reason)
+	events.Publish(events.Failure{Reason: reason, Category: category, Score: cs.FailureScore, TotalFailures: cs.TotalFailures, Topic: topic})
+	return nil
+}
 
-	log.Printf("Penance: FAILURE recorded (%s). Score: %d", reason, cs.FailureScore)
+// RecordKill notes that the guardian killed a forbidden app, which ends
+// the streak of clean days without touching the failure score.
+func RecordKill() error {
+	cs, err := LoadComplianceStatus()
+	if err != nil {
+		return fmt.Errorf("failed to load compliance status: %w", err)
+	}
+	cs.LastKill = clock.Now().UTC().Format(time.RFC3339)
 	return SaveComplianceStatus(cs)
 }
 
@@ -355,10 +716,18 @@ func RecordCompletion() error {
 
 	cs.TotalCompleted++
 	cs.TaskStatus = "completed"
-	cs.Locked = false
-
-	log.Printf("Penance: Task COMPLETED. Total completions: %d", cs.TotalCompleted)
-	return SaveComplianceStatus(cs)
+	cs.Topic = "" // the next task draws its own
+	if until, ok := cs.LockedUntil(); ok {
+		log.Printf("Penance: Task COMPLETED, but locked until %s", until.Format(time.RFC3339))
+	} else {
+		cs.Locked = false
+		log.Printf("Penance: Task COMPLETED. Total completions: %d", cs.TotalCompleted)
+	}
+	if err := SaveComplianceStatus(cs); err != nil {
+		return err
+	}
+	events.Publish(events.Completion{TotalCompleted: cs.TotalCompleted, Locked: cs.Locked})
+	return nil
 }
 
 // SelectWeightedTask selects a task type based on the current failure score
@@ -370,13 +739,65 @@ func SelectWeightedTask(m *Manifest) string {
 		return m.Active.Type
 	}
 
-	// Find the highest threshold that the failure score exceeds
+	bestThreshold, bestLevel := m.escalationLevel(cs.FailureScore)
+
+	if len(bestLevel.TaskPool) > 0 {
+		// Select from the pool (use deterministic selection based on time for simplicity)
+		idx := int(time.Now().UnixNano()) % len(bestLevel.TaskPool)
+		selected := bestLevel.TaskPool[idx]
+		log.Printf("Penance: Dynamic weighting selected task type '%s' (score: %d, threshold: %s)",
+			selected, cs.FailureScore, bestThreshold)
+		return selected
+	}
+
+	return m.Active.Type
+}
+
+// DrawTopic picks an essay topic at random from the topic pool of the
+// escalation level at score, so that a submission cannot be written
+// before the task is assigned.  It returns "" when the level has no pool.
+func (m *Manifest) DrawTopic(score int) string {
+	_, level := m.escalationLevel(score)
+	if len(level.TopicPool) == 0 {
+		return ""
+	}
+	return level.TopicPool[rand.IntN(len(level.TopicPool))]
+}
+
+// Topic is the essay topic of the open task: the one drawn for it, or the
+// active penance's.
+func (m *Manifest) Topic(cs *ComplianceStatus) string {
+	return cmp.Or(cs.Topic, m.Active.RequiredContent.Topic)
+}
+
+// AssignTopic draws a topic for the open task unless one was drawn
+// already, records it in the compliance status and returns it.  It
+// returns "" when there is no pool to draw from.
+func AssignTopic(m *Manifest) (string, error) {
+	cs, err := LoadComplianceStatus()
+	if err != nil {
+		return "", fmt.Errorf("failed to load compliance status: %w", err)
+	}
+	if cs.Topic != "" {
+		return "", nil
+	}
+	cs.Topic = m.DrawTopic(cs.FailureScore)
+	if cs.Topic == "" {
+		return "", nil
+	}
+	log.Printf("Penance: Assigned topic %q (score: %d)", cs.Topic, cs.FailureScore)
+	return cs.Topic, SaveComplianceStatus(cs)
+}
+
+// escalationLevel returns the highest threshold the failure score reaches
+// and its level.
+func (m *Manifest) escalationLevel(score int) (string, EscalationLevel) {
 	bestThreshold := ""
 	bestLevel := EscalationLevel{}
 	for threshold, level := range m.Escalation.Thresholds {
 		var t int
 		fmt.Sscanf(threshold, "%d", &t)
-		if cs.FailureScore >= t {
+		if score >= t {
 			var bt int
 			fmt.Sscanf(bestThreshold, "%d", &bt)
 			if t >= bt {
@@ -385,17 +806,92 @@ func SelectWeightedTask(m *Manifest) string {
 			}
 		}
 	}
+	return bestThreshold, bestLevel
+}
 
-	if len(bestLevel.TaskPool) > 0 {
-		// Select from the pool (use deterministic selection based on time for simplicity)
-		idx := int(time.Now().UnixNano()) % len(bestLevel.TaskPool)
-		selected := bestLevel.TaskPool[idx]
-		log.Printf("Penance: Dynamic weighting selected task type '%s' (score: %d, threshold: %s)",
-			selected, cs.FailureScore, bestThreshold)
-		return selected
+// LatencyRange returns the input latency range to enforce at the given
+// failure score: the manifest override, widened by the escalation level's
+// jitter.  min == max means a fixed delay.
+func (m *Manifest) LatencyRange(score int) (min, max int) {
+	min = m.Overrides.Compute.InputLatency
+	max = m.Overrides.Compute.InputLatencyMax
+	if max < min {
+		max = min
+	}
+	_, level := m.escalationLevel(score)
+	if level.Jitter > 0 {
+		max += level.Jitter
 	}
+	return min, max
+}
 
-	return m.Active.Type
+// -- Score History --
+
+// ScoreHistoryFile keeps every change of the failure score, oldest first,
+// as JSON lines.  Changes are rare, so nothing is ever pruned.
+var ScoreHistoryFile = "/var/lib/vex-cli/score-history.jsonl"
+
+// ScoreChange is one change of the failure score.
+type ScoreChange struct {
+	Time  time.Time `json:"t"`
+	From  int       `json:"from"`
+	To    int       `json:"to"`
+	Cause string    `json:"cause"` // "failure:<reason>", "escalation", "reset" or "sync:<host>"
+}
+
+var scoreHistoryMu sync.Mutex
+
+// RecordScoreChange appends a change of the failure score from one value
+// to another to ScoreHistoryFile.  Nothing is recorded when the score did
+// not change.  Failing to record is logged, not returned: the change
+// itself has already been saved.
+func RecordScoreChange(from, to int, cause string) {
+	if from == to {
+		return
+	}
+	scoreHistoryMu.Lock()
+	defer scoreHistoryMu.Unlock()
+
+	line, _ := json.Marshal(ScoreChange{Time: clock.Now().UTC(), From: from, To: to, Cause: cause})
+	err := os.MkdirAll(filepath.Dir(ScoreHistoryFile), 0755)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(ScoreHistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Penance: Could not record score change %d -> %d: %v", from, to, err)
+	}
+}
+
+// ReadScoreChanges returns the changes recorded at or after from, oldest
+// first.  A missing file has none; a line cut short by a crash is
+// skipped.
+func ReadScoreChanges(from time.Time) ([]ScoreChange, error) {
+	scoreHistoryMu.Lock()
+	defer scoreHistoryMu.Unlock()
+
+	f, err := os.Open(ScoreHistoryFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []ScoreChange
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var c ScoreChange
+		if json.Unmarshal(sc.Bytes(), &c) == nil && !c.Time.IsZero() && !c.Time.Before(from) {
+			out = append(out, c)
+		}
+	}
+	return out, sc.Err()
 }
 
 // -- Submission Validation --
@@ -432,7 +928,9 @@ func ValidateSubmission(text string, m *Manifest) *ValidationResult {
 
 	// 3. KPM validation (checked against surveillance metrics)
 	if constraints.EnforceRhythm && constraints.MinKPM > 0 {
-		kpm := surveillance.GetCurrentKPM()
+		// The long window spans a typical submission without being diluted
+		// by idle time before the task started.
+		_, kpm := surveillance.GetRollingKPM()
 		if kpm > 0 { // Only validate if we have data
 			if int(kpm) < constraints.MinKPM {
 				result.Valid = false
@@ -450,6 +948,102 @@ func ValidateSubmission(text string, m *Manifest) *ValidationResult {
 	return result
 }
 
+// Progress is where a submission in the making stands against the active
+// penance: what it lacks so far, and rhythm warnings that would fail it
+// if it were submitted now.
+type Progress struct {
+	Words    int
+	MinWords int
+	Missing  []string // required phrases not written yet
+	Warnings []string
+}
+
+// CheckProgress measures text, the lines written so far, against the
+// active penance as ValidateSubmission would, without failing anything.
+func CheckProgress(text string, m *Manifest) Progress {
+	req := m.Active.RequiredContent
+	constraints := m.Active.Constraints
+	p := Progress{Words: len(strings.Fields(text)), MinWords: req.MinWordCount}
+	for _, phrase := range req.ValidationStrings {
+		if !strings.Contains(text, phrase) {
+			p.Missing = append(p.Missing, phrase)
+		}
+	}
+	if constraints.EnforceRhythm && constraints.MinKPM > 0 {
+		if _, kpm := surveillance.GetRollingKPM(); kpm > 0 {
+			if int(kpm) < constraints.MinKPM {
+				p.Warnings = append(p.Warnings, fmt.Sprintf("Typing too slow: %.1f KPM (minimum: %d KPM)", kpm, constraints.MinKPM))
+			}
+			if constraints.MaxKPM > 0 && int(kpm) > constraints.MaxKPM {
+				p.Warnings = append(p.Warnings, fmt.Sprintf("Typing too fast: %.1f KPM (maximum: %d KPM)", kpm, constraints.MaxKPM))
+			}
+		}
+	}
+	return p
+}
+
+// -- Typing Test --
+
+// TypingPassages are used when a typing test is started without text.
+var TypingPassages = []string{
+	"I will respect the limits that have been set for me and account for every minute I spend at this machine.",
+	"Discipline is choosing between what you want now and what you want most. I choose to complete my tasks first.",
+	"Every keystroke in this test is recorded directly from the keyboard. There is no shortcut and no paste.",
+	"I acknowledge that my failure score reflects my own choices, and that only consistent compliance will lower it.",
+}
+
+// MinTypingAccuracy is the fraction of characters that must match the
+// target for a typing test to pass.
+var MinTypingAccuracy = 0.95
+
+// PickTypingPassage returns a passage for a new typing test.
+func PickTypingPassage() string {
+	return TypingPassages[int(time.Now().UnixNano()%int64(len(TypingPassages)))]
+}
+
+// TypingAccuracy compares typed text with the target position by position
+// and returns the fraction of the target typed correctly.
+func TypingAccuracy(target, typed string) float64 {
+	t, y := []rune(target), []rune(typed)
+	if len(t) == 0 {
+		return 0
+	}
+	correct := 0
+	for i := range t {
+		if i < len(y) && y[i] == t[i] {
+			correct++
+		}
+	}
+	return float64(correct) / float64(len(t))
+}
+
+// ValidateTypingTest checks a typing test captured from the keyboard
+// against accuracy and the active penance constraints.
+func ValidateTypingTest(target string, c surveillance.TypingCapture, constraints TaskConstraints) *ValidationResult {
+	result := &ValidationResult{Valid: true}
+	fail := func(format string, args ...any) {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	if acc := TypingAccuracy(target, c.Text); acc < MinTypingAccuracy {
+		fail("Accuracy too low: %.1f%% (minimum: %.0f%%)", acc*100, MinTypingAccuracy*100)
+	}
+	if !constraints.AllowBackspace && c.Backspaces > 0 {
+		fail("Backspace used %d time(s); corrections are not allowed", c.Backspaces)
+	}
+	if constraints.EnforceRhythm {
+		kpm := c.KPM()
+		if constraints.MinKPM > 0 && int(kpm) < constraints.MinKPM {
+			fail("Typing speed too slow: %.1f KPM (minimum: %d KPM)", kpm, constraints.MinKPM)
+		}
+		if constraints.MaxKPM > 0 && int(kpm) > constraints.MaxKPM {
+			fail("Typing speed suspiciously fast: %.1f KPM (maximum: %d KPM)", kpm, constraints.MaxKPM)
+		}
+	}
+	return result
+}
+
 // ValidateLineInput checks a single line for the allow_backspace constraint.
 // Returns true if the line is valid, false if a backspace was detected.
 func ValidateLineInput(line string, constraints TaskConstraints) bool {
@@ -3,6 +3,9 @@ package penance
 import (
 	"os"
 	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
 )
 
 type MockFileSystem struct {
@@ -164,3 +167,356 @@ func TestTaskLifecycle_PendingToInProgressToCompleted(t *testing.T) {
 		t.Errorf("Expected total_completed 1, got %d", cs.TotalCompleted)
 	}
 }
+
+func TestRecordCompletion_LockUntil(t *testing.T) {
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	statusJSON := `{"task_status":"in_progress","locked":true,"lock_until":"` + until + `"}`
+	var savedData []byte
+
+	mockFS := &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if savedData != nil {
+				return savedData, nil
+			}
+			return []byte(statusJSON), nil
+		},
+		WriteFileFunc: func(name string, data []byte, perm os.FileMode) error {
+			savedData = data
+			return nil
+		},
+	}
+	fsOps = mockFS
+
+	if err := RecordCompletion(); err != nil {
+		t.Fatalf("RecordCompletion failed: %v", err)
+	}
+	cs, err := LoadComplianceStatus()
+	if err != nil {
+		t.Fatalf("LoadComplianceStatus failed: %v", err)
+	}
+	if cs.TaskStatus != "completed" || cs.TotalCompleted != 1 {
+		t.Errorf("Expected the completion to be recorded, got %s/%d", cs.TaskStatus, cs.TotalCompleted)
+	}
+	if !cs.Locked {
+		t.Error("Completing a task must not unlock before lock_until")
+	}
+
+	// Once the deadline has passed, completion unlocks as usual.
+	cs.LockUntil = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if err := SaveComplianceStatus(cs); err != nil {
+		t.Fatalf("SaveComplianceStatus failed: %v", err)
+	}
+	if _, ok := cs.LockedUntil(); ok {
+		t.Error("Expected a past deadline to be inactive")
+	}
+	if err := RecordCompletion(); err != nil {
+		t.Fatalf("RecordCompletion failed: %v", err)
+	}
+	if cs, _ = LoadComplianceStatus(); cs.Locked {
+		t.Error("Expected unlock after the deadline")
+	}
+}
+
+func TestRecordKill_StreakBroken(t *testing.T) {
+	var savedData []byte
+	fsOps = &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if savedData != nil {
+				return savedData, nil
+			}
+			return []byte(`{"failure_score":20,"last_failure":"2026-01-01T10:00:00Z"}`), nil
+		},
+		WriteFileFunc: func(name string, data []byte, perm os.FileMode) error {
+			savedData = data
+			return nil
+		},
+	}
+
+	cs, _ := LoadComplianceStatus()
+	if got := cs.StreakBroken(); got != "2026-01-01T10:00:00Z" {
+		t.Errorf("StreakBroken() = %q, want the last failure", got)
+	}
+	if err := RecordKill(); err != nil {
+		t.Fatalf("RecordKill failed: %v", err)
+	}
+	cs, _ = LoadComplianceStatus()
+	if cs.FailureScore != 20 {
+		t.Errorf("A kill must not change the failure score, got %d", cs.FailureScore)
+	}
+	if got := cs.StreakBroken(); got != cs.LastKill || got == "" {
+		t.Errorf("StreakBroken() = %q, want the kill at %q", got, cs.LastKill)
+	}
+	if got := (&ComplianceStatus{}).StreakBroken(); got != "" {
+		t.Errorf("StreakBroken() = %q with no failure or kill, want empty", got)
+	}
+}
+
+func TestRecordFailure_Categories(t *testing.T) {
+	var savedData []byte
+	fsOps = &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if savedData != nil {
+				return savedData, nil
+			}
+			return []byte(`{"failure_score":0}`), nil
+		},
+		WriteFileFunc: func(name string, data []byte, perm os.FileMode) error {
+			savedData = data
+			return nil
+		},
+	}
+	ScoreHistoryFile = t.TempDir() + "/score-history.jsonl"
+	m := DefaultManifest()
+	m.Escalation.CategoryPenalties = map[string]int{CategoryTamper: 40}
+	CurrentManifest = m
+	defer func() { CurrentManifest = nil }()
+
+	for _, reason := range []string{"backspace_violation", "tunnel:wg0", "backspace_violation"} {
+		if err := RecordFailure(reason); err != nil {
+			t.Fatalf("RecordFailure(%s) failed: %v", reason, err)
+		}
+	}
+	cs, _ := LoadComplianceStatus()
+	if cs.FailureScore != 60 || cs.TotalFailures != 3 {
+		t.Errorf("Expected 10+40+10 from 3 failures, got %d from %d", cs.FailureScore, cs.TotalFailures)
+	}
+	if b := cs.Categories[CategoryBackspace]; b == nil || b.Count != 2 || b.Points != 20 {
+		t.Errorf("Unexpected backspace tally %+v", b)
+	}
+	if tt := cs.Categories[CategoryTamper]; tt == nil || tt.Count != 1 || tt.Reason != "tunnel:wg0" {
+		t.Errorf("Unexpected tamper tally %+v", tt)
+	}
+}
+
+func TestScoreHistory(t *testing.T) {
+	ScoreHistoryFile = t.TempDir() + "/score-history.jsonl"
+	if changes, err := ReadScoreChanges(time.Time{}); changes != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", changes, err)
+	}
+
+	RecordScoreChange(0, 10, "failure:backspace_violation")
+	RecordScoreChange(10, 10, "failure:ignored") // no change
+	RecordScoreChange(10, 20, "escalation")
+	RecordScoreChange(20, 0, "reset")
+
+	changes, err := ReadScoreChanges(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("ReadScoreChanges: %v", err)
+	}
+	if len(changes) != 3 || changes[0].To != 10 || changes[1].Cause != "escalation" || changes[2].From != 20 || changes[2].To != 0 {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+	if changes, _ := ReadScoreChanges(time.Now().Add(time.Minute)); len(changes) != 0 {
+		t.Errorf("Expected nothing after now, got %+v", changes)
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	for reason, want := range map[string]string{
+		"backspace_violation":   CategoryBackspace,
+		"usage_limit:games":     CategoryUsage,
+		"lines_abandoned":       CategoryDeadline,
+		"input_blackout_escape": CategoryTamper,
+		"submission_rejected":   CategorySubmission,
+		"something_new":         CategoryOther,
+	} {
+		if got := Categorize(reason); got != want {
+			t.Errorf("Categorize(%q) = %q, want %q", reason, got, want)
+		}
+	}
+}
+
+func TestSessionLock(t *testing.T) {
+	l := SessionLock{Event: "kill", When: map[string]string{"app": "steam"}, Count: 3}
+	if err := l.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if !l.Matches("kill", map[string]string{"app": "steam", "pid": "10"}) {
+		t.Error("Expected a steam kill to match")
+	}
+	if l.Matches("kill", map[string]string{"app": "firefox"}) || l.Matches("failure", map[string]string{"app": "steam"}) {
+		t.Error("Expected other apps and events not to match")
+	}
+	if err := (SessionLock{Event: "failure", Action: SessionLockBreak}).Validate(); err != nil {
+		t.Errorf("Expected a break rule to be valid, got %v", err)
+	}
+	if l.Threshold() != 3 || l.Window() != time.Hour {
+		t.Errorf("Got count %d within %s, want 3 within 1h", l.Threshold(), l.Window())
+	}
+
+	for _, bad := range []SessionLock{
+		{},
+		{Event: "[kill"},
+		{Event: "kill", Count: -1},
+		{Event: "kill", Action: "reboot"},
+		{Event: "kill", Action: SessionLockVT},
+	} {
+		if bad.Validate() == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestLatencyRange(t *testing.T) {
+	m := &Manifest{
+		Overrides: SystemStateOverrides{Compute: ComputeState{InputLatency: 50, InputLatencyMax: 200}},
+		Escalation: EscalationMatrix{Thresholds: map[string]EscalationLevel{
+			"0":   {},
+			"100": {Jitter: 100},
+			"250": {Jitter: 300},
+		}},
+	}
+
+	tests := []struct {
+		score    int
+		min, max int
+	}{
+		{0, 50, 200},
+		{150, 50, 300},
+		{400, 50, 500},
+	}
+	for _, tt := range tests {
+		min, max := m.LatencyRange(tt.score)
+		if min != tt.min || max != tt.max {
+			t.Errorf("score %d: got %d-%dms, want %d-%dms", tt.score, min, max, tt.min, tt.max)
+		}
+	}
+
+	// A fixed latency gains jitter only from the escalation matrix.
+	m.Overrides.Compute.InputLatencyMax = 0
+	if min, max := m.LatencyRange(0); min != 50 || max != 50 {
+		t.Errorf("Expected fixed 50ms at score 0, got %d-%dms", min, max)
+	}
+}
+
+func TestTopicPool(t *testing.T) {
+	var savedData []byte
+	fsOps = &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if savedData != nil {
+				return savedData, nil
+			}
+			return []byte(`{"failure_score":0}`), nil
+		},
+		WriteFileFunc: func(name string, data []byte, perm os.FileMode) error {
+			savedData = data
+			return nil
+		},
+	}
+	ScoreHistoryFile = t.TempDir() + "/score-history.jsonl"
+	m := DefaultManifest()
+	m.Active.RequiredContent.Topic = "Fixed topic"
+	m.Escalation.Thresholds = map[string]EscalationLevel{
+		"0":  {},
+		"10": {TopicPool: []string{"Obedience", "Patience"}},
+	}
+	CurrentManifest = m
+	defer func() { CurrentManifest = nil }()
+
+	if got := m.DrawTopic(0); got != "" {
+		t.Errorf("Expected no topic without a pool, got %q", got)
+	}
+	if err := RecordFailure("backspace_violation"); err != nil {
+		t.Fatalf("RecordFailure failed: %v", err)
+	}
+	cs, _ := LoadComplianceStatus()
+	first := cs.Topic
+	if first != "Obedience" && first != "Patience" {
+		t.Fatalf("Expected a topic from the pool, got %q", first)
+	}
+	// A second failure keeps the topic the open task was assigned.
+	RecordFailure("backspace_violation")
+	if cs, _ = LoadComplianceStatus(); cs.Topic != first || m.Topic(cs) != first {
+		t.Errorf("Expected the topic to stay %q, got %q", first, cs.Topic)
+	}
+	if topic, err := AssignTopic(m); err != nil || topic != "" {
+		t.Errorf("AssignTopic with a topic drawn: got %q, %v", topic, err)
+	}
+
+	RecordCompletion()
+	if cs, _ = LoadComplianceStatus(); cs.Topic != "" || m.Topic(cs) != "Fixed topic" {
+		t.Errorf("Expected completion to clear the topic, got %q", cs.Topic)
+	}
+	if topic, err := AssignTopic(m); err != nil || topic == "" {
+		t.Errorf("AssignTopic after completion: got %q, %v", topic, err)
+	}
+}
+
+func TestCheckProgress(t *testing.T) {
+	m := DefaultManifest()
+	m.Active.RequiredContent = ContentRequirements{MinWordCount: 10, ValidationStrings: []string{"I comply", "never again"}}
+
+	p := CheckProgress("I comply with the rules\nset for me\n", m)
+	if p.Words != 8 || p.MinWords != 10 {
+		t.Errorf("Expected 8/10 words, got %d/%d", p.Words, p.MinWords)
+	}
+	if len(p.Missing) != 1 || p.Missing[0] != "never again" {
+		t.Errorf("Expected only \"never again\" missing, got %v", p.Missing)
+	}
+	if len(p.Warnings) != 0 {
+		t.Errorf("Expected no rhythm warnings without KPM data, got %v", p.Warnings)
+	}
+}
+
+func TestValidateTypingTest(t *testing.T) {
+	target := "the quick brown fox"
+	start := time.Unix(1_700_000_000, 0)
+	c := surveillance.TypingCapture{
+		Text:       target,
+		Keystrokes: 19,
+		First:      start,
+		Last:       start.Add(6 * time.Second), // 190 KPM
+	}
+	constraints := TaskConstraints{AllowBackspace: false, EnforceRhythm: true, MinKPM: 100, MaxKPM: 400}
+
+	if r := ValidateTypingTest(target, c, constraints); !r.Valid {
+		t.Errorf("Expected a clean test to pass, got %v", r.Errors)
+	}
+
+	c.Backspaces = 1
+	if r := ValidateTypingTest(target, c, constraints); r.Valid {
+		t.Error("Expected backspace use to fail when disallowed")
+	}
+	c.Backspaces = 0
+
+	c.Text = "the quick brown"
+	if r := ValidateTypingTest(target, c, constraints); r.Valid {
+		t.Error("Expected an incomplete test to fail on accuracy")
+	}
+
+	if acc := TypingAccuracy("abcd", "abxd"); acc != 0.75 {
+		t.Errorf("Expected accuracy 0.75, got %.2f", acc)
+	}
+}
+
+func TestReloadManifest(t *testing.T) {
+	manifest := `{"manifest_version": "1", "active_penance": {"task_id": "A"}}`
+	fsOps = &MockFileSystem{
+		ReadFileFunc: func(name string) ([]byte, error) {
+			if name == ManifestFile {
+				return []byte(manifest), nil
+			}
+			return nil, os.ErrNotExist
+		},
+	}
+	CurrentManifest = nil
+	defer func() { CurrentManifest = nil }()
+
+	if changed, err := ReloadManifest(true); err != nil || changed != nil {
+		t.Fatalf("Expected the first load to enforce nothing, got %v, %v", changed, err)
+	}
+
+	manifest = `{"manifest_version": "2", "active_penance": {"task_id": "B"}}`
+	if changed, err := ReloadManifest(true); err != nil || changed != nil {
+		t.Errorf("Expected a new task alone to enforce nothing, got %v, %v", changed, err)
+	}
+	if CurrentManifest.Active.TaskID != "B" {
+		t.Errorf("Expected the reloaded manifest to be current, got task %s", CurrentManifest.Active.TaskID)
+	}
+
+	manifest = `{"manifest_version": "3", "active_penance": {"task_id": "B", "constraints": {"allow_backspace": true}}}`
+	defer surveillance.SetKeySuppression(false)
+	if changed, err := ReloadManifest(true); err != nil || len(changed) != 1 || changed[0] != "keys" {
+		t.Errorf("Expected only the editing keys to be enforced, got %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,170 @@
+// Package push sends short push notifications through ntfy or Gotify.
+//
+// It is a lighter alternative to the chat bots: each configured target
+// receives the keyholder events that pass its filter as a titled one-line
+// message, so the subject's phone can get "task assigned" and "deadline
+// approaching" while the keyholder's gets "tamper detected".
+package push
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+)
+
+var (
+	// ConfigFile lists the push targets.  Optional.
+	ConfigFile = "/etc/vex-cli/push.json"
+
+	// Timeout bounds a single delivery.
+	Timeout = 10 * time.Second
+)
+
+// Target is one ntfy topic or Gotify application.
+type Target struct {
+	Service string   `json:"service"`          // ntfy or gotify
+	URL     string   `json:"url"`              // ntfy: the topic URL; gotify: the server URL
+	Token   string   `json:"token,omitempty"`  // ntfy access token, or the Gotify application token
+	Events  []string `json:"events,omitempty"` // as in notify.json; empty sends all
+}
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Targets []Target `json:"targets"`
+}
+
+// titles are the notification titles for known events.
+var titles = map[string]string{
+	"kill":                 "Forbidden process killed",
+	"failure":              "Penance failed",
+	"escalation":           "Tamper detected",
+	"unlock":               "Restrictions lifted",
+	"completion":           "Task completed",
+	"task_assigned":        "Task assigned",
+	"task_abandoned":       "Task abandoned",
+	"tier_changed":         "Compliance tier changed",
+	"credits_spent":        "Credits spent",
+	"deadline_approaching": "Deadline approaching",
+	"budget_low":           "Usage budget running out",
+	"checkin_missed":       "Heartbeat missed",
+	"checkin_resumed":      "Heartbeat restored",
+	"line_rejected":        "Line rejected",
+	"streak":               "Streak milestone",
+	"exception_requested":  "Exception requested",
+	"exception_withdrawn":  "Exception withdrawn",
+	"exception_approved":   "Exception approved",
+	"report":               "Compliance report",
+	"session_locked":       "Session locked",
+	"forced_break":         "Forced break",
+	"session_freeze":       "Session freeze",
+	"usb_device":           "USB device plugged in",
+	"domain_warning":       "Monitored site visited",
+}
+
+// urgent events are sent with high priority.
+var urgent = map[string]bool{"escalation": true, "failure": true, "deadline_approaching": true, "checkin_missed": true, "forced_break": true, "session_freeze": true}
+
+var httpClient = &http.Client{Timeout: Timeout}
+
+// Init reads ConfigFile and registers each target as a notification
+// sink.  A missing file leaves push notifications off.
+func Init() error {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	for i, t := range c.Targets {
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("%s: target %d: %w", ConfigFile, i+1, err)
+		}
+	}
+	for _, t := range c.Targets {
+		notify.AddSink(notify.Sink{Name: t.Service, Events: t.Events, Send: t.Send})
+		log.Printf("Push: Sending notifications to %s (%s)", t.Service, t.host())
+	}
+	return nil
+}
+
+// Validate checks the service and URL.
+func (t Target) Validate() error {
+	if t.Service != "ntfy" && t.Service != "gotify" {
+		return fmt.Errorf("service must be ntfy or gotify")
+	}
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an http:// or https:// URL")
+	}
+	if t.Service == "gotify" && t.Token == "" {
+		return fmt.Errorf("gotify needs the application token")
+	}
+	return notify.ValidateFilter(t.Events)
+}
+
+func (t Target) host() string {
+	u, _ := url.Parse(t.URL)
+	return u.Host
+}
+
+// Send delivers one message.
+func (t Target) Send(m notify.Message) error {
+	title := titles[m.Event]
+	if title == "" {
+		title = m.Event
+	}
+	body := m.String()
+
+	var req *http.Request
+	var err error
+	switch t.Service {
+	case "ntfy":
+		req, err = http.NewRequest(http.MethodPost, t.URL, bytes.NewBufferString(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", title)
+		req.Header.Set("Tags", "vex-cli,"+m.Event)
+		if urgent[m.Event] {
+			req.Header.Set("Priority", "high")
+		}
+		if t.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+t.Token)
+		}
+	case "gotify":
+		priority := 5
+		if urgent[m.Event] {
+			priority = 8
+		}
+		payload, _ := json.Marshal(map[string]any{"title": title, "message": body, "priority": priority})
+		req, err = http.NewRequest(http.MethodPost, strings.TrimSuffix(t.URL, "/")+"/message", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", t.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", t.Service, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package push
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/notify"
+)
+
+func TestSend_Ntfy(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer srv.Close()
+
+	target := Target{Service: "ntfy", URL: srv.URL + "/vex-alerts", Token: "tk"}
+	err := target.Send(notify.Message{Event: "escalation", Host: "laptop", Details: map[string]string{"score": "40"}})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got.URL.Path != "/vex-alerts" || got.Header.Get("Title") != "Tamper detected" ||
+		got.Header.Get("Priority") != "high" || got.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("Unexpected request %s %v", got.URL.Path, got.Header)
+	}
+	if body != "escalation on laptop: score=40" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestSend_Gotify(t *testing.T) {
+	var got struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	var path, key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, key = r.URL.Path, r.Header.Get("X-Gotify-Key")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	target := Target{Service: "gotify", URL: srv.URL + "/", Token: "app"}
+	if err := target.Send(notify.Message{Event: "task_assigned", Host: "laptop"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if path != "/message" || key != "app" || got.Title != "Task assigned" || got.Priority != 5 {
+		t.Errorf("Unexpected request to %s (key %q): %+v", path, key, got)
+	}
+}
+
+func TestTarget_Validate(t *testing.T) {
+	for _, bad := range []Target{
+		{Service: "pushover", URL: "https://api.pushover.net"},
+		{Service: "ntfy", URL: "ntfy.sh/topic"},
+		{Service: "gotify", URL: "https://gotify.example.com"},
+		{Service: "ntfy", URL: "https://ntfy.sh/topic", Events: []string{"[kill"}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
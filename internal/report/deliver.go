@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/scheduler"
+)
+
+// ConfigFile schedules automatic reports.  Optional.
+var ConfigFile = "/etc/vex-cli/report.json"
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Period string      `json:"period,omitempty"` // day (default) or week
+	At     string      `json:"at"`               // HH:MM local time
+	Days   []string    `json:"days,omitempty"`   // days to send on; empty = every day
+	Post   bool        `json:"post,omitempty"`   // send as a "report" notification (webhook, chat)
+	Email  *MailConfig `json:"email,omitempty"`
+}
+
+// MailConfig is an SMTP server and the report's recipients.
+type MailConfig struct {
+	SMTP     string   `json:"smtp"` // host:port; STARTTLS is used when offered
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means no
+// reports are scheduled and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the schedule and that the report goes somewhere.
+func (c *Config) Validate() error {
+	if _, err := ParsePeriod(c.Period, time.Now()); err != nil {
+		return err
+	}
+	if _, err := scheduler.ParseClock(c.At); err != nil {
+		return err
+	}
+	if _, err := scheduler.ParseDays(strings.Join(c.Days, ",")); err != nil {
+		return err
+	}
+	if !c.Post && c.Email == nil {
+		return fmt.Errorf("set post, email or both")
+	}
+	if m := c.Email; m != nil {
+		if _, _, err := net.SplitHostPort(m.SMTP); err != nil {
+			return fmt.Errorf("email.smtp: %w", err)
+		}
+		if m.From == "" || len(m.To) == 0 {
+			return fmt.Errorf("email needs from and to")
+		}
+	}
+	return nil
+}
+
+// LastDue returns the most recent scheduled send time at or before now.
+func (c *Config) LastDue(now time.Time) time.Time {
+	mins, _ := scheduler.ParseClock(c.At)
+	days, _ := scheduler.ParseDays(strings.Join(c.Days, ","))
+	for back := 0; back <= 7; back++ {
+		d := now.AddDate(0, 0, -back)
+		t := time.Date(d.Year(), d.Month(), d.Day(), mins/60, mins%60, 0, 0, now.Location())
+		if t.After(now) || !onDay(days, t.Weekday()) {
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func onDay(days []string, wd time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	name := [...]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}[wd]
+	for _, d := range days {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Mail sends the report to m.To as a text and HTML message.
+func Mail(m *MailConfig, r *Report) error {
+	msg, err := mailMessage(m, r)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.SMTP)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.SMTP, auth, m.From, m.To, msg)
+}
+
+func mailMessage(m *MailConfig, r *Report) ([]byte, error) {
+	var rnd [12]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return nil, err
+	}
+	boundary := "vex-" + hex.EncodeToString(rnd[:])
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", r.Subject()))
+	fmt.Fprintf(&b, "Date: %s\r\n", r.Generated.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ kind, body string }{
+		{"text/plain", r.Text()},
+		{"text/html", r.HTML()},
+	} {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; charset=utf-8\r\n", part.kind)
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&b)
+		qp.Write([]byte(part.body))
+		qp.Close()
+		fmt.Fprintf(&b, "\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,198 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+)
+
+const timeLayout = "Mon 01-02 15:04"
+
+// Text renders the report as plain text.
+func (r *Report) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "VEX-CLI %s REPORT: %s\n", strings.ToUpper(r.periodLabel()), r.Host)
+	fmt.Fprintf(&b, "%s to %s\n\n", r.Period.From.Format(timeLayout), r.Period.To.Format(timeLayout))
+
+	fmt.Fprintln(&b, "[COMPLIANCE]")
+	fmt.Fprintf(&b, "  Locked:         %v\n", r.Locked)
+	fmt.Fprintf(&b, "  Failure Score:  %d\n", r.Score)
+	fmt.Fprintf(&b, "  Task Status:    %s\n", r.TaskStatus)
+	fmt.Fprintf(&b, "  Failures:       %d\n", len(r.Failures))
+	fmt.Fprintf(&b, "  Completions:    %d\n", len(r.Completions))
+	fmt.Fprintf(&b, "  Escalations:    %d\n", len(r.Escalations))
+	fmt.Fprintf(&b, "  Kills:          %d\n", r.TotalKills())
+	fmt.Fprintf(&b, "  Screen Time:    %s\n", FormatSeconds(r.ScreenTime()))
+
+	if trend := r.TrendLines(); len(trend) > 0 {
+		fmt.Fprintf(&b, "\n[TREND] one column per %s\n", r.bucketLabel())
+		for _, line := range trend {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	if len(r.ScoreHistory) > 0 {
+		fmt.Fprintln(&b, "\n[SCORE]")
+		for _, p := range r.ScoreHistory {
+			fmt.Fprintf(&b, "  %s  %4d  %s\n", p.Time.Format(timeLayout), p.Score, p.Cause)
+		}
+	}
+	if len(r.Kills) > 0 {
+		fmt.Fprintln(&b, "\n[KILLS]")
+		for _, k := range r.Kills {
+			fmt.Fprintf(&b, "  %-20s %d\n", k.App, k.Count)
+		}
+	}
+	for _, sec := range []struct {
+		title string
+		items []Item
+	}{
+		{"FAILURES", r.Failures},
+		{"COMPLETIONS", r.Completions},
+		{"ESCALATIONS", r.Escalations},
+		{"UNLOCKS", r.Unlocks},
+	} {
+		if len(sec.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n[%s]\n", sec.title)
+		for _, it := range sec.items {
+			fmt.Fprintf(&b, "  %s  %s\n", it.Time.Format(timeLayout), it.Details)
+		}
+	}
+	if len(r.Usage) > 0 {
+		fmt.Fprintln(&b, "\n[SCREEN TIME]")
+		fmt.Fprintf(&b, "  %-12s %-10s %-10s %s\n", "DATE", "SCREEN", "KEYS", "TOP APP")
+		for _, d := range r.Usage {
+			fmt.Fprintf(&b, "  %-12s %-10s %-10d %s\n", d.Date, FormatSeconds(d.ActiveSeconds), d.Keystrokes, orDash(d.TopApp))
+		}
+	}
+	return b.String()
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"when": func(it Item) string { return it.Time.Format(timeLayout) },
+	"secs": FormatSeconds,
+	"dash": orDash,
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Subject}}</title>
+<style>
+body { font-family: sans-serif; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+th { background: #eee; }
+</style></head><body>
+<h1>vex-cli {{.Label}} report: {{.Host}}</h1>
+<p>{{.From}} to {{.To}}</p>
+
+<h2>Compliance</h2>
+<table>
+<tr><th>Locked</th><td>{{.Locked}}</td></tr>
+<tr><th>Failure score</th><td>{{.Score}}</td></tr>
+<tr><th>Task status</th><td>{{.TaskStatus}}</td></tr>
+<tr><th>Failures</th><td>{{len .Failures}}</td></tr>
+<tr><th>Completions</th><td>{{len .Completions}}</td></tr>
+<tr><th>Escalations</th><td>{{len .Escalations}}</td></tr>
+<tr><th>Kills</th><td>{{.TotalKills}}</td></tr>
+<tr><th>Screen time</th><td>{{secs .ScreenTime}}</td></tr>
+</table>
+{{with .TrendLines}}
+<h2>Trend</h2>
+<p>One column per {{$.BucketLabel}}.</p>
+<pre>{{range .}}{{.}}
+{{end}}</pre>
+{{end}}{{with .ScoreHistory}}
+<h2>Score</h2>
+<table><tr><th>Time</th><th>Score</th><th>Cause</th></tr>
+{{range .}}<tr><td>{{.Time.Format "Mon 01-02 15:04"}}</td><td>{{.Score}}</td><td>{{.Cause}}</td></tr>
+{{end}}</table>
+{{end}}{{with .Kills}}
+<h2>Kills</h2>
+<table><tr><th>App</th><th>Count</th></tr>
+{{range .}}<tr><td>{{.App}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}{{range .Sections}}{{if .Items}}
+<h2>{{.Title}}</h2>
+<table><tr><th>Time</th><th>Details</th></tr>
+{{range .Items}}<tr><td>{{when .}}</td><td>{{.Details}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{with .Usage}}
+<h2>Screen time</h2>
+<table><tr><th>Date</th><th>Screen</th><th>Keys</th><th>Top app</th></tr>
+{{range .}}<tr><td>{{.Date}}</td><td>{{secs .ActiveSeconds}}</td><td>{{.Keystrokes}}</td><td>{{dash .TopApp}}</td></tr>
+{{end}}</table>
+{{end}}
+</body></html>
+`))
+
+// HTML renders the report as a standalone HTML page.
+func (r *Report) HTML() string {
+	type section struct {
+		Title string
+		Items []Item
+	}
+	data := struct {
+		*Report
+		Label, From, To string
+		BucketLabel     string
+		Sections        []section
+	}{
+		Report:      r,
+		Label:       r.periodLabel(),
+		BucketLabel: r.bucketLabel(),
+		From:        r.Period.From.Format(timeLayout),
+		To:          r.Period.To.Format(timeLayout),
+		Sections: []section{
+			{"Failures", r.Failures},
+			{"Completions", r.Completions},
+			{"Escalations", r.Escalations},
+			{"Unlocks", r.Unlocks},
+		},
+	}
+	var b bytes.Buffer
+	if err := htmlReport.Execute(&b, data); err != nil {
+		return "<p>" + template.HTMLEscapeString(err.Error()) + "</p>"
+	}
+	return b.String()
+}
+
+// TrendLines draws the failure score, kills, screen time and time spent
+// locked over the period as sparklines, or nothing without history.
+func (r *Report) TrendLines() []string {
+	recorded := false
+	for _, p := range r.Trend {
+		recorded = recorded || p.Samples > 0
+	}
+	if !recorded {
+		return nil
+	}
+	var lines []string
+	for _, m := range []struct {
+		label  string
+		metric func(history.Point) float64
+	}{
+		{"Score", func(p history.Point) float64 { return float64(p.Score) }},
+		{"Kills", func(p history.Point) float64 { return float64(p.Kills) }},
+		{"Screen", func(p history.Point) float64 { return p.ActiveSeconds }},
+		{"Locked", func(p history.Point) float64 { return float64(p.LockedPct) }},
+	} {
+		lines = append(lines, fmt.Sprintf("%-6s  %s", m.label, history.Sparkline(history.Series(r.Trend, m.metric))))
+	}
+	return lines
+}
+
+func (r *Report) bucketLabel() string {
+	if r.Period.Name == "week" {
+		return "6 hours"
+	}
+	return "hour"
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,213 @@
+// Package report compiles daily and weekly compliance reports.
+//
+// A report is built from the audit log (including rotated archives): the
+// failure score over the period, failures and completions, killed
+// processes, escalations and everything that lifted restrictions.  The
+// caller adds the daily screen time and the current compliance figures,
+// which are not in the log.  Reports render as plain text or HTML.
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+)
+
+// Period is the time range a report covers.
+type Period struct {
+	Name string // "day" or "week"
+	From time.Time
+	To   time.Time
+}
+
+// ParsePeriod returns the day or week ending at now.
+func ParsePeriod(name string, now time.Time) (Period, error) {
+	switch name {
+	case "", "day":
+		return Period{Name: "day", From: now.AddDate(0, 0, -1), To: now}, nil
+	case "week":
+		return Period{Name: "week", From: now.AddDate(0, 0, -7), To: now}, nil
+	}
+	return Period{}, fmt.Errorf("unknown period %q (use day or week)", name)
+}
+
+// Days is how many calendar days of usage the period touches.
+func (p Period) Days() int {
+	if p.Name == "week" {
+		return 7
+	}
+	return 2 // yesterday and today
+}
+
+// Buckets is how many columns the trend lines have: hours for a day,
+// quarter days for a week.
+func (p Period) Buckets() int {
+	if p.Name == "week" {
+		return 28
+	}
+	return 24
+}
+
+// Item is one logged event.
+type Item struct {
+	Time    time.Time
+	Details string
+}
+
+// ScorePoint is the failure score after an event.
+type ScorePoint struct {
+	Time  time.Time
+	Score int
+	Cause string
+}
+
+// AppCount is how often a forbidden app was killed.
+type AppCount struct {
+	App   string
+	Count int
+}
+
+// Day is one day of screen time.
+type Day struct {
+	Date          string
+	ActiveSeconds float64
+	Keystrokes    uint64
+	TopApp        string
+}
+
+// Report is a compiled compliance report.
+type Report struct {
+	Host      string
+	Period    Period
+	Generated time.Time
+
+	// Current compliance, filled in by the caller.
+	Locked     bool
+	Score      int
+	TaskStatus string
+
+	ScoreHistory []ScorePoint
+	Failures     []Item
+	Completions  []Item
+	Kills        []AppCount
+	Escalations  []Item
+	Unlocks      []Item
+	Usage        []Day           // filled in by the caller
+	Trend        []history.Point // filled in by the caller, Period.Buckets() long
+}
+
+// unlockEvents lifted restrictions in one way or another.
+var unlockEvents = map[string]string{
+	"SYSTEM/RESTRICTIONS_LIFTED": "Unlocked",
+	"SYSTEM/TEMPORARY_UNLOCK":    "Temporary unlock",
+	"SYSTEM/PAUSED":              "Paused",
+	"PENANCE/EARLY_RELEASE":      "Early release",
+	"PENANCE/SCORE_RESET":        "Score reset",
+	"CURFEW/OVERRIDDEN":          "Curfew overridden",
+	"EXCEPTION/APPROVED":         "Exception approved",
+}
+
+// Build compiles the log entries at logPath for p.
+func Build(logPath string, p Period) (*Report, error) {
+	r := &Report{Period: p, Generated: time.Now()}
+	kills := make(map[string]int)
+	err := vexlog.ReadEntries(logPath, p.From, p.To, func(e vexlog.Entry) {
+		key := e.Module + "/" + e.Event
+		f := fields(e.Details)
+		item := Item{Time: e.Time, Details: e.Details}
+		switch key {
+		case "PENANCE/FAILURE", "PENANCE/FAILURE_REPORTED":
+			r.Failures = append(r.Failures, item)
+			r.addScore(e.Time, f["score"], "failure: "+f["reason"])
+		case "PENANCE/COMPLETED":
+			r.Completions = append(r.Completions, item)
+		case "GUARDIAN/KILLED":
+			kills[f["app"]]++
+		case "ANTITAMPER/ESCALATED":
+			r.Escalations = append(r.Escalations, item)
+			r.addScore(e.Time, f["score"], "escalation")
+		}
+		if label, ok := unlockEvents[key]; ok {
+			r.Unlocks = append(r.Unlocks, Item{Time: e.Time, Details: label + ": " + e.Details})
+			if key == "PENANCE/SCORE_RESET" {
+				r.addScore(e.Time, "0", "reset")
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for app, n := range kills {
+		r.Kills = append(r.Kills, AppCount{App: app, Count: n})
+	}
+	sort.Slice(r.Kills, func(i, j int) bool {
+		if r.Kills[i].Count != r.Kills[j].Count {
+			return r.Kills[i].Count > r.Kills[j].Count
+		}
+		return r.Kills[i].App < r.Kills[j].App
+	})
+	return r, nil
+}
+
+func (r *Report) addScore(t time.Time, score, cause string) {
+	if n, err := strconv.Atoi(score); err == nil {
+		r.ScoreHistory = append(r.ScoreHistory, ScorePoint{Time: t, Score: n, Cause: cause})
+	}
+}
+
+// TotalKills is the number of processes killed in the period.
+func (r *Report) TotalKills() int {
+	n := 0
+	for _, k := range r.Kills {
+		n += k.Count
+	}
+	return n
+}
+
+// ScreenTime is the total active time in the period's usage days.
+func (r *Report) ScreenTime() float64 {
+	var secs float64
+	for _, d := range r.Usage {
+		secs += d.ActiveSeconds
+	}
+	return secs
+}
+
+// Subject is a one-line summary, used as the e-mail subject.
+func (r *Report) Subject() string {
+	return fmt.Sprintf("vex-cli %s report for %s: %d failures, %d completions, %d kills",
+		r.periodLabel(), r.Host, len(r.Failures), len(r.Completions), r.TotalKills())
+}
+
+func (r *Report) periodLabel() string {
+	if r.Period.Name == "week" {
+		return "weekly"
+	}
+	return "daily"
+}
+
+// fields parses the key=value pairs of an event's details.
+func fields(details string) map[string]string {
+	f := make(map[string]string)
+	for _, word := range strings.Fields(details) {
+		if k, v, ok := strings.Cut(word, "="); ok {
+			f[k] = v
+		}
+	}
+	return f
+}
+
+// FormatSeconds formats a duration as "3h12m" or "45m".
+func FormatSeconds(secs float64) string {
+	mins := int(secs+30) / 60
+	if mins < 60 {
+		return fmt.Sprintf("%dm", mins)
+	}
+	return fmt.Sprintf("%dh%02dm", mins/60, mins%60)
+}
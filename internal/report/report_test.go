@@ -0,0 +1,126 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+)
+
+func writeLog(t *testing.T, now time.Time, entries map[time.Duration]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vex-cli.log")
+	var b strings.Builder
+	for ago := 8 * 24 * time.Hour; ago >= 0; ago -= time.Minute {
+		if text, ok := entries[ago]; ok {
+			b.WriteString("[VEX-CLI] " + now.Add(-ago).Format("2006/01/02 15:04:05") + " " + text + "\n")
+		}
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	path := writeLog(t, now, map[time.Duration]string{
+		8 * 24 * time.Hour: "[PENANCE] FAILURE: reason=too_old score=10",
+		5 * 24 * time.Hour: "[PENANCE] FAILURE: reason=submission_rejected score=20",
+		4 * 24 * time.Hour: "[ANTITAMPER] ESCALATED: score=40 reasons=binary modified",
+		3 * 24 * time.Hour: "[GUARDIAN] KILLED: app=steam pid=10",
+		2 * 24 * time.Hour: "[GUARDIAN] KILLED: app=steam pid=11",
+		30 * time.Hour:     "[GUARDIAN] KILLED: app=discord pid=12",
+		20 * time.Hour:     "[PENANCE] SCORE_RESET: score 40 -> 0",
+		10 * time.Hour:     "[SYSTEM] TEMPORARY_UNLOCK: until=2024-03-01T22:00:00Z locked=true profile=standard blocked=0",
+		time.Hour:          "[PENANCE] COMPLETED: total_completed=3 locked=false",
+	})
+
+	week, _ := ParsePeriod("week", now)
+	r, err := Build(path, week)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(r.Failures) != 1 || len(r.Escalations) != 1 || len(r.Completions) != 1 || len(r.Unlocks) != 2 {
+		t.Errorf("Unexpected counts: %d failures, %d escalations, %d completions, %d unlocks",
+			len(r.Failures), len(r.Escalations), len(r.Completions), len(r.Unlocks))
+	}
+	if r.TotalKills() != 3 || r.Kills[0].App != "steam" || r.Kills[0].Count != 2 {
+		t.Errorf("Unexpected kills %+v", r.Kills)
+	}
+	var scores []int
+	for _, p := range r.ScoreHistory {
+		scores = append(scores, p.Score)
+	}
+	if len(scores) != 3 || scores[0] != 20 || scores[1] != 40 || scores[2] != 0 {
+		t.Errorf("Score history = %v, want [20 40 0]", scores)
+	}
+
+	day, _ := ParsePeriod("day", now)
+	r, _ = Build(path, day)
+	if len(r.Failures) != 0 || r.TotalKills() != 0 || len(r.Completions) != 1 {
+		t.Errorf("Expected only the last day's events, got %+v", r)
+	}
+
+	r.Host = "<laptop>"
+	if text := r.Text(); !strings.Contains(text, "VEX-CLI DAILY REPORT: <laptop>") || !strings.Contains(text, "[COMPLETIONS]") {
+		t.Errorf("Unexpected text report:\n%s", text)
+	}
+	if html := r.HTML(); !strings.Contains(html, "&lt;laptop&gt;") || strings.Contains(html, "<laptop>") {
+		t.Error("Expected the HTML report to escape its content")
+	}
+	if strings.Contains(r.Text(), "[TREND]") {
+		t.Error("Expected no trend without history")
+	}
+
+	r.Trend = history.Bucket([]history.Sample{
+		{Time: now.Add(-20 * time.Hour), Score: 10, Kills: 2},
+		{Time: now.Add(-2 * time.Hour), Score: 20, Locked: true},
+	}, day.From, day.To, day.Buckets())
+	if text := r.Text(); !strings.Contains(text, "[TREND] one column per hour") || !strings.Contains(text, "Kills       █") {
+		t.Errorf("Unexpected trend:\n%s", text)
+	}
+}
+
+func TestConfig_LastDue(t *testing.T) {
+	c := Config{Period: "week", At: "08:00", Days: []string{"mon"}, Post: true}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	wed := time.Date(2024, 3, 6, 12, 0, 0, 0, time.Local) // a Wednesday
+	if got, want := c.LastDue(wed), time.Date(2024, 3, 4, 8, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("LastDue = %s, want %s", got, want)
+	}
+	mon := time.Date(2024, 3, 4, 7, 59, 0, 0, time.Local)
+	if got, want := c.LastDue(mon), time.Date(2024, 2, 26, 8, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("LastDue just before the send time = %s, want %s", got, want)
+	}
+
+	for _, bad := range []Config{
+		{At: "08:00"},
+		{At: "8am", Post: true},
+		{At: "08:00", Period: "month", Post: true},
+		{At: "08:00", Email: &MailConfig{SMTP: "mail.example.com", From: "a@b", To: []string{"c@d"}}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestMailMessage(t *testing.T) {
+	r := &Report{Host: "laptop", Period: Period{Name: "day"}, Generated: time.Now()}
+	msg, err := mailMessage(&MailConfig{From: "vex@example.com", To: []string{"a@example.com", "b@example.com"}}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(msg)
+	for _, want := range []string{"To: a@example.com, b@example.com\r\n", "multipart/alternative", "text/plain", "text/html"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Expected the message to contain %q", want)
+		}
+	}
+}
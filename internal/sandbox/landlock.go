@@ -0,0 +1,121 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem rights, from linux/landlock.h.
+const (
+	readRights = unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	// fileRights are the only ones a rule on a single file may hold.
+	fileRights = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+)
+
+// handledRights are the filesystem rights the sandbox governs on a kernel
+// with the given Landlock ABI version.  Device ioctls (ABI 5) are left
+// alone.
+func handledRights(abi int) uint64 {
+	rights := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1) // ABI 1: execute … make_sym
+	if abi >= 2 {
+		rights |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		rights |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return rights
+}
+
+// landlockABI asks the kernel for its Landlock ABI version.
+func landlockABI() (int, error) {
+	v, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0, fmt.Errorf("not supported by the kernel: %w", errno)
+	}
+	return int(v), nil
+}
+
+// ProbeLandlock reports the Landlock ABI the sandbox would use.
+func ProbeLandlock() (string, error) {
+	abi, err := landlockABI()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ABI %d", abi), nil
+}
+
+// applyLandlock makes / readable, exec runnable and writable writable,
+// for every thread.  It returns the kernel's Landlock ABI version.
+func applyLandlock(writable, exec []string) (int, error) {
+	abi, err := landlockABI()
+	if err != nil {
+		return 0, err
+	}
+	handled := handledRights(abi)
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return abi, fmt.Errorf("create ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	rules := []struct {
+		paths  []string
+		access uint64
+	}{
+		{[]string{"/"}, readRights},
+		{exec, readRights | unix.LANDLOCK_ACCESS_FS_EXECUTE},
+		{writable, handled &^ unix.LANDLOCK_ACCESS_FS_EXECUTE},
+	}
+	for _, r := range rules {
+		for _, path := range r.paths {
+			if err := addRule(int(fd), path, r.access&handled); err != nil && !errors.Is(err, unix.ENOENT) {
+				return abi, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+		if errno == unix.ENOTSUP {
+			return abi, errors.New("vexd was built with cgo, so not every thread can be restricted")
+		}
+		return abi, fmt.Errorf("no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return abi, fmt.Errorf("restrict: %w", errno)
+	}
+	return abi, nil
+}
+
+// addRule grants access beneath path, or to path alone if it is not a
+// directory.
+func addRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= fileRights
+	}
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
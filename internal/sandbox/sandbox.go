@@ -0,0 +1,66 @@
+// Package sandbox restricts what vexd itself can do once it has started,
+// so that a bug in an IPC handler cannot be turned into arbitrary writes
+// or kernel changes.
+//
+// Landlock limits the filesystem: everything stays readable, but only the
+// Writable paths can be changed and only programs beneath the Exec paths
+// run.  A seccomp filter makes the syscalls vexd never needs after
+// startup, such as loading modules, mounting or setting the clock, fail
+// with EPERM.  Both are inherited by the helpers vexd runs, and neither
+// can be lifted until the process exits.
+//
+// Landlock applies per thread, and Go can only apply it to all of them in
+// a build without cgo.  Elsewhere, or on a kernel without Landlock, that
+// half is skipped and Apply says why.
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+)
+
+var (
+	// Disabled leaves vexd unrestricted.  Set from config.json.
+	Disabled bool
+
+	// ExtraWritable are writable on top of a Policy's own paths.  Set from
+	// config.json.
+	ExtraWritable []string
+)
+
+// Policy is what the sandbox still allows.  Paths that do not exist are
+// skipped.
+type Policy struct {
+	Writable []string // files created, changed and removed beneath these
+	Exec     []string // programs run from beneath these
+}
+
+// Apply restricts the calling process to p.  The two halves are applied
+// independently; the error reports whichever could not be.
+func Apply(p Policy) error {
+	if Disabled {
+		log.Println("Sandbox: disabled in config.json")
+		return nil
+	}
+	var errs []error
+
+	if n, err := applySeccomp(); err != nil {
+		errs = append(errs, fmt.Errorf("seccomp: %w", err))
+	} else {
+		log.Printf("Sandbox: seccomp denies %d syscalls", n)
+	}
+
+	writable := append(append([]string(nil), p.Writable...), ExtraWritable...)
+	if abi, err := applyLandlock(writable, p.Exec); err != nil {
+		errs = append(errs, fmt.Errorf("landlock: %w", err))
+	} else {
+		log.Printf("Sandbox: Landlock ABI %d, %d paths writable", abi, len(writable))
+	}
+
+	err := errors.Join(errs...)
+	vexlog.LogEvent("SANDBOX", "APPLIED", fmt.Sprintf("error=%v", err))
+	return err
+}
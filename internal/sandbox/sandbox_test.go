@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// run interprets the few BPF instructions filter emits against a
+// seccomp_data holding nr and arch.
+func run(t *testing.T, prog []unix.SockFilter, nr, arch uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		in := prog[pc]
+		switch in.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			acc = map[uint32]uint32{offsetNr: nr, offsetArch: arch}[in.K]
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			taken := acc == in.K
+			if in.Code == unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K {
+				taken = acc >= in.K
+			}
+			if taken {
+				pc += int(in.Jt)
+			} else {
+				pc += int(in.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return in.K
+		default:
+			t.Fatalf("Unexpected instruction %+v at %d", in, pc)
+		}
+	}
+	t.Fatal("Program ran off the end")
+	return 0
+}
+
+func TestFilter(t *testing.T) {
+	prog := filter(unix.AUDIT_ARCH_X86_64, true)
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+
+	for _, nr := range denied {
+		if got := run(t, prog, nr, unix.AUDIT_ARCH_X86_64); got != deny {
+			t.Errorf("Expected syscall %d to be denied, got %#x", nr, got)
+		}
+	}
+	for _, nr := range []uint32{unix.SYS_READ, unix.SYS_KILL, unix.SYS_BPF, unix.SYS_EXECVE} {
+		if got := run(t, prog, nr, unix.AUDIT_ARCH_X86_64); got != unix.SECCOMP_RET_ALLOW {
+			t.Errorf("Expected syscall %d to be allowed, got %#x", nr, got)
+		}
+	}
+	if got := run(t, prog, unix.SYS_READ|x32Bit, unix.AUDIT_ARCH_X86_64); got != deny {
+		t.Errorf("Expected an x32 syscall to be denied, got %#x", got)
+	}
+	if got := run(t, prog, unix.SYS_READ, unix.AUDIT_ARCH_I386); got != deny {
+		t.Errorf("Expected a foreign architecture to be denied, got %#x", got)
+	}
+}
+
+func TestHandledRights(t *testing.T) {
+	if handledRights(1)&unix.LANDLOCK_ACCESS_FS_REFER != 0 || handledRights(1)&unix.LANDLOCK_ACCESS_FS_MAKE_SYM == 0 {
+		t.Errorf("Unexpected ABI 1 rights %#x", handledRights(1))
+	}
+	if got := handledRights(5); got&unix.LANDLOCK_ACCESS_FS_TRUNCATE == 0 || got&unix.LANDLOCK_ACCESS_FS_IOCTL_DEV != 0 {
+		t.Errorf("Unexpected ABI 5 rights %#x", got)
+	}
+}
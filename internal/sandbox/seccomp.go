@@ -0,0 +1,111 @@
+package sandbox
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// denied are the syscalls the seccomp filter refuses.  vexd needs none of
+// them once it runs, and the helpers it starts should not either.
+var denied = []uint32{
+	// Kernel code
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD,
+	// Mounts and namespaces
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_OPEN_TREE, unix.SYS_MOVE_MOUNT, unix.SYS_MOUNT_SETATTR,
+	unix.SYS_FSOPEN, unix.SYS_FSCONFIG, unix.SYS_FSMOUNT, unix.SYS_FSPICK,
+	unix.SYS_SETNS, unix.SYS_UNSHARE,
+	// Other processes' memory
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	// The clock, which deadlines and expiries are measured by
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_CLOCK_ADJTIME, unix.SYS_ADJTIMEX,
+	// Everything else system-wide
+	unix.SYS_REBOOT, unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_ACCT,
+	unix.SYS_SETHOSTNAME, unix.SYS_SETDOMAINNAME, unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_ADD_KEY, unix.SYS_KEYCTL, unix.SYS_REQUEST_KEY, unix.SYS_USERFAULTFD,
+}
+
+// x32Bit marks syscalls made through the x32 ABI on amd64, which the
+// filter would otherwise not recognise.
+const x32Bit = 0x40000000
+
+// Offsets into struct seccomp_data.
+const (
+	offsetNr   = 0
+	offsetArch = 4
+)
+
+// applySeccomp installs the filter on every thread and returns the number
+// of syscalls it denies.
+func applySeccomp() (int, error) {
+	var arch uint32
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		arch = unix.AUDIT_ARCH_AARCH64
+	default:
+		return 0, fmt.Errorf("unsupported architecture %s", runtime.GOARCH)
+	}
+	prog := filter(arch, runtime.GOARCH == "amd64")
+
+	// The filter needs no_new_privs on this thread; TSYNC passes both on
+	// to the others.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return 0, fmt.Errorf("no_new_privs: %w", err)
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return 0, errno
+	}
+	if r != 0 {
+		return 0, fmt.Errorf("thread %d could not be synchronised", r)
+	}
+	return len(denied), nil
+}
+
+// filter builds the BPF program: syscalls from another architecture, from
+// the x32 ABI (if x32) or in denied fail with EPERM, the rest are allowed.
+func filter(arch uint32, x32 bool) []unix.SockFilter {
+	ld := func(off uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: off}
+	}
+	ret := func(v uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: v}
+	}
+
+	// Jumps are filled in once the position of the deny return is known:
+	// Jt marks "deny if true", Jf "deny if false".
+	const toDeny = 0xff
+	prog := []unix.SockFilter{
+		ld(offsetArch),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: arch, Jf: toDeny},
+		ld(offsetNr),
+	}
+	if x32 {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, K: x32Bit, Jt: toDeny})
+	}
+	for _, nr := range denied {
+		prog = append(prog, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: nr, Jt: toDeny})
+	}
+	prog = append(prog, ret(unix.SECCOMP_RET_ALLOW), ret(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)))
+
+	deny := len(prog) - 1
+	for i := range prog {
+		if prog[i].Jt == toDeny {
+			prog[i].Jt = uint8(deny - i - 1)
+		}
+		if prog[i].Jf == toDeny {
+			prog[i].Jf = uint8(deny - i - 1)
+		}
+	}
+	return prog
+}
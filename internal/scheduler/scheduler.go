@@ -0,0 +1,333 @@
+// Package scheduler runs time-of-day policies for vexd.
+//
+// A job pairs a predicate ("is the policy in force at time t?") with a
+// callback that is invoked whenever the answer changes.  The scheduler
+// polls rather than arming timers for the next transition, so suspend,
+// clock changes and daemon restarts are all handled the same way: the
+// first tick after any of them sees the current answer and reports it.
+// Callbacks must therefore be idempotent — the first evaluation of a job
+// always fires, even if the daemon already applied that state before a
+// restart.
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// Interval controls how often jobs are evaluated.
+var Interval = 30 * time.Second
+
+// -- Windows --
+
+// Window is a daily time-of-day range in local time.  An End at or before
+// Start wraps past midnight ("23:00"–"07:00").  Days restricts the window
+// to the days it starts on ("mon".."sun"); empty means every day.
+type Window struct {
+	Start string   `json:"start"` // "HH:MM"
+	End   string   `json:"end"`   // "HH:MM"
+	Days  []string `json:"days,omitempty"`
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseClock parses "HH:MM" into minutes after midnight.
+func ParseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hh < 0 || hh > 23 || mm < 0 || mm > 59 {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// ParseDays parses a comma-separated day list such as "mon,tue,fri".
+// The shorthands "weekdays" and "weekends" are accepted.
+func ParseDays(s string) ([]string, error) {
+	var days []string
+	for _, d := range strings.Split(strings.ToLower(s), ",") {
+		switch d = strings.TrimSpace(d); d {
+		case "":
+		case "weekdays":
+			days = append(days, "mon", "tue", "wed", "thu", "fri")
+		case "weekends":
+			days = append(days, "sat", "sun")
+		default:
+			if _, ok := dayNames[d[:min(3, len(d))]]; !ok {
+				return nil, fmt.Errorf("unknown day %q", d)
+			}
+			days = append(days, d[:3])
+		}
+	}
+	return days, nil
+}
+
+// Validate checks that both times parse, that the window is not empty and
+// that every day is known.
+func (w Window) Validate() error {
+	start, err := ParseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := ParseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("window %s-%s is empty", w.Start, w.End)
+	}
+	for _, d := range w.Days {
+		if _, ok := dayNames[d]; !ok {
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	return nil
+}
+
+// Wraps reports whether the window crosses midnight.
+func (w Window) Wraps() bool {
+	start, _ := ParseClock(w.Start)
+	end, _ := ParseClock(w.End)
+	return end <= start
+}
+
+// Contains reports whether t falls inside the window.  An invalid window
+// contains nothing.
+func (w Window) Contains(t time.Time) bool {
+	if w.Validate() != nil {
+		return false
+	}
+	t = t.Local()
+	start, _ := ParseClock(w.Start)
+	end, _ := ParseClock(w.End)
+	now := t.Hour()*60 + t.Minute()
+
+	if !w.Wraps() {
+		return now >= start && now < end && w.onDay(t.Weekday())
+	}
+	// Overnight: the evening half belongs to today's window, the morning
+	// half to the window that started yesterday.
+	if now >= start {
+		return w.onDay(t.Weekday())
+	}
+	if now < end {
+		return w.onDay((t.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+// EndAfter returns the first end of the window after t.
+func (w Window) EndAfter(t time.Time) time.Time {
+	return nextClock(w.End, t)
+}
+
+// StartAfter returns the first start of the window after t, honouring Days.
+func (w Window) StartAfter(t time.Time) time.Time {
+	next := nextClock(w.Start, t)
+	for i := 0; i < 7 && !w.onDay(next.Weekday()); i++ {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (w Window) String() string {
+	s := w.Start + "-" + w.End
+	if len(w.Days) > 0 {
+		s += " " + strings.Join(w.Days, ",")
+	}
+	return s
+}
+
+func (w Window) onDay(d time.Weekday) bool { return onDays(w.Days, d) }
+
+// onDays reports whether d is one of days; empty means every day.
+func onDays(days []string, d time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, name := range days {
+		if dayNames[name] == d {
+			return true
+		}
+	}
+	return false
+}
+
+// nextClock returns the first occurrence of the "HH:MM" clock after t.
+func nextClock(clock string, t time.Time) time.Time {
+	mins, _ := ParseClock(clock)
+	t = t.Local()
+	next := time.Date(t.Year(), t.Month(), t.Day(), mins/60, mins%60, 0, 0, time.Local)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// -- Daily times --
+
+// Daily is a time of day on some days of the week, such as "22:00 daily"
+// or "07:30 weekdays".  Days empty means every day.
+type Daily struct {
+	At   string   `json:"at"` // "HH:MM"
+	Days []string `json:"days,omitempty"`
+}
+
+// ParseDaily parses "HH:MM", optionally followed by "daily" or a day list
+// as ParseDays accepts it.
+func ParseDaily(s string) (Daily, error) {
+	at, days, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if _, err := ParseClock(at); err != nil {
+		return Daily{}, err
+	}
+	d := Daily{At: at}
+	if days = strings.TrimSpace(days); days != "" && days != "daily" {
+		var err error
+		if d.Days, err = ParseDays(days); err != nil {
+			return Daily{}, err
+		}
+	}
+	return d, nil
+}
+
+// Next returns the first occurrence after t.
+func (d Daily) Next(t time.Time) time.Time {
+	next := nextClock(d.At, t)
+	for i := 0; i < 7 && !onDays(d.Days, next.Weekday()); i++ {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Last returns the latest occurrence at or before t.
+func (d Daily) Last(t time.Time) time.Time {
+	mins, _ := ParseClock(d.At)
+	t = t.Local()
+	last := time.Date(t.Year(), t.Month(), t.Day(), mins/60, mins%60, 0, 0, time.Local)
+	if last.After(t) {
+		last = last.AddDate(0, 0, -1)
+	}
+	for i := 0; i < 7 && !onDays(d.Days, last.Weekday()); i++ {
+		last = last.AddDate(0, 0, -1)
+	}
+	return last
+}
+
+func (d Daily) String() string {
+	days := strings.Join(d.Days, ",")
+	switch days {
+	case "":
+		days = "daily"
+	case "mon,tue,wed,thu,fri":
+		days = "weekdays"
+	case "sat,sun":
+		days = "weekends"
+	}
+	return d.At + " " + days
+}
+
+// -- Jobs --
+
+type job struct {
+	active   func(time.Time) bool
+	onChange func(active bool)
+	known    bool
+	last     bool
+}
+
+var (
+	mu        sync.Mutex
+	jobs      = make(map[string]*job)
+	startOnce sync.Once
+)
+
+// Set registers or replaces a named job.  It is evaluated on the next tick
+// and onChange is called with the result, then again whenever it flips.
+func Set(name string, active func(time.Time) bool, onChange func(active bool)) {
+	mu.Lock()
+	defer mu.Unlock()
+	jobs[name] = &job{active: active, onChange: onChange}
+}
+
+// Remove unregisters a job.  Its callback is not invoked.
+func Remove(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(jobs, name)
+}
+
+// Jobs returns the names of the registered jobs, sorted.
+func Jobs() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tick evaluates every job at now and fires the callbacks of those whose
+// state changed.  Predicates and callbacks run without the scheduler lock
+// held, so they may take other locks that are also held around Set and
+// Remove.  Callbacks run in job name order.
+func Tick(now time.Time) {
+	mu.Lock()
+	names := make([]string, 0, len(jobs))
+	pending := make(map[string]*job, len(jobs))
+	for name, j := range jobs {
+		names = append(names, name)
+		pending[name] = j
+	}
+	mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		j := pending[name]
+		active := j.active(now)
+
+		mu.Lock()
+		if jobs[name] != j || (j.known && active == j.last) {
+			mu.Unlock()
+			continue // replaced or removed meanwhile, or unchanged
+		}
+		j.known, j.last = true, active
+		mu.Unlock()
+
+		log.Printf("Scheduler: %s -> active=%v", name, active)
+		j.onChange(active)
+	}
+}
+
+// Start begins evaluating jobs every Interval.  Calling it more than once
+// has no effect.
+func Start() {
+	startOnce.Do(func() {
+		supervisor.Go("scheduler", func() error {
+			Tick(clock.Now())
+			ticker := clock.NewTicker(Interval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				Tick(now)
+			}
+			return nil
+		})
+	})
+}
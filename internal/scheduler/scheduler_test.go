@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns a local time on the week of Monday 2024-01-01.
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+}
+
+func TestWindowContains_SameDay(t *testing.T) {
+	w := Window{Start: "09:00", End: "17:30"}
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(1, 8, 59), false},
+		{at(1, 9, 0), true},
+		{at(1, 17, 29), true},
+		{at(1, 17, 30), false},
+	}
+	for _, c := range cases {
+		if got := w.Contains(c.t); got != c.want {
+			t.Errorf("Contains(%s) = %v, want %v", c.t.Format("15:04"), got, c.want)
+		}
+	}
+}
+
+func TestWindowContains_Overnight(t *testing.T) {
+	w := Window{Start: "23:00", End: "07:00"}
+	if !w.Wraps() {
+		t.Fatal("Expected 23:00-07:00 to wrap past midnight")
+	}
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(1, 22, 59), false},
+		{at(1, 23, 0), true},
+		{at(2, 3, 0), true},
+		{at(2, 6, 59), true},
+		{at(2, 7, 0), false},
+		{at(2, 12, 0), false},
+	}
+	for _, c := range cases {
+		if got := w.Contains(c.t); got != c.want {
+			t.Errorf("Contains(%s) = %v, want %v", c.t.Format("Mon 15:04"), got, c.want)
+		}
+	}
+}
+
+func TestWindowContains_Days(t *testing.T) {
+	// School nights only: the window starting Friday evening is skipped,
+	// but Thursday's still covers early Friday morning.
+	w := Window{Start: "22:00", End: "06:00", Days: []string{"sun", "mon", "tue", "wed", "thu"}}
+
+	if !w.Contains(at(4, 23, 0)) { // Thursday
+		t.Error("Expected Thursday night to be inside the window")
+	}
+	if !w.Contains(at(5, 5, 0)) { // Friday morning, started Thursday
+		t.Error("Expected Friday early morning to belong to Thursday's window")
+	}
+	if w.Contains(at(5, 23, 0)) { // Friday night
+		t.Error("Expected Friday night to be outside the window")
+	}
+	if w.Contains(at(6, 5, 0)) { // Saturday morning, started Friday
+		t.Error("Expected Saturday early morning to be outside the window")
+	}
+}
+
+func TestWindowValidate(t *testing.T) {
+	bad := []Window{
+		{Start: "25:00", End: "07:00"},
+		{Start: "23:00", End: "7"},
+		{Start: "08:00", End: "08:00"},
+		{Start: "08:00", End: "09:00", Days: []string{"funday"}},
+	}
+	for _, w := range bad {
+		if err := w.Validate(); err == nil {
+			t.Errorf("Expected %s to be rejected", w)
+		}
+		if w.Contains(at(1, 8, 30)) {
+			t.Errorf("Invalid window %s must not contain anything", w)
+		}
+	}
+	if err := (Window{Start: "23:00", End: "07:00"}).Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	days, err := ParseDays("weekdays, Saturday")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(days) != 6 || days[5] != "sat" {
+		t.Errorf("Unexpected days: %v", days)
+	}
+	if _, err := ParseDays("mon,xyz"); err == nil {
+		t.Error("Expected unknown day to be rejected")
+	}
+}
+
+func TestWindowStartEndAfter(t *testing.T) {
+	w := Window{Start: "23:00", End: "07:00", Days: []string{"fri"}}
+
+	if got := w.EndAfter(at(1, 23, 30)); !got.Equal(at(2, 7, 0)) {
+		t.Errorf("EndAfter = %s, want Tue 07:00", got)
+	}
+	if got := w.StartAfter(at(1, 12, 0)); !got.Equal(at(5, 23, 0)) {
+		t.Errorf("StartAfter = %s, want Fri 23:00", got)
+	}
+}
+
+func TestDaily(t *testing.T) {
+	d, err := ParseDaily("22:00 mon,wed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.String() != "22:00 mon,wed" {
+		t.Errorf("Unexpected daily %s", d)
+	}
+	if got := d.Next(at(1, 22, 0)); !got.Equal(at(3, 22, 0)) {
+		t.Errorf("Next = %s, want Wed 22:00", got)
+	}
+	if got := d.Last(at(1, 22, 0)); !got.Equal(at(1, 22, 0)) {
+		t.Errorf("Last = %s, want Mon 22:00", got)
+	}
+	if got := d.Last(at(3, 21, 59)); !got.Equal(at(1, 22, 0)) {
+		t.Errorf("Last = %s, want Mon 22:00", got)
+	}
+
+	if d, err := ParseDaily("07:30 daily"); err != nil || d.Days != nil {
+		t.Errorf("Expected every day, got %+v, %v", d, err)
+	}
+	if d, _ := ParseDaily("07:30 weekdays"); d.String() != "07:30 weekdays" {
+		t.Errorf("Expected weekdays to be kept short, got %s", d)
+	}
+	for _, bad := range []string{"", "7pm daily", "22:00 someday"} {
+		if _, err := ParseDaily(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestTick_FiresOnTransitions(t *testing.T) {
+	defer Remove("test")
+
+	inside := false
+	var got []bool
+	Set("test", func(time.Time) bool { return inside }, func(active bool) { got = append(got, active) })
+
+	Tick(time.Now()) // first evaluation always reports
+	Tick(time.Now()) // unchanged
+	inside = true
+	Tick(time.Now())
+	Tick(time.Now())
+	inside = false
+	Tick(time.Now())
+
+	want := []bool{false, true, false}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d callbacks, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Callback %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRemove_StopsCallbacks(t *testing.T) {
+	calls := 0
+	Set("gone", func(time.Time) bool { return true }, func(bool) { calls++ })
+	Remove("gone")
+	Tick(time.Now())
+	if calls != 0 {
+		t.Errorf("Removed job fired %d times", calls)
+	}
+	if len(Jobs()) != 0 {
+		t.Errorf("Expected no jobs, got %v", Jobs())
+	}
+}
@@ -0,0 +1,78 @@
+package seat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+)
+
+// BreakConfigFile enables the forced break.  Without it the daemon never
+// suspends or powers off the machine.
+var BreakConfigFile = "/etc/vex-cli/forced-break.json"
+
+// Forced break actions.
+const (
+	BreakSuspend  = "suspend"
+	BreakShutdown = "shutdown"
+)
+
+// DefaultGrace is the countdown before a forced break when the config
+// does not set one.
+const DefaultGrace = 2 * time.Minute
+
+// BreakConfig is the contents of BreakConfigFile.
+type BreakConfig struct {
+	Action       string `json:"action"`                  // BreakSuspend or BreakShutdown
+	GraceSeconds int    `json:"grace_seconds,omitempty"` // countdown after the warning; default 120
+	Curfew       bool   `json:"curfew,omitempty"`        // also break when the curfew starts
+}
+
+// LoadBreakConfig reads and validates BreakConfigFile.  A missing file
+// means the forced break is off and returns nil.
+func LoadBreakConfig() (*BreakConfig, error) {
+	data, err := os.ReadFile(BreakConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c BreakConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", BreakConfigFile, err)
+	}
+	if c.Action != BreakSuspend && c.Action != BreakShutdown {
+		return nil, fmt.Errorf("%s: action must be suspend or shutdown", BreakConfigFile)
+	}
+	if c.GraceSeconds < 0 {
+		return nil, fmt.Errorf("%s: grace_seconds must not be negative", BreakConfigFile)
+	}
+	return &c, nil
+}
+
+// Grace is the countdown between the warning and the break.
+func (c *BreakConfig) Grace() time.Duration {
+	if c.GraceSeconds > 0 {
+		return time.Duration(c.GraceSeconds) * time.Second
+	}
+	return DefaultGrace
+}
+
+// Break suspends or powers off the machine, as action says.
+func Break(action string) error {
+	verb := "suspend"
+	if action == BreakShutdown {
+		verb = "poweroff"
+	}
+	if subsystem.Skip(subsystem.Surveillance, "%s the machine", verb) {
+		return nil
+	}
+	if out, err := cmdRunner.Run("systemctl", verb); err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", verb, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package seat acts on the subject's logind sessions: it locks their
+// graphical sessions, as the screen locker would, or switches the console
+// to another virtual terminal, such as one running a penance prompt.
+// When forced-break.json allows it, it also suspends or powers off the
+// machine.
+//
+// With target users (see package users) only their sessions are locked;
+// otherwise every session on the machine is.
+package seat
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// -- Interfaces for Testing --
+
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+type RealCommandRunner struct{}
+
+func (r *RealCommandRunner) Run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+var cmdRunner CommandRunner = &RealCommandRunner{}
+
+// Timeout bounds a single loginctl or chvt call.
+var Timeout = 10 * time.Second
+
+// Session is one logind session.
+type Session struct {
+	ID   string
+	UID  uint32
+	User string
+}
+
+// Sessions lists the logind sessions.
+func Sessions() ([]Session, error) {
+	out, err := cmdRunner.Run("loginctl", "list-sessions", "--no-legend")
+	if err != nil {
+		return nil, fmt.Errorf("loginctl list-sessions: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	var list []Session
+	for _, line := range strings.Split(string(out), "\n") {
+		// SESSION UID USER SEAT TTY ...
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		uid, err := strconv.ParseUint(f[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		list = append(list, Session{ID: f[0], UID: uint32(uid), User: f[2]})
+	}
+	return list, nil
+}
+
+// Lock locks the sessions enforcement applies to and returns the IDs of
+// those it locked.
+func Lock() ([]string, error) {
+	if subsystem.Skip(subsystem.Surveillance, "lock the graphical session") {
+		return nil, nil
+	}
+	if !users.Scoped() {
+		if out, err := cmdRunner.Run("loginctl", "lock-sessions"); err != nil {
+			return nil, fmt.Errorf("loginctl lock-sessions: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return []string{"all"}, nil
+	}
+	list, err := Sessions()
+	if err != nil {
+		return nil, err
+	}
+	var locked []string
+	for _, s := range list {
+		if !users.Includes(s.UID) {
+			continue
+		}
+		if out, err := cmdRunner.Run("loginctl", "lock-session", s.ID); err != nil {
+			return locked, fmt.Errorf("loginctl lock-session %s: %w: %s", s.ID, err, strings.TrimSpace(string(out)))
+		}
+		locked = append(locked, s.ID)
+	}
+	return locked, nil
+}
+
+// SwitchVT brings virtual terminal vt to the foreground.
+func SwitchVT(vt int) error {
+	if subsystem.Skip(subsystem.Surveillance, "switch to VT %d", vt) {
+		return nil
+	}
+	if out, err := cmdRunner.Run("chvt", strconv.Itoa(vt)); err != nil {
+		return fmt.Errorf("chvt %d: %w: %s", vt, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
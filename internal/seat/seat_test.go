@@ -0,0 +1,112 @@
+package seat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+type fakeRunner struct {
+	sessions string
+	calls    []string
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	call := name + " " + strings.Join(args, " ")
+	f.calls = append(f.calls, call)
+	if call == "loginctl list-sessions --no-legend" {
+		return []byte(f.sessions), nil
+	}
+	return nil, nil
+}
+
+func withRunner(t *testing.T, f *fakeRunner) {
+	old := cmdRunner
+	cmdRunner = f
+	t.Cleanup(func() { cmdRunner = old })
+}
+
+func TestLockEverySession(t *testing.T) {
+	f := &fakeRunner{}
+	withRunner(t, f)
+	locked, err := Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if len(locked) != 1 || strings.Join(f.calls, ";") != "loginctl lock-sessions" {
+		t.Errorf("Unexpected calls %v, locked %v", f.calls, locked)
+	}
+}
+
+func TestLockTargetSessions(t *testing.T) {
+	users.Targets = []string{"1000"}
+	if err := users.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		users.Targets = nil
+		users.Resolve()
+	})
+
+	f := &fakeRunner{sessions: "      2 1000 alice seat0 tty2\n      5 1001 bob   -     pts/0\n      7 1000 alice -     pts/1\n"}
+	withRunner(t, f)
+	locked, err := Lock()
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if got := strings.Join(locked, ","); got != "2,7" {
+		t.Errorf("Locked %s, want 2,7", got)
+	}
+	for _, c := range f.calls {
+		if c == "loginctl lock-session 5" {
+			t.Error("Locked another user's session")
+		}
+	}
+}
+
+func TestSwitchVT(t *testing.T) {
+	f := &fakeRunner{}
+	withRunner(t, f)
+	if err := SwitchVT(8); err != nil {
+		t.Fatalf("SwitchVT: %v", err)
+	}
+	if strings.Join(f.calls, ";") != "chvt 8" {
+		t.Errorf("Unexpected calls %v", f.calls)
+	}
+}
+
+func TestBreak(t *testing.T) {
+	f := &fakeRunner{}
+	withRunner(t, f)
+	if err := Break(BreakSuspend); err != nil {
+		t.Fatalf("Break: %v", err)
+	}
+	if err := Break(BreakShutdown); err != nil {
+		t.Fatalf("Break: %v", err)
+	}
+	if got := strings.Join(f.calls, ";"); got != "systemctl suspend;systemctl poweroff" {
+		t.Errorf("Unexpected calls %s", got)
+	}
+}
+
+func TestLoadBreakConfig(t *testing.T) {
+	BreakConfigFile = filepath.Join(t.TempDir(), "forced-break.json")
+	if c, err := LoadBreakConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+	os.WriteFile(BreakConfigFile, []byte(`{"action": "reboot"}`), 0o644)
+	if _, err := LoadBreakConfig(); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+	os.WriteFile(BreakConfigFile, []byte(`{"action": "suspend", "curfew": true}`), 0o644)
+	c, err := LoadBreakConfig()
+	if err != nil {
+		t.Fatalf("LoadBreakConfig: %v", err)
+	}
+	if !c.Curfew || c.Grace() != DefaultGrace {
+		t.Errorf("Got %+v with grace %s", c, c.Grace())
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
 )
 
 // -- Interfaces for Testing --
@@ -30,7 +32,7 @@ var fsOps FileSystem = &RealFileSystem{}
 
 // -- Key Management --
 
-const (
+var (
 	PublicKeyFile = "/etc/vex-cli/vex_management_key.pub"
 )
 
@@ -101,7 +103,17 @@ type SignedCommand struct {
 
 // VerifyCommand checks that a signed command was authorized by the management key.
 // Commands that lower restrictions (unlocking blocks/throttles) must be verified.
+// The outcome is recorded in the audit log as SECURITY AUTHORIZED or DENIED.
 func VerifyCommand(cmd *SignedCommand) error {
+	if err := verifyCommand(cmd); err != nil {
+		vexlog.LogEvent("SECURITY", "DENIED", fmt.Sprintf("command=%s timestamp=%d error=%v", cmd.Command, cmd.Timestamp, err))
+		return err
+	}
+	vexlog.LogEvent("SECURITY", "AUTHORIZED", fmt.Sprintf("command=%s args=%s timestamp=%d", cmd.Command, cmd.Args, cmd.Timestamp))
+	return nil
+}
+
+func verifyCommand(cmd *SignedCommand) error {
 	if managementKey == nil {
 		return fmt.Errorf("management key not loaded; all restricted commands are DENIED")
 	}
@@ -118,8 +130,6 @@ func VerifyCommand(cmd *SignedCommand) error {
 	if !ed25519.Verify(managementKey, messageBytes, sigBytes) {
 		return fmt.Errorf("SIGNATURE VERIFICATION FAILED for command '%s'", cmd.Command)
 	}
-
-	log.Printf("Security: Command '%s' signature verified", cmd.Command)
 	return nil
 }
 
@@ -133,6 +143,11 @@ func IsRestrictionLoweringCommand(command string) bool {
 		"clear-penance":   true,
 		"set-standard":    true,
 		"reset-score":     true,
+		"curfew-override": true,
+		"early-release":   true,
+		"pause":           true,
+		"approve":         true,
+		"config-set":      true,
 	}
 	return restrictedCommands[command]
 }
@@ -147,14 +162,11 @@ func VerifyBinaryIntegrity(expectedHash string) error {
 		return fmt.Errorf("failed to determine executable path: %w", err)
 	}
 
-	data, err := os.ReadFile(execPath)
+	actualHash, err := HashFile(execPath)
 	if err != nil {
 		return fmt.Errorf("failed to read executable: %w", err)
 	}
 
-	hash := sha256.Sum256(data)
-	actualHash := hex.EncodeToString(hash[:])
-
 	if actualHash != expectedHash {
 		return fmt.Errorf("BINARY INTEGRITY CHECK FAILED: expected %s, got %s", expectedHash, actualHash)
 	}
@@ -163,6 +175,16 @@ func VerifyBinaryIntegrity(expectedHash string) error {
 	return nil
 }
 
+// HashFile returns the hex SHA-256 of the file at path.
+func HashFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
 // -- SSH Key Parsing --
 
 // parseSSHEd25519PublicKey extracts the raw 32-byte Ed25519 public key from
@@ -0,0 +1,150 @@
+// Package selftest checks that the host gives vexd everything it needs to
+// enforce, without enforcing anything.  vexd --selftest prints the result
+// as a matrix, which doubles as a check of a fresh install.
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/adumbdinosaur/vex-cli/internal/guardian"
+	"github.com/adumbdinosaur/vex-cli/internal/netacct"
+	"github.com/adumbdinosaur/vex-cli/internal/sandbox"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+	"github.com/adumbdinosaur/vex-cli/internal/throttler"
+	"golang.org/x/sys/unix"
+)
+
+// Check is one capability vexd relies on.
+type Check struct {
+	Name     string
+	Required bool // without it some enforcement cannot work at all
+	Probe    func() (detail string, err error)
+}
+
+// Checks are the capabilities vexd relies on, in the order it uses them
+// at startup.  eBPF and Landlock have fallbacks: the /proc reaper and
+// seccomp alone.  Without network accounting, traffic is not counted.
+var Checks = []Check{
+	{"capabilities", true, probeCapabilities},
+	{"netlink", true, throttler.ProbeNetlink},
+	{"cgroup v2", true, throttler.ProbeCgroup},
+	{"nftables", true, guardian.ProbeFirewall},
+	{"eBPF", false, guardian.ProbeEBPF},
+	{"network accounting", false, netacct.Probe},
+	{"evdev", true, surveillance.ProbeInput},
+	{"uinput", true, surveillance.ProbeUinput},
+	{"landlock", false, sandbox.ProbeLandlock},
+}
+
+// Result is the outcome of one Check.
+type Result struct {
+	Check
+	Detail string
+	Err    error
+}
+
+// Status is "ok", "FAIL" for a required check that failed, or "missing"
+// for an optional one.
+func (r Result) Status() string {
+	switch {
+	case r.Err == nil:
+		return "ok"
+	case r.Required:
+		return "FAIL"
+	}
+	return "missing"
+}
+
+// Run probes every check.  A probe that panics fails its check instead of
+// stopping the rest.
+func Run(checks []Check) []Result {
+	results := make([]Result, len(checks))
+	for i, c := range checks {
+		results[i] = run(c)
+	}
+	return results
+}
+
+func run(c Check) (r Result) {
+	r.Check = c
+	defer func() {
+		if p := recover(); p != nil {
+			r.Err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	r.Detail, r.Err = c.Probe()
+	return r
+}
+
+// Print writes results as a table and reports whether every required
+// check passed.
+func Print(w io.Writer, results []Result) bool {
+	ok := true
+	width := len("CAPABILITY")
+	for _, r := range results {
+		width = max(width, len(r.Name))
+	}
+	fmt.Fprintf(w, "%-*s  %-7s  %s\n", width, "CAPABILITY", "STATUS", "DETAIL")
+	for _, r := range results {
+		detail := r.Detail
+		if r.Err != nil {
+			detail = r.Err.Error()
+			ok = ok && !r.Required
+		}
+		fmt.Fprintf(w, "%-*s  %-7s  %s\n", width, r.Name, r.Status(), detail)
+	}
+	return ok
+}
+
+// ── Process capabilities ───────────────────────────────────────────
+
+// statusFile is where the effective capability set is read from.
+var statusFile = "/proc/self/status"
+
+// neededCaps are the capabilities the subsystems use between them.
+var neededCaps = []struct {
+	bit  uint
+	name string
+}{
+	{unix.CAP_DAC_OVERRIDE, "CAP_DAC_OVERRIDE"}, // config, cgroup and device files
+	{unix.CAP_KILL, "CAP_KILL"},                 // reaping forbidden apps
+	{unix.CAP_NET_ADMIN, "CAP_NET_ADMIN"},       // qdiscs and nftables
+	{unix.CAP_SYS_ADMIN, "CAP_SYS_ADMIN"},       // the sandbox and anti-tamper
+	{unix.CAP_SYS_RESOURCE, "CAP_SYS_RESOURCE"}, // a negative OOM score
+}
+
+// probeCapabilities checks the effective capability set of the process.
+func probeCapabilities() (string, error) {
+	data, err := os.ReadFile(statusFile)
+	if err != nil {
+		return "", err
+	}
+	var eff uint64
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			if eff, err = strconv.ParseUint(strings.TrimSpace(v), 16, 64); err != nil {
+				return "", fmt.Errorf("CapEff: %w", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no CapEff in %s", statusFile)
+	}
+
+	var missing []string
+	for _, c := range neededCaps {
+		if eff&(1<<c.bit) == 0 {
+			missing = append(missing, c.name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s (run as root)", strings.Join(missing, ", "))
+	}
+	return fmt.Sprintf("%d needed capabilities held", len(neededCaps)), nil
+}
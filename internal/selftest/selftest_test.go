@@ -0,0 +1,47 @@
+package selftest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrint(t *testing.T) {
+	results := Run([]Check{
+		{"netlink", true, func() (string, error) { return "eth0, 1 qdiscs", nil }},
+		{"eBPF", false, func() (string, error) { return "", errors.New("not in this build") }},
+		{"uinput", true, func() (string, error) { panic("boom") }},
+	})
+
+	var b strings.Builder
+	if Print(&b, results) {
+		t.Error("Expected a failed required check to fail the self-test")
+	}
+	out := b.String()
+	for _, want := range []string{"netlink     ok", "eBPF        missing  not in this build", "uinput      FAIL     panic: boom"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+
+	if !Print(&b, results[:2]) {
+		t.Error("Expected a missing optional check not to fail the self-test")
+	}
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	statusFile = filepath.Join(t.TempDir(), "status")
+	defer func() { statusFile = "/proc/self/status" }()
+
+	os.WriteFile(statusFile, []byte("Name:\tvexd\nCapEff:\t000001ffffffffff\n"), 0644)
+	if _, err := probeCapabilities(); err != nil {
+		t.Errorf("Expected a full capability set to pass, got %v", err)
+	}
+
+	os.WriteFile(statusFile, []byte("Name:\tvexd\nCapEff:\t0000000000000000\n"), 0644)
+	if _, err := probeCapabilities(); err == nil || !strings.Contains(err.Error(), "CAP_NET_ADMIN") {
+		t.Errorf("Expected CAP_NET_ADMIN to be missing, got %v", err)
+	}
+}
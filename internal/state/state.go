@@ -10,64 +10,317 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
 )
 
-const (
+var (
 	// StateDir is the base directory for all vex-cli runtime state.
 	StateDir = "/var/lib/vex-cli"
 
 	// StateFile is the unified system state persisted to disk.
 	StateFile = "/var/lib/vex-cli/system-state.json"
-
-	// SocketPath is the Unix domain socket for CLI ↔ daemon IPC.
-	SocketPath = "/run/vex-cli/vexd.sock"
 )
 
+// SpoolDir holds the commands vex-cli queued while the daemon could not
+// be reached, for the daemon to run when it starts.
+var SpoolDir = "/var/lib/vex-cli/spool"
+
+// SocketPath is the Unix domain socket for CLI ↔ daemon IPC.  The daemon
+// configuration may move it.
+var SocketPath = "/run/vex-cli/vexd.sock"
+
 // SystemState is the single file that captures every enforceable setting.
 // The daemon reads it on startup and applies each section.
 // The CLI (via IPC) asks the daemon to mutate sections and persist.
 type SystemState struct {
-	Version     string         `json:"version"`
-	LastUpdated string         `json:"last_updated"`
-	ChangedBy   string         `json:"changed_by"` // "cli", "penance", "unlock", "daemon", "escalation"
-	Network     NetworkState   `json:"network"`
-	Compute     ComputeState   `json:"compute"`
-	Guardian    GuardianState  `json:"guardian"`
-	Compliance  ComplianceInfo `json:"compliance"`
-	Writing     WritingTask    `json:"writing"`
+	Version     string             `json:"version"`
+	LastUpdated string             `json:"last_updated"`
+	ChangedBy   string             `json:"changed_by"`            // "cli", "penance", "unlock", "daemon", "escalation", "sync", "pause", "calendar", "exception", "relock", "schedule", "reload", "spool"
+	Transitions []Transition       `json:"transitions,omitempty"` // the last MaxTransitions changes of ChangedBy, oldest first
+	Network     NetworkState       `json:"network"`
+	Compute     ComputeState       `json:"compute"`
+	Media       MediaState         `json:"media"`
+	Guardian    GuardianState      `json:"guardian"`
+	Compliance  ComplianceInfo     `json:"compliance"`
+	Writing     WritingTask        `json:"writing"`               // the active writing task
+	LinesQueue  []WritingTask      `json:"lines_queue,omitempty"` // tasks waiting behind Writing, highest priority first
+	Curfew      CurfewState        `json:"curfew"`
+	Expiries    []Expiry           `json:"expiries,omitempty"`
+	Allowances  []Allowance        `json:"allowances,omitempty"`
+	Pause       *PauseState        `json:"pause,omitempty"`
+	Calendar    CalendarState      `json:"calendar"`
+	Exceptions  []ExceptionRequest `json:"exceptions,omitempty"` // pending requests
+	Relock      *RelockState       `json:"relock,omitempty"`
+	LastReport  string             `json:"last_report,omitempty"` // RFC3339 time the scheduled report last went out
+	Streak      StreakState        `json:"streak"`
+	Credits     CreditsState       `json:"credits"`
+	Schedules   []Schedule         `json:"schedules,omitempty"`
+	Barred      []string           `json:"barred_clients,omitempty"` // SHA-256 of executables refused a session
+}
+
+// MaxTransitions is how many transitions the state keeps.
+const MaxTransitions = 10
+
+// Transition is a point where another source took over the state, or
+// the same one changed the network profile or the lock, with what it left
+// in force.  The timeline in vex-cli status shows why the current
+// restrictions exist.
+type Transition struct {
+	At      string `json:"at"` // RFC3339
+	By      string `json:"by"` // ChangedBy
+	Profile string `json:"profile"`
+	Locked  bool   `json:"locked"`
+}
+
+// noteTransition records a transition if s differs from the last one.
+func (s *SystemState) noteTransition(at string) {
+	if s.ChangedBy == "" {
+		return
+	}
+	t := Transition{At: at, By: s.ChangedBy, Profile: s.Network.Profile, Locked: s.Compliance.Locked}
+	if n := len(s.Transitions); n > 0 {
+		last := s.Transitions[n-1]
+		if last.By == t.By && last.Profile == t.Profile && last.Locked == t.Locked {
+			return
+		}
+	}
+	s.Transitions = append(s.Transitions, t)
+	if n := len(s.Transitions); n > MaxTransitions {
+		s.Transitions = slices.Clone(s.Transitions[n-MaxTransitions:])
+	}
 }
 
 // NetworkState holds all network-shaping parameters.
 type NetworkState struct {
 	Profile       string  `json:"profile"`         // standard, choke, dial-up, black-hole
 	PacketLossPct float32 `json:"packet_loss_pct"` // 0-100
+	ApplyStatus
 }
 
 // ComputeState holds CPU / OOM / latency overrides.
 type ComputeState struct {
-	CPULimitPct    int `json:"cpu_limit_pct"`     // 0-100  (100 = uncapped)
-	OOMScoreAdj    int `json:"oom_score_adj"`     // -1000 to 1000
-	InputLatencyMs int `json:"input_latency_ms"`  // 0 = none
+	CPULimitPct       int    `json:"cpu_limit_pct"`                  // 0-100  (100 = uncapped)
+	Priority          string `json:"priority,omitempty"`             // "low", "lowest" or "idle"; "" = the processes' own
+	GPULimitPct       int    `json:"gpu_limit_pct,omitempty"`        // GPU power/clock cap, %; 0 or 100 = uncapped
+	OOMScoreAdj       int    `json:"oom_score_adj"`                  // -1000 to 1000
+	InputLatencyMs    int    `json:"input_latency_ms"`               // 0 = none; jitter minimum when max is set
+	InputLatencyMaxMs int    `json:"input_latency_max_ms,omitempty"` // jitter maximum; 0 = fixed latency
+	InputLockUntil    string `json:"input_lock_until,omitempty"`     // RFC3339 end of an input blackout
+	FreezeAt          string `json:"freeze_at,omitempty"`            // RFC3339 start of a session freeze, once the warning is over
+	FreezeUntil       string `json:"freeze_until,omitempty"`         // RFC3339 thaw of the session freeze
+	Frozen            bool   `json:"frozen,omitempty"`               // the session freeze has started
+	ApplyStatus
+}
+
+// MediaState caps the display brightness and the audio output.  A cap
+// of 0 or 100 leaves the setting alone.
+type MediaState struct {
+	BrightnessPct int  `json:"brightness_pct,omitempty"` // backlight cap, % of each panel's maximum
+	VolumePct     int  `json:"volume_pct,omitempty"`     // output volume cap, %
+	Muted         bool `json:"muted,omitempty"`          // output kept muted
+}
+
+// Capped reports whether m restricts anything.
+func (m MediaState) Capped() bool {
+	return m.Muted || (m.BrightnessPct > 0 && m.BrightnessPct < 100) || (m.VolumePct > 0 && m.VolumePct < 100)
 }
 
 // GuardianState holds process-reaper and firewall config.
 type GuardianState struct {
-	FirewallEnabled bool     `json:"firewall_enabled"` // SNI blocking active
-	ReaperEnabled   bool     `json:"reaper_enabled"`   // Process reaper active
-	BlockedDomains  []string `json:"blocked_domains"`  // Currently blocked SNI domains
+	FirewallEnabled bool              `json:"firewall_enabled"`    // SNI blocking active
+	ReaperEnabled   bool              `json:"reaper_enabled"`      // Process reaper active
+	BlockedDomains  []string          `json:"blocked_domains"`     // Currently blocked SNI domains
+	Monitored       []MonitoredDomain `json:"monitored,omitempty"` // Domains that warn before they block
+	ApplyStatus
+}
+
+// MonitoredDomain is a domain in monitor mode: its first Allowed accesses
+// each day only warn, and the next one blocks it until the day is over.
+type MonitoredDomain struct {
+	Domain   string `json:"domain"`
+	Allowed  int    `json:"allowed"`
+	Day      string `json:"day,omitempty"` // YYYY-MM-DD, local time, Accesses counts
+	Accesses int    `json:"accesses,omitempty"`
+	Blocked  bool   `json:"blocked,omitempty"` // in BlockedDomains until the day is over
+}
+
+// ApplyStatus records the outcome of the daemon's most recent attempt to
+// enforce a section.  The section fields describe intent; these describe
+// whether the kernel actually agreed, so silent failures show up in status.
+type ApplyStatus struct {
+	LastApplied string `json:"last_applied,omitempty"` // RFC3339 time of the last attempt
+	LastError   string `json:"last_error,omitempty"`   // empty if the last attempt succeeded
+}
+
+// RecordApply stamps the section with the current time and the result of
+// an apply attempt (nil err clears any previous error).
+func (a *ApplyStatus) RecordApply(err error) {
+	a.LastApplied = clock.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		a.LastError = err.Error()
+	} else {
+		a.LastError = ""
+	}
 }
 
 // WritingTask represents a "write lines" punishment: the subject must
 // type an exact phrase a set number of times before the task is cleared.
-// The task persists across reboots until all lines are submitted.
+// The task persists across reboots until all lines are submitted.  Tasks
+// set while one is active wait in SystemState.LinesQueue.
 type WritingTask struct {
-	Active    bool   `json:"active"`
-	Phrase    string `json:"phrase"`
-	Required  int    `json:"required"`   // total lines to write
-	Completed int    `json:"completed"`  // lines accepted so far
+	Active      bool     `json:"active"`
+	Phrase      string   `json:"phrase"`
+	Required    int      `json:"required"`                  // total lines to write
+	Completed   int      `json:"completed"`                 // lines accepted so far
+	Priority    int      `json:"priority,omitempty"`        // higher goes first; a higher one set later takes over
+	MinSeconds  int      `json:"min_seconds,omitempty"`     // a line taking less is rejected; 0 = no minimum
+	MaxSeconds  int      `json:"max_seconds,omitempty"`     // a line taking more is rejected; 0 = no maximum
+	Live        bool     `json:"live,omitempty"`            // each line must match what the keyboard typed
+	MinKPM      int      `json:"min_kpm,omitempty"`         // live: a line typed slower is rejected; 0 = no minimum
+	MaxKPM      int      `json:"max_kpm,omitempty"`         // live: a line typed faster is rejected; 0 = no maximum
+	StepEvery   int      `json:"step_every,omitempty"`      // accepted lines between difficulty steps; 0 = none
+	Clauses     []string `json:"clauses,omitempty"`         // appended to Phrase, one per step, until used up
+	PaceStep    int      `json:"pace_step,omitempty"`       // seconds taken off MaxSeconds per step
+	AbandonMins int      `json:"abandon_minutes,omitempty"` // minutes without an accepted line before OnAbandon; 0 = never
+	OnAbandon   []string `json:"on_abandon,omitempty"`      // any of fail, bump, throttle
+	AbandonBump int      `json:"abandon_bump,omitempty"`    // lines added by bump
+	Progressed  string   `json:"progressed,omitempty"`      // RFC3339; when the task became active or last accepted a line
+	LineStarted string   `json:"line_started,omitempty"`    // RFC3339; when the task was set or the last line submitted
+}
+
+// StreakState tracks the run of clean days, without a penance failure or
+// a kill.
+type StreakState struct {
+	Since     string `json:"since,omitempty"`     // RFC3339 start: the last failure or kill, or when tracking began
+	Milestone int    `json:"milestone,omitempty"` // highest milestone announced for this streak, in days
+}
+
+// CreditsState is the balance of credits earned for good behaviour.
+type CreditsState struct {
+	Balance int    `json:"balance"`
+	LastDay string `json:"last_day,omitempty"` // YYYY-MM-DD, local time: the last day credited
+	Earned  int    `json:"earned,omitempty"`   // what that day earned
+}
+
+// CurfewState is a nightly curfew: between Start and End the network is
+// black-holed and Apps are added to the forbidden list.  The pre-curfew
+// profile and the apps the curfew added are remembered so wake time
+// restores exactly what was there before.
+type CurfewState struct {
+	Enabled      bool     `json:"enabled"`
+	Start        string   `json:"start,omitempty"`          // "HH:MM" local
+	End          string   `json:"end,omitempty"`            // "HH:MM" local; wraps past midnight
+	Days         []string `json:"days,omitempty"`           // days the curfew starts on; empty = nightly
+	Apps         []string `json:"apps,omitempty"`           // extra forbidden apps during curfew
+	Active       bool     `json:"active"`                   // curfew currently enforced
+	SavedProfile string   `json:"saved_profile,omitempty"`  // profile to restore at wake
+	SavedLossPct float32  `json:"saved_loss_pct,omitempty"` // packet loss to restore at wake
+	AddedApps    []string `json:"added_apps,omitempty"`     // apps to un-forbid at wake
+	SkipUntil    string   `json:"skip_until,omitempty"`     // RFC3339; set by a signed override
+}
+
+// Expiry is a restriction applied with --for.  When Until passes the
+// daemon restores Previous, unless something else has moved the setting
+// away from Value in the meantime.
+type Expiry struct {
+	Kind     string `json:"kind"`             // throttle, cpu, latency, block, lock, exception
+	Target   string `json:"target,omitempty"` // domain, for block
+	Value    string `json:"value"`            // setting applied by the command
+	Previous string `json:"previous"`         // setting restored at expiry
+	Until    string `json:"until"`            // RFC3339
+}
+
+// Allowance temporarily permits blocked domains and forbidden apps during
+// a recurring window.  The Lifted lists record what was actually removed
+// so the end of the window restores exactly that.
+type Allowance struct {
+	Name          string   `json:"name"`
+	Start         string   `json:"start"`          // "HH:MM" local
+	End           string   `json:"end"`            // "HH:MM" local
+	Days          []string `json:"days,omitempty"` // empty = every day
+	Domains       []string `json:"domains,omitempty"`
+	Apps          []string `json:"apps,omitempty"`
+	Active        bool     `json:"active"`
+	LiftedDomains []string `json:"lifted_domains,omitempty"`
+	LiftedApps    []string `json:"lifted_apps,omitempty"`
+}
+
+// Schedule is a command vexd runs at a time of day, added with
+// "vex-cli schedule add".  Command holds the words as typed, e.g.
+// "throttle black-hole", and is parsed again on every run.
+type Schedule struct {
+	ID      int      `json:"id"`
+	At      string   `json:"at"`             // "HH:MM" local
+	Days    []string `json:"days,omitempty"` // empty = every day
+	Command string   `json:"command"`
+	LastRun string   `json:"last_run"`           // RFC3339; set when added, so the first run is the next one
+	NextRun string   `json:"next_run,omitempty"` // RFC3339, for display
+
+	// Payload is the signed authorization, as JSON, that a command able
+	// to lower restrictions needs.  It is verified again on every run.
+	Payload string `json:"payload,omitempty"`
+}
+
+// CalendarState records the presets imposed by calendar events and what
+// they changed, so the end of an event undoes exactly that.  The profile
+// and CPU cap are only restored if nothing else changed them meanwhile.
+type CalendarState struct {
+	Presets        []string `json:"presets,omitempty"`         // presets currently in force
+	SavedProfile   string   `json:"saved_profile,omitempty"`   // profile before the first preset
+	AppliedProfile string   `json:"applied_profile,omitempty"` // profile the presets set
+	SavedCPU       int      `json:"saved_cpu,omitempty"`
+	AppliedCPU     int      `json:"applied_cpu,omitempty"`
+	AddedDomains   []string `json:"added_domains,omitempty"` // domains to unblock when the presets end
+	AddedApps      []string `json:"added_apps,omitempty"`    // apps to un-forbid when the presets end
+	LastFetch      string   `json:"last_fetch,omitempty"`    // RFC3339 of the last successful fetch
+	LastError      string   `json:"last_error,omitempty"`
+}
+
+// ExceptionRequest asks the keyholder to lift one blocked domain or
+// forbidden app for a while.  Nothing changes until it is approved; the
+// lift then becomes an "exception" Expiry and ends like any --for.
+type ExceptionRequest struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`   // "domain" or "app"
+	Target      string `json:"target"` // domain or app name
+	For         string `json:"for"`    // Go duration, e.g. "1h"
+	Reason      string `json:"reason,omitempty"`
+	Requested   string `json:"requested"`              // RFC3339
+	AutoApprove string `json:"auto_approve,omitempty"` // RFC3339; empty = signed approval only
+}
+
+// PauseState is a signed vacation pause.  Until it ends nothing is
+// enforced; Saved is what the daemon re-applies when it does.
+type PauseState struct {
+	Since string   `json:"since"` // RFC3339
+	Until string   `json:"until"` // RFC3339
+	Saved Snapshot `json:"saved"`
+}
+
+// RelockState is a temporary unlock.  When Until passes the daemon
+// re-applies Saved and, if compliance was locked, locks it again.
+type RelockState struct {
+	Since      string   `json:"since"` // RFC3339
+	Until      string   `json:"until"` // RFC3339
+	Locked     bool     `json:"locked"`
+	TaskStatus string   `json:"task_status,omitempty"`
+	Saved      Snapshot `json:"saved"`
+}
+
+// Snapshot is a copy of the enforceable settings, taken so they can be
+// re-applied later exactly as they were.
+type Snapshot struct {
+	Network       NetworkState  `json:"network"`
+	Compute       ComputeState  `json:"compute"`
+	Media         MediaState    `json:"media"`
+	Guardian      GuardianState `json:"guardian"`
+	ForbiddenApps []string      `json:"forbidden_apps,omitempty"`
+	Expiries      []Expiry      `json:"expiries,omitempty"`
 }
 
 // ComplianceInfo is a snapshot included for convenience — the authoritative
@@ -76,6 +329,10 @@ type ComplianceInfo struct {
 	Locked       bool   `json:"locked"`
 	FailureScore int    `json:"failure_score"`
 	TaskStatus   string `json:"task_status"`
+	LockUntil    string `json:"lock_until,omitempty"` // RFC3339 lockuntil deadline
+	Tier         string `json:"tier,omitempty"`       // compliance tier in force (tiers.json)
+
+	Failures map[string]int `json:"failures,omitempty"` // failures by category, from compliance-status.json
 }
 
 // FileOps is abstracted for testing.
@@ -104,7 +361,7 @@ var (
 func Default() *SystemState {
 	return &SystemState{
 		Version:     "1.0",
-		LastUpdated: time.Now().UTC().Format(time.RFC3339),
+		LastUpdated: clock.Now().UTC().Format(time.RFC3339),
 		ChangedBy:   "default",
 		Network: NetworkState{
 			Profile:       "standard",
@@ -150,12 +407,28 @@ func Load() (*SystemState, error) {
 	return &s, nil
 }
 
+// Clone returns a deep copy of s, one that shares no slice, map or
+// pointer with it.  Every field is persisted, so a round trip through
+// JSON copies them all.
+func (s *SystemState) Clone() *SystemState {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(fmt.Sprintf("state: cannot copy: %v", err))
+	}
+	var c SystemState
+	if err := json.Unmarshal(data, &c); err != nil {
+		panic(fmt.Sprintf("state: cannot copy: %v", err))
+	}
+	return &c
+}
+
 // Save persists the system state to disk. It ensures the directory exists.
 func Save(s *SystemState) error {
 	mu.Lock()
 	defer mu.Unlock()
 
-	s.LastUpdated = time.Now().UTC().Format(time.RFC3339)
+	s.LastUpdated = clock.Now().UTC().Format(time.RFC3339)
+	s.noteTransition(s.LastUpdated)
 
 	dir := filepath.Dir(StateFile)
 	if _, err := fsOps.Stat(dir); os.IsNotExist(err) {
@@ -200,6 +473,21 @@ func EnsureSocketDir() error {
 	return nil
 }
 
+// EnsureSpoolDir creates SpoolDir if it doesn't exist, owned by group
+// 'vex' so that non-root group members can queue commands in it.
+// 0730 = rwx-wx--- : group members may create files but not list or read
+// those of others.
+func EnsureSpoolDir() error {
+	if err := fsOps.MkdirAll(SpoolDir, 0730); err != nil {
+		return err
+	}
+	if err := os.Chmod(SpoolDir, 0730); err != nil {
+		log.Printf("State: WARNING - Could not chmod spool dir %s: %v", SpoolDir, err)
+	}
+	setDirGroupToVex(SpoolDir)
+	return nil
+}
+
 // setDirGroupToVex sets the group ownership of a directory to the 'vex' group.
 func setDirGroupToVex(path string) {
 	grp, err := user.LookupGroup("vex")
@@ -0,0 +1,277 @@
+package stats
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+)
+
+// Handler serves the API for src.  A non-empty token must be presented as
+// a bearer token on every request.
+func Handler(src Source, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/score", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseRange(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		points, err := Scores(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, points)
+	})
+	mux.HandleFunc("GET /api/usage", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseRange(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, src.Usage(from, to))
+	})
+	mux.HandleFunc("GET /api/submissions", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseRange(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		subs, err := src.Submissions(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, subs)
+	})
+	mux.HandleFunc("GET /api/failures", func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseRange(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		counts, err := src.Failures(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, counts)
+	})
+
+	// Grafana JSON datasource.
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "OK\n")
+	})
+	mux.HandleFunc("POST /search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, targetNames)
+	})
+	mux.HandleFunc("POST /metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics := make([]map[string]string, len(targetNames))
+		for i, name := range targetNames {
+			metrics[i] = map[string]string{"label": name, "value": name}
+		}
+		writeJSON(w, metrics)
+	})
+	mux.HandleFunc("POST /query", func(w http.ResponseWriter, r *http.Request) {
+		var q query
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&q); err != nil {
+			http.Error(w, "malformed query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		results, err := src.query(&q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, results)
+	})
+
+	if token == "" {
+		return mux
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// parseRange reads the from and to query parameters, each an RFC3339
+// time or Unix milliseconds as Grafana's ${__from} gives.  The range
+// defaults to the day ending now.
+func parseRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	to, err := parseTime(r.URL.Query().Get("to"), now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	from, err := parseTime(r.URL.Query().Get("from"), to.Add(-24*time.Hour))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use RFC3339 or Unix milliseconds)", s)
+}
+
+// targetNames are the series a Grafana panel can select.  The last three
+// are tables.
+var targetNames = []string{"score", "locked", "kills", "active_seconds", "keystrokes", "usage_per_app", "submissions", "failures_per_category"}
+
+// seriesMetrics extract a time series target from a bucket of samples.
+var seriesMetrics = map[string]func(history.Point) float64{
+	"score":          func(p history.Point) float64 { return float64(p.Score) },
+	"locked":         func(p history.Point) float64 { return float64(p.LockedPct) / 100 },
+	"kills":          func(p history.Point) float64 { return float64(p.Kills) },
+	"active_seconds": func(p history.Point) float64 { return p.ActiveSeconds },
+	"keystrokes":     func(p history.Point) float64 { return float64(p.Keystrokes) },
+}
+
+// query is the body of a JSON datasource /query request.
+type query struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	MaxDataPoints int `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+	} `json:"targets"`
+}
+
+// series is a time series result: datapoints are [value, unix ms].
+type series struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type column struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// table is a table result.
+type table struct {
+	Type    string   `json:"type"` // always "table"
+	RefID   string   `json:"refId,omitempty"`
+	Columns []column `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// query answers each target of q.  History samples are merged into
+// buckets when there are more than the panel can draw.
+func (src Source) query(q *query) ([]any, error) {
+	from, to := q.Range.From, q.Range.To
+	if !to.After(from) {
+		return nil, fmt.Errorf("range.from must be before range.to")
+	}
+	var points []history.Point
+	results := []any{}
+	for _, t := range q.Targets {
+		switch t.Target {
+		case "":
+			continue // a panel row with nothing selected yet
+		case "usage_per_app":
+			tb := table{Type: "table", RefID: t.RefID, Columns: []column{{"App", "string"}, {"Seconds", "number"}}, Rows: [][]any{}}
+			for _, a := range src.Usage(from, to).Apps {
+				tb.Rows = append(tb.Rows, []any{a.App, a.Seconds})
+			}
+			results = append(results, tb)
+		case "submissions":
+			subs, err := src.Submissions(from, to)
+			if err != nil {
+				return nil, err
+			}
+			tb := table{Type: "table", RefID: t.RefID, Columns: []column{{"Time", "time"}, {"Outcome", "string"}, {"Reason", "string"}, {"Category", "string"}, {"Details", "string"}}, Rows: [][]any{}}
+			for _, s := range subs {
+				tb.Rows = append(tb.Rows, []any{s.Time.UnixMilli(), s.Outcome, s.Reason, s.Category, s.Details})
+			}
+			results = append(results, tb)
+		case "failures_per_category":
+			counts, err := src.Failures(from, to)
+			if err != nil {
+				return nil, err
+			}
+			tb := table{Type: "table", RefID: t.RefID, Columns: []column{{"Category", "string"}, {"Failures", "number"}}, Rows: [][]any{}}
+			for _, c := range counts {
+				tb.Rows = append(tb.Rows, []any{c.Category, c.Count})
+			}
+			results = append(results, tb)
+		default:
+			metric, ok := seriesMetrics[t.Target]
+			if !ok {
+				return nil, fmt.Errorf("unknown target %q", t.Target)
+			}
+			if points == nil {
+				var err error
+				if points, err = historyPoints(from, to, q.MaxDataPoints); err != nil {
+					return nil, err
+				}
+			}
+			s := series{Target: t.Target, Datapoints: [][2]float64{}}
+			for _, p := range points {
+				if p.Samples > 0 {
+					s.Datapoints = append(s.Datapoints, [2]float64{metric(p), float64(p.Start.UnixMilli())})
+				}
+			}
+			results = append(results, s)
+		}
+	}
+	return results, nil
+}
+
+// historyPoints returns one point per sample in [from, to), or at most
+// limit buckets of them.
+func historyPoints(from, to time.Time, limit int) ([]history.Point, error) {
+	samples, err := history.Read(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(samples) > limit {
+		return history.Bucket(samples, from, to, limit), nil
+	}
+	points := make([]history.Point, len(samples))
+	for i, s := range samples {
+		points[i] = history.Point{
+			Start:         s.Time,
+			Samples:       1,
+			Score:         s.Score,
+			Profile:       s.Profile,
+			Kills:         s.Kills,
+			ActiveSeconds: s.ActiveSeconds,
+			Keystrokes:    s.Keystrokes,
+		}
+		if s.Locked {
+			points[i].LockedPct = 100
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,277 @@
+// Package stats serves compliance statistics as JSON over HTTP for
+// dashboards.
+//
+// Four series are available: the failure score over time (from the
+// metrics history), screen time per app (from the daily usage totals),
+// penance submissions and failures per category (both from the audit
+// log).  They are served both as plain
+// JSON under /api/ for a custom dashboard, and through the endpoints of
+// Grafana's JSON datasource plugin (simpod-json-datasource) so a Grafana
+// panel can chart them directly.  The listener is read-only and binds to
+// localhost unless stats.json says otherwise.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+	"github.com/adumbdinosaur/vex-cli/internal/penance"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+)
+
+var (
+	// ConfigFile holds the listen address and token.  Optional.
+	ConfigFile = "/etc/vex-cli/stats.json"
+
+	// DefaultListen is used when the config does not set listen.
+	DefaultListen = "127.0.0.1:7107"
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Listen string `json:"listen,omitempty"` // host:port, default DefaultListen
+	Token  string `json:"token,omitempty"`  // required as "Authorization: Bearer <token>" when set
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// stats API is not served and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	return &c, nil
+}
+
+// Validate checks the listen address.
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		c.Listen = DefaultListen
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen must be host:port: %w", err)
+	}
+	return nil
+}
+
+// Source supplies the data the daemon holds in memory.
+type Source struct {
+	LogPath    string                                 // audit log, for submissions
+	DailyUsage func(days int) []surveillance.DayUsage // the last days ending today, oldest first
+}
+
+// Start serves the API on c.Listen in the background.
+func Start(c *Config, src Source) error {
+	ln, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: Handler(src, c.Token), ReadHeaderTimeout: 10 * time.Second}
+	supervisor.Go("stats", func() error {
+		if ln == nil { // restarted: listen again
+			if ln, err = net.Listen("tcp", c.Listen); err != nil {
+				return err
+			}
+		}
+		err := srv.Serve(ln)
+		ln = nil
+		return fmt.Errorf("server stopped: %w", err)
+	})
+	log.Printf("Stats: Serving on %s", ln.Addr())
+	return nil
+}
+
+// ScorePoint is one history sample.
+type ScorePoint struct {
+	Time          time.Time `json:"time"`
+	Score         int       `json:"score"`
+	Locked        bool      `json:"locked"`
+	Profile       string    `json:"profile,omitempty"`
+	Kills         int       `json:"kills"`          // since the previous sample
+	ActiveSeconds float64   `json:"active_seconds"` // since the previous sample
+	Keystrokes    uint64    `json:"keystrokes"`     // since the previous sample
+}
+
+// Scores returns the samples recorded in [from, to).
+func Scores(from, to time.Time) ([]ScorePoint, error) {
+	samples, err := history.Read(from, to)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ScorePoint, len(samples))
+	for i, s := range samples {
+		out[i] = ScorePoint{
+			Time:          s.Time,
+			Score:         s.Score,
+			Locked:        s.Locked,
+			Profile:       s.Profile,
+			Kills:         s.Kills,
+			ActiveSeconds: s.ActiveSeconds,
+			Keystrokes:    s.Keystrokes,
+		}
+	}
+	return out, nil
+}
+
+// AppUsage is the focused time of one app.
+type AppUsage struct {
+	App     string  `json:"app"`
+	Seconds float64 `json:"seconds"`
+}
+
+// AppTraffic is the network traffic of one app.
+type AppTraffic struct {
+	App   string `json:"app"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// Usage is the screen time of the days in a range.
+type Usage struct {
+	Days    []surveillance.DayUsage `json:"days"`
+	Apps    []AppUsage              `json:"apps"`    // totals over Days, most used first
+	Traffic []AppTraffic            `json:"traffic"` // totals over Days, most bytes first
+}
+
+// Usage returns the usage of the local days that [from, to) touches, as
+// far back as surveillance keeps them.
+func (src Source) Usage(from, to time.Time) Usage {
+	today := time.Now()
+	days := int(midnight(today).Sub(midnight(from)).Hours()/24+0.5) + 1
+	days = max(1, min(days, surveillance.UsageHistoryDays))
+	first, last := from.Local().Format("2006-01-02"), to.Add(-time.Nanosecond).Local().Format("2006-01-02")
+
+	u := Usage{Days: []surveillance.DayUsage{}, Apps: []AppUsage{}, Traffic: []AppTraffic{}}
+	totals := make(map[string]float64)
+	bytes := make(map[string]uint64)
+	for _, d := range src.DailyUsage(days) {
+		if d.Date < first || d.Date > last {
+			continue
+		}
+		u.Days = append(u.Days, d)
+		for app, secs := range d.Apps {
+			totals[app] += secs
+		}
+		for app, n := range d.Network {
+			bytes[app] += n
+		}
+	}
+	for app, secs := range totals {
+		u.Apps = append(u.Apps, AppUsage{App: app, Seconds: secs})
+	}
+	sort.Slice(u.Apps, func(i, j int) bool {
+		if u.Apps[i].Seconds != u.Apps[j].Seconds {
+			return u.Apps[i].Seconds > u.Apps[j].Seconds
+		}
+		return u.Apps[i].App < u.Apps[j].App
+	})
+	for app, n := range bytes {
+		u.Traffic = append(u.Traffic, AppTraffic{App: app, Bytes: n})
+	}
+	sort.Slice(u.Traffic, func(i, j int) bool {
+		if u.Traffic[i].Bytes != u.Traffic[j].Bytes {
+			return u.Traffic[i].Bytes > u.Traffic[j].Bytes
+		}
+		return u.Traffic[i].App < u.Traffic[j].App
+	})
+	return u
+}
+
+func midnight(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// Submission is the outcome of one penance attempt.
+type Submission struct {
+	Time     time.Time `json:"time"`
+	Outcome  string    `json:"outcome"` // completed, failed, typing_passed or typing_failed
+	Reason   string    `json:"reason,omitempty"`
+	Category string    `json:"category,omitempty"` // of a failure; see penance.Categorize
+	Details  string    `json:"details"`
+}
+
+// outcomes maps the PENANCE events that end an attempt to outcomes.
+var outcomes = map[string]string{
+	"COMPLETED":          "completed",
+	"FAILURE":            "failed",
+	"FAILURE_REPORTED":   "failed",
+	"TYPING_TEST_PASSED": "typing_passed",
+	"TYPING_TEST_FAILED": "typing_failed",
+}
+
+// Submissions returns the penance outcomes logged in [from, to).
+func (src Source) Submissions(from, to time.Time) ([]Submission, error) {
+	out := []Submission{}
+	err := vexlog.ReadEntries(src.LogPath, from, to, func(e vexlog.Entry) {
+		outcome, ok := outcomes[e.Event]
+		if e.Module != "PENANCE" || !ok {
+			return
+		}
+		s := Submission{Time: e.Time, Outcome: outcome, Details: e.Details}
+		for _, word := range strings.Fields(e.Details) {
+			if v, ok := strings.CutPrefix(word, "reason="); ok {
+				s.Reason = v
+			}
+			if v, ok := strings.CutPrefix(word, "category="); ok {
+				s.Category = v
+			}
+		}
+		if outcome == "failed" && s.Category == "" {
+			s.Category = penance.Categorize(s.Reason) // logged before categories
+		}
+		out = append(out, s)
+	})
+	return out, err
+}
+
+// CategoryCount is how many failures of one category were logged.
+type CategoryCount struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+// Failures returns the failures logged in [from, to) by category, most
+// frequent first.
+func (src Source) Failures(from, to time.Time) ([]CategoryCount, error) {
+	subs, err := src.Submissions(from, to)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, s := range subs {
+		if s.Outcome == "failed" {
+			counts[s.Category]++
+		}
+	}
+	out := []CategoryCount{}
+	for c, n := range counts {
+		out = append(out, CategoryCount{Category: c, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Category < out[j].Category
+	})
+	return out, nil
+}
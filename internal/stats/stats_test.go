@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/history"
+	"github.com/adumbdinosaur/vex-cli/internal/surveillance"
+)
+
+func testSource(t *testing.T, now time.Time) Source {
+	t.Helper()
+	dir := t.TempDir()
+	history.File = filepath.Join(dir, "history.jsonl")
+	for i, score := range []int{0, 10, 20, 20} {
+		if err := history.Append(history.Sample{Time: now.Add(time.Duration(i-4) * time.Hour), Score: score, Locked: score > 0, Kills: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logPath := filepath.Join(dir, "vex-cli.log")
+	stamp := now.Add(-time.Hour).Format("2006/01/02 15:04:05")
+	lines := []string{
+		"[PENANCE] FAILURE: reason=submission_rejected score=10",
+		"[PENANCE] FAILURE_REPORTED: reason=backspace_violation category=backspace score=20",
+		"[PENANCE] LINE_ACCEPTED: line=1 words=9 total_words=9",
+		"[PENANCE] COMPLETED: total_completed=1 locked=false",
+		"[GUARDIAN] KILLED: app=steam pid=42",
+	}
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString("[VEX-CLI] " + stamp + " " + l + "\n")
+	}
+	if err := os.WriteFile(logPath, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return Source{
+		LogPath: logPath,
+		DailyUsage: func(days int) []surveillance.DayUsage {
+			out := make([]surveillance.DayUsage, days)
+			for i := range out {
+				out[i] = surveillance.DayUsage{
+					Date:    now.AddDate(0, 0, i-days+1).Format("2006-01-02"),
+					Apps:    map[string]float64{"firefox": 600, "steam": float64(100 * (i + 1))},
+					Network: map[string]uint64{"steam": 1 << 30, "firefox": 1 << 20},
+				}
+			}
+			return out
+		},
+	}
+}
+
+func get(t *testing.T, h http.Handler, method, path, body string, v any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer tok")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK && v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: malformed response %v\n%s", path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPI(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	h := Handler(testSource(t, now), "tok")
+
+	var scores []ScorePoint
+	if code := get(t, h, "GET", "/api/score", "", &scores); code != http.StatusOK || len(scores) != 4 || scores[2].Score != 20 || !scores[2].Locked {
+		t.Errorf("Unexpected scores %d %+v", code, scores)
+	}
+	from := now.Add(-150 * time.Minute).UnixMilli()
+	if get(t, h, "GET", "/api/score?from="+strconv.FormatInt(from, 10), "", &scores); len(scores) != 2 {
+		t.Errorf("Expected from= to limit the range, got %+v", scores)
+	}
+
+	var usage Usage
+	get(t, h, "GET", "/api/usage", "", &usage)
+	if len(usage.Apps) != 2 || usage.Apps[0].App != "firefox" || len(usage.Days) == 0 {
+		t.Errorf("Unexpected usage %+v", usage)
+	}
+	if len(usage.Traffic) != 2 || usage.Traffic[0].App != "steam" || usage.Traffic[0].Bytes%(1<<30) != 0 {
+		t.Errorf("Unexpected traffic %+v", usage.Traffic)
+	}
+
+	var subs []Submission
+	get(t, h, "GET", "/api/submissions", "", &subs)
+	if len(subs) != 3 || subs[0].Outcome != "failed" || subs[0].Reason != "submission_rejected" || subs[0].Category != "submission" || subs[2].Outcome != "completed" {
+		t.Errorf("Unexpected submissions %+v", subs)
+	}
+
+	var failures []CategoryCount
+	get(t, h, "GET", "/api/failures", "", &failures)
+	if len(failures) != 2 || failures[0].Category != "backspace" || failures[1].Category != "submission" || failures[1].Count != 1 {
+		t.Errorf("Unexpected failures %+v", failures)
+	}
+
+	if code := get(t, h, "GET", "/api/score?from=yesterday", "", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad time, got %d", code)
+	}
+	req := httptest.NewRequest("GET", "/api/score", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", rec.Code)
+	}
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	h := Handler(testSource(t, now), "tok")
+
+	var names []string
+	if get(t, h, "POST", "/search", "{}", &names); len(names) != len(targetNames) {
+		t.Errorf("Unexpected targets %v", names)
+	}
+
+	body := `{"range":{"from":` + jsonString(now.Add(-5*time.Hour)) + `,"to":` + jsonString(now) + `},
+		"maxDataPoints":2,
+		"targets":[{"target":"score","refId":"A"},{"target":"usage_per_app","refId":"B"},{"target":"submissions","refId":"C"},{"target":""}]}`
+	var results []map[string]any
+	if code := get(t, h, "POST", "/query", body, &results); code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("Unexpected query results %d %v", code, results)
+	}
+	points := results[0]["datapoints"].([]any)
+	if results[0]["target"] != "score" || len(points) > 2 || points[len(points)-1].([]any)[0] != 20.0 {
+		t.Errorf("Expected score bucketed to 2 points, got %v", results[0])
+	}
+	if results[1]["type"] != "table" || len(results[1]["rows"].([]any)) != 2 {
+		t.Errorf("Unexpected usage table %v", results[1])
+	}
+	if rows := results[2]["rows"].([]any); len(rows) != 3 || rows[1].([]any)[3] != "backspace" || rows[2].([]any)[1] != "completed" {
+		t.Errorf("Unexpected submissions table %v", results[2])
+	}
+
+	if code := get(t, h, "POST", "/query", `{"range":{"from":`+jsonString(now.Add(-time.Hour))+`,"to":`+jsonString(now)+`},"targets":[{"target":"nope"}]}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown target, got %d", code)
+	}
+}
+
+func jsonString(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
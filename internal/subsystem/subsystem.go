@@ -0,0 +1,101 @@
+// Package subsystem records how each enforcing subsystem runs: enforcing,
+// in dry-run, or off.  vexd's --dry-run puts every subsystem in dry-run;
+// config.json can set them one by one.
+//
+// The places where a subsystem changes the kernel (qdiscs, cgroups,
+// nftables, kills, OOM scores, keyboard grabs) or escalates call Skip
+// first.  In dry-run they log what they would have done and carry on as if it had
+// worked, so the daemon's state is tracked as usual.  Off is silent, and
+// vexd does not start the subsystem at all.
+package subsystem
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+)
+
+// Mode is how a subsystem runs.
+type Mode string
+
+const (
+	Enforce Mode = "enforce"
+	DryRun  Mode = "dry-run"
+	Off     Mode = "off"
+)
+
+// The subsystems that can be switched.
+const (
+	Throttler    = "throttler"    // network shaping and the CPU limit
+	Guardian     = "guardian"     // firewall, process reaper, OOM score
+	Surveillance = "surveillance" // keyboard and pointer listeners, input latency, blackout
+	AntiTamper   = "antitamper"   // integrity checks and escalation
+	Media        = "media"        // backlight and audio caps
+)
+
+// Names lists the subsystems in start order.
+var Names = []string{Throttler, Guardian, Surveillance, AntiTamper, Media}
+
+var (
+	mu     sync.Mutex
+	modes  = make(map[string]Mode)
+	allDry bool
+)
+
+// Valid reports whether m is a mode.
+func Valid(m Mode) bool {
+	return m == Enforce || m == DryRun || m == Off
+}
+
+// Known reports whether name is one of Names.
+func Known(name string) bool {
+	return slices.Contains(Names, name)
+}
+
+// Get returns the mode of the named subsystem, Enforce unless set.
+func Get(name string) Mode {
+	mu.Lock()
+	defer mu.Unlock()
+	if allDry {
+		return DryRun
+	}
+	if m, ok := modes[name]; ok {
+		return m
+	}
+	return Enforce
+}
+
+// Set changes the mode of the named subsystem.
+func Set(name string, m Mode) {
+	mu.Lock()
+	defer mu.Unlock()
+	modes[name] = m
+}
+
+// DryRunAll puts every subsystem in dry-run for the life of the process,
+// whatever Set is told afterwards, so a config reload cannot take it out.
+func DryRunAll() {
+	mu.Lock()
+	defer mu.Unlock()
+	allDry = true
+}
+
+// Enabled reports whether the named subsystem runs at all.
+func Enabled(name string) bool {
+	return Get(name) != Off
+}
+
+// Skip reports whether the named subsystem must leave the kernel alone.
+// In dry-run it logs the change it would have made, described by format
+// and args, e.g. Skip(Guardian, "kill %s (pid %d)", name, pid).
+func Skip(name, format string, args ...any) bool {
+	switch Get(name) {
+	case DryRun:
+		log.Printf("[DRY-RUN] %s: would %s", name, fmt.Sprintf(format, args...))
+		return true
+	case Off:
+		return true
+	}
+	return false
+}
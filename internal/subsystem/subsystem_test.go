@@ -0,0 +1,32 @@
+package subsystem
+
+import "testing"
+
+func TestSkip(t *testing.T) {
+	defer Set(Guardian, Enforce)
+
+	if Skip(Guardian, "kill %s", "steam") {
+		t.Error("Expected an enforcing subsystem not to skip")
+	}
+	for _, m := range []Mode{DryRun, Off} {
+		Set(Guardian, m)
+		if !Skip(Guardian, "kill %s", "steam") {
+			t.Errorf("Expected %s to skip", m)
+		}
+	}
+	if Enabled(Guardian) || !Enabled(Throttler) {
+		t.Errorf("Expected only the guardian to be off, got guardian=%s throttler=%s", Get(Guardian), Get(Throttler))
+	}
+}
+
+func TestDryRunAll(t *testing.T) {
+	defer func() { allDry = false }()
+	defer Set(Throttler, Enforce)
+
+	Set(Throttler, Off)
+	DryRunAll()
+	Set(Guardian, Enforce)
+	if Get(Throttler) != DryRun || Get(Guardian) != DryRun {
+		t.Errorf("Expected every subsystem in dry-run, got throttler=%s guardian=%s", Get(Throttler), Get(Guardian))
+	}
+}
@@ -0,0 +1,145 @@
+// Package supervisor runs the daemon's long-lived workers (the process
+// reaper, keyboard listeners, DNS refresh, periodic checks) and restarts
+// the ones that fail.
+//
+// A worker is a function that runs until it stops.  Returning nil means it
+// is done, e.g. its device was unplugged or it was told to stop, and it is
+// forgotten.  Returning an error or panicking is a failure: it is logged,
+// and the worker is started again after a backoff that doubles from
+// MinBackoff up to MaxBackoff, going back to MinBackoff once the worker
+// has stayed up for StableAfter.  Status reports every worker for the
+// health output.
+package supervisor
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	vexlog "github.com/adumbdinosaur/vex-cli/internal/logging"
+)
+
+var (
+	// MinBackoff is the delay before restarting a worker that failed once.
+	MinBackoff = time.Second
+
+	// MaxBackoff caps the delay, which doubles with every failure in a row.
+	MaxBackoff = 5 * time.Minute
+
+	// StableAfter is how long a worker must run before its next failure
+	// counts as the first in a row again.
+	StableAfter = 10 * time.Minute
+)
+
+// Worker states.
+const (
+	Running    = "running"
+	Restarting = "restarting" // failed, waiting out the backoff
+)
+
+// Worker is the health of one supervised worker.
+type Worker struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Since       string `json:"since"`                  // RFC3339 start of the current state
+	Restarts    int    `json:"restarts,omitempty"`     // since the daemon started
+	LastError   string `json:"last_error,omitempty"`   // the latest failure, or the panic value
+	LastFailure string `json:"last_failure,omitempty"` // RFC3339
+}
+
+var (
+	mu      sync.Mutex
+	workers = make(map[string]*Worker)
+)
+
+// Go runs fn as the worker called name in its own goroutine, restarting
+// it whenever it fails.  A worker already running under the same name is
+// left to finish but is no longer reported.
+func Go(name string, fn func() error) {
+	w := &Worker{Name: name}
+	mu.Lock()
+	workers[name] = w
+	mu.Unlock()
+
+	go func() {
+		failures := 0
+		for {
+			started := time.Now()
+			set(w, func() {
+				w.State = Running
+				w.Since = started.UTC().Format(time.RFC3339)
+			})
+
+			err := run(fn)
+			if err == nil {
+				mu.Lock()
+				if workers[name] == w {
+					delete(workers, name)
+				}
+				mu.Unlock()
+				return
+			}
+
+			if time.Since(started) >= StableAfter {
+				failures = 0
+			}
+			delay := Backoff(failures)
+			failures++
+			now := time.Now().UTC().Format(time.RFC3339)
+			set(w, func() {
+				w.State = Restarting
+				w.Since = now
+				w.Restarts++
+				w.LastError = err.Error()
+				w.LastFailure = now
+			})
+			log.Printf("Supervisor: %s failed: %v (restarting in %s)", name, err, delay)
+			vexlog.LogEvent("SUPERVISOR", "WORKER_FAILED",
+				fmt.Sprintf("worker=%s, error=%v, restart_in=%s", name, err, delay))
+			time.Sleep(delay)
+		}
+	}()
+}
+
+// run calls fn, turning a panic into an error.
+func run(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Supervisor: panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// Backoff is the delay before the restart that follows the given number
+// of earlier failures in a row.
+func Backoff(failures int) time.Duration {
+	d := MinBackoff
+	for i := 0; i < failures && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, MaxBackoff)
+}
+
+// set changes w under the lock.
+func set(w *Worker, fn func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	fn()
+}
+
+// Status returns every worker, sorted by name.
+func Status() []Worker {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make([]Worker, 0, len(workers))
+	for _, w := range workers {
+		out = append(out, *w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,82 @@
+package supervisor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGo(t *testing.T) {
+	MinBackoff = time.Millisecond
+	defer func() { MinBackoff = time.Second }()
+
+	runs := make(chan int, 10)
+	n := 0
+	Go("test.worker", func() error {
+		n++
+		runs <- n
+		switch n {
+		case 1:
+			return errors.New("device gone")
+		case 2:
+			panic("nil map")
+		}
+		return nil
+	})
+
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-runs:
+			if got != want {
+				t.Fatalf("Expected run %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Worker was not restarted for run %d", want)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(Status()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a finished worker to be forgotten, got %+v", Status())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	MinBackoff = time.Hour
+	defer func() { MinBackoff = time.Second }()
+
+	Go("test.failing", func() error { return errors.New("boom") })
+	deadline := time.Now().Add(time.Second)
+	for {
+		ws := Status()
+		if len(ws) == 1 && ws[0].State == Restarting {
+			if ws[0].Restarts != 1 || ws[0].LastError != "boom" {
+				t.Errorf("Unexpected worker %+v", ws[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the worker to wait out its backoff, got %+v", ws)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	delete(workers, "test.failing")
+	mu.Unlock()
+}
+
+func TestBackoff(t *testing.T) {
+	for failures, want := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+	} {
+		if got := Backoff(failures); got != want {
+			t.Errorf("Backoff(%d) = %s, expected %s", failures, got, want)
+		}
+	}
+	if got := Backoff(100); got != MaxBackoff {
+		t.Errorf("Expected the backoff to be capped at %s, got %s", MaxBackoff, got)
+	}
+}
@@ -0,0 +1,117 @@
+package surveillance
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/clock"
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// ---------------------------------------------------------------------
+// Input Blackout
+// ---------------------------------------------------------------------
+//
+// A blackout grabs every keyboard through the latency relay and drops all
+// key presses until it expires — a forced break.  Holding the escape chord
+// ends it immediately for emergencies; an events.BlackoutEnded tells the
+// daemon so it can audit the escape.
+
+// BlackoutEscapeChord must be held together to break out of a blackout.
+var BlackoutEscapeChord = []evdev.EvCode{
+	evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_LEFTSHIFT, evdev.KEY_ESC,
+}
+
+var (
+	blackoutUntil time.Time // guarded by latencyMu
+	blackoutTimer *clock.Timer
+)
+
+// StartInputBlackout drops all keyboard input for d.  If a blackout is
+// already running it is extended, never shortened.
+func StartInputBlackout(d time.Duration) (time.Time, error) {
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("blackout duration must be positive")
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	until := clock.Now().Add(d)
+	if blackoutUntil.After(until) {
+		until = blackoutUntil
+	}
+	blackoutUntil = until
+
+	if blackoutTimer != nil {
+		blackoutTimer.Stop()
+	}
+	blackoutTimer = clock.AfterFunc(clock.Until(until), expireBlackout)
+
+	err := syncRelaysLocked()
+	log.Printf("Surveillance: Input blackout until %s", until.Format(time.RFC3339))
+	return until, err
+}
+
+// EndInputBlackout lifts an active blackout.  escaped marks an emergency
+// exit via the escape chord.
+func EndInputBlackout(escaped bool) {
+	latencyMu.Lock()
+	if blackoutUntil.IsZero() {
+		latencyMu.Unlock()
+		return
+	}
+	blackoutUntil = time.Time{}
+	if blackoutTimer != nil {
+		blackoutTimer.Stop()
+		blackoutTimer = nil
+	}
+	if err := syncRelaysLocked(); err != nil {
+		log.Printf("Surveillance: %v", err)
+	}
+	latencyMu.Unlock()
+
+	if escaped {
+		log.Println("Surveillance: Input blackout ESCAPED via emergency chord")
+		events.Publish(events.BlackoutEnded{Escaped: true})
+	} else {
+		log.Println("Surveillance: Input blackout lifted")
+	}
+}
+
+func expireBlackout() {
+	latencyMu.Lock()
+	if blackoutUntil.IsZero() || clock.Now().Before(blackoutUntil) {
+		latencyMu.Unlock()
+		return // already lifted, or extended after the timer fired
+	}
+	latencyMu.Unlock()
+
+	EndInputBlackout(false)
+	log.Println("Surveillance: Input blackout expired")
+	events.Publish(events.BlackoutEnded{Escaped: false})
+}
+
+// InputBlackoutUntil returns when the active blackout ends, or the zero
+// time if none is running.
+func InputBlackoutUntil() time.Time {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	if clock.Now().Before(blackoutUntil) {
+		return blackoutUntil
+	}
+	return time.Time{}
+}
+
+// escapeChordHeld reports whether every escape-chord key is down.
+// Callers hold rl.mu.
+func (rl *relay) escapeChordHeld() bool {
+	for _, code := range BlackoutEscapeChord {
+		if !rl.held[code] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package surveillance
+
+import (
+	"sync"
+	"time"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// ---------------------------------------------------------------------
+// Typing-Test Capture
+// ---------------------------------------------------------------------
+//
+// A typing test needs the text actually typed on the keyboard, not what
+// arrives on the CLI's stdin (which can be piped).  While a capture is
+// running, key presses from every monitored keyboard are translated with
+// a US QWERTY map into a text buffer.  This is the only exception to the
+// zero-storage policy: nothing is captured outside an explicit test, and
+// the buffer is dropped when the test ends.
+
+// TypingCapture is the text and timing typed during a test.
+type TypingCapture struct {
+	Text       string    `json:"text"`
+	Keystrokes int       `json:"keystrokes"` // presses that produced or erased input
+	Backspaces int       `json:"backspaces"`
+	First      time.Time `json:"first"`
+	Last       time.Time `json:"last"`
+}
+
+// KPM returns the keystroke rate between the first and last key press.
+func (c TypingCapture) KPM() float64 {
+	span := c.Last.Sub(c.First)
+	if span < time.Second {
+		return 0
+	}
+	return float64(c.Keystrokes) / span.Minutes()
+}
+
+var (
+	captureMu     sync.Mutex
+	capture       *TypingCapture
+	captureBuf    []rune
+	captureShifts = make(map[evdev.EvCode]bool)
+)
+
+// BeginTypingCapture starts recording typed text, discarding any previous
+// capture.
+func BeginTypingCapture() {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	capture = &TypingCapture{}
+	captureBuf = nil
+}
+
+// TypingCaptureSnapshot returns the capture so far without ending it.
+func TypingCaptureSnapshot() (TypingCapture, bool) {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	if capture == nil {
+		return TypingCapture{}, false
+	}
+	c := *capture
+	c.Text = string(captureBuf)
+	return c, true
+}
+
+// EndTypingCapture stops recording and returns the result.
+func EndTypingCapture() (TypingCapture, bool) {
+	c, ok := TypingCaptureSnapshot()
+	captureMu.Lock()
+	capture, captureBuf = nil, nil
+	captureMu.Unlock()
+	return c, ok
+}
+
+// captureKey feeds one EV_KEY event into the active capture.  Shift state
+// is tracked from press and release events; auto-repeat types like a
+// press but is not counted as a keystroke.
+func captureKey(code evdev.EvCode, value int32, at time.Time) {
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	if code == evdev.KEY_LEFTSHIFT || code == evdev.KEY_RIGHTSHIFT {
+		captureShifts[code] = value != 0
+		return
+	}
+	if capture == nil || value == 0 {
+		return
+	}
+
+	shift := captureShifts[evdev.KEY_LEFTSHIFT] || captureShifts[evdev.KEY_RIGHTSHIFT]
+	switch code {
+	case evdev.KEY_BACKSPACE:
+		capture.Backspaces++
+		if KeySuppressionActive() {
+			break // the key never reached the terminal
+		}
+		if n := len(captureBuf); n > 0 {
+			captureBuf = captureBuf[:n-1]
+		}
+	default:
+		r, ok := usKeymap(code, shift)
+		if !ok {
+			return
+		}
+		captureBuf = append(captureBuf, r)
+	}
+
+	if value == 1 {
+		capture.Keystrokes++
+		if capture.First.IsZero() {
+			capture.First = at
+		}
+		capture.Last = at
+	}
+}
+
+type keyPair struct{ plain, shifted rune }
+
+var usKeys = map[evdev.EvCode]keyPair{
+	evdev.KEY_1: {'1', '!'}, evdev.KEY_2: {'2', '@'}, evdev.KEY_3: {'3', '#'},
+	evdev.KEY_4: {'4', '$'}, evdev.KEY_5: {'5', '%'}, evdev.KEY_6: {'6', '^'},
+	evdev.KEY_7: {'7', '&'}, evdev.KEY_8: {'8', '*'}, evdev.KEY_9: {'9', '('},
+	evdev.KEY_0: {'0', ')'}, evdev.KEY_MINUS: {'-', '_'}, evdev.KEY_EQUAL: {'=', '+'},
+	evdev.KEY_LEFTBRACE: {'[', '{'}, evdev.KEY_RIGHTBRACE: {']', '}'},
+	evdev.KEY_SEMICOLON: {';', ':'}, evdev.KEY_APOSTROPHE: {'\'', '"'},
+	evdev.KEY_GRAVE: {'`', '~'}, evdev.KEY_BACKSLASH: {'\\', '|'},
+	evdev.KEY_COMMA: {',', '<'}, evdev.KEY_DOT: {'.', '>'}, evdev.KEY_SLASH: {'/', '?'},
+	evdev.KEY_SPACE: {' ', ' '},
+}
+
+var usLetters = map[evdev.EvCode]rune{
+	evdev.KEY_A: 'a', evdev.KEY_B: 'b', evdev.KEY_C: 'c', evdev.KEY_D: 'd',
+	evdev.KEY_E: 'e', evdev.KEY_F: 'f', evdev.KEY_G: 'g', evdev.KEY_H: 'h',
+	evdev.KEY_I: 'i', evdev.KEY_J: 'j', evdev.KEY_K: 'k', evdev.KEY_L: 'l',
+	evdev.KEY_M: 'm', evdev.KEY_N: 'n', evdev.KEY_O: 'o', evdev.KEY_P: 'p',
+	evdev.KEY_Q: 'q', evdev.KEY_R: 'r', evdev.KEY_S: 's', evdev.KEY_T: 't',
+	evdev.KEY_U: 'u', evdev.KEY_V: 'v', evdev.KEY_W: 'w', evdev.KEY_X: 'x',
+	evdev.KEY_Y: 'y', evdev.KEY_Z: 'z',
+}
+
+// usKeymap translates a key code to the character it types on a US
+// layout.  Caps Lock is not tracked.
+func usKeymap(code evdev.EvCode, shift bool) (rune, bool) {
+	if r, ok := usLetters[code]; ok {
+		if shift {
+			r -= 'a' - 'A'
+		}
+		return r, true
+	}
+	if p, ok := usKeys[code]; ok {
+		if shift {
+			return p.shifted, true
+		}
+		return p.plain, true
+	}
+	return 0, false
+}
@@ -0,0 +1,196 @@
+package surveillance
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------
+// Keystroke Dynamics
+// ---------------------------------------------------------------------
+//
+// Every key press contributes the interval since the previous press to a
+// log-spaced histogram.  Everyday typing builds the subject's baseline
+// profile; while a penance or writing task is in progress the intervals go
+// to a separate sample instead, which is compared against the baseline to
+// estimate whether the subject is the one typing.  Only timings are kept,
+// never key codes.
+
+// TypingProfileFile persists the baseline across restarts.
+var TypingProfileFile = "/var/lib/vex-cli/typing-profile.json"
+
+const (
+	dynBins        = 24
+	dynMinInterval = 20 * time.Millisecond
+	dynMaxInterval = 2 * time.Second // longer gaps are pauses, not rhythm
+
+	// dynDecayAt halves the baseline once it holds this many samples so
+	// the profile follows gradual changes in the subject's typing.
+	dynDecayAt = 50000
+)
+
+var (
+	// MinBaselineSamples is the baseline size needed before matches are
+	// considered meaningful.
+	MinBaselineSamples uint64 = 500
+
+	// MinSampleKeys is the number of intervals a task sample needs.
+	MinSampleKeys uint64 = 40
+
+	// AnomalyThreshold is the confidence below which a submission is
+	// flagged as possibly typed by someone else.
+	AnomalyThreshold = 0.6
+
+	dynMu       sync.Mutex
+	baseline    TimingHistogram
+	sample      *TimingHistogram
+	lastPressAt time.Time
+)
+
+// TimingHistogram counts inter-key intervals in log-spaced bins.
+type TimingHistogram struct {
+	Bins  [dynBins]uint64 `json:"bins"`
+	Total uint64          `json:"total"`
+}
+
+// TypingMatch describes how closely a task sample matches the baseline.
+type TypingMatch struct {
+	Confidence float64 `json:"confidence"` // 0..1, Bhattacharyya coefficient
+	Samples    uint64  `json:"samples"`
+	Sufficient bool    `json:"sufficient"` // enough data on both sides
+}
+
+// Anomalous reports whether the match is reliable and below threshold.
+func (m TypingMatch) Anomalous() bool {
+	return m.Sufficient && m.Confidence < AnomalyThreshold
+}
+
+func (h *TimingHistogram) add(d time.Duration) {
+	if d < dynMinInterval || d > dynMaxInterval {
+		return
+	}
+	pos := math.Log(float64(d)/float64(dynMinInterval)) /
+		math.Log(float64(dynMaxInterval)/float64(dynMinInterval))
+	i := int(pos * dynBins)
+	if i >= dynBins {
+		i = dynBins - 1
+	}
+	h.Bins[i]++
+	h.Total++
+
+	if h.Total >= dynDecayAt {
+		h.Total = 0
+		for j := range h.Bins {
+			h.Bins[j] /= 2
+			h.Total += h.Bins[j]
+		}
+	}
+}
+
+// Similarity returns the Bhattacharyya coefficient of two histograms:
+// 1 for identical distributions, 0 for disjoint ones.
+func Similarity(a, b *TimingHistogram) float64 {
+	if a.Total == 0 || b.Total == 0 {
+		return 0
+	}
+	var bc float64
+	for i := range a.Bins {
+		bc += math.Sqrt(float64(a.Bins[i]) / float64(a.Total) * float64(b.Bins[i]) / float64(b.Total))
+	}
+	return bc
+}
+
+// recordKeyTiming feeds one key press into the baseline or active sample.
+func recordKeyTiming(at time.Time) {
+	dynMu.Lock()
+	defer dynMu.Unlock()
+
+	if !lastPressAt.IsZero() {
+		d := at.Sub(lastPressAt)
+		if sample != nil {
+			sample.add(d)
+		} else {
+			baseline.add(d)
+		}
+	}
+	lastPressAt = at
+}
+
+// BeginTypingSample starts capturing a task sample.  Intervals are kept out
+// of the baseline until EndTypingSample so another typist cannot skew it.
+func BeginTypingSample() {
+	dynMu.Lock()
+	defer dynMu.Unlock()
+	sample = &TimingHistogram{}
+}
+
+// TypingSampleActive reports whether a task sample is being captured.
+func TypingSampleActive() bool {
+	dynMu.Lock()
+	defer dynMu.Unlock()
+	return sample != nil
+}
+
+// CurrentTypingMatch compares the active sample with the baseline without
+// ending it.
+func CurrentTypingMatch() TypingMatch {
+	dynMu.Lock()
+	defer dynMu.Unlock()
+	return matchLocked()
+}
+
+// EndTypingSample stops capturing and returns the final match.
+func EndTypingSample() TypingMatch {
+	dynMu.Lock()
+	defer dynMu.Unlock()
+	m := matchLocked()
+	sample = nil
+	return m
+}
+
+func matchLocked() TypingMatch {
+	if sample == nil {
+		return TypingMatch{}
+	}
+	return TypingMatch{
+		Confidence: Similarity(&baseline, sample),
+		Samples:    sample.Total,
+		Sufficient: baseline.Total >= MinBaselineSamples && sample.Total >= MinSampleKeys,
+	}
+}
+
+// -- Persistence (see EnablePersistence) --
+
+func loadTypingProfile() {
+	data, err := os.ReadFile(TypingProfileFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Surveillance: Failed to read typing profile: %v", err)
+		}
+		return
+	}
+	var h TimingHistogram
+	if err := json.Unmarshal(data, &h); err != nil {
+		log.Printf("Surveillance: Ignoring corrupt typing profile: %v", err)
+		return
+	}
+
+	dynMu.Lock()
+	baseline = h
+	dynMu.Unlock()
+	log.Printf("Surveillance: Loaded typing profile (%d samples)", h.Total)
+}
+
+func saveTypingProfile() error {
+	dynMu.Lock()
+	data, err := json.Marshal(&baseline)
+	dynMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(TypingProfileFile, data)
+}
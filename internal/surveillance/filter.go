@@ -0,0 +1,135 @@
+package surveillance
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ---------------------------------------------------------------------
+// Device Selection
+// ---------------------------------------------------------------------
+//
+// By default every device that looks like a keyboard is attached.  The
+// VEX_INPUT_INCLUDE and VEX_INPUT_EXCLUDE environment variables refine
+// this with comma-separated patterns, each of which is one of:
+//
+//   /dev/input/…   a device path (glob allowed); by-id/by-path symlinks
+//                  are resolved
+//   046d:c52b      a USB vendor:product ID (hex)
+//   *macro pad*    a device name glob, case-insensitive
+//
+// Exclusions always win.  When an include list is set only matching
+// devices are attached, and they are attached even if the keyboard
+// heuristic would have skipped them.
+
+// DeviceFilter holds the include/exclude patterns.
+type DeviceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+var (
+	deviceFilter DeviceFilter
+
+	vendorProductPattern = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{4}$`)
+)
+
+// loadDeviceFilter reads the patterns from the environment.
+func loadDeviceFilter() DeviceFilter {
+	f := DeviceFilter{
+		Include: splitPatterns(os.Getenv("VEX_INPUT_INCLUDE")),
+		Exclude: splitPatterns(os.Getenv("VEX_INPUT_EXCLUDE")),
+	}
+	if len(f.Include) > 0 {
+		log.Printf("Surveillance: Only attaching devices matching %q", f.Include)
+	}
+	if len(f.Exclude) > 0 {
+		log.Printf("Surveillance: Ignoring devices matching %q", f.Exclude)
+	}
+	return f
+}
+
+func splitPatterns(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// shouldAttach reports whether dev should be monitored as a keyboard.
+func shouldAttach(dev InputDevice) bool {
+	if isRelayDevice(dev) {
+		return false // our own uinput output
+	}
+	return deviceFilter.Allows(dev)
+}
+
+// shouldAttachPointer reports whether dev should be watched for activity
+// as a mouse or touchpad.
+func shouldAttachPointer(dev InputDevice) bool {
+	if isRelayDevice(dev) || !isPointer(dev) {
+		return false
+	}
+	f := deviceFilter
+	return !f.excludes(dev) && (len(f.Include) == 0 || f.includes(dev))
+}
+
+// Allows applies the filter to dev, falling back to the keyboard
+// heuristic when no include list is configured.  An included mouse is
+// still not treated as a keyboard.
+func (f DeviceFilter) Allows(dev InputDevice) bool {
+	if f.excludes(dev) {
+		return false
+	}
+	if len(f.Include) == 0 {
+		return isKeyboard(dev)
+	}
+	return f.includes(dev) && !isPointer(dev)
+}
+
+func (f DeviceFilter) excludes(dev InputDevice) bool {
+	for _, p := range f.Exclude {
+		if matchDevice(p, dev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f DeviceFilter) includes(dev InputDevice) bool {
+	for _, p := range f.Include {
+		if matchDevice(p, dev) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDevice reports whether dev matches a single pattern.
+func matchDevice(pattern string, dev InputDevice) bool {
+	switch {
+	case strings.HasPrefix(pattern, "/"):
+		if ok, _ := filepath.Match(pattern, dev.Fn()); ok {
+			return true
+		}
+		target, err := filepath.EvalSymlinks(pattern)
+		return err == nil && target == dev.Fn()
+	case vendorProductPattern.MatchString(pattern):
+		id, err := dev.InputID()
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(pattern, fmt.Sprintf("%04x:%04x", id.Vendor, id.Product))
+	default:
+		ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(dev.Name()))
+		return ok
+	}
+}
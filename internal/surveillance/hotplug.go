@@ -0,0 +1,126 @@
+package surveillance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ---------------------------------------------------------------------
+// Keyboard Hotplug
+// ---------------------------------------------------------------------
+//
+// /dev/input is watched with inotify so keyboards plugged in after startup
+// are attached like the ones found by the initial scan.  Removal needs no
+// watch: the listener's read fails with ENODEV and, with the node gone,
+// it detaches itself.
+
+const inputDir = "/dev/input"
+
+var (
+	// hotplugRetries and hotplugRetryDelay cover the short window where
+	// the node exists but udev has not finished setting it up.
+	hotplugRetries    = 5
+	hotplugRetryDelay = 200 * time.Millisecond
+)
+
+// watchHotplug attaches keyboards as their nodes appear.  Without inotify
+// it gives up quietly; a failed read is returned so the supervisor starts
+// the watch again.
+func watchHotplug() error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		log.Printf("Surveillance: Hotplug detection unavailable: %v", err)
+		return nil
+	}
+	defer unix.Close(fd)
+
+	if _, err := unix.InotifyAddWatch(fd, inputDir, unix.IN_CREATE|unix.IN_ATTRIB); err != nil {
+		log.Printf("Surveillance: Failed to watch %s for hotplug: %v", inputDir, err)
+		return nil
+	}
+	log.Printf("Surveillance: Watching %s for new keyboards", inputDir)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(fd, buf)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return fmt.Errorf("hotplug watch: %w", err)
+		}
+
+		for _, ev := range parseInotify(buf[:n]) {
+			if ev.mask&unix.IN_Q_OVERFLOW != 0 {
+				log.Println("Surveillance: Hotplug event queue overflowed, rescanning devices")
+				go scanDevices()
+				continue
+			}
+			if strings.HasPrefix(ev.name, "event") {
+				go handleHotplug(filepath.Join(inputDir, ev.name))
+			}
+		}
+	}
+}
+
+type inotifyEvent struct {
+	mask uint32
+	name string
+}
+
+// parseInotify decodes a buffer of raw inotify_event records.
+func parseInotify(buf []byte) []inotifyEvent {
+	var events []inotifyEvent
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		mask := binary.NativeEndian.Uint32(buf[off+4:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+		start := off + unix.SizeofInotifyEvent
+		if start+nameLen > len(buf) {
+			break
+		}
+		name := string(bytes.TrimRight(buf[start:start+nameLen], "\x00"))
+		events = append(events, inotifyEvent{mask: mask, name: name})
+		off = start + nameLen
+	}
+	return events
+}
+
+// handleHotplug attaches path if it is a keyboard or mouse we are not yet
+// watching.
+func handleHotplug(path string) {
+	if isAttached(path) {
+		return
+	}
+
+	var (
+		dev InputDevice
+		err error
+	)
+	for attempt := 0; attempt < hotplugRetries; attempt++ {
+		if dev, err = evOps.Open(path); err == nil {
+			break
+		}
+		time.Sleep(hotplugRetryDelay)
+	}
+	if err != nil {
+		log.Printf("Surveillance: Failed to open hotplugged device %s: %v", path, err)
+		return
+	}
+
+	switch {
+	case shouldAttach(dev):
+		log.Printf("Surveillance: Hotplugged keyboard detected: %s (%s)", dev.Name(), path)
+		attachDevice(dev)
+	case shouldAttachPointer(dev):
+		attachPointer(dev)
+	default:
+		dev.Close()
+	}
+}
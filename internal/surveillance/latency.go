@@ -0,0 +1,324 @@
+package surveillance
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	evdev "github.com/holoplot/go-evdev"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// ---------------------------------------------------------------------
+// Latency Injection via uinput
+// ---------------------------------------------------------------------
+//
+// While a delay is configured every monitored keyboard is grabbed
+// (EVIOCGRAB) so applications stop receiving its events directly.  The
+// listener hands each event to a relay which re-emits it through a uinput
+// clone of the keyboard once the delay has elapsed.  Event order is
+// preserved per device.  Clearing the delay flushes anything still queued
+// and releases the grab.
+//
+// In jitter mode each key press draws its own delay uniformly from a
+// range, and the events that follow it (release, SYN) reuse that draw.
+// Because the queue is FIFO a short draw behind a long one waits for it,
+// so keys never arrive out of order.
+
+// relayDevicePrefix names our uinput devices so the scanner can skip them.
+const relayDevicePrefix = "VEX Latency Relay"
+
+// relayQueueSize bounds the number of in-flight events per keyboard.  At
+// the maximum practical typing rate this covers several seconds of delay;
+// beyond that the listener simply blocks and the kernel buffers.
+const relayQueueSize = 4096
+
+// uinputPath is where relays create their clones.
+var uinputPath = "/dev/uinput"
+
+var (
+	latencyMu     sync.Mutex
+	latencyDelay  time.Duration // fixed delay, or the jitter minimum
+	latencyJitter time.Duration // width of the jitter range; 0 = fixed
+	relays        = make(map[*relay]struct{})
+)
+
+type delayedEvent struct {
+	event evdev.InputEvent
+	due   time.Time
+}
+
+// relay owns the grab and uinput clone for one physical keyboard.
+type relay struct {
+	mu    sync.Mutex
+	dev   InputDevice
+	virt  VirtualDevice
+	queue chan delayedEvent
+	stop  chan struct{}
+	held  map[evdev.EvCode]bool // keys currently down on the physical device
+	extra time.Duration         // jitter drawn for the most recent key press
+}
+
+// InjectLatency sets the programmable delay for input events.
+// When delayMs > 0, the surveillance listener intercepts keyboard events,
+// grabs the device, and re-emits them through a uinput virtual device
+// after the specified delay. Setting delayMs to 0 disables injection.
+func InjectLatency(delayMs int) error {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	if delayMs < 0 {
+		delayMs = 0
+	}
+
+	latencyDelay = time.Duration(delayMs) * time.Millisecond
+	latencyJitter = 0
+
+	err := syncRelaysLocked()
+	log.Printf("Surveillance: Input latency set to %dms", delayMs)
+	return err
+}
+
+// InjectJitter delays each key press by a random amount between minMs and
+// maxMs, which is much harder to adapt to than a fixed delay.  maxMs equal
+// to minMs behaves like InjectLatency.
+func InjectJitter(minMs, maxMs int) error {
+	if minMs < 0 || maxMs < minMs {
+		return fmt.Errorf("invalid jitter range %d-%dms", minMs, maxMs)
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	latencyDelay = time.Duration(minMs) * time.Millisecond
+	latencyJitter = time.Duration(maxMs-minMs) * time.Millisecond
+
+	err := syncRelaysLocked()
+	log.Printf("Surveillance: Input latency jitter set to %d-%dms", minMs, maxMs)
+	return err
+}
+
+// relaysNeededLocked reports whether keyboards must currently be grabbed,
+// for latency injection, an input blackout or key suppression.
+func relaysNeededLocked() bool {
+	return latencyDelay > 0 || latencyJitter > 0 || time.Now().Before(blackoutUntil) || keySuppression
+}
+
+// syncRelaysLocked engages or releases every relay to match the current
+// latency and blackout settings.  Callers hold latencyMu.
+func syncRelaysLocked() error {
+	needed := relaysNeededLocked()
+
+	var errs []string
+	for rl := range relays {
+		if needed {
+			if err := rl.engage(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", rl.dev.Fn(), err))
+			}
+		} else {
+			rl.release()
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to relay keyboard(s): %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// GetInputLatency returns the currently injected input delay (the minimum
+// when jitter is active).
+func GetInputLatency() time.Duration {
+	return getLatencyDelay()
+}
+
+// GetInputLatencyRange returns the minimum and maximum injected delay.
+// They are equal unless jitter is active.
+func GetInputLatencyRange() (min, max time.Duration) {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	return latencyDelay, latencyDelay + latencyJitter
+}
+
+// getLatencyDelay returns the current latency delay setting
+func getLatencyDelay() time.Duration {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	return latencyDelay
+}
+
+// attachRelay registers a newly opened keyboard and engages it straight
+// away if latency, a blackout or key suppression is already active.
+func attachRelay(dev InputDevice) *relay {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	rl := &relay{dev: dev, held: make(map[evdev.EvCode]bool)}
+	relays[rl] = struct{}{}
+	if relaysNeededLocked() {
+		if err := rl.engage(); err != nil {
+			log.Printf("Surveillance: Latency relay unavailable for %s: %v", dev.Fn(), err)
+		}
+	}
+	return rl
+}
+
+// detachRelay drops a keyboard whose listener has exited.
+func detachRelay(rl *relay) {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	rl.release()
+	delete(relays, rl)
+}
+
+// releaseAllRelays ungrabs every keyboard and destroys the uinput clones.
+func releaseAllRelays() {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	for rl := range relays {
+		rl.release()
+	}
+}
+
+// ProbeUinput checks that uinput devices can be created, without creating
+// one.
+func ProbeUinput() (string, error) {
+	f, err := os.OpenFile(uinputPath, os.O_WRONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return uinputPath + " writable", nil
+}
+
+func isRelayDevice(dev InputDevice) bool {
+	return strings.HasPrefix(dev.Name(), relayDevicePrefix)
+}
+
+// engage creates the uinput clone and grabs the physical device.  The
+// clone is created first so a failure never leaves the keyboard grabbed
+// with nowhere to send its events.
+func (rl *relay) engage() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.virt != nil {
+		return nil
+	}
+	if subsystem.Skip(subsystem.Surveillance, "grab %s to delay or drop its input", rl.dev.Name()) {
+		return nil
+	}
+
+	virt, err := evOps.CreateVirtual(relayDevicePrefix+": "+rl.dev.Name(), rl.dev)
+	if err != nil {
+		return fmt.Errorf("uinput: %w", err)
+	}
+	if err := rl.dev.Grab(); err != nil {
+		virt.Close()
+		return fmt.Errorf("grab: %w", err)
+	}
+
+	rl.virt = virt
+	rl.queue = make(chan delayedEvent, relayQueueSize)
+	rl.stop = make(chan struct{})
+	go rl.drain(virt, rl.queue, rl.stop)
+
+	log.Printf("Surveillance: Grabbed %s for latency injection", rl.dev.Name())
+	return nil
+}
+
+// release ungrabs the device and flushes queued events without further
+// delay.  An event read in the instant between the ungrab and this
+// returning is already visible to applications and is not re-emitted.
+func (rl *relay) release() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.virt == nil {
+		return
+	}
+
+	if err := rl.dev.Ungrab(); err != nil {
+		log.Printf("Surveillance: Failed to ungrab %s: %v", rl.dev.Name(), err)
+	}
+	close(rl.stop)
+	close(rl.queue)
+	rl.virt, rl.queue, rl.stop = nil, nil, nil
+
+	log.Printf("Surveillance: Released %s from latency injection", rl.dev.Name())
+}
+
+// forward queues an event for delayed re-emission if the relay is engaged.
+// During a blackout key presses and repeats are dropped, and while key
+// suppression is on so are the suppressed keys; releases still pass so
+// keys held when either began don't stick.
+func (rl *relay) forward(event *evdev.InputEvent) {
+	// Read shared settings before taking rl.mu; InjectLatency holds
+	// latencyMu while engaging relays.
+	latencyMu.Lock()
+	now := time.Now()
+	delay, jitter := latencyDelay, latencyJitter
+	blackout := now.Before(blackoutUntil)
+	suppress := keySuppression
+	latencyMu.Unlock()
+	if !users.OnSeat() {
+		// Someone other than the target users is at the machine: pass
+		// their input straight through.
+		delay, jitter, blackout, suppress = 0, 0, false, false
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if jitter > 0 && event.Type == evdev.EV_KEY && event.Value == 1 {
+		rl.extra = rand.N(jitter + 1)
+	} else if jitter == 0 {
+		rl.extra = 0
+	}
+	due := now.Add(delay + rl.extra)
+
+	if event.Type == evdev.EV_KEY {
+		rl.held[event.Code] = event.Value != 0
+		if blackout && rl.escapeChordHeld() {
+			go EndInputBlackout(true)
+		}
+	}
+
+	if rl.queue == nil {
+		return
+	}
+	if blackout && event.Type == evdev.EV_KEY && event.Value != 0 {
+		return
+	}
+	if suppress && rl.suppressed(event) {
+		return
+	}
+	rl.queue <- delayedEvent{event: *event, due: due}
+}
+
+// drain writes queued events to the uinput clone once they are due and
+// destroys the clone when the queue is closed.
+func (rl *relay) drain(virt VirtualDevice, queue <-chan delayedEvent, stop <-chan struct{}) {
+	defer virt.Close()
+
+	for de := range queue {
+		if wait := time.Until(de.due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+			}
+		}
+		if err := virt.WriteOne(&de.event); err != nil {
+			log.Printf("Surveillance: Failed to re-emit event on %s: %v", rl.dev.Name(), err)
+		}
+	}
+}
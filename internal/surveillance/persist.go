@@ -0,0 +1,167 @@
+package surveillance
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// ---------------------------------------------------------------------
+// Persistence
+// ---------------------------------------------------------------------
+//
+// Keystroke counters and the rolling-KPM ring are checkpointed so a daemon
+// restart in the middle of a penance does not reset KPM validation.  Daily
+// usage totals are saved with them, and the typing profile on a slower
+// cadence.
+
+var (
+	// MetricsFile holds the most recent metrics checkpoint.
+	MetricsFile = "/var/lib/vex-cli/surveillance-metrics.json"
+
+	// MetricsCheckpointInterval controls how often metrics are written.
+	MetricsCheckpointInterval = 30 * time.Second
+
+	// TypingProfileSaveInterval controls how often the baseline is written.
+	TypingProfileSaveInterval = 5 * time.Minute
+
+	persistEnabled bool
+)
+
+// metricsCheckpoint is the on-disk form of Metrics.
+type metricsCheckpoint struct {
+	Keystrokes     uint64    `json:"keystrokes"`
+	LinesCompleted uint64    `json:"lines_completed"`
+	StartTime      time.Time `json:"start_time"`
+	SavedAt        time.Time `json:"saved_at"`
+	RingCounts     []uint32  `json:"ring_counts"`
+	RingStamps     []int64   `json:"ring_stamps"`
+}
+
+// EnablePersistence restores the metrics checkpoint and typing profile and
+// keeps both saved.  Only the daemon calls this; other processes that run
+// surveillance (e.g. an interactive penance session) must not overwrite
+// the daemon's files with their own partial view.
+func EnablePersistence() {
+	if err := loadMetrics(); err != nil {
+		log.Printf("Surveillance: Failed to restore metrics: %v", err)
+	}
+	loadTypingProfile()
+	if err := loadUsage(); err != nil {
+		log.Printf("Surveillance: Failed to restore daily usage: %v", err)
+	}
+	persistEnabled = true
+	supervisor.Go("surveillance.checkpoint", checkpointLoop)
+}
+
+func checkpointLoop() error {
+	metricsTicker := time.NewTicker(MetricsCheckpointInterval)
+	profileTicker := time.NewTicker(TypingProfileSaveInterval)
+	defer metricsTicker.Stop()
+	defer profileTicker.Stop()
+
+	for {
+		select {
+		case <-metricsTicker.C:
+			if err := saveMetrics(); err != nil {
+				log.Printf("Surveillance: Failed to checkpoint metrics: %v", err)
+			}
+			if err := saveUsage(); err != nil {
+				log.Printf("Surveillance: Failed to save daily usage: %v", err)
+			}
+		case <-profileTicker.C:
+			if err := saveTypingProfile(); err != nil {
+				log.Printf("Surveillance: Failed to save typing profile: %v", err)
+			}
+		}
+	}
+}
+
+// flushPersistence writes both files immediately (used on shutdown).
+func flushPersistence() error {
+	if !persistEnabled {
+		return nil
+	}
+	if err := saveMetrics(); err != nil {
+		return err
+	}
+	if err := saveUsage(); err != nil {
+		return err
+	}
+	return saveTypingProfile()
+}
+
+func saveMetrics() error {
+	GlobalMetrics.mu.Lock()
+	cp := metricsCheckpoint{
+		Keystrokes:     GlobalMetrics.Keystrokes,
+		LinesCompleted: GlobalMetrics.LinesCompleted,
+		StartTime:      GlobalMetrics.StartTime,
+		SavedAt:        time.Now(),
+		RingCounts:     append([]uint32(nil), GlobalMetrics.recent.counts[:]...),
+		RingStamps:     append([]int64(nil), GlobalMetrics.recent.stamps[:]...),
+	}
+	GlobalMetrics.mu.Unlock()
+
+	data, err := json.Marshal(&cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(MetricsFile, data)
+}
+
+func loadMetrics() error {
+	data, err := os.ReadFile(MetricsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cp metricsCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return err
+	}
+
+	GlobalMetrics.mu.Lock()
+	defer GlobalMetrics.mu.Unlock()
+
+	// Keys counted by this process before the restore (normally none)
+	// are added on top of the checkpoint.
+	GlobalMetrics.Keystrokes += cp.Keystrokes
+	GlobalMetrics.LinesCompleted += cp.LinesCompleted
+	if !cp.StartTime.IsZero() && cp.StartTime.Before(GlobalMetrics.StartTime) {
+		GlobalMetrics.StartTime = cp.StartTime
+	}
+	if len(cp.RingCounts) == len(GlobalMetrics.recent.counts) && len(cp.RingStamps) == len(GlobalMetrics.recent.stamps) {
+		for i := range cp.RingCounts {
+			if GlobalMetrics.recent.stamps[i] == cp.RingStamps[i] {
+				GlobalMetrics.recent.counts[i] += cp.RingCounts[i]
+			} else if GlobalMetrics.recent.stamps[i] < cp.RingStamps[i] {
+				GlobalMetrics.recent.stamps[i] = cp.RingStamps[i]
+				GlobalMetrics.recent.counts[i] = cp.RingCounts[i]
+			}
+		}
+	}
+
+	log.Printf("Surveillance: Restored metrics checkpoint from %s (%d keystrokes, %d lines)",
+		cp.SavedAt.Format(time.RFC3339), cp.Keystrokes, cp.LinesCompleted)
+	return nil
+}
+
+// writeFileAtomic writes via a temp file and rename so a crash mid-write
+// never leaves a truncated checkpoint.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
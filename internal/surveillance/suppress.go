@@ -0,0 +1,67 @@
+package surveillance
+
+import (
+	"log"
+
+	evdev "github.com/holoplot/go-evdev"
+)
+
+// ---------------------------------------------------------------------
+// Selective Key Suppression
+// ---------------------------------------------------------------------
+//
+// During a no-backspace penance the editing and paste keys are removed at
+// the evdev layer: keyboards are grabbed through the latency relay and the
+// suppressed presses are simply never re-emitted.  This replaces looking
+// for \b in submitted text, which never worked in a cooked terminal where
+// the line discipline consumes erase characters before the CLI sees them.
+
+var keySuppression bool // guarded by latencyMu
+
+// SetKeySuppression enables or disables dropping Backspace, Delete and
+// paste chords (Ctrl+V, Ctrl+Shift+V, Shift+Insert) on every keyboard.
+func SetKeySuppression(on bool) error {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	if keySuppression == on {
+		return nil
+	}
+	keySuppression = on
+
+	err := syncRelaysLocked()
+	if on {
+		log.Println("Surveillance: Suppressing Backspace/Delete/paste keys")
+	} else {
+		log.Println("Surveillance: Key suppression lifted")
+	}
+	return err
+}
+
+// KeySuppressionActive reports whether editing keys are being suppressed.
+func KeySuppressionActive() bool {
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	return keySuppression
+}
+
+// suppressed reports whether a key press or repeat must be dropped while
+// suppression is active.  Releases always pass.  Callers hold rl.mu and
+// have already recorded the event in rl.held.
+func (rl *relay) suppressed(event *evdev.InputEvent) bool {
+	if event.Type != evdev.EV_KEY || event.Value == 0 {
+		return false
+	}
+	ctrl := rl.held[evdev.KEY_LEFTCTRL] || rl.held[evdev.KEY_RIGHTCTRL]
+	shift := rl.held[evdev.KEY_LEFTSHIFT] || rl.held[evdev.KEY_RIGHTSHIFT]
+
+	switch event.Code {
+	case evdev.KEY_BACKSPACE, evdev.KEY_DELETE:
+		return true
+	case evdev.KEY_V:
+		return ctrl
+	case evdev.KEY_INSERT:
+		return shift
+	}
+	return false
+}
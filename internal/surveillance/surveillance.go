@@ -1,13 +1,20 @@
 package surveillance
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	evdev "github.com/holoplot/go-evdev"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 )
 
 // Metrics holds the surveillance data
@@ -16,16 +23,57 @@ type Metrics struct {
 	Keystrokes     uint64
 	LinesCompleted uint64 // Heuristic: counting 'Enter' keys
 	StartTime      time.Time
+	recent         keyRing // per-second counts for rolling KPM
+}
+
+// Rolling KPM windows exposed by GetRollingKPM.
+const (
+	KPMWindowShort = 1 * time.Minute
+	KPMWindowLong  = 5 * time.Minute
+)
+
+// keyRing counts keystrokes in one-second buckets covering the longest
+// rolling window.  Only counts are kept, never key codes.
+type keyRing struct {
+	counts [int(KPMWindowLong / time.Second)]uint32
+	stamps [int(KPMWindowLong / time.Second)]int64 // unix second each bucket belongs to
+}
+
+func (r *keyRing) add(now time.Time) {
+	sec := now.Unix()
+	i := int(sec % int64(len(r.counts)))
+	if r.stamps[i] != sec {
+		r.stamps[i] = sec
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+// count returns the keystrokes recorded in the window ending at now.
+func (r *keyRing) count(now time.Time, window time.Duration) uint64 {
+	sec := now.Unix()
+	oldest := sec - int64(window/time.Second)
+	var total uint64
+	for i, stamp := range r.stamps {
+		if stamp > oldest && stamp <= sec {
+			total += uint64(r.counts[i])
+		}
+	}
+	return total
 }
 
 var (
 	GlobalMetrics = &Metrics{StartTime: time.Now()}
-	activeDevices []InputDevice
+
+	devicesMu      sync.Mutex
+	activeDevices  = make(map[string]InputDevice) // keyed by device path
+	activePointers = make(map[string]InputDevice) // mice, for activity only
 )
 
 // Init initializes the surveillance subsystem
 func Init() error {
 	log.Println("Initializing Surveillance Subsystem...")
+	deviceFilter = loadDeviceFilter()
 
 	// Check for explicit device path override from environment
 	if devicePath := os.Getenv("VEX_DEVICE_PATH"); devicePath != "" {
@@ -33,38 +81,86 @@ func Init() error {
 		if err := listenToDevice(devicePath); err != nil {
 			log.Printf("Surveillance: Failed to attach to %s: %v", devicePath, err)
 		} else {
-			go metricReporter()
+			startBackground()
 			return nil
 		}
 		// Fall through to auto-detection if explicit path fails
 	}
 
 	// 1. Scan for Input Devices
+	scanDevices()
+	if attachedCount() == 0 {
+		log.Println("Surveillance: Warning - No keyboards detected to monitor.")
+	}
+
+	// 2. Attach keyboards plugged in later
+	supervisor.Go("surveillance.hotplug", watchHotplug)
+
+	startBackground()
+
+	return nil
+}
+
+// startBackground launches the workers shared by both attach modes.
+func startBackground() {
+	// Tally focused-application time
+	startWindowTracking()
+
+	// Start metric logger
+	supervisor.Go("surveillance.metrics", metricReporter)
+}
+
+// Shutdown releases any keyboards grabbed for latency injection so input
+// keeps working after the daemon exits, and flushes persisted metrics.
+func Shutdown() error {
+	releaseAllRelays()
+	return flushPersistence()
+}
+
+// scanDevices attaches every keyboard currently present.  Already attached
+// devices are skipped, so it is safe to call again after a hotplug overflow.
+func scanDevices() {
 	// Uses wrapper evOps
 	devices, err := evOps.ListInputDevices()
 	if err != nil {
 		log.Printf("Surveillance: Failed to list input devices: %v", err)
-		return nil
+		return
 	}
 
 	for _, dev := range devices {
-		if isKeyboard(dev) {
+		switch {
+		case isAttached(dev.Fn()):
+			dev.Close()
+		case shouldAttach(dev):
 			log.Printf("Surveillance: Attaching to keyboard: %s (%s)", dev.Name(), dev.Fn())
-			// Open the device for reading
-			if err := listenToDevice(dev.Fn()); err != nil {
-				log.Printf("Surveillance: Failed to attach to %s: %v", dev.Fn(), err)
-			}
+			attachDevice(dev)
+		case shouldAttachPointer(dev):
+			attachPointer(dev)
+		default:
+			dev.Close() // our own uinput output, filtered, or not an input we track
 		}
 	}
+}
 
-	if len(activeDevices) == 0 {
-		log.Println("Surveillance: Warning - No keyboards detected to monitor.")
+// ProbeInput checks that at least one keyboard the device filter allows
+// can be opened, without attaching to it.
+func ProbeInput() (string, error) {
+	devices, err := evOps.ListInputDevices()
+	if err != nil {
+		return "", err
 	}
-
-	// Start metric logger
-	go metricReporter()
-
-	return nil
+	filter := loadDeviceFilter()
+	var keyboards []string
+	for _, dev := range devices {
+		if !isRelayDevice(dev) && filter.Allows(dev) {
+			keyboards = append(keyboards, dev.Name())
+		}
+		dev.Close()
+	}
+	if len(keyboards) == 0 {
+		return "", fmt.Errorf("no readable keyboard among %d openable input devices", len(devices))
+	}
+	return fmt.Sprintf("%d keyboards (%s)", len(keyboards), strings.Join(keyboards, ", ")), nil
 }
 
 func isKeyboard(dev InputDevice) bool {
@@ -99,40 +195,118 @@ func listenToDevice(path string) error {
 	if err != nil {
 		return err
 	}
+	attachDevice(dev)
+	return nil
+}
 
-	activeDevices = append(activeDevices, dev)
+// attachDevice starts a supervised listener on an opened device.
+func attachDevice(dev InputDevice) {
+	devicesMu.Lock()
+	if _, ok := activeDevices[dev.Fn()]; ok {
+		devicesMu.Unlock()
+		dev.Close()
+		return
+	}
+	activeDevices[dev.Fn()] = dev
+	devicesMu.Unlock()
 
-	go func(d InputDevice) {
-		defer d.Close()
-		log.Printf("Surveillance: Started listener for %s", d.Name())
+	superviseDevice("keyboard", activeDevices, dev, listenKeyboard)
+}
 
-		for {
-			event, err := d.ReadOne()
-			if err != nil {
-				log.Printf("Surveillance: Error reading %s: %v", d.Name(), err)
-				return // Device likely disconnected
+// listenKeyboard counts keystrokes from d until a read fails.
+func listenKeyboard(d InputDevice) error {
+	rl := attachRelay(d)
+	defer detachRelay(rl)
+	log.Printf("Surveillance: Started listener for %s", d.Name())
+
+	for {
+		event, err := d.ReadOne()
+		if err != nil {
+			log.Printf("Surveillance: Error reading %s: %v", d.Name(), err)
+			return err
+		}
+
+		// While latency is active the device is grabbed and the
+		// event only reaches applications via the relay.
+		rl.forward(event)
+
+		if event.Type == evdev.EV_KEY && users.OnSeat() {
+			at := time.Unix(int64(event.Time.Sec), int64(event.Time.Usec)*1000)
+			captureKey(event.Code, event.Value, at)
+			if event.Value == 1 { // Key Press (not hold/release)
+				processKey(uint16(event.Code), at)
 			}
+		}
+	}
+}
 
-			if event.Type == evdev.EV_KEY && event.Value == 1 { // Key Press (not hold/release)
-				processKey(uint16(event.Code))
+// superviseDevice runs listen on dev, which is registered in devices under
+// its path, as a supervised worker.  If listen fails while the device node
+// still exists, the supervisor reopens the node and listens again after
+// its backoff.  If the node is gone the device was unplugged, so it is
+// closed and forgotten.
+func superviseDevice(kind string, devices map[string]InputDevice, dev InputDevice, listen func(InputDevice) error) {
+	path := dev.Fn()
+	forget := func() {
+		devicesMu.Lock()
+		delete(devices, path)
+		devicesMu.Unlock()
+	}
+
+	supervisor.Go("surveillance."+kind+":"+path, func() error {
+		if dev == nil {
+			d, err := evOps.Open(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				forget()
+				return nil
+			}
+			if err != nil {
+				return err
 			}
+			devicesMu.Lock()
+			devices[path] = d
+			devicesMu.Unlock()
+			dev = d
 		}
-	}(dev)
+		d := dev
+		dev = nil // reopen on restart
+		defer d.Close()
 
-	return nil
+		err := listen(d)
+		if _, statErr := os.Stat(path); err == nil || statErr != nil {
+			forget()
+			return nil
+		}
+		return err
+	})
 }
 
-func processKey(code uint16) {
-	// Apply latency injection if configured
-	delay := getLatencyDelay()
-	if delay > 0 {
-		time.Sleep(delay)
+func isAttached(path string) bool {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	_, ok := activeDevices[path]
+	if !ok {
+		_, ok = activePointers[path]
 	}
+	return ok
+}
+
+func attachedCount() int {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
+	return len(activeDevices)
+}
+
+func processKey(code uint16, at time.Time) {
+	recordKeyTiming(at)
+	markActivity(at)
+	recordDailyKey(at)
 
 	GlobalMetrics.mu.Lock()
 	defer GlobalMetrics.mu.Unlock()
 
 	GlobalMetrics.Keystrokes++
+	GlobalMetrics.recent.add(time.Now())
 
 	// KEY_ENTER is 28
 	if code == evdev.KEY_ENTER {
@@ -142,67 +316,70 @@ func processKey(code uint16) {
 	// Zero-Storage Policy: We do NOT log the keycode or create a buffer.
 }
 
-func metricReporter() {
+func metricReporter() error {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		GlobalMetrics.mu.Lock()
-		kpm := float64(GlobalMetrics.Keystrokes) / time.Since(GlobalMetrics.StartTime).Minutes()
-		log.Printf("Surveillance Stats: %d keystrokes total | %.2f KPM | %d lines",
-			GlobalMetrics.Keystrokes, kpm, GlobalMetrics.LinesCompleted)
-		GlobalMetrics.mu.Unlock()
+		kpm1, kpm5 := GetRollingKPM()
+		keystrokes, lines := GetMetricSnapshot()
+		log.Printf("Surveillance Stats: %d keystrokes total | %.2f KPM (1m) | %.2f KPM (5m) | %d lines",
+			keystrokes, kpm1, kpm5, lines)
 	}
+	return nil
 }
 
-// GetCurrentKPM returns the current keystrokes-per-minute rate
+// GetCurrentKPM returns the keystrokes-per-minute rate over the last minute
 func GetCurrentKPM() float64 {
-	GlobalMetrics.mu.Lock()
-	defer GlobalMetrics.mu.Unlock()
-
-	elapsed := time.Since(GlobalMetrics.StartTime).Minutes()
-	if elapsed <= 0 {
-		return 0
-	}
-	return float64(GlobalMetrics.Keystrokes) / elapsed
+	kpm1, _ := GetRollingKPM()
+	return kpm1
 }
 
-// GetMetricSnapshot returns a snapshot of current keystrokes and lines completed
-func GetMetricSnapshot() (uint64, uint64) {
+// GetRollingKPM returns the keystrokes-per-minute rate over the last
+// KPMWindowShort and KPMWindowLong.  Shortly after startup the rate is
+// computed over the elapsed time rather than the full window.
+func GetRollingKPM() (short, long float64) {
 	GlobalMetrics.mu.Lock()
 	defer GlobalMetrics.mu.Unlock()
-	return GlobalMetrics.Keystrokes, GlobalMetrics.LinesCompleted
-}
 
-// ---------------------------------------------------------------------
-// Latency Injection via uinput
-// ---------------------------------------------------------------------
+	now := time.Now()
+	return GlobalMetrics.rollingKPM(now, KPMWindowShort), GlobalMetrics.rollingKPM(now, KPMWindowLong)
+}
 
-var (
-	latencyMu    sync.Mutex
-	latencyDelay time.Duration
-)
+func (m *Metrics) rollingKPM(now time.Time, window time.Duration) float64 {
+	span := window
+	if elapsed := now.Sub(m.StartTime); elapsed < span {
+		span = elapsed
+	}
+	if span < time.Second {
+		return 0
+	}
+	return float64(m.recent.count(now, window)) / span.Minutes()
+}
 
-// InjectLatency sets the programmable delay for input events.
-// When delayMs > 0, the surveillance listener intercepts keyboard events,
-// grabs the device, and re-emits them through a uinput virtual device
-// after the specified delay. Setting delayMs to 0 disables injection.
-func InjectLatency(delayMs int) error {
-	latencyMu.Lock()
-	defer latencyMu.Unlock()
+// GetMonitoredDevices returns "name (path)" for every attached keyboard.
+func GetMonitoredDevices() []string {
+	devicesMu.Lock()
+	defer devicesMu.Unlock()
 
-	if delayMs < 0 {
-		delayMs = 0
+	out := make([]string, 0, len(activeDevices))
+	for path, dev := range activeDevices {
+		out = append(out, fmt.Sprintf("%s (%s)", dev.Name(), path))
 	}
+	sort.Strings(out)
+	return out
+}
 
-	latencyDelay = time.Duration(delayMs) * time.Millisecond
-	log.Printf("Surveillance: Input latency set to %dms", delayMs)
-	return nil
+// GetStartTime returns the start of the current counting period.
+func GetStartTime() time.Time {
+	GlobalMetrics.mu.Lock()
+	defer GlobalMetrics.mu.Unlock()
+	return GlobalMetrics.StartTime
 }
 
-// getLatencyDelay returns the current latency delay setting
-func getLatencyDelay() time.Duration {
-	latencyMu.Lock()
-	defer latencyMu.Unlock()
-	return latencyDelay
+// GetMetricSnapshot returns a snapshot of current keystrokes and lines completed
+func GetMetricSnapshot() (uint64, uint64) {
+	GlobalMetrics.mu.Lock()
+	defer GlobalMetrics.mu.Unlock()
+	return GlobalMetrics.Keystrokes, GlobalMetrics.LinesCompleted
 }
@@ -1,11 +1,17 @@
 package surveillance
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
 	evdev "github.com/holoplot/go-evdev"
 )
 
@@ -15,8 +21,10 @@ type MockInputDevice struct {
 	NameVal     string
 	FnVal       string
 	CapsVal     map[evdev.EvType][]evdev.EvCode
+	IDVal       evdev.InputID
 	ReadOneFunc func() (*evdev.InputEvent, error)
 	CloseFunc   func() error
+	Grabbed     bool
 }
 
 func (m *MockInputDevice) Name() string { return m.NameVal }
@@ -24,6 +32,7 @@ func (m *MockInputDevice) Fn() string   { return m.FnVal }
 func (m *MockInputDevice) Capabilities() map[evdev.EvType][]evdev.EvCode {
 	return m.CapsVal
 }
+func (m *MockInputDevice) InputID() (evdev.InputID, error) { return m.IDVal, nil }
 func (m *MockInputDevice) ReadOne() (*evdev.InputEvent, error) {
 	if m.ReadOneFunc != nil {
 		return m.ReadOneFunc()
@@ -38,9 +47,32 @@ func (m *MockInputDevice) Close() error {
 	return nil
 }
 
+func (m *MockInputDevice) Grab() error   { m.Grabbed = true; return nil }
+func (m *MockInputDevice) Ungrab() error { m.Grabbed = false; return nil }
+
+type MockVirtualDevice struct {
+	mu      sync.Mutex
+	Written []time.Time
+	Closed  bool
+}
+
+func (m *MockVirtualDevice) WriteOne(event *evdev.InputEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Written = append(m.Written, time.Now())
+	return nil
+}
+func (m *MockVirtualDevice) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Closed = true
+	return nil
+}
+
 type MockEvdevOps struct {
-	ListFunc func() ([]InputDevice, error)
-	OpenFunc func(path string) (InputDevice, error)
+	ListFunc    func() ([]InputDevice, error)
+	OpenFunc    func(path string) (InputDevice, error)
+	VirtualFunc func(name string, src InputDevice) (VirtualDevice, error)
 }
 
 func (m *MockEvdevOps) ListInputDevices() ([]InputDevice, error) {
@@ -55,6 +87,12 @@ func (m *MockEvdevOps) Open(path string) (InputDevice, error) {
 	}
 	return nil, fmt.Errorf("mock open failed")
 }
+func (m *MockEvdevOps) CreateVirtual(name string, src InputDevice) (VirtualDevice, error) {
+	if m.VirtualFunc != nil {
+		return m.VirtualFunc(name, src)
+	}
+	return nil, fmt.Errorf("mock uinput unavailable")
+}
 
 // -- Tests --
 
@@ -140,3 +178,703 @@ func TestKeystrokeProcess(t *testing.T) {
 		t.Errorf("Expected 1 line completed, got %d", GlobalMetrics.LinesCompleted)
 	}
 }
+
+func TestLatencyRelay(t *testing.T) {
+	defer InjectLatency(0)
+
+	virt := &MockVirtualDevice{}
+	evOps = &MockEvdevOps{
+		VirtualFunc: func(name string, src InputDevice) (VirtualDevice, error) {
+			return virt, nil
+		},
+	}
+	dev := &MockInputDevice{NameVal: "Relay Keyboard", FnVal: "/dev/input/eventRelay"}
+
+	rl := attachRelay(dev)
+	defer detachRelay(rl)
+
+	if err := InjectLatency(40); err != nil {
+		t.Fatalf("InjectLatency failed: %v", err)
+	}
+	if !dev.Grabbed {
+		t.Fatal("Expected keyboard to be grabbed while latency is active")
+	}
+
+	sent := time.Now()
+	rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 1})
+
+	time.Sleep(100 * time.Millisecond)
+	virt.mu.Lock()
+	if len(virt.Written) != 1 {
+		t.Fatalf("Expected 1 re-emitted event, got %d", len(virt.Written))
+	}
+	if delay := virt.Written[0].Sub(sent); delay < 40*time.Millisecond {
+		t.Errorf("Event re-emitted after %s, expected at least 40ms", delay)
+	}
+	virt.mu.Unlock()
+
+	if err := InjectLatency(0); err != nil {
+		t.Fatalf("InjectLatency(0) failed: %v", err)
+	}
+	if dev.Grabbed {
+		t.Error("Expected keyboard to be released when latency is cleared")
+	}
+	time.Sleep(10 * time.Millisecond)
+	virt.mu.Lock()
+	defer virt.mu.Unlock()
+	if !virt.Closed {
+		t.Error("Expected uinput device to be destroyed when latency is cleared")
+	}
+}
+
+func TestLatencyJitter(t *testing.T) {
+	defer InjectLatency(0)
+
+	virt := &MockVirtualDevice{}
+	evOps = &MockEvdevOps{
+		VirtualFunc: func(name string, src InputDevice) (VirtualDevice, error) {
+			return virt, nil
+		},
+	}
+	dev := &MockInputDevice{NameVal: "Jitter Keyboard", FnVal: "/dev/input/eventJitter"}
+	rl := attachRelay(dev)
+	defer detachRelay(rl)
+
+	if err := InjectJitter(20, 60); err != nil {
+		t.Fatalf("InjectJitter failed: %v", err)
+	}
+	if min, max := GetInputLatencyRange(); min != 20*time.Millisecond || max != 60*time.Millisecond {
+		t.Errorf("Expected range 20-60ms, got %s-%s", min, max)
+	}
+	if err := InjectJitter(60, 20); err == nil {
+		t.Error("Expected an error for an inverted range")
+	}
+
+	sent := time.Now()
+	rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 1})
+	time.Sleep(120 * time.Millisecond)
+
+	virt.mu.Lock()
+	defer virt.mu.Unlock()
+	if len(virt.Written) != 1 {
+		t.Fatalf("Expected 1 re-emitted event, got %d", len(virt.Written))
+	}
+	if delay := virt.Written[0].Sub(sent); delay < 20*time.Millisecond {
+		t.Errorf("Event re-emitted after %s, expected at least 20ms", delay)
+	}
+}
+
+func TestLatencyRelay_NoGrabWithoutUinput(t *testing.T) {
+	defer InjectLatency(0)
+
+	evOps = &MockEvdevOps{} // CreateVirtual fails
+	dev := &MockInputDevice{NameVal: "Relay Keyboard", FnVal: "/dev/input/eventRelay"}
+	rl := attachRelay(dev)
+	defer detachRelay(rl)
+
+	if err := InjectLatency(40); err == nil {
+		t.Error("Expected an error when uinput is unavailable")
+	}
+	if dev.Grabbed {
+		t.Error("Keyboard must not stay grabbed when the relay cannot be created")
+	}
+}
+
+func TestInputBlackout(t *testing.T) {
+	defer EndInputBlackout(false)
+
+	virt := &MockVirtualDevice{}
+	evOps = &MockEvdevOps{
+		VirtualFunc: func(name string, src InputDevice) (VirtualDevice, error) {
+			return virt, nil
+		},
+	}
+	dev := &MockInputDevice{NameVal: "Blackout Keyboard", FnVal: "/dev/input/eventBlackout"}
+	rl := attachRelay(dev)
+	defer detachRelay(rl)
+
+	escaped := make(chan bool, 1)
+	defer events.Subscribe(func(e events.Event) {
+		if b, ok := e.(events.BlackoutEnded); ok {
+			escaped <- b.Escaped
+		}
+	})()
+
+	until, err := StartInputBlackout(time.Hour)
+	if err != nil {
+		t.Fatalf("StartInputBlackout failed: %v", err)
+	}
+	if !dev.Grabbed {
+		t.Fatal("Expected keyboard to be grabbed during a blackout")
+	}
+
+	// A shorter request must not cut the blackout short.
+	if again, _ := StartInputBlackout(time.Minute); !again.Equal(until) {
+		t.Errorf("Blackout shortened from %s to %s", until, again)
+	}
+
+	rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 1})
+	rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 0})
+	time.Sleep(20 * time.Millisecond)
+	virt.mu.Lock()
+	if len(virt.Written) != 1 {
+		t.Errorf("Expected only the key release to pass, got %d events", len(virt.Written))
+	}
+	virt.mu.Unlock()
+
+	for _, code := range BlackoutEscapeChord {
+		rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: code, Value: 1})
+	}
+	select {
+	case e := <-escaped:
+		if !e {
+			t.Error("Expected BlackoutEnded{Escaped: true} after the escape chord")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Escape chord did not end the blackout")
+	}
+	if !InputBlackoutUntil().IsZero() {
+		t.Error("Expected no active blackout after escape")
+	}
+	if dev.Grabbed {
+		t.Error("Expected keyboard to be released after escape")
+	}
+}
+
+func TestKeySuppression(t *testing.T) {
+	defer SetKeySuppression(false)
+
+	virt := &MockVirtualDevice{}
+	evOps = &MockEvdevOps{
+		VirtualFunc: func(name string, src InputDevice) (VirtualDevice, error) {
+			return virt, nil
+		},
+	}
+	dev := &MockInputDevice{NameVal: "Penance Keyboard", FnVal: "/dev/input/eventPenance"}
+	rl := attachRelay(dev)
+	defer detachRelay(rl)
+
+	if err := SetKeySuppression(true); err != nil {
+		t.Fatalf("SetKeySuppression failed: %v", err)
+	}
+	if !dev.Grabbed {
+		t.Fatal("Expected keyboard to be grabbed while keys are suppressed")
+	}
+
+	key := func(code evdev.EvCode, value int32) {
+		rl.forward(&evdev.InputEvent{Type: evdev.EV_KEY, Code: code, Value: value})
+	}
+	key(evdev.KEY_BACKSPACE, 1) // dropped
+	key(evdev.KEY_BACKSPACE, 0) // release passes
+	key(evdev.KEY_V, 1)         // plain V passes
+	key(evdev.KEY_V, 0)
+	key(evdev.KEY_LEFTCTRL, 1)
+	key(evdev.KEY_V, 1) // Ctrl+V dropped
+	key(evdev.KEY_V, 0)
+	key(evdev.KEY_LEFTCTRL, 0)
+
+	time.Sleep(20 * time.Millisecond)
+	virt.mu.Lock()
+	if got := len(virt.Written); got != 6 {
+		t.Errorf("Expected 6 of 8 events re-emitted, got %d", got)
+	}
+	virt.mu.Unlock()
+
+	if err := SetKeySuppression(false); err != nil {
+		t.Fatalf("SetKeySuppression(false) failed: %v", err)
+	}
+	if dev.Grabbed {
+		t.Error("Expected keyboard to be released when suppression is lifted")
+	}
+}
+
+func TestRollingKPM(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	m := &Metrics{StartTime: start.Add(-time.Hour)}
+
+	// 60 keystrokes spread over the first minute...
+	for i := 0; i < 60; i++ {
+		m.recent.add(start.Add(time.Duration(i) * time.Second))
+	}
+	// ...then 3 minutes of idling.
+	now := start.Add(4 * time.Minute)
+
+	if kpm := m.rollingKPM(now, KPMWindowShort); kpm != 0 {
+		t.Errorf("Expected 0 KPM over the idle last minute, got %.2f", kpm)
+	}
+	if kpm := m.rollingKPM(now, KPMWindowLong); kpm != 12 {
+		t.Errorf("Expected 12 KPM over 5 minutes (60/5), got %.2f", kpm)
+	}
+
+	// Buckets older than the ring are overwritten, not double counted.
+	later := start.Add(10 * time.Minute)
+	m.recent.add(later)
+	if n := m.recent.count(later, KPMWindowLong); n != 1 {
+		t.Errorf("Expected only 1 keystroke in the window after wraparound, got %d", n)
+	}
+}
+
+func TestRollingKPM_ShortUptime(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	m := &Metrics{StartTime: start}
+	for i := 0; i < 30; i++ {
+		m.recent.add(start.Add(time.Duration(i) * time.Second))
+	}
+	// 30 keystrokes in the first 30 seconds is 60 KPM, not 30/5.
+	if kpm := m.rollingKPM(start.Add(30*time.Second), KPMWindowLong); kpm != 60 {
+		t.Errorf("Expected 60 KPM during warm-up, got %.2f", kpm)
+	}
+}
+
+func TestHotplugAttachAndDetach(t *testing.T) {
+	hotplugRetryDelay = time.Millisecond
+
+	eventChan := make(chan *evdev.InputEvent)
+	keyboard := &MockInputDevice{
+		NameVal: "Hotplug Keyboard",
+		FnVal:   "/dev/input/event42",
+		ReadOneFunc: func() (*evdev.InputEvent, error) {
+			ev, ok := <-eventChan
+			if !ok {
+				return nil, io.EOF // simulate unplug
+			}
+			return ev, nil
+		},
+	}
+	mouse := &MockInputDevice{NameVal: "USB Mouse", FnVal: "/dev/input/event43"}
+	opens := 0
+	evOps = &MockEvdevOps{
+		OpenFunc: func(path string) (InputDevice, error) {
+			opens++
+			switch path {
+			case keyboard.FnVal:
+				return keyboard, nil
+			case mouse.FnVal:
+				return mouse, nil
+			}
+			return nil, fmt.Errorf("no such device")
+		},
+	}
+
+	handleHotplug(mouse.FnVal)
+	if isAttached(mouse.FnVal) {
+		t.Error("Mouse should not be attached")
+	}
+
+	handleHotplug(keyboard.FnVal)
+	if !isAttached(keyboard.FnVal) {
+		t.Fatal("Expected hotplugged keyboard to be attached")
+	}
+
+	// A repeated IN_ATTRIB for the same node must not open it again.
+	before := opens
+	handleHotplug(keyboard.FnVal)
+	if opens != before {
+		t.Error("Already attached keyboard was opened again")
+	}
+
+	close(eventChan)
+	time.Sleep(20 * time.Millisecond)
+	if isAttached(keyboard.FnVal) {
+		t.Error("Expected keyboard to be detached after unplug")
+	}
+}
+
+func TestListenerRestart(t *testing.T) {
+	supervisor.MinBackoff = time.Millisecond
+	defer func() { supervisor.MinBackoff = time.Second }()
+
+	// The node stays present, so a read error is a failure rather than
+	// an unplug and the listener is reopened.
+	node := filepath.Join(t.TempDir(), "event7")
+	if err := os.WriteFile(node, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eventChan := make(chan *evdev.InputEvent, 1)
+	failing := &MockInputDevice{
+		NameVal: "Flaky Keyboard",
+		FnVal:   node,
+		ReadOneFunc: func() (*evdev.InputEvent, error) {
+			return nil, fmt.Errorf("read: input/output error")
+		},
+	}
+	reopened := &MockInputDevice{
+		NameVal: "Flaky Keyboard",
+		FnVal:   node,
+		ReadOneFunc: func() (*evdev.InputEvent, error) {
+			ev, ok := <-eventChan
+			if !ok {
+				return nil, io.EOF
+			}
+			return ev, nil
+		},
+	}
+	evOps = &MockEvdevOps{
+		OpenFunc: func(path string) (InputDevice, error) {
+			return reopened, nil
+		},
+	}
+
+	GlobalMetrics.mu.Lock()
+	GlobalMetrics.Keystrokes = 0
+	GlobalMetrics.mu.Unlock()
+
+	attachDevice(failing)
+	eventChan <- &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: 1}
+	deadline := time.Now().Add(time.Second)
+	for keystrokes, _ := GetMetricSnapshot(); keystrokes != 1; keystrokes, _ = GetMetricSnapshot() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the reopened keyboard to be counted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Unplugged: the node is gone, so the keyboard is forgotten.
+	os.Remove(node)
+	close(eventChan)
+	for isAttached(node) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the keyboard to be detached after unplug")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestParseInotify(t *testing.T) {
+	var buf []byte
+	for _, name := range []string{"event7", "js0"} {
+		rec := make([]byte, 16)
+		binary.NativeEndian.PutUint32(rec[4:], 0x100) // IN_CREATE
+		binary.NativeEndian.PutUint32(rec[12:], 16)
+		padded := make([]byte, 16)
+		copy(padded, name)
+		buf = append(append(buf, rec...), padded...)
+	}
+
+	events := parseInotify(buf)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].name != "event7" || events[1].name != "js0" {
+		t.Errorf("Unexpected names: %q, %q", events[0].name, events[1].name)
+	}
+	if events[0].mask != 0x100 {
+		t.Errorf("Unexpected mask: %#x", events[0].mask)
+	}
+}
+
+func TestParseWindowBackends(t *testing.T) {
+	hypr := []byte(`{"address":"0x1","class":"Firefox","title":"secret","pid":4242}`)
+	info, err := parseHyprlandWindow(hypr)
+	if err != nil || info.App != "firefox" || info.PID != 4242 {
+		t.Errorf("Hyprland: got %+v, %v", info, err)
+	}
+
+	sway := []byte(`{"focused":false,"nodes":[{"focused":false,"nodes":[
+		{"focused":false,"pid":10,"app_id":"foot"},
+		{"focused":true,"pid":11,"app_id":null,"window_properties":{"class":"Steam"}}
+	]}]}`)
+	info, err = parseSwayTree(sway)
+	if err != nil || info.App != "steam" || info.PID != 11 {
+		t.Errorf("Sway: got %+v, %v", info, err)
+	}
+
+	id, ok := parseX11ActiveID([]byte("_NET_ACTIVE_WINDOW(WINDOW): window id # 0x3a00007\n"))
+	if !ok || id != "0x3a00007" {
+		t.Errorf("X11 active id: got %q, %v", id, ok)
+	}
+	if _, ok := parseX11ActiveID([]byte("_NET_ACTIVE_WINDOW(WINDOW): window id # 0x0\n")); ok {
+		t.Error("X11: window 0x0 should mean nothing is focused")
+	}
+	info = parseX11Props([]byte("WM_CLASS(STRING) = \"Navigator\", \"firefox\"\n_NET_WM_PID(CARDINAL) = 1234\n"))
+	if info.App != "firefox" || info.PID != 1234 {
+		t.Errorf("X11 props: got %+v", info)
+	}
+}
+
+func TestRecordWindowSample(t *testing.T) {
+	windowMu.Lock()
+	activeWindow, lastSample = WindowInfo{}, time.Time{}
+	appUsage = make(map[string]time.Duration)
+	windowMu.Unlock()
+
+	start := time.Unix(1_700_000_000, 0)
+	recordWindowSample(WindowInfo{App: "firefox"}, start)
+	recordWindowSample(WindowInfo{App: "firefox"}, start.Add(WindowPollInterval))
+	recordWindowSample(WindowInfo{App: "foot"}, start.Add(2*WindowPollInterval))
+	recordWindowSample(WindowInfo{App: "foot"}, start.Add(3*WindowPollInterval))
+	// Suspend gap: not credited to foot.
+	recordWindowSample(WindowInfo{}, start.Add(time.Hour))
+
+	usage := GetAppUsage()
+	if usage["firefox"] != 2*WindowPollInterval {
+		t.Errorf("Expected firefox=%s, got %s", 2*WindowPollInterval, usage["firefox"])
+	}
+	if usage["foot"] != WindowPollInterval {
+		t.Errorf("Expected foot=%s, got %s", WindowPollInterval, usage["foot"])
+	}
+	if _, ok := GetActiveWindow(); ok {
+		t.Error("Expected no active window after an empty sample")
+	}
+}
+
+func TestDailyUsage(t *testing.T) {
+	windowMu.Lock()
+	activeWindow, lastSample = WindowInfo{}, time.Time{}
+	windowMu.Unlock()
+	usageMu.Lock()
+	usageDays = make(map[string]*DayUsage)
+	usageMu.Unlock()
+	lastActivity.Store(0)
+
+	now := time.Now().Add(-time.Minute)
+	recordWindowSample(WindowInfo{App: "steam"}, now)
+	// Idle: nothing credited.
+	recordWindowSample(WindowInfo{App: "steam"}, now.Add(WindowPollInterval))
+
+	markActivity(now.Add(WindowPollInterval))
+	recordWindowSample(WindowInfo{App: "firefox"}, now.Add(2*WindowPollInterval))
+	recordWindowSample(WindowInfo{}, now.Add(3*WindowPollInterval))
+
+	today := GetDailyUsage(1)[0]
+	if want := WindowPollInterval.Seconds() * 2; today.ActiveSeconds != want {
+		t.Errorf("Expected %.0fs active, got %.0fs", want, today.ActiveSeconds)
+	}
+	if want := WindowPollInterval.Seconds(); today.Apps["steam"] != want || today.Apps["firefox"] != want {
+		t.Errorf("Expected %.0fs each for steam and firefox, got %v", want, today.Apps)
+	}
+
+	rule := UsageRule{Name: "games", Apps: []string{"Steam*"}, MaxMinutes: 1}
+	if rule.Exceeded(today) {
+		t.Error("Rule should not fire below its limit")
+	}
+	if left := rule.Remaining(today); left != time.Minute-WindowPollInterval {
+		t.Errorf("Expected %s left, got %s", time.Minute-WindowPollInterval, left)
+	}
+	today.Apps["steam"] = 2 * 60
+	if !rule.Exceeded(today) {
+		t.Error("Rule should fire above its limit")
+	}
+	if left := rule.Remaining(today); left != 0 {
+		t.Errorf("Expected nothing left above the limit, got %s", left)
+	}
+	if !MarkUsageRuleTriggered("games") || MarkUsageRuleTriggered("games") {
+		t.Error("Expected a rule to trigger only once per day")
+	}
+
+	if week := GetDailyUsage(7); len(week) != 7 || week[6].Date != today.Date {
+		t.Errorf("Expected 7 days ending today, got %+v", week)
+	}
+
+	RecordSite("Reddit.com", 90*time.Second, now.Add(2*WindowPollInterval))
+	RecordSite("reddit.com", 0, now.Add(2*WindowPollInterval))
+	RecordSite("news.ycombinator.com", time.Minute, now.Add(time.Hour)) // idle by then
+	today = GetDailyUsage(1)[0]
+	if len(today.Sites) != 1 || today.Sites["reddit.com"] != 90 {
+		t.Errorf("Expected 90s on reddit.com alone, got %v", today.Sites)
+	}
+	sites := UsageRule{Name: "social", Sites: []string{"*reddit.com"}, MaxMinutes: 1}
+	if !sites.Exceeded(today) || sites.Usage(today) != 90*time.Second {
+		t.Errorf("Expected the site rule to count 90s, got %s", sites.Usage(today))
+	}
+
+	RecordNetwork("steam", 3<<20, now.Add(time.Hour)) // counted while idle too
+	RecordNetwork("firefox", 1<<20, now)
+	today = GetDailyUsage(1)[0]
+	download := UsageRule{Name: "downloads", Apps: []string{"steam"}, MaxMB: 2}
+	if got := download.Traffic(today); got != 3<<20 {
+		t.Errorf("Expected 3 MiB for steam, got %d", got)
+	}
+	if !download.Exceeded(today) || !download.OverTraffic(today) {
+		t.Error("Expected the traffic rule to fire above its budget")
+	}
+	if all := (UsageRule{MaxMB: 5}); all.Exceeded(today) || all.Traffic(today) != 4<<20 {
+		t.Errorf("Expected 4 MiB in all, under a 5 MB budget, got %d", all.Traffic(today))
+	}
+}
+
+func TestTypingDynamics(t *testing.T) {
+	dynMu.Lock()
+	baseline, sample, lastPressAt = TimingHistogram{}, nil, time.Time{}
+	dynMu.Unlock()
+
+	// Build a baseline of steady ~120ms intervals with some spread.
+	at := time.Unix(1_700_000_000, 0)
+	press := func(n int, gaps ...time.Duration) {
+		for i := 0; i < n; i++ {
+			at = at.Add(gaps[i%len(gaps)])
+			recordKeyTiming(at)
+		}
+	}
+	press(1000, 100*time.Millisecond, 120*time.Millisecond, 140*time.Millisecond)
+
+	// The subject typing the task: same rhythm.
+	BeginTypingSample()
+	press(100, 100*time.Millisecond, 120*time.Millisecond, 140*time.Millisecond)
+	same := EndTypingSample()
+	if !same.Sufficient || same.Confidence < 0.9 {
+		t.Errorf("Expected a confident match for the same rhythm, got %+v", same)
+	}
+	if same.Anomalous() {
+		t.Error("Same rhythm must not be flagged as anomalous")
+	}
+
+	// Someone much slower and more hesitant.
+	BeginTypingSample()
+	press(100, 450*time.Millisecond, 700*time.Millisecond)
+	other := EndTypingSample()
+	if !other.Anomalous() {
+		t.Errorf("Expected a different rhythm to be flagged, got %+v", other)
+	}
+
+	// Too few keys to judge.
+	BeginTypingSample()
+	press(5, 450*time.Millisecond)
+	if m := EndTypingSample(); m.Sufficient {
+		t.Errorf("Expected insufficient data for 5 keys, got %+v", m)
+	}
+
+	// Task samples must not leak into the baseline.
+	dynMu.Lock()
+	defer dynMu.Unlock()
+	if baseline.Total != 999 {
+		t.Errorf("Expected baseline to hold only the 999 everyday intervals, got %d", baseline.Total)
+	}
+}
+
+func TestMetricsCheckpointRoundTrip(t *testing.T) {
+	MetricsFile = filepath.Join(t.TempDir(), "metrics.json")
+
+	now := time.Now()
+	GlobalMetrics.mu.Lock()
+	GlobalMetrics.Keystrokes = 1200
+	GlobalMetrics.LinesCompleted = 30
+	GlobalMetrics.StartTime = now.Add(-time.Hour)
+	GlobalMetrics.recent = keyRing{}
+	for i := 0; i < 90; i++ {
+		GlobalMetrics.recent.add(now.Add(-time.Duration(i) * time.Second))
+	}
+	GlobalMetrics.mu.Unlock()
+
+	if err := saveMetrics(); err != nil {
+		t.Fatalf("saveMetrics failed: %v", err)
+	}
+
+	// Simulate a restart: fresh metrics, then restore.
+	GlobalMetrics.mu.Lock()
+	GlobalMetrics.Keystrokes = 0
+	GlobalMetrics.LinesCompleted = 0
+	GlobalMetrics.StartTime = now
+	GlobalMetrics.recent = keyRing{}
+	GlobalMetrics.mu.Unlock()
+
+	if err := loadMetrics(); err != nil {
+		t.Fatalf("loadMetrics failed: %v", err)
+	}
+
+	keystrokes, lines := GetMetricSnapshot()
+	if keystrokes != 1200 || lines != 30 {
+		t.Errorf("Expected 1200 keystrokes / 30 lines restored, got %d / %d", keystrokes, lines)
+	}
+	GlobalMetrics.mu.Lock()
+	defer GlobalMetrics.mu.Unlock()
+	if n := GlobalMetrics.recent.count(now, KPMWindowLong); n != 90 {
+		t.Errorf("Expected rolling window to retain 90 keystrokes, got %d", n)
+	}
+	if !GlobalMetrics.StartTime.Equal(now.Add(-time.Hour)) {
+		t.Errorf("Expected original start time to be restored, got %s", GlobalMetrics.StartTime)
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	kbdCaps := map[evdev.EvType][]evdev.EvCode{evdev.EV_KEY: {evdev.KEY_A}}
+	laptop := &MockInputDevice{NameVal: "AT Translated Set 2 keyboard", FnVal: "/dev/input/event3", CapsVal: kbdCaps,
+		IDVal: evdev.InputID{Vendor: 0x0001, Product: 0x0001}}
+	macro := &MockInputDevice{NameVal: "Acme Macro Pad", FnVal: "/dev/input/event7", CapsVal: kbdCaps,
+		IDVal: evdev.InputID{Vendor: 0x1d50, Product: 0x615e}}
+	lid := &MockInputDevice{NameVal: "Lid Switch", FnVal: "/dev/input/event0"}
+
+	tests := []struct {
+		name   string
+		filter DeviceFilter
+		dev    InputDevice
+		want   bool
+	}{
+		{"default keyboard", DeviceFilter{}, laptop, true},
+		{"default non-keyboard", DeviceFilter{}, lid, false},
+		{"exclude by name glob", DeviceFilter{Exclude: []string{"*macro*"}}, macro, false},
+		{"exclude by vendor:product", DeviceFilter{Exclude: []string{"1D50:615E"}}, macro, false},
+		{"exclude by path", DeviceFilter{Exclude: []string{"/dev/input/event7"}}, macro, false},
+		{"exclude leaves others", DeviceFilter{Exclude: []string{"*macro*"}}, laptop, true},
+		{"include restricts", DeviceFilter{Include: []string{"/dev/input/event3"}}, macro, false},
+		{"include forces non-keyboard", DeviceFilter{Include: []string{"lid switch"}}, lid, true},
+		{"exclude beats include", DeviceFilter{Include: []string{"*"}, Exclude: []string{"lid*"}}, lid, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Allows(tt.dev); got != tt.want {
+			t.Errorf("%s: Allows() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProbeInput(t *testing.T) {
+	defer func() { evOps = &RealEvdevOps{} }()
+	kbdCaps := map[evdev.EvType][]evdev.EvCode{evdev.EV_KEY: {evdev.KEY_A}}
+	laptop := &MockInputDevice{NameVal: "AT Translated Set 2 keyboard", CapsVal: kbdCaps}
+	relay := &MockInputDevice{NameVal: relayDevicePrefix + " 1", CapsVal: kbdCaps}
+	lid := &MockInputDevice{NameVal: "Lid Switch"}
+
+	evOps = &MockEvdevOps{ListFunc: func() ([]InputDevice, error) {
+		return []InputDevice{laptop, relay, lid}, nil
+	}}
+	if detail, err := ProbeInput(); err != nil || detail != "1 keyboards (AT Translated Set 2 keyboard)" {
+		t.Errorf("ProbeInput() = %q, %v", detail, err)
+	}
+
+	evOps = &MockEvdevOps{ListFunc: func() ([]InputDevice, error) {
+		return []InputDevice{lid}, nil
+	}}
+	if _, err := ProbeInput(); err == nil {
+		t.Error("Expected no keyboard to fail the probe")
+	}
+}
+
+func TestTypingCapture(t *testing.T) {
+	if _, ok := TypingCaptureSnapshot(); ok {
+		t.Fatal("Expected no capture before BeginTypingCapture")
+	}
+
+	start := time.Unix(1_700_000_000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * 200 * time.Millisecond) }
+
+	// Keys typed before the test are not captured.
+	captureKey(evdev.KEY_Q, 1, at(0))
+
+	BeginTypingCapture()
+	captureKey(evdev.KEY_LEFTSHIFT, 1, at(1))
+	captureKey(evdev.KEY_H, 1, at(2))
+	captureKey(evdev.KEY_LEFTSHIFT, 0, at(3))
+	captureKey(evdev.KEY_I, 1, at(4))
+	captureKey(evdev.KEY_X, 1, at(5))
+	captureKey(evdev.KEY_BACKSPACE, 1, at(6))
+	captureKey(evdev.KEY_1, 1, at(7))
+	captureKey(evdev.KEY_1, 2, at(8)) // auto-repeat types but isn't a keystroke
+
+	c, ok := EndTypingCapture()
+	if !ok {
+		t.Fatal("Expected a capture result")
+	}
+	if c.Text != "Hi11" {
+		t.Errorf("Expected %q, got %q", "Hi11", c.Text)
+	}
+	if c.Keystrokes != 5 || c.Backspaces != 1 {
+		t.Errorf("Expected 5 keystrokes and 1 backspace, got %d and %d", c.Keystrokes, c.Backspaces)
+	}
+	if !c.First.Equal(at(2)) || !c.Last.Equal(at(7)) {
+		t.Errorf("Unexpected capture span %s - %s", c.First, c.Last)
+	}
+	if _, ok := TypingCaptureSnapshot(); ok {
+		t.Error("Expected the capture to be cleared after EndTypingCapture")
+	}
+}
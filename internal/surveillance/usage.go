@@ -0,0 +1,399 @@
+package surveillance
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	evdev "github.com/holoplot/go-evdev"
+
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// ---------------------------------------------------------------------
+// Daily Screen Time
+// ---------------------------------------------------------------------
+//
+// Each window-poll tick credits the elapsed time to the current local day
+// if there was keyboard or mouse input within IdleTimeout, and to the app
+// that held focus.  Days are kept for UsageHistoryDays and persisted with
+// the metrics checkpoint.  Mice are attached for activity only: their
+// events are never counted or relayed.
+
+var (
+	// UsageFile holds the per-day totals.
+	UsageFile = "/var/lib/vex-cli/usage.json"
+
+	// UsageRulesFile configures automatic penalties for excessive usage.
+	UsageRulesFile = "/etc/vex-cli/usage-rules.json"
+
+	// IdleTimeout is how long after the last input the subject still
+	// counts as using the machine.
+	IdleTimeout = 5 * time.Minute
+
+	// UsageHistoryDays bounds how many days are retained.
+	UsageHistoryDays = 35
+
+	usageMu      sync.Mutex
+	usageDays    = make(map[string]*DayUsage)
+	lastActivity atomic.Int64 // unix nanoseconds of the last input event
+)
+
+// DayUsage is one local calendar day of activity.
+type DayUsage struct {
+	Date          string             `json:"date"` // YYYY-MM-DD, local time
+	ActiveSeconds float64            `json:"active_seconds"`
+	Keystrokes    uint64             `json:"keystrokes"`
+	Apps          map[string]float64 `json:"apps,omitempty"`      // focused seconds while active
+	Sites         map[string]float64 `json:"sites,omitempty"`     // seconds per site, as the browser extension reports them
+	Network       map[string]uint64  `json:"network,omitempty"`   // bytes sent and received per app
+	Triggered     []string           `json:"triggered,omitempty"` // usage rules already fired
+}
+
+// UsageRule applies a penalty once per day when time spent in matching
+// apps exceeds MaxMinutes, or their traffic exceeds MaxMB.
+type UsageRule struct {
+	Name          string   `json:"name"`
+	Apps          []string `json:"apps,omitempty"`  // app globs; empty, with Sites, = total screen time
+	Sites         []string `json:"sites,omitempty"` // site globs, from the browser extension's reports
+	MaxMinutes    int      `json:"max_minutes"`
+	MaxMB         int      `json:"max_mb,omitempty"`         // traffic budget of the apps; empty Apps = all traffic
+	Profile       string   `json:"profile,omitempty"`        // network profile to impose
+	RecordFailure bool     `json:"record_failure,omitempty"` // add to the failure score
+}
+
+func dayKey(t time.Time) string {
+	return t.Local().Format("2006-01-02")
+}
+
+// markActivity records input at the given time.
+func markActivity(at time.Time) {
+	lastActivity.Store(at.UnixNano())
+}
+
+// isActive reports whether there was input within IdleTimeout of now.
+func isActive(now time.Time) bool {
+	last := lastActivity.Load()
+	return last != 0 && now.Sub(time.Unix(0, last)) <= IdleTimeout
+}
+
+func dayLocked(key string) *DayUsage {
+	d, ok := usageDays[key]
+	if !ok {
+		d = &DayUsage{Date: key, Apps: make(map[string]float64)}
+		usageDays[key] = d
+		pruneUsageLocked()
+	}
+	return d
+}
+
+func pruneUsageLocked() {
+	if len(usageDays) <= UsageHistoryDays {
+		return
+	}
+	keys := make([]string, 0, len(usageDays))
+	for k := range usageDays {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys[:len(keys)-UsageHistoryDays] {
+		delete(usageDays, k)
+	}
+}
+
+// recordUsage credits elapsed time ending at now to today's totals if the
+// subject was active.  app is the application that held focus, if known.
+func recordUsage(app string, elapsed time.Duration, now time.Time) {
+	if elapsed <= 0 || !isActive(now) {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	d := dayLocked(dayKey(now))
+	d.ActiveSeconds += elapsed.Seconds()
+	if app != "" {
+		d.Apps[app] += elapsed.Seconds()
+	}
+}
+
+// RecordSite credits elapsed time ending at now to a site in today's
+// totals if the subject was active.  The browser extension reports the
+// time its focused tab spent on a site.
+func RecordSite(site string, elapsed time.Duration, now time.Time) {
+	if elapsed <= 0 || site == "" || !isActive(now) {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	d := dayLocked(dayKey(now))
+	if d.Sites == nil {
+		d.Sites = make(map[string]float64)
+	}
+	d.Sites[strings.ToLower(site)] += elapsed.Seconds()
+}
+
+// RecordNetwork credits bytes of traffic ending at now to an app in
+// today's totals.  Unlike time, traffic counts whether or not the subject
+// is at the machine.
+func RecordNetwork(app string, bytes uint64, now time.Time) {
+	if bytes == 0 || app == "" {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	d := dayLocked(dayKey(now))
+	if d.Network == nil {
+		d.Network = make(map[string]uint64)
+	}
+	d.Network[app] += bytes
+}
+
+func recordDailyKey(at time.Time) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	dayLocked(dayKey(at)).Keystrokes++
+}
+
+// GetDailyUsage returns the last n days ending today, oldest first.  Days
+// without activity are included with zero totals.
+func GetDailyUsage(n int) []DayUsage {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	out := make([]DayUsage, 0, n)
+	for i := n - 1; i >= 0; i-- {
+		key := dayKey(now.AddDate(0, 0, -i))
+		day := DayUsage{Date: key, Apps: make(map[string]float64)}
+		if d, ok := usageDays[key]; ok {
+			day.ActiveSeconds = d.ActiveSeconds
+			day.Keystrokes = d.Keystrokes
+			for app, secs := range d.Apps {
+				day.Apps[app] = secs
+			}
+			if len(d.Sites) > 0 {
+				day.Sites = make(map[string]float64, len(d.Sites))
+				for site, secs := range d.Sites {
+					day.Sites[site] = secs
+				}
+			}
+			if len(d.Network) > 0 {
+				day.Network = make(map[string]uint64, len(d.Network))
+				for app, n := range d.Network {
+					day.Network[app] = n
+				}
+			}
+			day.Triggered = append([]string(nil), d.Triggered...)
+		}
+		out = append(out, day)
+	}
+	return out
+}
+
+// MarkUsageRuleTriggered records that a rule fired today and reports
+// whether it had not already fired.
+func MarkUsageRuleTriggered(name string) bool {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	d := dayLocked(dayKey(time.Now()))
+	for _, t := range d.Triggered {
+		if t == name {
+			return false
+		}
+	}
+	d.Triggered = append(d.Triggered, name)
+	return true
+}
+
+// -- Usage rules --
+
+// LoadUsageRules reads UsageRulesFile.  A missing file means no rules.
+func LoadUsageRules() ([]UsageRule, error) {
+	data, err := os.ReadFile(UsageRulesFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []UsageRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", UsageRulesFile, err)
+	}
+	return rules, nil
+}
+
+// Usage returns the time the rule's apps and sites were used on the
+// given day.
+func (r UsageRule) Usage(d DayUsage) time.Duration {
+	if len(r.Apps) == 0 && len(r.Sites) == 0 {
+		return time.Duration(d.ActiveSeconds * float64(time.Second))
+	}
+	secs := matchedSeconds(d.Apps, r.Apps) + matchedSeconds(d.Sites, r.Sites)
+	return time.Duration(secs * float64(time.Second))
+}
+
+// matchedSeconds sums the seconds of the names that match a pattern.
+func matchedSeconds(totals map[string]float64, patterns []string) float64 {
+	var secs float64
+	for name, s := range totals {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+				secs += s
+				break
+			}
+		}
+	}
+	return secs
+}
+
+// Traffic returns the bytes the rule's apps sent and received on the
+// given day.
+func (r UsageRule) Traffic(d DayUsage) uint64 {
+	var n uint64
+	for app, b := range d.Network {
+		if len(r.Apps) == 0 || slices.ContainsFunc(r.Apps, func(pattern string) bool {
+			ok, _ := path.Match(strings.ToLower(pattern), app)
+			return ok
+		}) {
+			n += b
+		}
+	}
+	return n
+}
+
+// OverTraffic reports whether the day's traffic is over the rule's
+// budget.
+func (r UsageRule) OverTraffic(d DayUsage) bool {
+	return r.MaxMB > 0 && r.Traffic(d) > uint64(r.MaxMB)<<20
+}
+
+// Exceeded reports whether the day's usage is over either of the rule's
+// limits.
+func (r UsageRule) Exceeded(d DayUsage) bool {
+	return r.MaxMinutes > 0 && r.Usage(d) > time.Duration(r.MaxMinutes)*time.Minute || r.OverTraffic(d)
+}
+
+// Remaining returns how much of the rule's daily limit is left, or 0 once
+// it is used up.
+func (r UsageRule) Remaining(d DayUsage) time.Duration {
+	return max(time.Duration(r.MaxMinutes)*time.Minute-r.Usage(d), 0)
+}
+
+// -- Pointer activity --
+
+// isPointer reports whether dev is a mouse or touchpad rather than a
+// keyboard.
+func isPointer(dev InputDevice) bool {
+	if isKeyboard(dev) {
+		return false
+	}
+	caps := dev.Capabilities()
+	for _, code := range caps[evdev.EV_REL] {
+		if code == evdev.REL_X {
+			return true
+		}
+	}
+	for _, code := range caps[evdev.EV_ABS] {
+		if code == evdev.ABS_X {
+			return true
+		}
+	}
+	return false
+}
+
+// attachPointer starts an activity-only listener on a mouse or touchpad.
+func attachPointer(dev InputDevice) {
+	devicesMu.Lock()
+	if _, ok := activePointers[dev.Fn()]; ok {
+		devicesMu.Unlock()
+		dev.Close()
+		return
+	}
+	activePointers[dev.Fn()] = dev
+	devicesMu.Unlock()
+
+	superviseDevice("pointer", activePointers, dev, listenPointer)
+}
+
+// listenPointer marks activity on every event from d until a read fails.
+func listenPointer(d InputDevice) error {
+	log.Printf("Surveillance: Tracking activity on %s", d.Name())
+	for {
+		event, err := d.ReadOne()
+		if err != nil {
+			return err
+		}
+		if event.Type != evdev.EV_SYN && users.OnSeat() {
+			markActivity(time.Now())
+		}
+	}
+}
+
+// -- Persistence (see EnablePersistence) --
+
+func saveUsage() error {
+	usageMu.Lock()
+	days := make([]*DayUsage, 0, len(usageDays))
+	for _, d := range usageDays {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	data, err := json.Marshal(days)
+	usageMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(UsageFile, data)
+}
+
+func loadUsage() error {
+	data, err := os.ReadFile(UsageFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var days []*DayUsage
+	if err := json.Unmarshal(data, &days); err != nil {
+		return err
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	for _, d := range days {
+		if d.Apps == nil {
+			d.Apps = make(map[string]float64)
+		}
+		if cur, ok := usageDays[d.Date]; ok {
+			// Merge anything counted before the restore.
+			d.ActiveSeconds += cur.ActiveSeconds
+			d.Keystrokes += cur.Keystrokes
+			for app, s := range cur.Apps {
+				d.Apps[app] += s
+			}
+			for app, n := range cur.Network {
+				if d.Network == nil {
+					d.Network = make(map[string]uint64)
+				}
+				d.Network[app] += n
+			}
+		}
+		usageDays[d.Date] = d
+	}
+	pruneUsageLocked()
+	return nil
+}
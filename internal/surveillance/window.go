@@ -0,0 +1,381 @@
+package surveillance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// ---------------------------------------------------------------------
+// Active-Window Tracking
+// ---------------------------------------------------------------------
+//
+// The focused window is polled from the subject's graphical session and
+// the time each application holds focus is tallied.  Supported backends:
+//
+//   hyprland  hyprctl -j activewindow
+//   sway      swaymsg -t get_tree (focused node)
+//   x11       xprop _NET_ACTIVE_WINDOW → WM_CLASS / _NET_WM_PID (EWMH)
+//
+// There is no cross-compositor Wayland protocol or portal that exposes the
+// focused window, so other Wayland compositors (GNOME, KDE) report no
+// active window.  Only the application class is recorded; window titles
+// are never read, in keeping with the zero-storage policy.
+
+// WindowInfo identifies the focused application.
+type WindowInfo struct {
+	App string `json:"app"` // lower-cased WM_CLASS / app_id
+	PID int    `json:"pid,omitempty"`
+}
+
+// WindowBackend selects how the focused window is queried.
+type WindowBackend string
+
+const (
+	WindowBackendAuto     WindowBackend = "auto"
+	WindowBackendHyprland WindowBackend = "hyprland"
+	WindowBackendSway     WindowBackend = "sway"
+	WindowBackendX11      WindowBackend = "x11"
+	WindowBackendOff      WindowBackend = "off"
+)
+
+var (
+	// WindowPollInterval controls how often the focused window is sampled.
+	WindowPollInterval = 5 * time.Second
+
+	windowMu     sync.Mutex
+	activeWindow WindowInfo
+	appUsage     = make(map[string]time.Duration)
+	lastSample   time.Time
+)
+
+// -- Interfaces for Testing --
+
+type SessionRunner interface {
+	Output(env []string, name string, args ...string) ([]byte, error)
+}
+
+type RealSessionRunner struct{}
+
+func (r *RealSessionRunner) Output(env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	return cmd.Output()
+}
+
+var sessionRunner SessionRunner = &RealSessionRunner{}
+
+// -- Public API --
+
+// GetActiveWindow returns the currently focused application, if known.
+func GetActiveWindow() (WindowInfo, bool) {
+	windowMu.Lock()
+	defer windowMu.Unlock()
+	return activeWindow, activeWindow.App != ""
+}
+
+// GetAppUsage returns the focus time accumulated per application since the
+// daemon started.
+func GetAppUsage() map[string]time.Duration {
+	windowMu.Lock()
+	defer windowMu.Unlock()
+
+	out := make(map[string]time.Duration, len(appUsage))
+	for app, d := range appUsage {
+		out[app] = d
+	}
+	return out
+}
+
+// -- Tracking loop --
+
+func startWindowTracking() {
+	backend := WindowBackend(strings.ToLower(os.Getenv("VEX_WINDOW_BACKEND")))
+	if backend == "" {
+		backend = WindowBackendAuto
+	}
+	if backend == WindowBackendOff {
+		log.Println("Surveillance: Active-window tracking disabled (VEX_WINDOW_BACKEND=off)")
+	}
+	supervisor.Go("surveillance.windows", func() error { return trackWindows(backend) })
+}
+
+// trackWindows samples the focused window every WindowPollInterval.  With
+// the backend off it still ticks so daily screen time is recorded.
+func trackWindows(backend WindowBackend) error {
+	if backend != WindowBackendOff {
+		log.Printf("Surveillance: Active-window tracking started (backend=%s)", backend)
+	}
+
+	var env []string
+	warned := false
+	ticker := time.NewTicker(WindowPollInterval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if backend == WindowBackendOff {
+			recordWindowSample(WindowInfo{}, time.Now())
+			continue
+		}
+		if env == nil {
+			env = findSessionEnv()
+		}
+		var (
+			info WindowInfo
+			err  error
+		)
+		if env != nil {
+			info, err = queryActiveWindow(backend, env)
+			if err != nil {
+				env = nil // session may have ended; rediscover next tick
+			}
+		} else {
+			err = fmt.Errorf("no graphical session found")
+		}
+		if err != nil && !warned {
+			log.Printf("Surveillance: Active window unavailable: %v", err)
+			warned = true
+		} else if err == nil {
+			warned = false
+		}
+		recordWindowSample(info, time.Now())
+	}
+}
+
+// recordWindowSample credits the time since the previous sample to the
+// application that was focused during it, and to the daily totals.
+func recordWindowSample(info WindowInfo, now time.Time) {
+	windowMu.Lock()
+	defer windowMu.Unlock()
+
+	if !lastSample.IsZero() {
+		elapsed := now.Sub(lastSample)
+		// A gap far beyond the poll interval means we were suspended or
+		// stalled; don't credit it to whatever had focus.
+		if elapsed > 0 && elapsed <= 3*WindowPollInterval {
+			if activeWindow.App != "" {
+				appUsage[activeWindow.App] += elapsed
+			}
+			recordUsage(activeWindow.App, elapsed, now)
+		}
+	}
+	activeWindow = info
+	lastSample = now
+}
+
+// queryActiveWindow asks the session's compositor or X server for the
+// focused window.
+func queryActiveWindow(backend WindowBackend, env []string) (WindowInfo, error) {
+	if backend == WindowBackendAuto {
+		backend = detectWindowBackend(env)
+	}
+
+	switch backend {
+	case WindowBackendHyprland:
+		out, err := sessionRunner.Output(env, "hyprctl", "-j", "activewindow")
+		if err != nil {
+			return WindowInfo{}, fmt.Errorf("hyprctl: %w", err)
+		}
+		return parseHyprlandWindow(out)
+	case WindowBackendSway:
+		out, err := sessionRunner.Output(env, "swaymsg", "-t", "get_tree", "-r")
+		if err != nil {
+			return WindowInfo{}, fmt.Errorf("swaymsg: %w", err)
+		}
+		return parseSwayTree(out)
+	case WindowBackendX11:
+		root, err := sessionRunner.Output(env, "xprop", "-root", "_NET_ACTIVE_WINDOW")
+		if err != nil {
+			return WindowInfo{}, fmt.Errorf("xprop: %w", err)
+		}
+		id, ok := parseX11ActiveID(root)
+		if !ok {
+			return WindowInfo{}, nil // desktop focused, nothing active
+		}
+		props, err := sessionRunner.Output(env, "xprop", "-id", id, "WM_CLASS", "_NET_WM_PID")
+		if err != nil {
+			return WindowInfo{}, fmt.Errorf("xprop: %w", err)
+		}
+		return parseX11Props(props), nil
+	default:
+		return WindowInfo{}, fmt.Errorf("no supported window backend for this session")
+	}
+}
+
+func detectWindowBackend(env []string) WindowBackend {
+	switch {
+	case envValue(env, "HYPRLAND_INSTANCE_SIGNATURE") != "":
+		return WindowBackendHyprland
+	case envValue(env, "SWAYSOCK") != "":
+		return WindowBackendSway
+	case envValue(env, "DISPLAY") != "" && envValue(env, "WAYLAND_DISPLAY") == "":
+		return WindowBackendX11
+	}
+	return ""
+}
+
+// -- Backend parsers --
+
+func parseHyprlandWindow(out []byte) (WindowInfo, error) {
+	var w struct {
+		Class string `json:"class"`
+		PID   int    `json:"pid"`
+	}
+	if err := json.Unmarshal(out, &w); err != nil {
+		return WindowInfo{}, fmt.Errorf("hyprctl output: %w", err)
+	}
+	return WindowInfo{App: normalizeApp(w.Class), PID: w.PID}, nil
+}
+
+type swayNode struct {
+	Focused          bool       `json:"focused"`
+	AppID            string     `json:"app_id"`
+	PID              int        `json:"pid"`
+	Nodes            []swayNode `json:"nodes"`
+	FloatingNodes    []swayNode `json:"floating_nodes"`
+	WindowProperties struct {
+		Class string `json:"class"`
+	} `json:"window_properties"`
+}
+
+func parseSwayTree(out []byte) (WindowInfo, error) {
+	var root swayNode
+	if err := json.Unmarshal(out, &root); err != nil {
+		return WindowInfo{}, fmt.Errorf("swaymsg output: %w", err)
+	}
+	if n := findFocusedSway(&root); n != nil {
+		app := n.AppID
+		if app == "" {
+			app = n.WindowProperties.Class // XWayland client
+		}
+		return WindowInfo{App: normalizeApp(app), PID: n.PID}, nil
+	}
+	return WindowInfo{}, nil
+}
+
+func findFocusedSway(n *swayNode) *swayNode {
+	if n.Focused && n.PID > 0 {
+		return n
+	}
+	for _, list := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+		for i := range list {
+			if f := findFocusedSway(&list[i]); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+var x11WindowID = regexp.MustCompile(`window id # (0x[0-9a-fA-F]+)`)
+
+// parseX11ActiveID extracts the window ID from `xprop -root _NET_ACTIVE_WINDOW`.
+func parseX11ActiveID(out []byte) (string, bool) {
+	m := x11WindowID.FindSubmatch(out)
+	if m == nil || string(m[1]) == "0x0" {
+		return "", false
+	}
+	return string(m[1]), true
+}
+
+// parseX11Props reads WM_CLASS (instance, class) and _NET_WM_PID lines.
+func parseX11Props(out []byte) WindowInfo {
+	var info WindowInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "WM_CLASS"):
+			if i := strings.Index(line, "="); i >= 0 {
+				parts := strings.Split(line[i+1:], ",")
+				class := parts[len(parts)-1]
+				info.App = normalizeApp(strings.Trim(strings.TrimSpace(class), `"`))
+			}
+		case strings.HasPrefix(line, "_NET_WM_PID"):
+			if i := strings.Index(line, "="); i >= 0 {
+				info.PID, _ = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			}
+		}
+	}
+	return info
+}
+
+func normalizeApp(app string) string {
+	return strings.ToLower(strings.TrimSpace(app))
+}
+
+// -- Session discovery --
+
+// sessionVars are copied from the subject's session into backend commands.
+var sessionVars = []string{
+	"DISPLAY", "XAUTHORITY", "WAYLAND_DISPLAY", "XDG_RUNTIME_DIR",
+	"HYPRLAND_INSTANCE_SIGNATURE", "SWAYSOCK", "DBUS_SESSION_BUS_ADDRESS",
+}
+
+// Session is the subject's graphical session: the user it runs as and
+// the environment needed to reach its display server and session bus.
+type Session struct {
+	UID, GID uint32
+	Env      []string
+}
+
+// FindSession locates a non-root process running inside a graphical
+// session.  It returns nil when nobody is logged in graphically.
+func FindSession() *Session {
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range pids {
+		var st syscall.Stat_t
+		if err := syscall.Stat(dir, &st); err != nil || st.Uid == 0 {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, "environ"))
+		if err != nil {
+			continue
+		}
+		var env []string
+		for _, kv := range bytes.Split(raw, []byte{0}) {
+			for _, name := range sessionVars {
+				if bytes.HasPrefix(kv, []byte(name+"=")) {
+					env = append(env, string(kv))
+				}
+			}
+		}
+		if envValue(env, "XDG_RUNTIME_DIR") != "" &&
+			(envValue(env, "WAYLAND_DISPLAY") != "" || envValue(env, "DISPLAY") != "") {
+			return &Session{UID: st.Uid, GID: st.Gid, Env: append(env, "PATH="+os.Getenv("PATH"))}
+		}
+	}
+	return nil
+}
+
+// findSessionEnv returns the environment of the subject's session, or nil.
+func findSessionEnv() []string {
+	if s := FindSession(); s != nil {
+		return s.Env
+	}
+	return nil
+}
+
+// Getenv returns a variable from the session's environment.
+func (s *Session) Getenv(name string) string {
+	return envValue(s.Env, name)
+}
+
+func envValue(env []string, name string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, name+"=") {
+			return kv[len(name)+1:]
+		}
+	}
+	return ""
+}
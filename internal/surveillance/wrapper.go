@@ -11,6 +11,9 @@ type InputDevice interface {
 	Name() string
 	Fn() string
 	Capabilities() map[evdev.EvType][]evdev.EvCode
+	InputID() (evdev.InputID, error)
+	Grab() error
+	Ungrab() error
 }
 
 // RealInputDevice wraps the actual struct
@@ -24,7 +27,10 @@ func (r *RealInputDevice) Name() string {
 	name, _ := r.dev.Name()
 	return name
 }
-func (r *RealInputDevice) Fn() string { return r.dev.Path() }
+func (r *RealInputDevice) Fn() string                      { return r.dev.Path() }
+func (r *RealInputDevice) InputID() (evdev.InputID, error) { return r.dev.InputID() }
+func (r *RealInputDevice) Grab() error                     { return r.dev.Grab() }
+func (r *RealInputDevice) Ungrab() error                   { return r.dev.Ungrab() }
 func (r *RealInputDevice) Capabilities() map[evdev.EvType][]evdev.EvCode {
 	caps := make(map[evdev.EvType][]evdev.EvCode)
 	for _, t := range r.dev.CapableTypes() {
@@ -33,10 +39,25 @@ func (r *RealInputDevice) Capabilities() map[evdev.EvType][]evdev.EvCode {
 	return caps
 }
 
+// VirtualDevice is a uinput device that grabbed events are re-emitted through
+type VirtualDevice interface {
+	WriteOne(event *evdev.InputEvent) error
+	Close() error
+}
+
+// RealVirtualDevice wraps a uinput-backed evdev.InputDevice
+type RealVirtualDevice struct {
+	dev *evdev.InputDevice
+}
+
+func (r *RealVirtualDevice) WriteOne(event *evdev.InputEvent) error { return r.dev.WriteOne(event) }
+func (r *RealVirtualDevice) Close() error                           { return r.dev.Close() }
+
 // EvdevOps interface defines the static functions we use
 type EvdevOps interface {
 	ListInputDevices() ([]InputDevice, error)
 	Open(path string) (InputDevice, error)
+	CreateVirtual(name string, src InputDevice) (VirtualDevice, error)
 }
 
 // RealEvdevOps implementation
@@ -66,4 +87,27 @@ func (r *RealEvdevOps) Open(path string) (InputDevice, error) {
 	return &RealInputDevice{dev: dev}, nil
 }
 
+// CreateVirtual creates a uinput device mirroring src's capabilities.
+func (r *RealEvdevOps) CreateVirtual(name string, src InputDevice) (VirtualDevice, error) {
+	var (
+		dev *evdev.InputDevice
+		err error
+	)
+	if real, ok := src.(*RealInputDevice); ok {
+		dev, err = evdev.CloneDevice(name, real.dev)
+	} else {
+		dev, err = evdev.CreateDevice(name, evdev.InputID{BusType: 0x06}, src.Capabilities()) // BUS_VIRTUAL
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &RealVirtualDevice{dev: dev}, nil
+}
+
 var evOps EvdevOps = &RealEvdevOps{}
+
+// SetOps replaces the evdev operations, for tests outside the package
+// (see package fakes).
+func SetOps(ev EvdevOps) {
+	evOps = ev
+}
@@ -0,0 +1,74 @@
+package throttler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// ---------------------------------------------------------------------
+// Session Freeze
+// ---------------------------------------------------------------------
+//
+// A freeze stops every process of the target sessions, desktop included,
+// through cgroup v2's cgroup.freeze: user.slice, or with users.Scoped each
+// target user's slice, and the penalty slice when it is in use.  Nothing
+// is killed; thawing lets the processes carry on where they stopped.
+// vexd decides when to freeze and thaw.
+
+// freezePaths are the cgroup.freeze files a freeze writes.  A user's
+// slice only exists while they are logged in, so users who are not get
+// none.
+func freezePaths() []string {
+	var dirs []string
+	if users.Scoped() {
+		for _, uid := range users.UIDs() {
+			dirs = append(dirs, filepath.Join(cgroupMount, "user.slice", fmt.Sprintf("user-%d.slice", uid)))
+		}
+	} else {
+		dirs = append(dirs, filepath.Join(cgroupMount, "user.slice"))
+	}
+	if PenaltySlice {
+		dirs = append(dirs, penaltySlicePath())
+	}
+	var paths []string
+	for _, dir := range dirs {
+		path := filepath.Join(dir, "cgroup.freeze")
+		if _, err := fsOps.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// FreezeSessions freezes the target sessions, or thaws them.
+func FreezeSessions(frozen bool) error {
+	verb, done, value := "thaw", "Thawed", "0"
+	if frozen {
+		verb, done, value = "freeze", "Froze", "1"
+	}
+	if subsystem.Skip(subsystem.Throttler, "%s the target sessions", verb) {
+		return nil
+	}
+	paths := freezePaths()
+	if len(paths) == 0 {
+		if frozen {
+			return errors.New("no session cgroup to freeze (is anyone logged in?)")
+		}
+		return nil
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := fsOps.WriteFile(path, []byte(value), 0644); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to %s %s: %w", verb, filepath.Dir(path), err))
+			continue
+		}
+		log.Printf("Freeze: %s %s", done, filepath.Dir(path))
+	}
+	return errors.Join(errs...)
+}
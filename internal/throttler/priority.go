@@ -0,0 +1,281 @@
+package throttler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// ---------------------------------------------------------------------
+// Process Priority
+// ---------------------------------------------------------------------
+//
+// A softer compute penalty than a CPU cap: the target sessions' processes
+// (the same as the penalty slice's) keep all the CPU nobody else wants,
+// but lose to everything else for it, and for the disk.  Each thread's
+// own nice value, scheduling policy and I/O priority are saved first and
+// put back at PriorityNormal.  While a level is in force, processes
+// started since are given it every PenaltySweepInterval.
+
+// Priority levels, from none to the harshest.
+const (
+	PriorityNormal = "normal" // the processes' own
+	PriorityLow    = "low"    // nice 10
+	PriorityLowest = "lowest" // nice 19 and the idle I/O class
+	PriorityIdle   = "idle"   // SCHED_IDLE and the idle I/O class
+)
+
+// PriorityLevels lists the levels in order of severity.
+var PriorityLevels = []string{PriorityNormal, PriorityLow, PriorityLowest, PriorityIdle}
+
+var (
+	// PriorityTargets are command globs the level is limited to, e.g.
+	// "steam*".  Empty means every process of the target sessions.
+	PriorityTargets []string
+
+	// PrioritySavedFile keeps the priorities a level replaced, by thread,
+	// so that a restart in between can still put them back.
+	PrioritySavedFile = "/run/vex-cli/priority-saved.json"
+
+	prioOps PriorityOps = &RealPriorityOps{}
+
+	prioMu      sync.Mutex
+	prioLevel   = PriorityNormal
+	prioRunning bool
+)
+
+// ValidPriority reports whether level is one of PriorityLevels.
+func ValidPriority(level string) bool {
+	return slices.Contains(PriorityLevels, level)
+}
+
+// PrioritySeverity ranks a level; "" counts as PriorityNormal.
+func PrioritySeverity(level string) int {
+	return max(slices.Index(PriorityLevels, level), 0)
+}
+
+// TaskPriority is what a level changes about one thread.
+type TaskPriority struct {
+	Nice   int    `json:"nice"`
+	Policy uint32 `json:"policy"` // SCHED_NORMAL, SCHED_BATCH or SCHED_IDLE; real-time threads are left alone
+	IOPrio int    `json:"ioprio"` // class << 13 | data, as ioprio_get returns it
+}
+
+// PriorityOps reads and sets a thread's priority.
+type PriorityOps interface {
+	Get(tid int) (TaskPriority, error)
+	Set(tid int, p TaskPriority) error
+}
+
+// RealPriorityOps uses sched_getattr/sched_setattr and
+// ioprio_get/ioprio_set.
+type RealPriorityOps struct{}
+
+const ioprioWhoProcess = 1 // IOPRIO_WHO_PROCESS: a single thread
+
+func (r *RealPriorityOps) Get(tid int) (TaskPriority, error) {
+	attr, err := unix.SchedGetAttr(tid, 0)
+	if err != nil {
+		return TaskPriority{}, err
+	}
+	io, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return TaskPriority{}, errno
+	}
+	return TaskPriority{Nice: int(attr.Nice), Policy: attr.Policy, IOPrio: int(io)}, nil
+}
+
+func (r *RealPriorityOps) Set(tid int, p TaskPriority) error {
+	if err := unix.SchedSetAttr(tid, &unix.SchedAttr{Policy: p.Policy, Nice: int32(p.Nice)}, 0); err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(p.IOPrio)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+const ioprioIdle = 3 << 13 // IOPRIO_CLASS_IDLE
+
+// lowered is own at level.  A level never raises a thread above its own
+// priority.
+func lowered(own TaskPriority, level string) TaskPriority {
+	p := own
+	switch level {
+	case PriorityLow:
+		p.Nice = max(own.Nice, 10)
+	case PriorityLowest:
+		p.Nice, p.IOPrio = 19, ioprioIdle
+	case PriorityIdle:
+		p.Policy, p.IOPrio = unix.SCHED_IDLE, ioprioIdle
+	}
+	return p
+}
+
+// realtime reports whether policy is a real-time one, which a level
+// leaves alone.
+func realtime(policy uint32) bool {
+	return policy == unix.SCHED_FIFO || policy == unix.SCHED_RR || policy == unix.SCHED_DEADLINE
+}
+
+// SetPriority puts the target sessions' processes at level, or back at
+// their own priorities with PriorityNormal.
+func SetPriority(level string) error {
+	if !ValidPriority(level) {
+		return fmt.Errorf("invalid priority %q (use %s)", level, strings.Join(PriorityLevels, ", "))
+	}
+	if subsystem.Skip(subsystem.Throttler, "set the target sessions' priority to %s", level) {
+		return nil
+	}
+	prioMu.Lock()
+	defer prioMu.Unlock()
+	prioLevel = level
+	n, err := applyPriorityLocked()
+	if level == PriorityNormal {
+		log.Printf("Priority: restored on %d threads", n)
+		return err
+	}
+	log.Printf("Priority: %s on %d threads", level, n)
+	if !prioRunning {
+		prioRunning = true
+		supervisor.Go("throttler.priority", runPriority)
+	}
+	return err
+}
+
+// runPriority gives the level to new processes every
+// PenaltySweepInterval until it is back to normal.
+func runPriority() error {
+	ticker := time.NewTicker(PenaltySweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		prioMu.Lock()
+		if prioLevel == PriorityNormal {
+			prioRunning = false
+			prioMu.Unlock()
+			return nil
+		}
+		_, err := applyPriorityLocked()
+		prioMu.Unlock()
+		if err != nil {
+			log.Printf("Priority: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyPriorityLocked brings every thread of the target processes to
+// prioLevel, or back to its own priority at PriorityNormal.  It returns
+// how many threads it changed.
+func applyPriorityLocked() (int, error) {
+	saved := loadPrioritySaved()
+	if prioLevel == PriorityNormal {
+		var errs []error
+		for tid, own := range saved {
+			if err := prioOps.Set(tid, own); err != nil && !errors.Is(err, syscall.ESRCH) {
+				errs = append(errs, fmt.Errorf("TID %d: %w", tid, err))
+			}
+		}
+		os.Remove(PrioritySavedFile)
+		return len(saved), errors.Join(errs...)
+	}
+
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	n := 0
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == os.Getpid() || !priorityTarget(pid) {
+			continue
+		}
+		tasks, _ := os.ReadDir(filepath.Join(procDir, e.Name(), "task"))
+		for _, t := range tasks {
+			tid, err := strconv.Atoi(t.Name())
+			if err != nil {
+				continue
+			}
+			cur, err := prioOps.Get(tid)
+			if err != nil {
+				continue // exited
+			}
+			own, ok := saved[tid]
+			if !ok {
+				own = cur
+			}
+			want := lowered(own, prioLevel)
+			if cur == want || realtime(own.Policy) {
+				continue
+			}
+			if err := prioOps.Set(tid, want); err != nil {
+				if !errors.Is(err, syscall.ESRCH) {
+					errs = append(errs, fmt.Errorf("TID %d: %w", tid, err))
+				}
+				continue
+			}
+			saved[tid] = own
+			n++
+		}
+	}
+	if n > 0 {
+		if err := savePrioritySaved(saved); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// priorityTarget reports whether a level applies to pid.
+func priorityTarget(pid int) bool {
+	p, ok := readProc(pid)
+	if !ok || !penalized(p.uid) {
+		return false
+	}
+	if len(PriorityTargets) == 0 {
+		return true
+	}
+	comm, _ := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	name := strings.ToLower(strings.TrimSpace(string(comm)))
+	for _, pattern := range PriorityTargets {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func loadPrioritySaved() map[int]TaskPriority {
+	saved := make(map[int]TaskPriority)
+	if data, err := os.ReadFile(PrioritySavedFile); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	return saved
+}
+
+func savePrioritySaved(saved map[int]TaskPriority) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(PrioritySavedFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(PrioritySavedFile, data, 0o600)
+}
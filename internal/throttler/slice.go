@@ -0,0 +1,240 @@
+package throttler
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
+)
+
+// ---------------------------------------------------------------------
+// Penalty Slice
+// ---------------------------------------------------------------------
+//
+// With PenaltySlice set, a CPU limit goes to a cgroup of vexd's own,
+// /sys/fs/cgroup/vex-penalty.slice, instead of the first of
+// CPUMaxCandidates, and the target sessions' processes are moved into it:
+// the target users' with users.Scoped, otherwise every regular user's
+// (UID 1000 and up).  This needs nothing from whatever manages the rest
+// of the cgroup tree, so it works without systemd and in containers.
+// While a limit is in force, processes started outside the slice are
+// swept into it every PenaltySweepInterval.  Lifting the limit moves
+// each process back to the cgroup it, or the ancestor that was moved,
+// came from.
+
+const penaltySliceName = "vex-penalty.slice"
+
+var (
+	// PenaltySlice turns the penalty slice on.  Set from config.json.
+	PenaltySlice bool
+
+	// PenaltySweepInterval is how often processes are swept into the
+	// slice while a limit is in force.
+	PenaltySweepInterval = 10 * time.Second
+
+	procDir = "/proc"
+
+	sliceMu   sync.Mutex
+	origins   = make(map[int]string) // PID → the cgroup it was moved from
+	sweepDone chan struct{}
+)
+
+func penaltySlicePath() string { return filepath.Join(cgroupMount, penaltySliceName) }
+
+// ensurePenaltySlice creates the slice and enables the cpu controller
+// above it, so that it has a cpu.max.
+func ensurePenaltySlice() error {
+	dir := penaltySlicePath()
+	if err := fsOps.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := fsOps.WriteFile(filepath.Join(cgroupMount, "cgroup.subtree_control"), []byte("+cpu"), 0644); err != nil {
+		return fmt.Errorf("failed to enable the cpu controller for %s: %w", dir, err)
+	}
+	return nil
+}
+
+// updatePenaltySlice sweeps the target sessions into the slice and keeps
+// doing so while limitPercent is below 100, or moves them back out.
+func updatePenaltySlice(limitPercent int) {
+	if limitPercent == 100 {
+		stopSweep()
+		releasePenaltySlice()
+		return
+	}
+	if err := sweepIntoPenaltySlice(); err != nil {
+		log.Printf("CPU limit: %v", err)
+	}
+	sliceMu.Lock()
+	defer sliceMu.Unlock()
+	if sweepDone != nil {
+		return
+	}
+	done := make(chan struct{})
+	sweepDone = done
+	supervisor.Go("throttler.penalty-sweep", func() error {
+		ticker := time.NewTicker(PenaltySweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := sweepIntoPenaltySlice(); err != nil {
+					log.Printf("CPU limit: %v", err)
+				}
+			case <-done:
+				return nil
+			}
+		}
+	})
+}
+
+func stopSweep() {
+	sliceMu.Lock()
+	defer sliceMu.Unlock()
+	if sweepDone != nil {
+		close(sweepDone)
+		sweepDone = nil
+	}
+}
+
+// sweepIntoPenaltySlice moves the target sessions' processes that are
+// not in the slice yet into it.
+func sweepIntoPenaltySlice() error {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return err
+	}
+	procs := filepath.Join(penaltySlicePath(), "cgroup.procs")
+	inSlice := "/" + penaltySliceName
+
+	sliceMu.Lock()
+	defer sliceMu.Unlock()
+	moved, failed := 0, 0
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		p, ok := readProc(pid)
+		if !ok || p.cgroup == inSlice || !penalized(p.uid) {
+			continue
+		}
+		if err := fsOps.WriteFile(procs, []byte(strconv.Itoa(pid)), 0644); err != nil {
+			failed++ // exited, or in a cgroup processes cannot leave
+			continue
+		}
+		origins[pid] = p.cgroup
+		moved++
+	}
+	if moved > 0 {
+		log.Printf("CPU limit: moved %d processes into %s", moved, penaltySliceName)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d processes could not be moved into %s", failed, penaltySliceName)
+	}
+	return nil
+}
+
+// releasePenaltySlice moves every process in the slice back to the
+// cgroup it came from.  A process started inside the slice goes where
+// its nearest moved ancestor came from; one with no such ancestor, or
+// whose cgroup has gone, stays.
+func releasePenaltySlice() {
+	data, err := fsOps.ReadFile(filepath.Join(penaltySlicePath(), "cgroup.procs"))
+	if err != nil {
+		return // never created
+	}
+	sliceMu.Lock()
+	defer sliceMu.Unlock()
+	back, stayed := 0, 0
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		origin, ok := originOf(pid)
+		if ok && fsOps.WriteFile(filepath.Join(cgroupMount, origin, "cgroup.procs"), []byte(field), 0644) == nil {
+			back++
+		} else {
+			stayed++
+		}
+	}
+	clear(origins)
+	log.Printf("CPU limit: moved %d processes out of %s (%d stayed)", back, penaltySliceName, stayed)
+}
+
+// originOf finds the cgroup pid, or its nearest ancestor that was swept
+// into the slice, came from.  The caller holds sliceMu.
+func originOf(pid int) (string, bool) {
+	for range 64 { // bounds a walk through recycled PIDs
+		if origin, ok := origins[pid]; ok {
+			return origin, true
+		}
+		p, ok := readProc(pid)
+		if !ok || p.ppid <= 1 {
+			return "", false
+		}
+		pid = p.ppid
+	}
+	return "", false
+}
+
+// penalized reports whether processes of uid belong in the slice.
+func penalized(uid uint32) bool {
+	if users.Scoped() {
+		return users.Includes(uid)
+	}
+	return uid >= 1000 && uid != 65534 // nobody
+}
+
+// procInfo is what the sweep needs to know about a process.
+type procInfo struct {
+	cgroup string // cgroup v2 path, e.g. /user.slice/user-1000.slice/session-2.scope
+	uid    uint32 // real UID
+	ppid   int
+}
+
+// readProc reads pid's cgroup, owner and parent from procDir.
+func readProc(pid int) (procInfo, bool) {
+	var p procInfo
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	cg, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return p, false
+	}
+	for _, line := range strings.Split(string(cg), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			p.cgroup = path
+		}
+	}
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil || p.cgroup == "" {
+		return p, false
+	}
+	var haveUID bool
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		key, value, _ := strings.Cut(sc.Text(), ":")
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "Uid":
+			uid, err := strconv.ParseUint(fields[0], 10, 32)
+			p.uid, haveUID = uint32(uid), err == nil
+		case "PPid":
+			p.ppid, _ = strconv.Atoi(fields[0])
+		}
+	}
+	return p, haveUID
+}
@@ -2,6 +2,7 @@ package throttler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,7 +11,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/adumbdinosaur/vex-cli/internal/events"
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // Profile definitions
@@ -31,12 +36,14 @@ type NetlinkOps interface {
 	QdiscDel(qdisc netlink.Qdisc) error
 	RouteList(link netlink.Link, family int) ([]netlink.Route, error)
 	LinkByIndex(index int) (netlink.Link, error)
+	FilterAdd(filter netlink.Filter) error
 }
 
 type FileOps interface {
 	WriteFile(filename string, data []byte, perm os.FileMode) error
 	ReadFile(filename string) ([]byte, error)
 	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
 }
 
 // Default Implementations (Real System)
@@ -61,6 +68,9 @@ func (r *RealNetlinkOps) RouteList(link netlink.Link, family int) ([]netlink.Rou
 func (r *RealNetlinkOps) LinkByIndex(index int) (netlink.Link, error) {
 	return netlink.LinkByIndex(index)
 }
+func (r *RealNetlinkOps) FilterAdd(filter netlink.Filter) error {
+	return netlink.FilterAdd(filter)
+}
 
 type RealFileOps struct{}
 
@@ -73,6 +83,9 @@ func (r *RealFileOps) ReadFile(filename string) ([]byte, error) {
 func (r *RealFileOps) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
+func (r *RealFileOps) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
 
 // Config holds the configuration for the throttler
 type Config struct {
@@ -85,34 +98,64 @@ var (
 	fsOps         FileOps    = &RealFileOps{}
 )
 
+// SetOps replaces the kernel and file operations, for tests outside the
+// package (see package fakes).
+func SetOps(nl NetlinkOps, fs FileOps, prio PriorityOps) {
+	nlOps, fsOps, prioOps = nl, fs, prio
+}
+
 func Init() error {
 	log.Println("Initializing Throttler Subsystem...")
 
+	iface, source, err := detectInterface()
+	if err != nil {
+		log.Printf("Could not detect default interface: %v (set VEX_INTERFACE to override)", err)
+		return fmt.Errorf("no usable network interface found")
+	}
+	setInterface(iface)
+	log.Printf("Throttler attached to interface: %s%s", iface, source)
+
+	return nil
+}
+
+// detectInterface finds the interface to shape.  source notes how it was
+// found, for the log.
+func detectInterface() (iface, source string, err error) {
 	// Allow explicit override via environment
 	if envIface := os.Getenv("VEX_INTERFACE"); envIface != "" {
-		currentConfig.Interface = envIface
-		log.Printf("Throttler attached to interface: %s (from VEX_INTERFACE)", envIface)
-		return nil
+		return envIface, " (from VEX_INTERFACE)", nil
 	}
 
 	// Auto-detect interface from the default route
-	iface, err := getDefaultInterface()
+	iface, err = getDefaultInterface()
 	if err != nil {
 		// Try common physical interface names before giving up
 		for _, candidate := range []string{"enp9s0", "enp0s31f6", "eth0", "eno1"} {
 			if _, lerr := nlOps.LinkByName(candidate); lerr == nil {
-				currentConfig.Interface = candidate
-				log.Printf("Throttler attached to interface: %s (fallback probe)", candidate)
-				return nil
+				return candidate, " (fallback probe)", nil
 			}
 		}
-		log.Printf("Could not detect default interface: %v (set VEX_INTERFACE to override)", err)
-		return fmt.Errorf("no usable network interface found")
+		return "", "", err
 	}
-	currentConfig.Interface = iface
-	log.Printf("Throttler attached to interface: %s", iface)
+	return iface, "", nil
+}
 
-	return nil
+// ProbeNetlink checks, without changing anything, that the interface to
+// shape can be found and its qdiscs read over netlink.
+func ProbeNetlink() (string, error) {
+	iface, _, err := detectInterface()
+	if err != nil {
+		return "", fmt.Errorf("no usable network interface: %w", err)
+	}
+	link, err := nlOps.LinkByName(iface)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", iface, err)
+	}
+	qdiscs, err := nlOps.QdiscList(link)
+	if err != nil {
+		return "", fmt.Errorf("%s: list qdiscs: %w", iface, err)
+	}
+	return fmt.Sprintf("%s, %d qdiscs", iface, len(qdiscs)), nil
 }
 
 // ---------------------------------------------------------------------
@@ -120,7 +163,12 @@ func Init() error {
 // ---------------------------------------------------------------------
 
 // ApplyNetworkProfile applies the specified traffic shaping profile
-func ApplyNetworkProfile(profile Profile) error {
+func ApplyNetworkProfile(profile Profile) (err error) {
+	defer func() { publishApplied(profile, 0, err) }()
+	if subsystem.Skip(subsystem.Throttler, "apply the %s network profile", profile) {
+		return nil
+	}
+
 	link, err := nlOps.LinkByName(currentConfig.Interface)
 	if err != nil {
 		return fmt.Errorf("failed to find interface %s: %w", currentConfig.Interface, err)
@@ -136,11 +184,10 @@ func ApplyNetworkProfile(profile Profile) error {
 		return nil
 	}
 
-	// Common attributes for the Root Qdisc
-	attrs := netlink.QdiscAttrs{
-		LinkIndex: link.Attrs().Index,
-		Handle:    netlink.MakeHandle(1, 0),
-		Parent:    netlink.HANDLE_ROOT,
+	// Common attributes for the shaping qdisc
+	attrs, err := shapingAttrs(link)
+	if err != nil {
+		return err
 	}
 
 	var qdisc netlink.Qdisc
@@ -184,7 +231,12 @@ func ApplyNetworkProfile(profile Profile) error {
 // ApplyNetworkProfileWithEntropy applies a traffic shaping profile combined with
 // artificial packet loss in a single netem qdisc, avoiding the qdisc conflict
 // that occurs when ApplyNetworkProfile and InjectEntropy are called separately.
-func ApplyNetworkProfileWithEntropy(profile Profile, lossPercentage float32) error {
+func ApplyNetworkProfileWithEntropy(profile Profile, lossPercentage float32) (err error) {
+	defer func() { publishApplied(profile, lossPercentage, err) }()
+	if subsystem.Skip(subsystem.Throttler, "apply the %s network profile with %.2f%% packet loss", profile, lossPercentage) {
+		return nil
+	}
+
 	link, err := nlOps.LinkByName(currentConfig.Interface)
 	if err != nil {
 		return fmt.Errorf("failed to find interface %s: %w", currentConfig.Interface, err)
@@ -200,10 +252,9 @@ func ApplyNetworkProfileWithEntropy(profile Profile, lossPercentage float32) err
 		return nil
 	}
 
-	attrs := netlink.QdiscAttrs{
-		LinkIndex: link.Attrs().Index,
-		Handle:    netlink.MakeHandle(1, 0),
-		Parent:    netlink.HANDLE_ROOT,
+	attrs, err := shapingAttrs(link)
+	if err != nil {
+		return err
 	}
 
 	// Determine rate from profile
@@ -264,6 +315,50 @@ func InjectEntropy(lossPercentage float32) error {
 	return ApplyNetworkProfileWithEntropy(ProfileStandard, lossPercentage)
 }
 
+// shapingHandles are the handle and parent of the qdisc a profile
+// installs: the root, or with users.Scoped the band of a prio root that
+// only the target users' marked packets reach.
+func shapingHandles() (handle, parent uint32) {
+	if users.Scoped() {
+		return netlink.MakeHandle(2, 0), netlink.MakeHandle(1, 2)
+	}
+	return netlink.MakeHandle(1, 0), netlink.HANDLE_ROOT
+}
+
+// shapingAttrs returns the attributes of the qdisc a profile installs on
+// link, first adding the prio root and fw filter it hangs from if
+// enforcement is scoped to users.
+func shapingAttrs(link netlink.Link) (netlink.QdiscAttrs, error) {
+	index := link.Attrs().Index
+	handle, parent := shapingHandles()
+	attrs := netlink.QdiscAttrs{LinkIndex: index, Handle: handle, Parent: parent}
+	if !users.Scoped() {
+		return attrs, nil
+	}
+
+	// Two bands: everything goes to 1:1 unless the filter sends it to 1:2.
+	prio := netlink.NewPrio(netlink.QdiscAttrs{LinkIndex: index, Handle: netlink.MakeHandle(1, 0), Parent: netlink.HANDLE_ROOT})
+	prio.Bands = 2
+	prio.PriorityMap = [netlink.PRIORITY_MAP_LEN]uint8{}
+	if err := nlOps.QdiscAdd(prio); err != nil {
+		return attrs, fmt.Errorf("failed to add prio qdisc: %w", err)
+	}
+	filter := &netlink.FwFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: index,
+			Parent:    netlink.MakeHandle(1, 0),
+			Handle:    users.Mark,
+			Priority:  1,
+			Protocol:  unix.ETH_P_ALL,
+		},
+		ClassId: parent,
+	}
+	if err := nlOps.FilterAdd(filter); err != nil {
+		return attrs, fmt.Errorf("failed to add fw filter for mark %#x: %w", users.Mark, err)
+	}
+	return attrs, nil
+}
+
 func clearQdiscs(link netlink.Link) error {
 	qdiscs, err := nlOps.QdiscList(link)
 	if err != nil {
@@ -305,7 +400,8 @@ func getDefaultInterface() (string, error) {
 // State Persistence
 // ---------------------------------------------------------------------
 
-const stateFilePath = "/var/lib/vex-cli/throttler-state.json"
+// StateFile is where SaveState persists the throttler state.
+var StateFile = "/var/lib/vex-cli/throttler-state.json"
 
 // ThrottlerState is the persisted state written to disk so that the active
 // profile survives reboots.
@@ -325,13 +421,13 @@ func SaveState(state *ThrottlerState) error {
 		return fmt.Errorf("failed to marshal throttler state: %w", err)
 	}
 	// Ensure directory exists
-	dir := filepath.Dir(stateFilePath)
+	dir := filepath.Dir(StateFile)
 	if _, err := fsOps.Stat(dir); os.IsNotExist(err) {
 		if mkErr := os.MkdirAll(dir, 0755); mkErr != nil {
 			return fmt.Errorf("failed to create state directory %s: %w", dir, mkErr)
 		}
 	}
-	if err := fsOps.WriteFile(stateFilePath, data, 0644); err != nil {
+	if err := fsOps.WriteFile(StateFile, data, 0644); err != nil {
 		return fmt.Errorf("failed to write throttler state: %w", err)
 	}
 	log.Printf("Throttler state persisted: profile=%s, loss=%.2f%%, cpu=%d%%, by=%s",
@@ -342,7 +438,7 @@ func SaveState(state *ThrottlerState) error {
 // LoadState reads the persisted throttler state from disk.
 // Returns nil (no error) if the file does not exist.
 func LoadState() (*ThrottlerState, error) {
-	data, err := fsOps.ReadFile(stateFilePath)
+	data, err := fsOps.ReadFile(StateFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -375,6 +471,32 @@ var profileAliases = map[string]Profile{
 	"drop":       ProfileBlackHole,
 }
 
+// profileSeverity ranks profiles from least to most restrictive.
+var profileSeverity = map[Profile]int{
+	ProfileStandard:  0,
+	ProfileChoke:     1,
+	ProfileDialUp:    2,
+	ProfileBlackHole: 3,
+}
+
+// Severity returns how restrictive a profile is (0 = unrestricted).
+// Unknown profiles rank as standard.
+func Severity(p Profile) int {
+	return profileSeverity[p]
+}
+
+// Tighter returns the profile one step more restrictive than p, or p
+// itself if it is already the most restrictive.
+func Tighter(p Profile) Profile {
+	next := p
+	for q, sev := range profileSeverity {
+		if sev > Severity(p) && (next == p || sev < Severity(next)) {
+			next = q
+		}
+	}
+	return next
+}
+
 // ResolveProfile normalises a user-supplied profile string to a canonical Profile.
 // Returns an error if the input doesn't match any known profile or alias.
 func ResolveProfile(input string) (Profile, error) {
@@ -387,12 +509,12 @@ func ResolveProfile(input string) (Profile, error) {
 
 const cgroupMount = "/sys/fs/cgroup"
 
-// cpuMaxCandidates lists paths to try for cpu.max, in priority order.
+// CPUMaxCandidates lists paths to try for cpu.max, in priority order.
 // The root cgroup never has cpu.max on a real host — it only exists
 // inside containers where the root *is* the container's cgroup.
 // On a normal NixOS/systemd host we target user.slice so the penalty
 // affects all user sessions.
-var cpuMaxCandidates = []string{
+var CPUMaxCandidates = []string{
 	filepath.Join(cgroupMount, "cpu.max"),              // containers
 	filepath.Join(cgroupMount, "user.slice", "cpu.max"), // user processes (NixOS / systemd)
 	filepath.Join(cgroupMount, "system.slice", "cpu.max"),
@@ -401,12 +523,43 @@ var cpuMaxCandidates = []string{
 // resolveCPUMaxPath finds the first existing cpu.max file from the
 // candidate list, or returns an error.
 func resolveCPUMaxPath() (string, error) {
-	for _, p := range cpuMaxCandidates {
+	for _, p := range CPUMaxCandidates {
 		if _, err := fsOps.Stat(p); err == nil {
 			return p, nil
 		}
 	}
-	return "", fmt.Errorf("cgroup v2 cpu.max not found (tried %v). Ensure cgroups v2 is enabled", cpuMaxCandidates)
+	return "", fmt.Errorf("cgroup v2 cpu.max not found (tried %v). Ensure cgroups v2 is enabled", CPUMaxCandidates)
+}
+
+// ProbeCgroup checks that cgroup v2 is mounted and that the cpu.max files
+// a CPU limit goes to are writable, without writing them.
+func ProbeCgroup() (string, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(cgroupMount, &fs); err != nil {
+		return "", err
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return "", fmt.Errorf("%s is not cgroup v2", cgroupMount)
+	}
+	if PenaltySlice {
+		if err := unix.Access(cgroupMount, unix.W_OK); err != nil {
+			return "", fmt.Errorf("%s: %w", cgroupMount, err)
+		}
+		return penaltySlicePath() + " (created when a limit is set)", nil
+	}
+	paths, err := cpuMaxPaths()
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "no target user logged in", nil
+	}
+	for _, path := range paths {
+		if err := unix.Access(path, unix.W_OK); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return strings.Join(paths, ", "), nil
 }
 
 // SetCPULimit limits CPU usage via Cgroup v2 cpu.max.
@@ -416,6 +569,65 @@ func SetCPULimit(limitPercent int) error {
 		return fmt.Errorf("invalid percentage: %d", limitPercent)
 	}
 
+	value := cpuMaxValue(limitPercent)
+	if subsystem.Skip(subsystem.Throttler, "set the CPU limit to %d%% (%s)", limitPercent, value) {
+		return nil
+	}
+
+	if PenaltySlice && limitPercent < 100 {
+		if err := ensurePenaltySlice(); err != nil {
+			return err
+		}
+	}
+	paths, err := cpuMaxPaths()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := fsOps.WriteFile(path, []byte(value), 0644); err != nil {
+			if PenaltySlice && limitPercent == 100 && os.IsNotExist(err) {
+				continue // the slice was never needed
+			}
+			return fmt.Errorf("failed to write cpu limit to %s: %w", path, err)
+		}
+		log.Printf("CPU Limit Set: %d%% (%s) → %s", limitPercent, strings.TrimSpace(value), path)
+	}
+	if PenaltySlice {
+		updatePenaltySlice(limitPercent)
+	}
+	return nil
+}
+
+// cpuMaxPaths are the cpu.max files a CPU limit is written to: the
+// penalty slice's with PenaltySlice, the first candidate, or with
+// users.Scoped each target user's slice.  A user's slice only exists
+// while they are logged in, so users who are not get none.
+func cpuMaxPaths() ([]string, error) {
+	if PenaltySlice {
+		return []string{filepath.Join(penaltySlicePath(), "cpu.max")}, nil
+	}
+	if !users.Scoped() {
+		path, err := resolveCPUMaxPath()
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	}
+	var paths []string
+	for _, uid := range users.UIDs() {
+		path := filepath.Join(cgroupMount, "user.slice", fmt.Sprintf("user-%d.slice", uid), "cpu.max")
+		if _, err := fsOps.Stat(path); err == nil {
+			paths = append(paths, path)
+		} else {
+			log.Printf("CPU limit: no slice for UID %d (not logged in?)", uid)
+		}
+	}
+	return paths, nil
+}
+
+// cpuMaxValue is what cpu.max holds for a limit of limitPercent.
+func cpuMaxValue(limitPercent int) string {
 	// Default period in microseconds (100ms)
 	period := 100000
 
@@ -427,18 +639,67 @@ func SetCPULimit(limitPercent int) error {
 		quotaVal := (limitPercent * period) / 100
 		quota = strconv.Itoa(quotaVal)
 	}
+	return fmt.Sprintf("%s %d", quota, period)
+}
 
-	value := fmt.Sprintf("%s %d", quota, period)
+// Verify reads back the shaping qdisc and cpu.max and reports where they
+// differ from what applying the profile, packet loss and CPU limit would
+// have left.  A limit outside 1-100 is not checked.  vexd uses it after a
+// run that did not stop cleanly.
+func Verify(profile Profile, lossPercentage float32, limitPercent int) error {
+	var errs []error
 
-	path, err := resolveCPUMaxPath()
+	want := ""
+	switch {
+	case lossPercentage > 0, profile == ProfileDialUp, profile == ProfileBlackHole:
+		want = "netem"
+	case profile == ProfileChoke:
+		want = "tbf"
+	}
+	link, err := nlOps.LinkByName(currentConfig.Interface)
 	if err != nil {
-		return err
+		errs = append(errs, fmt.Errorf("failed to find interface %s: %w", currentConfig.Interface, err))
+	} else if qdiscs, err := nlOps.QdiscList(link); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list qdiscs: %w", err))
+	} else {
+		handle, parent := shapingHandles()
+		got := ""
+		for _, q := range qdiscs {
+			if q.Attrs().Parent == parent && q.Attrs().Handle == handle {
+				got = q.Type()
+			}
+		}
+		if got != want {
+			errs = append(errs, fmt.Errorf("%s has shaping qdisc %q, expected %q for %s", currentConfig.Interface, got, want, profile))
+		}
 	}
 
-	if err := fsOps.WriteFile(path, []byte(value), 0644); err != nil {
-		return fmt.Errorf("failed to write cpu limit to %s: %w", path, err)
+	if limitPercent > 0 && limitPercent <= 100 {
+		paths, err := cpuMaxPaths()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, path := range paths {
+			data, err := fsOps.ReadFile(path)
+			if err == nil {
+				if got, want := strings.TrimSpace(string(data)), cpuMaxValue(limitPercent); got != want {
+					err = fmt.Errorf("%s holds %q, expected %q", path, got, want)
+				}
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	log.Printf("CPU Limit Set: %d%% (%s) → %s", limitPercent, strings.TrimSpace(value), path)
-	return nil
+// publishApplied announces the outcome of applying a profile on the event
+// bus.
+func publishApplied(profile Profile, loss float32, err error) {
+	e := events.ProfileApplied{Profile: string(profile), Loss: loss}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	events.Publish(e)
 }
@@ -3,9 +3,12 @@ package throttler
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/adumbdinosaur/vex-cli/internal/users"
 	"github.com/vishvananda/netlink"
 )
 
@@ -18,6 +21,7 @@ type MockNetlinkOps struct {
 	QdiscDelFunc    func(qdisc netlink.Qdisc) error
 	RouteListFunc   func(link netlink.Link, family int) ([]netlink.Route, error)
 	LinkByIndexFunc func(index int) (netlink.Link, error)
+	FilterAddFunc   func(filter netlink.Filter) error
 }
 
 func (m *MockNetlinkOps) LinkByName(name string) (netlink.Link, error) {
@@ -56,6 +60,12 @@ func (m *MockNetlinkOps) LinkByIndex(index int) (netlink.Link, error) {
 	}
 	return &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "enp9s0", Index: index}}, nil
 }
+func (m *MockNetlinkOps) FilterAdd(filter netlink.Filter) error {
+	if m.FilterAddFunc != nil {
+		return m.FilterAddFunc(filter)
+	}
+	return nil
+}
 
 type MockFileOps struct {
 	WriteFileFunc func(filename string, data []byte, perm os.FileMode) error
@@ -80,6 +90,9 @@ func (m *MockFileOps) ReadFile(filename string) ([]byte, error) {
 	}
 	return nil, os.ErrNotExist
 }
+func (m *MockFileOps) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
 func (m *MockFileOps) Stat(name string) (os.FileInfo, error) {
 	if m.StatFunc != nil {
 		return m.StatFunc(name)
@@ -183,3 +196,293 @@ func TestSetCPULimit_Calculation(t *testing.T) {
 		t.Errorf("Expected content '%s', got '%s'", expectedValueMax, strings.TrimSpace(content))
 	}
 }
+
+func TestVerify(t *testing.T) {
+	currentConfig.Interface = "enp9s0"
+	root := netlink.QdiscAttrs{Handle: netlink.MakeHandle(1, 0), Parent: netlink.HANDLE_ROOT}
+	qdiscs := []netlink.Qdisc{&netlink.Tbf{QdiscAttrs: root}}
+	nlOps = &MockNetlinkOps{
+		QdiscListFunc: func(link netlink.Link) ([]netlink.Qdisc, error) { return qdiscs, nil },
+	}
+	fsOps = &MockFileOps{
+		ReadFileFunc: func(string) ([]byte, error) { return []byte("15000 100000\n"), nil },
+	}
+
+	if err := Verify(ProfileChoke, 0, 15); err != nil {
+		t.Errorf("Expected the kernel to match, got %v", err)
+	}
+	if err := Verify(ProfileChoke, 5, 15); err == nil || !strings.Contains(err.Error(), `expected "netem"`) {
+		t.Errorf("Expected packet loss to need netem, got %v", err)
+	}
+	if err := Verify(ProfileChoke, 0, 50); err == nil || !strings.Contains(err.Error(), "cpu.max") {
+		t.Errorf("Expected a cpu.max mismatch, got %v", err)
+	}
+
+	qdiscs = nil
+	if err := Verify(ProfileStandard, 0, 0); err != nil {
+		t.Errorf("Expected no qdisc for the standard profile, got %v", err)
+	}
+}
+
+func TestScopedToUsers(t *testing.T) {
+	users.Targets = []string{"1000"}
+	users.Resolve()
+	defer func() {
+		users.Targets = nil
+		users.Resolve()
+	}()
+
+	var added []netlink.Qdisc
+	var filters []netlink.Filter
+	nlOps = &MockNetlinkOps{
+		QdiscAddFunc:  func(q netlink.Qdisc) error { added = append(added, q); return nil },
+		FilterAddFunc: func(f netlink.Filter) error { filters = append(filters, f); return nil },
+	}
+	mockFS := &MockFileOps{}
+	fsOps = mockFS
+	currentConfig.Interface = "enp9s0"
+
+	if err := ApplyNetworkProfile(ProfileChoke); err != nil {
+		t.Fatalf("ApplyNetworkProfile failed: %v", err)
+	}
+	if len(added) != 2 || added[0].Type() != "prio" || added[1].Type() != "tbf" {
+		t.Fatalf("Expected a prio root with a tbf beneath it, got %v", added)
+	}
+	if added[1].Attrs().Parent != netlink.MakeHandle(1, 2) {
+		t.Errorf("Expected the tbf on 1:2, got %s", netlink.HandleStr(added[1].Attrs().Parent))
+	}
+	fw, ok := filters[0].(*netlink.FwFilter)
+	if len(filters) != 1 || !ok || fw.Handle != users.Mark || fw.ClassId != netlink.MakeHandle(1, 2) {
+		t.Errorf("Expected one fw filter sending mark %#x to 1:2, got %v", users.Mark, filters)
+	}
+
+	if err := SetCPULimit(15); err != nil {
+		t.Fatalf("SetCPULimit failed: %v", err)
+	}
+	want := "/sys/fs/cgroup/user.slice/user-1000.slice/cpu.max"
+	if len(mockFS.WrittenFiles) != 1 || mockFS.WrittenFiles[want] != "15000 100000" {
+		t.Errorf("Expected only %s to be limited, got %v", want, mockFS.WrittenFiles)
+	}
+}
+
+func TestPenaltySlice(t *testing.T) {
+	PenaltySlice, procDir = true, t.TempDir()
+	defer func() { PenaltySlice, procDir = false, "/proc" }()
+	for pid, p := range map[string]struct{ cgroup, uid, ppid string }{
+		"100": {"/user.slice/user-1000.slice/session-2.scope", "1000", "1"},
+		"101": {"/system.slice/sshd.service", "0", "1"},
+		"102": {"/vex-penalty.slice", "1000", "100"}, // started in the slice
+	} {
+		os.MkdirAll(filepath.Join(procDir, pid), 0755)
+		os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte("0::"+p.cgroup+"\n"), 0644)
+		os.WriteFile(filepath.Join(procDir, pid, "status"), []byte("Uid:\t"+p.uid+"\t"+p.uid+"\nPPid:\t"+p.ppid+"\n"), 0644)
+	}
+
+	var writes []string
+	mockFS := &MockFileOps{
+		WriteFileFunc: func(name string, data []byte, perm os.FileMode) error {
+			writes = append(writes, name+" "+string(data))
+			return nil
+		},
+		ReadFileFunc: func(name string) ([]byte, error) { return []byte("100\n102\n"), nil },
+	}
+	fsOps = mockFS
+
+	if err := SetCPULimit(15); err != nil {
+		t.Fatalf("SetCPULimit failed: %v", err)
+	}
+	for _, want := range []string{
+		"/sys/fs/cgroup/cgroup.subtree_control +cpu",
+		"/sys/fs/cgroup/vex-penalty.slice/cpu.max 15000 100000",
+		"/sys/fs/cgroup/vex-penalty.slice/cgroup.procs 100",
+	} {
+		if !slices.Contains(writes, want) {
+			t.Errorf("Expected write %q, got %v", want, writes)
+		}
+	}
+	if len(writes) != 3 {
+		t.Errorf("Expected only the session's process to be moved, got %v", writes)
+	}
+
+	writes = nil
+	if err := SetCPULimit(100); err != nil {
+		t.Fatalf("SetCPULimit 100 failed: %v", err)
+	}
+	session := "/sys/fs/cgroup/user.slice/user-1000.slice/session-2.scope/cgroup.procs"
+	for _, want := range []string{session + " 100", session + " 102"} {
+		if !slices.Contains(writes, want) {
+			t.Errorf("Expected write %q, got %v", want, writes)
+		}
+	}
+	if sweepDone != nil {
+		t.Error("Expected the sweep to stop when the limit is lifted")
+	}
+}
+
+type fakePriorityOps map[int]TaskPriority
+
+func (f fakePriorityOps) Get(tid int) (TaskPriority, error) { return f[tid], nil }
+func (f fakePriorityOps) Set(tid int, p TaskPriority) error { f[tid] = p; return nil }
+
+func TestSetPriority(t *testing.T) {
+	procDir, PrioritySavedFile = t.TempDir(), filepath.Join(t.TempDir(), "priority-saved.json")
+	defer func() { procDir, prioOps, PriorityTargets = "/proc", &RealPriorityOps{}, nil }()
+	for pid, p := range map[string]struct{ uid, comm string }{
+		"100": {"1000", "steam"},
+		"200": {"1000", "firefox"},
+		"300": {"0", "sshd"},
+	} {
+		os.MkdirAll(filepath.Join(procDir, pid, "task", pid), 0755)
+		os.WriteFile(filepath.Join(procDir, pid, "cgroup"), []byte("0::/user.slice\n"), 0644)
+		os.WriteFile(filepath.Join(procDir, pid, "status"), []byte("Uid:\t"+p.uid+"\t"+p.uid+"\nPPid:\t1\n"), 0644)
+		os.WriteFile(filepath.Join(procDir, pid, "comm"), []byte(p.comm+"\n"), 0644)
+	}
+	os.MkdirAll(filepath.Join(procDir, "100", "task", "101"), 0755) // a second thread
+	own := map[int]TaskPriority{100: {}, 101: {Nice: 15}, 200: {Nice: -5, IOPrio: 2<<13 | 4}, 300: {}}
+	ops := fakePriorityOps{}
+	for tid, p := range own {
+		ops[tid] = p
+	}
+	prioOps = ops
+
+	if err := SetPriority("idle"); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if p := ops[101]; p.Policy != 5 || p.IOPrio != ioprioIdle || p.Nice != 15 {
+		t.Errorf("Expected every thread at SCHED_IDLE, got %+v", p)
+	}
+	if ops[300] != own[300] {
+		t.Errorf("Expected root's processes to be left alone, got %+v", ops[300])
+	}
+
+	// A milder level starts from the threads' own priority.
+	if err := SetPriority("low"); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	if p := ops[200]; p != (TaskPriority{Nice: 10, IOPrio: 2<<13 | 4}) {
+		t.Errorf("Expected firefox at nice 10 with its own I/O priority, got %+v", p)
+	}
+	if p := ops[101]; p.Nice != 15 || p.Policy != 0 {
+		t.Errorf("Expected a level never to raise a thread, got %+v", p)
+	}
+
+	if err := SetPriority("normal"); err != nil {
+		t.Fatalf("SetPriority failed: %v", err)
+	}
+	for tid, p := range own {
+		if ops[tid] != p {
+			t.Errorf("Expected TID %d back at %+v, got %+v", tid, p, ops[tid])
+		}
+	}
+
+	PriorityTargets = []string{"Steam*"}
+	SetPriority("lowest")
+	if ops[100].Nice != 19 || ops[200] != own[200] {
+		t.Errorf("Expected only steam lowered, got %+v and %+v", ops[100], ops[200])
+	}
+	SetPriority("normal")
+
+	if err := SetPriority("nice"); err == nil {
+		t.Error("Expected an unknown level to be refused")
+	}
+}
+
+func TestUplinkMove(t *testing.T) {
+	currentConfig.Interface = "wlan0"
+	links := map[string]*netlink.Device{
+		"wlan0": {LinkAttrs: netlink.LinkAttrs{Name: "wlan0", Index: 10}},
+		"usb0":  {LinkAttrs: netlink.LinkAttrs{Name: "usb0", Index: 11}},
+	}
+	defaultIndex := 10
+	var deleted []int
+	nlOps = &MockNetlinkOps{
+		RouteListFunc: func(netlink.Link, int) ([]netlink.Route, error) {
+			return []netlink.Route{{Dst: nil, LinkIndex: defaultIndex}}, nil
+		},
+		LinkByIndexFunc: func(index int) (netlink.Link, error) {
+			for _, l := range links {
+				if l.Index == index {
+					return l, nil
+				}
+			}
+			return nil, fmt.Errorf("not found")
+		},
+		LinkByNameFunc: func(name string) (netlink.Link, error) { return links[name], nil },
+		QdiscListFunc: func(link netlink.Link) ([]netlink.Qdisc, error) {
+			return []netlink.Qdisc{&netlink.Tbf{QdiscAttrs: netlink.QdiscAttrs{LinkIndex: link.Attrs().Index, Parent: netlink.HANDLE_ROOT}}}, nil
+		},
+		QdiscDelFunc: func(q netlink.Qdisc) error { deleted = append(deleted, q.Attrs().LinkIndex); return nil },
+	}
+
+	var moves []string
+	moved := func(from, to string) {
+		moves = append(moves, from+"->"+to)
+		MoveInterface(to)
+	}
+	checkUplink(moved)
+	if len(moves) != 0 {
+		t.Fatalf("Expected no move while the default route stays, got %v", moves)
+	}
+
+	defaultIndex = 11 // tethered phone
+	checkUplink(moved)
+	checkUplink(moved)
+	if len(moves) != 1 || moves[0] != "wlan0->usb0" || Interface() != "usb0" {
+		t.Errorf("Expected one move to usb0, got %v (now %s)", moves, Interface())
+	}
+	if len(deleted) != 1 || deleted[0] != 10 {
+		t.Errorf("Expected the shaping to be removed from wlan0, got deletions on %v", deleted)
+	}
+}
+
+func TestTighter(t *testing.T) {
+	for p, want := range map[Profile]Profile{
+		ProfileStandard:  ProfileChoke,
+		ProfileChoke:     ProfileDialUp,
+		ProfileDialUp:    ProfileBlackHole,
+		ProfileBlackHole: ProfileBlackHole,
+		"":               ProfileChoke,
+	} {
+		if got := Tighter(p); got != want {
+			t.Errorf("Tighter(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestFreezeSessions(t *testing.T) {
+	users.Targets = []string{"1000", "1001"}
+	users.Resolve()
+	defer func() {
+		users.Targets = nil
+		users.Resolve()
+	}()
+
+	mockFS := &MockFileOps{
+		StatFunc: func(name string) (os.FileInfo, error) {
+			if strings.Contains(name, "user-1001.slice") {
+				return nil, os.ErrNotExist // not logged in
+			}
+			return nil, nil
+		},
+	}
+	fsOps = mockFS
+
+	want := "/sys/fs/cgroup/user.slice/user-1000.slice/cgroup.freeze"
+	if err := FreezeSessions(true); err != nil {
+		t.Fatalf("FreezeSessions failed: %v", err)
+	}
+	if len(mockFS.WrittenFiles) != 1 || mockFS.WrittenFiles[want] != "1" {
+		t.Errorf("Expected only %s to be frozen, got %v", want, mockFS.WrittenFiles)
+	}
+	if err := FreezeSessions(false); err != nil || mockFS.WrittenFiles[want] != "0" {
+		t.Errorf("Expected %s to be thawed, got %v, %v", want, mockFS.WrittenFiles, err)
+	}
+
+	mockFS.StatFunc = func(string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+	if err := FreezeSessions(true); err == nil {
+		t.Error("Expected an error with no session to freeze")
+	}
+	if err := FreezeSessions(false); err != nil {
+		t.Errorf("Expected nothing to thaw to be fine, got %v", err)
+	}
+}
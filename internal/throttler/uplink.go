@@ -0,0 +1,89 @@
+package throttler
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+// ---------------------------------------------------------------------
+// Uplink Watch
+// ---------------------------------------------------------------------
+//
+// The shaping qdisc sits on one interface.  When the default route moves
+// to another, say a phone tethered over USB or Bluetooth, traffic leaves
+// by the new uplink unshaped.  The uplink watch looks at the default
+// route every UplinkCheckInterval and hands a move to vexd, which calls
+// MoveInterface and applies the network state again.  An interface set
+// with VEX_INTERFACE is never moved.
+
+// UplinkCheckInterval is how often the uplink watch looks at the default
+// route.
+var UplinkCheckInterval = 5 * time.Second
+
+var ifaceMu sync.Mutex // guards currentConfig.Interface for the uplink watch
+
+// Interface returns the interface being shaped, "" if none was found.
+func Interface() string {
+	ifaceMu.Lock()
+	defer ifaceMu.Unlock()
+	return currentConfig.Interface
+}
+
+func setInterface(iface string) {
+	ifaceMu.Lock()
+	defer ifaceMu.Unlock()
+	currentConfig.Interface = iface
+}
+
+// WatchUplink starts the uplink watch.  moved is called when the default
+// route leaves the shaped interface, with the interface it now uses.
+func WatchUplink(moved func(from, to string)) {
+	if os.Getenv("VEX_INTERFACE") != "" {
+		log.Printf("Throttler: Interface pinned by VEX_INTERFACE; not watching the default route")
+		return
+	}
+	supervisor.Go("throttler.uplink-watch", func() error {
+		ticker := time.NewTicker(UplinkCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkUplink(moved)
+		}
+		return nil
+	})
+	log.Printf("Throttler: Uplink watch started (%s interval)", UplinkCheckInterval)
+}
+
+// checkUplink calls moved if the default route uses another interface
+// than the shaped one.  No default route, as while a link is replaced, is
+// not a move.
+func checkUplink(moved func(from, to string)) {
+	iface, err := getDefaultInterface()
+	if err != nil {
+		return
+	}
+	if from := Interface(); iface != from {
+		moved(from, iface)
+	}
+}
+
+// MoveInterface makes iface the shaped interface and removes the shaping
+// from the old one, if it is still there.  The caller applies the profile
+// to iface.
+func MoveInterface(iface string) {
+	from := Interface()
+	setInterface(iface)
+	log.Printf("Throttler attached to interface: %s (was %s)", iface, from)
+	if from == "" || subsystem.Skip(subsystem.Throttler, "remove the shaping from %s", from) {
+		return
+	}
+	if link, err := nlOps.LinkByName(from); err == nil {
+		if err := clearQdiscs(link); err != nil {
+			log.Printf("Throttler: failed to clear qdiscs on %s: %v", from, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package tiers maps the failure score to named compliance tiers, such as
+// green, yellow and red, each with a set of restrictions.
+//
+// Rather than reacting to single escalations, vexd keeps the machine in
+// the tier the current score falls in: when the score crosses into
+// another tier, that tier's restrictions replace the previous tier's.
+// The restrictions are a calendar.Preset and are imposed the same way,
+// together with any calendar presets in force.
+package tiers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/adumbdinosaur/vex-cli/internal/calendar"
+)
+
+// ConfigFile holds the tiers.  Optional.
+var ConfigFile = "/etc/vex-cli/tiers.json"
+
+// Tier is one band of the failure score.
+type Tier struct {
+	Name         string          `json:"name"`
+	MinScore     int             `json:"min_score"` // the tier applies from this score up to the next tier's
+	Restrictions calendar.Preset `json:"restrictions"`
+}
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Tiers []Tier `json:"tiers"`
+}
+
+// LoadConfig reads and validates ConfigFile and sorts the tiers by
+// MinScore.  A missing file means no tiers are configured and returns
+// nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	sort.Slice(c.Tiers, func(i, j int) bool { return c.Tiers[i].MinScore < c.Tiers[j].MinScore })
+	return &c, nil
+}
+
+// Validate requires at least one tier, unique names and minimum scores,
+// and valid restrictions.
+func (c *Config) Validate() error {
+	if len(c.Tiers) == 0 {
+		return fmt.Errorf("no tiers")
+	}
+	names := make(map[string]bool)
+	scores := make(map[int]bool)
+	for _, t := range c.Tiers {
+		name := strings.TrimSpace(t.Name)
+		if name == "" {
+			return fmt.Errorf("tier with min_score %d has no name", t.MinScore)
+		}
+		if names[name] {
+			return fmt.Errorf("tier %q is defined twice", name)
+		}
+		if scores[t.MinScore] {
+			return fmt.Errorf("tier %q: another tier also starts at %d", name, t.MinScore)
+		}
+		if t.MinScore < 0 {
+			return fmt.Errorf("tier %q: min_score must not be negative", name)
+		}
+		if err := t.Restrictions.Validate(); err != nil {
+			return fmt.Errorf("tier %q: %w", name, err)
+		}
+		names[name], scores[t.MinScore] = true, true
+	}
+	return nil
+}
+
+// For returns the tier score falls in, or nil when it is below every
+// tier.  The tiers must be sorted, as LoadConfig leaves them.
+func (c *Config) For(score int) *Tier {
+	var in *Tier
+	for i := range c.Tiers {
+		if c.Tiers[i].MinScore > score {
+			break
+		}
+		in = &c.Tiers[i]
+	}
+	return in
+}
+
+// Named returns the tier called name, or nil.
+func (c *Config) Named(name string) *Tier {
+	for i := range c.Tiers {
+		if c.Tiers[i].Name == name {
+			return &c.Tiers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package tiers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adumbdinosaur/vex-cli/internal/calendar"
+)
+
+func TestLoadConfig(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "tiers.json")
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+
+	data := `{"tiers": [
+		{"name": "red", "min_score": 30, "restrictions": {"profile": "black-hole", "cpu_limit": 20}},
+		{"name": "green", "min_score": 0},
+		{"name": "yellow", "min_score": 10, "restrictions": {"profile": "choke", "block": ["reddit.com"]}}
+	]}`
+	if err := os.WriteFile(ConfigFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	for score, want := range map[int]string{0: "green", 9: "green", 10: "yellow", 29: "yellow", 30: "red", 500: "red"} {
+		if got := c.For(score); got == nil || got.Name != want {
+			t.Errorf("For(%d) = %v, want %s", score, got, want)
+		}
+	}
+	if got := c.Named("yellow"); got == nil || got.Restrictions.Profile != "choke" {
+		t.Errorf("Named(yellow) = %v", got)
+	}
+	if c.Named("blue") != nil {
+		t.Error("Expected no tier named blue")
+	}
+}
+
+func TestForBelowEveryTier(t *testing.T) {
+	c := &Config{Tiers: []Tier{{Name: "red", MinScore: 30}}}
+	if got := c.For(5); got != nil {
+		t.Errorf("For(5) = %v, want nil", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tiers []Tier
+		want  string
+	}{
+		"empty":     {nil, "no tiers"},
+		"unnamed":   {[]Tier{{MinScore: 5}}, "no name"},
+		"duplicate": {[]Tier{{Name: "a"}, {Name: "a", MinScore: 5}}, "defined twice"},
+		"same from": {[]Tier{{Name: "a"}, {Name: "b"}}, "also starts at 0"},
+		"negative":  {[]Tier{{Name: "a", MinScore: -1}}, "negative"},
+		"profile":   {[]Tier{{Name: "a", Restrictions: calendar.Preset{Profile: "warp"}}}, "unknown profile"},
+		"cpu":       {[]Tier{{Name: "a", Restrictions: calendar.Preset{CPULimit: 101}}}, "cpu_limit"},
+	} {
+		err := (&Config{Tiers: tc.tiers}).Validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Package usb applies a policy to the USB devices plugged in while
+// penance is locked, which closes the gaps a new device opens: a game
+// booted from an external drive, a phone tethered for a network the
+// throttler does not shape, a second keyboard.
+//
+// The kernel's uevents are read from a netlink socket.  Each new USB
+// interface is sorted into a kind by its class, and the policy for the
+// kind says whether to log it, notify the keyholder or block it.  Blocking
+// deauthorizes the interface, as USBGuard does, so no driver binds to it;
+// the other interfaces of the device, such as a keyboard on a
+// keyboard-with-a-card-reader, keep working if their kind is not blocked.
+// Release authorizes the blocked interfaces again.
+package usb
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/adumbdinosaur/vex-cli/internal/subsystem"
+	"github.com/adumbdinosaur/vex-cli/internal/supervisor"
+)
+
+var (
+	// ConfigFile turns the policy on.  Without it USB devices are left
+	// alone.
+	ConfigFile = "/etc/vex-cli/usb.json"
+
+	// SysDir is where sysfs is mounted.
+	SysDir = "/sys"
+
+	// BlockedFile keeps the interfaces blocked so far, so that a restart
+	// in between can still release them.
+	BlockedFile = "/run/vex-cli/usb-blocked.json"
+)
+
+// Kinds of device a policy is set for.
+const (
+	KindStorage = "storage"
+	KindNetwork = "network"
+	KindInput   = "input"
+)
+
+// Actions a policy can take.
+const (
+	ActionLog    = "log"
+	ActionNotify = "notify"
+	ActionBlock  = "block"
+)
+
+// Config is the contents of ConfigFile.
+type Config struct {
+	Storage string   `json:"storage,omitempty"` // default block
+	Network string   `json:"network,omitempty"` // default block
+	Input   string   `json:"input,omitempty"`   // default notify
+	Allow   []string `json:"allow,omitempty"`   // "vendor:product" IDs left alone, e.g. "046d:c52b"
+}
+
+// LoadConfig reads and validates ConfigFile.  A missing file means the
+// policy is off and returns nil.
+func LoadConfig() (*Config, error) {
+	data, err := os.ReadFile(ConfigFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", ConfigFile, err)
+	}
+	for kind, action := range map[string]string{KindStorage: c.Storage, KindNetwork: c.Network, KindInput: c.Input} {
+		if action != "" && action != ActionLog && action != ActionNotify && action != ActionBlock {
+			return nil, fmt.Errorf("%s: %s must be log, notify or block", ConfigFile, kind)
+		}
+	}
+	for _, id := range c.Allow {
+		if v, p, ok := strings.Cut(id, ":"); !ok || !isHex(v) || !isHex(p) {
+			return nil, fmt.Errorf("%s: allow entry %q is not vendor:product", ConfigFile, id)
+		}
+	}
+	return &c, nil
+}
+
+// Action is what the policy does with a device of the given kind.
+func (c *Config) Action(kind string) string {
+	switch kind {
+	case KindStorage:
+		return cmp.Or(c.Storage, ActionBlock)
+	case KindNetwork:
+		return cmp.Or(c.Network, ActionBlock)
+	case KindInput:
+		return cmp.Or(c.Input, ActionNotify)
+	}
+	return ""
+}
+
+// Allowed reports whether d is on the allow list.
+func (c *Config) Allowed(d Device) bool {
+	return slices.Contains(c.Allow, d.ID())
+}
+
+// Device is one interface of a USB device.
+type Device struct {
+	Path    string // sysfs path of the interface, under SysDir
+	Kind    string // KindStorage, KindNetwork or KindInput
+	Vendor  string // four hex digits
+	Product string
+}
+
+// Name is the interface's name on the bus, e.g. 1-2:1.0.
+func (d Device) Name() string { return filepath.Base(d.Path) }
+
+// ID is the device's vendor:product.
+func (d Device) ID() string { return d.Vendor + ":" + d.Product }
+
+// Report is told about each new device the policy acted on: what it did
+// and, when blocking failed, why.
+type Report func(d Device, action string, err error)
+
+// Start reads uevents in the background and, while enforcing returns
+// true, applies c to each new USB interface.
+func Start(c *Config, enforcing func() bool, report Report) {
+	supervisor.Go("usb.watch", func() error {
+		fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+		if err != nil {
+			return fmt.Errorf("uevent socket: %w", err)
+		}
+		defer unix.Close(fd)
+		if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+			return fmt.Errorf("uevent socket: %w", err)
+		}
+		buf := make([]byte, 16*1024)
+		for {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == unix.EINTR || err == unix.ENOBUFS {
+					continue // ENOBUFS: events were dropped, but the socket is fine
+				}
+				return fmt.Errorf("uevent socket: %w", err)
+			}
+			d, ok := parseUevent(buf[:n])
+			if !ok || c.Allowed(d) || !enforcing() {
+				continue
+			}
+			action := c.Action(d.Kind)
+			var blockErr error
+			if action == ActionBlock {
+				blockErr = Block(d)
+			}
+			report(d, action, blockErr)
+		}
+	})
+	log.Printf("USB: Applying %s to new devices while locked", ConfigFile)
+}
+
+// parseUevent reads the new USB interface a uevent announces, if it is
+// one of a kind the policy covers.
+func parseUevent(msg []byte) (Device, bool) {
+	env := make(map[string]string)
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		if k, v, ok := strings.Cut(string(field), "="); ok {
+			env[k] = v
+		}
+	}
+	if env["ACTION"] != "add" || env["SUBSYSTEM"] != "usb" || env["DEVTYPE"] != "usb_interface" {
+		return Device{}, false
+	}
+	d := Device{Path: env["DEVPATH"], Kind: kindOf(env["INTERFACE"])}
+	if d.Kind == "" || d.Path == "" {
+		return Device{}, false
+	}
+	// PRODUCT is vendor/product/release in hex, without leading zeros.
+	parts := strings.Split(env["PRODUCT"], "/")
+	if len(parts) < 2 {
+		return Device{}, false
+	}
+	d.Vendor, d.Product = pad(parts[0]), pad(parts[1])
+	return d, true
+}
+
+// kindOf sorts an interface by its class/subclass/protocol, in decimal.
+func kindOf(iface string) string {
+	f := strings.Split(iface, "/")
+	if len(f) != 3 {
+		return ""
+	}
+	class, _ := strconv.Atoi(f[0])
+	switch class {
+	case 3: // HID
+		return KindInput
+	case 8: // mass storage
+		return KindStorage
+	case 2, 10: // CDC communications and data: USB Ethernet, tethering
+		return KindNetwork
+	case 224: // wireless controller: RNDIS, Bluetooth
+		return KindNetwork
+	case 239: // miscellaneous
+		if f[1] == "4" { // RNDIS over IAD
+			return KindNetwork
+		}
+	}
+	return ""
+}
+
+// Block deauthorizes d's interface, which unbinds its driver.
+func Block(d Device) error {
+	if subsystem.Skip(subsystem.Guardian, "block USB %s %s (%s)", d.Kind, d.Name(), d.ID()) {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(SysDir, d.Path, "authorized"), []byte("0"), 0o644); err != nil {
+		return fmt.Errorf("failed to block %s: %w", d.Name(), err)
+	}
+	blocked := loadBlocked()
+	if !slices.Contains(blocked, d.Path) {
+		blocked = append(blocked, d.Path)
+	}
+	return storeBlocked(blocked)
+}
+
+// Release authorizes every interface Block blocked again, and asks the
+// kernel to bind drivers to them.  Those unplugged since are forgotten.
+func Release() error {
+	blocked := loadBlocked()
+	if len(blocked) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, path := range blocked {
+		err := os.WriteFile(filepath.Join(SysDir, path, "authorized"), []byte("1"), 0o644)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to release %s: %w", filepath.Base(path), err))
+			continue
+		}
+		os.WriteFile(filepath.Join(SysDir, "bus", "usb", "drivers_probe"), []byte(filepath.Base(path)), 0o200)
+		log.Printf("USB: Released %s", filepath.Base(path))
+	}
+	if err := os.Remove(BlockedFile); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Paths returns what blocking and releasing write to, for the sandbox to
+// leave writable: the tree under each root hub and drivers_probe.
+func Paths() []string {
+	hubs, _ := filepath.Glob(filepath.Join(SysDir, "bus", "usb", "devices", "usb*"))
+	var paths []string
+	for _, hub := range hubs {
+		if p, err := filepath.EvalSymlinks(hub); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return append(paths, filepath.Join(SysDir, "bus", "usb", "drivers_probe"))
+}
+
+func loadBlocked() []string {
+	var blocked []string
+	if data, err := os.ReadFile(BlockedFile); err == nil {
+		json.Unmarshal(data, &blocked)
+	}
+	return blocked
+}
+
+func storeBlocked(blocked []string) error {
+	data, err := json.Marshal(blocked)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(BlockedFile), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(BlockedFile, data, 0o600)
+}
+
+// pad writes a hex ID with the four digits lsusb shows.
+func pad(id string) string {
+	return fmt.Sprintf("%04s", strings.ToLower(id))
+}
+
+func isHex(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 16)
+	return err == nil && len(s) == 4
+}
@@ -0,0 +1,106 @@
+package usb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uevent builds a netlink uevent message from its fields.
+func uevent(fields ...string) []byte {
+	return []byte("add@/devices/x\x00" + strings.Join(fields, "\x00") + "\x00")
+}
+
+func TestParseUevent(t *testing.T) {
+	const path = "/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0"
+	tests := []struct {
+		iface string
+		kind  string
+	}{
+		{"8/6/80", KindStorage},
+		{"3/1/1", KindInput},
+		{"2/6/0", KindNetwork},
+		{"224/1/3", KindNetwork},
+		{"239/4/1", KindNetwork},
+		{"239/2/1", ""},
+		{"1/1/0", ""}, // audio
+	}
+	for _, tt := range tests {
+		d, ok := parseUevent(uevent("ACTION=add", "DEVPATH="+path, "SUBSYSTEM=usb",
+			"DEVTYPE=usb_interface", "PRODUCT=781/5581/100", "INTERFACE="+tt.iface))
+		if ok != (tt.kind != "") || d.Kind != tt.kind {
+			t.Errorf("%s: got %q, %v; want %q", tt.iface, d.Kind, ok, tt.kind)
+			continue
+		}
+		if ok && (d.ID() != "0781:5581" || d.Name() != "1-2:1.0") {
+			t.Errorf("%s: got %s %s", tt.iface, d.ID(), d.Name())
+		}
+	}
+	if _, ok := parseUevent(uevent("ACTION=add", "DEVPATH="+path, "SUBSYSTEM=usb",
+		"DEVTYPE=usb_device", "PRODUCT=781/5581/100")); ok {
+		t.Error("Expected a whole device, not an interface, to be ignored")
+	}
+	if _, ok := parseUevent(uevent("ACTION=remove", "DEVPATH="+path, "SUBSYSTEM=usb",
+		"DEVTYPE=usb_interface", "PRODUCT=781/5581/100", "INTERFACE=8/6/80")); ok {
+		t.Error("Expected a removal to be ignored")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	ConfigFile = filepath.Join(t.TempDir(), "usb.json")
+	if c, err := LoadConfig(); c != nil || err != nil {
+		t.Fatalf("Missing file: got %v, %v; want nil, nil", c, err)
+	}
+	os.WriteFile(ConfigFile, []byte(`{"storage": "eject"}`), 0o644)
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+	os.WriteFile(ConfigFile, []byte(`{"allow": ["logitech"]}`), 0o644)
+	if _, err := LoadConfig(); err == nil {
+		t.Error("Expected a malformed allow entry to be rejected")
+	}
+	os.WriteFile(ConfigFile, []byte(`{"input": "log", "allow": ["046d:c52b"]}`), 0o644)
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if c.Action(KindStorage) != ActionBlock || c.Action(KindNetwork) != ActionBlock || c.Action(KindInput) != ActionLog {
+		t.Errorf("Got actions %s/%s/%s", c.Action(KindStorage), c.Action(KindNetwork), c.Action(KindInput))
+	}
+	if !c.Allowed(Device{Vendor: "046d", Product: "c52b"}) || c.Allowed(Device{Vendor: "0781", Product: "5581"}) {
+		t.Error("Allow list not applied")
+	}
+}
+
+func TestBlockRelease(t *testing.T) {
+	SysDir = t.TempDir()
+	BlockedFile = filepath.Join(t.TempDir(), "usb-blocked.json")
+	d := Device{Path: "/devices/usb1/1-2/1-2:1.0", Kind: KindStorage, Vendor: "0781", Product: "5581"}
+	authorized := filepath.Join(SysDir, d.Path, "authorized")
+	os.MkdirAll(filepath.Dir(authorized), 0o755)
+	os.WriteFile(authorized, []byte("1\n"), 0o644)
+	os.MkdirAll(filepath.Join(SysDir, "bus", "usb"), 0o755)
+
+	if err := Block(d); err != nil {
+		t.Fatalf("Block: %v", err)
+	}
+	if data, _ := os.ReadFile(authorized); string(data) != "0" {
+		t.Errorf("authorized = %q after Block; want 0", data)
+	}
+	// One blocked interface that has since been unplugged.
+	storeBlocked(append(loadBlocked(), "/devices/usb1/1-3/1-3:1.0"))
+
+	if err := Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if data, _ := os.ReadFile(authorized); string(data) != "1" {
+		t.Errorf("authorized = %q after Release; want 1", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(SysDir, "bus", "usb", "drivers_probe")); string(data) != "1-2:1.0" {
+		t.Errorf("drivers_probe = %q; want 1-2:1.0", data)
+	}
+	if _, err := os.Stat(BlockedFile); !os.IsNotExist(err) {
+		t.Error("Expected the blocked list to be removed")
+	}
+}
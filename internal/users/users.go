@@ -0,0 +1,252 @@
+// Package users scopes enforcement to the subject's own accounts, so that
+// anyone else on the same machine, such as an admin or a partner, is left
+// alone.
+//
+// With no Targets everything applies machine-wide, as it always has.
+// With Targets, Init resolves them to UIDs and installs an nftables table
+// that marks every packet those UIDs send with Mark.  The throttler then
+// shapes only marked traffic and limits the users' own slices rather than
+// user.slice, the guardian blocks only marked traffic and kills only their
+// processes, and surveillance only counts and delays input while one of
+// them holds the active seat.
+package users
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// Mark is the firewall mark on the target users' packets.
+const Mark = 0x106
+
+// tableName is the nftables table that sets Mark.
+const tableName = "vex-users"
+
+var (
+	// Targets are the accounts enforcement applies to, as names or
+	// numeric UIDs.  Empty means everyone.  Set from config.json.
+	Targets []string
+
+	// ProcDir and SeatFile are read to find who owns a process and who
+	// is at the machine; tests point them elsewhere.
+	ProcDir  = "/proc"
+	SeatFile = "/run/systemd/seats/seat0"
+)
+
+var (
+	mu   sync.Mutex
+	uids []uint32
+
+	// The active seat is checked for every input event, so the answer
+	// is kept for seatTTL.
+	seatMu      sync.Mutex
+	seatChecked time.Time
+	seatOurs    bool
+)
+
+const seatTTL = time.Second
+
+// Resolve looks up Targets.  Accounts that cannot be found are skipped and
+// reported; if none can, enforcement stays machine-wide.
+func Resolve() error {
+	var resolved []uint32
+	var errs []error
+	for _, t := range Targets {
+		uid, err := lookup(t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !slices.Contains(resolved, uid) {
+			resolved = append(resolved, uid)
+		}
+	}
+
+	mu.Lock()
+	uids = resolved
+	mu.Unlock()
+	seatMu.Lock()
+	seatChecked = time.Time{}
+	seatMu.Unlock()
+
+	if len(resolved) > 0 {
+		log.Printf("Users: enforcement scoped to UIDs %v", resolved)
+	} else if len(Targets) > 0 {
+		log.Printf("Users: none of %v could be resolved; enforcement is machine-wide", Targets)
+	}
+	return errors.Join(errs...)
+}
+
+// lookup resolves a user name or numeric UID.
+func lookup(target string) (uint32, error) {
+	if n, err := strconv.ParseUint(target, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	u, err := user.Lookup(target)
+	if err != nil {
+		return 0, fmt.Errorf("target user %q: %w", target, err)
+	}
+	n, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("target user %q has UID %q: %w", target, u.Uid, err)
+	}
+	return uint32(n), nil
+}
+
+// Init resolves Targets and, if any remain, installs the table that marks
+// their packets.
+func Init() error {
+	err := Resolve()
+	if !Scoped() {
+		return err
+	}
+	if merr := installMarks(UIDs()); merr != nil {
+		return errors.Join(err, fmt.Errorf("failed to mark the target users' packets: %w", merr))
+	}
+	return err
+}
+
+// installMarks fills the vex-users table with rules that set Mark on
+// packets from sockets owned by uids.  The chain runs at mangle priority,
+// ahead of the guardian's filter chain and of routing.
+func installMarks(uids []uint32) error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+
+	table := conn.AddTable(&nftables.Table{Name: tableName, Family: nftables.TableFamilyINet})
+	chain := conn.AddChain(&nftables.Chain{
+		Name:     "mark-output",
+		Table:    table,
+		Type:     nftables.ChainTypeRoute,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityMangle,
+	})
+
+	// Flush existing rules so a restart does not duplicate them
+	conn.FlushChain(chain)
+
+	for _, uid := range uids {
+		conn.AddRule(&nftables.Rule{
+			Table: table,
+			Chain: chain,
+			Exprs: []expr.Any{
+				// meta skuid == uid
+				&expr.Meta{Key: expr.MetaKeySKUID, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(uid)},
+				// meta mark set Mark
+				&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(Mark)},
+				&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: 1},
+			},
+		})
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Users: NFTables '%s' marks packets from %d users with %#x", tableName, len(uids), Mark)
+	return nil
+}
+
+// Clear removes the marking table.
+func Clear() error {
+	conn, err := nftables.New()
+	if err != nil {
+		return fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+	conn.DelTable(&nftables.Table{Name: tableName, Family: nftables.TableFamilyINet})
+	if err := conn.Flush(); err != nil {
+		// Table might not exist — that's fine
+		log.Printf("Users: nftables cleanup (may be harmless): %v", err)
+	}
+	return nil
+}
+
+// UIDs returns the resolved target UIDs, or nil if enforcement is
+// machine-wide.
+func UIDs() []uint32 {
+	mu.Lock()
+	defer mu.Unlock()
+	return slices.Clone(uids)
+}
+
+// Scoped reports whether enforcement is limited to the target users.
+func Scoped() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(uids) > 0
+}
+
+// Includes reports whether enforcement applies to uid.
+func Includes(uid uint32) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(uids) == 0 || slices.Contains(uids, uid)
+}
+
+// OwnsPID reports whether enforcement applies to the process pid.  A
+// process whose owner cannot be read, usually because it has exited, is
+// not.
+func OwnsPID(pid int) bool {
+	if !Scoped() {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(ProcDir, strconv.Itoa(pid)))
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && Includes(st.Uid)
+}
+
+// OnSeat reports whether enforcement applies to whoever is at the
+// machine: a target user holds the active session on seat0.  If logind
+// does not say who that is, the answer is yes.
+func OnSeat() bool {
+	if !Scoped() {
+		return true
+	}
+	seatMu.Lock()
+	defer seatMu.Unlock()
+	if time.Since(seatChecked) < seatTTL {
+		return seatOurs
+	}
+	seatChecked = time.Now()
+
+	uid, ok := activeUID()
+	seatOurs = !ok || Includes(uid)
+	return seatOurs
+}
+
+// activeUID reads ACTIVE_UID from logind's record of seat0.
+func activeUID() (uint32, bool) {
+	f, err := os.Open(SeatFile)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "ACTIVE_UID="); ok {
+			n, err := strconv.ParseUint(v, 10, 32)
+			return uint32(n), err == nil
+		}
+	}
+	return 0, false
+}
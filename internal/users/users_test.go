@@ -0,0 +1,90 @@
+package users
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func scope(t *testing.T, targets ...string) {
+	t.Helper()
+	Targets = targets
+	if err := Resolve(); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	t.Cleanup(func() {
+		Targets = nil
+		Resolve()
+	})
+}
+
+func TestUnscoped(t *testing.T) {
+	scope(t)
+	if Scoped() || !Includes(0) || !OwnsPID(1) || !OnSeat() {
+		t.Error("Expected everyone to be included with no targets")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	scope(t, "1000", "root", "1000")
+	if got := UIDs(); len(got) != 2 || got[0] != 1000 || got[1] != 0 {
+		t.Errorf("Expected UIDs [1000 0], got %v", got)
+	}
+	if !Includes(1000) || Includes(1001) {
+		t.Error("Expected only the targets to be included")
+	}
+
+	Targets = []string{"no-such-user-vex", "1001"}
+	if err := Resolve(); err == nil {
+		t.Error("Expected an unknown user to be reported")
+	}
+	if got := UIDs(); len(got) != 1 || got[0] != 1001 {
+		t.Errorf("Expected the known user to be kept, got %v", got)
+	}
+}
+
+func TestOwnsPID(t *testing.T) {
+	ProcDir = t.TempDir()
+	t.Cleanup(func() { ProcDir = "/proc" })
+	os.Mkdir(filepath.Join(ProcDir, "42"), 0755)
+	me := strconv.Itoa(os.Getuid())
+
+	scope(t, me)
+	if !OwnsPID(42) {
+		t.Error("Expected a process owned by a target to be included")
+	}
+	if OwnsPID(43) {
+		t.Error("Expected a process that has gone to be excluded")
+	}
+
+	scope(t, strconv.Itoa(os.Getuid()+1))
+	if OwnsPID(42) {
+		t.Error("Expected a process owned by someone else to be excluded")
+	}
+}
+
+func TestOnSeat(t *testing.T) {
+	SeatFile = filepath.Join(t.TempDir(), "seat0")
+	t.Cleanup(func() { SeatFile = "/run/systemd/seats/seat0" })
+	seat := func(uid string) {
+		os.WriteFile(SeatFile, []byte("# This is private data. Do not parse.\nIS_SEAT0=1\nACTIVE_UID="+uid+"\n"), 0644)
+		seatChecked = time.Time{}
+	}
+
+	scope(t, "1000")
+	seat("1000")
+	if !OnSeat() {
+		t.Error("Expected a target at the seat to count")
+	}
+	seat("1001")
+	if OnSeat() {
+		t.Error("Expected someone else at the seat not to count")
+	}
+	os.Remove(SeatFile)
+	seatChecked = time.Time{}
+	if !OnSeat() {
+		t.Error("Expected an unknown seat to count")
+	}
+}